@@ -0,0 +1,150 @@
+// Package golden compares rendered images against reference PNGs in tests.
+//
+// Run the tests with -update to write the current output as the new
+// goldens:
+//
+//	go test ./... -update
+//
+// The package registers the -update flag unless the test binary already
+// has one, so tests importing it read the same flag rather than defining
+// their own. Setting GOLDEN_UPDATE=1 works too, for runs where passing a
+// flag to every package is awkward.
+package golden
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/GrandpaEJ/advancegg/internal/core"
+)
+
+// UpdateFlag names the boolean flag that makes AssertImageMatches rewrite
+// goldens instead of comparing
+const UpdateFlag = "update"
+
+// UpdateEnv names an environment variable that, when set to a true value
+// such as 1, has the same effect as UpdateFlag
+const UpdateEnv = "GOLDEN_UPDATE"
+
+func init() {
+	// Defining the flag twice panics, so an existing one is shared
+	if flag.Lookup(UpdateFlag) == nil {
+		flag.Bool(UpdateFlag, false, "rewrite golden images instead of comparing against them")
+	}
+}
+
+func updating() bool {
+	if f := flag.Lookup(UpdateFlag); f != nil {
+		if g, ok := f.Value.(flag.Getter); ok {
+			if update, ok := g.Get().(bool); ok && update {
+				return true
+			}
+		}
+	}
+	update, _ := strconv.ParseBool(os.Getenv(UpdateEnv))
+	return update
+}
+
+// Tolerance controls how different an image may be from its golden
+type Tolerance struct {
+	// PixelDeltaE is the CIEDE2000 difference below which a pixel counts as
+	// unchanged. 2.3 is roughly one just-noticeable difference.
+	PixelDeltaE float64
+	// MaxDiffRatio is the fraction of pixels allowed above PixelDeltaE
+	MaxDiffRatio float64
+	// MinSSIM additionally requires this structural similarity when positive
+	MinSSIM float64
+}
+
+// Exact requires every pixel to match
+var Exact = Tolerance{}
+
+// DefaultTolerance ignores antialiasing noise: pixels up to one
+// just-noticeable difference apart, and up to 0.1% of pixels beyond that
+var DefaultTolerance = Tolerance{PixelDeltaE: 2.3, MaxDiffRatio: 0.001}
+
+// AssertImageMatches fails the test if got differs from the PNG at
+// goldenPath by more than tolerance. On failure it writes the actual image
+// and a difference heatmap next to the golden as name.actual.png and
+// name.diff.png. With -update or GOLDEN_UPDATE set it writes got as the
+// golden instead.
+func AssertImageMatches(t testing.TB, got image.Image, goldenPath string, tolerance Tolerance) {
+	t.Helper()
+
+	if updating() {
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0o755); err != nil {
+			t.Fatalf("golden: %v", err)
+		}
+		if err := core.SavePNG(goldenPath, got); err != nil {
+			t.Fatalf("golden: writing %s: %v", goldenPath, err)
+		}
+		t.Logf("golden: updated %s", goldenPath)
+		return
+	}
+
+	want, err := core.LoadPNG(goldenPath)
+	if err != nil {
+		t.Fatalf("golden: reading %s: %v (run with -%s to create it)", goldenPath, err, UpdateFlag)
+	}
+
+	base := strings.TrimSuffix(goldenPath, filepath.Ext(goldenPath))
+	actualPath, diffPath := base+".actual.png", base+".diff.png"
+
+	if problem := compare(want, got, tolerance); problem != "" {
+		var written []string
+		if err := core.SavePNG(actualPath, got); err == nil {
+			written = append(written, actualPath)
+		}
+		if dm, err := core.DeltaE(want, got); err == nil {
+			if err := core.SavePNG(diffPath, dm.Heatmap(0)); err == nil {
+				written = append(written, diffPath)
+			}
+		}
+		if len(written) > 0 {
+			problem += "; wrote " + strings.Join(written, ", ")
+		}
+		t.Errorf("golden: %s does not match: %s", goldenPath, problem)
+		return
+	}
+
+	// Leftovers from an earlier failure are stale now
+	os.Remove(actualPath)
+	os.Remove(diffPath)
+}
+
+// compare returns a description of how want and got differ beyond
+// tolerance, or "" if they match
+func compare(want, got image.Image, tolerance Tolerance) string {
+	ws, gs := want.Bounds().Size(), got.Bounds().Size()
+	if ws != gs {
+		return fmt.Sprintf("size is %dx%d, want %dx%d", gs.X, gs.Y, ws.X, ws.Y)
+	}
+
+	dm, err := core.DeltaE(want, got)
+	if err != nil {
+		return err.Error()
+	}
+	total := ws.X * ws.Y
+	diff := dm.Exceeding(tolerance.PixelDeltaE)
+	if total > 0 && float64(diff)/float64(total) > tolerance.MaxDiffRatio {
+		return fmt.Sprintf("%d of %d pixels differ by more than ΔE %.2f (max ΔE %.2f, mean %.3f)",
+			diff, total, tolerance.PixelDeltaE, dm.Max, dm.Mean)
+	}
+
+	if tolerance.MinSSIM > 0 {
+		ssim, err := core.SSIM(want, got)
+		if err != nil {
+			return err.Error()
+		}
+		if ssim < tolerance.MinSSIM {
+			return fmt.Sprintf("SSIM %.4f is below %.4f", ssim, tolerance.MinSSIM)
+		}
+	}
+	return ""
+}
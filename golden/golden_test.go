@@ -0,0 +1,102 @@
+package golden
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/GrandpaEJ/advancegg/internal/core"
+)
+
+// recorder captures failures instead of failing the enclosing test
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Helper()                           {}
+func (r *recorder) Logf(format string, args ...any)   {}
+func (r *recorder) Errorf(format string, args ...any) { r.failed = true }
+func (r *recorder) Fatalf(format string, args ...any) { r.failed = true; runtime.Goexit() }
+
+// fails reports whether AssertImageMatches fails, running it on its own
+// goroutine so Fatalf can stop it like the testing package does
+func fails(t *testing.T, got image.Image, path string, tolerance Tolerance) bool {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		AssertImageMatches(r, got, path, tolerance)
+	}()
+	<-done
+	return r.failed
+}
+
+func solid(c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestAssertImageMatches(t *testing.T) {
+	t.Setenv(UpdateEnv, "")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "card.png")
+	want := solid(color.RGBA{200, 100, 50, 255})
+	if err := core.SavePNG(path, want); err != nil {
+		t.Fatal(err)
+	}
+
+	near := solid(color.RGBA{201, 100, 50, 255})
+	if fails(t, near, path, DefaultTolerance) {
+		t.Error("an off-by-one color should match with the default tolerance")
+	}
+
+	if !fails(t, near, path, Exact) {
+		t.Error("an off-by-one color should not match exactly")
+	}
+
+	if !fails(t, solid(color.RGBA{20, 100, 200, 255}), path, DefaultTolerance) {
+		t.Error("a different color should not match")
+	}
+	for _, name := range []string{"card.actual.png", "card.diff.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("failure output %s not written: %v", name, err)
+		}
+	}
+
+	if !fails(t, want, filepath.Join(dir, "missing.png"), DefaultTolerance) {
+		t.Error("a missing golden should fail without -update")
+	}
+}
+
+func TestAssertImageMatchesUpdate(t *testing.T) {
+	t.Setenv(UpdateEnv, "")
+	flag.Set(UpdateFlag, "true")
+	t.Cleanup(func() { flag.Set(UpdateFlag, "false") })
+	path := filepath.Join(t.TempDir(), "new", "card.png")
+	want := solid(color.RGBA{10, 20, 30, 255})
+	if fails(t, want, path, Exact) {
+		t.Fatal("updating a missing golden with -update should not fail")
+	}
+	flag.Set(UpdateFlag, "false")
+	if fails(t, want, path, Exact) {
+		t.Error("the written golden should match")
+	}
+
+	// The environment variable is an extra switch
+	t.Setenv(UpdateEnv, "1")
+	other := filepath.Join(t.TempDir(), "card.png")
+	if fails(t, want, other, Exact) {
+		t.Fatal("updating a missing golden with GOLDEN_UPDATE should not fail")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("GOLDEN_UPDATE did not write the golden: %v", err)
+	}
+}
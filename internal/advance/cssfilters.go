@@ -4,6 +4,8 @@ import (
 	"image"
 	"image/color"
 	"math"
+
+	"github.com/GrandpaEJ/advancegg/internal/core"
 )
 
 // CSSFilter represents a CSS-like filter that can be applied to images
@@ -205,48 +207,12 @@ func (f OpacityFilter) Apply(img image.Image) image.Image {
 
 // Blur filter - CSS blur()
 type BlurFilter struct {
-	Radius float64 // Blur radius in pixels
+	Radius float64       // Standard deviation in pixels, as in CSS blur()
+	Edge   core.EdgeMode // Browsers use core.EdgeTransparent; the zero value clamps
 }
 
 func (f BlurFilter) Apply(img image.Image) image.Image {
-	bounds := img.Bounds()
-	result := image.NewRGBA(bounds)
-
-	r := int(f.Radius)
-	if r < 1 {
-		r = 1
-	}
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			var rSum, gSum, bSum, aSum, count uint32
-
-			for dy := -r; dy <= r; dy++ {
-				for dx := -r; dx <= r; dx++ {
-					px, py := x+dx, y+dy
-					if px >= bounds.Min.X && px < bounds.Max.X && py >= bounds.Min.Y && py < bounds.Max.Y {
-						pr, pg, pb, pa := img.At(px, py).RGBA()
-						rSum += pr >> 8
-						gSum += pg >> 8
-						bSum += pb >> 8
-						aSum += pa >> 8
-						count++
-					}
-				}
-			}
-
-			if count > 0 {
-				result.Set(x, y, color.RGBA{
-					uint8(rSum / count),
-					uint8(gSum / count),
-					uint8(bSum / count),
-					uint8(aSum / count),
-				})
-			}
-		}
-	}
-
-	return result
+	return core.GaussianBlurImage(img, f.Radius, f.Edge)
 }
 
 // Sepia filter - CSS sepia()
@@ -0,0 +1,299 @@
+package core
+
+import (
+	"image"
+	"math"
+)
+
+// Separable Gaussian blur engine shared by every blur in the library
+
+// EdgeMode selects how pixels outside the image are sampled
+type EdgeMode int
+
+const (
+	// EdgeClamp repeats the nearest edge pixel
+	EdgeClamp EdgeMode = iota
+	// EdgeWrap tiles the image, which suits seamless textures
+	EdgeWrap
+	// EdgeTransparent treats everything outside the image as transparent black
+	EdgeTransparent
+)
+
+// BlurMethod selects the Gaussian implementation
+type BlurMethod int
+
+const (
+	// BlurAuto uses an exact kernel for small sigmas and extended boxes otherwise
+	BlurAuto BlurMethod = iota
+	// BlurExact convolves with a sampled Gaussian kernel
+	BlurExact
+	// BlurExtendedBox approximates the Gaussian with three extended box passes
+	// (Gwosdek et al.), costing O(1) per pixel regardless of sigma
+	BlurExtendedBox
+)
+
+// exactBlurMaxSigma is the largest sigma BlurAuto handles with an exact kernel
+const exactBlurMaxSigma = 6.0
+
+// BlurOptions configures GaussianBlurWithOptions
+type BlurOptions struct {
+	Sigma      float64
+	Edge       EdgeMode
+	Method     BlurMethod
+	NumWorkers int
+	// Linear blurs in linear light, which keeps bright detail from being
+	// swallowed by dark surroundings
+	Linear bool
+}
+
+// RadiusToSigma converts a blur radius to a Gaussian standard deviation.
+// Like the canvas shadowBlur attribute, a radius covers two sigmas.
+func RadiusToSigma(radius float64) float64 {
+	return radius / 2
+}
+
+// GaussianBlur returns a Filter that blurs with the given standard deviation
+// and clamped edges. Applied through a linear-light context it blurs in
+// linear light.
+func GaussianBlur(sigma float64) Filter {
+	return func(img image.Image) image.Image {
+		opts := BlurOptions{Sigma: sigma, Edge: EdgeClamp, Linear: linearCanvas(img)}
+		if deep, ok := asDeepCanvas(img); ok {
+			return blurDeep(deep, opts)
+		}
+		return GaussianBlurWithOptions(img, opts)
+	}
+}
+
+// GaussianBlurImage blurs any image with the given sigma and edge mode
+func GaussianBlurImage(img image.Image, sigma float64, edge EdgeMode) *image.RGBA {
+	return GaussianBlurWithOptions(img, BlurOptions{Sigma: sigma, Edge: edge})
+}
+
+// GaussianBlurWithOptions blurs an image in premultiplied alpha, so
+// transparent pixels never bleed their color into the result
+func GaussianBlurWithOptions(img image.Image, opts BlurOptions) *image.RGBA {
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	result := image.NewRGBA(bounds)
+	if w == 0 || h == 0 {
+		return result
+	}
+
+	if opts.Sigma <= 0 {
+		for y := 0; y < h; y++ {
+			i := (y+bounds.Min.Y-src.Rect.Min.Y)*src.Stride + (bounds.Min.X-src.Rect.Min.X)*4
+			copy(result.Pix[y*result.Stride:y*result.Stride+w*4], src.Pix[i:i+w*4])
+		}
+		return result
+	}
+
+	// image.RGBA is already premultiplied, so we blur its samples directly,
+	// or their linear-light values scaled to the same 0-255 range
+	buf := make([]float32, w*h*4)
+	for y := 0; y < h; y++ {
+		i := (y+bounds.Min.Y-src.Rect.Min.Y)*src.Stride + (bounds.Min.X-src.Rect.Min.X)*4
+		if opts.Linear {
+			for x := 0; x < w; x++ {
+				p := src.Pix[i+x*4 : i+x*4+4 : i+x*4+4]
+				r, g, b, a := decodePremultiplied(p[0], p[1], p[2], p[3])
+				o := (y*w + x) * 4
+				buf[o], buf[o+1], buf[o+2], buf[o+3] = float32(r)/257, float32(g)/257, float32(b)/257, float32(a)/257
+			}
+			continue
+		}
+		for j := 0; j < w*4; j++ {
+			buf[y*w*4+j] = float32(src.Pix[i+j])
+		}
+	}
+
+	blurPlanar(buf, w, h, 4, opts)
+
+	for y := 0; y < h; y++ {
+		row := result.Pix[y*result.Stride:]
+		for x := 0; x < w; x++ {
+			o := (y*w + x) * 4
+			if opts.Linear {
+				c := encodeLinear255(float64(buf[o]), float64(buf[o+1]), float64(buf[o+2]), float64(buf[o+3]))
+				row[x*4+0], row[x*4+1], row[x*4+2], row[x*4+3] = c.R, c.G, c.B, c.A
+				continue
+			}
+			a := clampFloat32(buf[o+3], 0, 255)
+			row[x*4+0] = uint8(clampFloat32(buf[o+0], 0, a) + 0.5)
+			row[x*4+1] = uint8(clampFloat32(buf[o+1], 0, a) + 0.5)
+			row[x*4+2] = uint8(clampFloat32(buf[o+2], 0, a) + 0.5)
+			row[x*4+3] = uint8(a + 0.5)
+		}
+	}
+	return result
+}
+
+// blurPlanar blurs an interleaved float buffer with the given number of
+// channels in place, first along rows then along columns
+func blurPlanar(buf []float32, w, h, channels int, opts BlurOptions) {
+	method := opts.Method
+	if method == BlurAuto {
+		if opts.Sigma <= exactBlurMaxSigma {
+			method = BlurExact
+		} else {
+			method = BlurExtendedBox
+		}
+	}
+
+	var kernel []float32
+	var boxes []extendedBox
+	if method == BlurExact {
+		kernel = gaussianKernel(opts.Sigma)
+	} else {
+		boxes = extendedBoxes(opts.Sigma, 3)
+	}
+
+	pass := func(n, length, stride, step int) {
+		parallelRows(n, opts.NumWorkers, func(start, end int) {
+			line := make([]float32, length)
+			tmp := make([]float32, length)
+			for i := start; i < end; i++ {
+				for c := 0; c < channels; c++ {
+					base := i*stride + c
+					for k := 0; k < length; k++ {
+						line[k] = buf[base+k*step]
+					}
+					if kernel != nil {
+						convolveLine(line, tmp, kernel, opts.Edge)
+					} else {
+						for _, box := range boxes {
+							box.apply(line, tmp, opts.Edge)
+							line, tmp = tmp, line
+						}
+						line, tmp = tmp, line
+					}
+					for k := 0; k < length; k++ {
+						buf[base+k*step] = tmp[k]
+					}
+				}
+			}
+		})
+	}
+
+	// Rows: h lines of length w, consecutive pixels are `channels` apart
+	pass(h, w, w*channels, channels)
+	// Columns: w lines of length h, consecutive pixels are a row apart
+	pass(w, h, channels, w*channels)
+}
+
+// gaussianKernel returns a normalized sampled Gaussian covering 3 sigmas
+func gaussianKernel(sigma float64) []float32 {
+	radius := int(math.Ceil(sigma * 3))
+	if radius < 1 {
+		radius = 1
+	}
+	kernel := make([]float32, 2*radius+1)
+	var sum float64
+	weights := make([]float64, len(kernel))
+	for i := -radius; i <= radius; i++ {
+		v := math.Exp(-float64(i*i) / (2 * sigma * sigma))
+		weights[i+radius] = v
+		sum += v
+	}
+	for i, v := range weights {
+		kernel[i] = float32(v / sum)
+	}
+	return kernel
+}
+
+// edgeSample reads line[i], resolving out-of-range indices with the edge mode
+func edgeSample(line []float32, i int, edge EdgeMode) float32 {
+	n := len(line)
+	if i >= 0 && i < n {
+		return line[i]
+	}
+	switch edge {
+	case EdgeWrap:
+		i %= n
+		if i < 0 {
+			i += n
+		}
+		return line[i]
+	case EdgeTransparent:
+		return 0
+	default:
+		if i < 0 {
+			return line[0]
+		}
+		return line[n-1]
+	}
+}
+
+// convolveLine convolves src with a symmetric kernel into dst
+func convolveLine(src, dst, kernel []float32, edge EdgeMode) {
+	radius := len(kernel) / 2
+	n := len(src)
+	for i := 0; i < n; i++ {
+		var sum float32
+		if i >= radius && i < n-radius {
+			for k, w := range kernel {
+				sum += src[i+k-radius] * w
+			}
+		} else {
+			for k, w := range kernel {
+				sum += edgeSample(src, i+k-radius, edge) * w
+			}
+		}
+		dst[i] = sum
+	}
+}
+
+// extendedBox is one pass of an extended box filter: a box of radius r plus
+// fractional weight on the two samples just outside it
+type extendedBox struct {
+	r      int
+	c1, c2 float32
+}
+
+// extendedBoxes computes d identical extended boxes whose repeated
+// application has exactly the variance of a Gaussian with the given sigma
+func extendedBoxes(sigma float64, d int) []extendedBox {
+	variance := sigma * sigma / float64(d)
+	r := int(math.Floor(0.5*math.Sqrt(12*variance+1) - 0.5))
+	fr := float64(r)
+	alpha := (2*fr + 1) * (fr*(fr+1) - 3*variance) / (6 * (variance - (fr+1)*(fr+1)))
+	norm := 2*alpha + 2*fr + 1
+	box := extendedBox{
+		r:  r,
+		c1: float32(alpha / norm),
+		c2: float32(1 / norm),
+	}
+	boxes := make([]extendedBox, d)
+	for i := range boxes {
+		boxes[i] = box
+	}
+	return boxes
+}
+
+// apply runs the extended box over src into dst using a running sum
+func (b extendedBox) apply(src, dst []float32, edge EdgeMode) {
+	n := len(src)
+	r := b.r
+
+	var sum float32
+	for k := -r; k <= r; k++ {
+		sum += edgeSample(src, k, edge)
+	}
+	for i := 0; i < n; i++ {
+		outer := edgeSample(src, i-r-1, edge) + edgeSample(src, i+r+1, edge)
+		dst[i] = b.c2*sum + b.c1*outer
+		sum += edgeSample(src, i+r+1, edge) - edgeSample(src, i-r, edge)
+	}
+}
+
+// clampFloat32 clamps a float32 value between min and max
+func clampFloat32(value, min, max float32) float32 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
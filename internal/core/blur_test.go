@@ -0,0 +1,81 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// impulseVariance blurs a single bright pixel and measures the horizontal
+// variance of the response
+func impulseVariance(t *testing.T, sigma float64, method BlurMethod) float64 {
+	t.Helper()
+	const size = 201
+	img := image.NewRGBA(image.Rect(0, 0, size, 1))
+	img.SetRGBA(size/2, 0, color.RGBA{255, 255, 255, 255})
+
+	buf := make([]float32, size)
+	buf[size/2] = 1
+	blurPlanar(buf, size, 1, 1, BlurOptions{Sigma: sigma, Method: method, Edge: EdgeClamp})
+
+	var sum, variance float64
+	for x, v := range buf {
+		d := float64(x - size/2)
+		sum += float64(v)
+		variance += float64(v) * d * d
+	}
+	if math.Abs(sum-1) > 1e-3 {
+		t.Fatalf("impulse response sums to %f, want 1", sum)
+	}
+	return variance
+}
+
+func TestGaussianBlur_SigmaAccuracy(t *testing.T) {
+	for _, method := range []BlurMethod{BlurExact, BlurExtendedBox} {
+		for _, sigma := range []float64{2, 5, 12} {
+			got := math.Sqrt(impulseVariance(t, sigma, method))
+			if math.Abs(got-sigma)/sigma > 0.03 {
+				t.Errorf("method %d sigma %.1f: measured %.3f", method, sigma, got)
+			}
+		}
+	}
+}
+
+func TestGaussianBlur_PremultipliedEdges(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 10; x++ {
+			img.SetRGBA(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+
+	out := GaussianBlurImage(img, 2, EdgeClamp)
+	c := color.NRGBAModel.Convert(out.At(11, 10)).(color.NRGBA)
+	if c.A == 0 || c.A == 255 {
+		t.Fatalf("expected partial alpha near edge, got %d", c.A)
+	}
+	if c.R < 250 || c.G != 0 || c.B != 0 {
+		t.Errorf("transparent pixels bled into color: %v", c)
+	}
+}
+
+func TestGaussianBlur_EdgeModes(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+
+	clamp := GaussianBlurImage(img, 3, EdgeClamp)
+	if a := clamp.RGBAAt(0, 0).A; a != 255 {
+		t.Errorf("clamp mode corner alpha = %d, want 255", a)
+	}
+	wrap := GaussianBlurImage(img, 3, EdgeWrap)
+	if a := wrap.RGBAAt(0, 0).A; a != 255 {
+		t.Errorf("wrap mode corner alpha = %d, want 255", a)
+	}
+	transparent := GaussianBlurImage(img, 3, EdgeTransparent)
+	if a := transparent.RGBAAt(0, 0).A; a > 100 {
+		t.Errorf("transparent mode corner alpha = %d, want about 82", a)
+	}
+}
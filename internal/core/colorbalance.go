@@ -0,0 +1,400 @@
+package core
+
+import (
+	"image"
+	"math"
+)
+
+// White balance, color balance, vibrance and selective HSL adjustments
+
+// WhiteBalanceMethod selects how AutoWhiteBalance estimates the illuminant
+type WhiteBalanceMethod int
+
+const (
+	// WhiteBalanceGrayWorld assumes the scene averages to neutral gray
+	WhiteBalanceGrayWorld WhiteBalanceMethod = iota
+	// WhiteBalanceWhitePatch assumes the brightest pixels are white
+	WhiteBalanceWhitePatch
+)
+
+// HueRange names one of the eight hue bands used by SelectiveHSL
+type HueRange int
+
+const (
+	HueReds HueRange = iota
+	HueOranges
+	HueYellows
+	HueGreens
+	HueAquas
+	HueBlues
+	HuePurples
+	HueMagentas
+)
+
+// hueRangeCenters holds the center hue in degrees of each HueRange
+var hueRangeCenters = [8]float64{0, 30, 60, 120, 180, 240, 270, 300}
+
+// HSLAdjustment shifts colors within one hue range. Hue is in degrees;
+// Saturation and Lightness are in -1..1.
+type HSLAdjustment struct {
+	Hue        float64
+	Saturation float64
+	Lightness  float64
+}
+
+// HSLAdjustments holds one adjustment per HueRange
+type HSLAdjustments [8]HSLAdjustment
+
+// srgbToXYZMatrix and xyzToSRGBMatrix convert linear sRGB to and from XYZ (D65)
+var (
+	srgbToXYZMatrix = [3][3]float64{
+		{0.4124564, 0.3575761, 0.1804375},
+		{0.2126729, 0.7151522, 0.0721750},
+		{0.0193339, 0.1191920, 0.9503041},
+	}
+	xyzToSRGBMatrix = [3][3]float64{
+		{3.2404542, -1.5371385, -0.4985314},
+		{-0.9692660, 1.8760108, 0.0415560},
+		{0.0556434, -0.2040259, 1.0572252},
+	}
+)
+
+// WhitePointForTemperature returns the XYZ white point (Y = 1) of a light
+// source with the given correlated color temperature in kelvin. Tint in
+// -100..100 moves the point off the locus; positive values are greener.
+func WhitePointForTemperature(kelvin, tint float64) XYZColor {
+	t := clamp(kelvin, 1667, 25000)
+
+	var x, y float64
+	switch {
+	case t >= 7000:
+		x = -2.0064e9/(t*t*t) + 1.9018e6/(t*t) + 0.24748e3/t + 0.237040
+		y = -3*x*x + 2.870*x - 0.275
+	case t >= 4000:
+		x = -4.6070e9/(t*t*t) + 2.9678e6/(t*t) + 0.09911e3/t + 0.244063
+		y = -3*x*x + 2.870*x - 0.275
+	default:
+		// Planckian locus (Kim et al.) below the range of the daylight model
+		x = -0.2661239e9/(t*t*t) - 0.2343589e6/(t*t) + 0.8776956e3/t + 0.179910
+		if t >= 2222 {
+			y = -0.9549476*x*x*x - 1.37418593*x*x + 2.09137015*x - 0.16748867
+		} else {
+			y = -1.1063814*x*x*x - 1.34811020*x*x + 2.18555832*x - 0.20219683
+		}
+	}
+
+	if tint != 0 {
+		// Shift along v in the CIE 1960 UCS, roughly perpendicular to the locus
+		d := -2*x + 12*y + 3
+		u, v := 4*x/d, 6*y/d
+		v += tint * 0.0002
+		d = 2*u - 8*v + 4
+		x, y = 3*u/d, 2*v/d
+	}
+
+	return XYZColor{X: x / y, Y: 1, Z: (1 - x - y) / y}
+}
+
+// WhiteBalance returns a Filter that corrects an image lit by a source of the
+// given color temperature and tint to neutral D65 using Bradford chromatic
+// adaptation. WhiteBalance(6504, 0) leaves an sRGB image unchanged; lower
+// temperatures cool the image and positive tints add magenta.
+func WhiteBalance(kelvin, tint float64) Filter {
+	return func(img image.Image) image.Image {
+		return applyWhiteBalance(asRGBA(img), kelvin, tint)
+	}
+}
+
+// AutoWhiteBalance returns a Filter that estimates the scene illuminant and
+// adapts it to neutral
+func AutoWhiteBalance(method WhiteBalanceMethod) Filter {
+	return func(img image.Image) image.Image {
+		return applyAutoWhiteBalance(asRGBA(img), method)
+	}
+}
+
+func applyWhiteBalance(img *image.RGBA, kelvin, tint float64) *image.RGBA {
+	return applyAdaptationMatrix(img, adaptationMatrix(WhitePointForTemperature(kelvin, tint)))
+}
+
+func applyAutoWhiteBalance(img *image.RGBA, method WhiteBalanceMethod) *image.RGBA {
+	m, ok := autoWhiteBalanceMatrix(img, method)
+	if !ok {
+		return cloneImage(img)
+	}
+	return applyAdaptationMatrix(img, m)
+}
+
+// autoWhiteBalanceMatrix estimates the illuminant of img and returns the
+// matrix adapting it to neutral, or false when no estimate is possible
+func autoWhiteBalanceMatrix(img *image.RGBA, method WhiteBalanceMethod) ([3][3]float64, bool) {
+	var illuminant [3]float64
+	if method == WhiteBalanceWhitePatch {
+		illuminant = estimateWhitePatch(img)
+	} else {
+		illuminant = estimateGrayWorld(img)
+	}
+	if illuminant[0] <= 0 || illuminant[1] <= 0 || illuminant[2] <= 0 {
+		return [3][3]float64{}, false
+	}
+
+	xyz := mulMatrix3(srgbToXYZMatrix, illuminant)
+	if xyz[1] <= 0 {
+		return [3][3]float64{}, false
+	}
+	wp := XYZColor{X: xyz[0] / xyz[1], Y: 1, Z: xyz[2] / xyz[1]}
+	return adaptationMatrix(wp), true
+}
+
+// adaptationMatrix builds the linear sRGB matrix that adapts colors seen
+// under sourceWP to D65
+func adaptationMatrix(sourceWP XYZColor) [3][3]float64 {
+	cc := &ColorConverter{}
+	var m [3][3]float64
+	for j := 0; j < 3; j++ {
+		var primary [3]float64
+		primary[j] = 1
+		xyz := mulMatrix3(srgbToXYZMatrix, primary)
+		adapted := cc.chromaticAdaptation(XYZColor{X: xyz[0], Y: xyz[1], Z: xyz[2]}, sourceWP, IlluminantD65)
+		column := mulMatrix3(xyzToSRGBMatrix, [3]float64{adapted.X, adapted.Y, adapted.Z})
+		for i := 0; i < 3; i++ {
+			m[i][j] = column[i]
+		}
+	}
+	return m
+}
+
+// applyAdaptationMatrix multiplies every pixel by m in linear light
+func applyAdaptationMatrix(img *image.RGBA, m [3][3]float64) *image.RGBA {
+	return mapStraightRGB(img, adaptationFunc(m))
+}
+
+func adaptationFunc(m [3][3]float64) rgbFunc {
+	return func(r, g, b float64) (float64, float64, float64) {
+		out := mulMatrix3(m, [3]float64{srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)})
+		return linearToSRGB(clamp(out[0], 0, 1)), linearToSRGB(clamp(out[1], 0, 1)), linearToSRGB(clamp(out[2], 0, 1))
+	}
+}
+
+// estimateGrayWorld returns the alpha-weighted mean linear color
+func estimateGrayWorld(img *image.RGBA) [3]float64 {
+	var decode [256]float64
+	for i := range decode {
+		decode[i] = srgbToLinear(float64(i) / 255)
+	}
+
+	var sum [3]float64
+	var weight float64
+	forEachStraightPixel(img, func(r, g, b, a uint8) {
+		w := float64(a)
+		sum[0] += decode[r] * w
+		sum[1] += decode[g] * w
+		sum[2] += decode[b] * w
+		weight += w
+	})
+	if weight == 0 {
+		return [3]float64{}
+	}
+	return [3]float64{sum[0] / weight, sum[1] / weight, sum[2] / weight}
+}
+
+// estimateWhitePatch returns the mean linear color of the brightest 1% of
+// opaque pixels, which is more robust than the single brightest pixel
+func estimateWhitePatch(img *image.RGBA) [3]float64 {
+	var hist [256]int
+	total := 0
+	forEachStraightPixel(img, func(r, g, b, a uint8) {
+		if a < 128 {
+			return
+		}
+		hist[luma8(r, g, b)]++
+		total++
+	})
+	if total == 0 {
+		return [3]float64{}
+	}
+
+	threshold, count := 255, 0
+	for ; threshold > 0; threshold-- {
+		count += hist[threshold]
+		if count*100 >= total {
+			break
+		}
+	}
+
+	var sum [3]float64
+	n := 0
+	forEachStraightPixel(img, func(r, g, b, a uint8) {
+		if a < 128 || int(luma8(r, g, b)) < threshold {
+			return
+		}
+		sum[0] += srgbToLinear(float64(r) / 255)
+		sum[1] += srgbToLinear(float64(g) / 255)
+		sum[2] += srgbToLinear(float64(b) / 255)
+		n++
+	})
+	return [3]float64{sum[0] / float64(n), sum[1] / float64(n), sum[2] / float64(n)}
+}
+
+// forEachStraightPixel calls fn with the unpremultiplied color of every
+// non-transparent pixel
+func forEachStraightPixel(img *image.RGBA, fn func(r, g, b, a uint8)) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := img.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x, i = x+1, i+4 {
+			a := uint32(img.Pix[i+3])
+			if a == 0 {
+				continue
+			}
+			if a == 255 {
+				fn(img.Pix[i], img.Pix[i+1], img.Pix[i+2], 255)
+				continue
+			}
+			fn(unpremultiply8(img.Pix[i], a), unpremultiply8(img.Pix[i+1], a), unpremultiply8(img.Pix[i+2], a), uint8(a))
+		}
+	}
+}
+
+// unpremultiply8 converts a premultiplied 8-bit sample back to straight color
+func unpremultiply8(c uint8, a uint32) uint8 {
+	v := (uint32(c)*255 + a/2) / a
+	if v > 255 {
+		v = 255
+	}
+	return uint8(v)
+}
+
+// luma8 returns the Rec. 601 luma of an 8-bit color
+func luma8(r, g, b uint8) uint8 {
+	return uint8((299*uint32(r) + 587*uint32(g) + 114*uint32(b) + 500) / 1000)
+}
+
+// ColorBalance returns a Filter that shifts the colors of shadows, midtones
+// and highlights separately. Each triple holds the cyan-red, magenta-green
+// and yellow-blue shifts in -1..1. Luminosity is preserved.
+func ColorBalance(shadows, midtones, highlights [3]float64) Filter {
+	return func(img image.Image) image.Image {
+		return applyColorBalance(asRGBA(img), shadows, midtones, highlights)
+	}
+}
+
+func applyColorBalance(img *image.RGBA, shadows, midtones, highlights [3]float64) *image.RGBA {
+	return mapStraightRGB(img, colorBalanceFunc(shadows, midtones, highlights))
+}
+
+func colorBalanceFunc(shadows, midtones, highlights [3]float64) rgbFunc {
+	return func(r, g, b float64) (float64, float64, float64) {
+		l := 0.299*r + 0.587*g + 0.114*b
+		ws := 1 - smoothstep(0, 0.5, l)
+		wh := smoothstep(0.5, 1, l)
+		wm := 1 - ws - wh
+
+		c := [3]float64{r, g, b}
+		for i := range c {
+			c[i] += 0.5 * (ws*shadows[i] + wm*midtones[i] + wh*highlights[i])
+			c[i] = clamp(c[i], 0, 1)
+		}
+
+		// Restore the original luma so the shift changes only the hue
+		d := l - (0.299*c[0] + 0.587*c[1] + 0.114*c[2])
+		return c[0] + d, c[1] + d, c[2] + d
+	}
+}
+
+// smoothstep returns a smooth 0-1 transition of x between edge0 and edge1
+func smoothstep(edge0, edge1, x float64) float64 {
+	t := clamp((x-edge0)/(edge1-edge0), 0, 1)
+	return t * t * (3 - 2*t)
+}
+
+// Vibrance returns a Filter that raises the saturation of muted colors more
+// than already saturated ones. Amount is in -1..1; negative values mute.
+func Vibrance(amount float64) Filter {
+	return func(img image.Image) image.Image {
+		return applyVibrance(asRGBA(img), amount)
+	}
+}
+
+func applyVibrance(img *image.RGBA, amount float64) *image.RGBA {
+	return mapStraightRGB(img, vibranceFunc(amount))
+}
+
+func vibranceFunc(amount float64) rgbFunc {
+	return func(r, g, b float64) (float64, float64, float64) {
+		max := math.Max(r, math.Max(g, b))
+		min := math.Min(r, math.Min(g, b))
+		sat := max - min
+		scale := 1 + amount*(1-sat)
+		l := 0.299*r + 0.587*g + 0.114*b
+		return l + (r-l)*scale, l + (g-l)*scale, l + (b-l)*scale
+	}
+}
+
+// SelectiveHSL returns a Filter that shifts the hue, saturation and lightness
+// of colors by hue range, blending smoothly between neighboring ranges
+func SelectiveHSL(adjustments HSLAdjustments) Filter {
+	return func(img image.Image) image.Image {
+		return applySelectiveHSL(asRGBA(img), adjustments)
+	}
+}
+
+func applySelectiveHSL(img *image.RGBA, adjustments HSLAdjustments) *image.RGBA {
+	return mapStraightRGB(img, selectiveHSLFunc(adjustments))
+}
+
+func selectiveHSLFunc(adjustments HSLAdjustments) rgbFunc {
+	return func(r, g, b float64) (float64, float64, float64) {
+		hsl := Color{R: r, G: g, B: b, A: 1}.ToHSL()
+		if hsl.S == 0 {
+			return r, g, b
+		}
+
+		var dh, ds, dl float64
+		for i, w := range hueRangeWeights(hsl.H) {
+			if w == 0 {
+				continue
+			}
+			dh += w * adjustments[i].Hue
+			ds += w * adjustments[i].Saturation
+			dl += w * adjustments[i].Lightness
+		}
+
+		hsl.H = math.Mod(hsl.H+dh+360, 360)
+		hsl.S = clamp(hsl.S*(1+ds), 0, 1)
+		// Scale lightness shifts by saturation so near-grays barely move
+		dl *= hsl.S
+		if dl > 0 {
+			hsl.L += (1 - hsl.L) * dl
+		} else {
+			hsl.L += hsl.L * dl
+		}
+		c := hsl.ToRGB()
+		return c.R, c.G, c.B
+	}
+}
+
+// hueRangeWeights returns the membership of a hue in each HueRange. Weights
+// fall off linearly toward the neighboring range centers and sum to 1.
+func hueRangeWeights(hue float64) [8]float64 {
+	var weights [8]float64
+	n := len(hueRangeCenters)
+	for i := 0; i < n; i++ {
+		start := hueRangeCenters[i]
+		end := hueRangeCenters[(i+1)%n]
+		if end <= start {
+			end += 360
+		}
+		h := hue
+		if h < start {
+			h += 360
+		}
+		if h >= start && h < end {
+			t := (h - start) / (end - start)
+			weights[i] = 1 - t
+			weights[(i+1)%n] = t
+			break
+		}
+	}
+	return weights
+}
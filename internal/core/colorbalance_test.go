@@ -0,0 +1,81 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestWhiteBalanceIdentityAtD65(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{200, 120, 40, 255})
+	img.SetRGBA(1, 0, color.RGBA{30, 90, 180, 255})
+
+	out := WhiteBalance(6504, 0)(img).(*image.RGBA)
+	for x := 0; x < 2; x++ {
+		a, b := img.RGBAAt(x, 0), out.RGBAAt(x, 0)
+		if absDiff(a.R, b.R) > 2 || absDiff(a.G, b.G) > 2 || absDiff(a.B, b.B) > 2 {
+			t.Errorf("pixel %d changed from %v to %v", x, a, b)
+		}
+	}
+}
+
+func TestWhiteBalanceDirection(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{128, 128, 128, 255})
+
+	warm := WhiteBalance(3200, 0)(img).(*image.RGBA).RGBAAt(0, 0)
+	if warm.B <= warm.R {
+		t.Errorf("tungsten correction should cool gray, got %v", warm)
+	}
+	magenta := WhiteBalance(6504, 50)(img).(*image.RGBA).RGBAAt(0, 0)
+	if magenta.G >= magenta.R || magenta.G >= magenta.B {
+		t.Errorf("positive tint should add magenta, got %v", magenta)
+	}
+}
+
+func TestAutoWhiteBalanceGrayWorld(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < 64; i++ {
+		v := uint8(60 + i*2)
+		// A scene with a strong orange cast
+		img.SetRGBA(i%8, i/8, color.RGBA{v, uint8(float64(v) * 0.8), uint8(float64(v) * 0.5), 255})
+	}
+
+	out := AutoWhiteBalance(WhiteBalanceGrayWorld)(img).(*image.RGBA)
+	var sum [3]float64
+	for i := 0; i < len(out.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			sum[c] += srgbToLinear(float64(out.Pix[i+c]) / 255)
+		}
+	}
+	if math.Abs(sum[0]-sum[2])/sum[1] > 0.05 || math.Abs(sum[0]-sum[1])/sum[1] > 0.05 {
+		t.Errorf("channel means not neutral after gray world: %v", sum)
+	}
+}
+
+func TestSelectiveHSLTargetsRange(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{220, 30, 30, 255})
+	img.SetRGBA(1, 0, color.RGBA{30, 30, 220, 255})
+
+	var adj HSLAdjustments
+	adj[HueReds].Saturation = -1
+	out := SelectiveHSL(adj)(img).(*image.RGBA)
+
+	red := out.RGBAAt(0, 0)
+	if red.R != red.G || red.G != red.B {
+		t.Errorf("red was not desaturated: %v", red)
+	}
+	if blue := out.RGBAAt(1, 0); blue != img.RGBAAt(1, 0) {
+		t.Errorf("blue changed to %v", blue)
+	}
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
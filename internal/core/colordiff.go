@@ -0,0 +1,33 @@
+package core
+
+import "math"
+
+// Color-difference formulas. For LAB inputs a difference around 1 is just
+// noticeable; DeltaEOK works on the 0-1 OKLab scale, where about 0.02 is.
+
+// DeltaE76 returns the CIE76 color difference, the Euclidean distance in LAB
+func DeltaE76(a, b LAB) float64 {
+	return math.Sqrt(sq(a.L-b.L) + sq(a.A-b.A) + sq(a.B-b.B))
+}
+
+// DeltaE94 returns the CIE94 color difference with graphic arts weights,
+// taking a as the reference color
+func DeltaE94(a, b LAB) float64 {
+	const kL, k1, k2 = 1, 0.045, 0.015
+	c1, c2 := math.Hypot(a.A, a.B), math.Hypot(b.A, b.B)
+	dL, dC := a.L-b.L, c1-c2
+	// ΔH² is what remains of the squared distance after ΔL and ΔC
+	dH2 := math.Max(0, sq(a.A-b.A)+sq(a.B-b.B)-sq(dC))
+	sC, sH := 1+k1*c1, 1+k2*c1
+	return math.Sqrt(sq(dL/kL) + sq(dC/sC) + dH2/sq(sH))
+}
+
+// DeltaE2000 returns the CIEDE2000 color difference
+func DeltaE2000(a, b LAB) float64 {
+	return deltaE2000(a, b)
+}
+
+// DeltaEOK returns the Euclidean distance in OKLab
+func DeltaEOK(a, b OKLab) float64 {
+	return math.Sqrt(sq(a.L-b.L) + sq(a.A-b.A) + sq(a.B-b.B))
+}
@@ -363,3 +363,19 @@ func labFInv(t float64) float64 {
 	}
 	return (t - 16.0/116.0) / 7.787
 }
+
+// srgbToLinear decodes an sRGB component in 0-1 to linear light
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB encodes a linear-light component in 0-1 with the sRGB curve
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
@@ -105,6 +105,12 @@ type Context struct {
 	colorConverter *ColorConverter
 	// Advanced stroke
 	advancedStroke *StrokeStyle
+	// Text contrast audit
+	contrastAudit *contrastAudit
+	// Linear-light compositing and resampling
+	linearLight bool
+	// High bit-depth canvas; nil for 8-bit contexts, which draw into im
+	deep deepCanvas
 }
 
 // NewContext creates a new image.RGBA with the specified width and height
@@ -160,6 +166,9 @@ func (dc *Context) GetCurrentPoint() (Point, bool) {
 
 // Image returns the image that has been drawn by this context.
 func (dc *Context) Image() image.Image {
+	if dc.deep != nil {
+		return dc.deep.image()
+	}
 	return dc.im
 }
 
@@ -175,52 +184,56 @@ func (dc *Context) Height() int {
 
 // SavePNG encodes the image as a PNG and writes it to disk.
 func (dc *Context) SavePNG(path string) error {
-	return SavePNG(path, dc.im)
+	return SavePNG(path, dc.Image())
 }
 
 // SaveJPEG saves the current image as a JPEG file.
 func (dc *Context) SaveJPEG(path string, quality int) error {
-	return SaveJPEG(path, dc.im, quality)
+	return SaveJPEG(path, dc.Image(), quality)
 }
 
 // SaveGIF saves the current image as a GIF file.
 func (dc *Context) SaveGIF(path string) error {
-	return SaveGIF(path, dc.im)
+	return SaveGIF(path, dc.Image())
 }
 
 // SaveBMP saves the current image as a BMP file.
 func (dc *Context) SaveBMP(path string) error {
-	return SaveBMP(path, dc.im)
+	return SaveBMP(path, dc.Image())
 }
 
 // SaveTIFF saves the current image as a TIFF file.
 func (dc *Context) SaveTIFF(path string) error {
-	return SaveTIFF(path, dc.im)
+	return SaveTIFF(path, dc.Image())
 }
 
 // ImageData methods
 
 // GetImageData returns the current image as ImageData for pixel manipulation
 func (dc *Context) GetImageData() *ImageData {
-	return NewImageDataFromImage(dc.im)
+	return NewImageDataFromImage(dc.Image())
 }
 
 // GetImageDataRegion returns a region of the current image as ImageData
 func (dc *Context) GetImageDataRegion(x, y, width, height int) *ImageData {
-	imageData := NewImageDataFromImage(dc.im)
+	imageData := NewImageDataFromImage(dc.Image())
 	return imageData.GetSubImageData(x, y, width, height)
 }
 
 // PutImageData replaces the current image with ImageData
 func (dc *Context) PutImageData(imageData *ImageData) {
+	if dc.deep != nil {
+		dc.setDeepImage(imageData.Image())
+		return
+	}
 	dc.im = imageData.ToImage()
 }
 
 // PutImageDataAt places ImageData at the specified coordinates
 func (dc *Context) PutImageDataAt(imageData *ImageData, x, y int) {
-	currentData := NewImageDataFromImage(dc.im)
+	currentData := NewImageDataFromImage(dc.Image())
 	currentData.CopyFrom(imageData, 0, 0, imageData.Width, imageData.Height, x, y)
-	dc.im = currentData.ToImage()
+	dc.PutImageData(currentData)
 }
 
 // CreateImageData creates a new ImageData with the specified dimensions
@@ -230,19 +243,19 @@ func (dc *Context) CreateImageData(width, height int) *ImageData {
 
 // SaveJPG encodes the image as a JPG and writes it to disk.
 func (dc *Context) SaveJPG(path string, quality int) error {
-	return SaveJPG(path, dc.im, quality)
+	return SaveJPG(path, dc.Image(), quality)
 }
 
 // EncodePNG encodes the image as a PNG and writes it to the provided io.Writer.
 func (dc *Context) EncodePNG(w io.Writer) error {
-	return png.Encode(w, dc.im)
+	return png.Encode(w, dc.Image())
 }
 
 // EncodeJPG encodes the image as a JPG and writes it to the provided io.Writer
 // in JPEG 4:2:0 baseline format with the given options.
 // Default parameters are used if a nil *jpeg.Options is passed.
 func (dc *Context) EncodeJPG(w io.Writer, o *jpeg.Options) error {
-	return jpeg.Encode(w, dc.im, o)
+	return jpeg.Encode(w, dc.Image(), o)
 }
 
 // SetDash sets the current dash pattern to use. Call with zero arguments to
@@ -365,6 +378,14 @@ func (dc *Context) SetLAB(l, a, b float64) {
 	dc.SetRGBA(rgb.R, rgb.G, rgb.B, 1.0)
 }
 
+// SetOKLCH sets the current color using OKLCH values. Colors outside sRGB
+// are brought in by reducing chroma.
+func (dc *Context) SetOKLCH(l, c, h float64) {
+	lch := OKLCH{L: l, C: c, H: h}
+	rgb := lch.ToRGB()
+	dc.SetRGBA(rgb.R, rgb.G, rgb.B, 1.0)
+}
+
 // Shadow methods
 
 // SetShadow sets the shadow properties
@@ -404,6 +425,7 @@ func (dc *Context) HasShadow() bool {
 func (dc *Context) EnableLayers() {
 	if dc.layerManager == nil {
 		dc.layerManager = NewLayerManager(dc.width, dc.height)
+		dc.layerManager.LinearLight = dc.linearLight
 	}
 	dc.useLayerSystem = true
 }
@@ -457,6 +479,10 @@ func (dc *Context) CompositeToImage() {
 	}
 
 	composited := dc.layerManager.Composite()
+	if dc.deep != nil {
+		dc.setDeepImage(composited)
+		return
+	}
 	dc.im = composited
 }
 
@@ -773,7 +799,9 @@ func (dc *Context) fill(painter raster.Painter) {
 // operation.
 func (dc *Context) StrokePreserve() {
 	var painter raster.Painter
-	if dc.mask == nil {
+	if dc.deep != nil {
+		painter = &deepPainter{dc.deep, dc.mask, dc.strokePattern, dc.linearLight}
+	} else if dc.mask == nil && !dc.linearLight {
 		if pattern, ok := dc.strokePattern.(*solidPattern); ok {
 			// with a nil mask and a solid color pattern, we can be more efficient
 			// TODO: refactor so we don't have to do this type assertion stuff?
@@ -783,7 +811,7 @@ func (dc *Context) StrokePreserve() {
 		}
 	}
 	if painter == nil {
-		painter = newPatternPainter(dc.im, dc.mask, dc.strokePattern)
+		painter = newPatternPainter(dc.im, dc.mask, dc.strokePattern, dc.linearLight)
 	}
 	dc.stroke(painter)
 }
@@ -800,7 +828,9 @@ func (dc *Context) Stroke() {
 // are implicity closed. The path is preserved after this operation.
 func (dc *Context) FillPreserve() {
 	var painter raster.Painter
-	if dc.mask == nil {
+	if dc.deep != nil {
+		painter = &deepPainter{dc.deep, dc.mask, dc.fillPattern, dc.linearLight}
+	} else if dc.mask == nil && !dc.linearLight {
 		if pattern, ok := dc.fillPattern.(*solidPattern); ok {
 			// with a nil mask and a solid color pattern, we can be more efficient
 			// TODO: refactor so we don't have to do this type assertion stuff?
@@ -810,7 +840,7 @@ func (dc *Context) FillPreserve() {
 		}
 	}
 	if painter == nil {
-		painter = newPatternPainter(dc.im, dc.mask, dc.fillPattern)
+		painter = newPatternPainter(dc.im, dc.mask, dc.fillPattern, dc.linearLight)
 	}
 	dc.fill(painter)
 }
@@ -853,8 +883,9 @@ func (dc *Context) SetMask(mask *image.Alpha) error {
 // context. This can be useful for advanced clipping operations where you first
 // render the mask geometry and then use it as a mask.
 func (dc *Context) AsMask() *image.Alpha {
-	mask := image.NewAlpha(dc.im.Bounds())
-	draw.Draw(mask, dc.im.Bounds(), dc.im, image.ZP, draw.Src)
+	im := dc.Image()
+	mask := image.NewAlpha(im.Bounds())
+	draw.Draw(mask, im.Bounds(), im, image.ZP, draw.Src)
 	return mask
 }
 
@@ -888,11 +919,19 @@ func (dc *Context) ResetClip() {
 // Clear fills the entire image with the current color.
 func (dc *Context) Clear() {
 	src := image.NewUniform(dc.color)
+	if dc.deep != nil {
+		draw.Draw(dc.deep, dc.deep.Bounds(), src, image.ZP, draw.Src)
+		return
+	}
 	draw.Draw(dc.im, dc.im.Bounds(), src, image.ZP, draw.Src)
 }
 
 // SetPixel sets the color of the specified pixel using the current color.
 func (dc *Context) SetPixel(x, y int) {
+	if dc.deep != nil {
+		dc.deep.Set(x, y, dc.color)
+		return
+	}
 	dc.im.Set(x, y, dc.color)
 }
 
@@ -1108,7 +1147,17 @@ func (dc *Context) DrawImageAnchored(im image.Image, x, y int, ax, ay float64) {
 	fx, fy := float64(x), float64(y)
 	m := dc.matrix.Translate(fx, fy)
 	s2d := f64.Aff3{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0}
-	if dc.mask == nil {
+	if dc.deep != nil && dc.linearLight {
+		dc.drawImageDeepLinear(im, s2d, transformer)
+	} else if dc.deep != nil {
+		var opts *draw.Options
+		if dc.mask != nil {
+			opts = &draw.Options{DstMask: dc.mask, DstMaskP: image.ZP}
+		}
+		transformer.Transform(dc.deep, s2d, im, im.Bounds(), draw.Over, opts)
+	} else if dc.linearLight {
+		dc.drawImageLinear(im, s2d, transformer)
+	} else if dc.mask == nil {
 		transformer.Transform(dc.im, s2d, im, im.Bounds(), draw.Over, nil)
 	} else {
 		transformer.Transform(dc.im, s2d, im, im.Bounds(), draw.Over, &draw.Options{
@@ -1131,6 +1180,15 @@ func (dc *Context) PasteImageWithMask(im image.Image, mask image.Image, x, y int
 		return
 	}
 
+	if dc.deep != nil {
+		bounds := im.Bounds()
+		srcRect := image.Rect(x, y, x+bounds.Dx(), y+bounds.Dy())
+		if srcRect.In(dc.deep.Bounds()) {
+			draw.DrawMask(dc.deep, srcRect, im, bounds.Min, mask, bounds.Min, draw.Over)
+		}
+		return
+	}
+
 	// Create a temporary image for compositing
 	temp := image.NewRGBA(dc.im.Bounds())
 	draw.Draw(temp, dc.im.Bounds(), dc.im, image.Point{}, draw.Src)
@@ -1150,8 +1208,12 @@ func (dc *Context) CompositeImage(src image.Image, dstX, dstY int, blendMode dra
 	bounds := src.Bounds()
 	srcRect := image.Rect(dstX, dstY, dstX+bounds.Dx(), dstY+bounds.Dy())
 
-	if srcRect.In(dc.im.Bounds()) {
-		draw.Draw(dc.im, srcRect, src, bounds.Min, blendMode)
+	var dst draw.Image = dc.im
+	if dc.deep != nil {
+		dst = dc.deep
+	}
+	if srcRect.In(dst.Bounds()) {
+		draw.Draw(dst, srcRect, src, bounds.Min, blendMode)
 	}
 }
 
@@ -1581,7 +1643,15 @@ func (dc *Context) DrawStringAnchored(s string, x, y, ax, ay float64) {
 	w, h := dc.MeasureString(s)
 	x -= ax * w
 	y += ay * h
-	if dc.mask == nil {
+	if dc.contrastAudit != nil {
+		dc.auditText(s, x, y)
+	}
+	if dc.deep != nil {
+		// Glyph coverage is 8-bit; it is composited at full precision
+		im := image.NewRGBA(image.Rect(0, 0, dc.width, dc.height))
+		dc.drawMixedString(im, s, x, y)
+		dc.compositeDeep(im)
+	} else if dc.mask == nil {
 		dc.drawMixedString(dc.im, s, x, y)
 	} else {
 		im := image.NewRGBA(image.Rect(0, 0, dc.width, dc.height))
@@ -1972,6 +2042,7 @@ func (dc *Context) Pop() {
 	dc.start = before.start
 	dc.current = before.current
 	dc.hasCurrent = before.hasCurrent
+	dc.contrastAudit = before.contrastAudit
 }
 
 // Non-destructive editing methods
@@ -1979,7 +2050,11 @@ func (dc *Context) Pop() {
 // EnableNonDestructiveEditing enables non-destructive editing
 func (dc *Context) EnableNonDestructiveEditing() {
 	if dc.editStack == nil {
-		dc.editStack = NewEditStack(dc.im)
+		if dc.deep != nil {
+			dc.editStack = NewFloatEditStack(dc.Image())
+		} else {
+			dc.editStack = NewEditStack(dc.im)
+		}
 	}
 }
 
@@ -1993,9 +2068,14 @@ func (dc *Context) AddEditOperation(op EditOperation) {
 
 // ApplyNonDestructiveEdits applies all non-destructive edits to the image
 func (dc *Context) ApplyNonDestructiveEdits() {
-	if dc.editStack != nil {
-		dc.im = dc.editStack.GetResult()
+	if dc.editStack == nil {
+		return
+	}
+	if dc.deep != nil && dc.editStack.FloatBase != nil {
+		dc.setDeepImage(dc.editStack.GetFloatResult())
+		return
 	}
+	dc.im = dc.editStack.GetResult()
 }
 
 // GetEditStack returns the edit stack
@@ -0,0 +1,282 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// WCAG 2.x and APCA text contrast
+
+// RelativeLuminance returns the WCAG 2.x relative luminance of an opaque
+// color, from 0 for black to 1 for white
+func RelativeLuminance(c Color) float64 {
+	l := c.ToLinearRGB()
+	return 0.2126*l.R + 0.7152*l.G + 0.0722*l.B
+}
+
+// ContrastRatio returns the WCAG 2.x contrast ratio, from 1 to 21, of text
+// drawn over background. A translucent text color is composited over the
+// background first.
+func ContrastRatio(text, background Color) float64 {
+	text = compositeColor(text, background)
+	l1, l2 := RelativeLuminance(text), RelativeLuminance(background)
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+// WCAGLevel is a WCAG 2.x conformance level
+type WCAGLevel int
+
+const (
+	// WCAGAA needs 4.5:1 for body text and 3:1 for large text
+	WCAGAA WCAGLevel = iota
+	// WCAGAAA needs 7:1 for body text and 4.5:1 for large text
+	WCAGAAA
+)
+
+// MeetsWCAG reports whether a contrast ratio passes the level. Large text
+// is at least 24 px, or 18.66 px when bold.
+func MeetsWCAG(ratio float64, level WCAGLevel, largeText bool) bool {
+	need := map[WCAGLevel][2]float64{WCAGAA: {4.5, 3}, WCAGAAA: {7, 4.5}}[level]
+	if largeText {
+		return ratio >= need[1]
+	}
+	return ratio >= need[0]
+}
+
+// APCAContrast returns the APCA (0.0.98G) lightness contrast Lc of text
+// over background. It is positive for dark text on light backgrounds,
+// negative for light text on dark ones, and about ±106 at most.
+func APCAContrast(text, background Color) float64 {
+	text = compositeColor(text, background)
+	ys := func(c Color) float64 {
+		y := 0.2126729*math.Pow(clamp(c.R, 0, 1), 2.4) +
+			0.7151522*math.Pow(clamp(c.G, 0, 1), 2.4) +
+			0.0721750*math.Pow(clamp(c.B, 0, 1), 2.4)
+		// Soft clamp near black
+		if y < 0.022 {
+			y += math.Pow(0.022-y, 1.414)
+		}
+		return y
+	}
+	yt, yb := ys(text), ys(background)
+	if math.Abs(yb-yt) < 0.0005 {
+		return 0
+	}
+
+	var lc float64
+	if yb > yt {
+		sapc := (math.Pow(yb, 0.56) - math.Pow(yt, 0.57)) * 1.14
+		if sapc >= 0.1 {
+			lc = sapc - 0.027
+		}
+	} else {
+		sapc := (math.Pow(yb, 0.65) - math.Pow(yt, 0.62)) * 1.14
+		if sapc <= -0.1 {
+			lc = sapc + 0.027
+		}
+	}
+	return lc * 100
+}
+
+// MeetsAPCA reports whether an APCA Lc passes for body or large text.
+// AA asks for |Lc| 75 for body text and 60 for large text, AAA for 90 and
+// 75, following the APCA readability guidance.
+func MeetsAPCA(lc float64, level WCAGLevel, largeText bool) bool {
+	need := map[WCAGLevel][2]float64{WCAGAA: {75, 60}, WCAGAAA: {90, 75}}[level]
+	if largeText {
+		return math.Abs(lc) >= need[1]
+	}
+	return math.Abs(lc) >= need[0]
+}
+
+// compositeColor blends a translucent color over an opaque background
+func compositeColor(c, background Color) Color {
+	if c.A >= 1 {
+		return c
+	}
+	a := clamp(c.A, 0, 1)
+	return Color{
+		R: c.R*a + background.R*(1-a),
+		G: c.G*a + background.G*(1-a),
+		B: c.B*a + background.B*(1-a),
+		A: 1,
+	}
+}
+
+// Text contrast audit
+
+// ContrastAuditOptions configures a Context text contrast audit
+type ContrastAuditOptions struct {
+	Level WCAGLevel
+	// APCA judges text by APCA Lc instead of the WCAG 2.x ratio
+	APCA bool
+	// Backdrop shows through transparent canvas pixels; white when nil
+	Backdrop color.Color
+}
+
+// TextContrastResult reports the contrast of one drawn string
+type TextContrastResult struct {
+	Text string
+	// Bounds covers the glyph pixels on the canvas
+	Bounds     image.Rectangle
+	Foreground Color
+	// Background is the mean canvas color under the glyphs
+	Background Color
+	// Ratio and APCA, the magnitude of Lc, are taken at the 10th
+	// percentile of the glyph pixels, so a few stray pixels on a busy
+	// background do not decide the result
+	Ratio     float64
+	APCA      float64
+	LargeText bool
+	Pass      bool
+}
+
+// contrastAudit collects results while an audit runs
+type contrastAudit struct {
+	opts    ContrastAuditOptions
+	results []TextContrastResult
+}
+
+// StartContrastAudit begins checking the contrast of text drawn with
+// DrawString and the functions built on it, against what is already on
+// the canvas. The audit is not part of the state saved by Push, so one
+// started or stopped between Push and Pop stays that way after Pop.
+func (dc *Context) StartContrastAudit(opts ContrastAuditOptions) {
+	dc.contrastAudit = &contrastAudit{opts: opts}
+}
+
+// StopContrastAudit ends the audit and returns every checked string
+func (dc *Context) StopContrastAudit() []TextContrastResult {
+	results := dc.ContrastAudit()
+	dc.contrastAudit = nil
+	return results
+}
+
+// ContrastAudit returns every string checked so far
+func (dc *Context) ContrastAudit() []TextContrastResult {
+	if dc.contrastAudit == nil {
+		return nil
+	}
+	return append([]TextContrastResult(nil), dc.contrastAudit.results...)
+}
+
+// LowContrastText returns the strings that failed the audit so far
+func (dc *Context) LowContrastText() []TextContrastResult {
+	var failed []TextContrastResult
+	for _, r := range dc.ContrastAudit() {
+		if !r.Pass {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// auditText checks a string about to be drawn at x, y
+func (dc *Context) auditText(s string, x, y float64) {
+	audit := dc.contrastAudit
+	// Render opaque glyphs to find the pixels the text covers
+	area := dc.textArea(s, x, y)
+	if area.Empty() {
+		return
+	}
+	glyphs := image.NewRGBA(area)
+	textColor := dc.color
+	dc.color = color.Black
+	dc.drawMixedString(glyphs, s, x, y)
+	dc.color = textColor
+
+	backdrop := Color{R: 1, G: 1, B: 1, A: 1}
+	if audit.opts.Backdrop != nil {
+		n := color.NRGBAModel.Convert(audit.opts.Backdrop).(color.NRGBA)
+		backdrop = NewColorFromRGBA255(n.R, n.G, n.B, 255)
+	}
+	n := color.NRGBAModel.Convert(textColor).(color.NRGBA)
+	fg := NewColorFromRGBA255(n.R, n.G, n.B, n.A)
+
+	var ratios, lcs []float64
+	var sum [3]float64
+	bounds := image.Rectangle{}
+	for py := area.Min.Y; py < area.Max.Y; py++ {
+		for px := area.Min.X; px < area.Max.X; px++ {
+			if glyphs.Pix[glyphs.PixOffset(px, py)+3] < 128 {
+				continue
+			}
+			if dc.mask != nil && dc.mask.AlphaAt(px, py).A == 0 {
+				continue
+			}
+			bg := compositeColor(dc.canvasColor(px, py), backdrop)
+			ratios = append(ratios, ContrastRatio(fg, bg))
+			lcs = append(lcs, math.Abs(APCAContrast(fg, bg)))
+			sum[0] += bg.R
+			sum[1] += bg.G
+			sum[2] += bg.B
+			bounds = bounds.Union(image.Rect(px, py, px+1, py+1))
+		}
+	}
+	if len(ratios) == 0 {
+		return
+	}
+
+	count := float64(len(ratios))
+	result := TextContrastResult{
+		Text:       s,
+		Bounds:     bounds,
+		Foreground: fg,
+		Background: Color{R: sum[0] / count, G: sum[1] / count, B: sum[2] / count, A: 1},
+		Ratio:      lowPercentile(ratios, 0.1),
+		APCA:       lowPercentile(lcs, 0.1),
+		LargeText:  dc.fontHeight >= 24,
+	}
+	if audit.opts.APCA {
+		result.Pass = MeetsAPCA(result.APCA, audit.opts.Level, result.LargeText)
+	} else {
+		result.Pass = MeetsWCAG(result.Ratio, audit.opts.Level, result.LargeText)
+	}
+	audit.results = append(audit.results, result)
+}
+
+// canvasColor returns the straight color of a canvas pixel, with values
+// past white clamped
+func (dc *Context) canvasColor(x, y int) Color {
+	if dc.deep == nil {
+		r, g, b, a := straightAt(dc.im, x, y)
+		return NewColorFromRGBA255(r, g, b, a)
+	}
+	p := dc.deep.pixel(x, y)
+	c := Color{A: clamp(float64(p[3]), 0, 1)}
+	if p[3] > 0 {
+		c.R = clamp(float64(p[0]/p[3]), 0, 1)
+		c.G = clamp(float64(p[1]/p[3]), 0, 1)
+		c.B = clamp(float64(p[2]/p[3]), 0, 1)
+	}
+	return c
+}
+
+// textArea returns the part of the canvas a string drawn at x, y can
+// cover. It pads the measured line by a line height for overhangs and
+// emoji, which are placed without the transformation.
+func (dc *Context) textArea(s string, x, y float64) image.Rectangle {
+	w, h := dc.MeasureString(s)
+	m := dc.fontFace.Metrics()
+	top := y - float64(m.Ascent)/64 - h
+	bottom := y + float64(m.Descent)/64 + h
+	var area image.Rectangle
+	for _, p := range []Point{{x - h, top}, {x + w + h, top}, {x - h, bottom}, {x + w + h, bottom}} {
+		tx, ty := dc.matrix.TransformPoint(p.X, p.Y)
+		for _, q := range [][2]float64{{tx, ty}, {p.X, p.Y}} {
+			area = area.Union(image.Rect(int(math.Floor(q[0])), int(math.Floor(q[1])), int(math.Ceil(q[0]))+1, int(math.Ceil(q[1]))+1))
+		}
+	}
+	return area.Intersect(image.Rect(0, 0, dc.width, dc.height))
+}
+
+// lowPercentile returns the value at fraction p of the sorted values
+func lowPercentile(values []float64, p float64) float64 {
+	sort.Float64s(values)
+	return values[int(p*float64(len(values)-1))]
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"image/color"
+	"math"
+	"runtime"
+	"testing"
+)
+
+func TestContrastRatio(t *testing.T) {
+	black, white := NewColor(0, 0, 0, 1), NewColor(1, 1, 1, 1)
+	if r := ContrastRatio(black, white); math.Abs(r-21) > 1e-9 {
+		t.Errorf("black on white = %v", r)
+	}
+	if r := ContrastRatio(white, white); r != 1 {
+		t.Errorf("white on white = %v", r)
+	}
+	// #777 on white is the classic near miss for AA body text
+	gray := NewColorFromRGBA255(0x77, 0x77, 0x77, 255)
+	r := ContrastRatio(gray, white)
+	if math.Abs(r-4.48) > 0.01 {
+		t.Errorf("#777 on white = %v", r)
+	}
+	if MeetsWCAG(r, WCAGAA, false) || !MeetsWCAG(r, WCAGAA, true) || MeetsWCAG(r, WCAGAAA, true) {
+		t.Error("#777 on white should only pass AA for large text")
+	}
+	if r := ContrastRatio(NewColor(0, 0, 0, 0.5), white); r > 5 {
+		t.Errorf("half-transparent black should composite to gray, ratio %v", r)
+	}
+}
+
+func TestAPCAContrast(t *testing.T) {
+	black, white := NewColor(0, 0, 0, 1), NewColor(1, 1, 1, 1)
+	cases := []struct {
+		text, bg Color
+		want     float64
+	}{
+		{black, white, 106.04},
+		{white, black, -107.88},
+		{NewColorFromRGBA255(0x88, 0x88, 0x88, 255), white, 63.06},
+		{white, NewColorFromRGBA255(0x88, 0x88, 0x88, 255), -68.54},
+	}
+	for _, tc := range cases {
+		if lc := APCAContrast(tc.text, tc.bg); math.Abs(lc-tc.want) > 0.05 {
+			t.Errorf("Lc(%v on %v) = %.2f, want %.2f", tc.text, tc.bg, lc, tc.want)
+		}
+	}
+	if APCAContrast(white, white) != 0 {
+		t.Error("equal colors should have no contrast")
+	}
+	if !MeetsAPCA(-80, WCAGAA, false) || MeetsAPCA(70, WCAGAA, false) || !MeetsAPCA(70, WCAGAA, true) {
+		t.Error("APCA thresholds")
+	}
+}
+
+func TestContrastAudit(t *testing.T) {
+	dc := NewContext(200, 60)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0.2, 0.2, 0.8)
+	dc.DrawRectangle(100, 0, 100, 60)
+	dc.Fill()
+
+	dc.StartContrastAudit(ContrastAuditOptions{})
+	dc.SetRGB(0, 0, 0)
+	dc.DrawString("Readable", 10, 30)
+	dc.Push()
+	dc.SetRGB(0.1, 0.1, 0.5)
+	dc.DrawString("Hidden", 110, 30)
+	dc.Pop()
+	results := dc.StopContrastAudit()
+
+	if len(results) != 2 {
+		t.Fatalf("audited %d strings", len(results))
+	}
+	if !results[0].Pass || results[0].Ratio < 15 {
+		t.Errorf("black on white should pass: %+v", results[0])
+	}
+	low := results[1]
+	if low.Pass || low.Text != "Hidden" || low.Ratio > 3 {
+		t.Errorf("navy on blue should fail: %+v", low)
+	}
+	if low.Bounds.Min.X < 110 || low.Bounds.Max.Y > 32 || low.Bounds.Empty() {
+		t.Errorf("bounds = %v", low.Bounds)
+	}
+	if low.Background.B < 0.75 {
+		t.Errorf("background = %v", low.Background)
+	}
+	if dc.LowContrastText() != nil {
+		t.Error("a stopped audit should report nothing")
+	}
+
+	// An audit started inside Push and Pop outlives them
+	dc.Push()
+	dc.StartContrastAudit(ContrastAuditOptions{})
+	dc.Pop()
+	dc.DrawString("After", 10, 30)
+	if results := dc.StopContrastAudit(); len(results) != 1 || results[0].Text != "After" {
+		t.Errorf("audit across Pop = %+v", results)
+	}
+}
+
+func TestContrastAuditDeep(t *testing.T) {
+	// Near-white text on white fails against the canvas at every depth
+	for _, format := range []PixelFormat{FormatRGBA8, FormatRGBA16, FormatRGBAF32} {
+		dc := NewContextWithFormat(100, 40, format)
+		dc.SetRGB(1, 1, 1)
+		dc.Clear()
+
+		// A black backdrop shows up if the blank 8-bit buffer is sampled
+		dc.StartContrastAudit(ContrastAuditOptions{Backdrop: color.Black})
+		dc.SetRGB(0.95, 0.95, 0.95)
+		dc.DrawString("Faint", 5, 25)
+		results := dc.StopContrastAudit()
+		if len(results) != 1 || results[0].Pass || results[0].Ratio > 1.5 {
+			t.Errorf("format %v: near-white on white = %+v", format, results)
+		}
+	}
+}
+
+func TestContrastAuditArea(t *testing.T) {
+	// Only the area around the text is rendered and scanned
+	dc := NewContext(2000, 2000)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	dc.StartContrastAudit(ContrastAuditOptions{})
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	dc.DrawString("Small", 1000, 1000)
+	runtime.ReadMemStats(&after)
+	if grown := after.TotalAlloc - before.TotalAlloc; grown > 1<<20 {
+		t.Errorf("auditing one word allocated %d bytes", grown)
+	}
+
+	dc.RotateAbout(math.Pi/2, 1000, 1000)
+	dc.DrawString("Turned", 1000, 1000)
+	results := dc.StopContrastAudit()
+	if len(results) != 2 {
+		t.Fatalf("audited %d strings", len(results))
+	}
+	if b := results[1].Bounds; b.Dx() >= b.Dy() || b.Min.X < 980 || b.Max.Y < 1020 {
+		t.Errorf("rotated text bounds = %v", b)
+	}
+}
@@ -0,0 +1,156 @@
+package core
+
+import "image"
+
+// Color-vision deficiency simulation and daltonization
+
+// CVDType selects the simulated color-vision deficiency
+type CVDType int
+
+const (
+	// Protanopia lacks long-wavelength (red) cones
+	Protanopia CVDType = iota
+	// Deuteranopia lacks medium-wavelength (green) cones
+	Deuteranopia
+	// Tritanopia lacks short-wavelength (blue) cones
+	Tritanopia
+	// Achromatopsia sees luminance only
+	Achromatopsia
+)
+
+// CVDModel selects the simulation model
+type CVDModel int
+
+const (
+	// CVDMachado uses the Machado, Oliveira and Fernandes (2009) model
+	CVDMachado CVDModel = iota
+	// CVDBrettel uses the Brettel, Viénot and Mollon (1997) model, which
+	// projects onto two half-planes and is more accurate for tritanopia
+	CVDBrettel
+)
+
+// machadoMatrices hold the full-severity Machado matrices in linear RGB
+var machadoMatrices = map[CVDType][3][3]float64{
+	Protanopia: {
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	},
+	Deuteranopia: {
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	},
+	Tritanopia: {
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	},
+}
+
+// brettelParams holds the two projection matrices of a Brettel simulation
+// in linear RGB and the normal of the plane that picks between them
+type brettelParams struct {
+	first, second [3][3]float64
+	normal        [3]float64
+}
+
+var brettelTables = map[CVDType]brettelParams{
+	Protanopia: {
+		first:  [3][3]float64{{0.14510, 1.20165, -0.34675}, {0.10447, 0.85316, 0.04237}, {0.00429, -0.00603, 1.00174}},
+		second: [3][3]float64{{0.14115, 1.16782, -0.30897}, {0.10495, 0.85730, 0.03776}, {0.00431, -0.00586, 1.00155}},
+		normal: [3]float64{0.00048, 0.00416, -0.00464},
+	},
+	Deuteranopia: {
+		first:  [3][3]float64{{0.36198, 0.86755, -0.22953}, {0.26099, 0.64512, 0.09389}, {-0.01975, 0.02686, 0.99289}},
+		second: [3][3]float64{{0.37009, 0.88540, -0.25549}, {0.25767, 0.63782, 0.10451}, {-0.01950, 0.02741, 0.99209}},
+		normal: [3]float64{-0.00293, -0.00645, 0.00938},
+	},
+	Tritanopia: {
+		first:  [3][3]float64{{1.01354, 0.14268, -0.15622}, {-0.01181, 0.87561, 0.13619}, {0.07707, 0.81208, 0.11085}},
+		second: [3][3]float64{{0.93337, 0.19999, -0.13336}, {0.05809, 0.82565, 0.11626}, {-0.37923, 1.13825, 0.24098}},
+		normal: [3]float64{0.03960, -0.02831, -0.01129},
+	},
+}
+
+// cvdLinear simulates a deficiency on linear RGB. Severity in 0..1 blends
+// from normal vision to full dichromacy.
+func cvdLinear(rgb [3]float64, kind CVDType, severity float64, model CVDModel) [3]float64 {
+	var sim [3]float64
+	switch {
+	case kind == Achromatopsia:
+		y := 0.2126729*rgb[0] + 0.7151522*rgb[1] + 0.0721750*rgb[2]
+		sim = [3]float64{y, y, y}
+	case model == CVDBrettel:
+		p := brettelTables[kind]
+		m := p.first
+		if rgb[0]*p.normal[0]+rgb[1]*p.normal[1]+rgb[2]*p.normal[2] < 0 {
+			m = p.second
+		}
+		sim = mulMatrix3(m, rgb)
+	default:
+		sim = mulMatrix3(machadoMatrices[kind], rgb)
+	}
+	for i := range sim {
+		sim[i] = rgb[i] + (sim[i]-rgb[i])*severity
+	}
+	return sim
+}
+
+// SimulateCVD shows how img appears with a color-vision deficiency, using
+// the Machado model; severity runs from 0 (normal) to 1 (dichromacy)
+func SimulateCVD(img image.Image, kind CVDType, severity float64) *image.RGBA {
+	return SimulateCVDWithModel(img, kind, severity, CVDMachado)
+}
+
+// SimulateCVDWithModel shows how img appears with a color-vision
+// deficiency using the given model
+func SimulateCVDWithModel(img image.Image, kind CVDType, severity float64, model CVDModel) *image.RGBA {
+	severity = clamp(severity, 0, 1)
+	return mapStraightRGB(asRGBA(img), func(r, g, b float64) (float64, float64, float64) {
+		sim := cvdLinear([3]float64{srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)}, kind, severity, model)
+		return linearToSRGB(clamp(sim[0], 0, 1)), linearToSRGB(clamp(sim[1], 0, 1)), linearToSRGB(clamp(sim[2], 0, 1))
+	})
+}
+
+// CVDSimulation returns a filter applying SimulateCVD
+func CVDSimulation(kind CVDType, severity float64) Filter {
+	return func(img image.Image) image.Image {
+		return SimulateCVD(img, kind, severity)
+	}
+}
+
+// SimulateCVD returns the color as seen with a color-vision deficiency
+func (c Color) SimulateCVD(kind CVDType, severity float64) Color {
+	l := c.ToLinearRGB()
+	sim := cvdLinear([3]float64{l.R, l.G, l.B}, kind, clamp(severity, 0, 1), CVDMachado)
+	out := LinearRGB{R: sim[0], G: sim[1], B: sim[2]}.ToRGB()
+	out.A = c.A
+	return out
+}
+
+// Daltonize returns a filter that recolors images so details lost to a
+// deficiency become visible. The color error the deficiency hides is moved
+// into channels it still distinguishes (Fidaner, Lin and Ozguven).
+func Daltonize(kind CVDType, severity float64) Filter {
+	severity = clamp(severity, 0, 1)
+	// Protan and deutan errors shift into green-blue contrast, tritan
+	// errors into red-green
+	shift := [3][3]float64{{0, 0, 0}, {0.7, 1, 0}, {0.7, 0, 1}}
+	if kind == Tritanopia {
+		shift = [3][3]float64{{1, 0, 0.7}, {0, 1, 0.7}, {0, 0, 0}}
+	}
+	return func(img image.Image) image.Image {
+		if kind == Achromatopsia || severity == 0 {
+			return imageToRGBA(img)
+		}
+		return mapStraightRGB(asRGBA(img), func(r, g, b float64) (float64, float64, float64) {
+			rgb := [3]float64{srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)}
+			sim := cvdLinear(rgb, kind, severity, CVDMachado)
+			diff := mulMatrix3(shift, [3]float64{rgb[0] - sim[0], rgb[1] - sim[1], rgb[2] - sim[2]})
+			return linearToSRGB(clamp(rgb[0]+diff[0], 0, 1)),
+				linearToSRGB(clamp(rgb[1]+diff[1], 0, 1)),
+				linearToSRGB(clamp(rgb[2]+diff[2], 0, 1))
+		})
+	}
+}
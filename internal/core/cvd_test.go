@@ -0,0 +1,58 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSimulateCVD(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{200, 40, 40, 255}) // red
+	img.SetRGBA(1, 0, color.RGBA{60, 160, 40, 255}) // green
+	img.SetRGBA(2, 0, color.RGBA{128, 128, 128, 128})
+
+	for _, model := range []CVDModel{CVDMachado, CVDBrettel} {
+		for _, kind := range []CVDType{Protanopia, Deuteranopia} {
+			sim := SimulateCVDWithModel(img, kind, 1, model)
+			red, green := sim.RGBAAt(0, 0), sim.RGBAAt(1, 0)
+			// Red and green collapse onto the same yellow-blue axis: both
+			// end up with more green than blue and similar hue
+			if abs(int(red.R)-int(red.G)) > 40 || abs(int(green.R)-int(green.G)) > 40 {
+				t.Errorf("model %d kind %d: red %v and green %v should lose red-green contrast", model, kind, red, green)
+			}
+			if d := maxChannelDiff(img.At(2, 0), sim.At(2, 0)); d > 1 {
+				t.Errorf("model %d kind %d: gray changed by %d", model, kind, d)
+			}
+		}
+	}
+
+	if d := maxChannelDiff(img.At(0, 0), SimulateCVD(img, Tritanopia, 0).At(0, 0)); d > 0 {
+		t.Errorf("zero severity changed the image by %d", d)
+	}
+	mono := SimulateCVD(img, Achromatopsia, 1).RGBAAt(0, 0)
+	if mono.R != mono.G || mono.G != mono.B {
+		t.Errorf("achromatopsia should be gray, got %v", mono)
+	}
+	if c := NewColor(0, 0, 1, 1).SimulateCVD(Tritanopia, 1); c.B > 0.6 {
+		t.Errorf("tritanopes should barely see pure blue as blue, got %v", c)
+	}
+}
+
+func TestDaltonize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{200, 60, 60, 255})
+	img.SetRGBA(1, 0, color.RGBA{90, 140, 60, 255})
+
+	distance := func(im *image.RGBA) float64 {
+		a := NewColorFromRGBA255(im.Pix[0], im.Pix[1], im.Pix[2], 255).ToLAB()
+		b := NewColorFromRGBA255(im.Pix[4], im.Pix[5], im.Pix[6], 255).ToLAB()
+		return DeltaE2000(a, b)
+	}
+	before := distance(SimulateCVD(img, Deuteranopia, 1))
+	corrected := Daltonize(Deuteranopia, 1)(img)
+	after := distance(SimulateCVD(corrected, Deuteranopia, 1))
+	if after <= before*1.2 {
+		t.Errorf("daltonizing should separate the colors for deuteranopes: %.1f -> %.1f", before, after)
+	}
+}
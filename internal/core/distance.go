@@ -0,0 +1,244 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Exact Euclidean distance transforms and signed distance fields
+
+// distanceInf marks grid cells that have no seed pixel yet
+const distanceInf = 1e20
+
+// DistanceField is a dense grid of distances in pixels, stored row by row
+type DistanceField struct {
+	Width  int
+	Height int
+	Data   []float64
+}
+
+// NewDistanceField creates a zero-filled distance field
+func NewDistanceField(width, height int) *DistanceField {
+	return &DistanceField{
+		Width:  width,
+		Height: height,
+		Data:   make([]float64, width*height),
+	}
+}
+
+// At returns the distance at the specified coordinates
+func (df *DistanceField) At(x, y int) float64 {
+	if x < 0 || x >= df.Width || y < 0 || y >= df.Height {
+		return 0
+	}
+	return df.Data[y*df.Width+x]
+}
+
+// Set sets the distance at the specified coordinates
+func (df *DistanceField) Set(x, y int, d float64) {
+	if x < 0 || x >= df.Width || y < 0 || y >= df.Height {
+		return
+	}
+	df.Data[y*df.Width+x] = d
+}
+
+// DistanceTransform computes the exact Euclidean distance from every pixel to
+// the nearest fully transparent pixel of the mask. Transparent pixels get 0.
+func DistanceTransform(mask *image.Alpha) *DistanceField {
+	bounds := mask.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	grid := make([]float64, w*h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if mask.AlphaAt(bounds.Min.X+x, bounds.Min.Y+y).A == 0 {
+				grid[y*w+x] = 0
+			} else {
+				grid[y*w+x] = distanceInf
+			}
+		}
+	}
+
+	edt2D(grid, w, h)
+
+	df := &DistanceField{Width: w, Height: h, Data: grid}
+	for i, d := range df.Data {
+		df.Data[i] = math.Sqrt(d)
+	}
+	return df
+}
+
+// GenerateSDF computes a signed distance field for the mask. Distances are
+// negative inside the shape and positive outside, with the zero crossing on
+// the 50% alpha contour. Antialiased edges are used for sub-pixel accuracy;
+// hard binary edges are accurate to half a pixel. Distances are clamped to [-spread, spread].
+func GenerateSDF(mask *image.Alpha, spread float64) *DistanceField {
+	bounds := mask.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	outer := make([]float64, w*h)
+	inner := make([]float64, w*h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			a := float64(mask.AlphaAt(bounds.Min.X+x, bounds.Min.Y+y).A) / 255.0
+			switch {
+			case a >= 1:
+				outer[i] = 0
+				inner[i] = distanceInf
+			case a <= 0:
+				outer[i] = distanceInf
+				inner[i] = 0
+			default:
+				d := math.Max(0, 0.5-a)
+				outer[i] = d * d
+				d = math.Max(0, a-0.5)
+				inner[i] = d * d
+			}
+		}
+	}
+
+	edt2D(outer, w, h)
+	edt2D(inner, w, h)
+
+	df := NewDistanceField(w, h)
+	for i := range df.Data {
+		d := math.Sqrt(outer[i]) - math.Sqrt(inner[i])
+		if spread > 0 {
+			d = clampFloat64(d, -spread, spread)
+		}
+		df.Data[i] = d
+	}
+	return df
+}
+
+// ToGray encodes the field as an 8-bit image where 128 is the zero crossing
+// and each unit of spread maps to 127 levels. Inside pixels are brighter.
+func (df *DistanceField) ToGray(spread float64) *image.Gray {
+	if spread <= 0 {
+		spread = 1
+	}
+	img := image.NewGray(image.Rect(0, 0, df.Width, df.Height))
+	for i, d := range df.Data {
+		img.Pix[i] = uint8(clampFloat64(math.Round(128-d/spread*127), 0, 255))
+	}
+	return img
+}
+
+// ToAlpha thresholds the field at offset, producing an antialiased mask of
+// every pixel whose distance is at most offset. Softness widens the falloff
+// band, which turns the result into a glow.
+func (df *DistanceField) ToAlpha(offset, softness float64) *image.Alpha {
+	if softness < 1 {
+		softness = 1
+	}
+	mask := image.NewAlpha(image.Rect(0, 0, df.Width, df.Height))
+	for i, d := range df.Data {
+		t := 0.5 - (d-offset)/softness
+		mask.Pix[i] = uint8(math.Round(clampFloat64(t, 0, 1) * 255))
+	}
+	return mask
+}
+
+// Outline returns a mask covering a band of the given width around the
+// shape's contour, outside the shape. Use it with SDFs from GenerateSDF.
+func (df *DistanceField) Outline(width, softness float64) *image.Alpha {
+	outer := df.ToAlpha(width, softness)
+	inner := df.ToAlpha(0, softness)
+	for i := range outer.Pix {
+		if inner.Pix[i] >= outer.Pix[i] {
+			outer.Pix[i] = 0
+		} else {
+			outer.Pix[i] -= inner.Pix[i]
+		}
+	}
+	return outer
+}
+
+// DrawDistanceOutline strokes the contour of a mask with the current color,
+// producing a crisp band of the given width outside the shape
+func (dc *Context) DrawDistanceOutline(mask *image.Alpha, width float64) {
+	sdf := GenerateSDF(mask, width+1)
+	band := sdf.Outline(width, 1)
+	r, g, b, ca := dc.color.RGBA()
+	bounds := mask.Bounds()
+	for y := 0; y < band.Rect.Dy(); y++ {
+		for x := 0; x < band.Rect.Dx(); x++ {
+			a := band.Pix[y*band.Stride+x]
+			if a == 0 {
+				continue
+			}
+			k := float64(a) / 255.0
+			dc.blendPixel(bounds.Min.X+x, bounds.Min.Y+y, color.RGBA{
+				R: uint8(r >> 8),
+				G: uint8(g >> 8),
+				B: uint8(b >> 8),
+				A: uint8(float64(ca>>8) * k),
+			})
+		}
+	}
+}
+
+// edt2D computes the squared Euclidean distance transform of a sampled
+// function in place (Felzenszwalb & Huttenlocher), columns first then rows
+func edt2D(grid []float64, w, h int) {
+	n := w
+	if h > n {
+		n = h
+	}
+	f := make([]float64, n)
+	d := make([]float64, n)
+	v := make([]int, n)
+	z := make([]float64, n+1)
+
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			f[y] = grid[y*w+x]
+		}
+		edt1D(f[:h], d[:h], v, z)
+		for y := 0; y < h; y++ {
+			grid[y*w+x] = d[y]
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		copy(f[:w], grid[y*w:(y+1)*w])
+		edt1D(f[:w], d[:w], v, z)
+		copy(grid[y*w:(y+1)*w], d[:w])
+	}
+}
+
+// edt1D computes the 1D squared distance transform of f into d using the
+// lower envelope of parabolas rooted at each sample
+func edt1D(f, d []float64, v []int, z []float64) {
+	n := len(f)
+	if n == 0 {
+		return
+	}
+	k := 0
+	v[0] = 0
+	z[0] = -math.MaxFloat64
+	z[1] = math.MaxFloat64
+
+	for q := 1; q < n; q++ {
+		s := ((f[q] + float64(q*q)) - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*q-2*v[k])
+		for s <= z[k] {
+			k--
+			s = ((f[q] + float64(q*q)) - (f[v[k]] + float64(v[k]*v[k]))) / float64(2*q-2*v[k])
+		}
+		k++
+		v[k] = q
+		z[k] = s
+		z[k+1] = math.MaxFloat64
+	}
+
+	k = 0
+	for q := 0; q < n; q++ {
+		for z[k+1] < float64(q) {
+			k++
+		}
+		dq := float64(q - v[k])
+		d[q] = dq*dq + f[v[k]]
+	}
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestDistanceTransform_SinglePixel(t *testing.T) {
+	mask := image.NewAlpha(image.Rect(0, 0, 9, 9))
+	for i := range mask.Pix {
+		mask.Pix[i] = 255
+	}
+	mask.Pix[4*mask.Stride+4] = 0
+
+	df := DistanceTransform(mask)
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 9; x++ {
+			want := math.Hypot(float64(x-4), float64(y-4))
+			if got := df.At(x, y); math.Abs(got-want) > 1e-9 {
+				t.Fatalf("distance at (%d,%d) = %f, want %f", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestGenerateSDF_Sign(t *testing.T) {
+	dc := NewContext(64, 64)
+	dc.SetRGB(1, 1, 1)
+	dc.DrawCircle(32, 32, 16)
+	dc.Fill()
+
+	sdf := GenerateSDF(dc.AsMask(), 8)
+	if d := sdf.At(32, 32); d != -8 {
+		t.Errorf("center distance = %f, want clamped -8", d)
+	}
+	if d := sdf.At(0, 0); d != 8 {
+		t.Errorf("corner distance = %f, want clamped 8", d)
+	}
+	if d := sdf.At(52, 32); d < 4 || d > 5 {
+		t.Errorf("distance 4px outside edge = %f", d)
+	}
+
+	gray := sdf.ToGray(8)
+	if gray.GrayAt(32, 32).Y != 255 || gray.GrayAt(0, 0).Y != 1 {
+		t.Errorf("unexpected encoding: center %d, corner %d", gray.GrayAt(32, 32).Y, gray.GrayAt(0, 0).Y)
+	}
+}
@@ -0,0 +1,346 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Palette-constrained output with error diffusion and ordered dithering
+
+// DitherAlgorithm selects how colors between palette entries are rendered
+type DitherAlgorithm int
+
+const (
+	// DitherNone maps every pixel to its nearest palette color
+	DitherNone DitherAlgorithm = iota
+	DitherFloydSteinberg
+	// DitherAtkinson diffuses only 3/4 of the error, keeping highlights clean
+	DitherAtkinson
+	DitherJarvisJudiceNinke
+	DitherSierra
+	// DitherBayer adds an 8x8 ordered threshold pattern
+	DitherBayer
+	// DitherBlueNoise adds a 64x64 void-and-cluster threshold pattern, which
+	// avoids the cross-hatch look of Bayer
+	DitherBlueNoise
+)
+
+// diffusionTap is one neighbor of an error diffusion kernel
+type diffusionTap struct {
+	dx, dy int
+	weight float64
+}
+
+var diffusionKernels = map[DitherAlgorithm][]diffusionTap{
+	DitherFloydSteinberg: {
+		{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	DitherAtkinson: {
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8}, {-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8}, {0, 2, 1.0 / 8},
+	},
+	DitherJarvisJudiceNinke: {
+		{1, 0, 7.0 / 48}, {2, 0, 5.0 / 48},
+		{-2, 1, 3.0 / 48}, {-1, 1, 5.0 / 48}, {0, 1, 7.0 / 48}, {1, 1, 5.0 / 48}, {2, 1, 3.0 / 48},
+		{-2, 2, 1.0 / 48}, {-1, 2, 3.0 / 48}, {0, 2, 5.0 / 48}, {1, 2, 3.0 / 48}, {2, 2, 1.0 / 48},
+	},
+	DitherSierra: {
+		{1, 0, 5.0 / 32}, {2, 0, 3.0 / 32},
+		{-2, 1, 2.0 / 32}, {-1, 1, 4.0 / 32}, {0, 1, 5.0 / 32}, {1, 1, 4.0 / 32}, {2, 1, 2.0 / 32},
+		{-1, 2, 2.0 / 32}, {0, 2, 3.0 / 32}, {1, 2, 2.0 / 32},
+	},
+}
+
+// Dither maps the image onto the palette using the given algorithm. Pixels
+// less than half opaque use the palette's first transparent entry if any.
+func Dither(img image.Image, palette color.Palette, algo DitherAlgorithm) *image.Paletted {
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	result := image.NewPaletted(bounds, palette)
+	if len(palette) == 0 {
+		return result
+	}
+
+	pm := newPaletteMatcher(palette)
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if kernel, ok := diffusionKernels[algo]; ok {
+		ditherDiffuse(src, result, pm, kernel)
+		return result
+	}
+
+	var threshold func(x, y int) float64
+	switch algo {
+	case DitherBayer:
+		threshold = func(x, y int) float64 { return bayerMatrix8[y&7][x&7] }
+	case DitherBlueNoise:
+		noise := blueNoiseMatrix()
+		threshold = func(x, y int) float64 { return noise[(y&63)*64+(x&63)] }
+	}
+	spread := orderedDitherSpread(pm.opaqueCount())
+
+	parallelRows(h, 0, func(start, end int) {
+		cache := make(map[uint32]uint8)
+		for y := start; y < end; y++ {
+			for x := 0; x < w; x++ {
+				r, g, b, a := straightAt(src, bounds.Min.X+x, bounds.Min.Y+y)
+				var idx uint8
+				switch {
+				case a < 128 && pm.transparent >= 0:
+					idx = uint8(pm.transparent)
+				case threshold != nil:
+					t := threshold(x, y) * spread
+					idx = uint8(pm.nearest(float64(r)+t, float64(g)+t, float64(b)+t))
+				default:
+					key := uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+					cached, ok := cache[key]
+					if !ok {
+						cached = uint8(pm.nearest(float64(r), float64(g), float64(b)))
+						cache[key] = cached
+					}
+					idx = cached
+				}
+				result.Pix[y*result.Stride+x] = idx
+			}
+		}
+	})
+	return result
+}
+
+// DitherFilter returns a Filter that renders the image with the palette
+func DitherFilter(palette color.Palette, algo DitherAlgorithm) Filter {
+	return func(img image.Image) image.Image {
+		return imageToRGBA(Dither(img, palette, algo))
+	}
+}
+
+// ditherDiffuse runs serpentine error diffusion, which avoids the diagonal
+// drift of always scanning left to right
+func ditherDiffuse(src *image.RGBA, dst *image.Paletted, pm *paletteMatcher, kernel []diffusionTap) {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	buf := make([]float64, w*h*3)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := straightAt(src, bounds.Min.X+x, bounds.Min.Y+y)
+			i := (y*w + x) * 3
+			buf[i], buf[i+1], buf[i+2] = float64(r), float64(g), float64(b)
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		reverse := y%2 == 1
+		for k := 0; k < w; k++ {
+			x := k
+			if reverse {
+				x = w - 1 - k
+			}
+
+			_, _, _, a := straightAt(src, bounds.Min.X+x, bounds.Min.Y+y)
+			if a < 128 && pm.transparent >= 0 {
+				dst.Pix[y*dst.Stride+x] = uint8(pm.transparent)
+				continue
+			}
+
+			i := (y*w + x) * 3
+			r, g, b := clamp(buf[i], 0, 255), clamp(buf[i+1], 0, 255), clamp(buf[i+2], 0, 255)
+			idx := pm.nearest(r, g, b)
+			dst.Pix[y*dst.Stride+x] = uint8(idx)
+
+			c := pm.colors[idx]
+			er, eg, eb := r-c[0], g-c[1], b-c[2]
+			for _, tap := range kernel {
+				dx := tap.dx
+				if reverse {
+					dx = -dx
+				}
+				nx, ny := x+dx, y+tap.dy
+				if nx < 0 || nx >= w || ny >= h {
+					continue
+				}
+				j := (ny*w + nx) * 3
+				buf[j] += er * tap.weight
+				buf[j+1] += eg * tap.weight
+				buf[j+2] += eb * tap.weight
+			}
+		}
+	}
+}
+
+// straightAt returns the unpremultiplied color at (x, y)
+func straightAt(img *image.RGBA, x, y int) (uint8, uint8, uint8, uint8) {
+	i := img.PixOffset(x, y)
+	r, g, b, a := img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]
+	if a == 255 || a == 0 {
+		return r, g, b, a
+	}
+	return unpremultiply8(r, uint32(a)), unpremultiply8(g, uint32(a)), unpremultiply8(b, uint32(a)), a
+}
+
+// paletteMatcher finds the nearest opaque palette color
+type paletteMatcher struct {
+	colors      [][3]float64
+	opaque      []int
+	transparent int
+}
+
+func newPaletteMatcher(palette color.Palette) *paletteMatcher {
+	pm := &paletteMatcher{colors: make([][3]float64, len(palette)), transparent: -1}
+	for i, c := range palette {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		pm.colors[i] = [3]float64{float64(nc.R), float64(nc.G), float64(nc.B)}
+		if nc.A < 128 {
+			if pm.transparent < 0 {
+				pm.transparent = i
+			}
+			continue
+		}
+		pm.opaque = append(pm.opaque, i)
+	}
+	if len(pm.opaque) == 0 {
+		for i := range palette {
+			pm.opaque = append(pm.opaque, i)
+		}
+	}
+	return pm
+}
+
+func (pm *paletteMatcher) opaqueCount() int {
+	return len(pm.opaque)
+}
+
+func (pm *paletteMatcher) nearest(r, g, b float64) int {
+	best, bestDist := pm.opaque[0], math.MaxFloat64
+	for _, i := range pm.opaque {
+		c := pm.colors[i]
+		dr, dg, db := r-c[0], g-c[1], b-c[2]
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// orderedDitherSpread estimates the spacing between palette levels per
+// channel, which sets the amplitude of the threshold pattern
+func orderedDitherSpread(n int) float64 {
+	levels := math.Round(math.Cbrt(float64(n)))
+	if levels < 2 {
+		levels = 2
+	}
+	return 255 / (levels - 1)
+}
+
+// bayerMatrix8 holds 8x8 Bayer thresholds centered on zero in -0.5..0.5
+var bayerMatrix8 = func() [8][8]float64 {
+	var m [8][8]float64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			// Interleave the bits of x^y and y to get the Bayer index
+			v, xc, yc := 0, x^y, y
+			for bit := 0; bit < 3; bit++ {
+				v = v<<2 | (xc>>uint(bit)&1)<<1 | (yc >> uint(bit) & 1)
+			}
+			m[y][x] = (float64(v)+0.5)/64 - 0.5
+		}
+	}
+	return m
+}()
+
+var (
+	blueNoiseOnce sync.Once
+	blueNoise     []float64
+)
+
+// blueNoiseMatrix returns a 64x64 tileable blue noise threshold map in
+// -0.5..0.5, generated once with Ulichney's void-and-cluster method
+func blueNoiseMatrix() []float64 {
+	blueNoiseOnce.Do(func() {
+		const size = 64
+		const n = size * size
+		const sigma = 1.5
+
+		// Toroidal Gaussian energy kernel
+		kernel := make([]float64, n)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				dx := math.Min(float64(x), float64(size-x))
+				dy := math.Min(float64(y), float64(size-y))
+				kernel[y*size+x] = math.Exp(-(dx*dx + dy*dy) / (2 * sigma * sigma))
+			}
+		}
+
+		pattern := make([]bool, n)
+		energy := make([]float64, n)
+		update := func(p []bool, e []float64, i int, add bool) {
+			p[i] = add
+			sign := 1.0
+			if !add {
+				sign = -1
+			}
+			px, py := i%size, i/size
+			for y := 0; y < size; y++ {
+				ky := ((y - py) + size) % size
+				for x := 0; x < size; x++ {
+					kx := ((x - px) + size) % size
+					e[y*size+x] += sign * kernel[ky*size+kx]
+				}
+			}
+		}
+		extreme := func(p []bool, e []float64, want bool, max bool) int {
+			best := -1
+			for i := range p {
+				if p[i] != want {
+					continue
+				}
+				if best < 0 || (max && e[i] > e[best]) || (!max && e[i] < e[best]) {
+					best = i
+				}
+			}
+			return best
+		}
+
+		// Initial random pattern relaxed so its points are evenly spread
+		rng := rand.New(rand.NewSource(1))
+		initial := n / 10
+		for placed := 0; placed < initial; {
+			i := rng.Intn(n)
+			if !pattern[i] {
+				update(pattern, energy, i, true)
+				placed++
+			}
+		}
+		for iter := 0; iter < n; iter++ {
+			cluster := extreme(pattern, energy, true, true)
+			update(pattern, energy, cluster, false)
+			void := extreme(pattern, energy, false, false)
+			if void == cluster {
+				update(pattern, energy, cluster, true)
+				break
+			}
+			update(pattern, energy, void, true)
+		}
+
+		rank := make([]int, n)
+		work := append([]bool(nil), pattern...)
+		workEnergy := append([]float64(nil), energy...)
+		for r := initial - 1; r >= 0; r-- {
+			cluster := extreme(work, workEnergy, true, true)
+			update(work, workEnergy, cluster, false)
+			rank[cluster] = r
+		}
+		for r := initial; r < n; r++ {
+			void := extreme(pattern, energy, false, false)
+			update(pattern, energy, void, true)
+			rank[void] = r
+		}
+
+		blueNoise = make([]float64, n)
+		for i, r := range rank {
+			blueNoise[i] = (float64(r)+0.5)/n - 0.5
+		}
+	})
+	return blueNoise
+}
@@ -126,7 +126,7 @@ func (dc *Context) drawImageAt(dst *image.RGBA, src image.Image, x, y int) {
 		for dx := 0; dx < bounds.Dx(); dx++ {
 			px := x + dx
 			py := y + dy
-			if px >= 0 && px < dst.Bounds().Dx() && py >= 0 && py < dst.Bounds().Dy() {
+			if image.Pt(px, py).In(dst.Bounds()) {
 				srcColor := src.At(bounds.Min.X+dx, bounds.Min.Y+dy)
 				dst.Set(px, py, srcColor)
 			}
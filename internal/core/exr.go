@@ -0,0 +1,549 @@
+package core
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+)
+
+// OpenEXR scanline images
+//
+// Single-part scanline files with uncompressed, ZIP and PIZ blocks are
+// supported. EXR samples are linear light with premultiplied alpha, which
+// is how they are stored in *RGBAF32.
+
+// EXRCompression selects how EncodeEXR compresses pixel data
+type EXRCompression int
+
+const (
+	// EXRNoCompression stores raw scanlines
+	EXRNoCompression EXRCompression = 0
+	// EXRZIPCompression deflates blocks of 16 scanlines
+	EXRZIPCompression EXRCompression = 3
+	// EXRPIZCompression uses the wavelet and Huffman coder of OpenEXR,
+	// which suits grainy photographic images
+	EXRPIZCompression EXRCompression = 4
+
+	// exrZIPS deflates single scanlines; it is only read
+	exrZIPS EXRCompression = 2
+)
+
+// EXROptions configures EncodeEXR
+type EXROptions struct {
+	Compression EXRCompression
+	// Float stores 32-bit floats instead of 16-bit half floats
+	Float bool
+}
+
+// EXR channel pixel types
+const (
+	exrUint  = 0
+	exrHalf  = 1
+	exrFloat = 2
+)
+
+type exrChannel struct {
+	name      string
+	pixelType int32
+}
+
+// size returns the number of bytes per sample
+func (c exrChannel) size() int {
+	if c.pixelType == exrHalf {
+		return 2
+	}
+	return 4
+}
+
+// exrLinesPerBlock returns how many scanlines a compression packs together
+func exrLinesPerBlock(c EXRCompression) int {
+	switch c {
+	case EXRZIPCompression:
+		return 16
+	case EXRPIZCompression:
+		return 32
+	}
+	return 1
+}
+
+func exrFormatError(details string) *AdvanceError {
+	return NewInvalidFormatError("OpenEXR", []string{"exr"}).WithContext("details", details)
+}
+
+// LoadEXR loads an OpenEXR image
+func LoadEXR(path string) (*RGBAF32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := DecodeEXR(file)
+	if err != nil {
+		if ae, ok := err.(*AdvanceError); ok {
+			ae.WithContext("filepath", path)
+		}
+		return nil, err
+	}
+	return img, nil
+}
+
+// SaveEXR writes img as an OpenEXR file. A nil opts writes half floats
+// with ZIP compression.
+func SaveEXR(path string, img image.Image, opts *EXROptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return EncodeEXR(file, img, opts)
+}
+
+// exrReader walks the little-endian header of an EXR file
+type exrReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *exrReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data)-r.pos {
+		r.err = exrFormatError("unexpected end of file")
+		// Zeros keep the fixed-size readers in bounds; longer reads are
+		// never used once err is set
+		if n > 8 {
+			return nil
+		}
+		return make([]byte, max(n, 0))
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *exrReader) uint32() uint32 { return binary.LittleEndian.Uint32(r.bytes(4)) }
+
+func (r *exrReader) int32() int32 { return int32(r.uint32()) }
+
+func (r *exrReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.data[r.pos:], 0)
+	if end < 0 || end > 255 {
+		r.err = exrFormatError("unterminated name")
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+end])
+	r.pos += end + 1
+	return s
+}
+
+// DecodeEXR reads a single-part scanline OpenEXR image. R, G, B and A
+// channels are used, or Y for luminance images; other channels are
+// ignored. The data window is moved to the origin.
+func DecodeEXR(r io.Reader) (*RGBAF32, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	rd := &exrReader{data: data}
+	if magic := rd.uint32(); magic != 20000630 {
+		return nil, exrFormatError("missing magic number")
+	}
+	version := rd.uint32()
+	if version&0xff != 2 {
+		return nil, exrFormatError(fmt.Sprintf("unknown version %d", version&0xff))
+	}
+	if version&0x1a00 != 0 {
+		return nil, NewUnsupportedOperationError("DecodeEXR", "tiled, deep and multi-part files are not supported")
+	}
+
+	var channels []exrChannel
+	var window [4]int32
+	compression := EXRCompression(-1)
+	haveWindow := false
+	for {
+		name := rd.cstring()
+		if rd.err != nil {
+			return nil, rd.err
+		}
+		if name == "" {
+			break
+		}
+		rd.cstring() // attribute type
+		size := rd.int32()
+		value := &exrReader{data: rd.bytes(int(size))}
+		if rd.err != nil {
+			return nil, rd.err
+		}
+		switch name {
+		case "channels":
+			for {
+				ch := value.cstring()
+				if ch == "" || value.err != nil {
+					break
+				}
+				pixelType := value.int32()
+				value.bytes(4) // pLinear and reserved
+				xs, ys := value.int32(), value.int32()
+				if xs != 1 || ys != 1 {
+					return nil, NewUnsupportedOperationError("DecodeEXR", "subsampled channels are not supported")
+				}
+				if pixelType < exrUint || pixelType > exrFloat {
+					return nil, exrFormatError("unknown pixel type")
+				}
+				channels = append(channels, exrChannel{ch, pixelType})
+			}
+		case "compression":
+			compression = EXRCompression(value.bytes(1)[0])
+		case "dataWindow":
+			for i := range window {
+				window[i] = value.int32()
+			}
+			haveWindow = true
+		}
+		if value.err != nil {
+			return nil, exrFormatError("malformed " + name + " attribute")
+		}
+	}
+
+	switch compression {
+	case EXRNoCompression, exrZIPS, EXRZIPCompression, EXRPIZCompression:
+	default:
+		return nil, NewUnsupportedOperationError("DecodeEXR", fmt.Sprintf("compression method %d is not supported", compression))
+	}
+	if len(channels) == 0 || !haveWindow {
+		return nil, exrFormatError("missing channels or dataWindow")
+	}
+	width := int64(window[2]) - int64(window[0]) + 1
+	height := int64(window[3]) - int64(window[1]) + 1
+	if width <= 0 || height <= 0 || width > math.MaxInt32 || height > math.MaxInt32 {
+		return nil, exrFormatError("invalid data window")
+	}
+	w, h := int(width), int(height)
+	if err := checkDecodeSize("DecodeEXR", w, h, 0); err != nil {
+		return nil, err
+	}
+
+	// Map the channels we understand to RGBA components
+	slot := make([]int, len(channels))
+	found := false
+	for i, ch := range channels {
+		slot[i] = -1
+		switch ch.name {
+		case "R":
+			slot[i] = 0
+		case "G":
+			slot[i] = 1
+		case "B":
+			slot[i] = 2
+		case "A":
+			slot[i] = 3
+		case "Y":
+			slot[i] = 4
+		}
+		found = found || (slot[i] >= 0 && slot[i] != 3)
+	}
+	if !found {
+		return nil, NewUnsupportedOperationError("DecodeEXR", "no R, G, B or Y channels")
+	}
+
+	img := NewRGBAF32(image.Rect(0, 0, w, h))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 1
+	}
+
+	lineSize := 0
+	for _, ch := range channels {
+		lineSize += ch.size() * w
+	}
+	linesPerBlock := exrLinesPerBlock(compression)
+	blocks := (h + linesPerBlock - 1) / linesPerBlock
+	offsets := make([]uint64, blocks)
+	for i := range offsets {
+		offsets[i] = binary.LittleEndian.Uint64(rd.bytes(8))
+	}
+	if rd.err != nil {
+		return nil, rd.err
+	}
+
+	for _, offset := range offsets {
+		if offset > uint64(len(data)) {
+			return nil, exrFormatError("block offset out of range")
+		}
+		chunk := &exrReader{data: data, pos: int(offset)}
+		y0 := int(int64(chunk.int32()) - int64(window[1]))
+		size := int(chunk.int32())
+		packed := chunk.bytes(size)
+		if chunk.err != nil {
+			return nil, chunk.err
+		}
+		if y0 < 0 || y0 >= h || y0%linesPerBlock != 0 {
+			return nil, exrFormatError("block position out of range")
+		}
+		lines := min(linesPerBlock, h-y0)
+		raw := packed
+		if size < lines*lineSize {
+			switch compression {
+			case exrZIPS, EXRZIPCompression:
+				raw, err = exrZIPDecode(packed, lines*lineSize)
+			case EXRPIZCompression:
+				raw, err = exrPIZDecode(packed, channels, w, lines)
+			default:
+				err = exrFormatError("short uncompressed block")
+			}
+			if err != nil {
+				return nil, err
+			}
+		} else if size != lines*lineSize {
+			return nil, exrFormatError("block size mismatch")
+		}
+
+		pos := 0
+		for y := y0; y < y0+lines; y++ {
+			pix := img.Pix[img.PixOffset(0, y):]
+			for i, ch := range channels {
+				for x := 0; x < w; x++ {
+					var v float32
+					switch ch.pixelType {
+					case exrHalf:
+						v = halfToFloat32(binary.LittleEndian.Uint16(raw[pos:]))
+					case exrFloat:
+						v = math.Float32frombits(binary.LittleEndian.Uint32(raw[pos:]))
+					default:
+						v = float32(binary.LittleEndian.Uint32(raw[pos:]))
+					}
+					pos += ch.size()
+					switch s := slot[i]; s {
+					case -1:
+					case 4:
+						pix[x*4], pix[x*4+1], pix[x*4+2] = v, v, v
+					default:
+						pix[x*4+s] = v
+					}
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+// EncodeEXR writes img as a single-part scanline OpenEXR image. *RGBAF32
+// images are written as linear light; other images are decoded from sRGB
+// first. An alpha channel is only written when some pixel is not opaque.
+// A nil opts writes half floats with ZIP compression.
+func EncodeEXR(w io.Writer, img image.Image, opts *EXROptions) error {
+	if opts == nil {
+		opts = &EXROptions{Compression: EXRZIPCompression}
+	}
+	switch opts.Compression {
+	case EXRNoCompression, EXRZIPCompression, EXRPIZCompression:
+	default:
+		return NewInvalidParameterError("Compression", opts.Compression, "EXRNoCompression, EXRZIPCompression or EXRPIZCompression")
+	}
+	src := linearFloatImage(img)
+	b := src.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	pixelType := int32(exrHalf)
+	if opts.Float {
+		pixelType = exrFloat
+	}
+	// Channels are stored in alphabetical order
+	names := []string{"B", "G", "R"}
+	for i := 3; i < len(src.Pix); i += 4 {
+		if src.Pix[i] != 1 {
+			names = []string{"A", "B", "G", "R"}
+			break
+		}
+	}
+	channels := make([]exrChannel, len(names))
+	components := make([]int, len(names))
+	for i, name := range names {
+		channels[i] = exrChannel{name, pixelType}
+		components[i] = map[string]int{"R": 0, "G": 1, "B": 2, "A": 3}[name]
+	}
+
+	le := binary.LittleEndian
+	var header bytes.Buffer
+	header.Write(le.AppendUint32(nil, 20000630))
+	header.Write(le.AppendUint32(nil, 2))
+	attribute := func(name, kind string, value []byte) {
+		header.WriteString(name + "\x00" + kind + "\x00")
+		header.Write(le.AppendUint32(nil, uint32(len(value))))
+		header.Write(value)
+	}
+	var chlist []byte
+	for _, ch := range channels {
+		chlist = append(chlist, ch.name...)
+		chlist = append(chlist, 0)
+		chlist = le.AppendUint32(chlist, uint32(ch.pixelType))
+		chlist = append(chlist, 0, 0, 0, 0) // pLinear and reserved
+		chlist = le.AppendUint32(chlist, 1)
+		chlist = le.AppendUint32(chlist, 1)
+	}
+	chlist = append(chlist, 0)
+	window := le.AppendUint32(le.AppendUint32(le.AppendUint32(le.AppendUint32(nil, 0), 0), uint32(width-1)), uint32(height-1))
+	float1 := le.AppendUint32(nil, math.Float32bits(1))
+	attribute("channels", "chlist", chlist)
+	attribute("compression", "compression", []byte{byte(opts.Compression)})
+	attribute("dataWindow", "box2i", window)
+	attribute("displayWindow", "box2i", window)
+	attribute("lineOrder", "lineOrder", []byte{0})
+	attribute("pixelAspectRatio", "float", float1)
+	attribute("screenWindowCenter", "v2f", make([]byte, 8))
+	attribute("screenWindowWidth", "float", float1)
+	header.WriteByte(0)
+
+	linesPerBlock := exrLinesPerBlock(opts.Compression)
+	blocks := (height + linesPerBlock - 1) / linesPerBlock
+	offset := uint64(header.Len() + blocks*8)
+	table := make([]byte, 0, blocks*8)
+	var body bytes.Buffer
+	for y0 := 0; y0 < height; y0 += linesPerBlock {
+		lines := min(linesPerBlock, height-y0)
+		var raw []byte
+		for y := y0; y < y0+lines; y++ {
+			pix := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for i, ch := range channels {
+				for x := 0; x < width; x++ {
+					v := pix[x*4+components[i]]
+					if ch.pixelType == exrHalf {
+						raw = le.AppendUint16(raw, float32ToHalf(v))
+					} else {
+						raw = le.AppendUint32(raw, math.Float32bits(v))
+					}
+				}
+			}
+		}
+		packed := raw
+		switch opts.Compression {
+		case EXRZIPCompression:
+			packed = exrZIPEncode(raw)
+		case EXRPIZCompression:
+			packed = exrPIZEncode(raw, channels, width, lines)
+		}
+		if len(packed) >= len(raw) {
+			packed = raw
+		}
+		table = le.AppendUint64(table, offset)
+		chunk := le.AppendUint32(le.AppendUint32(nil, uint32(y0)), uint32(len(packed)))
+		body.Write(chunk)
+		body.Write(packed)
+		offset += uint64(len(chunk) + len(packed))
+	}
+
+	for _, part := range [][]byte{header.Bytes(), table, body.Bytes()} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exrZIPEncode splits raw into even and odd bytes, delta encodes the
+// result and deflates it
+func exrZIPEncode(raw []byte) []byte {
+	n := len(raw)
+	tmp := make([]byte, n)
+	half := (n + 1) / 2
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			tmp[i/2] = raw[i]
+		} else {
+			tmp[half+i/2] = raw[i]
+		}
+	}
+	for i := n - 1; i > 0; i-- {
+		tmp[i] = tmp[i] - tmp[i-1] + 128
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(tmp)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// exrZIPDecode reverses exrZIPEncode for a block of size bytes
+func exrZIPDecode(packed []byte, size int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(packed))
+	if err != nil {
+		return nil, exrFormatError("corrupt ZIP block")
+	}
+	tmp := make([]byte, size)
+	if _, err := io.ReadFull(zr, tmp); err != nil {
+		return nil, exrFormatError("corrupt ZIP block")
+	}
+	for i := 1; i < size; i++ {
+		tmp[i] = tmp[i-1] + tmp[i] - 128
+	}
+	raw := make([]byte, size)
+	half := (size + 1) / 2
+	for i := 0; i < size; i++ {
+		if i%2 == 0 {
+			raw[i] = tmp[i/2]
+		} else {
+			raw[i] = tmp[half+i/2]
+		}
+	}
+	return raw, nil
+}
+
+// halfToFloat32 widens an IEEE 754 half precision value
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch exp {
+	case 0:
+		// Zero or subnormal
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 31:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | mant<<13)
+}
+
+// float32ToHalf narrows a float to half precision, rounding to nearest even
+func float32ToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	if bits&0x7fffffff > 0x7f800000 {
+		return sign | 0x7e00 // NaN
+	}
+	exp := int32(bits>>23&0xff) - 127 + 15
+	mant := bits & 0x7fffff
+	if exp >= 31 {
+		return sign | 0x7c00
+	}
+	if exp <= 0 {
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint32(14 - exp)
+		h := mant >> shift
+		rem, halfway := mant&(1<<shift-1), uint32(1)<<(shift-1)
+		if rem > halfway || (rem == halfway && h&1 == 1) {
+			h++
+		}
+		return sign | uint16(h)
+	}
+	h := uint32(exp)<<10 | mant>>13
+	if rem := mant & 0x1fff; rem > 0x1000 || (rem == 0x1000 && h&1 == 1) {
+		// A carry into the exponent is still the correctly rounded value
+		h++
+	}
+	return sign | uint16(h)
+}
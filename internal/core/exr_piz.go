@@ -0,0 +1,701 @@
+package core
+
+import (
+	"container/heap"
+	"encoding/binary"
+)
+
+// PIZ compression for OpenEXR: the 16-bit words of a block are remapped
+// to a dense range, wavelet transformed per channel and Huffman coded.
+// The bit layouts follow the OpenEXR reference implementation.
+
+const (
+	pizBitmapSize = 1 << 16 >> 3
+
+	hufEncBits = 16
+	hufDecBits = 14
+	hufEncSize = 1<<hufEncBits + 1
+	hufDecSize = 1 << hufDecBits
+	hufDecMask = hufDecSize - 1
+
+	hufShortZeroRun    = 59
+	hufLongZeroRun     = 63
+	hufShortestLongRun = 2 + hufLongZeroRun - hufShortZeroRun
+	hufLongestLongRun  = 255 + hufShortestLongRun
+)
+
+// pizChannel locates one channel's words inside the block buffer
+type pizChannel struct {
+	start, nx, ny, size int
+}
+
+// pizLayout splits a block of words into per-channel planes
+func pizLayout(channels []exrChannel, width, lines int) ([]pizChannel, int) {
+	layout := make([]pizChannel, len(channels))
+	n := 0
+	for i, ch := range channels {
+		size := ch.size() / 2
+		layout[i] = pizChannel{start: n, nx: width, ny: lines, size: size}
+		n += width * lines * size
+	}
+	return layout, n
+}
+
+// exrPIZEncode compresses one block of raw scanline data
+func exrPIZEncode(raw []byte, channels []exrChannel, width, lines int) []byte {
+	layout, n := pizLayout(channels, width, lines)
+	words := make([]uint16, n)
+	ends := make([]int, len(layout))
+	for i, c := range layout {
+		ends[i] = c.start
+	}
+	pos := 0
+	for y := 0; y < lines; y++ {
+		for i, c := range layout {
+			for k := 0; k < c.nx*c.size; k++ {
+				words[ends[i]] = binary.LittleEndian.Uint16(raw[pos:])
+				ends[i]++
+				pos += 2
+			}
+		}
+	}
+
+	// Zero is never stored in the bitmap; the data is assumed to hold it
+	var bitmap [pizBitmapSize]byte
+	for _, v := range words {
+		bitmap[v>>3] |= 1 << (v & 7)
+	}
+	bitmap[0] &^= 1
+	minNonZero, maxNonZero := pizBitmapSize-1, 0
+	for i, b := range bitmap {
+		if b != 0 {
+			minNonZero = min(minNonZero, i)
+			maxNonZero = max(maxNonZero, i)
+		}
+	}
+
+	var lut [1 << 16]uint16
+	k := 0
+	for i := range lut {
+		if i == 0 || bitmap[i>>3]&(1<<(i&7)) != 0 {
+			lut[i] = uint16(k)
+			k++
+		}
+	}
+	maxValue := uint16(k - 1)
+	for i, v := range words {
+		words[i] = lut[v]
+	}
+
+	out := binary.LittleEndian.AppendUint16(nil, uint16(minNonZero))
+	out = binary.LittleEndian.AppendUint16(out, uint16(maxNonZero))
+	if minNonZero <= maxNonZero {
+		out = append(out, bitmap[minNonZero:maxNonZero+1]...)
+	}
+
+	for _, c := range layout {
+		for j := 0; j < c.size; j++ {
+			wav2Encode(words[c.start+j:], c.nx, c.size, c.ny, c.nx*c.size, maxValue)
+		}
+	}
+
+	huf := hufCompress(words)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(huf)))
+	return append(out, huf...)
+}
+
+// exrPIZDecode reverses exrPIZEncode
+func exrPIZDecode(packed []byte, channels []exrChannel, width, lines int) ([]byte, error) {
+	layout, n := pizLayout(channels, width, lines)
+	if len(packed) < 4 {
+		return nil, exrFormatError("truncated PIZ block")
+	}
+	minNonZero := int(binary.LittleEndian.Uint16(packed))
+	maxNonZero := int(binary.LittleEndian.Uint16(packed[2:]))
+	pos := 4
+	if maxNonZero >= pizBitmapSize {
+		return nil, exrFormatError("corrupt PIZ bitmap")
+	}
+	var bitmap [pizBitmapSize]byte
+	if minNonZero <= maxNonZero {
+		if len(packed) < pos+maxNonZero-minNonZero+1 {
+			return nil, exrFormatError("truncated PIZ block")
+		}
+		pos += copy(bitmap[minNonZero:maxNonZero+1], packed[pos:])
+	}
+
+	var lut [1 << 16]uint16
+	k := 0
+	for i := 0; i < len(lut); i++ {
+		if i == 0 || bitmap[i>>3]&(1<<(i&7)) != 0 {
+			lut[k] = uint16(i)
+			k++
+		}
+	}
+	maxValue := uint16(k - 1)
+
+	if len(packed) < pos+4 {
+		return nil, exrFormatError("truncated PIZ block")
+	}
+	length := int(binary.LittleEndian.Uint32(packed[pos:]))
+	pos += 4
+	if length < 0 || length > len(packed)-pos {
+		return nil, exrFormatError("truncated PIZ block")
+	}
+	words, err := hufUncompress(packed[pos:pos+length], n)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range layout {
+		for j := 0; j < c.size; j++ {
+			wav2Decode(words[c.start+j:], c.nx, c.size, c.ny, c.nx*c.size, maxValue)
+		}
+	}
+	for i, v := range words {
+		words[i] = lut[v]
+	}
+
+	raw := make([]byte, 0, n*2)
+	ends := make([]int, len(layout))
+	for i, c := range layout {
+		ends[i] = c.start
+	}
+	for y := 0; y < lines; y++ {
+		for i, c := range layout {
+			for k := 0; k < c.nx*c.size; k++ {
+				raw = binary.LittleEndian.AppendUint16(raw, words[ends[i]])
+				ends[i]++
+			}
+		}
+	}
+	return raw, nil
+}
+
+// Wavelet transform
+
+// wenc14 and wdec14 are the lossless Haar step for values below 1<<14
+func wenc14(a, b uint16) (l, h uint16) {
+	as, bs := int(int16(a)), int(int16(b))
+	return uint16((as + bs) >> 1), uint16(as - bs)
+}
+
+func wdec14(l, h uint16) (a, b uint16) {
+	ls, hs := int(int16(l)), int(int16(h))
+	ai := ls + (hs & 1) + (hs >> 1)
+	return uint16(ai), uint16(ai - hs)
+}
+
+// wenc16 and wdec16 are the modular Haar step for the full 16-bit range
+func wenc16(a, b uint16) (l, h uint16) {
+	const offset, mask = 1 << 15, 1<<16 - 1
+	ao := (int(a) + offset) & mask
+	m := (ao + int(b)) >> 1
+	d := ao - int(b)
+	if d < 0 {
+		m = (m + offset) & mask
+	}
+	return uint16(m), uint16(d & mask)
+}
+
+func wdec16(l, h uint16) (a, b uint16) {
+	const offset, mask = 1 << 15, 1<<16 - 1
+	m, d := int(l), int(h)
+	bb := (m - (d >> 1)) & mask
+	aa := (d + bb - offset) & mask
+	return uint16(aa), uint16(bb)
+}
+
+// wav2Encode applies the 2D wavelet transform in place. Samples are ox
+// words apart along a row and rows are oy words apart.
+func wav2Encode(in []uint16, nx, ox, ny, oy int, mx uint16) {
+	enc := wenc16
+	if mx < 1<<14 {
+		enc = wenc14
+	}
+	n := min(nx, ny)
+	for p, p2 := 1, 2; p2 <= n; p, p2 = p2, p2<<1 {
+		oy1, oy2, ox1, ox2 := oy*p, oy*p2, ox*p, ox*p2
+		ey := oy * (ny - p2)
+		py := 0
+		for ; py <= ey; py += oy2 {
+			px := py
+			ex := py + ox*(nx-p2)
+			for ; px <= ex; px += ox2 {
+				p01, p10 := px+ox1, px+oy1
+				p11 := p10 + ox1
+				i00, i01 := enc(in[px], in[p01])
+				i10, i11 := enc(in[p10], in[p11])
+				in[px], in[p10] = enc(i00, i10)
+				in[p01], in[p11] = enc(i01, i11)
+			}
+			// Odd column
+			if nx&p != 0 {
+				p10 := px + oy1
+				in[px], in[p10] = enc(in[px], in[p10])
+			}
+		}
+		// Odd line
+		if ny&p != 0 {
+			px := py
+			ex := py + ox*(nx-p2)
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				in[px], in[p01] = enc(in[px], in[p01])
+			}
+		}
+	}
+}
+
+// wav2Decode inverts wav2Encode
+func wav2Decode(in []uint16, nx, ox, ny, oy int, mx uint16) {
+	dec := wdec16
+	if mx < 1<<14 {
+		dec = wdec14
+	}
+	n := min(nx, ny)
+	p := 1
+	for p <= n {
+		p <<= 1
+	}
+	p >>= 1
+	p2 := p
+	p >>= 1
+	for ; p >= 1; p2, p = p, p>>1 {
+		oy1, oy2, ox1, ox2 := oy*p, oy*p2, ox*p, ox*p2
+		ey := oy * (ny - p2)
+		py := 0
+		for ; py <= ey; py += oy2 {
+			px := py
+			ex := py + ox*(nx-p2)
+			for ; px <= ex; px += ox2 {
+				p01, p10 := px+ox1, px+oy1
+				p11 := p10 + ox1
+				i00, i10 := dec(in[px], in[p10])
+				i01, i11 := dec(in[p01], in[p11])
+				in[px], in[p01] = dec(i00, i01)
+				in[p10], in[p11] = dec(i10, i11)
+			}
+			// Odd column
+			if nx&p != 0 {
+				p10 := px + oy1
+				in[px], in[p10] = dec(in[px], in[p10])
+			}
+		}
+		// Odd line
+		if ny&p != 0 {
+			px := py
+			ex := py + ox*(nx-p2)
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				in[px], in[p01] = dec(in[px], in[p01])
+			}
+		}
+	}
+}
+
+// Huffman coding
+//
+// Code tables hold (code << 6 | length) per symbol. Symbol hufEncSize-1 or
+// below marks a run: it is followed by an 8-bit repeat count of the
+// previous symbol.
+
+type hufBitWriter struct {
+	out []byte
+	c   uint64
+	lc  int
+}
+
+func (w *hufBitWriter) bits(n int, v uint64) {
+	w.c = w.c<<n | v
+	w.lc += n
+	for w.lc >= 8 {
+		w.lc -= 8
+		w.out = append(w.out, byte(w.c>>w.lc))
+	}
+}
+
+func (w *hufBitWriter) code(code uint64) {
+	w.bits(int(code&63), code>>6)
+}
+
+// flush pads the last partial byte with zero bits
+func (w *hufBitWriter) flush() {
+	if w.lc > 0 {
+		w.out = append(w.out, byte(w.c<<(8-w.lc)))
+	}
+}
+
+type hufBitReader struct {
+	in []byte
+	c  uint64
+	lc int
+}
+
+func (r *hufBitReader) fill() bool {
+	if len(r.in) == 0 {
+		return false
+	}
+	r.c = r.c<<8 | uint64(r.in[0])
+	r.in = r.in[1:]
+	r.lc += 8
+	return true
+}
+
+func (r *hufBitReader) bits(n int) (uint64, bool) {
+	for r.lc < n {
+		if !r.fill() {
+			return 0, false
+		}
+	}
+	r.lc -= n
+	return r.c >> r.lc & (1<<n - 1), true
+}
+
+// hufCanonicalCodeTable turns the code lengths in hcode into canonical
+// codes. Shorter codes get numerically higher values, and codes of one
+// length increase with the symbol value.
+func hufCanonicalCodeTable(hcode []uint64) {
+	var n [59]uint64
+	for _, l := range hcode {
+		n[l]++
+	}
+	c := uint64(0)
+	for i := 58; i > 0; i-- {
+		nc := (c + n[i]) >> 1
+		n[i] = c
+		c = nc
+	}
+	for i, l := range hcode {
+		if l > 0 {
+			hcode[i] = l | n[l]<<6
+			n[l]++
+		}
+	}
+}
+
+// hufHeap orders symbol indices by frequency
+type hufHeap struct {
+	idx []int
+	frq []uint64
+}
+
+func (h *hufHeap) Len() int           { return len(h.idx) }
+func (h *hufHeap) Less(i, j int) bool { return h.frq[h.idx[i]] < h.frq[h.idx[j]] }
+func (h *hufHeap) Swap(i, j int)      { h.idx[i], h.idx[j] = h.idx[j], h.idx[i] }
+func (h *hufHeap) Push(x any)         { h.idx = append(h.idx, x.(int)) }
+func (h *hufHeap) Pop() any {
+	x := h.idx[len(h.idx)-1]
+	h.idx = h.idx[:len(h.idx)-1]
+	return x
+}
+
+// hufBuildEncTable replaces the frequencies in frq with a code table and
+// returns the range of symbols it covers. The last symbol is the run
+// marker.
+func hufBuildEncTable(frq []uint64) (im, iM int) {
+	for frq[im] == 0 {
+		im++
+	}
+	hlink := make([]int, hufEncSize)
+	h := &hufHeap{frq: frq}
+	for i := im; i < hufEncSize; i++ {
+		hlink[i] = i
+		if frq[i] != 0 {
+			h.idx = append(h.idx, i)
+			iM = i
+		}
+	}
+	iM++
+	frq[iM] = 1
+	h.idx = append(h.idx, iM)
+	heap.Init(h)
+
+	// Merge the two rarest nodes until one is left. Each node's leaves are
+	// kept in a list linked through hlink, and every merge makes all their
+	// codes one bit longer.
+	scode := make([]uint64, hufEncSize)
+	for h.Len() > 1 {
+		mm := heap.Pop(h).(int)
+		m := heap.Pop(h).(int)
+		frq[m] += frq[mm]
+		heap.Push(h, m)
+		for j := m; ; j = hlink[j] {
+			scode[j]++
+			if hlink[j] == j {
+				hlink[j] = mm
+				break
+			}
+		}
+		for j := mm; ; j = hlink[j] {
+			scode[j]++
+			if hlink[j] == j {
+				break
+			}
+		}
+	}
+	hufCanonicalCodeTable(scode)
+	copy(frq, scode)
+	return im, iM
+}
+
+// hufPackEncTable writes the code lengths of symbols im to iM, with runs
+// of unused symbols collapsed
+func hufPackEncTable(hcode []uint64, im, iM int) []byte {
+	w := &hufBitWriter{}
+	for ; im <= iM; im++ {
+		l := hcode[im] & 63
+		if l == 0 {
+			run := 1
+			for im < iM && run < hufLongestLongRun && hcode[im+1]&63 == 0 {
+				im++
+				run++
+			}
+			if run >= 2 {
+				if run >= hufShortestLongRun {
+					w.bits(6, hufLongZeroRun)
+					w.bits(8, uint64(run-hufShortestLongRun))
+				} else {
+					w.bits(6, uint64(hufShortZeroRun+run-2))
+				}
+				continue
+			}
+		}
+		w.bits(6, l)
+	}
+	w.flush()
+	return w.out
+}
+
+// hufUnpackEncTable reads the table written by hufPackEncTable and returns
+// the canonical codes and the number of bytes used
+func hufUnpackEncTable(data []byte, im, iM int) ([]uint64, int, error) {
+	hcode := make([]uint64, hufEncSize)
+	r := &hufBitReader{in: data}
+	for ; im <= iM; im++ {
+		l, ok := r.bits(6)
+		if !ok {
+			return nil, 0, exrFormatError("truncated Huffman table")
+		}
+		hcode[im] = l
+		run := 0
+		if l == hufLongZeroRun {
+			extra, ok := r.bits(8)
+			if !ok {
+				return nil, 0, exrFormatError("truncated Huffman table")
+			}
+			run = int(extra) + hufShortestLongRun
+		} else if l >= hufShortZeroRun {
+			run = int(l) - hufShortZeroRun + 2
+		}
+		if run > 0 {
+			if im+run > iM+1 {
+				return nil, 0, exrFormatError("Huffman table too long")
+			}
+			for ; run > 0; run-- {
+				hcode[im] = 0
+				im++
+			}
+			im--
+		}
+	}
+	hufCanonicalCodeTable(hcode)
+	return hcode, len(data) - len(r.in), nil
+}
+
+// hufEncode writes the codes for in, collapsing repeats into runs when
+// that is shorter
+func hufEncode(hcode []uint64, in []uint16, rlc int) ([]byte, int) {
+	w := &hufBitWriter{}
+	send := func(s uint16, repeats int) {
+		code := hcode[s]
+		if code&63+hcode[rlc]&63+8 < code&63*uint64(repeats) {
+			w.code(code)
+			w.code(hcode[rlc])
+			w.bits(8, uint64(repeats))
+			return
+		}
+		for ; repeats >= 0; repeats-- {
+			w.code(code)
+		}
+	}
+	s, repeats := in[0], 0
+	for _, v := range in[1:] {
+		if v == s && repeats < 255 {
+			repeats++
+		} else {
+			send(s, repeats)
+			repeats = 0
+		}
+		s = v
+	}
+	send(s, repeats)
+	nBits := len(w.out)*8 + w.lc
+	w.flush()
+	return w.out, nBits
+}
+
+// hufDec is a decoding table entry: a short code of up to hufDecBits, or
+// the candidate symbols of the longer codes sharing this prefix
+type hufDec struct {
+	len  int
+	lit  int
+	long []int
+}
+
+func hufBuildDecTable(hcode []uint64, im, iM int) ([]hufDec, error) {
+	table := make([]hufDec, hufDecSize)
+	for ; im <= iM; im++ {
+		c, l := hcode[im]>>6, int(hcode[im]&63)
+		if c>>l != 0 {
+			return nil, exrFormatError("invalid Huffman table")
+		}
+		if l > hufDecBits {
+			pl := &table[c>>(l-hufDecBits)]
+			if pl.len != 0 {
+				return nil, exrFormatError("invalid Huffman table")
+			}
+			pl.long = append(pl.long, im)
+		} else if l > 0 {
+			base := int(c << (hufDecBits - l))
+			for i := 0; i < 1<<(hufDecBits-l); i++ {
+				pl := &table[base+i]
+				if pl.len != 0 || pl.long != nil {
+					return nil, exrFormatError("invalid Huffman table")
+				}
+				pl.len, pl.lit = l, im
+			}
+		}
+	}
+	return table, nil
+}
+
+// hufDecode decodes nBits bits of data into exactly n symbols
+func hufDecode(hcode []uint64, table []hufDec, data []byte, nBits, rlc, n int) ([]uint16, error) {
+	out := make([]uint16, 0, n)
+	r := &hufBitReader{in: data[:(nBits+7)/8]}
+	corrupt := exrFormatError("corrupt Huffman data")
+	emit := func(sym int) error {
+		if sym != rlc {
+			if len(out) >= n {
+				return corrupt
+			}
+			out = append(out, uint16(sym))
+			return nil
+		}
+		count, ok := r.bits(8)
+		if !ok || len(out) == 0 || len(out)+int(count) > n {
+			return corrupt
+		}
+		prev := out[len(out)-1]
+		for ; count > 0; count-- {
+			out = append(out, prev)
+		}
+		return nil
+	}
+
+	for r.fill() {
+		for r.lc >= hufDecBits {
+			pl := table[r.c>>(r.lc-hufDecBits)&hufDecMask]
+			if pl.len != 0 {
+				r.lc -= pl.len
+				if err := emit(pl.lit); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			found := false
+			for _, sym := range pl.long {
+				l := int(hcode[sym] & 63)
+				for r.lc < l && r.fill() {
+				}
+				if r.lc >= l && hcode[sym]>>6 == r.c>>(r.lc-l)&(1<<l-1) {
+					r.lc -= l
+					if err := emit(sym); err != nil {
+						return nil, err
+					}
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, corrupt
+			}
+		}
+	}
+
+	// The remaining bits hold short codes, minus the padding
+	pad := (8 - nBits) & 7
+	r.c >>= pad
+	r.lc -= pad
+	for r.lc > 0 {
+		pl := table[r.c<<(hufDecBits-r.lc)&hufDecMask]
+		if pl.len == 0 || pl.len > r.lc {
+			return nil, corrupt
+		}
+		r.lc -= pl.len
+		if err := emit(pl.lit); err != nil {
+			return nil, err
+		}
+	}
+	if len(out) != n {
+		return nil, corrupt
+	}
+	return out, nil
+}
+
+// hufCompress encodes raw with a 20-byte header, the packed code table
+// and the bit stream
+func hufCompress(raw []uint16) []byte {
+	if len(raw) == 0 {
+		return nil
+	}
+	frq := make([]uint64, hufEncSize)
+	for _, v := range raw {
+		frq[v]++
+	}
+	im, iM := hufBuildEncTable(frq)
+	table := hufPackEncTable(frq, im, iM)
+	data, nBits := hufEncode(frq, raw, iM)
+
+	be := binary.BigEndian
+	out := be.AppendUint32(nil, uint32(im))
+	out = be.AppendUint32(out, uint32(iM))
+	out = be.AppendUint32(out, uint32(len(table)))
+	out = be.AppendUint32(out, uint32(nBits))
+	out = be.AppendUint32(out, 0)
+	out = append(out, table...)
+	return append(out, data...)
+}
+
+// hufUncompress decodes n symbols written by hufCompress
+func hufUncompress(data []byte, n int) ([]uint16, error) {
+	if len(data) == 0 {
+		if n != 0 {
+			return nil, exrFormatError("missing Huffman data")
+		}
+		return nil, nil
+	}
+	if len(data) < 20 {
+		return nil, exrFormatError("truncated Huffman data")
+	}
+	be := binary.BigEndian
+	im, iM := int(be.Uint32(data)), int(be.Uint32(data[4:]))
+	nBits := int(be.Uint32(data[12:]))
+	if im < 0 || im >= hufEncSize || iM < im || iM >= hufEncSize {
+		return nil, exrFormatError("invalid Huffman table size")
+	}
+	hcode, used, err := hufUnpackEncTable(data[20:], im, iM)
+	if err != nil {
+		return nil, err
+	}
+	rest := data[20+used:]
+	if nBits < 0 || nBits > 8*len(rest) {
+		return nil, exrFormatError("invalid Huffman bit count")
+	}
+	table, err := hufBuildDecTable(hcode, im, iM)
+	if err != nil {
+		return nil, err
+	}
+	return hufDecode(hcode, table, rest, nBits, iM, n)
+}
@@ -0,0 +1,381 @@
+package core
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+)
+
+// Corner detection, binary descriptors and descriptor matching
+
+// Keypoint is a detected feature in pixel coordinates
+type Keypoint struct {
+	X, Y     float64
+	Response float64
+	// Angle is the dominant orientation in radians, set by DescribeORB
+	Angle float64
+}
+
+// CornerOptions limits the corners a detector returns
+type CornerOptions struct {
+	// MaxCorners caps the result, strongest first; 0 means no limit
+	MaxCorners int
+	// MinDistance is the smallest allowed spacing between corners in pixels
+	MinDistance float64
+	// Threshold is detector specific: for Harris a fraction of the strongest
+	// response (default 0.01), for FAST the brightness difference in 0..1
+	// (default 0.08)
+	Threshold float64
+}
+
+// HarrisCorners finds corners where the image gradient varies strongly in
+// two directions, using k = 0.04 and a Gaussian window with sigma 1
+func HarrisCorners(img image.Image, opts CornerOptions) []Keypoint {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	response := harrisResponse(luminancePlane(img), w, h)
+
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		threshold = 0.01
+	}
+	var peak float64
+	for _, r := range response {
+		peak = math.Max(peak, r)
+	}
+	if peak <= 0 {
+		return nil
+	}
+
+	var candidates []Keypoint
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			r := response[y*w+x]
+			if r > threshold*peak && isLocalMax(response, w, x, y) {
+				candidates = append(candidates, Keypoint{X: float64(x), Y: float64(y), Response: r})
+			}
+		}
+	}
+	return selectCorners(candidates, opts)
+}
+
+// harrisResponse computes det(M) - k*trace(M)^2 of the smoothed structure
+// tensor at every pixel
+func harrisResponse(plane []float64, w, h int) []float64 {
+	n := w * h
+	ixx, iyy, ixy := make([]float64, n), make([]float64, n), make([]float64, n)
+	at := func(x, y int) float64 {
+		return plane[clampInt(y, 0, h-1)*w+clampInt(x, 0, w-1)]
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := (at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)) / 8
+			gy := (at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)) / 8
+			i := y*w + x
+			ixx[i], iyy[i], ixy[i] = gx*gx, gy*gy, gx*gy
+		}
+	}
+	kernel := gaussianKernel(1)
+	sxx, syy, sxy := gaussianPlane(ixx, w, h, kernel), gaussianPlane(iyy, w, h, kernel), gaussianPlane(ixy, w, h, kernel)
+
+	response := make([]float64, n)
+	for i := range response {
+		det := sxx[i]*syy[i] - sxy[i]*sxy[i]
+		tr := sxx[i] + syy[i]
+		response[i] = det - 0.04*tr*tr
+	}
+	return response
+}
+
+// isLocalMax reports whether (x, y) is the maximum of its 3x3 neighborhood
+func isLocalMax(values []float64, w, x, y int) bool {
+	v := values[y*w+x]
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if (dx != 0 || dy != 0) && values[(y+dy)*w+x+dx] > v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fastCircle is the Bresenham circle of radius 3 used by FAST
+var fastCircle = [16][2]int{
+	{0, -3}, {1, -3}, {2, -2}, {3, -1}, {3, 0}, {3, 1}, {2, 2}, {1, 3},
+	{0, 3}, {-1, 3}, {-2, 2}, {-3, 1}, {-3, 0}, {-3, -1}, {-2, -2}, {-1, -3},
+}
+
+// FASTCorners runs the FAST-9 segment test: a pixel is a corner when nine
+// contiguous pixels on the surrounding circle are all brighter or all darker
+// than it by the threshold
+func FASTCorners(img image.Image, opts CornerOptions) []Keypoint {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	return selectCorners(fastCandidates(luminancePlane(img), w, h, opts.Threshold), opts)
+}
+
+// fastCandidates returns non-maximum suppressed FAST corners scored by the
+// summed contrast of the circle pixels that passed the test
+func fastCandidates(plane []float64, w, h int, threshold float64) []Keypoint {
+	if threshold <= 0 {
+		threshold = 0.08
+	}
+	if w < 7 || h < 7 {
+		return nil
+	}
+	scores := make([]float64, w*h)
+	parallelRows(h-6, 0, func(start, end int) {
+		for y := start + 3; y < end+3; y++ {
+			for x := 3; x < w-3; x++ {
+				scores[y*w+x] = fastScore(plane, w, x, y, threshold)
+			}
+		}
+	})
+
+	var candidates []Keypoint
+	for y := 3; y < h-3; y++ {
+		for x := 3; x < w-3; x++ {
+			if s := scores[y*w+x]; s > 0 && isLocalMax(scores, w, x, y) {
+				candidates = append(candidates, Keypoint{X: float64(x), Y: float64(y), Response: s})
+			}
+		}
+	}
+	return candidates
+}
+
+// fastScore returns 0 unless (x, y) passes the segment test
+func fastScore(plane []float64, w, x, y int, threshold float64) float64 {
+	p := plane[y*w+x]
+	var brighter, darker uint32
+	var ring [16]float64
+	for i, o := range fastCircle {
+		v := plane[(y+o[1])*w+x+o[0]]
+		ring[i] = v
+		if v > p+threshold {
+			brighter |= 1 << uint(i)
+		} else if v < p-threshold {
+			darker |= 1 << uint(i)
+		}
+	}
+	mask := brighter
+	if !hasArc(brighter, 9) {
+		if !hasArc(darker, 9) {
+			return 0
+		}
+		mask = darker
+	}
+	var score float64
+	for i, v := range ring {
+		if mask&(1<<uint(i)) != 0 {
+			score += math.Abs(v-p) - threshold
+		}
+	}
+	return score
+}
+
+// hasArc reports whether the 16-bit ring mask contains n contiguous set bits,
+// wrapping around
+func hasArc(mask uint32, n int) bool {
+	doubled := mask | mask<<16
+	run := 0
+	for i := 0; i < 32; i++ {
+		if doubled&(1<<uint(i)) != 0 {
+			run++
+			if run >= n {
+				return true
+			}
+		} else {
+			run = 0
+		}
+	}
+	return false
+}
+
+// selectCorners keeps the strongest candidates that respect MinDistance and
+// MaxCorners
+func selectCorners(candidates []Keypoint, opts CornerOptions) []Keypoint {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Response > candidates[j].Response
+	})
+	if opts.MinDistance <= 0 {
+		if opts.MaxCorners > 0 && len(candidates) > opts.MaxCorners {
+			candidates = candidates[:opts.MaxCorners]
+		}
+		return candidates
+	}
+
+	// Bucket accepted corners on a grid of MinDistance cells so each check
+	// only looks at the neighboring cells
+	cell := opts.MinDistance
+	grid := make(map[[2]int][]Keypoint)
+	minSq := opts.MinDistance * opts.MinDistance
+	var kept []Keypoint
+	for _, c := range candidates {
+		if opts.MaxCorners > 0 && len(kept) >= opts.MaxCorners {
+			break
+		}
+		cx, cy := int(c.X/cell), int(c.Y/cell)
+		ok := true
+		for gy := cy - 1; gy <= cy+1 && ok; gy++ {
+			for gx := cx - 1; gx <= cx+1 && ok; gx++ {
+				for _, k := range grid[[2]int{gx, gy}] {
+					if dx, dy := k.X-c.X, k.Y-c.Y; dx*dx+dy*dy < minSq {
+						ok = false
+						break
+					}
+				}
+			}
+		}
+		if ok {
+			kept = append(kept, c)
+			grid[[2]int{cx, cy}] = append(grid[[2]int{cx, cy}], c)
+		}
+	}
+	return kept
+}
+
+// Descriptor is a 256-bit binary feature descriptor
+type Descriptor [4]uint64
+
+// Distance returns the Hamming distance between two descriptors
+func (d Descriptor) Distance(other Descriptor) int {
+	n := 0
+	for i := range d {
+		n += bits.OnesCount64(d[i] ^ other[i])
+	}
+	return n
+}
+
+// orbPatchRadius is the radius of the patch used for orientation and the
+// descriptor; keypoints closer than this to the border cannot be described
+const orbPatchRadius = 15
+
+// orbPattern holds 256 BRIEF test pairs drawn once from an isotropic
+// Gaussian and kept inside the patch radius so they stay in bounds when
+// rotated
+var orbPattern = func() [256][4]float64 {
+	var pattern [256][4]float64
+	rng := rand.New(rand.NewSource(0x0b1e))
+	sigma := 2 * orbPatchRadius / 5.0
+	for i := range pattern {
+		for j := 0; j < 4; j += 2 {
+			for {
+				x, y := rng.NormFloat64()*sigma, rng.NormFloat64()*sigma
+				if x*x+y*y <= (orbPatchRadius-1)*(orbPatchRadius-1) {
+					pattern[i][j], pattern[i][j+1] = x, y
+					break
+				}
+			}
+		}
+	}
+	return pattern
+}()
+
+// DescribeORB computes rotation-aware BRIEF descriptors (as in ORB) for the
+// keypoints, orienting each by its intensity centroid. Keypoints too close
+// to the border are dropped, so the returned keypoints, with Angle filled
+// in, line up with the descriptors.
+func DescribeORB(img image.Image, keypoints []Keypoint) ([]Keypoint, []Descriptor) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	plane := luminancePlane(img)
+	smooth := gaussianPlane(plane, w, h, gaussianKernel(2))
+
+	var kept []Keypoint
+	var descs []Descriptor
+	for _, kp := range keypoints {
+		cx, cy := int(math.Round(kp.X)), int(math.Round(kp.Y))
+		if cx < orbPatchRadius+1 || cy < orbPatchRadius+1 || cx >= w-orbPatchRadius-1 || cy >= h-orbPatchRadius-1 {
+			continue
+		}
+
+		var m10, m01 float64
+		for dy := -orbPatchRadius; dy <= orbPatchRadius; dy++ {
+			for dx := -orbPatchRadius; dx <= orbPatchRadius; dx++ {
+				if dx*dx+dy*dy > orbPatchRadius*orbPatchRadius {
+					continue
+				}
+				v := plane[(cy+dy)*w+cx+dx]
+				m10 += float64(dx) * v
+				m01 += float64(dy) * v
+			}
+		}
+		kp.Angle = math.Atan2(m01, m10)
+		sin, cos := math.Sincos(kp.Angle)
+
+		sample := func(px, py float64) float64 {
+			x := cx + int(math.Round(px*cos-py*sin))
+			y := cy + int(math.Round(px*sin+py*cos))
+			return smooth[y*w+x]
+		}
+		var d Descriptor
+		for i, p := range orbPattern {
+			if sample(p[0], p[1]) < sample(p[2], p[3]) {
+				d[i/64] |= 1 << uint(i%64)
+			}
+		}
+		kept = append(kept, kp)
+		descs = append(descs, d)
+	}
+	return kept, descs
+}
+
+// DetectORB finds up to maxFeatures FAST corners ranked by Harris response
+// and describes them with DescribeORB. Detection runs at a single scale.
+func DetectORB(img image.Image, maxFeatures int) ([]Keypoint, []Descriptor) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	plane := luminancePlane(img)
+	candidates := fastCandidates(plane, w, h, 0)
+	harris := harrisResponse(plane, w, h)
+	for i := range candidates {
+		candidates[i].Response = harris[int(candidates[i].Y)*w+int(candidates[i].X)]
+	}
+	corners := selectCorners(candidates, CornerOptions{MaxCorners: maxFeatures, MinDistance: 3})
+	return DescribeORB(img, corners)
+}
+
+// DescriptorMatch pairs descriptor Query of one set with Train of another
+type DescriptorMatch struct {
+	Query, Train int
+	Distance     int
+}
+
+// MatchDescriptors brute-force matches query against train, keeping only
+// mutual nearest neighbors whose distance is below maxRatio times the
+// second-nearest distance (Lowe's ratio test; 0 disables it)
+func MatchDescriptors(query, train []Descriptor, maxRatio float64) []DescriptorMatch {
+	nearest := func(d Descriptor, set []Descriptor) (int, int, int) {
+		best, bestDist, second := -1, math.MaxInt, math.MaxInt
+		for i, o := range set {
+			dist := d.Distance(o)
+			if dist < bestDist {
+				best, bestDist, second = i, dist, bestDist
+			} else if dist < second {
+				second = dist
+			}
+		}
+		return best, bestDist, second
+	}
+
+	backward := make([]int, len(train))
+	for i, d := range train {
+		backward[i], _, _ = nearest(d, query)
+	}
+
+	var matches []DescriptorMatch
+	for qi, d := range query {
+		ti, dist, second := nearest(d, train)
+		if ti < 0 || backward[ti] != qi {
+			continue
+		}
+		if maxRatio > 0 && second != math.MaxInt && float64(dist) >= maxRatio*float64(second) {
+			continue
+		}
+		matches = append(matches, DescriptorMatch{Query: qi, Train: ti, Distance: dist})
+	}
+	return matches
+}
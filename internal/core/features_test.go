@@ -0,0 +1,170 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// blocksImage scatters random gray rectangles, which gives plenty of corners
+func blocksImage(w, h int, seed int64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i-3], img.Pix[i-2], img.Pix[i-1], img.Pix[i] = 128, 128, 128, 255
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for n := 0; n < 60; n++ {
+		x, y := rng.Intn(w-10), rng.Intn(h-10)
+		rw, rh := 4+rng.Intn(20), 4+rng.Intn(20)
+		v := uint8(rng.Intn(256))
+		for yy := y; yy < y+rh && yy < h; yy++ {
+			for xx := x; xx < x+rw && xx < w; xx++ {
+				img.SetRGBA(xx, yy, color.RGBA{v, v, v, 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestMatchTemplate(t *testing.T) {
+	img := blocksImage(80, 60, 1)
+	tmpl := img.SubImage(image.Rect(30, 20, 46, 32))
+
+	for _, method := range []MatchMethod{MatchSSD, MatchNCC} {
+		res, err := MatchTemplate(img, tmpl, method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		best := res.Best()
+		if best.X != 30 || best.Y != 20 {
+			t.Errorf("method %d: best match at (%d, %d), want (30, 20)", method, best.X, best.Y)
+		}
+		if top := res.BestN(3, 4); len(top) != 3 || top[0] != best {
+			t.Errorf("method %d: BestN = %v", method, top)
+		}
+	}
+
+	if _, err := MatchTemplate(tmpl, img, MatchSSD); err == nil {
+		t.Error("expected an error for a template larger than the image")
+	}
+}
+
+func TestMatchTemplateFFT(t *testing.T) {
+	img := blocksImage(90, 70, 2)
+	tmpl := img.SubImage(image.Rect(41, 23, 66, 43))
+	if tmpl.Bounds().Dx() <= FFTKernelThreshold {
+		t.Fatal("template too small to use the FFT")
+	}
+
+	for _, method := range []MatchMethod{MatchSSD, MatchNCC} {
+		fast, err := MatchTemplate(img, tmpl, method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := FFTKernelThreshold
+		FFTKernelThreshold = 1 << 30
+		direct, _ := MatchTemplate(img, tmpl, method)
+		FFTKernelThreshold = saved
+
+		for i := range direct.Scores {
+			if math.Abs(fast.Scores[i]-direct.Scores[i]) > 1e-6 {
+				t.Fatalf("method %d: score %d = %v through the FFT, %v directly", method, i, fast.Scores[i], direct.Scores[i])
+			}
+		}
+		if best := fast.Best(); best.X != 41 || best.Y != 23 {
+			t.Errorf("method %d: best match at (%d, %d), want (41, 23)", method, best.X, best.Y)
+		}
+	}
+}
+
+func TestCornerDetectors(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{0, 0, 0, 255}
+			if x >= 10 && x < 30 && y >= 10 && y < 30 {
+				c = color.RGBA{255, 255, 255, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	corners := []Point{{10, 10}, {29, 10}, {10, 29}, {29, 29}}
+
+	for name, kps := range map[string][]Keypoint{
+		"harris": HarrisCorners(img, CornerOptions{MinDistance: 5}),
+		"fast":   FASTCorners(img, CornerOptions{MinDistance: 5}),
+	} {
+		if len(kps) != 4 {
+			t.Errorf("%s: found %d corners, want 4: %v", name, len(kps), kps)
+			continue
+		}
+		for _, c := range corners {
+			found := false
+			for _, k := range kps {
+				if math.Hypot(k.X-c.X, k.Y-c.Y) <= 2 {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s: no corner near %v", name, c)
+			}
+		}
+	}
+}
+
+func TestEstimateHomographyWithOutliers(t *testing.T) {
+	want := Homography{1.1, 0.05, 12, -0.03, 0.95, -7, 0.0004, -0.0002, 1}
+	rng := rand.New(rand.NewSource(3))
+	var src, dst []Point
+	for i := 0; i < 60; i++ {
+		p := Point{rng.Float64() * 200, rng.Float64() * 150}
+		x, y := want.TransformPoint(p.X, p.Y)
+		if i%5 == 0 {
+			x, y = rng.Float64()*200, rng.Float64()*150
+		}
+		src, dst = append(src, p), append(dst, Point{x, y})
+	}
+
+	got, inliers, err := EstimateHomography(src, dst, RANSACOptions{Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, ok := range inliers {
+		if ok {
+			count++
+		}
+	}
+	if count != 48 {
+		t.Errorf("found %d inliers, want 48", count)
+	}
+	x, y := got.TransformPoint(100, 75)
+	wx, wy := want.TransformPoint(100, 75)
+	if math.Hypot(x-wx, y-wy) > 0.01 {
+		t.Errorf("estimated transform maps (100, 75) to (%.3f, %.3f), want (%.3f, %.3f)", x, y, wx, wy)
+	}
+
+	if _, _, err := EstimateAffine(src[:2], dst[:2], RANSACOptions{}); err == nil {
+		t.Error("expected an error for too few points")
+	}
+}
+
+func TestAlignImage(t *testing.T) {
+	ref := blocksImage(160, 120, 7)
+	shifted := Warp(ref, func(x, y float64) (float64, float64) { return x - 6, y + 4 }, SamplerNearest)
+
+	aligned, hm, err := AlignImage(shifted, ref, TransformAffine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := hm.TransformPoint(50, 50)
+	if math.Abs(x-56) > 0.5 || math.Abs(y-46) > 0.5 {
+		t.Errorf("reference (50, 50) maps to (%.2f, %.2f), want (56, 46)", x, y)
+	}
+	inner := image.Rect(20, 20, 140, 100)
+	if ssim, _ := SSIM(ref.SubImage(inner), aligned.SubImage(inner)); ssim < 0.95 {
+		t.Errorf("aligned image SSIM = %.3f", ssim)
+	}
+}
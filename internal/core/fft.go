@@ -0,0 +1,235 @@
+package core
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// Discrete Fourier transforms of image planes
+
+// Spectrum is the 2D discrete Fourier transform of a Width x Height plane,
+// stored row-major with the zero frequency at index 0
+type Spectrum struct {
+	Width, Height int
+	Data          []complex128
+}
+
+// FFT2D transforms a row-major plane of real samples. Power-of-two sizes use
+// radix-2 transforms and other sizes use Bluestein's algorithm.
+func FFT2D(plane []float64, width, height int) *Spectrum {
+	s := &Spectrum{Width: width, Height: height, Data: make([]complex128, width*height)}
+	for i, v := range plane[:width*height] {
+		s.Data[i] = complex(v, 0)
+	}
+	fft2D(s.Data, width, height, false)
+	return s
+}
+
+// IFFT2D returns the real part of the inverse transform
+func (s *Spectrum) IFFT2D() []float64 {
+	data := append([]complex128(nil), s.Data...)
+	fft2D(data, s.Width, s.Height, true)
+	plane := make([]float64, len(data))
+	for i, v := range data {
+		plane[i] = real(v)
+	}
+	return plane
+}
+
+// FFTChannel transforms one channel of the image (0 red, 1 green, 2 blue,
+// 3 alpha) as premultiplied values in 0..1
+func FFTChannel(img image.Image, channel int) *Spectrum {
+	src := asRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	return FFT2D(channelPlane(src, channel), w, h)
+}
+
+// FFTLuminance transforms the image's luminance in 0..1
+func FFTLuminance(img image.Image) *Spectrum {
+	b := img.Bounds()
+	return FFT2D(luminancePlane(img), b.Dx(), b.Dy())
+}
+
+// Magnitude renders log(1 + |F|) scaled to 0..255, shifted so the zero
+// frequency is at the center of the image
+func (s *Spectrum) Magnitude() *image.Gray {
+	values := make([]float64, len(s.Data))
+	var peak float64
+	for i, v := range s.Data {
+		values[i] = math.Log1p(cmplx.Abs(v))
+		peak = math.Max(peak, values[i])
+	}
+	if peak == 0 {
+		peak = 1
+	}
+	return s.shiftedGray(func(i int) float64 { return values[i] / peak })
+}
+
+// Phase renders the phase angle, -pi..pi mapped to 0..255, shifted so the
+// zero frequency is at the center of the image
+func (s *Spectrum) Phase() *image.Gray {
+	return s.shiftedGray(func(i int) float64 {
+		return (cmplx.Phase(s.Data[i]) + math.Pi) / (2 * math.Pi)
+	})
+}
+
+// shiftedGray draws value(i) for every coefficient with the quadrants swapped
+func (s *Spectrum) shiftedGray(value func(i int) float64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, s.Width, s.Height))
+	for y := 0; y < s.Height; y++ {
+		sy := (y + s.Height/2) % s.Height
+		for x := 0; x < s.Width; x++ {
+			sx := (x + s.Width/2) % s.Width
+			img.Pix[sy*img.Stride+sx] = uint8(math.Round(clamp(value(y*s.Width+x), 0, 1) * 255))
+		}
+	}
+	return img
+}
+
+// Frequency returns the signed frequency of coefficient (x, y) in cycles per
+// pixel, each in -0.5..0.5
+func (s *Spectrum) Frequency(x, y int) (fx, fy float64) {
+	return signedFrequency(x, s.Width), signedFrequency(y, s.Height)
+}
+
+func signedFrequency(i, n int) float64 {
+	if i > n/2 {
+		i -= n
+	}
+	return float64(i) / float64(n)
+}
+
+// channelPlane extracts a premultiplied channel in 0..1
+func channelPlane(img *image.RGBA, channel int) []float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	plane := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			plane[y*w+x] = float64(row[x*4+channel]) / 255
+		}
+	}
+	return plane
+}
+
+// fft2D transforms data in place, rows then columns. The inverse includes
+// the 1/(w*h) normalization.
+func fft2D(data []complex128, w, h int, inverse bool) {
+	parallelRows(h, 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			fft(data[y*w:(y+1)*w], inverse)
+		}
+	})
+	parallelRows(w, 0, func(start, end int) {
+		col := make([]complex128, h)
+		for x := start; x < end; x++ {
+			for y := 0; y < h; y++ {
+				col[y] = data[y*w+x]
+			}
+			fft(col, inverse)
+			for y := 0; y < h; y++ {
+				data[y*w+x] = col[y]
+			}
+		}
+	})
+}
+
+// fft transforms x in place, dividing by len(x) for the inverse
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	if n <= 1 {
+		return
+	}
+	if n&(n-1) == 0 {
+		fftRadix2(x, inverse)
+	} else {
+		fftBluestein(x, inverse)
+	}
+	if inverse {
+		scale := complex(1/float64(n), 0)
+		for i := range x {
+			x[i] *= scale
+		}
+	}
+}
+
+// fftRadix2 is an unnormalized iterative Cooley-Tukey transform for
+// power-of-two lengths
+func fftRadix2(x []complex128, inverse bool) {
+	n := len(x)
+	shift := 64 - uint(bits.Len(uint(n-1)))
+	for i := 0; i < n; i++ {
+		if j := int(bits.Reverse64(uint64(i)) >> shift); j > i {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		half := size / 2
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < half; k++ {
+				a, b := x[start+k], x[start+k+half]*w
+				x[start+k], x[start+k+half] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// fftBluestein computes an unnormalized transform of any length as a
+// convolution with a chirp, which is evaluated with power-of-two transforms
+func fftBluestein(x []complex128, inverse bool) {
+	n := len(x)
+	m := 1 << uint(bits.Len(uint(2*n-2)))
+
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	chirp := make([]complex128, n)
+	for k := 0; k < n; k++ {
+		// k*k mod 2n keeps the angle accurate for long rows
+		kk := (k * k) % (2 * n)
+		chirp[k] = cmplx.Rect(1, sign*math.Pi*float64(kk)/float64(n))
+	}
+
+	a := make([]complex128, m)
+	b := make([]complex128, m)
+	for k := 0; k < n; k++ {
+		a[k] = x[k] * chirp[k]
+	}
+	b[0] = cmplx.Conj(chirp[0])
+	for k := 1; k < n; k++ {
+		b[k] = cmplx.Conj(chirp[k])
+		b[m-k] = b[k]
+	}
+
+	fftRadix2(a, false)
+	fftRadix2(b, false)
+	for i := range a {
+		a[i] *= b[i]
+	}
+	fftRadix2(a, true)
+
+	scale := complex(1/float64(m), 0)
+	for k := 0; k < n; k++ {
+		x[k] = a[k] * scale * chirp[k]
+	}
+}
+
+// nextPowerOfTwo returns the smallest power of two that is at least n
+func nextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << uint(bits.Len(uint(n-1)))
+}
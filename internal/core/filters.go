@@ -6,18 +6,50 @@ import (
 	"math"
 	"math/rand"
 	"runtime"
+	"sync"
 )
 
 // Filter represents an image filter function
 type Filter func(img image.Image) image.Image
 
-// ApplyFilter applies a filter to the context's current image
+// linearCanvases holds the canvases ApplyFilter is filtering for a
+// linear-light context, so blur filters can pick up the setting
+var linearCanvases sync.Map
+
+// linearCanvas reports whether img is being filtered in linear light
+func linearCanvas(img image.Image) bool {
+	_, ok := linearCanvases.Load(img)
+	return ok
+}
+
+// ApplyFilter applies a filter to the context's current image. On 16-bit
+// and float canvases a filter that returns an 8-bit image is converted
+// back to the canvas format. With linear light on, the blur filters
+// (GaussianBlur, Blur and FastBlur) blur in linear light.
 func (dc *Context) ApplyFilter(filter Filter) {
-	dc.im = filter(dc.im).(*image.RGBA)
+	var src image.Image = dc.im
+	if dc.deep != nil {
+		src = dc.deep.image()
+	}
+	if dc.linearLight {
+		linearCanvases.Store(src, struct{}{})
+		defer linearCanvases.Delete(src)
+	}
+	if dc.deep != nil {
+		dc.setDeepImage(filter(src))
+		return
+	}
+	dc.im = filter(src).(*image.RGBA)
 }
 
 // Grayscale converts the image to grayscale
 func Grayscale(img image.Image) image.Image {
+	if deep := mapDeep(img, func(c [4]float32) [4]float32 {
+		l := 0.299*c[0] + 0.587*c[1] + 0.114*c[2]
+		return [4]float32{l, l, l, c[3]}
+	}); deep != nil {
+		return deep
+	}
 	bounds := img.Bounds()
 	grayImg := image.NewRGBA(bounds)
 
@@ -34,6 +66,11 @@ func Grayscale(img image.Image) image.Image {
 
 // Invert inverts the colors of the image
 func Invert(img image.Image) image.Image {
+	if deep := mapDeep(img, func(c [4]float32) [4]float32 {
+		return [4]float32{c[3] - c[0], c[3] - c[1], c[3] - c[2], c[3]}
+	}); deep != nil {
+		return deep
+	}
 	bounds := img.Bounds()
 	inverted := image.NewRGBA(bounds)
 
@@ -53,6 +90,16 @@ func Invert(img image.Image) image.Image {
 
 // Sepia applies a sepia tone effect
 func Sepia(img image.Image) image.Image {
+	if deep := mapDeep(img, func(c [4]float32) [4]float32 {
+		return [4]float32{
+			min32(c[3], 0.393*c[0]+0.769*c[1]+0.189*c[2]),
+			min32(c[3], 0.349*c[0]+0.686*c[1]+0.168*c[2]),
+			min32(c[3], 0.272*c[0]+0.534*c[1]+0.131*c[2]),
+			c[3],
+		}
+	}); deep != nil {
+		return deep
+	}
 	bounds := img.Bounds()
 	sepia := image.NewRGBA(bounds)
 
@@ -80,6 +127,12 @@ func Sepia(img image.Image) image.Image {
 // Brightness adjusts the brightness of the image
 func Brightness(factor float64) Filter {
 	return func(img image.Image) image.Image {
+		f := float32(factor)
+		if deep := mapDeep(img, func(c [4]float32) [4]float32 {
+			return [4]float32{c[0] * f, c[1] * f, c[2] * f, c[3]}
+		}); deep != nil {
+			return deep
+		}
 		bounds := img.Bounds()
 		bright := image.NewRGBA(bounds)
 
@@ -106,6 +159,13 @@ func Brightness(factor float64) Filter {
 // Contrast adjusts the contrast of the image
 func Contrast(factor float64) Filter {
 	return func(img image.Image) image.Image {
+		f := float32(factor)
+		if deep := mapDeep(img, func(c [4]float32) [4]float32 {
+			mid := c[3] / 2
+			return [4]float32{(c[0]-mid)*f + mid, (c[1]-mid)*f + mid, (c[2]-mid)*f + mid, c[3]}
+		}); deep != nil {
+			return deep
+		}
 		bounds := img.Bounds()
 		contrast := image.NewRGBA(bounds)
 
@@ -131,43 +191,10 @@ func Contrast(factor float64) Filter {
 	}
 }
 
-// Blur applies a simple box blur
+// Blur applies a Gaussian blur. The radius covers two standard deviations;
+// see RadiusToSigma.
 func Blur(radius int) Filter {
-	return func(img image.Image) image.Image {
-		bounds := img.Bounds()
-		blurred := image.NewRGBA(bounds)
-
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				var rSum, gSum, bSum, aSum, count uint32
-
-				// Sample surrounding pixels
-				for dy := -radius; dy <= radius; dy++ {
-					for dx := -radius; dx <= radius; dx++ {
-						px, py := x+dx, y+dy
-						if px >= bounds.Min.X && px < bounds.Max.X && py >= bounds.Min.Y && py < bounds.Max.Y {
-							r, g, b, a := img.At(px, py).RGBA()
-							rSum += r / 256
-							gSum += g / 256
-							bSum += b / 256
-							aSum += a / 256
-							count++
-						}
-					}
-				}
-
-				if count > 0 {
-					blurred.Set(x, y, color.RGBA{
-						uint8(rSum / count),
-						uint8(gSum / count),
-						uint8(bSum / count),
-						uint8(aSum / count),
-					})
-				}
-			}
-		}
-		return blurred
-	}
+	return GaussianBlur(RadiusToSigma(float64(radius)))
 }
 
 // Sharpen applies a sharpening filter
@@ -450,15 +477,10 @@ func FastContrast(factor float64) Filter {
 	}
 }
 
-// FastBlur is an optimized blur filter using separable convolution
+// FastBlur is an optimized blur filter using separable convolution.
+// It is equivalent to Blur and kept for compatibility.
 func FastBlur(radius int) Filter {
-	return func(img image.Image) image.Image {
-		if rgba, ok := img.(*image.RGBA); ok {
-			return SIMDBlur(rgba, radius)
-		}
-
-		return Blur(radius)(img)
-	}
+	return Blur(radius)
 }
 
 // FastSharpen is an optimized sharpen filter
@@ -596,6 +618,43 @@ func ParallelFilter(filter Filter, numWorkers int) Filter {
 	}
 }
 
+// parallelRows splits the range [0, n) into contiguous bands and processes
+// them concurrently, using the same worker layout as ParallelFilter
+func parallelRows(n, numWorkers int, fn func(start, end int)) {
+	if numWorkers <= 0 {
+		numWorkers = runtime.NumCPU()
+	}
+	if numWorkers > n {
+		numWorkers = n
+	}
+	if numWorkers <= 1 {
+		if n > 0 {
+			fn(0, n)
+		}
+		return
+	}
+
+	rowsPerWorker := n / numWorkers
+	done := make(chan bool, numWorkers)
+
+	for worker := 0; worker < numWorkers; worker++ {
+		start := worker * rowsPerWorker
+		end := start + rowsPerWorker
+		if worker == numWorkers-1 {
+			end = n
+		}
+
+		go func(start, end int) {
+			fn(start, end)
+			done <- true
+		}(start, end)
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		<-done
+	}
+}
+
 // Posterize reduces the number of colors
 func Posterize(levels int) Filter {
 	return func(img image.Image) image.Image {
@@ -658,3 +717,11 @@ func Vignette(strength float64) Filter {
 		return vignetted
 	}
 }
+
+// min32 returns the smaller of two float32 values
+func min32(a, b float32) float32 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,380 @@
+package core
+
+import (
+	"image"
+	"math"
+	"math/cmplx"
+)
+
+// Frequency-domain filtering, FFT convolution and deconvolution
+
+// FrequencyMask gives the gain for a frequency in cycles per pixel, each
+// component in -0.5..0.5
+type FrequencyMask func(fx, fy float64) float64
+
+// FrequencyFilter returns a Filter that scales every frequency of the color
+// channels by mask. Alpha is left unchanged. The image is treated as
+// periodic, so strong differences between opposite edges can ring.
+func FrequencyFilter(mask FrequencyMask) Filter {
+	return func(img image.Image) image.Image {
+		return applyFrequencyMask(asRGBA(img), mask, 0)
+	}
+}
+
+// LowPassFilter keeps frequencies below cutoff cycles per pixel (0..0.5)
+// with a Gaussian roll-off, which avoids ringing
+func LowPassFilter(cutoff float64) Filter {
+	return FrequencyFilter(gaussianLowPass(cutoff))
+}
+
+// HighPassFilter removes frequencies below cutoff cycles per pixel. As in
+// photo editors, the result is centered on 50% gray so both darker and
+// lighter detail stays visible.
+func HighPassFilter(cutoff float64) Filter {
+	low := gaussianLowPass(cutoff)
+	return func(img image.Image) image.Image {
+		return applyFrequencyMask(asRGBA(img), func(fx, fy float64) float64 {
+			return 1 - low(fx, fy)
+		}, 0.5)
+	}
+}
+
+// BandPassFilter keeps frequencies between low and high cycles per pixel
+func BandPassFilter(low, high float64) Filter {
+	lo, hi := gaussianLowPass(low), gaussianLowPass(high)
+	return FrequencyFilter(func(fx, fy float64) float64 {
+		return math.Max(hi(fx, fy)-lo(fx, fy), 0)
+	})
+}
+
+// Notch is a frequency to reject, in cycles per pixel, with its radius.
+// Its mirror image -U, -V is rejected as well.
+type Notch struct {
+	U, V   float64
+	Radius float64
+}
+
+// NotchFilter rejects narrow frequency peaks, such as the halftone or
+// screen pattern that causes moiré in scans. Use a Spectrum's Magnitude to
+// find the peaks.
+func NotchFilter(notches ...Notch) Filter {
+	return FrequencyFilter(func(fx, fy float64) float64 {
+		gain := 1.0
+		for _, n := range notches {
+			r2 := 2 * n.Radius * n.Radius
+			if r2 == 0 {
+				continue
+			}
+			for _, s := range []float64{1, -1} {
+				du, dv := fx-s*n.U, fy-s*n.V
+				gain *= 1 - math.Exp(-(du*du+dv*dv)/r2)
+			}
+		}
+		return gain
+	})
+}
+
+func gaussianLowPass(cutoff float64) FrequencyMask {
+	if cutoff <= 0 {
+		return func(fx, fy float64) float64 { return 0 }
+	}
+	// The gain falls to 1/sqrt(2) at the cutoff
+	s2 := cutoff * cutoff / math.Ln2
+	return func(fx, fy float64) float64 {
+		return math.Exp(-(fx*fx + fy*fy) / s2)
+	}
+}
+
+// applyFrequencyMask filters the premultiplied color channels, adding
+// offset (scaled by alpha) to the result
+func applyFrequencyMask(src *image.RGBA, mask FrequencyMask, offset float64) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	result := image.NewRGBA(b)
+	if w == 0 || h == 0 {
+		return result
+	}
+
+	gain := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		fy := signedFrequency(y, h)
+		for x := 0; x < w; x++ {
+			gain[y*w+x] = mask(signedFrequency(x, w), fy)
+		}
+	}
+
+	alpha := channelPlane(src, 3)
+	var planes [3][]float64
+	for c := 0; c < 3; c++ {
+		s := FFT2D(channelPlane(src, c), w, h)
+		for i := range s.Data {
+			s.Data[i] *= complex(gain[i], 0)
+		}
+		planes[c] = s.IFFT2D()
+	}
+
+	for y := 0; y < h; y++ {
+		row := result.Pix[y*result.Stride:]
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			a := alpha[i]
+			p := row[x*4 : x*4+4]
+			for c := 0; c < 3; c++ {
+				p[c] = uint8(math.Round(clamp(planes[c][i]+offset*a, 0, a) * 255))
+			}
+			p[3] = uint8(math.Round(a * 255))
+		}
+	}
+	return result
+}
+
+// FFTKernelThreshold is the kernel size above which ApplyKernel and
+// SIMDConvolution switch from direct summation to FFT convolution
+var FFTKernelThreshold = 15
+
+// correlateFFT correlates each plane with kernel the way the direct
+// convolution loops do, out[y][x] = sum of p[y+j-r][x+i-r] * k[j][i],
+// clamping samples beyond the edges. Planes are transformed two at a time
+// as the real and imaginary parts of one complex signal.
+func correlateFFT(planes [][]float64, w, h int, kernel [][]float64) [][]float64 {
+	kh := len(kernel)
+	kw := len(kernel[0])
+	rx, ry := kw/2, kh/2
+	pw, ph := nextPowerOfTwo(w+kw-1), nextPowerOfTwo(h+kh-1)
+
+	k := make([]complex128, pw*ph)
+	for j, row := range kernel {
+		for i, v := range row {
+			k[j*pw+i] = complex(v, 0)
+		}
+	}
+	fft2D(k, pw, ph, false)
+
+	out := make([][]float64, len(planes))
+	buf := make([]complex128, pw*ph)
+	for p := 0; p < len(planes); p += 2 {
+		for i := range buf {
+			buf[i] = 0
+		}
+		for y := 0; y < h+kh-1; y++ {
+			sy := clampInt(y-ry, 0, h-1)
+			for x := 0; x < w+kw-1; x++ {
+				sx := clampInt(x-rx, 0, w-1)
+				re := planes[p][sy*w+sx]
+				var im float64
+				if p+1 < len(planes) {
+					im = planes[p+1][sy*w+sx]
+				}
+				buf[y*pw+x] = complex(re, im)
+			}
+		}
+		fft2D(buf, pw, ph, false)
+		for i := range buf {
+			buf[i] *= cmplx.Conj(k[i])
+		}
+		fft2D(buf, pw, ph, true)
+
+		out[p] = make([]float64, w*h)
+		if p+1 < len(planes) {
+			out[p+1] = make([]float64, w*h)
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				v := buf[y*pw+x]
+				out[p][y*w+x] = real(v)
+				if p+1 < len(planes) {
+					out[p+1][y*w+x] = imag(v)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// GaussianPSF returns a normalized Gaussian point spread function covering
+// 3 sigmas
+func GaussianPSF(sigma float64) [][]float64 {
+	k := gaussianKernel(sigma)
+	psf := make([][]float64, len(k))
+	for j := range psf {
+		psf[j] = make([]float64, len(k))
+		for i := range psf[j] {
+			psf[j][i] = float64(k[j]) * float64(k[i])
+		}
+	}
+	return psf
+}
+
+// MotionPSF returns a normalized linear motion blur of the given length in
+// pixels along angle radians
+func MotionPSF(length, angle float64) [][]float64 {
+	r := int(math.Ceil(length / 2))
+	size := 2*r + 1
+	psf := make([][]float64, size)
+	for j := range psf {
+		psf[j] = make([]float64, size)
+	}
+	sin, cos := math.Sincos(angle)
+	steps := int(math.Ceil(length*4)) + 1
+	for s := 0; s < steps; s++ {
+		t := length * (float64(s)/float64(max(steps-1, 1)) - 0.5)
+		x := clampInt(int(math.Round(float64(r)+t*cos)), 0, size-1)
+		y := clampInt(int(math.Round(float64(r)+t*sin)), 0, size-1)
+		psf[y][x]++
+	}
+	normalizeKernel(psf)
+	return psf
+}
+
+func normalizeKernel(k [][]float64) {
+	var sum float64
+	for _, row := range k {
+		for _, v := range row {
+			sum += v
+		}
+	}
+	if sum == 0 {
+		return
+	}
+	for _, row := range k {
+		for i := range row {
+			row[i] /= sum
+		}
+	}
+}
+
+// WienerDeconvolution returns a Filter that undoes blurring by psf, a
+// centered point spread function such as GaussianPSF or MotionPSF.
+// noiseToSignal regularizes the inverse: around 0.001 for clean images,
+// higher for noisy ones.
+func WienerDeconvolution(psf [][]float64, noiseToSignal float64) Filter {
+	return func(img image.Image) image.Image {
+		return wienerDeconvolve(asRGBA(img), psf, noiseToSignal)
+	}
+}
+
+func wienerDeconvolve(src *image.RGBA, psf [][]float64, nsr float64) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	result := image.NewRGBA(b)
+	if w == 0 || h == 0 || len(psf) == 0 || len(psf[0]) == 0 {
+		copyRGBA(result, src)
+		return result
+	}
+	nsr = math.Max(nsr, 1e-9)
+
+	// Pad with repeated edges so the periodic transform does not wrap
+	// opposite borders into each other
+	kh, kw := len(psf), len(psf[0])
+	rx, ry := kw, kh
+	pw, ph := nextPowerOfTwo(w+2*rx), nextPowerOfTwo(h+2*ry)
+
+	hk := make([]complex128, pw*ph)
+	for j, row := range psf {
+		for i, v := range row {
+			x := ((i-kw/2)%pw + pw) % pw
+			y := ((j-kh/2)%ph + ph) % ph
+			hk[y*pw+x] += complex(v, 0)
+		}
+	}
+	fft2D(hk, pw, ph, false)
+	gain := make([]complex128, len(hk))
+	for i, v := range hk {
+		mag2 := real(v)*real(v) + imag(v)*imag(v)
+		gain[i] = cmplx.Conj(v) / complex(mag2+nsr, 0)
+	}
+
+	alpha := channelPlane(src, 3)
+	var planes [3][]float64
+	for c := 0; c < 3; c++ {
+		plane := channelPlane(src, c)
+		buf := make([]complex128, pw*ph)
+		for y := 0; y < ph; y++ {
+			sy := clampInt(y-ry, 0, h-1)
+			for x := 0; x < pw; x++ {
+				sx := clampInt(x-rx, 0, w-1)
+				buf[y*pw+x] = complex(plane[sy*w+sx], 0)
+			}
+		}
+		fft2D(buf, pw, ph, false)
+		for i := range buf {
+			buf[i] *= gain[i]
+		}
+		fft2D(buf, pw, ph, true)
+		planes[c] = make([]float64, w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				planes[c][y*w+x] = real(buf[(y+ry)*pw+x+rx])
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		row := result.Pix[y*result.Stride:]
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			a := alpha[i]
+			p := row[x*4 : x*4+4]
+			for c := 0; c < 3; c++ {
+				p[c] = uint8(math.Round(clamp(planes[c][i], 0, a) * 255))
+			}
+			p[3] = uint8(math.Round(a * 255))
+		}
+	}
+	return result
+}
+
+// applyKernelFFT is ApplyKernel for large kernels
+func (id *ImageData) applyKernelFFT(kernel [][]float64) *ImageData {
+	result := id.Clone()
+	w, h := id.Width, id.Height
+	offset := len(kernel) / 2
+	planes := make([][]float64, 3)
+	for c := range planes {
+		planes[c] = make([]float64, w*h)
+		for i := range planes[c] {
+			planes[c][i] = float64(id.Data[i*4+c])
+		}
+	}
+	out := correlateFFT(planes, w, h, kernel)
+	for y := offset; y < h-offset; y++ {
+		for x := offset; x < w-offset; x++ {
+			i := y*w + x
+			for c := 0; c < 3; c++ {
+				result.Data[i*4+c] = fftSampleToUint8(out[c][i])
+			}
+		}
+	}
+	return result
+}
+
+// simdConvolutionFFT is SIMDConvolution for large kernels
+func simdConvolutionFFT(img *image.RGBA, kernel [][]float64) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(bounds)
+	w, h := bounds.Dx(), bounds.Dy()
+	offset := len(kernel) / 2
+	planes := make([][]float64, 3)
+	for c := range planes {
+		planes[c] = channelPlane(img, c)
+	}
+	out := correlateFFT(planes, w, h, kernel)
+	for y := offset; y < h-offset; y++ {
+		src := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		dst := result.Pix[y*result.Stride:]
+		for x := offset; x < w-offset; x++ {
+			i := y*w + x
+			for c := 0; c < 3; c++ {
+				dst[x*4+c] = fftSampleToUint8(out[c][i] * 255)
+			}
+			dst[x*4+3] = src[x*4+3]
+		}
+	}
+	return result
+}
+
+// fftSampleToUint8 truncates like the direct convolution loops, after
+// absorbing the transform's rounding error
+func fftSampleToUint8(v float64) uint8 {
+	return uint8(clamp(v+1e-6, 0, 255))
+}
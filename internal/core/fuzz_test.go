@@ -0,0 +1,166 @@
+package core
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// Fuzz targets for the decoders. Seeds come from the encoders, and the
+// checked-in corpus under testdata/fuzz adds damaged and oversized headers.
+// Run one with, for example, go test -fuzz FuzzDecodeQOI ./internal/core
+
+// fuzzLimits keeps every input small enough to decode quickly
+func fuzzLimits(f *testing.F) {
+	withDecodeLimits(f, DecodeLimits{
+		MaxPixels:        1 << 16,
+		MaxWidth:         1 << 10,
+		MaxHeight:        1 << 10,
+		MaxFrames:        8,
+		MaxFontSize:      1 << 20,
+		MaxFontTableSize: 1 << 18,
+	})
+}
+
+// fuzzSeed encodes a small scene with an encoder
+func fuzzSeed(f *testing.F, encode func(*bytes.Buffer, image.Image) error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, formatScene(7, 5, true)); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+}
+
+// checkDecodedSize fails if a decoder returned an image that its own
+// DecodeConfig disagrees with
+func checkDecodedSize(t *testing.T, img image.Image, config image.Config, configErr error) {
+	if configErr != nil {
+		t.Fatalf("decoded an image whose config fails: %v", configErr)
+	}
+	if size := img.Bounds().Size(); size.X != config.Width || size.Y != config.Height {
+		t.Fatalf("decoded %v, config says %dx%d", size, config.Width, config.Height)
+	}
+}
+
+func FuzzDecodeQOI(f *testing.F) {
+	fuzzLimits(f)
+	fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error { return EncodeQOI(b, img) })
+	f.Fuzz(func(t *testing.T, data []byte) {
+		img, err := DecodeQOI(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		config, err := DecodeQOIConfig(bytes.NewReader(data))
+		checkDecodedSize(t, img, config, err)
+	})
+}
+
+func FuzzDecodeNetpbm(f *testing.F) {
+	fuzzLimits(f)
+	for _, format := range []NetpbmFormat{NetpbmPBM, NetpbmPGM, NetpbmPPM, NetpbmPAM} {
+		for _, plain := range []bool{false, true} {
+			opts := &NetpbmOptions{Format: format, Plain: plain && format != NetpbmPAM}
+			fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error { return EncodeNetpbm(b, img, opts) })
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		img, err := DecodeNetpbm(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		config, err := DecodeNetpbmConfig(bytes.NewReader(data))
+		checkDecodedSize(t, img, config, err)
+	})
+}
+
+func FuzzDecodeTGA(f *testing.F) {
+	fuzzLimits(f)
+	for _, uncompressed := range []bool{false, true} {
+		opts := &TGAOptions{Uncompressed: uncompressed}
+		fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error { return EncodeTGA(b, img, opts) })
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		img, err := DecodeTGA(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		config, err := DecodeTGAConfig(bytes.NewReader(data))
+		checkDecodedSize(t, img, config, err)
+	})
+}
+
+func FuzzDecodeIcon(f *testing.F) {
+	fuzzLimits(f)
+	fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error {
+		icon, err := NewIcon(img, 16, 8)
+		if err != nil {
+			return err
+		}
+		return EncodeIcon(b, icon)
+	})
+	fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error {
+		icon, err := NewCursor(img, image.Pt(1, 2))
+		if err != nil {
+			return err
+		}
+		return EncodeIcon(b, icon)
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		icon, err := DecodeIcon(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(icon.Entries) == 0 {
+			t.Fatal("decoded an icon without entries")
+		}
+	})
+}
+
+func FuzzDecodeHDR(f *testing.F) {
+	fuzzLimits(f)
+	fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error { return EncodeHDR(b, img) })
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DecodeHDR(bytes.NewReader(data))
+	})
+}
+
+func FuzzDecodeEXR(f *testing.F) {
+	fuzzLimits(f)
+	for _, compression := range []EXRCompression{EXRNoCompression, EXRZIPCompression, EXRPIZCompression} {
+		opts := &EXROptions{Compression: compression}
+		fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error { return EncodeEXR(b, img, opts) })
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DecodeEXR(bytes.NewReader(data))
+	})
+}
+
+func FuzzParseWOFF(f *testing.F) {
+	fuzzLimits(f)
+	f.Add(testWOFF(1000))
+	f.Add(testWOFF(3))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sfnt, err := ParseWOFF(data)
+		if err != nil {
+			return
+		}
+		if err := checkFontData("FuzzParseWOFF", sfnt); err != nil && err.Type == ErrorTypeMemoryError {
+			t.Fatalf("ParseWOFF returned data over the limits: %v", err)
+		}
+	})
+}
+
+// FuzzImageDecode runs every registered decoder the way LoadImage does
+func FuzzImageDecode(f *testing.F) {
+	fuzzLimits(f)
+	fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error { return EncodeQOI(b, img) })
+	fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error { return EncodeTGA(b, img, nil) })
+	fuzzSeed(f, func(b *bytes.Buffer, img image.Image) error { return EncodeNetpbm(b, img, nil) })
+	f.Fuzz(func(t *testing.T, data []byte) {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || checkDecodeSize("FuzzImageDecode", config.Width, config.Height, 0) != nil {
+			return
+		}
+		image.Decode(bytes.NewReader(data))
+	})
+}
@@ -40,6 +40,10 @@ type linearGradient struct {
 }
 
 func (g *linearGradient) ColorAt(x, y int) color.Color {
+	return g.colorAt(x, y, colorLerp)
+}
+
+func (g *linearGradient) colorAt(x, y int, mix colorMixer) color.Color {
 	if len(g.stops) == 0 {
 		return color.Transparent
 	}
@@ -50,12 +54,12 @@ func (g *linearGradient) ColorAt(x, y int) color.Color {
 
 	// Horizontal
 	if dy == 0 && dx != 0 {
-		return getColor((fx-x0)/dx, g.stops)
+		return getColor((fx-x0)/dx, g.stops, mix)
 	}
 
 	// Vertical
 	if dx == 0 && dy != 0 {
-		return getColor((fy-y0)/dy, g.stops)
+		return getColor((fy-y0)/dy, g.stops, mix)
 	}
 
 	// Dot product
@@ -68,7 +72,7 @@ func (g *linearGradient) ColorAt(x, y int) color.Color {
 	u := ((fx-x0)*-dy + (fy-y0)*dx) / (mag * mag)
 	x2, y2 := x0+u*-dy, y0+u*dx
 	d := math.Hypot(fx-x2, fy-y2) / mag
-	return getColor(d, g.stops)
+	return getColor(d, g.stops, mix)
 }
 
 func (g *linearGradient) AddColorStop(offset float64, color color.Color) {
@@ -101,6 +105,10 @@ func dot3(x0, y0, z0, x1, y1, z1 float64) float64 {
 }
 
 func (g *radialGradient) ColorAt(x, y int) color.Color {
+	return g.colorAt(x, y, colorLerp)
+}
+
+func (g *radialGradient) colorAt(x, y int, mix colorMixer) color.Color {
 	if len(g.stops) == 0 {
 		return color.Transparent
 	}
@@ -117,7 +125,7 @@ func (g *radialGradient) ColorAt(x, y int) color.Color {
 		}
 		t := 0.5 * c / b
 		if t*g.cd.r >= g.mindr {
-			return getColor(t, g.stops)
+			return getColor(t, g.stops, mix)
 		}
 		return color.Transparent
 	}
@@ -129,9 +137,9 @@ func (g *radialGradient) ColorAt(x, y int) color.Color {
 		t1 := (b - sqrtdiscr) * g.inva
 
 		if t0*g.cd.r >= g.mindr {
-			return getColor(t0, g.stops)
+			return getColor(t0, g.stops, mix)
 		} else if t1*g.cd.r >= g.mindr {
-			return getColor(t1, g.stops)
+			return getColor(t1, g.stops, mix)
 		}
 	}
 
@@ -172,6 +180,10 @@ type conicGradient struct {
 }
 
 func (g *conicGradient) ColorAt(x, y int) color.Color {
+	return g.colorAt(x, y, colorLerp)
+}
+
+func (g *conicGradient) colorAt(x, y int, mix colorMixer) color.Color {
 	if len(g.stops) == 0 {
 		return color.Transparent
 	}
@@ -180,7 +192,7 @@ func (g *conicGradient) ColorAt(x, y int) color.Color {
 	if t < 0 {
 		t += 1
 	}
-	return getColor(t, g.stops)
+	return getColor(t, g.stops, mix)
 }
 
 func (g *conicGradient) AddColorStop(offset float64, color color.Color) {
@@ -210,7 +222,16 @@ func norm(value, a, b float64) float64 {
 	return (value - a) * (1.0 / (b - a))
 }
 
-func getColor(pos float64, stops stops) color.Color {
+// colorMixer interpolates between two gradient stop colors
+type colorMixer func(c0, c1 color.Color, t float64) color.Color
+
+// mixingPattern is implemented by gradients, which painters can ask to
+// interpolate their stops in linear light or at higher precision
+type mixingPattern interface {
+	colorAt(x, y int, mix colorMixer) color.Color
+}
+
+func getColor(pos float64, stops stops, mix colorMixer) color.Color {
 	if pos <= 0.0 || len(stops) == 1 {
 		return stops[0].color
 	}
@@ -224,7 +245,7 @@ func getColor(pos float64, stops stops) color.Color {
 	for i, stop := range stops[1:] {
 		if pos < stop.pos {
 			pos = (pos - stops[i].pos) / (stop.pos - stops[i].pos)
-			return colorLerp(stops[i].color, stop.color, pos)
+			return mix(stops[i].color, stop.color, pos)
 		}
 	}
 
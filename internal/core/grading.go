@@ -0,0 +1,371 @@
+package core
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// Tone curves, levels and channel mixing
+
+// CurvePoint is a control point of a tone curve, both coordinates in 0-255
+type CurvePoint struct {
+	X, Y float64
+}
+
+// SplineCurve maps input levels to output levels through a monotone cubic
+// spline passing through its control points. An empty curve is the identity.
+type SplineCurve []CurvePoint
+
+// CurveSet holds a master curve applied to all color channels plus one
+// curve per channel. Channel curves are applied first, then the master.
+type CurveSet struct {
+	RGB   SplineCurve
+	Red   SplineCurve
+	Green SplineCurve
+	Blue  SplineCurve
+}
+
+// LevelsOptions describes a levels adjustment. Input and output values are
+// in 0-255; Gamma above 1 brightens midtones.
+type LevelsOptions struct {
+	InputBlack  float64
+	InputWhite  float64
+	Gamma       float64
+	OutputBlack float64
+	OutputWhite float64
+}
+
+// ChannelMatrix is a channel mixer: each output channel (rows red, green,
+// blue) is a weighted sum of the input red, green and blue plus a constant
+// offset in the fourth column, all on a 0-1 scale
+type ChannelMatrix [3][4]float64
+
+// DefaultLevels returns levels that leave the image unchanged
+func DefaultLevels() LevelsOptions {
+	return LevelsOptions{InputBlack: 0, InputWhite: 255, Gamma: 1, OutputBlack: 0, OutputWhite: 255}
+}
+
+// IdentityChannelMatrix returns a channel mixer that leaves the image unchanged
+func IdentityChannelMatrix() ChannelMatrix {
+	return ChannelMatrix{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+	}
+}
+
+// Eval returns the curve's output level for input x
+func (c SplineCurve) Eval(x float64) float64 {
+	pts := c.sorted()
+	if len(pts) == 0 {
+		return x
+	}
+	if len(pts) == 1 {
+		return pts[0].Y
+	}
+	return evalMonotoneSpline(pts, monotoneTangents(pts), x)
+}
+
+// lut samples the curve at every 8-bit input level
+func (c SplineCurve) lut() [256]float64 {
+	var table [256]float64
+	pts := c.sorted()
+	var tangents []float64
+	if len(pts) > 1 {
+		tangents = monotoneTangents(pts)
+	}
+	for i := range table {
+		x := float64(i)
+		switch len(pts) {
+		case 0:
+			table[i] = x
+		case 1:
+			table[i] = pts[0].Y
+		default:
+			table[i] = evalMonotoneSpline(pts, tangents, x)
+		}
+	}
+	return table
+}
+
+// sorted returns the control points ordered by X with duplicates removed
+func (c SplineCurve) sorted() []CurvePoint {
+	pts := append([]CurvePoint(nil), c...)
+	sort.SliceStable(pts, func(i, j int) bool { return pts[i].X < pts[j].X })
+	unique := pts[:0]
+	for _, p := range pts {
+		if len(unique) > 0 && p.X == unique[len(unique)-1].X {
+			unique[len(unique)-1] = p
+			continue
+		}
+		unique = append(unique, p)
+	}
+	return unique
+}
+
+// monotoneTangents computes Fritsch-Carlson tangents, which keep the spline
+// from overshooting between control points
+func monotoneTangents(pts []CurvePoint) []float64 {
+	n := len(pts)
+	slopes := make([]float64, n-1)
+	for i := 0; i < n-1; i++ {
+		slopes[i] = (pts[i+1].Y - pts[i].Y) / (pts[i+1].X - pts[i].X)
+	}
+
+	tangents := make([]float64, n)
+	tangents[0] = slopes[0]
+	tangents[n-1] = slopes[n-2]
+	for i := 1; i < n-1; i++ {
+		if slopes[i-1]*slopes[i] <= 0 {
+			tangents[i] = 0
+		} else {
+			tangents[i] = (slopes[i-1] + slopes[i]) / 2
+		}
+	}
+
+	for i := 0; i < n-1; i++ {
+		if slopes[i] == 0 {
+			tangents[i] = 0
+			tangents[i+1] = 0
+			continue
+		}
+		a := tangents[i] / slopes[i]
+		b := tangents[i+1] / slopes[i]
+		if s := a*a + b*b; s > 9 {
+			t := 3 / math.Sqrt(s)
+			tangents[i] = t * a * slopes[i]
+			tangents[i+1] = t * b * slopes[i]
+		}
+	}
+	return tangents
+}
+
+// evalMonotoneSpline evaluates the cubic Hermite spline at x, holding the end
+// values outside the control point range
+func evalMonotoneSpline(pts []CurvePoint, tangents []float64, x float64) float64 {
+	n := len(pts)
+	if x <= pts[0].X {
+		return pts[0].Y
+	}
+	if x >= pts[n-1].X {
+		return pts[n-1].Y
+	}
+
+	i := sort.Search(n, func(i int) bool { return pts[i].X > x }) - 1
+	h := pts[i+1].X - pts[i].X
+	t := (x - pts[i].X) / h
+	t2, t3 := t*t, t*t*t
+	return (2*t3-3*t2+1)*pts[i].Y +
+		(t3-2*t2+t)*h*tangents[i] +
+		(-2*t3+3*t2)*pts[i+1].Y +
+		(t3-t2)*h*tangents[i+1]
+}
+
+// lut builds the 8-bit lookup table for the levels adjustment
+func (l LevelsOptions) lut() [256]float64 {
+	var table [256]float64
+	gamma := l.Gamma
+	if gamma <= 0 {
+		gamma = 1
+	}
+	for i := range table {
+		var t float64
+		if l.InputWhite <= l.InputBlack {
+			if float64(i) >= l.InputBlack {
+				t = 1
+			}
+		} else {
+			t = clamp((float64(i)-l.InputBlack)/(l.InputWhite-l.InputBlack), 0, 1)
+		}
+		t = math.Pow(t, 1/gamma)
+		table[i] = l.OutputBlack + t*(l.OutputWhite-l.OutputBlack)
+	}
+	return table
+}
+
+// Curves returns a Filter applying the curve set to the image's colors
+func Curves(curves CurveSet) Filter {
+	return func(img image.Image) image.Image {
+		return applyCurves(asRGBA(img), curves)
+	}
+}
+
+// Levels returns a Filter applying the same levels to every color channel
+func Levels(opts LevelsOptions) Filter {
+	return ChannelLevels(opts, opts, opts)
+}
+
+// ChannelLevels returns a Filter applying separate levels to the red, green
+// and blue channels
+func ChannelLevels(red, green, blue LevelsOptions) Filter {
+	return func(img image.Image) image.Image {
+		return applyLevels(asRGBA(img), red, green, blue)
+	}
+}
+
+// ChannelMixer returns a Filter that recombines the color channels
+func ChannelMixer(m ChannelMatrix) Filter {
+	return func(img image.Image) image.Image {
+		return applyChannelMixer(asRGBA(img), m)
+	}
+}
+
+func applyCurves(img *image.RGBA, curves CurveSet) *image.RGBA {
+	master := curves.RGB.lut()
+	var tables [3][256]uint8
+	for c, curve := range []SplineCurve{curves.Red, curves.Green, curves.Blue} {
+		channel := curve.lut()
+		for i := range channel {
+			v := clamp(channel[i], 0, 255)
+			tables[c][i] = uint8(clamp(lerpTable(&master, v), 0, 255) + 0.5)
+		}
+	}
+	return applyChannelLUTs(img, &tables)
+}
+
+func applyLevels(img *image.RGBA, red, green, blue LevelsOptions) *image.RGBA {
+	var tables [3][256]uint8
+	for c, levels := range []LevelsOptions{red, green, blue} {
+		channel := levels.lut()
+		for i := range channel {
+			tables[c][i] = uint8(clamp(channel[i], 0, 255) + 0.5)
+		}
+	}
+	return applyChannelLUTs(img, &tables)
+}
+
+func applyChannelMixer(img *image.RGBA, m ChannelMatrix) *image.RGBA {
+	return mapStraightRGB(img, channelMixerFunc(m))
+}
+
+func channelMixerFunc(m ChannelMatrix) rgbFunc {
+	return func(r, g, b float64) (float64, float64, float64) {
+		return m[0][0]*r + m[0][1]*g + m[0][2]*b + m[0][3],
+			m[1][0]*r + m[1][1]*g + m[1][2]*b + m[1][3],
+			m[2][0]*r + m[2][1]*g + m[2][2]*b + m[2][3]
+	}
+}
+
+// curvesFunc evaluates a curve set continuously, for images with more
+// than 8 bits per channel
+func curvesFunc(curves CurveSet) rgbFunc {
+	master := curves.RGB.lut()
+	var channels [3][256]float64
+	for c, curve := range []SplineCurve{curves.Red, curves.Green, curves.Blue} {
+		channels[c] = curve.lut()
+	}
+	apply := func(c int, v float64) float64 {
+		v = clamp(lerpTable(&channels[c], clamp(v, 0, 1)*255), 0, 255)
+		return lerpTable(&master, v) / 255
+	}
+	return func(r, g, b float64) (float64, float64, float64) {
+		return apply(0, r), apply(1, g), apply(2, b)
+	}
+}
+
+// levelsFunc evaluates per-channel levels continuously
+func levelsFunc(red, green, blue LevelsOptions) rgbFunc {
+	tables := [3][256]float64{red.lut(), green.lut(), blue.lut()}
+	apply := func(c int, v float64) float64 {
+		return lerpTable(&tables[c], clamp(v, 0, 1)*255) / 255
+	}
+	return func(r, g, b float64) (float64, float64, float64) {
+		return apply(0, r), apply(1, g), apply(2, b)
+	}
+}
+
+// lerpTable reads a 256-entry table at a fractional index
+func lerpTable(table *[256]float64, v float64) float64 {
+	i := int(v)
+	if i >= 255 {
+		return table[255]
+	}
+	f := v - float64(i)
+	return table[i]*(1-f) + table[i+1]*f
+}
+
+// applyChannelLUTs maps the straight (unpremultiplied) color channels of img
+// through per-channel 8-bit tables
+func applyChannelLUTs(img *image.RGBA, tables *[3][256]uint8) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(bounds)
+	w := bounds.Dx()
+	parallelRows(bounds.Dy(), 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			si := img.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			di := result.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			for x := 0; x < w; x++ {
+				s := img.Pix[si+x*4 : si+x*4+4]
+				d := result.Pix[di+x*4 : di+x*4+4]
+				a := uint32(s[3])
+				d[3] = s[3]
+				if a == 0 {
+					continue
+				}
+				for c := 0; c < 3; c++ {
+					straight := (uint32(s[c])*255 + a/2) / a
+					if straight > 255 {
+						straight = 255
+					}
+					d[c] = uint8((uint32(tables[c][straight])*a + 127) / 255)
+				}
+			}
+		}
+	})
+	return result
+}
+
+// rgbFunc maps a straight color on a 0-1 scale
+type rgbFunc func(r, g, b float64) (float64, float64, float64)
+
+// mapStraightRGB applies fn to the straight color of every pixel on a 0-1
+// scale, keeping alpha
+func mapStraightRGB(img *image.RGBA, fn rgbFunc) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(bounds)
+	w := bounds.Dx()
+	parallelRows(bounds.Dy(), 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			si := img.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			di := result.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			for x := 0; x < w; x++ {
+				s := img.Pix[si+x*4 : si+x*4+4]
+				d := result.Pix[di+x*4 : di+x*4+4]
+				d[3] = s[3]
+				if s[3] == 0 {
+					continue
+				}
+				a := float64(s[3]) / 255
+				r, g, b := fn(float64(s[0])/255/a, float64(s[1])/255/a, float64(s[2])/255/a)
+				d[0] = uint8(clamp(r, 0, 1)*a*255 + 0.5)
+				d[1] = uint8(clamp(g, 0, 1)*a*255 + 0.5)
+				d[2] = uint8(clamp(b, 0, 1)*a*255 + 0.5)
+			}
+		}
+	})
+	return result
+}
+
+// mapStraightRGBFloat is mapStraightRGB for float images. Results are only
+// clamped below, so high dynamic range values survive.
+func mapStraightRGBFloat(img *RGBAF32, fn rgbFunc) *RGBAF32 {
+	return mapStraightRGBDeep(img, fn).(*RGBAF32)
+}
+
+// mapStraightRGBDeep is mapStraightRGB for 16-bit and float images
+func mapStraightRGBDeep(img image.Image, fn rgbFunc) image.Image {
+	return mapDeep(img, func(c [4]float32) [4]float32 {
+		a := c[3]
+		if a <= 0 {
+			return c
+		}
+		r, g, b := fn(float64(c[0]/a), float64(c[1]/a), float64(c[2]/a))
+		return [4]float32{
+			float32(math.Max(r, 0)) * a,
+			float32(math.Max(g, 0)) * a,
+			float32(math.Max(b, 0)) * a,
+			a,
+		}
+	})
+}
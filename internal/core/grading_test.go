@@ -0,0 +1,92 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestToneCurveMonotone(t *testing.T) {
+	curve := SplineCurve{{0, 0}, {64, 40}, {128, 160}, {255, 255}}
+	prev := -1.0
+	for x := 0.0; x <= 255; x++ {
+		y := curve.Eval(x)
+		if y < prev {
+			t.Fatalf("curve decreases at %v: %v < %v", x, y, prev)
+		}
+		prev = y
+	}
+	for _, p := range curve {
+		if got := curve.Eval(p.X); math.Abs(got-p.Y) > 1e-9 {
+			t.Errorf("Eval(%v) = %v, want %v", p.X, got, p.Y)
+		}
+	}
+	if got := (SplineCurve{}).Eval(77); got != 77 {
+		t.Errorf("empty curve Eval(77) = %v, want identity", got)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{20, 20, 20, 255})
+	img.SetRGBA(1, 0, color.RGBA{135, 135, 135, 255})
+	img.SetRGBA(2, 0, color.RGBA{250, 250, 250, 255})
+
+	levels := DefaultLevels()
+	levels.InputBlack, levels.InputWhite = 20, 250
+	out := Levels(levels)(img).(*image.RGBA)
+	if got := out.RGBAAt(0, 0).R; got != 0 {
+		t.Errorf("black point = %d, want 0", got)
+	}
+	if got := out.RGBAAt(1, 0).R; got < 126 || got > 129 {
+		t.Errorf("midpoint = %d, want ~128", got)
+	}
+	if got := out.RGBAAt(2, 0).R; got != 255 {
+		t.Errorf("white point = %d, want 255", got)
+	}
+}
+
+func TestLUT3DRoundTrip(t *testing.T) {
+	hald := NewHaldCLUTImage(4)
+	lut, err := NewLUT3DFromHald(hald)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lut.Size != 16 {
+		t.Fatalf("Size = %d, want 16", lut.Size)
+	}
+
+	var sb strings.Builder
+	if err := lut.WriteCube(&sb); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseCubeLUT(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, interp := range []LUTInterpolation{LUTTetrahedral, LUTTrilinear} {
+		for _, c := range [][3]float64{{0, 0, 0}, {0.3, 0.6, 0.9}, {1, 0.5, 0.25}} {
+			r, g, b := parsed.Lookup(c[0], c[1], c[2], interp)
+			if math.Abs(r-c[0]) > 0.005 || math.Abs(g-c[1]) > 0.005 || math.Abs(b-c[2]) > 0.005 {
+				t.Errorf("interp %d: Lookup(%v) = %v %v %v, want identity", interp, c, r, g, b)
+			}
+		}
+	}
+
+	if _, err := ParseCubeLUT(strings.NewReader("LUT_3D_SIZE 2\n0 0 0\n")); err == nil {
+		t.Error("expected error for truncated table")
+	}
+}
+
+func TestChannelMixerSwap(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{100, 0, 0, 200})
+	swap := ChannelMatrix{{0, 0, 1, 0}, {0, 1, 0, 0}, {1, 0, 0, 0}}
+	got := ChannelMixer(swap)(img).(*image.RGBA).RGBAAt(0, 0)
+	if got != (color.RGBA{0, 0, 100, 200}) {
+		t.Errorf("swap = %v, want {0 0 100 200}", got)
+	}
+}
@@ -0,0 +1,319 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Radiance RGBE (.hdr) images
+//
+// HDR files hold linear scene radiance. They are read into *RGBAF32 with
+// opaque alpha and samples that may exceed 1.
+
+// LoadHDR loads a Radiance .hdr image
+func LoadHDR(path string) (*RGBAF32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := DecodeHDR(file)
+	if err != nil {
+		if ae, ok := err.(*AdvanceError); ok {
+			ae.WithContext("filepath", path)
+		}
+		return nil, err
+	}
+	return img, nil
+}
+
+// SaveHDR writes img as a run-length encoded Radiance .hdr file. See
+// EncodeHDR for how samples are interpreted.
+func SaveHDR(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return EncodeHDR(file, img)
+}
+
+func hdrFormatError(details string) *AdvanceError {
+	return NewInvalidFormatError("Radiance HDR", []string{"hdr"}).WithContext("details", details)
+}
+
+// DecodeHDR reads a Radiance .hdr image in RGBE format. Top-down and
+// bottom-up files are supported; XYZE files and rotated orientations are not.
+func DecodeHDR(r io.Reader) (*RGBAF32, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#?") {
+		return nil, hdrFormatError("missing #? signature")
+	}
+	for {
+		line, err = br.ReadString('\n')
+		if err != nil {
+			return nil, hdrFormatError("unterminated header")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if format, ok := strings.CutPrefix(line, "FORMAT="); ok && format != "32-bit_rle_rgbe" {
+			return nil, NewUnsupportedOperationError("DecodeHDR", "pixel format "+format+" is not supported")
+		}
+	}
+
+	line, err = br.ReadString('\n')
+	if err != nil {
+		return nil, hdrFormatError("missing resolution line")
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 4 || fields[2] != "+X" || (fields[0] != "-Y" && fields[0] != "+Y") {
+		return nil, hdrFormatError(fmt.Sprintf("unsupported resolution line %q", strings.TrimSpace(line)))
+	}
+	height, err1 := strconv.Atoi(fields[1])
+	width, err2 := strconv.Atoi(fields[3])
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return nil, hdrFormatError("invalid image size")
+	}
+	if err := checkDecodeSize("DecodeHDR", width, height, 0); err != nil {
+		return nil, err
+	}
+	bottomUp := fields[0] == "+Y"
+
+	img := NewRGBAF32(image.Rect(0, 0, width, height))
+	scan := make([]byte, width*4)
+	flat := width < 8 || width > 0x7fff
+	for y := 0; y < height; y++ {
+		if flat {
+			err = readFlatRGBE(br, scan, 0)
+		} else {
+			flat, err = readRLERGBE(br, scan)
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := y
+		if bottomUp {
+			row = height - 1 - y
+		}
+		pix := img.Pix[img.PixOffset(0, row):]
+		for x := 0; x < width; x++ {
+			r, g, b := rgbeToFloat(scan[x*4:])
+			copy(pix[x*4:], []float32{r, g, b, 1})
+		}
+	}
+	return img, nil
+}
+
+// readRLERGBE reads one scanline that may use the new run-length encoding.
+// It reports true when the file turns out to be flat, in which case the
+// rest of the image is read as flat pixels.
+func readRLERGBE(br *bufio.Reader, scan []byte) (bool, error) {
+	width := len(scan) / 4
+	if _, err := io.ReadFull(br, scan[:4]); err != nil {
+		return false, hdrFormatError("truncated pixel data")
+	}
+	if scan[0] != 2 || scan[1] != 2 || scan[2]&0x80 != 0 {
+		return true, readFlatRGBE(br, scan, 1)
+	}
+	if int(scan[2])<<8|int(scan[3]) != width {
+		return false, hdrFormatError("scanline width mismatch")
+	}
+
+	// Each of the four components is run-length encoded separately
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := br.ReadByte()
+			if err != nil {
+				return false, hdrFormatError("truncated pixel data")
+			}
+			n := int(count)
+			if n > 128 {
+				n -= 128
+				if n > width-x {
+					return false, hdrFormatError("run overflows the scanline")
+				}
+				v, err := br.ReadByte()
+				if err != nil {
+					return false, hdrFormatError("truncated pixel data")
+				}
+				for ; n > 0; n-- {
+					scan[x*4+c] = v
+					x++
+				}
+				continue
+			}
+			if n == 0 || n > width-x {
+				return false, hdrFormatError("invalid literal run")
+			}
+			for ; n > 0; n-- {
+				v, err := br.ReadByte()
+				if err != nil {
+					return false, hdrFormatError("truncated pixel data")
+				}
+				scan[x*4+c] = v
+				x++
+			}
+		}
+	}
+	return false, nil
+}
+
+// readFlatRGBE reads uncompressed pixels into scan starting at pixel
+// start, expanding old-style runs of 1,1,1,n repeat markers
+func readFlatRGBE(br *bufio.Reader, scan []byte, start int) error {
+	width := len(scan) / 4
+	shift := 0
+	for x := start; x < width; {
+		p := scan[x*4 : x*4+4]
+		if _, err := io.ReadFull(br, p); err != nil {
+			return hdrFormatError("truncated pixel data")
+		}
+		if p[0] == 1 && p[1] == 1 && p[2] == 1 {
+			if x == 0 {
+				return hdrFormatError("repeat marker at the start of a scanline")
+			}
+			n := int(p[3]) << shift
+			if n > width-x {
+				return hdrFormatError("run overflows the scanline")
+			}
+			for ; n > 0; n-- {
+				copy(scan[x*4:x*4+4], scan[x*4-4:x*4])
+				x++
+			}
+			shift += 8
+			continue
+		}
+		shift = 0
+		x++
+	}
+	return nil
+}
+
+// rgbeToFloat decodes a shared-exponent pixel, centering each mantissa in
+// its quantization step
+func rgbeToFloat(p []byte) (r, g, b float32) {
+	if p[3] == 0 {
+		return 0, 0, 0
+	}
+	f := math.Ldexp(1, int(p[3])-(128+8))
+	return float32((float64(p[0]) + 0.5) * f), float32((float64(p[1]) + 0.5) * f), float32((float64(p[2]) + 0.5) * f)
+}
+
+// floatToRGBE encodes a linear color with a shared exponent
+func floatToRGBE(r, g, b float32, p []byte) {
+	r, g, b = max(r, 0), max(g, 0), max(b, 0)
+	v := float64(max(r, g, b))
+	if v < 1e-32 {
+		p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+		return
+	}
+	m, e := math.Frexp(v)
+	if e > 127 {
+		m, e = 255.0/256, 127
+		v = math.Ldexp(m, e)
+	}
+	scale := m * 256 / v
+	p[0] = uint8(math.Min(float64(r)*scale, 255))
+	p[1] = uint8(math.Min(float64(g)*scale, 255))
+	p[2] = uint8(math.Min(float64(b)*scale, 255))
+	p[3] = uint8(e + 128)
+}
+
+// linearFloatImage returns img as linear light floats. *RGBAF32 images are
+// taken to be linear already; everything else is decoded from sRGB.
+func linearFloatImage(img image.Image) *RGBAF32 {
+	if f, ok := img.(*RGBAF32); ok {
+		return f
+	}
+	f := ToRGBAF32(img)
+	for i := 0; i < len(f.Pix); i += 4 {
+		a := f.Pix[i+3]
+		if a <= 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			f.Pix[i+c] = float32(srgbToLinear(float64(f.Pix[i+c]/a))) * a
+		}
+	}
+	return f
+}
+
+// EncodeHDR writes img as a run-length encoded Radiance .hdr file. *RGBAF32
+// images are written as linear radiance; other images are decoded from
+// sRGB first. RGBE has no alpha, so colors are unpremultiplied.
+func EncodeHDR(w io.Writer, img image.Image) error {
+	src := linearFloatImage(img)
+	b := src.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", height, width)
+
+	scan := make([]byte, width*4)
+	component := make([]byte, width)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := 0; x < width; x++ {
+			r, g, bl, a := src.FloatAt(b.Min.X+x, y)
+			if a > 0 {
+				r, g, bl = r/a, g/a, bl/a
+			}
+			floatToRGBE(r, g, bl, scan[x*4:])
+		}
+		if width < 8 || width > 0x7fff {
+			bw.Write(scan)
+			continue
+		}
+		bw.Write([]byte{2, 2, byte(width >> 8), byte(width)})
+		for c := 0; c < 4; c++ {
+			for x := 0; x < width; x++ {
+				component[x] = scan[x*4+c]
+			}
+			writeRLEBytes(bw, component)
+		}
+	}
+	return bw.Flush()
+}
+
+// writeRLEBytes run-length encodes one scanline component the way
+// Radiance does: runs of four or more bytes become runs, the rest literals
+func writeRLEBytes(bw *bufio.Writer, data []byte) {
+	const minRun = 4
+	n := len(data)
+	for cur := 0; cur < n; {
+		// Find the next run of at least minRun bytes
+		begin, run, oldRun := cur, 0, 0
+		for run < minRun && begin < n {
+			begin += run
+			oldRun = run
+			run = 1
+			for begin+run < n && run < 127 && data[begin] == data[begin+run] {
+				run++
+			}
+		}
+		// A short run right before it is still worth encoding as a run
+		if oldRun > 1 && oldRun == begin-cur {
+			bw.Write([]byte{byte(128 + oldRun), data[cur]})
+			cur = begin
+		}
+		for cur < begin {
+			count := min(begin-cur, 128)
+			bw.WriteByte(byte(count))
+			bw.Write(data[cur : cur+count])
+			cur += count
+		}
+		if run >= minRun {
+			bw.Write([]byte{byte(128 + run), data[begin]})
+			cur += run
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package core
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// hdrScene returns a float image with smooth gradients, flat areas and
+// values far above 1
+func hdrScene(w, h int, alpha bool) *RGBAF32 {
+	img := NewRGBAF32(image.Rect(0, 0, w, h))
+	rng := rand.New(rand.NewSource(7))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := float32(math.Exp2(float64(x)/float64(w)*16 - 8))
+			a := float32(1)
+			if alpha && y%3 == 0 {
+				a = float32(x%5) / 4
+			}
+			r, g, b := v, v*float32(y+1)/float32(h), float32(rng.Float64())
+			if y > h/2 {
+				r, g, b = 0.5, 0.25, 0.125
+			}
+			img.SetFloat(x, y, r*a, g*a, b*a, a)
+		}
+	}
+	return img
+}
+
+func TestRadianceHDRRoundTrip(t *testing.T) {
+	for _, w := range []int{5, 40} { // flat and run-length encoded scanlines
+		src := hdrScene(w, 9, false)
+		var buf bytes.Buffer
+		if err := EncodeHDR(&buf, src); err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeHDR(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Bounds() != src.Bounds() {
+			t.Fatalf("bounds = %v", got.Bounds())
+		}
+		for i := range src.Pix {
+			want, have := float64(src.Pix[i]), float64(got.Pix[i])
+			// The shared exponent leaves 8 bits for the brightest channel
+			maxChannel := float64(max(src.Pix[i&^3], src.Pix[i&^3+1], src.Pix[i&^3+2]))
+			if math.Abs(have-want) > maxChannel/256+1e-30 {
+				t.Fatalf("width %d sample %d = %g, want %g", w, i, have, want)
+			}
+		}
+	}
+}
+
+func TestRadianceHDRDecode(t *testing.T) {
+	// A hand-written bottom-up file: one flat pixel and a repeat marker
+	data := []byte("#?RGBE\nEXPOSURE=1\n\n+Y 2 +X 3\n")
+	data = append(data, 128, 64, 32, 129, 1, 1, 1, 2) // 1, 0.5, 0.25 then 2 repeats
+	data = append(data, 0, 0, 0, 0, 128, 128, 128, 128, 1, 1, 1, 1)
+	img, err := DecodeHDR(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 3; x++ {
+		r, g, b, a := img.FloatAt(x, 1)
+		if math.Abs(float64(r)-1) > 0.01 || math.Abs(float64(g)-0.5) > 0.01 || math.Abs(float64(b)-0.25) > 0.01 || a != 1 {
+			t.Errorf("bottom row pixel %d = %v %v %v %v", x, r, g, b, a)
+		}
+	}
+	if r, _, _, _ := img.FloatAt(2, 0); math.Abs(float64(r)-0.5) > 0.01 {
+		t.Errorf("repeated pixel = %v", r)
+	}
+
+	if _, err := DecodeHDR(bytes.NewReader([]byte("P6\n"))); err == nil {
+		t.Error("expected an error for a file without a signature")
+	}
+	if _, err := DecodeHDR(bytes.NewReader(data[:len(data)-3])); err == nil {
+		t.Error("expected an error for truncated pixel data")
+	}
+}
+
+func TestHalfFloat(t *testing.T) {
+	for _, v := range []float32{0, 1, -2, 0.5, 65504, 1.0 / (1 << 24), 6.1035156e-05} {
+		if got := halfToFloat32(float32ToHalf(v)); got != v {
+			t.Errorf("half(%g) = %g", v, got)
+		}
+	}
+	if h := float32ToHalf(1e6); h != 0x7c00 {
+		t.Errorf("overflow = %#x, want infinity", h)
+	}
+	// 1 + 2^-11 lies halfway between two halves and rounds to even
+	if h := float32ToHalf(1 + 1.0/(1<<11)); h != 0x3c00 {
+		t.Errorf("tie = %#x", h)
+	}
+	for h := 0; h < 0x7c00; h++ {
+		if back := float32ToHalf(halfToFloat32(uint16(h))); back != uint16(h) {
+			t.Fatalf("half %#x came back as %#x", h, back)
+		}
+	}
+}
+
+func TestEXRRoundTrip(t *testing.T) {
+	src := hdrScene(67, 45, true)
+	for _, comp := range []EXRCompression{EXRNoCompression, EXRZIPCompression, EXRPIZCompression} {
+		for _, float := range []bool{false, true} {
+			var buf bytes.Buffer
+			if err := EncodeEXR(&buf, src, &EXROptions{Compression: comp, Float: float}); err != nil {
+				t.Fatal(err)
+			}
+			size := buf.Len()
+			got, err := DecodeEXR(&buf)
+			if err != nil {
+				t.Fatalf("compression %d: %v", comp, err)
+			}
+			for i := range src.Pix {
+				want := src.Pix[i]
+				if !float {
+					want = halfToFloat32(float32ToHalf(want))
+				}
+				if got.Pix[i] != want {
+					t.Fatalf("compression %d float %v: sample %d = %g, want %g", comp, float, i, got.Pix[i], want)
+				}
+			}
+			if raw := 67 * 45 * 4 * 2; comp != EXRNoCompression && !float && size >= raw {
+				t.Errorf("compression %d did not compress: %d bytes", comp, size)
+			}
+		}
+	}
+}
+
+func TestEXRDecodeOpaqueAndErrors(t *testing.T) {
+	// An opaque 8-bit image is written without alpha, in linear light
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	src.SetRGBA(1, 1, color.RGBA{188, 0, 0, 255})
+	path := filepath.Join(t.TempDir(), "flat.exr")
+	if err := SaveEXR(path, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	img, err := LoadEXR(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, _, a := img.FloatAt(1, 1); math.Abs(float64(r)-0.5) > 0.01 || g != 0 || a != 1 {
+		t.Errorf("pixel = %v %v alpha %v", r, g, a)
+	}
+
+	var buf bytes.Buffer
+	EncodeEXR(&buf, hdrScene(8, 8, false), &EXROptions{Compression: EXRPIZCompression})
+	data := buf.Bytes()
+	for _, cut := range []int{3, 60, len(data) - 20} {
+		if _, err := DecodeEXR(bytes.NewReader(data[:cut])); err == nil {
+			t.Errorf("expected an error for a file cut at %d bytes", cut)
+		}
+	}
+
+	// A short file declaring a 2 GB attribute fails without allocating it
+	huge := []byte("v/1\x01\x02\x00\x00\x00a\x00b\x00\xff\xff\xff\x7f")
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := DecodeEXR(bytes.NewReader(huge)); err == nil {
+		t.Error("expected an error for an attribute past the end of the file")
+	}
+	runtime.ReadMemStats(&after)
+	if grown := after.TotalAlloc - before.TotalAlloc; grown > 1<<20 {
+		t.Errorf("decoding a %d-byte file allocated %d bytes", len(huge), grown)
+	}
+	if err := EncodeEXR(&buf, src, &EXROptions{Compression: 7}); err == nil {
+		t.Error("expected an error for an unsupported compression")
+	}
+}
+
+func TestPIZWavelet(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, size := range [][2]int{{1, 1}, {7, 3}, {32, 32}, {13, 29}} {
+		for _, mx := range []uint16{1000, 0xffff} {
+			n := size[0] * size[1]
+			data := make([]uint16, n)
+			for i := range data {
+				data[i] = uint16(rng.Intn(int(mx) + 1))
+			}
+			work := append([]uint16(nil), data...)
+			wav2Encode(work, size[0], 1, size[1], size[0], mx)
+			wav2Decode(work, size[0], 1, size[1], size[0], mx)
+			for i := range data {
+				if work[i] != data[i] {
+					t.Fatalf("%v max %d: word %d = %d, want %d", size, mx, i, work[i], data[i])
+				}
+			}
+		}
+	}
+
+	// Long runs and rare symbols exercise run codes and long Huffman codes
+	words := make([]uint16, 5000)
+	for i := range words {
+		switch {
+		case i < 3000:
+			words[i] = 7
+		case i%97 == 0:
+			words[i] = uint16(rng.Intn(65536))
+		default:
+			words[i] = uint16(i % 40)
+		}
+	}
+	got, err := hufUncompress(hufCompress(words), len(words))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range words {
+		if got[i] != words[i] {
+			t.Fatalf("word %d = %d, want %d", i, got[i], words[i])
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package core
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Merging exposure brackets into a high dynamic range image, after Debevec
+// and Malik, "Recovering High Dynamic Range Radiance Maps from Photographs"
+
+// CameraResponse holds the log exposure that produces each 8-bit value,
+// per channel: the g curve of Debevec and Malik, with g(128) = 0
+type CameraResponse [3][256]float64
+
+// DebevecOptions configures RecoverCameraResponse and MergeDebevec
+type DebevecOptions struct {
+	// Lambda weighs the smoothness of the recovered curve, 10 by default
+	Lambda float64
+	// Samples is the number of pixel locations the curve is recovered
+	// from; by default enough to constrain every value
+	Samples int
+	// Response skips recovery in MergeDebevec and uses a known curve
+	Response *CameraResponse
+}
+
+// debevecWeight is the hat function that trusts midtones most. It stays
+// positive at the extremes so pixels clipped in every exposure still merge.
+func debevecWeight(z uint8) float64 {
+	if z < 128 {
+		return float64(z) + 1
+	}
+	return 256 - float64(z)
+}
+
+// bracketPixels validates an exposure bracket and converts it to 8 bits
+func bracketPixels(exposures []image.Image, times []float64, minImages int) ([]*image.RGBA, error) {
+	if len(exposures) < minImages {
+		return nil, NewInvalidParameterError("exposures", len(exposures), fmt.Sprintf("at least %d images", minImages))
+	}
+	if len(times) != len(exposures) {
+		return nil, NewInvalidParameterError("times", len(times), "one exposure time per image")
+	}
+	bounds := exposures[0].Bounds()
+	pixels := make([]*image.RGBA, len(exposures))
+	for i, img := range exposures {
+		if times[i] <= 0 {
+			return nil, NewInvalidParameterError("times", times[i], "positive exposure times")
+		}
+		if img.Bounds().Size() != bounds.Size() {
+			return nil, NewInvalidParameterError("exposures", img.Bounds(), "images of the same size")
+		}
+		pixels[i] = imageToRGBA(img)
+	}
+	return pixels, nil
+}
+
+// RecoverCameraResponse solves for the response curve of the camera that
+// took an aligned exposure bracket. times are exposure times in seconds,
+// or any values proportional to them.
+func RecoverCameraResponse(exposures []image.Image, times []float64, opts DebevecOptions) (*CameraResponse, error) {
+	pixels, err := bracketPixels(exposures, times, 2)
+	if err != nil {
+		return nil, err
+	}
+	return recoverResponse(pixels, times, opts)
+}
+
+func recoverResponse(pixels []*image.RGBA, times []float64, opts DebevecOptions) (*CameraResponse, error) {
+	lambda := opts.Lambda
+	if lambda <= 0 {
+		lambda = 10
+	}
+	size := pixels[0].Bounds().Size()
+	samples := opts.Samples
+	if samples <= 0 {
+		// Debevec and Malik need N(P-1) > 255 equations; use twice that
+		samples = max(100, 2*256/(len(pixels)-1)+1)
+	}
+	samples = min(samples, size.X*size.Y)
+
+	// Spread the sample locations over a regular grid
+	cols := max(1, int(math.Sqrt(float64(samples)*float64(size.X)/float64(size.Y))))
+	rows := (samples + cols - 1) / cols
+	points := make([]image.Point, 0, samples)
+	for r := 0; r < rows && len(points) < samples; r++ {
+		for c := 0; c < cols && len(points) < samples; c++ {
+			points = append(points, image.Pt((2*c+1)*size.X/(2*cols), (2*r+1)*size.Y/(2*rows)))
+		}
+	}
+
+	response := &CameraResponse{}
+	for ch := 0; ch < 3; ch++ {
+		// Unknowns are g(0..255) and the log irradiance E of every sample.
+		// The least-squares system is accumulated as normal equations. Each
+		// E only meets the curve values of its own pixels, so it is
+		// eliminated as soon as its rows are in (a Schur complement), which
+		// leaves a 256×256 system however many samples there are.
+		const n = 256
+		ata := make([]float64, n*n)
+		atb := make([]float64, n)
+		addRow := func(idx []int, coef []float64, rhs float64) {
+			for a, i := range idx {
+				atb[i] += coef[a] * rhs
+				for b, j := range idx {
+					ata[i*n+j] += coef[a] * coef[b]
+				}
+			}
+		}
+
+		zs := make([]int, len(pixels))
+		ws := make([]float64, len(pixels))
+		for _, p := range points {
+			// Each exposure adds the row wz·g(z) - wz·E = wz·ln t
+			d, e := 1e-9, 0.0
+			for j, img := range pixels {
+				z := img.Pix[img.PixOffset(img.Rect.Min.X+p.X, img.Rect.Min.Y+p.Y)+ch]
+				wz := debevecWeight(z)
+				zs[j], ws[j] = int(z), wz*wz
+				addRow(zs[j:j+1], []float64{wz}, wz*math.Log(times[j]))
+				d += wz * wz
+				e -= wz * wz * math.Log(times[j])
+			}
+			// Eliminate E, whose coupling to g(z) is -wz²
+			for a, za := range zs {
+				atb[za] += ws[a] * e / d
+				for b, zb := range zs {
+					ata[za*n+zb] -= ws[a] * ws[b] / d
+				}
+			}
+		}
+		// Fix the scale with g(128) = 0
+		addRow([]int{128}, []float64{1}, 0)
+		// Smoothness of the curve
+		for z := 1; z < 255; z++ {
+			wz := lambda * debevecWeight(uint8(z))
+			addRow([]int{z - 1, z, z + 1}, []float64{wz, -2 * wz, wz}, 0)
+		}
+		// A tiny ridge keeps values that never occur well defined
+		for i := 0; i < n; i++ {
+			ata[i*n+i] += 1e-9
+		}
+
+		x, ok := solveCholesky(ata, atb, n)
+		if !ok {
+			return nil, NewRenderError("RecoverCameraResponse", "the response curve could not be solved")
+		}
+		copy(response[ch][:], x[:256])
+	}
+	return response, nil
+}
+
+// solveCholesky solves the symmetric positive definite system a x = b,
+// overwriting a with its factor
+func solveCholesky(a, b []float64, n int) ([]float64, bool) {
+	for j := 0; j < n; j++ {
+		d := a[j*n+j]
+		for k := 0; k < j; k++ {
+			d -= a[j*n+k] * a[j*n+k]
+		}
+		if d <= 0 {
+			return nil, false
+		}
+		d = math.Sqrt(d)
+		a[j*n+j] = d
+		parallelRows(n-j-1, 0, func(start, end int) {
+			for i := j + 1 + start; i < j+1+end; i++ {
+				s := a[i*n+j]
+				ri, rj := a[i*n:i*n+j], a[j*n:j*n+j]
+				for k := range rj {
+					s -= ri[k] * rj[k]
+				}
+				a[i*n+j] = s / d
+			}
+		})
+	}
+	x := make([]float64, n)
+	for i := 0; i < n; i++ {
+		s := b[i]
+		for k := 0; k < i; k++ {
+			s -= a[i*n+k] * x[k]
+		}
+		x[i] = s / a[i*n+i]
+	}
+	for i := n - 1; i >= 0; i-- {
+		s := x[i]
+		for k := i + 1; k < n; k++ {
+			s -= a[k*n+i] * x[k]
+		}
+		x[i] = s / a[i*n+i]
+	}
+	return x, true
+}
+
+// MergeDebevec merges an aligned exposure bracket, such as several LoadJPG
+// images, into a linear radiance map. times are exposure times in seconds.
+// The camera response is recovered from the bracket unless opts.Response
+// is set. Radiance is relative: a midtone exposed for one second is 1.
+func MergeDebevec(exposures []image.Image, times []float64, opts DebevecOptions) (*RGBAF32, error) {
+	response := opts.Response
+	minImages := 1
+	if response == nil {
+		minImages = 2
+	}
+	pixels, err := bracketPixels(exposures, times, minImages)
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		if response, err = recoverResponse(pixels, times, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	size := pixels[0].Bounds().Size()
+	logTimes := make([]float64, len(times))
+	for j, t := range times {
+		logTimes[j] = math.Log(t)
+	}
+	dst := NewRGBAF32(image.Rect(0, 0, size.X, size.Y))
+	parallelRows(size.Y, 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < size.X; x++ {
+				o := dst.PixOffset(x, y)
+				for ch := 0; ch < 3; ch++ {
+					var sum, weights float64
+					for j, img := range pixels {
+						z := img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)+ch]
+						wz := debevecWeight(z)
+						sum += wz * (response[ch][z] - logTimes[j])
+						weights += wz
+					}
+					dst.Pix[o+ch] = float32(math.Exp(sum / weights))
+				}
+				dst.Pix[o+3] = 1
+			}
+		}
+	})
+	return dst, nil
+}
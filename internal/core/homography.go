@@ -0,0 +1,359 @@
+package core
+
+import (
+	"image"
+	"math"
+	"math/rand"
+)
+
+// Robust transform estimation and feature-based image alignment
+
+// Homography is a 3x3 projective transform in row-major order
+type Homography [9]float64
+
+// IdentityHomography returns the transform that leaves points unchanged
+func IdentityHomography() Homography {
+	return Homography{1, 0, 0, 0, 1, 0, 0, 0, 1}
+}
+
+// HomographyFromMatrix converts an affine Matrix to a Homography
+func HomographyFromMatrix(m Matrix) Homography {
+	return Homography{m.XX, m.XY, m.X0, m.YX, m.YY, m.Y0, 0, 0, 1}
+}
+
+// TransformPoint maps (x, y) through the homography
+func (hm Homography) TransformPoint(x, y float64) (float64, float64) {
+	w := hm[6]*x + hm[7]*y + hm[8]
+	if w == 0 {
+		return math.Inf(1), math.Inf(1)
+	}
+	return (hm[0]*x + hm[1]*y + hm[2]) / w, (hm[3]*x + hm[4]*y + hm[5]) / w
+}
+
+// Multiply returns the transform that applies b and then hm
+func (hm Homography) Multiply(b Homography) Homography {
+	var r Homography
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			r[i*3+j] = hm[i*3]*b[j] + hm[i*3+1]*b[3+j] + hm[i*3+2]*b[6+j]
+		}
+	}
+	return r
+}
+
+// Inverse returns the inverse transform, or false if hm is singular
+func (hm Homography) Inverse() (Homography, bool) {
+	a, b, c, d, e, f, g, h, i := hm[0], hm[1], hm[2], hm[3], hm[4], hm[5], hm[6], hm[7], hm[8]
+	det := a*(e*i-f*h) - b*(d*i-f*g) + c*(d*h-e*g)
+	if math.Abs(det) < 1e-12 {
+		return Homography{}, false
+	}
+	return Homography{
+		(e*i - f*h) / det, (c*h - b*i) / det, (b*f - c*e) / det,
+		(f*g - d*i) / det, (a*i - c*g) / det, (c*d - a*f) / det,
+		(d*h - e*g) / det, (b*g - a*h) / det, (a*e - b*d) / det,
+	}, true
+}
+
+// RANSACOptions configures robust transform estimation
+type RANSACOptions struct {
+	// Threshold is the largest reprojection error, in pixels, of an inlier
+	// (default 3)
+	Threshold float64
+	// Iterations is the number of random samples tried (default 1000)
+	Iterations int
+	// Seed makes the sampling reproducible
+	Seed int64
+}
+
+func (o RANSACOptions) withDefaults() RANSACOptions {
+	if o.Threshold <= 0 {
+		o.Threshold = 3
+	}
+	if o.Iterations <= 0 {
+		o.Iterations = 1000
+	}
+	return o
+}
+
+// EstimateAffine fits the affine Matrix mapping src points onto dst with
+// RANSAC, refitting on the inliers by least squares. The returned slice
+// marks which correspondences are inliers.
+func EstimateAffine(src, dst []Point, opts RANSACOptions) (Matrix, []bool, error) {
+	hm, inliers, err := ransac(src, dst, 3, fitAffine, opts)
+	if err != nil {
+		return Identity(), nil, err
+	}
+	return Matrix{XX: hm[0], XY: hm[1], X0: hm[2], YX: hm[3], YY: hm[4], Y0: hm[5]}, inliers, nil
+}
+
+// EstimateHomography fits the Homography mapping src points onto dst with
+// RANSAC, refitting on the inliers by least squares
+func EstimateHomography(src, dst []Point, opts RANSACOptions) (Homography, []bool, error) {
+	return ransac(src, dst, 4, fitHomography, opts)
+}
+
+// ransac repeatedly fits a model to minimal random samples and keeps the one
+// with the most inliers
+func ransac(src, dst []Point, sampleSize int, fit func(src, dst []Point) (Homography, bool), opts RANSACOptions) (Homography, []bool, error) {
+	if len(src) != len(dst) {
+		return Homography{}, nil, NewInvalidParameterError("dst", len(dst), "as many points as src")
+	}
+	if len(src) < sampleSize {
+		return Homography{}, nil, NewInvalidParameterError("src", len(src), "at least enough correspondences for the model").WithContext("required", sampleSize)
+	}
+	opts = opts.withDefaults()
+	rng := rand.New(rand.NewSource(opts.Seed))
+	thresholdSq := opts.Threshold * opts.Threshold
+
+	inliersOf := func(hm Homography) ([]bool, int) {
+		mask := make([]bool, len(src))
+		count := 0
+		for i, p := range src {
+			x, y := hm.TransformPoint(p.X, p.Y)
+			if dx, dy := x-dst[i].X, y-dst[i].Y; dx*dx+dy*dy <= thresholdSq {
+				mask[i] = true
+				count++
+			}
+		}
+		return mask, count
+	}
+
+	var best []bool
+	bestCount := 0
+	sampleSrc := make([]Point, sampleSize)
+	sampleDst := make([]Point, sampleSize)
+	for iter := 0; iter < opts.Iterations; iter++ {
+		for i, idx := range rng.Perm(len(src))[:sampleSize] {
+			sampleSrc[i], sampleDst[i] = src[idx], dst[idx]
+		}
+		hm, ok := fit(sampleSrc, sampleDst)
+		if !ok {
+			continue
+		}
+		if mask, count := inliersOf(hm); count > bestCount {
+			best, bestCount = mask, count
+			if count == len(src) {
+				break
+			}
+		}
+	}
+	if bestCount < sampleSize {
+		return Homography{}, nil, NewInvalidParameterError("src", len(src), "correspondences that agree on a non-degenerate transform")
+	}
+
+	// Refit on all inliers, then recount against the refined model
+	var inSrc, inDst []Point
+	for i, ok := range best {
+		if ok {
+			inSrc, inDst = append(inSrc, src[i]), append(inDst, dst[i])
+		}
+	}
+	hm, ok := fit(inSrc, inDst)
+	if !ok {
+		return Homography{}, nil, NewInvalidParameterError("src", len(src), "correspondences that agree on a non-degenerate transform")
+	}
+	mask, _ := inliersOf(hm)
+	return hm, mask, nil
+}
+
+// fitAffine solves the least-squares affine transform, returned as a
+// Homography with a constant last row
+func fitAffine(src, dst []Point) (Homography, bool) {
+	var ata [3][3]float64
+	var atx, aty [3]float64
+	for i, p := range src {
+		row := [3]float64{p.X, p.Y, 1}
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				ata[r][c] += row[r] * row[c]
+			}
+			atx[r] += row[r] * dst[i].X
+			aty[r] += row[r] * dst[i].Y
+		}
+	}
+	a := make([][]float64, 3)
+	for r := range a {
+		a[r] = ata[r][:]
+	}
+	xs, ok := solveLinearSystem(a, atx[:])
+	if !ok {
+		return Homography{}, false
+	}
+	ys, ok := solveLinearSystem(a, aty[:])
+	if !ok {
+		return Homography{}, false
+	}
+	return Homography{xs[0], xs[1], xs[2], ys[0], ys[1], ys[2], 0, 0, 1}, true
+}
+
+// fitHomography solves the least-squares homography with the last entry
+// fixed to 1, after Hartley normalization of both point sets
+func fitHomography(src, dst []Point) (Homography, bool) {
+	ts, ok := normalizingTransform(src)
+	if !ok {
+		return Homography{}, false
+	}
+	td, ok := normalizingTransform(dst)
+	if !ok {
+		return Homography{}, false
+	}
+
+	a := make([][]float64, 8)
+	for i := range a {
+		a[i] = make([]float64, 8)
+	}
+	atb := make([]float64, 8)
+	for i := range src {
+		x, y := ts.TransformPoint(src[i].X, src[i].Y)
+		u, v := td.TransformPoint(dst[i].X, dst[i].Y)
+		rows := [2][8]float64{
+			{x, y, 1, 0, 0, 0, -x * u, -y * u},
+			{0, 0, 0, x, y, 1, -x * v, -y * v},
+		}
+		rhs := [2]float64{u, v}
+		for k, row := range rows {
+			for r := 0; r < 8; r++ {
+				for c := 0; c < 8; c++ {
+					a[r][c] += row[r] * row[c]
+				}
+				atb[r] += row[r] * rhs[k]
+			}
+		}
+	}
+	h, ok := solveLinearSystem(a, atb)
+	if !ok {
+		return Homography{}, false
+	}
+	hn := Homography{h[0], h[1], h[2], h[3], h[4], h[5], h[6], h[7], 1}
+
+	tdInv, ok := td.Inverse()
+	if !ok {
+		return Homography{}, false
+	}
+	result := tdInv.Multiply(hn).Multiply(ts)
+	if math.Abs(result[8]) < 1e-12 {
+		return Homography{}, false
+	}
+	for i := range result {
+		result[i] /= result[8]
+	}
+	return result, true
+}
+
+// normalizingTransform moves the points' centroid to the origin and scales
+// their mean distance from it to sqrt(2)
+func normalizingTransform(pts []Point) (Homography, bool) {
+	var cx, cy float64
+	for _, p := range pts {
+		cx += p.X
+		cy += p.Y
+	}
+	n := float64(len(pts))
+	cx, cy = cx/n, cy/n
+	var dist float64
+	for _, p := range pts {
+		dist += math.Hypot(p.X-cx, p.Y-cy)
+	}
+	if dist == 0 {
+		return Homography{}, false
+	}
+	s := math.Sqrt2 * n / dist
+	return Homography{s, 0, -s * cx, 0, s, -s * cy, 0, 0, 1}, true
+}
+
+// solveLinearSystem solves a x = b by Gaussian elimination with partial
+// pivoting. The inputs are left unchanged.
+func solveLinearSystem(a [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	m := make([][]float64, n)
+	for i := range m {
+		m[i] = make([]float64, n+1)
+		copy(m[i], a[i])
+		m[i][n] = b[i]
+	}
+
+	var scale float64
+	for i := range m {
+		for j := 0; j < n; j++ {
+			scale = math.Max(scale, math.Abs(m[i][j]))
+		}
+	}
+	eps := 1e-12 * math.Max(scale, 1)
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < eps {
+			return nil, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for r := col + 1; r < n; r++ {
+			f := m[r][col] / m[col][col]
+			for c := col; c <= n; c++ {
+				m[r][c] -= f * m[col][c]
+			}
+		}
+	}
+
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		s := m[r][n]
+		for c := r + 1; c < n; c++ {
+			s -= m[r][c] * x[c]
+		}
+		x[r] = s / m[r][r]
+	}
+	return x, true
+}
+
+// TransformModel selects the transform fitted when aligning images
+type TransformModel int
+
+const (
+	// TransformAffine allows translation, rotation, scale and shear
+	TransformAffine TransformModel = iota
+	// TransformHomography also allows perspective
+	TransformHomography
+)
+
+// AlignImage warps img into the frame of reference by matching ORB features
+// between them and fitting model with RANSAC. It returns the aligned image,
+// the same size as reference with uncovered areas transparent, and the
+// transform from reference pixel coordinates to img pixel coordinates.
+func AlignImage(img, reference image.Image, model TransformModel) (*image.RGBA, Homography, error) {
+	refKps, refDescs := DetectORB(reference, 1000)
+	imgKps, imgDescs := DetectORB(img, 1000)
+	matches := MatchDescriptors(refDescs, imgDescs, 0.8)
+
+	src := make([]Point, len(matches))
+	dst := make([]Point, len(matches))
+	for i, m := range matches {
+		src[i] = Point{refKps[m.Query].X, refKps[m.Query].Y}
+		dst[i] = Point{imgKps[m.Train].X, imgKps[m.Train].Y}
+	}
+
+	var hm Homography
+	var err error
+	if model == TransformHomography {
+		hm, _, err = EstimateHomography(src, dst, RANSACOptions{})
+	} else {
+		var m Matrix
+		m, _, err = EstimateAffine(src, dst, RANSACOptions{})
+		hm = HomographyFromMatrix(m)
+	}
+	if err != nil {
+		return nil, Homography{}, err
+	}
+
+	// Keypoints sit on pixel indices while warps address pixel centers
+	aligned := WarpWithOptions(img, func(x, y float64) (float64, float64) {
+		sx, sy := hm.TransformPoint(x-0.5, y-0.5)
+		return sx + 0.5, sy + 0.5
+	}, WarpOptions{Sampler: SamplerBilinear, Edge: EdgeTransparent, Size: reference.Bounds().Size()})
+	return aligned, hm, nil
+}
@@ -1,9 +1,9 @@
 package core
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
+	"image"
 	"image/color"
 	"math"
 )
@@ -24,6 +24,10 @@ type ICCProfile struct {
 	GreenColorant XYZColor
 	BlueColorant  XYZColor
 	Curves        []ToneCurve
+
+	// LUT transforms by rendering intent, from A2Bn and B2An tags
+	aToB [3]*iccLUT
+	bToA [3]*iccLUT
 }
 
 // ICCHeader represents the ICC profile header
@@ -80,10 +84,90 @@ type XYZColor struct {
 	X, Y, Z float64
 }
 
-// ToneCurve represents a tone reproduction curve
+// ToneCurve represents a tone reproduction curve. Points holds a single
+// gamma exponent or a table sampled evenly over 0..1; parametric ('para')
+// curves instead set Function, the ICC function type 0-4, and Params, its
+// g, a, b, c, d, e, f coefficients.
 type ToneCurve struct {
-	Type   uint32
-	Points []float64
+	Type     uint32
+	Points   []float64
+	Function int
+	Params   []float64
+}
+
+// Eval maps a device value in 0..1 to linear light
+func (c ToneCurve) Eval(x float64) float64 {
+	if c.Type == typeParametric && len(c.Params) > 0 {
+		return c.evalParametric(x)
+	}
+	switch len(c.Points) {
+	case 0:
+		return x
+	case 1:
+		return math.Pow(math.Max(x, 0), c.Points[0])
+	}
+	x = clamp(x, 0, 1)
+	pos := x * float64(len(c.Points)-1)
+	i := min(int(pos), len(c.Points)-2)
+	t := pos - float64(i)
+	return c.Points[i]*(1-t) + c.Points[i+1]*t
+}
+
+func (c ToneCurve) evalParametric(x float64) float64 {
+	p := make([]float64, 7)
+	copy(p, c.Params)
+	g, a, b, cc, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+	pow := func(v float64) float64 { return math.Pow(math.Max(v, 0), g) }
+	switch c.Function {
+	case 1:
+		if a != 0 && x >= -b/a {
+			return pow(a*x + b)
+		}
+		return 0
+	case 2:
+		if a != 0 && x >= -b/a {
+			return pow(a*x+b) + cc
+		}
+		return cc
+	case 3:
+		if x >= d {
+			return pow(a*x + b)
+		}
+		return cc * x
+	case 4:
+		if x >= d {
+			return pow(a*x+b) + e
+		}
+		return cc*x + f
+	}
+	return pow(x)
+}
+
+// Inverse maps linear light in 0..1 back to a device value. Curves are
+// assumed monotonic.
+func (c ToneCurve) Inverse(y float64) float64 {
+	if c.Type != typeParametric || len(c.Params) == 0 {
+		switch len(c.Points) {
+		case 0:
+			return y
+		case 1:
+			if c.Points[0] != 0 {
+				return math.Pow(math.Max(y, 0), 1/c.Points[0])
+			}
+			return y
+		}
+	}
+	lo, hi := 0.0, 1.0
+	increasing := c.Eval(1) >= c.Eval(0)
+	for i := 0; i < 40; i++ {
+		mid := (lo + hi) / 2
+		if (c.Eval(mid) < y) == increasing {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
 }
 
 // ColorSpace represents different color spaces
@@ -110,6 +194,12 @@ const (
 	TagGrayTRC       = 0x6B545243 // 'kTRC'
 	TagDescription   = 0x64657363 // 'desc'
 	TagCopyright     = 0x63707274 // 'cprt'
+	TagAToB0         = 0x41324230 // 'A2B0', perceptual device to PCS
+	TagAToB1         = 0x41324231 // 'A2B1', colorimetric device to PCS
+	TagAToB2         = 0x41324232 // 'A2B2', saturation device to PCS
+	TagBToA0         = 0x42324130 // 'B2A0', perceptual PCS to device
+	TagBToA1         = 0x42324131 // 'B2A1', colorimetric PCS to device
+	TagBToA2         = 0x42324132 // 'B2A2', saturation PCS to device
 )
 
 // Standard illuminants
@@ -131,44 +221,78 @@ func NewICCProfile() *ICCProfile {
 
 // LoadICCProfile loads an ICC profile from data
 func LoadICCProfile(data []byte) (*ICCProfile, error) {
-	if len(data) < 128 {
+	if len(data) < 132 {
 		return nil, fmt.Errorf("ICC profile too small")
 	}
 
 	profile := NewICCProfile()
-	reader := bytes.NewReader(data)
-
-	// Read header
-	if err := binary.Read(reader, binary.BigEndian, &profile.Header); err != nil {
-		return nil, fmt.Errorf("failed to read ICC header: %v", err)
-	}
+	profile.Header = parseICCHeader(data)
 
 	// Validate profile
-	if profile.Header.ProfileSize != uint32(len(data)) {
+	if int64(profile.Header.ProfileSize) > int64(len(data)) || profile.Header.ProfileSize < 132 {
 		return nil, fmt.Errorf("profile size mismatch")
 	}
+	data = data[:profile.Header.ProfileSize]
+	profile.ColorSpace = profile.Header.DataColorSpace
+	profile.PCS = profile.Header.PCS
+	profile.Intent = profile.Header.RenderingIntent
 
 	// Read tag table
-	var tagCount uint32
-	if err := binary.Read(reader, binary.BigEndian, &tagCount); err != nil {
-		return nil, fmt.Errorf("failed to read tag count: %v", err)
+	tagCount := binary.BigEndian.Uint32(data[128:])
+	if int64(tagCount)*12+132 > int64(len(data)) {
+		return nil, fmt.Errorf("tag table truncated")
 	}
 
 	profile.TagTable = make([]ICCTag, tagCount)
-	for i := uint32(0); i < tagCount; i++ {
-		if err := binary.Read(reader, binary.BigEndian, &profile.TagTable[i]); err != nil {
-			return nil, fmt.Errorf("failed to read tag %d: %v", i, err)
-		}
+	for i := range profile.TagTable {
+		entry := data[132+12*i:]
+		tag := &profile.TagTable[i]
+		copy(tag.Signature[:], entry[:4])
+		tag.Offset = binary.BigEndian.Uint32(entry[4:])
+		tag.Size = binary.BigEndian.Uint32(entry[8:])
 	}
 
 	// Parse important tags
 	profile.parseColorants(data)
 	profile.parseToneCurves(data)
+	if err := profile.parseLUTs(data); err != nil {
+		return nil, err
+	}
 
 	profile.Data = data
 	return profile, nil
 }
 
+// parseICCHeader decodes the fixed 128-byte profile header
+func parseICCHeader(data []byte) ICCHeader {
+	var h ICCHeader
+	h.ProfileSize = binary.BigEndian.Uint32(data[0:])
+	copy(h.PreferredCMM[:], data[4:8])
+	h.ProfileVersion = binary.BigEndian.Uint32(data[8:])
+	h.DeviceClass = DeviceClass(binary.BigEndian.Uint32(data[12:]))
+	h.DataColorSpace = ColorSpace(binary.BigEndian.Uint32(data[16:]))
+	h.PCS = ColorSpace(binary.BigEndian.Uint32(data[20:]))
+	copy(h.CreationDateTime[:], data[24:36])
+	copy(h.PlatformSignature[:], data[40:44])
+	h.ProfileFlags = binary.BigEndian.Uint32(data[44:])
+	copy(h.DeviceManufacturer[:], data[48:52])
+	copy(h.DeviceModel[:], data[52:56])
+	h.DeviceAttributes = binary.BigEndian.Uint64(data[56:])
+	h.RenderingIntent = RenderingIntent(binary.BigEndian.Uint32(data[64:]) & 0xFFFF)
+	h.PCSIlluminant = XYZColor{X: s15Fixed16(data[68:]), Y: s15Fixed16(data[72:]), Z: s15Fixed16(data[76:])}
+	copy(h.ProfileCreator[:], data[80:84])
+	copy(h.Reserved[:], data[84:128])
+	return h
+}
+
+// tagData returns the bytes of a tag, or nil when it lies outside data
+func tagData(data []byte, tag ICCTag) []byte {
+	if int64(tag.Offset)+int64(tag.Size) > int64(len(data)) {
+		return nil
+	}
+	return data[tag.Offset : tag.Offset+tag.Size]
+}
+
 // parseColorants parses colorant tags
 func (p *ICCProfile) parseColorants(data []byte) {
 	for _, tag := range p.TagTable {
@@ -191,34 +315,24 @@ func (p *ICCProfile) parseColorants(data []byte) {
 
 // parseXYZTag parses an XYZ tag
 func (p *ICCProfile) parseXYZTag(data []byte, tag ICCTag) XYZColor {
-	if tag.Offset+tag.Size > uint32(len(data)) {
-		return XYZColor{}
-	}
-
-	tagData := data[tag.Offset : tag.Offset+tag.Size]
+	tagData := tagData(data, tag)
 	if len(tagData) < 20 {
 		return XYZColor{}
 	}
 
-	// Skip type signature and reserved bytes
-	reader := bytes.NewReader(tagData[8:])
-
-	var x, y, z uint32
-	binary.Read(reader, binary.BigEndian, &x)
-	binary.Read(reader, binary.BigEndian, &y)
-	binary.Read(reader, binary.BigEndian, &z)
-
-	// Convert from fixed point to float
+	// Skip type signature and reserved bytes; values are s15Fixed16
 	return XYZColor{
-		X: float64(x) / 65536.0,
-		Y: float64(y) / 65536.0,
-		Z: float64(z) / 65536.0,
+		X: s15Fixed16(tagData[8:]),
+		Y: s15Fixed16(tagData[12:]),
+		Z: s15Fixed16(tagData[16:]),
 	}
 }
 
-// parseToneCurves parses tone reproduction curves
+// parseToneCurves parses tone reproduction curves into red, green, blue
+// order, or a single gray curve
 func (p *ICCProfile) parseToneCurves(data []byte) {
-	curves := make([]ToneCurve, 0, 3)
+	var rgb [3]*ToneCurve
+	var gray *ToneCurve
 
 	for _, tag := range p.TagTable {
 		signature := binary.BigEndian.Uint32(tag.Signature[:])
@@ -226,57 +340,260 @@ func (p *ICCProfile) parseToneCurves(data []byte) {
 		switch signature {
 		case TagRedTRC, TagGreenTRC, TagBlueTRC, TagGrayTRC:
 			curve := p.parseCurveTag(data, tag)
-			curves = append(curves, curve)
+			switch signature {
+			case TagRedTRC:
+				rgb[0] = &curve
+			case TagGreenTRC:
+				rgb[1] = &curve
+			case TagBlueTRC:
+				rgb[2] = &curve
+			default:
+				gray = &curve
+			}
 		}
 	}
 
+	curves := make([]ToneCurve, 0, 3)
+	if rgb[0] != nil && rgb[1] != nil && rgb[2] != nil {
+		curves = append(curves, *rgb[0], *rgb[1], *rgb[2])
+	} else if gray != nil {
+		curves = append(curves, *gray)
+	}
 	p.Curves = curves
 }
 
-// parseCurveTag parses a curve tag
+// parseCurveTag parses a 'curv' or 'para' curve tag
 func (p *ICCProfile) parseCurveTag(data []byte, tag ICCTag) ToneCurve {
-	if tag.Offset+tag.Size > uint32(len(data)) {
+	curve, _, err := parseCurve(tagData(data, tag))
+	if err != nil {
 		return ToneCurve{}
 	}
+	return curve
+}
 
-	tagData := data[tag.Offset : tag.Offset+tag.Size]
-	if len(tagData) < 12 {
-		return ToneCurve{}
+// parseLUTs parses the A2Bn and B2An transforms
+func (p *ICCProfile) parseLUTs(data []byte) error {
+	for _, tag := range p.TagTable {
+		signature := binary.BigEndian.Uint32(tag.Signature[:])
+		var slot **iccLUT
+		xyzInput := false
+		switch signature {
+		case TagAToB0, TagAToB1, TagAToB2:
+			slot = &p.aToB[signature-TagAToB0]
+		case TagBToA0, TagBToA1, TagBToA2:
+			slot = &p.bToA[signature-TagBToA0]
+			xyzInput = p.PCS == ColorSpaceXYZ
+		default:
+			continue
+		}
+		lut, err := parseLUT(tagData(data, tag), xyzInput)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s tag: %v", sigString(signature), err)
+		}
+		*slot = lut
 	}
+	return nil
+}
 
-	reader := bytes.NewReader(tagData)
+// Channels returns the number of device channels of the profile's color space
+func (p *ICCProfile) Channels() int {
+	switch p.ColorSpace {
+	case ColorSpaceCMYK:
+		return 4
+	case ColorSpaceGray:
+		return 1
+	}
+	return 3
+}
 
-	var typeSignature uint32
-	var reserved uint32
-	var count uint32
+// HasLUT reports whether the profile converts with lookup tables rather
+// than colorants and tone curves for the intent
+func (p *ICCProfile) HasLUT(intent RenderingIntent) bool {
+	return pickLUT(p.aToB, intent) != nil
+}
 
-	binary.Read(reader, binary.BigEndian, &typeSignature)
-	binary.Read(reader, binary.BigEndian, &reserved)
-	binary.Read(reader, binary.BigEndian, &count)
+// pickLUT returns the table for the intent, falling back to the perceptual
+// table as the ICC specification requires. Absolute colorimetric uses the
+// relative colorimetric table.
+func pickLUT(tables [3]*iccLUT, intent RenderingIntent) *iccLUT {
+	i := int(intent)
+	if intent == IntentAbsoluteColorimetric {
+		i = 1
+	}
+	if i >= 0 && i < len(tables) && tables[i] != nil {
+		return tables[i]
+	}
+	return tables[0]
+}
 
-	curve := ToneCurve{
-		Type:   typeSignature,
-		Points: make([]float64, count),
+// toPCS converts device values in 0..1 to PCS XYZ, relative to D50
+func (p *ICCProfile) toPCS(in []float64, intent RenderingIntent) XYZColor {
+	if lut := pickLUT(p.aToB, intent); lut != nil && len(in) >= lut.inputs {
+		return p.decodePCS(lut.eval(in[:lut.inputs]), lut.legacyLab)
 	}
 
-	if count == 0 {
-		// Linear curve
-		curve.Points = []float64{0.0, 1.0}
-	} else if count == 1 {
-		// Gamma curve
-		var gamma uint16
-		binary.Read(reader, binary.BigEndian, &gamma)
-		curve.Points = []float64{float64(gamma) / 256.0}
-	} else {
-		// Table curve
-		for i := uint32(0); i < count; i++ {
-			var value uint16
-			binary.Read(reader, binary.BigEndian, &value)
-			curve.Points[i] = float64(value) / 65535.0
+	switch p.ColorSpace {
+	case ColorSpaceGray:
+		y := in[0]
+		if len(p.Curves) > 0 {
+			y = p.Curves[0].Eval(y)
 		}
+		return XYZColor{X: IlluminantD50.X * y, Y: y, Z: IlluminantD50.Z * y}
+	case ColorSpaceLab:
+		return labToPCS(in[0]*100, in[1]*255-128, in[2]*255-128)
+	case ColorSpaceXYZ:
+		return XYZColor{X: in[0] * xyzEncodingScale, Y: in[1] * xyzEncodingScale, Z: in[2] * xyzEncodingScale}
+	case ColorSpaceCMYK:
+		// No tables: fall back to naive conversion through sRGB
+		r, g, b := color.CMYKToRGB(uint8(in[0]*255+0.5), uint8(in[1]*255+0.5), uint8(in[2]*255+0.5), uint8(in[3]*255+0.5))
+		return srgbProfile.toPCS([]float64{float64(r) / 255, float64(g) / 255, float64(b) / 255}, intent)
 	}
 
-	return curve
+	r, g, b := in[0], in[1], in[2]
+	if len(p.Curves) >= 3 {
+		r = p.Curves[0].Eval(r)
+		g = p.Curves[1].Eval(g)
+		b = p.Curves[2].Eval(b)
+	}
+	out := mulMatrix3(p.colorantMatrix(), [3]float64{r, g, b})
+	return XYZColor{X: out[0], Y: out[1], Z: out[2]}
+}
+
+// fromPCS converts PCS XYZ, relative to D50, to device values in 0..1
+func (p *ICCProfile) fromPCS(xyz XYZColor, intent RenderingIntent) []float64 {
+	if lut := pickLUT(p.bToA, intent); lut != nil {
+		return lut.eval(p.encodePCS(xyz, lut.legacyLab))
+	}
+
+	switch p.ColorSpace {
+	case ColorSpaceGray:
+		y := clamp(xyz.Y, 0, 1)
+		if len(p.Curves) > 0 {
+			y = p.Curves[0].Inverse(y)
+		}
+		return []float64{y}
+	case ColorSpaceLab:
+		lab := pcsToLab(xyz)
+		return []float64{clamp(lab[0]/100, 0, 1), clamp((lab[1]+128)/255, 0, 1), clamp((lab[2]+128)/255, 0, 1)}
+	case ColorSpaceXYZ:
+		return []float64{clamp(xyz.X/xyzEncodingScale, 0, 1), clamp(xyz.Y/xyzEncodingScale, 0, 1), clamp(xyz.Z/xyzEncodingScale, 0, 1)}
+	case ColorSpaceCMYK:
+		rgb := srgbProfile.fromPCS(xyz, intent)
+		c, m, y, k := color.RGBToCMYK(uint8(rgb[0]*255+0.5), uint8(rgb[1]*255+0.5), uint8(rgb[2]*255+0.5))
+		return []float64{float64(c) / 255, float64(m) / 255, float64(y) / 255, float64(k) / 255}
+	}
+
+	inv, ok := invertMatrix3(p.colorantMatrix())
+	if !ok {
+		return []float64{0, 0, 0}
+	}
+	rgb := mulMatrix3(inv, [3]float64{xyz.X, xyz.Y, xyz.Z})
+	out := make([]float64, 3)
+	for i, v := range rgb {
+		v = clamp(v, 0, 1)
+		if len(p.Curves) >= 3 {
+			v = p.Curves[i].Inverse(v)
+		}
+		out[i] = clamp(v, 0, 1)
+	}
+	return out
+}
+
+// colorantMatrix returns the matrix mapping linear RGB to PCS XYZ
+func (p *ICCProfile) colorantMatrix() [3][3]float64 {
+	return [3][3]float64{
+		{p.RedColorant.X, p.GreenColorant.X, p.BlueColorant.X},
+		{p.RedColorant.Y, p.GreenColorant.Y, p.BlueColorant.Y},
+		{p.RedColorant.Z, p.GreenColorant.Z, p.BlueColorant.Z},
+	}
+}
+
+// xyzEncodingScale converts normalized 16-bit PCS XYZ, where 0x8000 is 1.0
+const xyzEncodingScale = 65535.0 / 32768
+
+// decodePCS converts normalized LUT output to PCS XYZ
+func (p *ICCProfile) decodePCS(v []float64, legacy bool) XYZColor {
+	if len(v) < 3 {
+		return XYZColor{}
+	}
+	if p.PCS == ColorSpaceLab {
+		scale := 1.0
+		if legacy {
+			scale = 65535.0 / 65280
+		}
+		return labToPCS(v[0]*scale*100, v[1]*scale*255-128, v[2]*scale*255-128)
+	}
+	return XYZColor{X: v[0] * xyzEncodingScale, Y: v[1] * xyzEncodingScale, Z: v[2] * xyzEncodingScale}
+}
+
+// encodePCS converts PCS XYZ to normalized LUT input
+func (p *ICCProfile) encodePCS(xyz XYZColor, legacy bool) []float64 {
+	if p.PCS == ColorSpaceLab {
+		scale := 1.0
+		if legacy {
+			scale = 65280.0 / 65535
+		}
+		lab := pcsToLab(xyz)
+		return []float64{
+			clamp(lab[0]/100*scale, 0, 1),
+			clamp((lab[1]+128)/255*scale, 0, 1),
+			clamp((lab[2]+128)/255*scale, 0, 1),
+		}
+	}
+	return []float64{
+		clamp(xyz.X/xyzEncodingScale, 0, 1),
+		clamp(xyz.Y/xyzEncodingScale, 0, 1),
+		clamp(xyz.Z/xyzEncodingScale, 0, 1),
+	}
+}
+
+// labToPCS converts CIE Lab relative to D50 to XYZ
+func labToPCS(l, a, b float64) XYZColor {
+	fy := (l + 16) / 116
+	fx := a/500 + fy
+	fz := fy - b/200
+	return XYZColor{
+		X: labFInv(fx) * IlluminantD50.X,
+		Y: labFInv(fy) * IlluminantD50.Y,
+		Z: labFInv(fz) * IlluminantD50.Z,
+	}
+}
+
+// pcsToLab converts XYZ to CIE Lab relative to D50
+func pcsToLab(xyz XYZColor) [3]float64 {
+	fx := labF(xyz.X / IlluminantD50.X)
+	fy := labF(xyz.Y / IlluminantD50.Y)
+	fz := labF(xyz.Z / IlluminantD50.Z)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// blackPoint returns the darkest neutral the profile reproduces, in PCS XYZ.
+// Output profiles with tables use the round trip of PCS black, which lands
+// on the device's maximum ink black.
+func (p *ICCProfile) blackPoint(intent RenderingIntent) XYZColor {
+	var xyz XYZColor
+	switch p.ColorSpace {
+	case ColorSpaceCMYK:
+		if pickLUT(p.bToA, intent) != nil {
+			xyz = p.toPCS(p.fromPCS(XYZColor{}, intent), intent)
+		} else {
+			xyz = p.toPCS([]float64{1, 1, 1, 1}, intent)
+		}
+	case ColorSpaceRGB, ColorSpaceGray:
+		xyz = p.toPCS(make([]float64, p.Channels()), intent)
+	default:
+		return XYZColor{}
+	}
+	y := math.Max(xyz.Y, 0)
+	return XYZColor{X: IlluminantD50.X * y, Y: y, Z: IlluminantD50.Z * y}
+}
+
+// mediaWhite returns the profile's media white point, D50 when unset
+func (p *ICCProfile) mediaWhite() XYZColor {
+	if p.WhitePoint.Y <= 0 {
+		return IlluminantD50
+	}
+	return p.WhitePoint
 }
 
 // ColorConverter handles color space conversions using ICC profiles
@@ -284,6 +601,10 @@ type ColorConverter struct {
 	SourceProfile *ICCProfile
 	DestProfile   *ICCProfile
 	Intent        RenderingIntent
+	// BlackPointCompensation maps the source black onto the destination
+	// black so shadow detail is kept. It has no effect with the absolute
+	// colorimetric intent.
+	BlackPointCompensation bool
 }
 
 // NewColorConverter creates a new color converter
@@ -295,165 +616,259 @@ func NewColorConverter(source, dest *ICCProfile) *ColorConverter {
 	}
 }
 
-// ConvertColor converts a color from source to destination profile
-func (cc *ColorConverter) ConvertColor(c color.Color) color.Color {
-	// Convert to RGBA first
-	r, g, b, a := c.RGBA()
-	rf := float64(r) / 65535.0
-	gf := float64(g) / 65535.0
-	bf := float64(b) / 65535.0
-	af := float64(a) / 65535.0
+// Convert converts device values in 0..1 from the source to the
+// destination profile. Values are in the profiles' channel order: RGB,
+// CMYK or a single gray.
+func (cc *ColorConverter) Convert(in []float64) []float64 {
+	return cc.transform()(in)
+}
 
-	// Convert source RGB to XYZ
-	xyz := cc.rgbToXYZ(rf, gf, bf, cc.SourceProfile)
+// transform prepares the conversion, resolving black points once
+func (cc *ColorConverter) transform() func([]float64) []float64 {
+	src, dst, intent := cc.SourceProfile, cc.DestProfile, cc.Intent
+	channels := src.Channels()
 
-	// Apply chromatic adaptation if needed
-	if cc.SourceProfile.WhitePoint != cc.DestProfile.WhitePoint {
-		xyz = cc.chromaticAdaptation(xyz, cc.SourceProfile.WhitePoint, cc.DestProfile.WhitePoint)
+	var srcBlack, dstBlack XYZColor
+	bpc := cc.BlackPointCompensation && intent != IntentAbsoluteColorimetric
+	if bpc {
+		srcBlack, dstBlack = src.blackPoint(intent), dst.blackPoint(intent)
 	}
+	srcWhite, dstWhite := src.mediaWhite(), dst.mediaWhite()
 
-	// Convert XYZ to destination RGB
-	rf, gf, bf = cc.xyzToRGB(xyz, cc.DestProfile)
-
-	// Clamp values
-	rf = math.Max(0, math.Min(1, rf))
-	gf = math.Max(0, math.Min(1, gf))
-	bf = math.Max(0, math.Min(1, bf))
-
-	return color.RGBA{
-		R: uint8(rf * 255),
-		G: uint8(gf * 255),
-		B: uint8(bf * 255),
-		A: uint8(af * 255),
+	return func(in []float64) []float64 {
+		if len(in) < channels {
+			padded := make([]float64, channels)
+			copy(padded, in)
+			in = padded
+		}
+		xyz := src.toPCS(in, intent)
+		switch {
+		case intent == IntentAbsoluteColorimetric:
+			// Relative PCS is scaled to the source media white, then back
+			// from the destination's
+			xyz = XYZColor{
+				X: xyz.X * srcWhite.X / dstWhite.X,
+				Y: xyz.Y * srcWhite.Y / dstWhite.Y,
+				Z: xyz.Z * srcWhite.Z / dstWhite.Z,
+			}
+		case bpc:
+			xyz = compensateBlackPoint(xyz, srcBlack, dstBlack)
+		}
+		return dst.fromPCS(xyz, intent)
 	}
 }
 
-// rgbToXYZ converts RGB to XYZ using the profile's colorants and curves
-func (cc *ColorConverter) rgbToXYZ(r, g, b float64, profile *ICCProfile) XYZColor {
-	// Apply inverse tone curves
-	if len(profile.Curves) >= 3 {
-		r = cc.applyInverseCurve(r, profile.Curves[0])
-		g = cc.applyInverseCurve(g, profile.Curves[1])
-		b = cc.applyInverseCurve(b, profile.Curves[2])
+// compensateBlackPoint scales XYZ linearly so the source black point maps
+// to the destination black point while D50 white stays fixed
+func compensateBlackPoint(xyz, src, dst XYZColor) XYZColor {
+	adjust := func(v, s, d, w float64) float64 {
+		if math.Abs(w-s) < 1e-9 {
+			return v
+		}
+		return (v-s)*(w-d)/(w-s) + d
+	}
+	return XYZColor{
+		X: adjust(xyz.X, src.X, dst.X, IlluminantD50.X),
+		Y: adjust(xyz.Y, src.Y, dst.Y, IlluminantD50.Y),
+		Z: adjust(xyz.Z, src.Z, dst.Z, IlluminantD50.Z),
 	}
-
-	// Matrix transformation using colorants
-	x := r*profile.RedColorant.X + g*profile.GreenColorant.X + b*profile.BlueColorant.X
-	y := r*profile.RedColorant.Y + g*profile.GreenColorant.Y + b*profile.BlueColorant.Y
-	z := r*profile.RedColorant.Z + g*profile.GreenColorant.Z + b*profile.BlueColorant.Z
-
-	return XYZColor{X: x, Y: y, Z: z}
 }
 
-// xyzToRGB converts XYZ to RGB using the profile's colorants and curves
-func (cc *ColorConverter) xyzToRGB(xyz XYZColor, profile *ICCProfile) (float64, float64, float64) {
-	// Inverse matrix transformation
-	// This is a simplified implementation - real ICC would use proper matrix inversion
-	r := xyz.X/profile.RedColorant.X + xyz.Y/profile.RedColorant.Y + xyz.Z/profile.RedColorant.Z
-	g := xyz.X/profile.GreenColorant.X + xyz.Y/profile.GreenColorant.Y + xyz.Z/profile.GreenColorant.Z
-	b := xyz.X/profile.BlueColorant.X + xyz.Y/profile.BlueColorant.Y + xyz.Z/profile.BlueColorant.Z
+// ConvertColor converts a color from source to destination profile. CMYK
+// destinations return color.CMYK and gray destinations color.Gray; a
+// color.CMYK is read directly when the source is CMYK.
+func (cc *ColorConverter) ConvertColor(c color.Color) color.Color {
+	in, alpha := deviceValues(c, cc.SourceProfile.ColorSpace)
+	return deviceColor(cc.Convert(in), alpha, cc.DestProfile.ColorSpace)
+}
 
-	// Apply tone curves
-	if len(profile.Curves) >= 3 {
-		r = cc.applyCurve(r, profile.Curves[0])
-		g = cc.applyCurve(g, profile.Curves[1])
-		b = cc.applyCurve(b, profile.Curves[2])
+// ConvertImage converts a whole image. The result is an *image.CMYK for
+// CMYK destinations, an *image.Gray for gray ones and an *image.RGBA
+// otherwise. RGB to RGB conversions go through a precomputed 3D LUT.
+func (cc *ColorConverter) ConvertImage(img image.Image) image.Image {
+	b := img.Bounds()
+	srcSpace, dstSpace := cc.SourceProfile.ColorSpace, cc.DestProfile.ColorSpace
+	if srcSpace == ColorSpaceRGB && dstSpace == ColorSpaceRGB {
+		lut, _ := cc.LUT3D(iccLUTSize)
+		return applyLUT3D(asRGBA(img), lut, LUTTetrahedral)
 	}
 
-	return r, g, b
-}
-
-// applyCurve applies a tone curve to a value
-func (cc *ColorConverter) applyCurve(value float64, curve ToneCurve) float64 {
-	if len(curve.Points) == 0 {
-		return value // Linear
-	} else if len(curve.Points) == 1 {
-		// Gamma curve
-		gamma := curve.Points[0]
-		return math.Pow(value, gamma)
-	} else {
-		// Table interpolation
-		if value <= 0 {
-			return curve.Points[0]
+	fn := cc.transform()
+	var set func(x, y int, v []float64, alpha float64)
+	var result image.Image
+	switch dstSpace {
+	case ColorSpaceCMYK:
+		out := image.NewCMYK(b)
+		set = func(x, y int, v []float64, _ float64) {
+			i := out.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				out.Pix[i+c] = uint8(clamp(v[c], 0, 1)*255 + 0.5)
+			}
+		}
+		result = out
+	case ColorSpaceGray:
+		out := image.NewGray(b)
+		set = func(x, y int, v []float64, _ float64) {
+			out.Pix[out.PixOffset(x, y)] = uint8(clamp(v[0], 0, 1)*255 + 0.5)
 		}
-		if value >= 1 {
-			return curve.Points[len(curve.Points)-1]
+		result = out
+	default:
+		out := image.NewRGBA(b)
+		set = func(x, y int, v []float64, alpha float64) {
+			i := out.PixOffset(x, y)
+			setPremultiplied(out.Pix[i:i+4], v[0]*alpha*255, v[1]*alpha*255, v[2]*alpha*255, alpha*255)
 		}
+		result = out
+	}
 
-		// Linear interpolation
-		index := value * float64(len(curve.Points)-1)
-		i := int(index)
-		if i >= len(curve.Points)-1 {
-			return curve.Points[len(curve.Points)-1]
+	parallelRows(b.Dy(), 0, func(start, end int) {
+		for y := b.Min.Y + start; y < b.Min.Y+end; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				in, alpha := deviceValues(img.At(x, y), srcSpace)
+				set(x, y, fn(in), alpha)
+			}
 		}
+	})
+	return result
+}
 
-		t := index - float64(i)
-		return curve.Points[i]*(1-t) + curve.Points[i+1]*t
+// iccLUTSize is the grid size of the 3D LUTs used for whole images
+const iccLUTSize = 33
+
+// LUT3D samples an RGB to RGB conversion into a 3D LUT with size points
+// per axis, for fast conversion of whole images
+func (cc *ColorConverter) LUT3D(size int) (*LUT3D, error) {
+	if cc.SourceProfile.Channels() != 3 || cc.DestProfile.ColorSpace != ColorSpaceRGB {
+		return nil, NewUnsupportedOperationError("ColorConverter.LUT3D", "only RGB to RGB conversions fit a 3D LUT")
 	}
+	lut := NewIdentityLUT3D(size)
+	fn := cc.transform()
+	parallelRows(lut.Size, 0, func(start, end int) {
+		for i := start * lut.Size * lut.Size; i < end*lut.Size*lut.Size; i++ {
+			d := lut.Data[i*3 : i*3+3]
+			out := fn([]float64{float64(d[0]), float64(d[1]), float64(d[2])})
+			d[0], d[1], d[2] = float32(out[0]), float32(out[1]), float32(out[2])
+		}
+	})
+	return lut, nil
 }
 
-// applyInverseCurve applies the inverse of a tone curve
-func (cc *ColorConverter) applyInverseCurve(value float64, curve ToneCurve) float64 {
-	if len(curve.Points) == 0 {
-		return value // Linear
-	} else if len(curve.Points) == 1 {
-		// Inverse gamma
-		gamma := curve.Points[0]
-		if gamma != 0 {
-			return math.Pow(value, 1.0/gamma)
+// deviceValues extracts device values in 0..1 and straight alpha from a color
+func deviceValues(c color.Color, space ColorSpace) ([]float64, float64) {
+	if space == ColorSpaceCMYK {
+		k, ok := c.(color.CMYK)
+		if !ok {
+			k = color.CMYKModel.Convert(c).(color.CMYK)
 		}
-		return value
-	} else {
-		// Inverse table lookup (simplified)
-		for i, point := range curve.Points {
-			if point >= value {
-				if i == 0 {
-					return 0
-				}
-				// Linear interpolation
-				t := (value - curve.Points[i-1]) / (point - curve.Points[i-1])
-				return (float64(i-1) + t) / float64(len(curve.Points)-1)
-			}
+		return []float64{float64(k.C) / 255, float64(k.M) / 255, float64(k.Y) / 255, float64(k.K) / 255}, 1
+	}
+	n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+	r, g, b, a := float64(n.R)/65535, float64(n.G)/65535, float64(n.B)/65535, float64(n.A)/65535
+	if space == ColorSpaceGray {
+		return []float64{0.299*r + 0.587*g + 0.114*b}, a
+	}
+	return []float64{r, g, b}, a
+}
+
+// deviceColor builds a color from device values in 0..1 and straight alpha
+func deviceColor(v []float64, alpha float64, space ColorSpace) color.Color {
+	to8 := func(x float64) uint8 { return uint8(clamp(x, 0, 1)*255 + 0.5) }
+	switch space {
+	case ColorSpaceCMYK:
+		return color.CMYK{C: to8(v[0]), M: to8(v[1]), Y: to8(v[2]), K: to8(v[3])}
+	case ColorSpaceGray:
+		if alpha >= 1 {
+			return color.Gray{Y: to8(v[0])}
 		}
-		return 1.0
+		g := to8(v[0])
+		return color.NRGBA{R: g, G: g, B: g, A: to8(alpha)}
 	}
+	return color.RGBA{
+		R: to8(v[0] * alpha),
+		G: to8(v[1] * alpha),
+		B: to8(v[2] * alpha),
+		A: to8(alpha),
+	}
+}
+
+// invertMatrix3 inverts a 3x3 matrix, reporting false when it is singular
+func invertMatrix3(m [3][3]float64) ([3][3]float64, bool) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-12 {
+		return [3][3]float64{}, false
+	}
+	inv := 1 / det
+	return [3][3]float64{
+		{(m[1][1]*m[2][2] - m[1][2]*m[2][1]) * inv, (m[0][2]*m[2][1] - m[0][1]*m[2][2]) * inv, (m[0][1]*m[1][2] - m[0][2]*m[1][1]) * inv},
+		{(m[1][2]*m[2][0] - m[1][0]*m[2][2]) * inv, (m[0][0]*m[2][2] - m[0][2]*m[2][0]) * inv, (m[0][2]*m[1][0] - m[0][0]*m[1][2]) * inv},
+		{(m[1][0]*m[2][1] - m[1][1]*m[2][0]) * inv, (m[0][1]*m[2][0] - m[0][0]*m[2][1]) * inv, (m[0][0]*m[1][1] - m[0][1]*m[1][0]) * inv},
+	}, true
 }
 
-// chromaticAdaptation performs chromatic adaptation between white points
+// bradfordMatrix and bradfordInverse map XYZ to and from the sharpened cone
+// response space of the Bradford chromatic adaptation transform
+var (
+	bradfordMatrix = [3][3]float64{
+		{0.8951, 0.2664, -0.1614},
+		{-0.7502, 1.7135, 0.0367},
+		{0.0389, -0.0685, 1.0296},
+	}
+	bradfordInverse = [3][3]float64{
+		{0.9869929, -0.1470543, 0.1599627},
+		{0.4323053, 0.5183603, 0.0492912},
+		{-0.0085287, 0.0400428, 0.9684867},
+	}
+)
+
+// chromaticAdaptation performs Bradford chromatic adaptation between white points
 func (cc *ColorConverter) chromaticAdaptation(xyz XYZColor, sourceWP, destWP XYZColor) XYZColor {
-	// Bradford chromatic adaptation matrix (simplified)
-	// In a full implementation, this would use proper Bradford transformation
+	cone := mulMatrix3(bradfordMatrix, [3]float64{xyz.X, xyz.Y, xyz.Z})
+	srcCone := mulMatrix3(bradfordMatrix, [3]float64{sourceWP.X, sourceWP.Y, sourceWP.Z})
+	dstCone := mulMatrix3(bradfordMatrix, [3]float64{destWP.X, destWP.Y, destWP.Z})
 
-	scaleX := destWP.X / sourceWP.X
-	scaleY := destWP.Y / sourceWP.Y
-	scaleZ := destWP.Z / sourceWP.Z
+	for i := range cone {
+		if srcCone[i] != 0 {
+			cone[i] *= dstCone[i] / srcCone[i]
+		}
+	}
 
-	return XYZColor{
-		X: xyz.X * scaleX,
-		Y: xyz.Y * scaleY,
-		Z: xyz.Z * scaleZ,
+	out := mulMatrix3(bradfordInverse, cone)
+	return XYZColor{X: out[0], Y: out[1], Z: out[2]}
+}
+
+// mulMatrix3 multiplies a 3x3 matrix by a column vector
+func mulMatrix3(m [3][3]float64, v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
 	}
 }
 
 // Standard ICC profiles
 
+// srgbProfile backs the naive CMYK fallback of profiles without tables
+var srgbProfile = CreateSRGBProfile()
+
 // CreateSRGBProfile creates a standard sRGB ICC profile
 func CreateSRGBProfile() *ICCProfile {
 	profile := NewICCProfile()
 
-	// sRGB colorants (ITU-R BT.709 primaries)
-	profile.RedColorant = XYZColor{X: 0.4124, Y: 0.2126, Z: 0.0193}
-	profile.GreenColorant = XYZColor{X: 0.3576, Y: 0.7152, Z: 0.1192}
-	profile.BlueColorant = XYZColor{X: 0.1805, Y: 0.0722, Z: 0.9505}
+	// sRGB colorants (ITU-R BT.709 primaries), Bradford-adapted to the D50 PCS
+	profile.RedColorant = XYZColor{X: 0.4360747, Y: 0.2225045, Z: 0.0139322}
+	profile.GreenColorant = XYZColor{X: 0.3850649, Y: 0.7168786, Z: 0.0971045}
+	profile.BlueColorant = XYZColor{X: 0.1430804, Y: 0.0606169, Z: 0.7141733}
 	profile.WhitePoint = IlluminantD65
 
-	// sRGB gamma curves (simplified)
-	gamma := 2.4
-	profile.Curves = []ToneCurve{
-		{Type: 0, Points: []float64{gamma}},
-		{Type: 0, Points: []float64{gamma}},
-		{Type: 0, Points: []float64{gamma}},
+	// IEC 61966-2.1 curve: a 2.4 power segment with a linear toe
+	srgb := ToneCurve{
+		Type:     typeParametric,
+		Function: 3,
+		Params:   []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045},
 	}
+	profile.Curves = []ToneCurve{srgb, srgb, srgb}
 
 	return profile
 }
@@ -462,14 +877,14 @@ func CreateSRGBProfile() *ICCProfile {
 func CreateAdobeRGBProfile() *ICCProfile {
 	profile := NewICCProfile()
 
-	// Adobe RGB colorants
-	profile.RedColorant = XYZColor{X: 0.5767, Y: 0.2973, Z: 0.0270}
-	profile.GreenColorant = XYZColor{X: 0.1856, Y: 0.6274, Z: 0.0707}
-	profile.BlueColorant = XYZColor{X: 0.1882, Y: 0.0753, Z: 0.9911}
+	// Adobe RGB colorants, Bradford-adapted to the D50 PCS
+	profile.RedColorant = XYZColor{X: 0.6097559, Y: 0.3111242, Z: 0.0194811}
+	profile.GreenColorant = XYZColor{X: 0.2052401, Y: 0.6256560, Z: 0.0608902}
+	profile.BlueColorant = XYZColor{X: 0.1492240, Y: 0.0632197, Z: 0.7448387}
 	profile.WhitePoint = IlluminantD65
 
-	// Adobe RGB gamma (2.2)
-	gamma := 2.2
+	// Adobe RGB gamma (563/256, about 2.2)
+	gamma := 563.0 / 256
 	profile.Curves = []ToneCurve{
 		{Type: 0, Points: []float64{gamma}},
 		{Type: 0, Points: []float64{gamma}},
@@ -499,21 +914,40 @@ func (dc *Context) SetColorConverter(converter *ColorConverter) {
 	dc.colorConverter = converter
 }
 
-// ConvertToColorSpace converts the current image to a different color space
+// ConvertToColorSpace converts the current image to a different color
+// space and makes it the context's profile. The converter set with
+// SetColorConverter is used when it targets the profile. RGB and gray
+// targets are supported; use ColorConverter.ConvertImage for CMYK.
 func (dc *Context) ConvertToColorSpace(targetProfile *ICCProfile) {
-	if dc.colorConverter == nil {
-		sourceProfile := dc.GetColorProfile()
-		dc.colorConverter = NewColorConverter(sourceProfile, targetProfile)
-	}
-
-	bounds := dc.im.Bounds()
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := dc.im.RGBAAt(x, y)
-			convertedColor := dc.colorConverter.ConvertColor(originalColor)
-			if rgba, ok := convertedColor.(color.RGBA); ok {
-				dc.im.SetRGBA(x, y, rgba)
-			}
+	converter := dc.colorConverter
+	if converter == nil || converter.DestProfile != targetProfile {
+		converter = NewColorConverter(dc.GetColorProfile(), targetProfile)
+	}
+
+	gray := targetProfile.ColorSpace == ColorSpaceGray
+	if !gray && targetProfile.ColorSpace != ColorSpaceRGB {
+		return
+	}
+	fn := converter.transform()
+	convert := func(r, g, b float64) (float64, float64, float64) {
+		in := []float64{r, g, b}
+		if converter.SourceProfile.ColorSpace == ColorSpaceGray {
+			in = []float64{0.299*r + 0.587*g + 0.114*b}
+		}
+		v := fn(in)
+		if gray {
+			return v[0], v[0], v[0]
 		}
+		return v[0], v[1], v[2]
+	}
+
+	switch {
+	case dc.deep != nil:
+		dc.setDeepImage(mapStraightRGBDeep(dc.deep.image(), convert))
+	case gray:
+		copyRGBA(dc.im, mapStraightRGB(dc.im, convert))
+	default:
+		copyRGBA(dc.im, asRGBA(converter.ConvertImage(dc.im)))
 	}
+	dc.colorProfile = targetProfile
 }
@@ -0,0 +1,415 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// ICC lookup-table transforms: lut8 (mft1), lut16 (mft2), lutAToB (mAB)
+// and lutBToA (mBA) tags, evaluated on values normalized to 0..1
+
+// ICC tag type signatures
+const (
+	typeCurve      = 0x63757276 // 'curv'
+	typeParametric = 0x70617261 // 'para'
+	typeLut8       = 0x6D667431 // 'mft1'
+	typeLut16      = 0x6D667432 // 'mft2'
+	typeLutAToB    = 0x6D414220 // 'mAB '
+	typeLutBToA    = 0x6D424120 // 'mBA '
+)
+
+// paraParamCounts is the number of parameters of each parametric curve function
+var paraParamCounts = [5]int{1, 3, 4, 5, 7}
+
+// iccStage is one step of a LUT pipeline
+type iccStage interface {
+	apply(v []float64) []float64
+}
+
+// iccLUT is a parsed LUT tag: a pipeline of curve, matrix and CLUT stages
+type iccLUT struct {
+	inputs, outputs int
+	stages          []iccStage
+	// legacyLab marks lut16 tags, whose Lab PCS values use the ICC v2
+	// 16-bit encoding where 0xFF00 is L* 100
+	legacyLab bool
+}
+
+// eval runs the pipeline; values are clamped to 0..1 between stages
+func (l *iccLUT) eval(in []float64) []float64 {
+	v := append([]float64(nil), in...)
+	for _, s := range l.stages {
+		for i := range v {
+			v[i] = clamp(v[i], 0, 1)
+		}
+		v = s.apply(v)
+	}
+	for i := range v {
+		v[i] = clamp(v[i], 0, 1)
+	}
+	return v
+}
+
+type curveStage []ToneCurve
+
+func (s curveStage) apply(v []float64) []float64 {
+	for i := range v {
+		if i < len(s) {
+			v[i] = s[i].Eval(v[i])
+		}
+	}
+	return v
+}
+
+// matrixStage applies a 3x3 matrix and offset to the first three channels
+type matrixStage struct {
+	m      [9]float64
+	offset [3]float64
+}
+
+func (s matrixStage) apply(v []float64) []float64 {
+	if len(v) < 3 {
+		return v
+	}
+	x, y, z := v[0], v[1], v[2]
+	v[0] = s.m[0]*x + s.m[1]*y + s.m[2]*z + s.offset[0]
+	v[1] = s.m[3]*x + s.m[4]*y + s.m[5]*z + s.offset[1]
+	v[2] = s.m[6]*x + s.m[7]*y + s.m[8]*z + s.offset[2]
+	return v
+}
+
+// clutStage is a multidimensional color lookup table. The first input
+// varies slowest, as stored in the tag.
+type clutStage struct {
+	grid    []int
+	outputs int
+	data    []float64
+}
+
+// apply interpolates multilinearly between the 2^n corners of the grid cell
+func (s clutStage) apply(v []float64) []float64 {
+	n := len(s.grid)
+	base := 0
+	stride := make([]int, n)
+	frac := make([]float64, n)
+	step := s.outputs
+	for i := n - 1; i >= 0; i-- {
+		stride[i] = step
+		g := s.grid[i] - 1
+		p := 0.0
+		if i < len(v) {
+			p = v[i] * float64(g)
+		}
+		idx := min(int(p), max(g-1, 0))
+		frac[i] = p - float64(idx)
+		base += idx * step
+		step *= s.grid[i]
+	}
+
+	out := make([]float64, s.outputs)
+	for corner := 0; corner < 1<<n; corner++ {
+		w := 1.0
+		off := base
+		for i := 0; i < n; i++ {
+			if corner&(1<<(n-1-i)) != 0 {
+				if s.grid[i] == 1 {
+					w = 0
+					break
+				}
+				w *= frac[i]
+				off += stride[i]
+			} else {
+				w *= 1 - frac[i]
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		for c := range out {
+			out[c] += w * s.data[off+c]
+		}
+	}
+	return out
+}
+
+// parseCurve parses a 'curv' or 'para' element, returning it and its
+// length in bytes
+func parseCurve(b []byte) (ToneCurve, int, error) {
+	if len(b) < 12 {
+		return ToneCurve{}, 0, fmt.Errorf("curve too short")
+	}
+	sig := binary.BigEndian.Uint32(b)
+	switch sig {
+	case typeCurve:
+		count := int(binary.BigEndian.Uint32(b[8:]))
+		size := 12 + 2*count
+		if count < 0 || size > len(b) {
+			return ToneCurve{}, 0, fmt.Errorf("curve table truncated")
+		}
+		curve := ToneCurve{Type: sig}
+		switch count {
+		case 0:
+			curve.Points = []float64{0, 1}
+		case 1:
+			curve.Points = []float64{float64(binary.BigEndian.Uint16(b[12:])) / 256}
+		default:
+			curve.Points = make([]float64, count)
+			for i := range curve.Points {
+				curve.Points[i] = float64(binary.BigEndian.Uint16(b[12+2*i:])) / 65535
+			}
+		}
+		return curve, size, nil
+	case typeParametric:
+		fn := int(binary.BigEndian.Uint16(b[8:]))
+		if fn >= len(paraParamCounts) {
+			return ToneCurve{}, 0, fmt.Errorf("unknown parametric curve function %d", fn)
+		}
+		size := 12 + 4*paraParamCounts[fn]
+		if size > len(b) {
+			return ToneCurve{}, 0, fmt.Errorf("parametric curve truncated")
+		}
+		curve := ToneCurve{Type: sig, Function: fn, Params: make([]float64, paraParamCounts[fn])}
+		for i := range curve.Params {
+			curve.Params[i] = s15Fixed16(b[12+4*i:])
+		}
+		return curve, size, nil
+	}
+	return ToneCurve{}, 0, fmt.Errorf("unsupported curve type %q", sigString(sig))
+}
+
+// parseCurves parses n consecutive 4-byte aligned curves starting at off
+func parseCurves(tag []byte, off, n int) ([]ToneCurve, error) {
+	curves := make([]ToneCurve, n)
+	for i := range curves {
+		if off < 0 || off >= len(tag) {
+			return nil, fmt.Errorf("curve offset out of range")
+		}
+		c, size, err := parseCurve(tag[off:])
+		if err != nil {
+			return nil, err
+		}
+		curves[i] = c
+		off += (size + 3) &^ 3
+	}
+	return curves, nil
+}
+
+// parseLUT parses a LUT tag. xyzInput tells whether the tag's input is
+// PCS XYZ, the only case where lut8 and lut16 matrices apply.
+func parseLUT(tag []byte, xyzInput bool) (*iccLUT, error) {
+	if len(tag) < 32 {
+		return nil, fmt.Errorf("LUT tag too short")
+	}
+	sig := binary.BigEndian.Uint32(tag)
+	in, out := int(tag[8]), int(tag[9])
+	if in < 1 || in > 15 || out < 1 || out > 15 {
+		return nil, fmt.Errorf("unsupported LUT with %d inputs and %d outputs", in, out)
+	}
+	switch sig {
+	case typeLut8, typeLut16:
+		return parseLegacyLUT(tag, sig, in, out, xyzInput)
+	case typeLutAToB, typeLutBToA:
+		return parseABLUT(tag, sig, in, out)
+	}
+	return nil, fmt.Errorf("unsupported LUT type %q", sigString(sig))
+}
+
+func parseLegacyLUT(tag []byte, sig uint32, in, out int, xyzInput bool) (*iccLUT, error) {
+	if len(tag) < 52 {
+		return nil, fmt.Errorf("LUT tag too short")
+	}
+	grid := int(tag[10])
+	if grid < 2 {
+		return nil, fmt.Errorf("invalid CLUT grid size %d", grid)
+	}
+	lut := &iccLUT{inputs: in, outputs: out, legacyLab: sig == typeLut16}
+
+	var m matrixStage
+	identity := true
+	for i := range m.m {
+		m.m[i] = s15Fixed16(tag[12+4*i:])
+		want := 0.0
+		if i%4 == 0 {
+			want = 1
+		}
+		identity = identity && math.Abs(m.m[i]-want) < 1e-6
+	}
+	if xyzInput && in == 3 && !identity {
+		lut.stages = append(lut.stages, m)
+	}
+
+	width, inEntries, outEntries, off := 1, 256, 256, 48
+	if sig == typeLut16 {
+		width = 2
+		inEntries = int(binary.BigEndian.Uint16(tag[48:]))
+		outEntries = int(binary.BigEndian.Uint16(tag[50:]))
+		off = 52
+		if inEntries < 2 || outEntries < 2 {
+			return nil, fmt.Errorf("LUT tables need at least 2 entries")
+		}
+	}
+	cells := 1
+	for i := 0; i < in; i++ {
+		if cells > len(tag)/grid {
+			return nil, fmt.Errorf("LUT tag truncated")
+		}
+		cells *= grid
+	}
+	need := off + width*(in*inEntries+cells*out+out*outEntries)
+	if need > len(tag) {
+		return nil, fmt.Errorf("LUT tag truncated")
+	}
+	read := func(n int) []float64 {
+		vals := make([]float64, n)
+		for i := range vals {
+			if width == 2 {
+				vals[i] = float64(binary.BigEndian.Uint16(tag[off+2*i:])) / 65535
+			} else {
+				vals[i] = float64(tag[off+i]) / 255
+			}
+		}
+		off += n * width
+		return vals
+	}
+
+	inCurves := make(curveStage, in)
+	for i := range inCurves {
+		inCurves[i] = ToneCurve{Type: typeCurve, Points: read(inEntries)}
+	}
+	clut := clutStage{grid: make([]int, in), outputs: out}
+	for i := range clut.grid {
+		clut.grid[i] = grid
+	}
+	clut.data = read(cells * out)
+	outCurves := make(curveStage, out)
+	for i := range outCurves {
+		outCurves[i] = ToneCurve{Type: typeCurve, Points: read(outEntries)}
+	}
+	lut.stages = append(lut.stages, inCurves, clut, outCurves)
+	return lut, nil
+}
+
+func parseABLUT(tag []byte, sig uint32, in, out int) (*iccLUT, error) {
+	offB := int(binary.BigEndian.Uint32(tag[12:]))
+	offMatrix := int(binary.BigEndian.Uint32(tag[16:]))
+	offM := int(binary.BigEndian.Uint32(tag[20:]))
+	offCLUT := int(binary.BigEndian.Uint32(tag[24:]))
+	offA := int(binary.BigEndian.Uint32(tag[28:]))
+	lut := &iccLUT{inputs: in, outputs: out}
+
+	// B curves sit on the PCS side: after everything in AToB, first in BToA
+	pcs := out
+	if sig == typeLutBToA {
+		pcs = in
+	}
+	device := in + out - pcs
+	if offB == 0 {
+		return nil, fmt.Errorf("LUT is missing its B curves")
+	}
+	bCurves, err := parseCurves(tag, offB, pcs)
+	if err != nil {
+		return nil, err
+	}
+
+	var matrix iccStage
+	if offMatrix != 0 && pcs == 3 {
+		if offMatrix < 0 || offMatrix+48 > len(tag) {
+			return nil, fmt.Errorf("LUT matrix out of range")
+		}
+		var m matrixStage
+		for i := range m.m {
+			m.m[i] = s15Fixed16(tag[offMatrix+4*i:])
+		}
+		for i := range m.offset {
+			m.offset[i] = s15Fixed16(tag[offMatrix+36+4*i:])
+		}
+		matrix = m
+	}
+	var mCurves iccStage
+	if offM != 0 {
+		c, err := parseCurves(tag, offM, pcs)
+		if err != nil {
+			return nil, err
+		}
+		mCurves = curveStage(c)
+	}
+	var clut iccStage
+	if offCLUT != 0 {
+		c, err := parseCLUT(tag, offCLUT, in, out)
+		if err != nil {
+			return nil, err
+		}
+		clut = c
+	} else if in != out {
+		return nil, fmt.Errorf("LUT without a CLUT must have as many inputs as outputs")
+	}
+	var aCurves iccStage
+	if offA != 0 {
+		c, err := parseCurves(tag, offA, device)
+		if err != nil {
+			return nil, err
+		}
+		aCurves = curveStage(c)
+	}
+
+	var order []iccStage
+	if sig == typeLutAToB {
+		order = []iccStage{aCurves, clut, mCurves, matrix, curveStage(bCurves)}
+	} else {
+		order = []iccStage{curveStage(bCurves), matrix, mCurves, clut, aCurves}
+	}
+	for _, s := range order {
+		if s != nil {
+			lut.stages = append(lut.stages, s)
+		}
+	}
+	return lut, nil
+}
+
+func parseCLUT(tag []byte, off, in, out int) (clutStage, error) {
+	if off < 0 || off+20 > len(tag) {
+		return clutStage{}, fmt.Errorf("CLUT out of range")
+	}
+	clut := clutStage{grid: make([]int, in), outputs: out}
+	cells := 1
+	for i := range clut.grid {
+		clut.grid[i] = int(tag[off+i])
+		if clut.grid[i] < 1 {
+			return clutStage{}, fmt.Errorf("invalid CLUT grid size")
+		}
+		if cells > len(tag)/clut.grid[i] {
+			return clutStage{}, fmt.Errorf("CLUT truncated")
+		}
+		cells *= clut.grid[i]
+	}
+	width := int(tag[off+16])
+	if width != 1 && width != 2 {
+		return clutStage{}, fmt.Errorf("invalid CLUT precision %d", width)
+	}
+	off += 20
+	if off+cells*out*width > len(tag) {
+		return clutStage{}, fmt.Errorf("CLUT truncated")
+	}
+	clut.data = make([]float64, cells*out)
+	for i := range clut.data {
+		if width == 2 {
+			clut.data[i] = float64(binary.BigEndian.Uint16(tag[off+2*i:])) / 65535
+		} else {
+			clut.data[i] = float64(tag[off+i]) / 255
+		}
+	}
+	return clut, nil
+}
+
+// s15Fixed16 decodes a signed 15.16 fixed-point number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// sigString renders a four-byte signature for error messages
+func sigString(sig uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], sig)
+	return string(b[:])
+}
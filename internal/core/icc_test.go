@@ -0,0 +1,467 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// ICC profile builders for synthetic test profiles
+
+type iccTestTag struct {
+	sig  uint32
+	data []byte
+}
+
+func buildICC(space, pcs ColorSpace, tags ...iccTestTag) []byte {
+	header := make([]byte, 132+12*len(tags))
+	binary.BigEndian.PutUint32(header[8:], 0x04300000)
+	binary.BigEndian.PutUint32(header[12:], uint32(DeviceClassOutput))
+	binary.BigEndian.PutUint32(header[16:], uint32(space))
+	binary.BigEndian.PutUint32(header[20:], uint32(pcs))
+	copy(header[36:], "acsp")
+	putS15(header[68:], IlluminantD50.X, IlluminantD50.Y, IlluminantD50.Z)
+	binary.BigEndian.PutUint32(header[128:], uint32(len(tags)))
+
+	offset := len(header)
+	for i, tag := range tags {
+		entry := header[132+12*i:]
+		binary.BigEndian.PutUint32(entry, tag.sig)
+		binary.BigEndian.PutUint32(entry[4:], uint32(offset))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(tag.data)))
+		offset += len(pad4(tag.data))
+	}
+	data := header
+	for _, tag := range tags {
+		data = append(data, pad4(tag.data)...)
+	}
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+func pad4(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func putS15(b []byte, vals ...float64) {
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(b[4*i:], uint32(int32(math.Round(v*65536))))
+	}
+}
+
+func typeHeader(sig string, size int) []byte {
+	b := make([]byte, size)
+	copy(b, sig)
+	return b
+}
+
+func xyzTag(c XYZColor) []byte {
+	b := typeHeader("XYZ ", 20)
+	putS15(b[8:], c.X, c.Y, c.Z)
+	return b
+}
+
+func paraTag(fn int, params ...float64) []byte {
+	b := typeHeader("para", 12+4*len(params))
+	binary.BigEndian.PutUint16(b[8:], uint16(fn))
+	putS15(b[12:], params...)
+	return b
+}
+
+func identityCurv() []byte {
+	return typeHeader("curv", 12)
+}
+
+// sampleCLUT samples fn over a grid with the first input varying slowest
+func sampleCLUT(in, out, grid int, fn func([]float64) []float64) []uint16 {
+	cells := 1
+	for i := 0; i < in; i++ {
+		cells *= grid
+	}
+	data := make([]uint16, 0, cells*out)
+	v := make([]float64, in)
+	for cell := 0; cell < cells; cell++ {
+		rest := cell
+		for i := in - 1; i >= 0; i-- {
+			v[i] = float64(rest%grid) / float64(grid-1)
+			rest /= grid
+		}
+		for _, o := range fn(v) {
+			data = append(data, uint16(clamp(o, 0, 1)*65535+0.5))
+		}
+	}
+	return data
+}
+
+func mft2Tag(in, out, grid int, fn func([]float64) []float64) []byte {
+	b := typeHeader("mft2", 52)
+	b[8], b[9], b[10] = byte(in), byte(out), byte(grid)
+	putS15(b[12:], 1, 0, 0, 0, 1, 0, 0, 0, 1)
+	binary.BigEndian.PutUint16(b[48:], 2)
+	binary.BigEndian.PutUint16(b[50:], 2)
+	put16 := func(v uint16) { b = binary.BigEndian.AppendUint16(b, v) }
+	for i := 0; i < in; i++ {
+		put16(0)
+		put16(65535)
+	}
+	for _, v := range sampleCLUT(in, out, grid, fn) {
+		put16(v)
+	}
+	for i := 0; i < out; i++ {
+		put16(0)
+		put16(65535)
+	}
+	return b
+}
+
+// abTag builds an mAB or mBA tag with identity B curves, a 16-bit CLUT
+// and the given A curves
+func abTag(sig string, in, out, grid int, aCurve []byte, fn func([]float64) []float64) []byte {
+	b := typeHeader(sig, 32)
+	b[8], b[9] = byte(in), byte(out)
+	pcs, device := out, in
+	if sig == "mBA " {
+		pcs, device = in, out
+	}
+	binary.BigEndian.PutUint32(b[12:], uint32(len(b)))
+	for i := 0; i < pcs; i++ {
+		b = append(b, identityCurv()...)
+	}
+	binary.BigEndian.PutUint32(b[24:], uint32(len(b)))
+	clut := make([]byte, 20)
+	for i := 0; i < in; i++ {
+		clut[i] = byte(grid)
+	}
+	clut[16] = 2
+	for _, v := range sampleCLUT(in, out, grid, fn) {
+		clut = binary.BigEndian.AppendUint16(clut, v)
+	}
+	b = append(b, pad4(clut)...)
+	binary.BigEndian.PutUint32(b[28:], uint32(len(b)))
+	for i := 0; i < device; i++ {
+		b = append(b, pad4(append([]byte(nil), aCurve...))...)
+	}
+	return b
+}
+
+// Lab encodings of the reference sRGB profile, used to sample the tables
+
+func srgbToLabEncoded(legacy bool) func([]float64) []float64 {
+	scale := 1.0
+	if legacy {
+		scale = 65280.0 / 65535
+	}
+	return func(v []float64) []float64 {
+		lab := pcsToLab(srgbProfile.toPCS(v, IntentPerceptual))
+		return []float64{lab[0] / 100 * scale, (lab[1] + 128) / 255 * scale, (lab[2] + 128) / 255 * scale}
+	}
+}
+
+func labEncodedToSRGB(v []float64) []float64 {
+	return srgbProfile.fromPCS(labToPCS(v[0]*100, v[1]*255-128, v[2]*255-128), IntentPerceptual)
+}
+
+func srgbMatrixTags() []iccTestTag {
+	curve := paraTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+	return []iccTestTag{
+		{TagRedColorant, xyzTag(srgbProfile.RedColorant)},
+		{TagGreenColorant, xyzTag(srgbProfile.GreenColorant)},
+		{TagBlueColorant, xyzTag(srgbProfile.BlueColorant)},
+		{TagWhitePoint, xyzTag(IlluminantD50)},
+		{TagRedTRC, curve},
+		{TagGreenTRC, curve},
+		{TagBlueTRC, curve},
+	}
+}
+
+// randomInGamut returns an opaque color away from the gamut boundary, where
+// tables interpolate across clipped grid points
+func randomInGamut(rng *rand.Rand) color.RGBA {
+	return color.RGBA{uint8(40 + rng.Intn(176)), uint8(40 + rng.Intn(176)), uint8(40 + rng.Intn(176)), 255}
+}
+
+func maxChannelDiff(a, b color.Color) int {
+	r1, g1, b1, _ := a.RGBA()
+	r2, g2, b2, _ := b.RGBA()
+	return max(abs(int(r1>>8)-int(r2>>8)), abs(int(g1>>8)-int(g2>>8)), abs(int(b1>>8)-int(b2>>8)))
+}
+
+func TestICCMatrixProfile(t *testing.T) {
+	profile, err := LoadICCProfile(buildICC(ColorSpaceRGB, ColorSpaceXYZ, srgbMatrixTags()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.ColorSpace != ColorSpaceRGB || len(profile.Curves) != 3 || profile.Curves[0].Function != 3 {
+		t.Fatalf("parsed profile: space %x, curves %+v", profile.ColorSpace, profile.Curves)
+	}
+	if math.Abs(profile.Header.PCSIlluminant.Z-IlluminantD50.Z) > 1e-4 {
+		t.Errorf("PCS illuminant = %+v", profile.Header.PCSIlluminant)
+	}
+
+	cc := NewColorConverter(profile, CreateSRGBProfile())
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		c := color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+		if d := maxChannelDiff(c, cc.ConvertColor(c)); d > 1 {
+			t.Fatalf("%v changed by %d through an identical profile", c, d)
+		}
+	}
+
+	red := NewColorConverter(CreateSRGBProfile(), CreateAdobeRGBProfile()).ConvertColor(color.RGBA{255, 0, 0, 255})
+	if d := maxChannelDiff(red, color.RGBA{219, 0, 0, 255}); d > 1 {
+		t.Errorf("sRGB red in Adobe RGB = %v, want (219, 0, 0)", red)
+	}
+}
+
+func TestICCLUTProfiles(t *testing.T) {
+	// The A curves take the square root of the device values, so the
+	// CLUT squares its input back
+	squaredToLab := func(v []float64) []float64 {
+		return srgbToLabEncoded(false)([]float64{v[0] * v[0], v[1] * v[1], v[2] * v[2]})
+	}
+
+	profiles := map[string][]byte{
+		"mft2": buildICC(ColorSpaceRGB, ColorSpaceLab,
+			iccTestTag{TagAToB0, mft2Tag(3, 3, 17, srgbToLabEncoded(true))},
+			iccTestTag{TagBToA0, mft2Tag(3, 3, 33, func(v []float64) []float64 {
+				scale := 65535.0 / 65280
+				return labEncodedToSRGB([]float64{v[0] * scale, v[1] * scale, v[2] * scale})
+			})},
+		),
+		"mAB": buildICC(ColorSpaceRGB, ColorSpaceLab,
+			// The square-root A curves halve the grid density in the
+			// highlights, so the table needs as many points as B2A0
+			iccTestTag{TagAToB0, abTag("mAB ", 3, 3, 33, paraTag(0, 0.5), squaredToLab)},
+			iccTestTag{TagBToA0, abTag("mBA ", 3, 3, 33, paraTag(0, 1/2.2), func(v []float64) []float64 {
+				// The A curves raise the CLUT output to the 1/2.2 power
+				rgb := labEncodedToSRGB(v)
+				for i := range rgb {
+					rgb[i] = math.Pow(math.Max(rgb[i], 0), 2.2)
+				}
+				return rgb
+			})},
+		),
+	}
+
+	// Each profile draws its own colors so the map order does not matter
+	for name, data := range profiles {
+		rng := rand.New(rand.NewSource(2))
+		profile, err := LoadICCProfile(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !profile.HasLUT(IntentRelativeColorimetric) {
+			t.Fatalf("%s: relative intent should fall back to A2B0", name)
+		}
+		toSRGB := NewColorConverter(profile, CreateSRGBProfile())
+		fromSRGB := NewColorConverter(CreateSRGBProfile(), profile)
+		for i := 0; i < 200; i++ {
+			c := color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+			if d := maxChannelDiff(c, toSRGB.ConvertColor(c)); d > 5 {
+				t.Fatalf("%s: A2B of %v is off by %d", name, c, d)
+			}
+			// Lab-indexed tables interpolate a curved surface, so the
+			// reverse direction is looser than the forward one
+			c = randomInGamut(rng)
+			if d := maxChannelDiff(c, fromSRGB.ConvertColor(c)); d > 8 {
+				t.Fatalf("%s: B2A of %v is off by %d", name, c, d)
+			}
+		}
+	}
+}
+
+// cmykTestProfile models ideal inks on sRGB paper: A2B0 multiplies out the
+// inks and B2A0 separates with full gray component replacement
+func cmykTestProfile(t *testing.T) *ICCProfile {
+	toLab := func(v []float64) []float64 {
+		k := 1 - v[3]
+		return srgbToLabEncoded(true)([]float64{(1 - v[0]) * k, (1 - v[1]) * k, (1 - v[2]) * k})
+	}
+	fromLab := func(v []float64) []float64 {
+		scale := 65535.0 / 65280
+		rgb := labEncodedToSRGB([]float64{v[0] * scale, v[1] * scale, v[2] * scale})
+		k := 1 - math.Max(rgb[0], math.Max(rgb[1], rgb[2]))
+		if k >= 1 {
+			return []float64{0, 0, 0, 1}
+		}
+		return []float64{(1 - rgb[0] - k) / (1 - k), (1 - rgb[1] - k) / (1 - k), (1 - rgb[2] - k) / (1 - k), k}
+	}
+	profile, err := LoadICCProfile(buildICC(ColorSpaceCMYK, ColorSpaceLab,
+		iccTestTag{TagWhitePoint, xyzTag(IlluminantD50)},
+		iccTestTag{TagAToB0, mft2Tag(4, 3, 17, toLab)},
+		iccTestTag{TagBToA0, mft2Tag(3, 4, 33, fromLab)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profile
+}
+
+func TestICCCMYKProfile(t *testing.T) {
+	cmyk := cmykTestProfile(t)
+	if cmyk.Channels() != 4 {
+		t.Fatalf("channels = %d", cmyk.Channels())
+	}
+	toCMYK := NewColorConverter(CreateSRGBProfile(), cmyk)
+	toCMYK.Intent = IntentRelativeColorimetric
+
+	red, ok := toCMYK.ConvertColor(color.RGBA{255, 0, 0, 255}).(color.CMYK)
+	if !ok {
+		t.Fatal("CMYK destination should produce color.CMYK")
+	}
+	if red.C > 8 || red.M < 247 || red.Y < 247 || red.K > 8 {
+		t.Errorf("red separated as %v", red)
+	}
+
+	back := NewColorConverter(cmyk, CreateSRGBProfile())
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 100; i++ {
+		// Interpolation error accumulates across both tables
+		c := randomInGamut(rng)
+		if d := maxChannelDiff(c, back.ConvertColor(toCMYK.ConvertColor(c))); d > 12 {
+			t.Fatalf("%v does not survive a CMYK round trip (off by %d)", c, d)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw := color.RGBA{0, 0, 255, 255}
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []uint8{draw.R, draw.G, draw.B, draw.A})
+	}
+	sep, ok := toCMYK.ConvertImage(img).(*image.CMYK)
+	if !ok {
+		t.Fatal("ConvertImage should return *image.CMYK")
+	}
+	// Blue sits on a gamut corner of the coarse test table
+	if c := sep.CMYKAt(2, 2); c.C < 224 || c.M < 224 || c.Y > 16 {
+		t.Errorf("blue separated as %v", c)
+	}
+}
+
+func TestICCRenderingIntents(t *testing.T) {
+	darker := func(v []float64) []float64 {
+		enc := srgbToLabEncoded(false)(v)
+		enc[0] *= 0.8
+		return enc
+	}
+	profile, err := LoadICCProfile(buildICC(ColorSpaceRGB, ColorSpaceLab,
+		iccTestTag{TagAToB0, abTag("mAB ", 3, 3, 9, identityCurv(), darker)},
+		iccTestTag{TagAToB1, abTag("mAB ", 3, 3, 9, identityCurv(), srgbToLabEncoded(false))},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := color.RGBA{128, 128, 128, 255}
+	cc := NewColorConverter(profile, CreateSRGBProfile())
+	results := map[RenderingIntent]color.RGBA{}
+	for _, intent := range []RenderingIntent{IntentPerceptual, IntentRelativeColorimetric, IntentSaturation} {
+		cc.Intent = intent
+		results[intent] = cc.ConvertColor(gray).(color.RGBA)
+	}
+	if maxChannelDiff(results[IntentRelativeColorimetric], gray) > 2 {
+		t.Errorf("relative colorimetric = %v, want %v", results[IntentRelativeColorimetric], gray)
+	}
+	if results[IntentPerceptual].R >= 120 {
+		t.Errorf("perceptual = %v, want the darker A2B0 rendering", results[IntentPerceptual])
+	}
+	if results[IntentSaturation] != results[IntentPerceptual] {
+		t.Error("saturation should fall back to the perceptual table")
+	}
+
+	// Absolute colorimetric keeps the paper's color: 80% white paper is
+	// shown as a gray rather than mapped to white
+	paper := CreateSRGBProfile()
+	paper.WhitePoint = XYZColor{X: IlluminantD50.X * 0.8, Y: 0.8, Z: IlluminantD50.Z * 0.8}
+	display := CreateSRGBProfile()
+	display.WhitePoint = IlluminantD50
+	abs := NewColorConverter(paper, display)
+	white := color.RGBA{255, 255, 255, 255}
+	if got := abs.ConvertColor(white); maxChannelDiff(got, white) != 0 {
+		t.Errorf("relative white = %v", got)
+	}
+	abs.Intent = IntentAbsoluteColorimetric
+	want := uint8(linearToSRGB(0.8)*255 + 0.5)
+	if got := abs.ConvertColor(white).(color.RGBA); maxChannelDiff(got, color.RGBA{want, want, want, 255}) > 1 {
+		t.Errorf("absolute white = %v, want %d gray", got, want)
+	}
+}
+
+func TestICCBlackPointCompensation(t *testing.T) {
+	// A device whose black is a dark gray at 3% luminance
+	lifted := CreateSRGBProfile()
+	points := make([]float64, 256)
+	for i := range points {
+		points[i] = 0.03 + 0.97*srgbToLinear(float64(i)/255)
+	}
+	curve := ToneCurve{Type: typeCurve, Points: points}
+	lifted.Curves = []ToneCurve{curve, curve, curve}
+
+	cc := NewColorConverter(lifted, CreateSRGBProfile())
+	black := color.RGBA{0, 0, 0, 255}
+	if got := cc.ConvertColor(black).(color.RGBA); got.R < 40 {
+		t.Errorf("black without compensation = %v, want the lifted gray", got)
+	}
+	cc.BlackPointCompensation = true
+	if got := cc.ConvertColor(black).(color.RGBA); got.R > 1 {
+		t.Errorf("black with compensation = %v, want black", got)
+	}
+	if got := cc.ConvertColor(color.White).(color.RGBA); got.R != 255 {
+		t.Errorf("white with compensation = %v", got)
+	}
+}
+
+func TestICCLUTGridOverflow(t *testing.T) {
+	// 16^15 grid cells overflow an int long before the size check
+	mft1 := make([]byte, 52)
+	copy(mft1, "mft1")
+	mft1[8], mft1[9], mft1[10] = 15, 15, 16
+	if _, err := parseLUT(mft1, false); err == nil {
+		t.Error("expected an error for an mft1 grid larger than the tag")
+	}
+
+	clut := bytes.Repeat([]byte{255}, 20)
+	clut[16] = 1
+	if _, err := parseCLUT(clut, 0, 15, 15); err == nil {
+		t.Error("expected an error for a CLUT grid larger than the tag")
+	}
+}
+
+func TestICCLUT3DFastPath(t *testing.T) {
+	cc := NewColorConverter(CreateSRGBProfile(), CreateAdobeRGBProfile())
+	lut, err := cc.LUT3D(iccLUTSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(4))
+	for i := 0; i < 200; i++ {
+		in := []float64{rng.Float64(), rng.Float64(), rng.Float64()}
+		want := cc.Convert(in)
+		r, g, b := lut.Lookup(in[0], in[1], in[2], LUTTetrahedral)
+		// Interpolation error is largest next to black, where the curves are steepest
+		if math.Abs(r-want[0]) > 5.0/255 || math.Abs(g-want[1]) > 5.0/255 || math.Abs(b-want[2]) > 5.0/255 {
+			t.Fatalf("LUT %v -> (%.4f %.4f %.4f), want %v", in, r, g, b, want)
+		}
+	}
+	if _, err := NewColorConverter(CreateSRGBProfile(), cmykTestProfile(t)).LUT3D(17); err == nil {
+		t.Error("CMYK destinations should not fit a 3D LUT")
+	}
+
+	dc := NewContext(8, 8)
+	dc.SetRGB(1, 0, 0)
+	dc.Clear()
+	adobe := CreateAdobeRGBProfile()
+	dc.ConvertToColorSpace(adobe)
+	if got := dc.Image().(*image.RGBA).RGBAAt(3, 3); maxChannelDiff(got, color.RGBA{219, 0, 0, 255}) > 1 {
+		t.Errorf("converted red = %v, want (219, 0, 0)", got)
+	}
+	if dc.GetColorProfile() != adobe {
+		t.Error("context profile should follow the conversion")
+	}
+}
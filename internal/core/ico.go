@@ -0,0 +1,457 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Windows icons and cursors (.ico, .cur)
+//
+// An icon holds several images of the same artwork at different sizes.
+// Entries are stored either as PNG files or as headerless BMPs with a
+// 1-bit transparency mask; both are read, and EncodeIcon writes PNG for
+// 256-pixel entries and 32-bit BMP for smaller ones, which is what every
+// Windows version since XP reads.
+
+// IconEntry is one image of an icon or cursor
+type IconEntry struct {
+	Image image.Image
+	// Hotspot is the click point of a cursor, relative to the image
+	Hotspot image.Point
+}
+
+// Icon is the decoded content of an .ico or .cur file
+type Icon struct {
+	// Cursor marks a .cur file, whose entries carry hotspots
+	Cursor  bool
+	Entries []IconEntry
+}
+
+const (
+	icoHeaderSize = 6
+	icoEntrySize  = 16
+	icoMaxSize    = 256
+	icoPNGMagic   = "\x89PNG\r\n\x1a\n"
+)
+
+func icoFormatError(details string) *AdvanceError {
+	return NewInvalidFormatError("ICO", []string{"ico", "cur"}).WithContext("details", details)
+}
+
+// NewIcon builds an icon from img at each of the given square sizes. The
+// image is scaled to fit each square and centered on a transparent
+// background. Without sizes the icon holds img itself, scaled down if
+// either side exceeds 256 pixels.
+func NewIcon(img image.Image, sizes ...int) (*Icon, error) {
+	return newIcon(img, false, image.Point{}, sizes)
+}
+
+// NewCursor builds a cursor like NewIcon does. hotspot is the click point
+// in the coordinates of img and is scaled along with every entry.
+func NewCursor(img image.Image, hotspot image.Point, sizes ...int) (*Icon, error) {
+	return newIcon(img, true, hotspot, sizes)
+}
+
+func newIcon(img image.Image, cursor bool, hotspot image.Point, sizes []int) (*Icon, error) {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, NewInvalidParameterError("img", b, "a non-empty image")
+	}
+	icon := &Icon{Cursor: cursor}
+	hotspot = hotspot.Sub(b.Min)
+	if len(sizes) == 0 && b.Dx() <= icoMaxSize && b.Dy() <= icoMaxSize {
+		icon.Entries = []IconEntry{{Image: img, Hotspot: hotspot}}
+		return icon, nil
+	}
+
+	squares := true
+	if len(sizes) == 0 {
+		sizes, squares = []int{icoMaxSize}, false
+	}
+	for _, size := range sizes {
+		if size < 1 || size > icoMaxSize {
+			return nil, NewOutOfBoundsError("NewIcon", size, 1, icoMaxSize)
+		}
+		scale := math.Min(float64(size)/float64(b.Dx()), float64(size)/float64(b.Dy()))
+		w := max(1, int(math.Round(float64(b.Dx())*scale)))
+		h := max(1, int(math.Round(float64(b.Dy())*scale)))
+		canvas := image.Rect(0, 0, w, h)
+		if squares {
+			canvas = image.Rect(0, 0, size, size)
+		}
+		offset := image.Pt((canvas.Dx()-w)/2, (canvas.Dy()-h)/2)
+		dst := image.NewRGBA(canvas)
+		xdraw.CatmullRom.Scale(dst, image.Rectangle{offset, offset.Add(image.Pt(w, h))}, img, b, xdraw.Src, nil)
+		spot := image.Pt(int(float64(hotspot.X)*scale), int(float64(hotspot.Y)*scale)).Add(offset)
+		icon.Entries = append(icon.Entries, IconEntry{Image: dst, Hotspot: spot})
+	}
+	return icon, nil
+}
+
+// Largest returns the entry image with the most pixels
+func (ic *Icon) Largest() image.Image {
+	var best image.Image
+	area := -1
+	for _, e := range ic.Entries {
+		if s := e.Image.Bounds().Size(); s.X*s.Y > area {
+			best, area = e.Image, s.X*s.Y
+		}
+	}
+	return best
+}
+
+// LoadIcon loads every entry of an .ico or .cur file
+func LoadIcon(path string) (*Icon, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	icon, err := DecodeIcon(file)
+	if err != nil {
+		if ae, ok := err.(*AdvanceError); ok {
+			ae.WithContext("filepath", path)
+		}
+		return nil, err
+	}
+	return icon, nil
+}
+
+// SaveIcon writes an icon, or a cursor if icon.Cursor is set, to disk
+func SaveIcon(path string, icon *Icon) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return EncodeIcon(file, icon)
+}
+
+// icoDirEntry is one 16-byte directory entry
+type icoDirEntry struct {
+	width, height int
+	planes, bits  int // the hotspot in cursors
+	size, offset  int
+}
+
+func readIcoDirectory(r io.Reader) ([]icoDirEntry, bool, error) {
+	var header [icoHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, false, icoFormatError("truncated header")
+	}
+	kind := binary.LittleEndian.Uint16(header[2:])
+	count := int(binary.LittleEndian.Uint16(header[4:]))
+	if binary.LittleEndian.Uint16(header[0:]) != 0 || (kind != 1 && kind != 2) {
+		return nil, false, icoFormatError("not an icon or cursor")
+	}
+	if count == 0 {
+		return nil, false, icoFormatError("no images")
+	}
+	dir := make([]byte, count*icoEntrySize)
+	if _, err := io.ReadFull(r, dir); err != nil {
+		return nil, false, icoFormatError("truncated directory")
+	}
+	entries := make([]icoDirEntry, count)
+	for i := range entries {
+		d := dir[i*icoEntrySize:]
+		e := &entries[i]
+		e.width, e.height = int(d[0]), int(d[1])
+		if e.width == 0 {
+			e.width = icoMaxSize
+		}
+		if e.height == 0 {
+			e.height = icoMaxSize
+		}
+		e.planes = int(binary.LittleEndian.Uint16(d[4:]))
+		e.bits = int(binary.LittleEndian.Uint16(d[6:]))
+		e.size = int(binary.LittleEndian.Uint32(d[8:]))
+		e.offset = int(binary.LittleEndian.Uint32(d[12:]))
+	}
+	return entries, kind == 2, nil
+}
+
+// DecodeIconConfig returns the size of the largest entry of an icon or
+// cursor, as given by its directory
+func DecodeIconConfig(r io.Reader) (image.Config, error) {
+	entries, _, err := readIcoDirectory(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	best := entries[0]
+	for _, e := range entries[1:] {
+		if e.width*e.height > best.width*best.height {
+			best = e
+		}
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: best.width, Height: best.height}, nil
+}
+
+// DecodeIcon reads every entry of an .ico or .cur file
+func DecodeIcon(r io.Reader) (*Icon, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entries, cursor, err := readIcoDirectory(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	icon := &Icon{Cursor: cursor}
+	var pixels int64
+	for i, e := range entries {
+		if e.offset < 0 || e.size <= 0 || e.offset+e.size > len(data) || e.offset+e.size < e.offset {
+			return nil, icoFormatError(fmt.Sprintf("entry %d lies outside the file", i))
+		}
+		payload := data[e.offset : e.offset+e.size]
+		// Entries may share their data, so the pixels of every entry count
+		// towards the limit
+		isPNG := bytes.HasPrefix(payload, []byte(icoPNGMagic))
+		var config image.Config
+		if isPNG {
+			config, err = png.DecodeConfig(bytes.NewReader(payload))
+		} else {
+			config, err = decodeIcoBMPConfig(payload)
+		}
+		if err != nil {
+			return nil, icoFormatError(fmt.Sprintf("entry %d: %v", i, err))
+		}
+		if err := checkDecodeSize("DecodeIcon", config.Width, config.Height, pixels); err != nil {
+			return nil, err
+		}
+		pixels += int64(config.Width) * int64(config.Height)
+
+		var img image.Image
+		if isPNG {
+			if img, err = png.Decode(bytes.NewReader(payload)); err != nil {
+				return nil, icoFormatError(fmt.Sprintf("entry %d: %v", i, err))
+			}
+		} else if img, err = decodeIcoBMP(payload); err != nil {
+			return nil, err
+		}
+		entry := IconEntry{Image: img}
+		if cursor {
+			entry.Hotspot = image.Pt(e.planes, e.bits)
+		}
+		icon.Entries = append(icon.Entries, entry)
+	}
+	return icon, nil
+}
+
+// decodeIcoImage decodes the largest entry, for image.Decode
+func decodeIcoImage(r io.Reader) (image.Image, error) {
+	icon, err := DecodeIcon(r)
+	if err != nil {
+		return nil, err
+	}
+	return icon.Largest(), nil
+}
+
+// decodeIcoBMPConfig reads the size of a BMP entry from its header
+func decodeIcoBMPConfig(data []byte) (image.Config, error) {
+	if len(data) < 40 {
+		return image.Config{}, fmt.Errorf("truncated bitmap header")
+	}
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      int(int32(binary.LittleEndian.Uint32(data[4:]))),
+		Height:     int(int32(binary.LittleEndian.Uint32(data[8:]))) / 2,
+	}, nil
+}
+
+// decodeIcoBMP decodes a BMP entry: a BITMAPINFOHEADER with a doubled
+// height, the color pixels bottom-up and a 1-bit AND mask where set bits
+// are transparent
+func decodeIcoBMP(data []byte) (image.Image, error) {
+	if len(data) < 40 {
+		return nil, icoFormatError("truncated bitmap header")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data))
+	width := int(int32(binary.LittleEndian.Uint32(data[4:])))
+	height := int(int32(binary.LittleEndian.Uint32(data[8:]))) / 2
+	bits := int(binary.LittleEndian.Uint16(data[14:]))
+	compression := binary.LittleEndian.Uint32(data[16:])
+	colors := int(binary.LittleEndian.Uint32(data[32:]))
+	if headerSize < 40 || headerSize > len(data) || width <= 0 || height <= 0 || width > icoMaxSize || height > icoMaxSize {
+		return nil, icoFormatError("invalid bitmap header")
+	}
+	switch {
+	case compression == 0 && (bits == 1 || bits == 4 || bits == 8 || bits == 16 || bits == 24 || bits == 32):
+	case compression == 3 && bits == 32: // bit fields, taken to be BGRA
+	default:
+		return nil, NewUnsupportedOperationError("DecodeIcon", fmt.Sprintf("%d-bit bitmaps with compression %d are not supported", bits, compression))
+	}
+
+	pos := headerSize
+	var palette []color.NRGBA
+	if bits <= 8 {
+		if colors == 0 || colors > 1<<bits {
+			colors = 1 << bits
+		}
+		if pos+colors*4 > len(data) {
+			return nil, icoFormatError("truncated palette")
+		}
+		palette = make([]color.NRGBA, colors)
+		for i := range palette {
+			p := data[pos+i*4:]
+			palette[i] = color.NRGBA{p[2], p[1], p[0], 255}
+		}
+		pos += colors * 4
+	}
+
+	stride := (width*bits + 31) / 32 * 4
+	maskStride := (width + 31) / 32 * 4
+	if pos+stride*height > len(data) {
+		return nil, icoFormatError("truncated bitmap")
+	}
+	pixels := data[pos : pos+stride*height]
+	var mask []byte
+	if end := pos + stride*height + maskStride*height; end <= len(data) {
+		mask = data[pos+stride*height : end]
+	} else if bits != 32 {
+		return nil, icoFormatError("truncated transparency mask")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	alphaSeen := false
+	for y := 0; y < height; y++ {
+		row := pixels[(height-1-y)*stride:]
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+			switch bits {
+			case 32:
+				c = color.NRGBA{row[x*4+2], row[x*4+1], row[x*4], row[x*4+3]}
+				alphaSeen = alphaSeen || c.A != 0
+			case 24:
+				c = color.NRGBA{row[x*3+2], row[x*3+1], row[x*3], 255}
+			case 16:
+				c = tgaColor(row[x*2:], 15, false)
+			default:
+				index := int(row[x*bits/8]>>(8-bits-x*bits%8)) & (1<<bits - 1)
+				if index >= len(palette) {
+					return nil, icoFormatError("color index outside the palette")
+				}
+				c = palette[index]
+			}
+			o := img.PixOffset(x, y)
+			img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = c.R, c.G, c.B, c.A
+		}
+	}
+
+	// Without an alpha channel, or with one left empty, the mask decides
+	if !alphaSeen && mask != nil {
+		for y := 0; y < height; y++ {
+			row := mask[(height-1-y)*maskStride:]
+			for x := 0; x < width; x++ {
+				a := uint8(255)
+				if row[x/8]>>(7-x%8)&1 != 0 {
+					a = 0
+				}
+				img.Pix[img.PixOffset(x, y)+3] = a
+			}
+		}
+	} else if !alphaSeen {
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 255
+		}
+	}
+	return img, nil
+}
+
+// EncodeIcon writes icon as an .ico file, or a .cur file if icon.Cursor is
+// set. Entries must be at most 256 pixels on each side.
+func EncodeIcon(w io.Writer, icon *Icon) error {
+	if icon == nil || len(icon.Entries) == 0 || len(icon.Entries) > 0xffff {
+		return NewInvalidParameterError("icon", icon, "an icon with 1 to 65535 entries")
+	}
+	payloads := make([][]byte, len(icon.Entries))
+	for i, e := range icon.Entries {
+		s := e.Image.Bounds().Size()
+		if s.X < 1 || s.Y < 1 || s.X > icoMaxSize || s.Y > icoMaxSize {
+			return NewInvalidParameterError("icon", s, "entries between 1 and 256 pixels on each side")
+		}
+		if s.X == icoMaxSize || s.Y == icoMaxSize {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, e.Image); err != nil {
+				return err
+			}
+			payloads[i] = buf.Bytes()
+		} else {
+			payloads[i] = encodeIcoBMP(e.Image)
+		}
+	}
+
+	kind := uint16(1)
+	if icon.Cursor {
+		kind = 2
+	}
+	header := make([]byte, icoHeaderSize+len(icon.Entries)*icoEntrySize)
+	binary.LittleEndian.PutUint16(header[2:], kind)
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(icon.Entries)))
+	offset := len(header)
+	for i, e := range icon.Entries {
+		d := header[icoHeaderSize+i*icoEntrySize:]
+		s := e.Image.Bounds().Size()
+		d[0], d[1] = byte(s.X), byte(s.Y) // 256 wraps to 0 as the format wants
+		if icon.Cursor {
+			binary.LittleEndian.PutUint16(d[4:], uint16(e.Hotspot.X))
+			binary.LittleEndian.PutUint16(d[6:], uint16(e.Hotspot.Y))
+		} else {
+			binary.LittleEndian.PutUint16(d[4:], 1)
+			binary.LittleEndian.PutUint16(d[6:], 32)
+		}
+		binary.LittleEndian.PutUint32(d[8:], uint32(len(payloads[i])))
+		binary.LittleEndian.PutUint32(d[12:], uint32(offset))
+		offset += len(payloads[i])
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, p := range payloads {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeIcoBMP writes a 32-bit BGRA entry with a matching AND mask, so
+// readers that ignore alpha still see the transparent areas
+func encodeIcoBMP(img image.Image) []byte {
+	src := imageToNRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	maskStride := (width + 31) / 32 * 4
+	data := make([]byte, 40+width*height*4+maskStride*height)
+	binary.LittleEndian.PutUint32(data, 40)
+	binary.LittleEndian.PutUint32(data[4:], uint32(width))
+	binary.LittleEndian.PutUint32(data[8:], uint32(height*2))
+	binary.LittleEndian.PutUint16(data[12:], 1)
+	binary.LittleEndian.PutUint16(data[14:], 32)
+	binary.LittleEndian.PutUint32(data[20:], uint32(len(data)-40))
+
+	pixels := data[40:]
+	mask := data[40+width*height*4:]
+	for y := 0; y < height; y++ {
+		row := pixels[(height-1-y)*width*4:]
+		maskRow := mask[(height-1-y)*maskStride:]
+		for x := 0; x < width; x++ {
+			p := src.Pix[y*src.Stride+x*4:]
+			row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = p[2], p[1], p[0], p[3]
+			if p[3] == 0 {
+				maskRow[x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	return data
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"image"
 	"image/color"
+	"math"
 )
 
 // ImageData represents pixel data that can be manipulated directly
@@ -10,6 +11,10 @@ type ImageData struct {
 	Data   []uint8 // RGBA data: [R, G, B, A, R, G, B, A, ...]
 	Width  int
 	Height int
+	// Format is FormatRGBA8 for Data; 16-bit and float pixels are kept
+	// as premultiplied samples in FloatData instead
+	Format    PixelFormat
+	FloatData []float32
 }
 
 // NewImageData creates a new ImageData with the specified dimensions
@@ -21,8 +26,34 @@ func NewImageData(width, height int) *ImageData {
 	}
 }
 
-// NewImageDataFromImage creates ImageData from an existing image
+// NewImageDataWithFormat creates a new ImageData holding pixels of the
+// given format
+func NewImageDataWithFormat(width, height int, format PixelFormat) *ImageData {
+	if format == FormatRGBA8 {
+		return NewImageData(width, height)
+	}
+	return &ImageData{
+		Width:     width,
+		Height:    height,
+		Format:    format,
+		FloatData: make([]float32, width*height*4),
+	}
+}
+
+// NewImageDataFromImage creates ImageData from an existing image. 16-bit
+// and float images keep their precision.
 func NewImageDataFromImage(img image.Image) *ImageData {
+	if deep, ok := asDeepCanvas(img); ok {
+		bounds := img.Bounds()
+		id := NewImageDataWithFormat(bounds.Dx(), bounds.Dy(), deep.format())
+		for y := 0; y < id.Height; y++ {
+			for x := 0; x < id.Width; x++ {
+				p := deep.pixel(bounds.Min.X+x, bounds.Min.Y+y)
+				copy(id.FloatData[(y*id.Width+x)*4:], p[:])
+			}
+		}
+		return id
+	}
 	bounds := img.Bounds()
 	width := bounds.Max.X - bounds.Min.X
 	height := bounds.Max.Y - bounds.Min.Y
@@ -55,6 +86,10 @@ func (id *ImageData) GetPixel(x, y int) (r, g, b, a uint8) {
 	}
 	
 	index := (y*id.Width + x) * 4
+	if id.FloatData != nil {
+		f := id.FloatData[index : index+4]
+		return unitTo8(f[0], f[3]), unitTo8(f[1], f[3]), unitTo8(f[2], f[3]), unitTo8(f[3], 1)
+	}
 	return id.Data[index], id.Data[index+1], id.Data[index+2], id.Data[index+3]
 }
 
@@ -65,12 +100,66 @@ func (id *ImageData) SetPixel(x, y int, r, g, b, a uint8) {
 	}
 	
 	index := (y*id.Width + x) * 4
+	if id.FloatData != nil {
+		id.SetPixelFloat(x, y, float32(r)/255, float32(g)/255, float32(b)/255, float32(a)/255)
+		return
+	}
 	id.Data[index] = r
 	id.Data[index+1] = g
 	id.Data[index+2] = b
 	id.Data[index+3] = a
 }
 
+// GetPixelFloat returns the premultiplied samples at the specified
+// coordinates on a 0-1 scale, at the full precision of the format
+func (id *ImageData) GetPixelFloat(x, y int) (r, g, b, a float32) {
+	if x < 0 || x >= id.Width || y < 0 || y >= id.Height {
+		return 0, 0, 0, 0
+	}
+	index := (y*id.Width + x) * 4
+	if id.FloatData == nil {
+		d := id.Data[index : index+4]
+		return float32(d[0]) / 255, float32(d[1]) / 255, float32(d[2]) / 255, float32(d[3]) / 255
+	}
+	f := id.FloatData[index : index+4]
+	return f[0], f[1], f[2], f[3]
+}
+
+// SetPixelFloat sets the premultiplied samples at the specified
+// coordinates. 8-bit and 16-bit data round and clamp them to 0-1.
+func (id *ImageData) SetPixelFloat(x, y int, r, g, b, a float32) {
+	if x < 0 || x >= id.Width || y < 0 || y >= id.Height {
+		return
+	}
+	index := (y*id.Width + x) * 4
+	switch id.Format {
+	case FormatRGBAF32:
+		copy(id.FloatData[index:], []float32{r, g, b, a})
+	case FormatRGBA16:
+		q := func(v, limit float32) float32 { return float32(unitTo16(v, limit)) / 0xffff }
+		copy(id.FloatData[index:], []float32{q(r, a), q(g, a), q(b, a), q(a, 1)})
+	default:
+		id.SetPixel(x, y, unitTo8(r, a), unitTo8(g, a), unitTo8(b, a), unitTo8(a, 1))
+	}
+}
+
+// copyPixelTo copies the pixel at x, y to dx, dy in dst, at full precision
+// when both hold high bit-depth data
+func (id *ImageData) copyPixelTo(dst *ImageData, x, y, dx, dy int) {
+	if id.FloatData != nil && dst.FloatData != nil {
+		r, g, b, a := id.GetPixelFloat(x, y)
+		dst.SetPixelFloat(dx, dy, r, g, b, a)
+		return
+	}
+	r, g, b, a := id.GetPixel(x, y)
+	dst.SetPixel(dx, dy, r, g, b, a)
+}
+
+// unitTo8 converts a sample in 0..limit to 8 bits
+func unitTo8(v, limit float32) uint8 {
+	return uint8(unitTo16(v, limit) >> 8)
+}
+
 // GetPixelColor returns the color at the specified coordinates
 func (id *ImageData) GetPixelColor(x, y int) color.RGBA {
 	r, g, b, a := id.GetPixel(x, y)
@@ -83,7 +172,40 @@ func (id *ImageData) SetPixelColor(x, y int, c color.Color) {
 	id.SetPixel(x, y, uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8))
 }
 
-// ToImage converts ImageData to a standard Go image
+// Image converts ImageData to an image of its own format: *image.RGBA,
+// *image.RGBA64 or *RGBAF32
+func (id *ImageData) Image() image.Image {
+	if id.FloatData == nil {
+		return id.ToImage()
+	}
+	dst := newDeepCanvas(id.Format, image.Rect(0, 0, id.Width, id.Height))
+	for y := 0; y < id.Height; y++ {
+		for x := 0; x < id.Width; x++ {
+			r, g, b, a := id.GetPixelFloat(x, y)
+			dst.setPixel(x, y, [4]float32{r, g, b, a})
+		}
+	}
+	return dst.image()
+}
+
+// withFormat returns img as ImageData in the format of id, so operations
+// that work in 8 bits hand back the format they were given
+func (id *ImageData) withFormat(img image.Image) *ImageData {
+	out := NewImageDataFromImage(img)
+	if out.Format == id.Format {
+		return out
+	}
+	converted := NewImageDataWithFormat(out.Width, out.Height, id.Format)
+	for y := 0; y < out.Height; y++ {
+		for x := 0; x < out.Width; x++ {
+			r, g, b, a := out.GetPixelFloat(x, y)
+			converted.SetPixelFloat(x, y, r, g, b, a)
+		}
+	}
+	return converted
+}
+
+// ToImage converts ImageData to a standard 8-bit Go image
 func (id *ImageData) ToImage() *image.RGBA {
 	img := image.NewRGBA(image.Rect(0, 0, id.Width, id.Height))
 	
@@ -102,15 +224,29 @@ func (id *ImageData) Clone() *ImageData {
 	newData := make([]uint8, len(id.Data))
 	copy(newData, id.Data)
 	
-	return &ImageData{
+	clone := &ImageData{
 		Data:   newData,
 		Width:  id.Width,
 		Height: id.Height,
+		Format: id.Format,
+	}
+	if id.FloatData != nil {
+		clone.Data = nil
+		clone.FloatData = append([]float32(nil), id.FloatData...)
 	}
+	return clone
 }
 
 // Fill fills the entire ImageData with the specified color
 func (id *ImageData) Fill(r, g, b, a uint8) {
+	if id.Format != FormatRGBA8 {
+		for y := 0; y < id.Height; y++ {
+			for x := 0; x < id.Width; x++ {
+				id.SetPixelFloat(x, y, float32(r)/255, float32(g)/255, float32(b)/255, float32(a)/255)
+			}
+		}
+		return
+	}
 	for i := 0; i < len(id.Data); i += 4 {
 		id.Data[i] = r
 		id.Data[i+1] = g
@@ -133,8 +269,7 @@ func (id *ImageData) CopyFrom(src *ImageData, srcX, srcY, srcWidth, srcHeight, d
 	for dy := 0; dy < srcHeight; dy++ {
 		for dx := 0; dx < srcWidth; dx++ {
 			if srcX+dx >= 0 && srcX+dx < src.Width && srcY+dy >= 0 && srcY+dy < src.Height {
-				r, g, b, a := src.GetPixel(srcX+dx, srcY+dy)
-				id.SetPixel(dstX+dx, dstY+dy, r, g, b, a)
+				src.copyPixelTo(id, srcX+dx, srcY+dy, dstX+dx, dstY+dy)
 			}
 		}
 	}
@@ -142,8 +277,14 @@ func (id *ImageData) CopyFrom(src *ImageData, srcX, srcY, srcWidth, srcHeight, d
 
 // ApplyKernel applies a convolution kernel to the ImageData
 func (id *ImageData) ApplyKernel(kernel [][]float64) *ImageData {
-	result := id.Clone()
 	kernelSize := len(kernel)
+	if id.FloatData != nil {
+		return id.applyKernelFloat(kernel)
+	}
+	if kernelSize > FFTKernelThreshold {
+		return id.applyKernelFFT(kernel)
+	}
+	result := id.Clone()
 	offset := kernelSize / 2
 	
 	for y := offset; y < id.Height-offset; y++ {
@@ -177,15 +318,43 @@ func (id *ImageData) ApplyKernel(kernel [][]float64) *ImageData {
 	return result
 }
 
+// applyKernelFloat convolves high bit-depth data without rounding to 8 bits
+func (id *ImageData) applyKernelFloat(kernel [][]float64) *ImageData {
+	result := id.Clone()
+	offset := len(kernel) / 2
+	
+	for y := offset; y < id.Height-offset; y++ {
+		for x := offset; x < id.Width-offset; x++ {
+			var sum [3]float64
+			for ky, row := range kernel {
+				for kx, weight := range row {
+					r, g, b, _ := id.GetPixelFloat(x+kx-offset, y+ky-offset)
+					sum[0] += float64(r) * weight
+					sum[1] += float64(g) * weight
+					sum[2] += float64(b) * weight
+				}
+			}
+			_, _, _, a := id.GetPixelFloat(x, y)
+			limit := float64(a)
+			if id.Format == FormatRGBAF32 {
+				limit = math.Inf(1)
+			}
+			result.SetPixelFloat(x, y,
+				float32(clamp(sum[0], 0, limit)), float32(clamp(sum[1], 0, limit)), float32(clamp(sum[2], 0, limit)), a)
+		}
+	}
+	
+	return result
+}
+
 // GetSubImageData extracts a rectangular region as new ImageData
 func (id *ImageData) GetSubImageData(x, y, width, height int) *ImageData {
-	subData := NewImageData(width, height)
+	subData := NewImageDataWithFormat(width, height, id.Format)
 	
 	for dy := 0; dy < height; dy++ {
 		for dx := 0; dx < width; dx++ {
 			if x+dx >= 0 && x+dx < id.Width && y+dy >= 0 && y+dy < id.Height {
-				r, g, b, a := id.GetPixel(x+dx, y+dy)
-				subData.SetPixel(dx, dy, r, g, b, a)
+				id.copyPixelTo(subData, x+dx, y+dy, dx, dy)
 			}
 		}
 	}
@@ -195,7 +364,7 @@ func (id *ImageData) GetSubImageData(x, y, width, height int) *ImageData {
 
 // Resize creates a new ImageData with different dimensions using nearest neighbor
 func (id *ImageData) Resize(newWidth, newHeight int) *ImageData {
-	result := NewImageData(newWidth, newHeight)
+	result := NewImageDataWithFormat(newWidth, newHeight, id.Format)
 	
 	xRatio := float64(id.Width) / float64(newWidth)
 	yRatio := float64(id.Height) / float64(newHeight)
@@ -205,8 +374,7 @@ func (id *ImageData) Resize(newWidth, newHeight int) *ImageData {
 			srcX := int(float64(x) * xRatio)
 			srcY := int(float64(y) * yRatio)
 			
-			r, g, b, a := id.GetPixel(srcX, srcY)
-			result.SetPixel(x, y, r, g, b, a)
+			id.copyPixelTo(result, srcX, srcY, x, y)
 		}
 	}
 	
@@ -215,12 +383,11 @@ func (id *ImageData) Resize(newWidth, newHeight int) *ImageData {
 
 // FlipHorizontal flips the ImageData horizontally
 func (id *ImageData) FlipHorizontal() *ImageData {
-	result := NewImageData(id.Width, id.Height)
+	result := NewImageDataWithFormat(id.Width, id.Height, id.Format)
 	
 	for y := 0; y < id.Height; y++ {
 		for x := 0; x < id.Width; x++ {
-			r, g, b, a := id.GetPixel(x, y)
-			result.SetPixel(id.Width-1-x, y, r, g, b, a)
+			id.copyPixelTo(result, x, y, id.Width-1-x, y)
 		}
 	}
 	
@@ -229,12 +396,11 @@ func (id *ImageData) FlipHorizontal() *ImageData {
 
 // FlipVertical flips the ImageData vertically
 func (id *ImageData) FlipVertical() *ImageData {
-	result := NewImageData(id.Width, id.Height)
+	result := NewImageDataWithFormat(id.Width, id.Height, id.Format)
 	
 	for y := 0; y < id.Height; y++ {
 		for x := 0; x < id.Width; x++ {
-			r, g, b, a := id.GetPixel(x, y)
-			result.SetPixel(x, id.Height-1-y, r, g, b, a)
+			id.copyPixelTo(result, x, y, x, id.Height-1-y)
 		}
 	}
 	
@@ -243,12 +409,11 @@ func (id *ImageData) FlipVertical() *ImageData {
 
 // Rotate90 rotates the ImageData 90 degrees clockwise
 func (id *ImageData) Rotate90() *ImageData {
-	result := NewImageData(id.Height, id.Width)
+	result := NewImageDataWithFormat(id.Height, id.Width, id.Format)
 	
 	for y := 0; y < id.Height; y++ {
 		for x := 0; x < id.Width; x++ {
-			r, g, b, a := id.GetPixel(x, y)
-			result.SetPixel(id.Height-1-y, x, r, g, b, a)
+			id.copyPixelTo(result, x, y, id.Height-1-y, x)
 		}
 	}
 	
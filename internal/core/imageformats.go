@@ -0,0 +1,135 @@
+package core
+
+import (
+	"image"
+	"image/draw"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	// image.Decode tries formats in registration order. Icons go before
+	// TGA, whose header pattern has no real signature.
+	image.RegisterFormat("qoi", "qoif", DecodeQOI, DecodeQOIConfig)
+	for _, magic := range []string{"P1", "P2", "P3", "P4", "P5", "P6", "P7"} {
+		image.RegisterFormat("netpbm", magic, DecodeNetpbm, DecodeNetpbmConfig)
+	}
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", decodeIcoImage, DecodeIconConfig)
+	image.RegisterFormat("cur", "\x00\x00\x02\x00", decodeIcoImage, DecodeIconConfig)
+	// An ID field of any length, then the color map type and image type.
+	// True-color and grayscale images have an empty color map spec.
+	for _, magic := range []string{
+		"?\x00\x02\x00\x00\x00\x00\x00", "?\x00\x0a\x00\x00\x00\x00\x00",
+		"?\x00\x03\x00\x00\x00\x00\x00", "?\x00\x0b\x00\x00\x00\x00\x00",
+		"?\x01\x01", "?\x01\x09",
+	} {
+		image.RegisterFormat("tga", magic, DecodeTGA, DecodeTGAConfig)
+	}
+}
+
+// SaveOptions configures SaveImage. Options for formats other than the one
+// picked by the extension are ignored.
+type SaveOptions struct {
+	// Quality is the JPEG quality from 1 to 100, 90 by default
+	Quality int
+	// EXR configures .exr files; see SaveEXR for the defaults
+	EXR *EXROptions
+	// Netpbm configures .pbm, .pgm, .ppm, .pnm and .pam files. The
+	// extension picks the format except for .pnm, which uses Netpbm.Format.
+	Netpbm *NetpbmOptions
+	// TGA configures .tga files
+	TGA *TGAOptions
+	// IconSizes lists the square sizes written to .ico and .cur files. By
+	// default the image is written at its own size.
+	IconSizes []int
+	// Hotspot is the click point of a .cur cursor, in image coordinates
+	Hotspot image.Point
+}
+
+// saveFormats lists the extensions SaveImage understands
+var saveFormats = []string{
+	"png", "jpg", "jpeg", "gif", "bmp", "tif", "tiff", "qoi", "pbm", "pgm", "ppm",
+	"pnm", "pam", "tga", "ico", "cur", "hdr", "exr",
+}
+
+// SaveImage writes img to path with the encoder that matches the file
+// extension. opts may be nil.
+func SaveImage(path string, img image.Image, opts *SaveOptions) error {
+	if opts == nil {
+		opts = &SaveOptions{}
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	switch ext {
+	case "png":
+		return SavePNG(path, img)
+	case "jpg", "jpeg":
+		quality := opts.Quality
+		if quality <= 0 {
+			quality = 90
+		}
+		return SaveJPEG(path, img, quality)
+	case "gif":
+		return SaveGIF(path, img)
+	case "bmp":
+		return SaveBMP(path, img)
+	case "tif", "tiff":
+		return SaveTIFF(path, img)
+	case "qoi":
+		return SaveQOI(path, img)
+	case "pbm", "pgm", "ppm", "pnm", "pam":
+		netpbm := NetpbmOptions{}
+		if opts.Netpbm != nil {
+			netpbm = *opts.Netpbm
+		}
+		if ext != "pnm" {
+			netpbm.Format = map[string]NetpbmFormat{
+				"pbm": NetpbmPBM, "pgm": NetpbmPGM, "ppm": NetpbmPPM, "pam": NetpbmPAM,
+			}[ext]
+		}
+		return SaveNetpbm(path, img, &netpbm)
+	case "tga":
+		return SaveTGA(path, img, opts.TGA)
+	case "ico", "cur":
+		var icon *Icon
+		var err error
+		if ext == "cur" {
+			icon, err = NewCursor(img, opts.Hotspot, opts.IconSizes...)
+		} else {
+			icon, err = NewIcon(img, opts.IconSizes...)
+		}
+		if err != nil {
+			return err
+		}
+		return SaveIcon(path, icon)
+	case "hdr":
+		return SaveHDR(path, img)
+	case "exr":
+		return SaveEXR(path, img, opts.EXR)
+	}
+	return NewInvalidFormatError(path, saveFormats)
+}
+
+// imageToNRGBA returns a copy of img with straight alpha, anchored at the
+// origin
+func imageToNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
+
+// isOpaqueImage reports whether every pixel of img is fully opaque
+func isOpaqueImage(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,320 @@
+package core
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// formatScene returns an image with gradients, noise and flat areas, and
+// transparency in its lower half when alpha is set
+func formatScene(w, h int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rng := rand.New(rand.NewSource(11))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8(rng.Intn(256)), 255}
+			if x < w/3 {
+				c = color.NRGBA{200, 40, 40, 255}
+			}
+			if alpha && y > h/2 {
+				c.A = uint8(x * 255 / w)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// sameNRGBA compares straight colors, ignoring the color of transparent pixels
+func sameNRGBA(t *testing.T, name string, got image.Image, want *image.NRGBA) {
+	t.Helper()
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("%s: size %v, want %v", name, got.Bounds().Size(), want.Bounds().Size())
+	}
+	g := imageToNRGBA(got)
+	for y := 0; y < want.Rect.Dy(); y++ {
+		for x := 0; x < want.Rect.Dx(); x++ {
+			a, b := g.NRGBAAt(x, y), want.NRGBAAt(x, y)
+			if a.A == 0 && b.A == 0 {
+				continue
+			}
+			if a != b {
+				t.Fatalf("%s: pixel (%d, %d) = %v, want %v", name, x, y, a, b)
+			}
+		}
+	}
+}
+
+func TestQOI(t *testing.T) {
+	// Pixels that exercise a run, a small difference and a full color
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{1, 1, 1, 255})
+	src.SetNRGBA(2, 0, color.NRGBA{10, 20, 30, 255})
+	var buf bytes.Buffer
+	if err := EncodeQOI(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("qoif\x00\x00\x00\x03\x00\x00\x00\x01\x03\x00\xc0\x7f\xfe\x0a\x14\x1e\x00\x00\x00\x00\x00\x00\x00\x01")
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("encoded % x\nwant    % x", buf.Bytes(), want)
+	}
+
+	for _, alpha := range []bool{false, true} {
+		src := formatScene(70, 40, alpha)
+		buf.Reset()
+		if err := EncodeQOI(&buf, src); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		img, err := DecodeQOI(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameNRGBA(t, "qoi", img, src)
+		if _, err := DecodeQOI(bytes.NewReader(data[:len(data)/2])); err == nil {
+			t.Error("expected an error for truncated data")
+		}
+	}
+}
+
+func TestNetpbmDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []uint8 // gray values, or RGBA
+	}{
+		{"plain pbm", "P1\n# comment\n3 2\n101\n0 1 0\n", []uint8{0, 255, 0, 255, 0, 255}},
+		{"raw pbm", "P4 3 2\n\xa0\x40", []uint8{0, 255, 0, 255, 0, 255}},
+		{"plain pgm", "P2 2 1 4\n0 # note\n 2\n", []uint8{0, 128}},
+		{"raw ppm", "P6 1 1 255\n\x01\x02\x03", []uint8{1, 2, 3, 255}},
+		{"16-bit ppm", "P6 1 1 65535\n\x01\x00\x02\x00\x03\x00", []uint8{1, 0, 2, 0, 3, 0, 0xff, 0xff}},
+		{"pam", "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 2\nMAXVAL 255\nTUPLTYPE GRAYSCALE_ALPHA\nENDHDR\n\x50\x80", []uint8{0x50, 0x50, 0x50, 0x80}},
+	}
+	for _, tt := range tests {
+		img, err := DecodeNetpbm(bytes.NewReader([]byte(tt.data)))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var pix []uint8
+		switch m := img.(type) {
+		case *image.Gray:
+			pix = m.Pix
+		case *image.RGBA:
+			pix = m.Pix
+		case *image.RGBA64:
+			pix = m.Pix
+		case *image.NRGBA:
+			pix = m.Pix
+		}
+		if !bytes.Equal(pix, tt.want) {
+			t.Errorf("%s: %T pixels %v, want %v", tt.name, img, pix, tt.want)
+		}
+	}
+
+	for _, data := range []string{"P5 2 2 255\n\x01", "P3 1 1 255\n1 2", "P5 0 1 255\n", "P2 1 1 70000\n1", "P8 1 1\n"} {
+		if _, err := DecodeNetpbm(bytes.NewReader([]byte(data))); err == nil {
+			t.Errorf("expected an error for %q", data)
+		}
+	}
+}
+
+func TestNetpbmRoundTrip(t *testing.T) {
+	color8 := formatScene(37, 9, false)
+	gray := image.NewGray(color8.Rect)
+	deep := image.NewRGBA64(color8.Rect)
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 37; x++ {
+			gray.SetGray(x, y, color.Gray{uint8(x * 7)})
+			deep.SetRGBA64(x, y, color.RGBA64{uint16(x * 1777), uint16(y * 7000), 12345, 0xffff})
+		}
+	}
+	translucent := formatScene(37, 9, true)
+
+	for _, plain := range []bool{false, true} {
+		for _, tt := range []struct {
+			img    image.Image
+			format NetpbmFormat
+		}{
+			{color8, NetpbmPPM}, {gray, NetpbmPGM}, {deep, NetpbmPPM}, {translucent, NetpbmPAM}, {gray, NetpbmPAM},
+		} {
+			var buf bytes.Buffer
+			if err := EncodeNetpbm(&buf, tt.img, &NetpbmOptions{Format: tt.format, Plain: plain}); err != nil {
+				t.Fatal(err)
+			}
+			img, err := DecodeNetpbm(&buf)
+			if err != nil {
+				t.Fatalf("format %d plain %v: %v", tt.format, plain, err)
+			}
+			b := tt.img.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					want := color.NRGBA64Model.Convert(tt.img.At(x, y))
+					if got := color.NRGBA64Model.Convert(img.At(x, y)); got != want {
+						t.Fatalf("format %d plain %v: pixel (%d, %d) = %v, want %v", tt.format, plain, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+
+	// Bitmaps threshold at mid gray, whatever the row padding
+	var buf bytes.Buffer
+	if err := EncodeNetpbm(&buf, gray, &NetpbmOptions{Format: NetpbmPBM}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := DecodeNetpbm(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 37; x++ {
+		want := uint8(0)
+		if x*7 >= 128 {
+			want = 255
+		}
+		if got := img.(*image.Gray).GrayAt(x, 3).Y; got != want {
+			t.Errorf("bitmap pixel %d = %d, want %d", x, got, want)
+		}
+	}
+}
+
+func TestTGA(t *testing.T) {
+	for _, opts := range []*TGAOptions{nil, {Uncompressed: true}} {
+		for _, alpha := range []bool{false, true} {
+			src := formatScene(300, 7, alpha) // rows longer than one packet
+			var buf bytes.Buffer
+			if err := EncodeTGA(&buf, src, opts); err != nil {
+				t.Fatal(err)
+			}
+			img, err := DecodeTGA(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameNRGBA(t, "tga", img, src)
+		}
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 5, 3))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i / 4 * 40)
+	}
+	var buf bytes.Buffer
+	if err := EncodeTGA(&buf, gray, nil); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := DecodeTGA(&buf); err != nil || !bytes.Equal(img.(*image.Gray).Pix, gray.Pix) {
+		t.Errorf("grayscale round trip = %v, %v", img, err)
+	}
+
+	// A top-down, color-mapped RLE file with 16-bit map entries: a run of
+	// three red pixels and a raw green one
+	header := []byte{0, 1, 9, 0, 0, 2, 0, 16, 0, 0, 0, 0, 2, 0, 2, 0, 8, 0x20}
+	data := append(header, 0x00, 0x7c, 0xe0, 0x03) // red and green, 5-5-5
+	data = append(data, 0x82, 0, 0x00, 1)
+	img, err := DecodeTGA(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	red, green := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}
+	for i, want := range []color.NRGBA{red, red, red, green} {
+		if got := img.(*image.NRGBA).NRGBAAt(i%2, i/2); got != want {
+			t.Errorf("mapped pixel %d = %v, want %v", i, got, want)
+		}
+	}
+	if _, err := DecodeTGA(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("expected an error for truncated pixel data")
+	}
+
+	// A true-color image with a color map of zero-bit entries
+	header = []byte{0, 1, 2, 0, 0, 1, 0, 0, 0, 0, 0, 0, 1, 0, 1, 0, 24, 0}
+	if _, err := DecodeTGA(bytes.NewReader(append(header, 0, 0, 0))); err == nil {
+		t.Error("expected an error for an invalid color map entry size")
+	}
+}
+
+func TestIcon(t *testing.T) {
+	src := formatScene(300, 200, true)
+	icon, err := NewCursor(src, image.Pt(150, 100), 16, 48, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeIcon(&buf, icon); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeIcon(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Cursor || len(got.Entries) != 3 {
+		t.Fatalf("decoded %d entries, cursor %v", len(got.Entries), got.Cursor)
+	}
+	for i, e := range got.Entries {
+		sameNRGBA(t, "icon entry", e.Image, imageToNRGBA(icon.Entries[i].Image))
+		if e.Hotspot != icon.Entries[i].Hotspot {
+			t.Errorf("entry %d hotspot = %v, want %v", i, e.Hotspot, icon.Entries[i].Hotspot)
+		}
+	}
+	if s := got.Largest().Bounds().Size(); s != image.Pt(256, 256) {
+		t.Errorf("largest entry is %v", s)
+	}
+	if h := got.Entries[1].Hotspot; h != image.Pt(24, 24) {
+		t.Errorf("48-pixel hotspot = %v", h)
+	}
+
+	// A 2x2 icon with a 1-bit palette; the AND mask hides one pixel
+	bmp := make([]byte, 40+8+8+8)
+	copy(bmp, []byte{40, 0, 0, 0, 2, 0, 0, 0, 4, 0, 0, 0, 1, 0, 1})
+	copy(bmp[40:], []byte{0, 0, 255, 0, 255, 0, 0, 0}) // red, blue
+	bmp[48], bmp[52] = 0x40, 0x80                      // bottom row 0 1, top row 1 0
+	bmp[56], bmp[60] = 0x00, 0x40                      // top-right transparent
+	file := []byte{0, 0, 1, 0, 1, 0, 2, 2, 0, 0, 1, 0, 1, 0, byte(len(bmp)), 0, 0, 0, 22, 0, 0, 0}
+	img, err := DecodeIcon(bytes.NewReader(append(file, bmp...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := img.Entries[0].Image.(*image.NRGBA)
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	for i, want := range []color.NRGBA{blue, {255, 0, 0, 0}, red, blue} {
+		if c := m.NRGBAAt(i%2, i/2); c != want {
+			t.Errorf("bitmap pixel %d = %v, want %v", i, c, want)
+		}
+	}
+
+	if _, err := NewIcon(src, 300); err == nil {
+		t.Error("expected an error for an oversized entry")
+	}
+}
+
+func TestSaveImageFormats(t *testing.T) {
+	dir := t.TempDir()
+	opaque := formatScene(40, 30, false)
+	for _, ext := range []string{"png", "bmp", "tiff", "qoi", "ppm", "pam", "tga", "ico", "cur"} {
+		path := filepath.Join(dir, "image."+ext)
+		if err := SaveImage(path, opaque, nil); err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		// LoadImage finds the format from the content
+		img, err := LoadImage(path)
+		if err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		sameNRGBA(t, ext, img, opaque)
+	}
+
+	for _, ext := range []string{"jpg", "gif", "pgm", "pbm", "hdr", "exr"} {
+		if err := SaveImage(filepath.Join(dir, "image."+ext), opaque, &SaveOptions{Quality: 80}); err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+	}
+	if img, err := LoadImage(filepath.Join(dir, "image.pgm")); err != nil || img.ColorModel() != color.GrayModel {
+		t.Errorf("pgm loaded as %v, %v", img, err)
+	}
+	if err := SaveImage(filepath.Join(dir, "image.xyz"), opaque, nil); err == nil {
+		t.Error("expected an error for an unknown extension")
+	}
+}
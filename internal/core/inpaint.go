@@ -0,0 +1,709 @@
+package core
+
+import (
+	"container/heap"
+	"image"
+	"math"
+	"math/rand"
+)
+
+// Filling masked regions from their surroundings
+
+// InpaintMethod selects the inpainting algorithm
+type InpaintMethod int
+
+const (
+	// InpaintTelea marches inward from the hole boundary with the fast
+	// marching method, which suits scratches, dust and thin text
+	InpaintTelea InpaintMethod = iota
+	// InpaintNavierStokes starts from the Telea fill and continues image
+	// edges into the hole by transporting smoothness along isophotes
+	InpaintNavierStokes
+	// InpaintPatchMatch copies texture from elsewhere in the image with
+	// multi-scale PatchMatch, which suits larger holes and removed objects
+	InpaintPatchMatch
+)
+
+// InpaintOptions configures InpaintWithOptions
+type InpaintOptions struct {
+	Method InpaintMethod
+	// Radius is the neighborhood Telea averages over (default 5)
+	Radius int
+	// PatchSize is the width of PatchMatch patches, rounded up to odd
+	// (default 7)
+	PatchSize int
+	// Seed makes PatchMatch reproducible
+	Seed int64
+}
+
+// Inpaint fills the pixels where mask is at least half opaque. The mask is
+// in the same coordinates as img, so one drawn with a Context and taken
+// with AsMask can be used directly.
+func Inpaint(img image.Image, mask *image.Alpha, method InpaintMethod) *image.RGBA {
+	return InpaintWithOptions(img, mask, InpaintOptions{Method: method})
+}
+
+// InpaintWithOptions fills the masked pixels of img
+func InpaintWithOptions(img image.Image, mask *image.Alpha, opts InpaintOptions) *image.RGBA {
+	if opts.Radius <= 0 {
+		opts.Radius = 5
+	}
+	if opts.PatchSize <= 0 {
+		opts.PatchSize = 7
+	}
+	opts.PatchSize |= 1
+
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	buf := newInpaintBuffer(src, mask)
+	result := image.NewRGBA(bounds)
+	if !buf.hasHole() || buf.holeCount() == len(buf.hole) {
+		copyRGBA(result, src)
+		return result
+	}
+
+	switch opts.Method {
+	case InpaintNavierStokes:
+		buf.telea(opts.Radius)
+		buf.navierStokes(300)
+	case InpaintPatchMatch:
+		buf.patchMatch(opts.PatchSize/2, rand.New(rand.NewSource(opts.Seed)), opts.Radius)
+	default:
+		buf.telea(opts.Radius)
+	}
+	buf.writeTo(result)
+	return result
+}
+
+// inpaintBuffer holds premultiplied pixels as floats in 0..255 and the hole
+type inpaintBuffer struct {
+	w, h int
+	pix  []float64
+	hole []bool
+}
+
+func newInpaintBuffer(src *image.RGBA, mask *image.Alpha) *inpaintBuffer {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	buf := &inpaintBuffer{w: w, h: h, pix: make([]float64, w*h*4), hole: make([]bool, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+			for c := 0; c < 4; c++ {
+				buf.pix[(y*w+x)*4+c] = float64(src.Pix[i+c])
+			}
+			if mask != nil {
+				p := image.Point{b.Min.X + x, b.Min.Y + y}
+				buf.hole[y*w+x] = p.In(mask.Rect) && mask.AlphaAt(p.X, p.Y).A >= 128
+			}
+		}
+	}
+	return buf
+}
+
+func (b *inpaintBuffer) hasHole() bool {
+	for _, v := range b.hole {
+		if v {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *inpaintBuffer) holeCount() int {
+	n := 0
+	for _, v := range b.hole {
+		if v {
+			n++
+		}
+	}
+	return n
+}
+
+func (b *inpaintBuffer) writeTo(dst *image.RGBA) {
+	for y := 0; y < b.h; y++ {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < b.w; x++ {
+			p := b.pix[(y*b.w+x)*4:]
+			a := clamp(p[3], 0, 255)
+			for c := 0; c < 3; c++ {
+				row[x*4+c] = uint8(math.Round(clamp(p[c], 0, a)))
+			}
+			row[x*4+3] = uint8(math.Round(a))
+		}
+	}
+}
+
+// Fast marching states
+const (
+	fmmKnown = iota
+	fmmBand
+	fmmInside
+)
+
+// fmmItem is a queued band pixel
+type fmmItem struct {
+	t   float64
+	idx int
+}
+
+type fmmQueue []fmmItem
+
+func (q fmmQueue) Len() int            { return len(q) }
+func (q fmmQueue) Less(i, j int) bool  { return q[i].t < q[j].t }
+func (q fmmQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *fmmQueue) Push(x interface{}) { *q = append(*q, x.(fmmItem)) }
+func (q *fmmQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+var fourNeighbors = [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+
+// telea fills the hole in order of distance from its boundary, setting each
+// pixel to an average of the known pixels within radius weighted by
+// direction, distance and level-set proximity (Telea 2004)
+func (b *inpaintBuffer) telea(radius int) {
+	w, h := b.w, b.h
+	n := w * h
+	t := make([]float64, n)
+	flag := make([]uint8, n)
+	q := &fmmQueue{}
+	for i := range t {
+		if b.hole[i] {
+			t[i] = math.Inf(1)
+			flag[i] = fmmInside
+		}
+	}
+	for i, inHole := range b.hole {
+		if !inHole {
+			continue
+		}
+		x, y := i%w, i/w
+		for _, d := range fourNeighbors {
+			nx, ny := x+d[0], y+d[1]
+			if nx >= 0 && nx < w && ny >= 0 && ny < h && flag[ny*w+nx] == fmmKnown {
+				t[i] = 1
+				flag[i] = fmmBand
+				heap.Push(q, fmmItem{1, i})
+				break
+			}
+		}
+	}
+
+	known := func(x, y int) bool {
+		return x >= 0 && x < w && y >= 0 && y < h && flag[y*w+x] == fmmKnown
+	}
+	for q.Len() > 0 {
+		item := heap.Pop(q).(fmmItem)
+		i := item.idx
+		if flag[i] == fmmKnown {
+			continue
+		}
+		x, y := i%w, i/w
+		b.teleaPixel(x, y, radius, t, known)
+		flag[i] = fmmKnown
+
+		for _, d := range fourNeighbors {
+			nx, ny := x+d[0], y+d[1]
+			if nx < 0 || nx >= w || ny < 0 || ny >= h || flag[ny*w+nx] == fmmKnown {
+				continue
+			}
+			j := ny*w + nx
+			if tj := solveEikonal(nx, ny, w, t, known); tj < t[j] {
+				t[j] = tj
+				flag[j] = fmmBand
+				heap.Push(q, fmmItem{tj, j})
+			}
+		}
+	}
+}
+
+// solveEikonal estimates the arrival time at (x, y) from known neighbors
+func solveEikonal(x, y, w int, t []float64, known func(x, y int) bool) float64 {
+	a, c := math.Inf(1), math.Inf(1)
+	if known(x-1, y) {
+		a = t[y*w+x-1]
+	}
+	if known(x+1, y) {
+		a = math.Min(a, t[y*w+x+1])
+	}
+	if known(x, y-1) {
+		c = t[(y-1)*w+x]
+	}
+	if known(x, y+1) {
+		c = math.Min(c, t[(y+1)*w+x])
+	}
+	switch {
+	case math.IsInf(a, 1) && math.IsInf(c, 1):
+		return math.Inf(1)
+	case math.IsInf(a, 1):
+		return c + 1
+	case math.IsInf(c, 1):
+		return a + 1
+	case math.Abs(a-c) >= 1:
+		return math.Min(a, c) + 1
+	default:
+		return (a + c + math.Sqrt(2-(a-c)*(a-c))) / 2
+	}
+}
+
+func (b *inpaintBuffer) teleaPixel(x, y, radius int, t []float64, known func(x, y int) bool) {
+	w := b.w
+	tp := t[y*w+x]
+
+	// Gradient of the arrival time, which points into the hole
+	grad := func(d0, d1 [2]int) float64 {
+		f, bk := known(x+d0[0], y+d0[1]), known(x+d1[0], y+d1[1])
+		switch {
+		case f && bk:
+			return (t[(y+d0[1])*w+x+d0[0]] - t[(y+d1[1])*w+x+d1[0]]) / 2
+		case f:
+			return t[(y+d0[1])*w+x+d0[0]] - tp
+		case bk:
+			return tp - t[(y+d1[1])*w+x+d1[0]]
+		}
+		return 0
+	}
+	gx := grad([2]int{1, 0}, [2]int{-1, 0})
+	gy := grad([2]int{0, 1}, [2]int{0, -1})
+
+	var sum [4]float64
+	var total float64
+	r2 := radius * radius
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			d2 := dx*dx + dy*dy
+			if d2 == 0 || d2 > r2 || !known(x+dx, y+dy) {
+				continue
+			}
+			j := (y+dy)*w + x + dx
+			rx, ry := float64(-dx), float64(-dy)
+			dist := math.Sqrt(float64(d2))
+			dir := math.Abs(rx*gx+ry*gy) / dist
+			if dir < 1e-6 {
+				dir = 1e-6
+			}
+			dst := 1 / (float64(d2) * dist)
+			lev := 1 / (1 + math.Abs(t[j]-tp))
+			wt := dir * dst * lev
+			qx, qy := x+dx, y+dy
+			for c := 0; c < 4; c++ {
+				// First-order extrapolation from q toward p
+				ix := b.knownDerivative(qx, qy, 1, 0, c, known)
+				iy := b.knownDerivative(qx, qy, 0, 1, c, known)
+				sum[c] += wt * (b.pix[j*4+c] + ix*rx + iy*ry)
+			}
+			total += wt
+		}
+	}
+	if total > 0 {
+		for c := 0; c < 4; c++ {
+			b.pix[(y*w+x)*4+c] = sum[c] / total
+		}
+	}
+}
+
+// knownDerivative estimates the derivative of channel c at (x, y) along
+// (dx, dy) from known pixels only
+func (b *inpaintBuffer) knownDerivative(x, y, dx, dy, c int, known func(x, y int) bool) float64 {
+	f, bk := known(x+dx, y+dy), known(x-dx, y-dy)
+	v := func(x, y int) float64 { return b.pix[(y*b.w+x)*4+c] }
+	switch {
+	case f && bk:
+		return (v(x+dx, y+dy) - v(x-dx, y-dy)) / 2
+	case f:
+		return v(x+dx, y+dy) - v(x, y)
+	case bk:
+		return v(x, y) - v(x-dx, y-dy)
+	}
+	return 0
+}
+
+// navierStokes continues isophotes into the hole by evolving
+// I_t = grad(laplacian I) . perp(grad I) (Bertalmio et al.), with short
+// diffusion steps interleaved for stability
+func (b *inpaintBuffer) navierStokes(iterations int) {
+	w, h := b.w, b.h
+	// Only the hole's bounding box, with a margin for the stencils, matters
+	x0, y0, x1, y1 := w, h, -1, -1
+	for i, v := range b.hole {
+		if v {
+			x, y := i%w, i/w
+			x0, y0 = min(x0, x), min(y0, y)
+			x1, y1 = max(x1, x), max(y1, y)
+		}
+	}
+	x0, y0 = max(x0-2, 0), max(y0-2, 0)
+	x1, y1 = min(x1+2, w-1), min(y1+2, h-1)
+	bw, bh := x1-x0+1, y1-y0+1
+
+	at := func(plane []float64, x, y int) float64 {
+		return plane[clampInt(y, 0, bh-1)*bw+clampInt(x, 0, bw-1)]
+	}
+	const dt = 0.1
+	plane := make([]float64, bw*bh)
+	lap := make([]float64, bw*bh)
+	next := make([]float64, bw*bh)
+	for c := 0; c < 4; c++ {
+		for y := 0; y < bh; y++ {
+			for x := 0; x < bw; x++ {
+				plane[y*bw+x] = b.pix[((y+y0)*w+x+x0)*4+c] / 255
+			}
+		}
+		for iter := 0; iter < iterations; iter++ {
+			diffuse := iter%15 >= 13
+			for y := 0; y < bh; y++ {
+				for x := 0; x < bw; x++ {
+					lap[y*bw+x] = at(plane, x+1, y) + at(plane, x-1, y) + at(plane, x, y+1) + at(plane, x, y-1) - 4*at(plane, x, y)
+				}
+			}
+			copy(next, plane)
+			for y := 0; y < bh; y++ {
+				for x := 0; x < bw; x++ {
+					if !b.hole[(y+y0)*w+x+x0] {
+						continue
+					}
+					i := y*bw + x
+					if diffuse {
+						next[i] = plane[i] + 0.2*lap[i]
+						continue
+					}
+					dLx := (at(lap, x+1, y) - at(lap, x-1, y)) / 2
+					dLy := (at(lap, x, y+1) - at(lap, x, y-1)) / 2
+					ix := (at(plane, x+1, y) - at(plane, x-1, y)) / 2
+					iy := (at(plane, x, y+1) - at(plane, x, y-1)) / 2
+					norm := math.Sqrt(ix*ix + iy*iy + 1e-12)
+					beta := (dLx*-iy + dLy*ix) / norm
+
+					xb, xf := plane[i]-at(plane, x-1, y), at(plane, x+1, y)-plane[i]
+					yb, yf := plane[i]-at(plane, x, y-1), at(plane, x, y+1)-plane[i]
+					var g float64
+					if beta > 0 {
+						g = sq(math.Min(xb, 0)) + sq(math.Max(xf, 0)) + sq(math.Min(yb, 0)) + sq(math.Max(yf, 0))
+					} else {
+						g = sq(math.Max(xb, 0)) + sq(math.Min(xf, 0)) + sq(math.Max(yb, 0)) + sq(math.Min(yf, 0))
+					}
+					next[i] = plane[i] + dt*beta*math.Sqrt(g)
+				}
+			}
+			plane, next = next, plane
+		}
+		for y := 0; y < bh; y++ {
+			for x := 0; x < bw; x++ {
+				if b.hole[(y+y0)*w+x+x0] {
+					b.pix[((y+y0)*w+x+x0)*4+c] = clamp(plane[y*bw+x], 0, 1) * 255
+				}
+			}
+		}
+	}
+}
+
+func sq(v float64) float64 { return v * v }
+
+// patchMatch fills the hole coarse to fine: at each pyramid level an
+// approximate nearest-neighbor field from PatchMatch maps every patch
+// touching the hole to a patch outside it, and hole pixels take the average
+// of the patches that vote for them (Wexler et al. 2007)
+func (b *inpaintBuffer) patchMatch(r int, rng *rand.Rand, teleaRadius int) {
+	levels := []*inpaintBuffer{b}
+	for {
+		cur := levels[len(levels)-1]
+		if min(cur.w, cur.h)/2 < 4*(2*r+1) || len(levels) >= 6 {
+			break
+		}
+		next := cur.downsample()
+		if !next.hasHole() {
+			break
+		}
+		levels = append(levels, next)
+	}
+
+	coarsest := levels[len(levels)-1]
+	coarsest.telea(teleaRadius)
+
+	var nnf []int
+	for l := len(levels) - 1; l >= 0; l-- {
+		level := levels[l]
+		if l < len(levels)-1 {
+			level.upsampleHoleFrom(levels[l+1])
+			nnf = level.upsampleNNF(nnf, levels[l+1], r)
+		}
+		em := 2
+		if l == len(levels)-1 {
+			em = 4
+		}
+		var ok bool
+		for i := 0; i < em; i++ {
+			nnf, ok = level.patchMatchPass(nnf, r, rng)
+			if !ok {
+				// No patch lies fully outside the hole; keep the smooth fill
+				level.telea(teleaRadius)
+				break
+			}
+			level.vote(nnf, r)
+		}
+	}
+}
+
+// downsample halves the buffer, averaging known pixels. A coarse pixel is in
+// the hole only when all four of its fine pixels are.
+func (b *inpaintBuffer) downsample() *inpaintBuffer {
+	w, h := b.w/2, b.h/2
+	d := &inpaintBuffer{w: w, h: h, pix: make([]float64, w*h*4), hole: make([]bool, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum [4]float64
+			n := 0
+			for _, o := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				i := (2*y+o[1])*b.w + 2*x + o[0]
+				if b.hole[i] {
+					continue
+				}
+				for c := 0; c < 4; c++ {
+					sum[c] += b.pix[i*4+c]
+				}
+				n++
+			}
+			j := y*w + x
+			if n == 0 {
+				d.hole[j] = true
+				continue
+			}
+			for c := 0; c < 4; c++ {
+				d.pix[j*4+c] = sum[c] / float64(n)
+			}
+		}
+	}
+	return d
+}
+
+// upsampleHoleFrom seeds this level's hole from the coarser solution
+func (b *inpaintBuffer) upsampleHoleFrom(coarse *inpaintBuffer) {
+	for y := 0; y < b.h; y++ {
+		cy := min(y/2, coarse.h-1)
+		for x := 0; x < b.w; x++ {
+			i := y*b.w + x
+			if !b.hole[i] {
+				continue
+			}
+			j := cy*coarse.w + min(x/2, coarse.w-1)
+			copy(b.pix[i*4:i*4+4], coarse.pix[j*4:j*4+4])
+		}
+	}
+}
+
+// validSources marks patch centers whose whole patch is inside the image
+// and outside the hole, using a summed-area table of the hole
+func (b *inpaintBuffer) validSources(r int) ([]bool, []int) {
+	w, h := b.w, b.h
+	sat := make([]int, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		row := 0
+		for x := 0; x < w; x++ {
+			if b.hole[y*w+x] {
+				row++
+			}
+			sat[(y+1)*(w+1)+x+1] = sat[y*(w+1)+x+1] + row
+		}
+	}
+	valid := make([]bool, w*h)
+	var list []int
+	for y := r; y < h-r; y++ {
+		for x := r; x < w-r; x++ {
+			x0, y0, x1, y1 := x-r, y-r, x+r+1, y+r+1
+			if sat[y1*(w+1)+x1]-sat[y0*(w+1)+x1]-sat[y1*(w+1)+x0]+sat[y0*(w+1)+x0] == 0 {
+				valid[y*w+x] = true
+				list = append(list, y*w+x)
+			}
+		}
+	}
+	return valid, list
+}
+
+// targets lists the pixels whose patch overlaps the hole
+func (b *inpaintBuffer) targets(r int) []int {
+	w, h := b.w, b.h
+	near := make([]bool, w*h)
+	for i, v := range b.hole {
+		if !v {
+			continue
+		}
+		x, y := i%w, i/w
+		for ty := max(y-r, 0); ty <= min(y+r, h-1); ty++ {
+			for tx := max(x-r, 0); tx <= min(x+r, w-1); tx++ {
+				near[ty*w+tx] = true
+			}
+		}
+	}
+	var list []int
+	for i, v := range near {
+		if v {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+// patchDistance is the squared difference between the patch at target p and
+// the patch at source s, stopping early once it exceeds limit
+func (b *inpaintBuffer) patchDistance(p, s, r int, limit float64) float64 {
+	w, h := b.w, b.h
+	px, py := p%w, p/w
+	sx, sy := s%w, s/w
+	var d float64
+	for dy := -r; dy <= r; dy++ {
+		ty := py + dy
+		if ty < 0 || ty >= h {
+			continue
+		}
+		for dx := -r; dx <= r; dx++ {
+			tx := px + dx
+			if tx < 0 || tx >= w {
+				continue
+			}
+			ti := (ty*w + tx) * 4
+			si := ((sy+dy)*w + sx + dx) * 4
+			for c := 0; c < 4; c++ {
+				diff := b.pix[ti+c] - b.pix[si+c]
+				d += diff * diff
+			}
+		}
+		if d > limit {
+			return d
+		}
+	}
+	return d
+}
+
+// patchMatchPass refines the nearest-neighbor field, indexed by pixel and
+// holding source patch centers, with propagation and random search. It
+// reports false if there are no source patches.
+func (b *inpaintBuffer) patchMatchPass(nnf []int, r int, rng *rand.Rand) ([]int, bool) {
+	w := b.w
+	valid, sources := b.validSources(r)
+	if len(sources) == 0 {
+		return nnf, false
+	}
+	targets := b.targets(r)
+	if nnf == nil {
+		nnf = make([]int, w*b.h)
+		for i := range nnf {
+			nnf[i] = -1
+		}
+	}
+	dist := make([]float64, w*b.h)
+	inTarget := make([]bool, w*b.h)
+	for _, p := range targets {
+		inTarget[p] = true
+		if nnf[p] < 0 || nnf[p] >= len(valid) || !valid[nnf[p]] {
+			nnf[p] = sources[rng.Intn(len(sources))]
+		}
+		dist[p] = b.patchDistance(p, nnf[p], r, math.Inf(1))
+	}
+
+	try := func(p, s int) {
+		if s < 0 || s >= len(valid) || !valid[s] || s == nnf[p] {
+			return
+		}
+		if d := b.patchDistance(p, s, r, dist[p]); d < dist[p] {
+			nnf[p], dist[p] = s, d
+		}
+	}
+	maxRadius := max(w, b.h)
+	for iter := 0; iter < 4; iter++ {
+		step := 1
+		order := targets
+		if iter%2 == 1 {
+			step = -1
+			order = make([]int, len(targets))
+			for i, p := range targets {
+				order[len(targets)-1-i] = p
+			}
+		}
+		for _, p := range order {
+			x, y := p%w, p/w
+			// Propagation: shift the matches of already visited neighbors
+			if nx := x - step; nx >= 0 && nx < w && inTarget[p-step] {
+				if s := nnf[p-step]; s%w+step >= 0 && s%w+step < w {
+					try(p, s+step)
+				}
+			}
+			if ny := y - step; ny >= 0 && ny < b.h && inTarget[p-step*w] {
+				try(p, nnf[p-step*w]+step*w)
+			}
+			// Random search in shrinking windows around the current best
+			for rad := maxRadius; rad >= 1; rad /= 2 {
+				bx, by := nnf[p]%w, nnf[p]/w
+				cx := clampInt(bx+rng.Intn(2*rad+1)-rad, 0, w-1)
+				cy := clampInt(by+rng.Intn(2*rad+1)-rad, 0, b.h-1)
+				try(p, cy*w+cx)
+			}
+		}
+	}
+	return nnf, true
+}
+
+// vote sets every hole pixel to the average of the source pixels that the
+// patches covering it map it to
+func (b *inpaintBuffer) vote(nnf []int, r int) {
+	w, h := b.w, b.h
+	sum := make([]float64, w*h*4)
+	count := make([]float64, w*h)
+	for _, p := range b.targets(r) {
+		s := nnf[p]
+		px, py := p%w, p/w
+		sx, sy := s%w, s/w
+		for dy := -r; dy <= r; dy++ {
+			ty := py + dy
+			if ty < 0 || ty >= h {
+				continue
+			}
+			for dx := -r; dx <= r; dx++ {
+				tx := px + dx
+				if tx < 0 || tx >= w || !b.hole[ty*w+tx] {
+					continue
+				}
+				ti := ty*w + tx
+				si := ((sy+dy)*w + sx + dx) * 4
+				for c := 0; c < 4; c++ {
+					sum[ti*4+c] += b.pix[si+c]
+				}
+				count[ti]++
+			}
+		}
+	}
+	for i, n := range count {
+		if n > 0 {
+			for c := 0; c < 4; c++ {
+				b.pix[i*4+c] = sum[i*4+c] / n
+			}
+		}
+	}
+}
+
+// upsampleNNF scales a coarse nearest-neighbor field to this level
+func (b *inpaintBuffer) upsampleNNF(coarseNNF []int, coarse *inpaintBuffer, r int) []int {
+	nnf := make([]int, b.w*b.h)
+	for i := range nnf {
+		nnf[i] = -1
+	}
+	if coarseNNF == nil {
+		return nnf
+	}
+	for y := 0; y < b.h; y++ {
+		cy := min(y/2, coarse.h-1)
+		for x := 0; x < b.w; x++ {
+			s := coarseNNF[cy*coarse.w+min(x/2, coarse.w-1)]
+			if s < 0 {
+				continue
+			}
+			sx := clampInt(2*(s%coarse.w)+x%2, r, b.w-1-r)
+			sy := clampInt(2*(s/coarse.w)+y%2, r, b.h-1-r)
+			nnf[y*b.w+x] = sy*b.w + sx
+		}
+	}
+	return nnf
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func holeMask(w, h int, hole image.Rectangle) *image.Alpha {
+	dc := NewContext(w, h)
+	dc.DrawRectangle(float64(hole.Min.X), float64(hole.Min.Y), float64(hole.Dx()), float64(hole.Dy()))
+	dc.SetRGB(1, 1, 1)
+	dc.Fill()
+	return dc.AsMask()
+}
+
+// holeError returns the largest channel difference inside the hole and
+// whether anything outside it changed
+func holeError(want, got *image.RGBA, hole image.Rectangle) (int, bool) {
+	worst, outside := 0, false
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			a, c := want.RGBAAt(x, y), got.RGBAAt(x, y)
+			d := max(int(absDiff(a.R, c.R)), int(absDiff(a.G, c.G)), int(absDiff(a.B, c.B)), int(absDiff(a.A, c.A)))
+			if (image.Point{x, y}).In(hole) {
+				worst = max(worst, d)
+			} else if d != 0 {
+				outside = true
+			}
+		}
+	}
+	return worst, outside
+}
+
+func TestInpaintSmoothFill(t *testing.T) {
+	img := gradientImage(40, 30)
+	hole := image.Rect(15, 10, 21, 16)
+	mask := holeMask(40, 30, hole)
+
+	for _, method := range []InpaintMethod{InpaintTelea, InpaintNavierStokes} {
+		out := Inpaint(img, mask, method)
+		worst, outside := holeError(img, out, hole)
+		if outside {
+			t.Errorf("method %d changed pixels outside the mask", method)
+		}
+		if worst > 4 {
+			t.Errorf("method %d: hole differs from the gradient by up to %d", method, worst)
+		}
+	}
+}
+
+func TestInpaintPatchMatchTexture(t *testing.T) {
+	// Vertical stripes four pixels wide, which averaging methods would blur
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.RGBA{40, 60, 200, 255}
+			if (x/4)%2 == 0 {
+				c = color.RGBA{230, 200, 40, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	hole := image.Rect(26, 18, 38, 30)
+	mask := holeMask(64, 48, hole)
+
+	out := InpaintWithOptions(img, mask, InpaintOptions{Method: InpaintPatchMatch, Seed: 1})
+	if _, outside := holeError(img, out, hole); outside {
+		t.Error("PatchMatch changed pixels outside the mask")
+	}
+	if ssim, _ := SSIM(img.SubImage(hole), out.SubImage(hole)); ssim < 0.8 {
+		t.Errorf("PatchMatch fill SSIM = %.3f, want stripes restored", ssim)
+	}
+
+	telea := Inpaint(img, mask, InpaintTelea)
+	teleaSSIM, _ := SSIM(img.SubImage(hole), telea.SubImage(hole))
+	pmSSIM, _ := SSIM(img.SubImage(hole), out.SubImage(hole))
+	if pmSSIM <= teleaSSIM {
+		t.Errorf("PatchMatch (%.3f) should beat Telea (%.3f) on texture", pmSSIM, teleaSSIM)
+	}
+}
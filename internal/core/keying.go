@@ -0,0 +1,385 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Background removal: chroma keying, trimap matting and plain backgrounds.
+// Results use straight alpha (*image.NRGBA) so edge colors survive further
+// compositing unchanged.
+
+// ChromaKeyOptions configures ChromaKeyWithOptions
+type ChromaKeyOptions struct {
+	Key color.Color
+	// Tolerance is the chroma distance from the key, as a fraction of the
+	// key's own saturation, below which pixels become fully transparent
+	Tolerance float64
+	// Softness widens the partially transparent ramp beyond Tolerance
+	Softness float64
+	// Spill in 0..1 removes that much of the key color reflected onto the
+	// subject
+	Spill float64
+}
+
+// ChromaKey makes pixels close in hue and saturation to key transparent and
+// fully suppresses key-colored spill on the rest
+func ChromaKey(img image.Image, key color.Color, tolerance, softness float64) *image.NRGBA {
+	return ChromaKeyWithOptions(img, ChromaKeyOptions{Key: key, Tolerance: tolerance, Softness: softness, Spill: 1})
+}
+
+// ChromaKeyWithOptions keys out a colored background
+func ChromaKeyWithOptions(img image.Image, opts ChromaKeyOptions) *image.NRGBA {
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	result := image.NewNRGBA(bounds)
+
+	kc := color.NRGBAModel.Convert(opts.Key).(color.NRGBA)
+	kr, kg, kb := float64(kc.R)/255, float64(kc.G)/255, float64(kc.B)/255
+	kcb, kcr := chroma(kr, kg, kb)
+	scale := math.Max(math.Hypot(kcb, kcr), 0.1)
+	spill := clamp(opts.Spill, 0, 1)
+	// The channel that dominates the key is the one spill inflates
+	dominant := 1
+	if kr >= kg && kr >= kb {
+		dominant = 0
+	} else if kb > kg {
+		dominant = 2
+	}
+
+	w, h := bounds.Dx(), bounds.Dy()
+	parallelRows(h, 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := result.Pix[y*result.Stride:]
+			for x := 0; x < w; x++ {
+				r8, g8, b8, a8 := straightAt(src, bounds.Min.X+x, bounds.Min.Y+y)
+				rgb := [3]float64{float64(r8) / 255, float64(g8) / 255, float64(b8) / 255}
+				cb, cr := chroma(rgb[0], rgb[1], rgb[2])
+				d := math.Hypot(cb-kcb, cr-kcr) / scale
+
+				alpha := 1.0
+				if opts.Softness > 0 {
+					alpha = smoothstep(opts.Tolerance, opts.Tolerance+opts.Softness, d)
+				} else if d <= opts.Tolerance {
+					alpha = 0
+				}
+
+				if spill > 0 {
+					limit := (rgb[0] + rgb[1] + rgb[2] - rgb[dominant]) / 2
+					if rgb[dominant] > limit {
+						rgb[dominant] = limit + (rgb[dominant]-limit)*(1-spill)
+					}
+				}
+
+				p := row[x*4 : x*4+4]
+				for c := 0; c < 3; c++ {
+					p[c] = uint8(math.Round(clamp(rgb[c], 0, 1) * 255))
+				}
+				p[3] = uint8(math.Round(alpha * float64(a8)))
+			}
+		}
+	})
+	return result
+}
+
+// chroma returns the BT.601 Cb and Cr components of a color in 0..1
+func chroma(r, g, b float64) (float64, float64) {
+	y := 0.299*r + 0.587*g + 0.114*b
+	return (b - y) * 0.564, (r - y) * 0.713
+}
+
+// Trimap values understood by MatteTrimap; anything in between is unknown
+const (
+	TrimapBackground = 0
+	TrimapForeground = 255
+)
+
+// MatteTrimap estimates alpha in the unknown region of trimap, which has
+// the same bounds as img. For each unknown pixel it samples known
+// foreground and background colors along rays, keeps the pair that best
+// explains the pixel as a mix (shared matting, Gastal and Oliveira 2010),
+// then smooths the result with a guided filter. Unknown pixels take the
+// estimated foreground color.
+func MatteTrimap(img image.Image, trimap *image.Gray) *image.NRGBA {
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	colors := make([][3]float64, w*h)
+	inAlpha := make([]uint8, w*h)
+	state := make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			r, g, b, a := straightAt(src, bounds.Min.X+x, bounds.Min.Y+y)
+			colors[i] = [3]float64{float64(r) / 255, float64(g) / 255, float64(b) / 255}
+			inAlpha[i] = a
+			state[i] = 128
+			if p := (image.Point{bounds.Min.X + x, bounds.Min.Y + y}); p.In(trimap.Rect) {
+				state[i] = trimap.GrayAt(p.X, p.Y).Y
+			}
+		}
+	}
+
+	m := &matting{w: w, h: h, colors: colors, state: state}
+	alpha, fg := m.solve(src)
+
+	result := image.NewNRGBA(bounds)
+	for i := range colors {
+		p := result.Pix[(i/w)*result.Stride+(i%w)*4:]
+		c := colors[i]
+		a := 1.0
+		switch state[i] {
+		case TrimapBackground:
+			a = 0
+		case TrimapForeground:
+		default:
+			a, c = alpha[i], fg[i]
+		}
+		if a == 0 {
+			p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+			continue
+		}
+		for ch := 0; ch < 3; ch++ {
+			p[ch] = uint8(math.Round(clamp(c[ch], 0, 1) * 255))
+		}
+		p[3] = uint8(math.Round(a * float64(inAlpha[i])))
+	}
+	return result
+}
+
+// matting holds straight colors in 0..1 and trimap states for MatteTrimap
+type matting struct {
+	w, h   int
+	colors [][3]float64
+	state  []uint8
+}
+
+// mattePair is a candidate foreground/background explanation of a pixel
+type mattePair struct {
+	f, b [3]float64
+	cost float64
+}
+
+// matteRays is the number of sampling rays per unknown pixel
+const matteRays = 8
+
+func (m *matting) solve(guide *image.RGBA) ([]float64, [][3]float64) {
+	w, h := m.w, m.h
+	n := w * h
+	pairs := make([]mattePair, n)
+	unknown := make([]int, 0)
+	var meanF, meanB [3]float64
+	var nf, nb float64
+	for i, s := range m.state {
+		switch s {
+		case TrimapForeground:
+			meanF = addColor(meanF, m.colors[i])
+			nf++
+		case TrimapBackground:
+			meanB = addColor(meanB, m.colors[i])
+			nb++
+		default:
+			unknown = append(unknown, i)
+		}
+	}
+	meanF, meanB = scaleColor(meanF, 1/math.Max(nf, 1)), scaleColor(meanB, 1/math.Max(nb, 1))
+
+	// Gathering: the best pair from samples along rays, whose angles are
+	// rotated per pixel so neighbors see different samples
+	maxDist := max(w, h)
+	parallelRows(len(unknown), 0, func(start, end int) {
+		for _, i := range unknown[start:end] {
+			x, y := i%w, i/w
+			var fs, bs [][3]float64
+			offset := float64((x*3+y*5)%9) / 9 * 2 * math.Pi / matteRays
+			for r := 0; r < matteRays; r++ {
+				angle := offset + float64(r)*2*math.Pi/matteRays
+				dx, dy := math.Cos(angle), math.Sin(angle)
+				foundF, foundB := false, false
+				for step := 1; step < maxDist && !(foundF && foundB); step++ {
+					sx := x + int(math.Round(dx*float64(step)))
+					sy := y + int(math.Round(dy*float64(step)))
+					if sx < 0 || sx >= w || sy < 0 || sy >= h {
+						break
+					}
+					j := sy*w + sx
+					if !foundF && m.state[j] == TrimapForeground {
+						fs, foundF = append(fs, m.colors[j]), true
+					} else if !foundB && m.state[j] == TrimapBackground {
+						bs, foundB = append(bs, m.colors[j]), true
+					}
+				}
+			}
+			if len(fs) == 0 {
+				fs = append(fs, meanF)
+			}
+			if len(bs) == 0 {
+				bs = append(bs, meanB)
+			}
+			best := mattePair{cost: math.Inf(1)}
+			for _, f := range fs {
+				for _, b := range bs {
+					if c := mixCost(m.colors[i], f, b); c < best.cost {
+						best = mattePair{f: f, b: b, cost: c}
+					}
+				}
+			}
+			pairs[i] = best
+		}
+	})
+
+	// Refinement: re-rank the neighbors' pairs on each pixel's own color
+	// and average the three best
+	alpha := make([]float64, n)
+	fg := make([][3]float64, n)
+	parallelRows(len(unknown), 0, func(start, end int) {
+		var candidates []mattePair
+		for _, i := range unknown[start:end] {
+			x, y := i%w, i/w
+			candidates = candidates[:0]
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || nx >= w || ny < 0 || ny >= h {
+						continue
+					}
+					j := ny*w + nx
+					if s := m.state[j]; s == TrimapForeground || s == TrimapBackground {
+						continue
+					}
+					p := pairs[j]
+					p.cost = mixCost(m.colors[i], p.f, p.b)
+					candidates = append(candidates, p)
+				}
+			}
+			sort.Slice(candidates, func(a, b int) bool { return candidates[a].cost < candidates[b].cost })
+			k := min(3, len(candidates))
+			var f, b [3]float64
+			for _, c := range candidates[:k] {
+				f, b = addColor(f, c.f), addColor(b, c.b)
+			}
+			f, b = scaleColor(f, 1/float64(k)), scaleColor(b, 1/float64(k))
+			alpha[i] = mixAlpha(m.colors[i], f, b)
+			fg[i] = f
+		}
+	})
+
+	// Smooth the matte along image edges, leaving the known regions exact
+	mask := image.NewAlpha(image.Rect(0, 0, w, h))
+	for i, s := range m.state {
+		switch s {
+		case TrimapForeground:
+			mask.Pix[i] = 255
+		case TrimapBackground:
+		default:
+			mask.Pix[i] = uint8(math.Round(alpha[i] * 255))
+		}
+	}
+	if refined, err := RefineMask(mask, guide, 2, 1e-4); err == nil {
+		for _, i := range unknown {
+			alpha[i] = float64(refined.Pix[i]) / 255
+		}
+	}
+	return alpha, fg
+}
+
+// mixAlpha projects c onto the segment from b to f
+func mixAlpha(c, f, b [3]float64) float64 {
+	var num, den float64
+	for i := 0; i < 3; i++ {
+		d := f[i] - b[i]
+		num += (c[i] - b[i]) * d
+		den += d * d
+	}
+	if den < 1e-8 {
+		return 0.5
+	}
+	return clamp(num/den, 0, 1)
+}
+
+// mixCost is the color distortion of explaining c as a mix of f and b
+func mixCost(c, f, b [3]float64) float64 {
+	a := mixAlpha(c, f, b)
+	var d float64
+	for i := 0; i < 3; i++ {
+		e := c[i] - (a*f[i] + (1-a)*b[i])
+		d += e * e
+	}
+	return math.Sqrt(d)
+}
+
+func addColor(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+func scaleColor(a [3]float64, s float64) [3]float64 {
+	return [3]float64{a[0] * s, a[1] * s, a[2] * s}
+}
+
+// RemoveUniformBackground removes a plain background. The background color
+// is the median of the border pixels; the background is everything
+// connected to the border within tolerance (0..1, Euclidean RGB distance
+// relative to black-to-white) of it. Edges are then matted with MatteTrimap
+// across a band of bandWidth pixels (2 when not positive).
+func RemoveUniformBackground(img image.Image, tolerance float64, bandWidth int) *image.NRGBA {
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return image.NewNRGBA(bounds)
+	}
+	if bandWidth <= 0 {
+		bandWidth = 2
+	}
+
+	at := func(x, y int) [3]float64 {
+		r, g, b, _ := straightAt(src, bounds.Min.X+x, bounds.Min.Y+y)
+		return [3]float64{float64(r) / 255, float64(g) / 255, float64(b) / 255}
+	}
+	var border [3][]float64
+	var seeds []int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x == 0 || y == 0 || x == w-1 || y == h-1 {
+				c := at(x, y)
+				for ch := range border {
+					border[ch] = append(border[ch], c[ch])
+				}
+				seeds = append(seeds, y*w+x)
+			}
+		}
+	}
+	var bg [3]float64
+	for ch := range border {
+		sort.Float64s(border[ch])
+		bg[ch] = border[ch][len(border[ch])/2]
+	}
+
+	limit := tolerance * math.Sqrt(3)
+	inside := func(x, y int) bool {
+		c := at(x, y)
+		return math.Sqrt(sq(c[0]-bg[0])+sq(c[1]-bg[1])+sq(c[2]-bg[2])) <= limit
+	}
+	background := scanlineFill(w, h, seeds, Connectivity4, inside)
+
+	fgMask := image.NewAlpha(image.Rect(0, 0, w, h))
+	for i, isBG := range background {
+		if !isBG {
+			fgMask.Pix[i] = 255
+		}
+	}
+	sdf := GenerateSDF(fgMask, 0)
+	trimap := image.NewGray(bounds)
+	for i, d := range sdf.Data {
+		v := uint8(128)
+		if d <= -float64(bandWidth) {
+			v = TrimapForeground
+		} else if d >= float64(bandWidth) {
+			v = TrimapBackground
+		}
+		trimap.Pix[(i/w)*trimap.Stride+i%w] = v
+	}
+	return MatteTrimap(src, trimap)
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestChromaKey(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			c := color.RGBA{30, 200, 40, 255}
+			if x >= 5 && x < 15 && y >= 5 && y < 15 {
+				// A light subject with a green cast from the backdrop
+				c = color.RGBA{200, 230, 190, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	out := ChromaKey(img, color.RGBA{0, 255, 0, 255}, 0.4, 0.2)
+	if a := out.NRGBAAt(1, 1).A; a != 0 {
+		t.Errorf("background alpha = %d, want 0", a)
+	}
+	subject := out.NRGBAAt(10, 10)
+	if subject.A != 255 {
+		t.Errorf("subject alpha = %d, want 255", subject.A)
+	}
+	if subject.G > 196 {
+		t.Errorf("green spill not suppressed: %v", subject)
+	}
+
+	lm := NewLayerManager(20, 20)
+	layer := lm.AddImageLayer("cutout", out, 0, 0)
+	if got := layer.Image.RGBAAt(10, 10); got.A != 255 || got.R != subject.R {
+		t.Errorf("layer pixel = %v, want the opaque subject %v", got, subject)
+	}
+}
+
+func TestMatteTrimapRecoversAlpha(t *testing.T) {
+	// Red over blue with a horizontal alpha ramp from x=10 to x=20
+	const w, h = 30, 10
+	fg, bg := [3]float64{220, 40, 30}, [3]float64{20, 50, 200}
+	truth := func(x int) float64 { return clamp(float64(20-x)/10, 0, 1) }
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	trimap := image.NewGray(img.Rect)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := truth(x)
+			img.SetRGBA(x, y, color.RGBA{
+				uint8(a*fg[0] + (1-a)*bg[0]), uint8(a*fg[1] + (1-a)*bg[1]), uint8(a*fg[2] + (1-a)*bg[2]), 255,
+			})
+			v := uint8(128)
+			if x < 8 {
+				v = TrimapForeground
+			} else if x > 22 {
+				v = TrimapBackground
+			}
+			trimap.SetGray(x, y, color.Gray{v})
+		}
+	}
+
+	out := MatteTrimap(img, trimap)
+	for x := 0; x < w; x++ {
+		got := float64(out.NRGBAAt(x, h/2).A) / 255
+		if math.Abs(got-truth(x)) > 0.12 {
+			t.Errorf("alpha at x=%d = %.2f, want %.2f", x, got, truth(x))
+		}
+	}
+	if c := out.NRGBAAt(15, h/2); c.R < 180 || c.B > 80 {
+		t.Errorf("edge pixel color %v should be the unmixed foreground", c)
+	}
+}
+
+func TestRemoveUniformBackground(t *testing.T) {
+	dc := NewContext(40, 40)
+	dc.SetRGB(0.97, 0.97, 0.95)
+	dc.Clear()
+	dc.SetRGB(0.1, 0.2, 0.6)
+	dc.DrawCircle(20, 20, 10)
+	dc.Fill()
+
+	out := RemoveUniformBackground(dc.Image(), 0.1, 0)
+	if a := out.NRGBAAt(2, 2).A; a != 0 {
+		t.Errorf("corner alpha = %d, want 0", a)
+	}
+	if c := out.NRGBAAt(20, 20); c.A != 255 || c.B < 140 {
+		t.Errorf("center = %v, want the opaque circle color", c)
+	}
+	if a := out.NRGBAAt(20, 31).A; a != 0 {
+		t.Errorf("pixel just outside the circle has alpha %d", a)
+	}
+}
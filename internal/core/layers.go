@@ -69,6 +69,9 @@ type LayerManager struct {
 	Width       int
 	Height      int
 	Background  color.Color
+	// LinearLight composites in premultiplied linear light instead of on
+	// gamma-encoded values
+	LinearLight bool
 }
 
 // NewLayerManager creates a new layer manager
@@ -108,6 +111,15 @@ func (lm *LayerManager) AddLayer(name string) *Layer {
 	return layer
 }
 
+// AddImageLayer adds a new layer holding img drawn at (x, y), such as a
+// cutout from ChromaKey or RemoveUniformBackground
+func (lm *LayerManager) AddImageLayer(name string, img image.Image, x, y int) *Layer {
+	layer := lm.AddLayer(name)
+	b := img.Bounds()
+	draw.Draw(layer.Image, image.Rect(x, y, x+b.Dx(), y+b.Dy()), img, b.Min, draw.Src)
+	return layer
+}
+
 // InsertLayer inserts a layer at the specified index
 func (lm *LayerManager) InsertLayer(index int, name string) *Layer {
 	if index < 0 || index > len(lm.Layers) {
@@ -240,6 +252,10 @@ func (lm *LayerManager) Composite() *image.RGBA {
 
 // compositeLayer composites a single layer onto the result
 func (lm *LayerManager) compositeLayer(dst *image.RGBA, layer *Layer) {
+	if lm.LinearLight {
+		lm.compositeLayerLinear(dst, layer)
+		return
+	}
 	bounds := dst.Bounds()
 	mode := layer.BlendMode
 
@@ -712,6 +728,12 @@ func (l *Layer) AddMask() {
 	draw.Draw(l.Mask, l.Mask.Bounds(), &image.Uniform{color.Alpha{255}}, image.Point{}, draw.Src)
 }
 
+// AddMaskFromSelection adds a layer mask that shows only the selected pixels
+func (l *Layer) AddMaskFromSelection(sel *Selection) {
+	l.Mask = image.NewAlpha(l.Image.Bounds())
+	draw.Draw(l.Mask, l.Mask.Bounds(), sel.Mask, sel.Mask.Bounds().Min, draw.Src)
+}
+
 // RemoveMask removes the layer mask
 func (l *Layer) RemoveMask() {
 	l.Mask = nil
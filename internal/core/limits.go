@@ -0,0 +1,371 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+// Resource limits for decoding untrusted files
+//
+// Image headers are inspected before any pixels are allocated: the size
+// from image.DecodeConfig, and for GIF and APNG files the frame count.
+// Fonts are checked against their file size and the size of each table.
+
+// DecodeLimits bounds what loaders allocate for a single file. Zero fields
+// are not limited.
+type DecodeLimits struct {
+	// MaxPixels caps width × height, summed over the frames that are
+	// decoded from an animation
+	MaxPixels int64
+	// MaxWidth and MaxHeight cap each side of an image
+	MaxWidth, MaxHeight int
+	// MaxFrames caps the frames of an animated GIF or APNG file
+	MaxFrames int
+	// MaxFontSize caps a font file and the SFNT data unpacked from a WOFF
+	MaxFontSize int64
+	// MaxFontTableSize caps each table of a font once decompressed
+	MaxFontTableSize int64
+}
+
+// DefaultDecodeLimits returns the limits loaders use unless
+// SetDecodeLimits is called
+func DefaultDecodeLimits() DecodeLimits {
+	return DecodeLimits{
+		MaxPixels:        1 << 27, // 512 MiB as 8-bit RGBA
+		MaxWidth:         1 << 16,
+		MaxHeight:        1 << 16,
+		MaxFrames:        1000,
+		MaxFontSize:      128 << 20,
+		MaxFontTableSize: 64 << 20,
+	}
+}
+
+var (
+	decodeLimitsMu sync.RWMutex
+	decodeLimits   = DefaultDecodeLimits()
+)
+
+// SetDecodeLimits replaces the limits applied by every loader and decoder
+// and returns the previous ones
+func SetDecodeLimits(limits DecodeLimits) DecodeLimits {
+	decodeLimitsMu.Lock()
+	defer decodeLimitsMu.Unlock()
+	previous := decodeLimits
+	decodeLimits = limits
+	return previous
+}
+
+// GetDecodeLimits returns the limits currently applied by loaders
+func GetDecodeLimits() DecodeLimits {
+	decodeLimitsMu.RLock()
+	defer decodeLimitsMu.RUnlock()
+	return decodeLimits
+}
+
+// limitError reports a header that asks for more than a limit allows
+func limitError(operation string, requested int64, limit string, value interface{}) *AdvanceError {
+	return NewMemoryError(operation, requested).
+		WithContext("limit", limit).
+		WithContext("limit_value", value).
+		WithSuggestion("Raise the limit with SetDecodeLimits if the file is trusted")
+}
+
+// checkDecodeSize checks the size of an image or frame against the limits.
+// pixels is the number already committed to earlier frames. Sides past
+// math.MaxInt32 are rejected even without limits, so buffer sizes computed
+// from them cannot overflow.
+func checkDecodeSize(operation string, width, height int, pixels int64) error {
+	limits := GetDecodeLimits()
+	inRange := width >= 0 && height >= 0 && width <= math.MaxInt32 && height <= math.MaxInt32
+	// The byte count in errors saturates rather than wrapping
+	requested := int64(math.MaxInt64)
+	if n := int64(width) * int64(height); inRange && pixels <= math.MaxInt64/4-n {
+		requested = (n + pixels) * 4
+	}
+	switch {
+	case !inRange || requested == math.MaxInt64:
+		return limitError(operation, requested, "MaxInt32", math.MaxInt32).
+			WithContext("width", width).WithContext("height", height)
+	case limits.MaxWidth > 0 && width > limits.MaxWidth:
+		return limitError(operation, requested, "MaxWidth", limits.MaxWidth).WithContext("width", width)
+	case limits.MaxHeight > 0 && height > limits.MaxHeight:
+		return limitError(operation, requested, "MaxHeight", limits.MaxHeight).WithContext("height", height)
+	case limits.MaxPixels > 0 && (pixels > limits.MaxPixels || height > 0 && int64(width) > (limits.MaxPixels-pixels)/int64(height)):
+		return limitError(operation, requested, "MaxPixels", limits.MaxPixels).
+			WithContext("width", width).WithContext("height", height)
+	}
+	return nil
+}
+
+// checkFrameCount checks the frame count of an animation
+func checkFrameCount(operation string, frames int) error {
+	if limits := GetDecodeLimits(); limits.MaxFrames > 0 && frames > limits.MaxFrames {
+		return limitError(operation, 0, "MaxFrames", limits.MaxFrames).WithContext("frames", frames)
+	}
+	return nil
+}
+
+// loadFormats lists the formats LoadImage recognizes
+var loadFormats = []string{
+	"png", "jpg", "gif", "bmp", "tiff", "webp", "qoi", "pbm", "pgm", "ppm", "pam", "tga", "ico", "cur",
+}
+
+// loadImageFile opens path, checks its header against the decode limits
+// and decodes it. decodeConfig reports the format name, which selects the
+// frame count inspection for GIF and PNG.
+func loadImageFile(path, operation string, formats []string,
+	decodeConfig func(io.Reader) (image.Config, string, error),
+	decode func(io.Reader) (image.Image, error)) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	formatError := func(err error) error {
+		if ae, ok := err.(*AdvanceError); ok {
+			return ae.WithContext("filepath", path)
+		}
+		return NewInvalidFormatError(path, formats).WithContext("details", err.Error())
+	}
+
+	br := bufio.NewReader(file)
+	config, format, err := decodeConfig(br)
+	if err != nil {
+		return nil, formatError(err)
+	}
+	if err := checkDecodeSize(operation, config.Width, config.Height, 0); err != nil {
+		return nil, formatError(err)
+	}
+	if format == "gif" || format == "png" {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		br.Reset(file)
+		// A damaged stream is left for the decoder to report; only the
+		// frames counted so far are checked
+		frames, _ := countFrames(br, format)
+		if err := checkFrameCount(operation, frames); err != nil {
+			return nil, formatError(err)
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br.Reset(file)
+	img, err := decode(br)
+	if err != nil {
+		return nil, formatError(err)
+	}
+	return img, nil
+}
+
+// withFormat adapts a single-format DecodeConfig to loadImageFile
+func withFormat(format string, decodeConfig func(io.Reader) (image.Config, error)) func(io.Reader) (image.Config, string, error) {
+	return func(r io.Reader) (image.Config, string, error) {
+		config, err := decodeConfig(r)
+		return config, format, err
+	}
+}
+
+// countFrames counts the frames of a GIF, or of a PNG from its acTL chunk,
+// without decoding any pixels. On a read error it returns the frames found
+// so far along with the error.
+func countFrames(br *bufio.Reader, format string) (int, error) {
+	if format == "png" {
+		return countAPNGFrames(br)
+	}
+	return countGIFFrames(br, GetDecodeLimits().MaxFrames)
+}
+
+// countAPNGFrames returns the frame count declared by the acTL chunk, or 1
+// for a still PNG. The chunk must precede the image data.
+func countAPNGFrames(br *bufio.Reader) (int, error) {
+	if _, err := br.Discard(8); err != nil {
+		return 0, err
+	}
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return 0, err
+		}
+		length := int(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:]) {
+		case "acTL":
+			var frames [4]byte
+			if _, err := io.ReadFull(br, frames[:]); err != nil {
+				return 0, err
+			}
+			return int(binary.BigEndian.Uint32(frames[:])), nil
+		case "IDAT", "IEND":
+			return 1, nil
+		}
+		if length < 0 || length > 1<<31-1 {
+			return 0, fmt.Errorf("png: invalid chunk length")
+		}
+		if _, err := br.Discard(length + 4); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// countGIFFrames walks the blocks of a GIF, skipping image data, and
+// returns the number of image descriptors, stopping once it exceeds limit
+func countGIFFrames(br *bufio.Reader, limit int) (int, error) {
+	var header [13]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return 0, err
+	}
+	skipTable := func(flags byte) error {
+		if flags&0x80 == 0 {
+			return nil
+		}
+		_, err := br.Discard(3 << (flags&7 + 1))
+		return err
+	}
+	skipSubBlocks := func() error {
+		for {
+			n, err := br.ReadByte()
+			if err != nil || n == 0 {
+				return err
+			}
+			if _, err := br.Discard(int(n)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := skipTable(header[10]); err != nil {
+		return 0, err
+	}
+
+	frames := 0
+	for {
+		block, err := br.ReadByte()
+		if err != nil {
+			return frames, err
+		}
+		switch block {
+		case 0x21: // extension
+			if _, err := br.ReadByte(); err != nil {
+				return frames, err
+			}
+			if err := skipSubBlocks(); err != nil {
+				return frames, err
+			}
+		case 0x2c: // image descriptor
+			frames++
+			if limit > 0 && frames > limit {
+				return frames, nil
+			}
+			var desc [9]byte
+			if _, err := io.ReadFull(br, desc[:]); err != nil {
+				return frames, err
+			}
+			if err := skipTable(desc[8]); err != nil {
+				return frames, err
+			}
+			if _, err := br.ReadByte(); err != nil { // LZW code size
+				return frames, err
+			}
+			if err := skipSubBlocks(); err != nil {
+				return frames, err
+			}
+		default: // the trailer, or data the decoder will reject
+			return frames, nil
+		}
+	}
+}
+
+// LoadAnimatedGIF loads every frame of a GIF after checking the frame
+// count and the pixels of all frames against the decode limits
+func LoadAnimatedGIF(path string) (*gif.GIF, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fail := func(err error) (*gif.GIF, error) {
+		if ae, ok := err.(*AdvanceError); ok {
+			return nil, ae.WithContext("filepath", path)
+		}
+		return nil, NewInvalidFormatError(path, []string{"gif"}).WithContext("details", err.Error())
+	}
+
+	br := bufio.NewReader(file)
+	config, err := gif.DecodeConfig(br)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br.Reset(file)
+	frames, err := countGIFFrames(br, GetDecodeLimits().MaxFrames)
+	if err != nil {
+		return fail(err)
+	}
+	if err := checkFrameCount("LoadAnimatedGIF", frames); err != nil {
+		return fail(err)
+	}
+	// Every frame is decoded at most at the size of the logical screen
+	if err := checkDecodeSize("LoadAnimatedGIF", config.Width, config.Height, int64(config.Width)*int64(config.Height)*int64(max(frames-1, 0))); err != nil {
+		return fail(err)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br.Reset(file)
+	g, err := gif.DecodeAll(br)
+	if err != nil {
+		return fail(err)
+	}
+	return g, nil
+}
+
+// checkFontData checks a font file against the font limits and, for SFNT
+// data, that every table fits the table limit and lies inside the file
+func checkFontData(operation string, data []byte) *AdvanceError {
+	limits := GetDecodeLimits()
+	if limits.MaxFontSize > 0 && int64(len(data)) > limits.MaxFontSize {
+		return limitError(operation, int64(len(data)), "MaxFontSize", limits.MaxFontSize)
+	}
+	if len(data) < 12 {
+		return nil // too short to be SFNT; the parser reports it
+	}
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "OTTO", "true", "typ1":
+	default:
+		return nil
+	}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	if 12+numTables*16 > len(data) {
+		return fontFormatError("table directory runs past the end of the file")
+	}
+	for i := 0; i < numTables; i++ {
+		entry := data[12+i*16:]
+		offset := int64(binary.BigEndian.Uint32(entry[8:]))
+		length := int64(binary.BigEndian.Uint32(entry[12:]))
+		if limits.MaxFontTableSize > 0 && length > limits.MaxFontTableSize {
+			return limitError(operation, length, "MaxFontTableSize", limits.MaxFontTableSize).
+				WithContext("table", string(entry[:4]))
+		}
+		if offset+length > int64(len(data)) {
+			return fontFormatError(fmt.Sprintf("table %q lies outside the file", entry[:4]))
+		}
+	}
+	return nil
+}
+
+func fontFormatError(details string) *AdvanceError {
+	return NewInvalidFormatError("font", []string{"ttf", "otf", "woff"}).WithContext("details", details)
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withDecodeLimits applies limits for the rest of the test
+func withDecodeLimits(tb testing.TB, limits DecodeLimits) {
+	previous := SetDecodeLimits(limits)
+	tb.Cleanup(func() { SetDecodeLimits(previous) })
+}
+
+// wantLimitError checks that err is a memory error raised by the named limit
+func wantLimitError(t *testing.T, name string, err error, limit string) {
+	t.Helper()
+	ae, ok := err.(*AdvanceError)
+	if !ok || ae == nil {
+		t.Fatalf("%s: error %v, want an *AdvanceError", name, err)
+	}
+	if ae.Type != ErrorTypeMemoryError || ae.Context["limit"] != limit {
+		t.Fatalf("%s: error type %v with limit %v, want a memory error for %s", name, ae.Type, ae.Context["limit"], limit)
+	}
+}
+
+func TestSetDecodeLimits(t *testing.T) {
+	limits := DecodeLimits{MaxPixels: 10}
+	previous := SetDecodeLimits(limits)
+	if GetDecodeLimits() != limits {
+		t.Fatalf("GetDecodeLimits() = %+v, want %+v", GetDecodeLimits(), limits)
+	}
+	if SetDecodeLimits(previous) != limits || GetDecodeLimits() != DefaultDecodeLimits() {
+		t.Fatal("SetDecodeLimits did not restore the defaults")
+	}
+}
+
+func TestDecodeLimitsImageSize(t *testing.T) {
+	dir := t.TempDir()
+	img := formatScene(40, 30, false)
+	for _, name := range []string{"scene.png", "scene.qoi", "scene.tga", "scene.ppm", "scene.hdr", "scene.exr"} {
+		if err := SaveImage(filepath.Join(dir, name), img, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	withDecodeLimits(t, DecodeLimits{MaxPixels: 1000})
+	for _, name := range []string{"scene.png", "scene.qoi", "scene.tga", "scene.ppm"} {
+		_, err := LoadImage(filepath.Join(dir, name))
+		wantLimitError(t, name, err, "MaxPixels")
+	}
+	_, err := LoadPNG(filepath.Join(dir, "scene.png"))
+	wantLimitError(t, "LoadPNG", err, "MaxPixels")
+	_, err = LoadHDR(filepath.Join(dir, "scene.hdr"))
+	wantLimitError(t, "LoadHDR", err, "MaxPixels")
+	_, err = LoadEXR(filepath.Join(dir, "scene.exr"))
+	wantLimitError(t, "LoadEXR", err, "MaxPixels")
+
+	// The decoders check the header themselves
+	data, _ := os.ReadFile(filepath.Join(dir, "scene.qoi"))
+	_, err = DecodeQOI(bytes.NewReader(data))
+	wantLimitError(t, "DecodeQOI", err, "MaxPixels")
+	if _, err := DecodeQOIConfig(bytes.NewReader(data)); err != nil {
+		t.Fatalf("DecodeQOIConfig: %v", err)
+	}
+
+	withDecodeLimits(t, DecodeLimits{MaxWidth: 32})
+	_, err = LoadImage(filepath.Join(dir, "scene.png"))
+	wantLimitError(t, "MaxWidth", err, "MaxWidth")
+	withDecodeLimits(t, DecodeLimits{MaxWidth: 40, MaxHeight: 30, MaxPixels: 1200})
+	if _, err := LoadImage(filepath.Join(dir, "scene.png")); err != nil {
+		t.Fatalf("image at the limits: %v", err)
+	}
+
+	// Huge headers are rejected without overflowing the pixel count
+	withDecodeLimits(t, DecodeLimits{MaxPixels: 1000})
+	huge := []byte{'q', 'o', 'i', 'f', 0x80, 0, 0, 0, 0x80, 0, 0, 0, 4, 0}
+	_, err = DecodeQOI(bytes.NewReader(huge))
+	wantLimitError(t, "huge QOI", err, "MaxInt32")
+	wantLimitError(t, "pixel count", checkDecodeSize("test", 1<<30, 1<<30, 0), "MaxPixels")
+	withDecodeLimits(t, DecodeLimits{})
+	wantLimitError(t, "no limits", checkDecodeSize("test", 1<<31, 1<<31, 0), "MaxInt32")
+	if err := checkDecodeSize("test", 1<<20, 1<<20, 0); err != nil {
+		t.Errorf("no limits: %v", err)
+	}
+}
+
+func TestDecodeLimitsFrames(t *testing.T) {
+	dir := t.TempDir()
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 10, 10), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	gifPath := filepath.Join(dir, "anim.gif")
+	if err := os.WriteFile(gifPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	withDecodeLimits(t, DecodeLimits{MaxFrames: 2})
+	_, err := LoadGIF(gifPath)
+	wantLimitError(t, "LoadGIF", err, "MaxFrames")
+	_, err = LoadAnimatedGIF(gifPath)
+	wantLimitError(t, "LoadAnimatedGIF", err, "MaxFrames")
+
+	// Every frame counts towards MaxPixels
+	withDecodeLimits(t, DecodeLimits{MaxFrames: 3, MaxPixels: 250})
+	if _, err := LoadGIF(gifPath); err != nil {
+		t.Fatalf("LoadGIF: %v", err)
+	}
+	_, err = LoadAnimatedGIF(gifPath)
+	wantLimitError(t, "LoadAnimatedGIF pixels", err, "MaxPixels")
+	withDecodeLimits(t, DecodeLimits{MaxFrames: 3, MaxPixels: 300})
+	if g, err := LoadAnimatedGIF(gifPath); err != nil || len(g.Image) != 3 {
+		t.Fatalf("LoadAnimatedGIF: %v", err)
+	}
+
+	// An APNG declares its frame count in an acTL chunk before the image data
+	buf.Reset()
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	still := buf.Bytes()
+	chunk := []byte{0, 0, 0, 8, 'a', 'c', 'T', 'L', 0, 0, 0, 5, 0, 0, 0, 0}
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	ihdrEnd := 8 + 8 + 13 + 4
+	apng := append(append(append([]byte(nil), still[:ihdrEnd]...), chunk...), still[ihdrEnd:]...)
+	apngPath := filepath.Join(dir, "anim.png")
+	if err := os.WriteFile(apngPath, apng, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withDecodeLimits(t, DecodeLimits{MaxFrames: 4})
+	_, err = LoadImage(apngPath)
+	wantLimitError(t, "APNG", err, "MaxFrames")
+	withDecodeLimits(t, DecodeLimits{MaxFrames: 5})
+	if _, err := LoadImage(apngPath); err != nil {
+		t.Fatalf("APNG within the limit: %v", err)
+	}
+}
+
+// testWOFF wraps a single table of size bytes, compressed when it shrinks
+func testWOFF(size int) []byte {
+	table := bytes.Repeat([]byte("advance"), size/7+1)[:size]
+	var packed bytes.Buffer
+	zw := zlib.NewWriter(&packed)
+	zw.Write(table)
+	zw.Close()
+	comp := packed.Bytes()
+	if len(comp) >= size {
+		comp = table
+	}
+
+	be := binary.BigEndian
+	data := []byte("wOFF\x00\x01\x00\x00")
+	data = be.AppendUint32(data, uint32(44+20+len(comp)))
+	data = be.AppendUint16(data, 1) // numTables
+	data = be.AppendUint16(data, 0)
+	data = be.AppendUint32(data, uint32(12+16+(size+3)&^3))
+	data = append(data, make([]byte, 24)...) // versions, metadata and private data
+	data = append(data, "test"...)
+	data = be.AppendUint32(data, 44+20)
+	data = be.AppendUint32(data, uint32(len(comp)))
+	data = be.AppendUint32(data, uint32(size))
+	data = be.AppendUint32(data, 0)
+	return append(data, comp...)
+}
+
+func TestWOFFLimits(t *testing.T) {
+	woff := testWOFF(1000)
+	sfnt, err := ParseWOFF(woff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sfnt) != 12+16+1000 || !bytes.HasPrefix(sfnt[28:], []byte("advanceadvance")) {
+		t.Fatalf("unexpected SFNT data of %d bytes", len(sfnt))
+	}
+	if err := checkFontData("test", sfnt); err != nil {
+		t.Fatalf("checkFontData: %v", err)
+	}
+
+	withDecodeLimits(t, DecodeLimits{MaxFontTableSize: 999})
+	_, err = ParseWOFF(woff)
+	wantLimitError(t, "ParseWOFF table", err, "MaxFontTableSize")
+	wantLimitError(t, "checkFontData table", checkFontData("test", sfnt), "MaxFontTableSize")
+	withDecodeLimits(t, DecodeLimits{MaxFontSize: 1000})
+	_, err = ParseWOFF(woff)
+	wantLimitError(t, "ParseWOFF size", err, "MaxFontSize")
+
+	path := filepath.Join(t.TempDir(), "font.woff")
+	if err := os.WriteFile(path, woff, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withDecodeLimits(t, DecodeLimits{MaxFontSize: 64})
+	_, err = LoadFontBytes(path)
+	wantLimitError(t, "LoadFontBytes", err, "MaxFontSize")
+
+	// Damaged files are format errors rather than panics
+	withDecodeLimits(t, DefaultDecodeLimits())
+	for name, data := range map[string][]byte{
+		"truncated": woff[:len(woff)-1],
+		"no tables": append(append([]byte(nil), woff[:12]...), append([]byte{0, 0}, woff[14:]...)...),
+		"header":    woff[:20],
+	} {
+		_, err := ParseWOFF(data)
+		if ae, ok := err.(*AdvanceError); !ok || ae.Type != ErrorTypeInvalidFormat {
+			t.Errorf("%s: error %v, want an invalid format error", name, err)
+		}
+	}
+	bad := append([]byte(nil), sfnt...)
+	binary.BigEndian.PutUint32(bad[12+12:], 2000)
+	if err := checkFontData("test", bad); err == nil || err.Type != ErrorTypeInvalidFormat {
+		t.Errorf("table past the end: error %v, want an invalid format error", err)
+	}
+}
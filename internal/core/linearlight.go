@@ -0,0 +1,466 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// Linear-light (gamma-correct) rendering. Pixels stay 8-bit premultiplied
+// sRGB in memory; in linear-light mode they are decoded before blending,
+// interpolating, blurring or resampling and encoded again afterwards, so
+// antialiased edges, blurs and downscales no longer come out too dark.
+
+var (
+	// srgbDecodeLUT maps an 8-bit sRGB sample to 16-bit linear light
+	srgbDecodeLUT [256]uint16
+	// srgbEncodeLUT maps 16-bit linear light to an 8-bit sRGB sample
+	srgbEncodeLUT [1 << 16]uint8
+)
+
+func init() {
+	for i := range srgbDecodeLUT {
+		srgbDecodeLUT[i] = uint16(srgbToLinear(float64(i)/255)*0xffff + 0.5)
+	}
+	for i := range srgbEncodeLUT {
+		srgbEncodeLUT[i] = uint8(linearToSRGB(float64(i)/0xffff)*255 + 0.5)
+	}
+}
+
+// SetLinearLight turns linear-light rendering on or off. It applies to
+// fills, strokes, gradients, shadow blur, DrawImage resampling, layer
+// compositing and the blur filters run through ApplyFilter, on 8-bit,
+// 16-bit and float canvases alike. Other filters and the standalone
+// image functions are unaffected.
+func (dc *Context) SetLinearLight(enabled bool) {
+	dc.linearLight = enabled
+	if dc.layerManager != nil {
+		dc.layerManager.LinearLight = enabled
+	}
+}
+
+// LinearLight reports whether linear-light rendering is on
+func (dc *Context) LinearLight() bool {
+	return dc.linearLight
+}
+
+// decodePremultiplied converts an 8-bit premultiplied sRGB pixel to 16-bit
+// premultiplied linear light
+func decodePremultiplied(r, g, b, a uint8) (uint32, uint32, uint32, uint32) {
+	switch a {
+	case 0:
+		return 0, 0, 0, 0
+	case 255:
+		return uint32(srgbDecodeLUT[r]), uint32(srgbDecodeLUT[g]), uint32(srgbDecodeLUT[b]), 0xffff
+	}
+	a16 := uint32(a) * 0x101
+	decode := func(v uint8) uint32 {
+		straight := (uint32(v)*255 + uint32(a)/2) / uint32(a)
+		if straight > 255 {
+			straight = 255
+		}
+		return uint32(srgbDecodeLUT[straight]) * a16 / 0xffff
+	}
+	return decode(r), decode(g), decode(b), a16
+}
+
+// encodePremultiplied converts 16-bit premultiplied linear light back to
+// an 8-bit premultiplied sRGB pixel
+func encodePremultiplied(r, g, b, a uint32) (uint8, uint8, uint8, uint8) {
+	if a > 0xffff {
+		a = 0xffff
+	}
+	a8 := (a*255 + 0x7fff) / 0xffff
+	if a8 == 0 {
+		return 0, 0, 0, 0
+	}
+	encode := func(v uint32) uint8 {
+		s := uint32(255)
+		if v < a {
+			s = uint32(srgbEncodeLUT[v*0xffff/a])
+		}
+		return uint8((s*a8 + 127) / 255)
+	}
+	return encode(r), encode(g), encode(b), uint8(a8)
+}
+
+// encodeLinear255 encodes premultiplied linear light scaled to 0-255, as
+// the blur and resize kernels produce it
+func encodeLinear255(r, g, b, a float64) color.RGBA {
+	a16 := uint32(clamp(a*257+0.5, 0, 0xffff))
+	to16 := func(v float64) uint32 {
+		return uint32(clamp(v*257+0.5, 0, float64(a16)))
+	}
+	r8, g8, b8, a8 := encodePremultiplied(to16(r), to16(g), to16(b), a16)
+	return color.RGBA{r8, g8, b8, a8}
+}
+
+// linearColor is a 16-bit premultiplied linear-light color. Gradients hand
+// it to the painter so a linear interpolation is not rounded to sRGB and
+// decoded again; anything else sees the encoded sRGB value.
+type linearColor struct {
+	r, g, b, a uint32
+}
+
+// RGBA satisfies the color.Color interface
+func (c linearColor) RGBA() (uint32, uint32, uint32, uint32) {
+	r, g, b, a := encodePremultiplied(c.r, c.g, c.b, c.a)
+	return uint32(r) * 0x101, uint32(g) * 0x101, uint32(b) * 0x101, uint32(a) * 0x101
+}
+
+// toLinearColor decodes any color to premultiplied linear light
+func toLinearColor(c color.Color) linearColor {
+	switch c := c.(type) {
+	case linearColor:
+		return c
+	case color.RGBA:
+		r, g, b, a := decodePremultiplied(c.R, c.G, c.B, c.A)
+		return linearColor{r, g, b, a}
+	}
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return linearColor{}
+	}
+	straight := func(v uint32) uint32 {
+		s := (v*0xffff/a + 0x80) >> 8
+		if s > 255 {
+			s = 255
+		}
+		return uint32(srgbDecodeLUT[s]) * a / 0xffff
+	}
+	return linearColor{straight(r), straight(g), straight(b), a}
+}
+
+// colorLerpLinear interpolates two colors in premultiplied linear light
+func colorLerpLinear(c0, c1 color.Color, t float64) color.Color {
+	a, b := toLinearColor(c0), toLinearColor(c1)
+	mix := func(x, y uint32) uint32 {
+		return uint32(float64(x)*(1-t) + float64(y)*t + 0.5)
+	}
+	return linearColor{mix(a.r, b.r), mix(a.g, b.g), mix(a.b, b.b), mix(a.a, b.a)}
+}
+
+// blendLinear composites the pattern over the pixel at offset i with
+// coverage ma, in linear light
+func (r *patternPainter) blendLinear(i, x, y int, ma uint32) {
+	const m = 1<<16 - 1
+	var c color.Color
+	if mp, ok := r.p.(mixingPattern); ok {
+		c = mp.colorAt(x, y, colorLerpLinear)
+	} else {
+		c = r.p.ColorAt(x, y)
+	}
+	src := toLinearColor(c)
+	pix := r.im.Pix[i : i+4 : i+4]
+	dr, dg, db, da := decodePremultiplied(pix[0], pix[1], pix[2], pix[3])
+	sa := src.a * ma / m
+	inv := m - sa
+	pix[0], pix[1], pix[2], pix[3] = encodePremultiplied(
+		src.r*ma/m+dr*inv/m,
+		src.g*ma/m+dg*inv/m,
+		src.b*ma/m+db*inv/m,
+		sa+da*inv/m,
+	)
+}
+
+// overLinear composites a premultiplied pixel over the first four bytes
+// of pix in linear light
+func overLinear(pix []uint8, c color.RGBA) {
+	const m = 1<<16 - 1
+	sr, sg, sb, sa := decodePremultiplied(c.R, c.G, c.B, c.A)
+	dr, dg, db, da := decodePremultiplied(pix[0], pix[1], pix[2], pix[3])
+	inv := m - sa
+	pix[0], pix[1], pix[2], pix[3] = encodePremultiplied(sr+dr*inv/m, sg+dg*inv/m, sb+db*inv/m, sa+da*inv/m)
+}
+
+// transformedArea returns the part of bounds that src covers once mapped
+// through s2d, padded by a pixel for the resampling filter
+func transformedArea(src image.Rectangle, s2d f64.Aff3, bounds image.Rectangle) image.Rectangle {
+	area := image.Rectangle{}
+	for _, p := range [][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		x := float64(src.Min.X) + p[0]*float64(src.Dx())
+		y := float64(src.Min.Y) + p[1]*float64(src.Dy())
+		tx := s2d[0]*x + s2d[1]*y + s2d[2]
+		ty := s2d[3]*x + s2d[4]*y + s2d[5]
+		pt := image.Rect(int(math.Floor(tx))-1, int(math.Floor(ty))-1, int(math.Ceil(tx))+1, int(math.Ceil(ty))+1)
+		area = area.Union(pt)
+	}
+	return area.Intersect(bounds)
+}
+
+// drawImageLinear draws im through the transform s2d, resampling and
+// compositing in linear light. Only the covered part of the canvas is
+// decoded.
+func (dc *Context) drawImageLinear(im image.Image, s2d f64.Aff3, transformer draw.Transformer) {
+	sb := im.Bounds()
+	area := transformedArea(sb, s2d, dc.im.Bounds())
+	if area.Empty() {
+		return
+	}
+
+	src := decodeLinearRGBA64(imageToRGBA(im), sb)
+	dst := decodeLinearRGBA64(dc.im, area)
+	before := image.NewRGBA64(area)
+	copy(before.Pix, dst.Pix)
+	var opts *draw.Options
+	if dc.mask != nil {
+		opts = &draw.Options{DstMask: dc.mask, DstMaskP: image.Point{}}
+	}
+	transformer.Transform(dst, s2d, src, sb, draw.Over, opts)
+	encodeLinearRGBA64(dc.im, dst, before)
+}
+
+// drawImageDeepLinear is drawImageLinear for 16-bit and float canvases
+func (dc *Context) drawImageDeepLinear(im image.Image, s2d f64.Aff3, transformer draw.Transformer) {
+	sb := im.Bounds()
+	area := transformedArea(sb, s2d, dc.deep.Bounds())
+	if area.Empty() {
+		return
+	}
+
+	src := NewRGBAF32(sb)
+	draw.Draw(src, sb, im, sb.Min, draw.Src)
+	src = mapDeep(src, decodeDeep).(*RGBAF32)
+	dst := NewRGBAF32(area)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			dst.setPixel(x, y, decodeDeep(dc.deep.pixel(x, y)))
+		}
+	}
+	var opts *draw.Options
+	if dc.mask != nil {
+		opts = &draw.Options{DstMask: dc.mask, DstMaskP: image.Point{}}
+	}
+	transformer.Transform(dst, s2d, src, sb, draw.Over, opts)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			dc.deep.setPixel(x, y, encodeDeep(dst.pixel(x, y)))
+		}
+	}
+}
+
+// decodeDeep converts a premultiplied sRGB float pixel to premultiplied
+// linear light
+func decodeDeep(c [4]float32) [4]float32 {
+	if c[3] <= 0 {
+		return c
+	}
+	for i := 0; i < 3; i++ {
+		c[i] = float32(srgbToLinear(float64(c[i]/c[3]))) * c[3]
+	}
+	return c
+}
+
+// encodeDeep converts a premultiplied linear-light float pixel back to
+// premultiplied sRGB
+func encodeDeep(c [4]float32) [4]float32 {
+	if c[3] <= 0 {
+		return c
+	}
+	for i := 0; i < 3; i++ {
+		c[i] = float32(linearToSRGB(float64(c[i]/c[3]))) * c[3]
+	}
+	return c
+}
+
+// decodeLinearRGBA64 decodes the part of src inside r to 16-bit
+// premultiplied linear light
+func decodeLinearRGBA64(src *image.RGBA, r image.Rectangle) *image.RGBA64 {
+	r = r.Intersect(src.Bounds())
+	dst := image.NewRGBA64(r)
+	parallelRows(r.Dy(), 0, func(start, end int) {
+		for y := r.Min.Y + start; y < r.Min.Y+end; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				cr, cg, cb, ca := decodePremultiplied(src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3])
+				dst.SetRGBA64(x, y, color.RGBA64{uint16(cr), uint16(cg), uint16(cb), uint16(ca)})
+			}
+		}
+	})
+	return dst
+}
+
+// encodeLinearRGBA64 writes the pixels of lin that differ from before back
+// into dst as premultiplied sRGB. Untouched pixels keep their exact value.
+func encodeLinearRGBA64(dst *image.RGBA, lin, before *image.RGBA64) {
+	r := lin.Bounds()
+	parallelRows(r.Dy(), 0, func(start, end int) {
+		for y := r.Min.Y + start; y < r.Min.Y+end; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := lin.RGBA64At(x, y)
+				if before != nil && c == before.RGBA64At(x, y) {
+					continue
+				}
+				i := dst.PixOffset(x, y)
+				dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] =
+					encodePremultiplied(uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A))
+			}
+		}
+	})
+}
+
+// ResizeImageLinear resizes an image with bilinear interpolation in linear
+// light
+func ResizeImageLinear(img image.Image, newWidth, newHeight int) image.Image {
+	if img == nil || newWidth <= 0 || newHeight <= 0 {
+		return img
+	}
+	return resizeBilinearRGBA(imageToRGBA(img), newWidth, newHeight, true)
+}
+
+// compositeLayerLinear composites a layer in premultiplied linear light.
+// Separable blend modes follow the W3C Compositing and Blending formulas;
+// the others fall back to normal, as in gamma space.
+func (lm *LayerManager) compositeLayerLinear(dst *image.RGBA, layer *Layer) {
+	bounds := dst.Bounds()
+	mode := layer.BlendMode
+	const m = 0xffff
+	parallelRows(bounds.Dy(), 0, func(start, end int) {
+		for y := bounds.Min.Y + start; y < bounds.Min.Y+end; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if !image.Pt(x, y).In(layer.Image.Bounds()) {
+					continue
+				}
+				i := layer.Image.PixOffset(x, y)
+				p := layer.Image.Pix[i : i+4 : i+4]
+				sr, sg, sb, sa := decodePremultiplied(p[0], p[1], p[2], p[3])
+				scale := clamp(layer.Opacity, 0, 1)
+				if layer.Mask != nil {
+					scale *= float64(layer.Mask.AlphaAt(x, y).A) / 255
+				}
+				src := [4]float64{float64(sr) / m * scale, float64(sg) / m * scale, float64(sb) / m * scale, float64(sa) / m * scale}
+				if src[3] == 0 && isStandardBlendMode(mode) {
+					continue
+				}
+
+				j := dst.PixOffset(x, y)
+				q := dst.Pix[j : j+4 : j+4]
+				dr, dg, db, da := decodePremultiplied(q[0], q[1], q[2], q[3])
+				dstC := [4]float64{float64(dr) / m, float64(dg) / m, float64(db) / m, float64(da) / m}
+
+				var out [4]float64
+				if isCompositingOperator(mode) {
+					out = porterDuffLinear(src, dstC, mode)
+				} else {
+					out = blendLinearPremultiplied(src, dstC, mode)
+				}
+				c := encodeLinear255(out[0]*255, out[1]*255, out[2]*255, out[3]*255)
+				q[0], q[1], q[2], q[3] = c.R, c.G, c.B, c.A
+			}
+		}
+	})
+}
+
+// porterDuffLinear applies a Porter-Duff operator to premultiplied colors
+func porterDuffLinear(src, dst [4]float64, mode BlendMode) [4]float64 {
+	sa, da := src[3], dst[3]
+	var fa, fb float64
+	switch mode {
+	case BlendModeClear:
+		fa, fb = 0, 0
+	case BlendModeSource:
+		fa, fb = 1, 0
+	case BlendModeDest:
+		fa, fb = 0, 1
+	case BlendModeDstOver:
+		fa, fb = 1-da, 1
+	case BlendModeSrcIn:
+		fa, fb = da, 0
+	case BlendModeDstIn:
+		fa, fb = 0, sa
+	case BlendModeSrcOut:
+		fa, fb = 1-da, 0
+	case BlendModeDstOut:
+		fa, fb = 0, 1-sa
+	case BlendModeSrcAtop:
+		fa, fb = da, 1-sa
+	case BlendModeDstAtop:
+		fa, fb = 1-da, sa
+	case BlendModeXor:
+		fa, fb = 1-da, 1-sa
+	case BlendModeAdd:
+		fa, fb = 1, 1
+	default: // BlendModeSrcOver
+		fa, fb = 1, 1-sa
+	}
+	var out [4]float64
+	for c := range out {
+		out[c] = math.Min(1, src[c]*fa+dst[c]*fb)
+	}
+	return out
+}
+
+// blendLinearPremultiplied blends premultiplied src over dst with a
+// separable blend mode
+func blendLinearPremultiplied(src, dst [4]float64, mode BlendMode) [4]float64 {
+	sa, da := src[3], dst[3]
+	var out [4]float64
+	for c := 0; c < 3; c++ {
+		var cs, cb float64
+		if sa > 0 {
+			cs = math.Min(1, src[c]/sa)
+		}
+		if da > 0 {
+			cb = math.Min(1, dst[c]/da)
+		}
+		out[c] = (1-da)*src[c] + (1-sa)*dst[c] + sa*da*blendChannel(cb, cs, mode)
+	}
+	out[3] = sa + da - sa*da
+	return out
+}
+
+// blendChannel returns B(cb, cs) for a backdrop and source channel
+func blendChannel(cb, cs float64, mode BlendMode) float64 {
+	switch mode {
+	case BlendModeMultiply:
+		return cb * cs
+	case BlendModeScreen:
+		return cb + cs - cb*cs
+	case BlendModeOverlay:
+		return blendChannel(cs, cb, BlendModeHardLight)
+	case BlendModeDarken:
+		return math.Min(cb, cs)
+	case BlendModeLighten:
+		return math.Max(cb, cs)
+	case BlendModeColorDodge:
+		switch {
+		case cb == 0:
+			return 0
+		case cs >= 1:
+			return 1
+		}
+		return math.Min(1, cb/(1-cs))
+	case BlendModeColorBurn:
+		switch {
+		case cb >= 1:
+			return 1
+		case cs == 0:
+			return 0
+		}
+		return 1 - math.Min(1, (1-cb)/cs)
+	case BlendModeHardLight:
+		if cs <= 0.5 {
+			return cb * 2 * cs
+		}
+		s := 2*cs - 1
+		return cb + s - cb*s
+	case BlendModeSoftLight:
+		if cs <= 0.5 {
+			return cb - (1-2*cs)*cb*(1-cb)
+		}
+		d := math.Sqrt(cb)
+		if cb <= 0.25 {
+			d = ((16*cb-12)*cb + 4) * cb
+		}
+		return cb + (2*cs-1)*(d-cb)
+	case BlendModeDifference:
+		return math.Abs(cb - cs)
+	case BlendModeExclusion:
+		return cb + cs - 2*cb*cs
+	default:
+		return cs
+	}
+}
@@ -0,0 +1,308 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/golang/freetype/raster"
+)
+
+// The reference renders below are computed per pixel in float64 with the
+// exact sRGB transfer functions, independently of the lookup tables
+
+func refEncode(v float64) float64 {
+	return linearToSRGB(clamp(v, 0, 1)) * 255
+}
+
+func refDecode(v uint8) float64 {
+	return srgbToLinear(float64(v) / 255)
+}
+
+func assertNear(t *testing.T, what string, got uint8, want float64, tolerance float64) {
+	t.Helper()
+	if math.Abs(float64(got)-want) > tolerance {
+		t.Errorf("%s = %d, want %.1f", what, got, want)
+	}
+}
+
+func TestSRGBLookupTables(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		lin := srgbDecodeLUT[i]
+		if got := srgbEncodeLUT[lin]; int(got) != i {
+			t.Errorf("round trip of %d gave %d", i, got)
+		}
+		if want := refDecode(uint8(i)) * 0xffff; math.Abs(float64(lin)-want) > 0.5 {
+			t.Errorf("decode(%d) = %d, want %.1f", i, lin, want)
+		}
+	}
+	// Premultiplied pixels survive a round trip within one step
+	for _, a := range []uint8{255, 200, 128, 17} {
+		for v := 0; v <= int(a); v += 7 {
+			r, g, b, a2 := encodePremultiplied(decodePremultiplied(uint8(v), uint8(v), uint8(v), a))
+			if a2 != a || abs(int(r)-v) > 1 || g != r || b != r {
+				t.Errorf("pixel %d/%d came back as %d/%d", v, a, r, a2)
+			}
+		}
+	}
+}
+
+func TestLinearLightFill(t *testing.T) {
+	fg := color.RGBA{250, 120, 30, 255}
+	bg := color.RGBA{20, 60, 200, 255}
+	// Exact 16-bit coverage of every pixel, straight from the rasterizer
+	var coverage [64][64]float64
+	cov := NewContext(64, 64)
+	cov.DrawCircle(32.3, 31.7, 20.4)
+	cov.fill(raster.PainterFunc(func(ss []raster.Span, done bool) {
+		for _, s := range ss {
+			for x := s.X0; x < s.X1; x++ {
+				coverage[s.Y][x] += float64(s.Alpha) / 0xffff
+			}
+		}
+	}))
+
+	dc := NewContext(64, 64)
+	dc.SetColor(bg)
+	dc.Clear()
+	dc.SetLinearLight(true)
+	dc.SetColor(fg)
+	dc.DrawCircle(32.3, 31.7, 20.4)
+	dc.Fill()
+
+	im := dc.Image().(*image.RGBA)
+	edges := 0
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			a := coverage[y][x]
+			got := im.RGBAAt(x, y)
+			if a > 0 && a < 1 {
+				edges++
+			}
+			for ch, pair := range [3][2]uint8{{fg.R, bg.R}, {fg.G, bg.G}, {fg.B, bg.B}} {
+				want := refEncode(a*refDecode(pair[0]) + (1-a)*refDecode(pair[1]))
+				assertNear(t, "fill channel", []uint8{got.R, got.G, got.B}[ch], want, 1)
+			}
+		}
+	}
+	if edges < 50 {
+		t.Fatalf("only %d antialiased pixels", edges)
+	}
+}
+
+func TestLinearLightEdgeBrightness(t *testing.T) {
+	// A white rectangle covering exactly half of a column of black pixels
+	render := func(linear bool) uint8 {
+		dc := NewContext(4, 4)
+		dc.SetRGB(0, 0, 0)
+		dc.Clear()
+		dc.SetLinearLight(linear)
+		dc.SetRGB(1, 1, 1)
+		dc.DrawRectangle(0, 0, 1.5, 4)
+		dc.Fill()
+		return dc.im.RGBAAt(1, 2).R
+	}
+	assertNear(t, "gamma edge", render(false), 128, 1)
+	assertNear(t, "linear edge", render(true), 188, 1)
+}
+
+func TestLinearLightGradient(t *testing.T) {
+	dc := NewContext(256, 2)
+	dc.SetLinearLight(true)
+	g := NewLinearGradient(0, 0, 255, 0)
+	g.AddColorStop(0, color.RGBA{255, 0, 0, 255})
+	g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
+	dc.SetFillStyle(g)
+	dc.DrawRectangle(0, 0, 256, 2)
+	dc.Fill()
+
+	for x := 0; x < 256; x++ {
+		t0 := float64(x) / 255
+		got := dc.im.RGBAAt(x, 0)
+		assertNear(t, "gradient red", got.R, refEncode(1-t0), 1)
+		assertNear(t, "gradient blue", got.B, refEncode(t0), 1)
+	}
+	// Halfway between red and blue stays bright instead of dipping
+	if mid := dc.im.RGBAAt(128, 0); mid.R < 180 || mid.B < 180 {
+		t.Errorf("midpoint = %v", mid)
+	}
+}
+
+func TestLinearLightBlur(t *testing.T) {
+	// A one-pixel white line on black, blurred across its width
+	const w, sigma = 33, 2.0
+	src := image.NewRGBA(image.Rect(0, 0, w, 1))
+	for x := 0; x < w; x++ {
+		src.SetRGBA(x, 0, color.RGBA{0, 0, 0, 255})
+	}
+	src.SetRGBA(w/2, 0, color.RGBA{255, 255, 255, 255})
+
+	got := GaussianBlurWithOptions(src, BlurOptions{Sigma: sigma, Edge: EdgeClamp, Method: BlurExact, Linear: true})
+	kernel := gaussianKernel(sigma)
+	radius := len(kernel) / 2
+	for x := 0; x < w; x++ {
+		var sum float64
+		for k, weight := range kernel {
+			sx := x + k - radius
+			if sx == w/2 {
+				sum += float64(weight)
+			}
+		}
+		assertNear(t, "blurred pixel", got.RGBAAt(x, 0).G, refEncode(sum), 1)
+	}
+
+	gamma := GaussianBlurImage(src, sigma, EdgeClamp)
+	if got.RGBAAt(w/2+2, 0).G <= gamma.RGBAAt(w/2+2, 0).G {
+		t.Error("a linear blur should keep more of the bright line")
+	}
+}
+
+func TestLinearLightResize(t *testing.T) {
+	// Alternating black and white columns downscale to mid gray, which in
+	// linear light is sRGB 188 rather than 128
+	src := image.NewRGBA(image.Rect(0, 0, 64, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(255 * (x % 2))
+			src.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	got := ResizeImageLinear(src, 21, 4).(*image.RGBA)
+
+	xRatio := 63.0 / 21
+	for x := 0; x < 21; x++ {
+		fx := xRatio * float64(x)
+		sx := math.Floor(fx)
+		dx := fx - sx
+		ref := (1-dx)*refDecode(src.Pix[int(sx)*4]) + dx*refDecode(src.Pix[int(sx+1)*4])
+		assertNear(t, "resized pixel", got.RGBAAt(x, 1).R, refEncode(ref), 1)
+	}
+}
+
+func TestLinearLightDrawImage(t *testing.T) {
+	checker := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint8(255 * ((x + y) % 2))
+			checker.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	render := func(linear bool) color.RGBA {
+		dc := NewContext(16, 16)
+		dc.SetLinearLight(linear)
+		dc.Scale(0.5, 0.5)
+		dc.DrawImage(checker, 0, 0)
+		return dc.im.RGBAAt(8, 8)
+	}
+	assertNear(t, "gamma downscale", render(false).G, 128, 4)
+	assertNear(t, "linear downscale", render(true).G, 188, 4)
+}
+
+func TestLinearLightDeep(t *testing.T) {
+	at := func(dc *Context, x, y int) color.RGBA {
+		return color.RGBAModel.Convert(dc.Image().At(x, y)).(color.RGBA)
+	}
+	checker := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint8(255 * ((x + y) % 2))
+			checker.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	for _, format := range []PixelFormat{FormatRGBA16, FormatRGBAF32} {
+		dc := NewContextWithFormat(16, 16, format)
+		dc.SetRGB(0, 0, 0)
+		dc.Clear()
+		dc.SetLinearLight(true)
+		dc.SetRGBA(1, 1, 1, 0.5)
+		dc.DrawRectangle(0, 0, 4, 4)
+		dc.Fill()
+		assertNear(t, "deep linear fill", at(dc, 2, 2).R, 188, 1)
+
+		layer := image.NewRGBA(dc.im.Rect)
+		layer.SetRGBA(8, 2, color.RGBA{128, 128, 128, 128})
+		dc.compositeDeep(layer)
+		assertNear(t, "deep linear composite", at(dc, 8, 2).R, 188, 1)
+
+		g := NewLinearGradient(0, 0, 16, 0)
+		g.AddColorStop(0, color.RGBA{255, 0, 0, 255})
+		g.AddColorStop(1, color.RGBA{0, 0, 255, 255})
+		dc.SetFillStyle(g)
+		dc.DrawRectangle(0, 4, 16, 4)
+		dc.Fill()
+		if mid := at(dc, 8, 6); mid.R < 180 || mid.B < 180 {
+			t.Errorf("format %v: gradient midpoint = %v", format, mid)
+		}
+
+		dc.Push()
+		dc.Translate(0, 8)
+		dc.Scale(0.5, 0.5)
+		dc.DrawImage(checker, 0, 0)
+		dc.Pop()
+		assertNear(t, "deep linear downscale", at(dc, 8, 12).G, 188, 4)
+	}
+}
+
+func TestLinearLightLayers(t *testing.T) {
+	fg := color.RGBA{230, 140, 40, 255}
+	bg := color.RGBA{40, 90, 160, 255}
+	modes := map[BlendMode]func(s, d float64) float64{
+		BlendModeNormal:   func(s, d float64) float64 { return s },
+		BlendModeMultiply: func(s, d float64) float64 { return s * d },
+		BlendModeScreen:   func(s, d float64) float64 { return s + d - s*d },
+		BlendModeLighten:  math.Max,
+	}
+	for mode, blend := range modes {
+		lm := NewLayerManager(2, 2)
+		lm.LinearLight = true
+		lm.Background = bg
+		layer := lm.AddLayer("top")
+		layer.Fill(fg)
+		layer.SetBlendMode(mode)
+		layer.SetOpacity(0.5)
+		out := lm.Composite().RGBAAt(1, 1)
+
+		for ch, pair := range [3][2]uint8{{fg.R, bg.R}, {fg.G, bg.G}, {fg.B, bg.B}} {
+			s, d := refDecode(pair[0]), refDecode(pair[1])
+			want := refEncode(0.5*blend(s, d) + 0.5*d)
+			assertNear(t, "layer channel", []uint8{out.R, out.G, out.B}[ch], want, 1)
+		}
+	}
+
+	dc := NewContext(2, 2)
+	dc.SetLinearLight(true)
+	dc.EnableLayers()
+	if !dc.GetLayerManager().LinearLight {
+		t.Error("layers should inherit linear light from the context")
+	}
+}
+
+func TestLinearLightBlurFilter(t *testing.T) {
+	checker := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			v := uint8(255 * ((x + y) % 2))
+			checker.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	for _, format := range []PixelFormat{FormatRGBA8, FormatRGBA16, FormatRGBAF32} {
+		for _, linear := range []bool{false, true} {
+			dc := NewContextWithFormat(16, 16, format)
+			dc.DrawImage(checker, 0, 0)
+			dc.SetLinearLight(linear)
+			dc.ApplyFilter(GaussianBlur(3))
+			got := color.RGBAModel.Convert(dc.Image().At(8, 8)).(color.RGBA)
+			want := uint8(128)
+			if linear {
+				want = 188
+			}
+			if d := int(got.R) - int(want); d < -2 || d > 2 {
+				t.Errorf("format %v, linear %v: blurred checker = %d, want %d", format, linear, got.R, want)
+			}
+		}
+	}
+	if linearCanvas(checker) {
+		t.Error("ApplyFilter should forget the canvas once the filter returns")
+	}
+}
@@ -0,0 +1,339 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 3D color lookup tables: Adobe .cube files and Hald CLUT images
+
+// LUTInterpolation selects how a 3D LUT is sampled between grid points
+type LUTInterpolation int
+
+const (
+	// LUTTetrahedral splits each grid cell into six tetrahedra; it is cheaper
+	// than trilinear and keeps neutral grays neutral
+	LUTTetrahedral LUTInterpolation = iota
+	// LUTTrilinear blends the eight corners of the enclosing grid cell
+	LUTTrilinear
+)
+
+// LUT3D is a cubic color lookup table of Size^3 RGB entries on a 0-1 scale,
+// stored with red varying fastest, then green, then blue
+type LUT3D struct {
+	Title     string
+	Size      int
+	DomainMin [3]float64
+	DomainMax [3]float64
+	Data      []float32
+}
+
+// NewIdentityLUT3D creates a LUT of the given size that leaves colors unchanged
+func NewIdentityLUT3D(size int) *LUT3D {
+	if size < 2 {
+		size = 2
+	}
+	lut := &LUT3D{
+		Size:      size,
+		DomainMax: [3]float64{1, 1, 1},
+		Data:      make([]float32, size*size*size*3),
+	}
+	scale := float32(size - 1)
+	for b := 0; b < size; b++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				i := ((b*size+g)*size + r) * 3
+				lut.Data[i] = float32(r) / scale
+				lut.Data[i+1] = float32(g) / scale
+				lut.Data[i+2] = float32(b) / scale
+			}
+		}
+	}
+	return lut
+}
+
+// LoadCubeLUT loads an Adobe/Resolve .cube 3D LUT file
+func LoadCubeLUT(path string) (*LUT3D, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lut, err := ParseCubeLUT(file)
+	if err != nil {
+		if ae, ok := err.(*AdvanceError); ok {
+			ae.WithContext("filepath", path)
+		}
+		return nil, err
+	}
+	return lut, nil
+}
+
+// ParseCubeLUT reads a .cube 3D LUT. 1D LUTs are not supported.
+func ParseCubeLUT(r io.Reader) (*LUT3D, error) {
+	lut := &LUT3D{DomainMax: [3]float64{1, 1, 1}}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	formatError := func(msg string) error {
+		return NewInvalidFormatError("cube LUT", []string{"cube"}).
+			WithContext("line", lineNo).
+			WithContext("details", msg)
+	}
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+
+		switch strings.ToUpper(fields[0]) {
+		case "TITLE":
+			lut.Title = strings.Trim(strings.TrimSpace(line[len(fields[0]):]), "\"")
+			continue
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return nil, formatError("LUT_3D_SIZE needs one value")
+			}
+			size, err := strconv.Atoi(fields[1])
+			if err != nil || size < 2 || size > 256 {
+				return nil, formatError("LUT_3D_SIZE must be between 2 and 256")
+			}
+			lut.Size = size
+			lut.Data = make([]float32, 0, size*size*size*3)
+			continue
+		case "LUT_1D_SIZE":
+			return nil, NewUnsupportedOperationError("ParseCubeLUT", "1D LUTs are not supported")
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			if len(fields) != 4 {
+				return nil, formatError(fields[0] + " needs three values")
+			}
+			var domain [3]float64
+			for i := 0; i < 3; i++ {
+				v, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return nil, formatError("invalid " + fields[0])
+				}
+				domain[i] = v
+			}
+			if strings.ToUpper(fields[0]) == "DOMAIN_MIN" {
+				lut.DomainMin = domain
+			} else {
+				lut.DomainMax = domain
+			}
+			continue
+		}
+
+		if lut.Size == 0 {
+			return nil, formatError("table data before LUT_3D_SIZE")
+		}
+		if len(fields) != 3 {
+			return nil, formatError("table rows need three values")
+		}
+		if len(lut.Data) >= cap(lut.Data) {
+			return nil, formatError("too many table rows")
+		}
+		for _, f := range fields {
+			v, err := strconv.ParseFloat(f, 32)
+			if err != nil {
+				return nil, formatError("invalid table value " + f)
+			}
+			lut.Data = append(lut.Data, float32(v))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if lut.Size == 0 {
+		return nil, formatError("missing LUT_3D_SIZE")
+	}
+	if want := lut.Size * lut.Size * lut.Size * 3; len(lut.Data) != want {
+		return nil, formatError(fmt.Sprintf("expected %d table rows, found %d", want/3, len(lut.Data)/3))
+	}
+	for i := 0; i < 3; i++ {
+		if lut.DomainMax[i] <= lut.DomainMin[i] {
+			return nil, formatError("DOMAIN_MAX must exceed DOMAIN_MIN")
+		}
+	}
+	return lut, nil
+}
+
+// WriteCube writes the LUT in .cube format
+func (l *LUT3D) WriteCube(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if l.Title != "" {
+		fmt.Fprintf(bw, "TITLE \"%s\"\n", l.Title)
+	}
+	fmt.Fprintf(bw, "LUT_3D_SIZE %d\n", l.Size)
+	if l.DomainMin != [3]float64{} || l.DomainMax != [3]float64{1, 1, 1} {
+		fmt.Fprintf(bw, "DOMAIN_MIN %g %g %g\n", l.DomainMin[0], l.DomainMin[1], l.DomainMin[2])
+		fmt.Fprintf(bw, "DOMAIN_MAX %g %g %g\n", l.DomainMax[0], l.DomainMax[1], l.DomainMax[2])
+	}
+	for i := 0; i+2 < len(l.Data); i += 3 {
+		fmt.Fprintf(bw, "%.6f %.6f %.6f\n", l.Data[i], l.Data[i+1], l.Data[i+2])
+	}
+	return bw.Flush()
+}
+
+// LoadHaldCLUT loads a Hald CLUT image from disk
+func LoadHaldCLUT(path string) (*LUT3D, error) {
+	img, err := LoadImage(path)
+	if err != nil {
+		return nil, err
+	}
+	lut, err := NewLUT3DFromHald(img)
+	if err != nil {
+		if ae, ok := err.(*AdvanceError); ok {
+			ae.WithContext("filepath", path)
+		}
+		return nil, err
+	}
+	return lut, nil
+}
+
+// NewLUT3DFromHald converts a Hald CLUT image, whose pixel count must be a
+// perfect cube (for example 512x512 for a 64-point LUT), into a LUT3D
+func NewLUT3DFromHald(img image.Image) (*LUT3D, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	size := int(math.Round(math.Cbrt(float64(w * h))))
+	if size < 2 || size*size*size != w*h {
+		return nil, NewInvalidParameterError("img", fmt.Sprintf("%dx%d", w, h), "a Hald CLUT whose pixel count is a perfect cube")
+	}
+
+	lut := &LUT3D{
+		Size:      size,
+		DomainMax: [3]float64{1, 1, 1},
+		Data:      make([]float32, size*size*size*3),
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			i := (y*w + x) * 3
+			lut.Data[i] = float32(r) / 0xffff
+			lut.Data[i+1] = float32(g) / 0xffff
+			lut.Data[i+2] = float32(b) / 0xffff
+		}
+	}
+	return lut, nil
+}
+
+// NewHaldCLUTImage renders an identity Hald CLUT of the given level (the
+// LUT has level^2 points per axis). Grade it in any editor and load it back
+// with NewLUT3DFromHald.
+func NewHaldCLUTImage(level int) *image.RGBA {
+	if level < 2 {
+		level = 2
+	}
+	size := level * level
+	side := level * level * level
+	img := image.NewRGBA(image.Rect(0, 0, side, side))
+	scale := 255 / float64(size-1)
+	for i := 0; i < side*side; i++ {
+		r := i % size
+		g := (i / size) % size
+		b := i / (size * size)
+		o := i * 4
+		img.Pix[o] = uint8(float64(r)*scale + 0.5)
+		img.Pix[o+1] = uint8(float64(g)*scale + 0.5)
+		img.Pix[o+2] = uint8(float64(b)*scale + 0.5)
+		img.Pix[o+3] = 255
+	}
+	return img
+}
+
+// Lookup maps an RGB color on a 0-1 scale through the LUT
+func (l *LUT3D) Lookup(r, g, b float64, interp LUTInterpolation) (float64, float64, float64) {
+	n := l.Size - 1
+	in := [3]float64{r, g, b}
+	var idx [3]int
+	var frac [3]float64
+	for c := 0; c < 3; c++ {
+		v := (in[c] - l.DomainMin[c]) / (l.DomainMax[c] - l.DomainMin[c])
+		v = clamp(v, 0, 1) * float64(n)
+		i := int(v)
+		if i >= n {
+			i = n - 1
+		}
+		idx[c] = i
+		frac[c] = v - float64(i)
+	}
+
+	at := func(dr, dg, db int) [3]float64 {
+		i := (((idx[2]+db)*l.Size+idx[1]+dg)*l.Size + idx[0] + dr) * 3
+		return [3]float64{float64(l.Data[i]), float64(l.Data[i+1]), float64(l.Data[i+2])}
+	}
+
+	var out [3]float64
+	if interp == LUTTrilinear {
+		fr, fg, fb := frac[0], frac[1], frac[2]
+		c000, c100, c010, c110 := at(0, 0, 0), at(1, 0, 0), at(0, 1, 0), at(1, 1, 0)
+		c001, c101, c011, c111 := at(0, 0, 1), at(1, 0, 1), at(0, 1, 1), at(1, 1, 1)
+		for c := 0; c < 3; c++ {
+			c00 := c000[c]*(1-fr) + c100[c]*fr
+			c10 := c010[c]*(1-fr) + c110[c]*fr
+			c01 := c001[c]*(1-fr) + c101[c]*fr
+			c11 := c011[c]*(1-fr) + c111[c]*fr
+			c0 := c00*(1-fg) + c10*fg
+			c1 := c01*(1-fg) + c11*fg
+			out[c] = c0*(1-fb) + c1*fb
+		}
+		return out[0], out[1], out[2]
+	}
+
+	// Tetrahedral: walk from the cell origin to the far corner along the
+	// axes in order of decreasing fraction
+	fr, fg, fb := frac[0], frac[1], frac[2]
+	c000, c111 := at(0, 0, 0), at(1, 1, 1)
+	var v1, v2 [3]float64
+	var w0, w1, w2, w3 float64
+	switch {
+	case fr >= fg && fg >= fb:
+		v1, v2 = at(1, 0, 0), at(1, 1, 0)
+		w0, w1, w2, w3 = 1-fr, fr-fg, fg-fb, fb
+	case fr >= fb && fb >= fg:
+		v1, v2 = at(1, 0, 0), at(1, 0, 1)
+		w0, w1, w2, w3 = 1-fr, fr-fb, fb-fg, fg
+	case fb >= fr && fr >= fg:
+		v1, v2 = at(0, 0, 1), at(1, 0, 1)
+		w0, w1, w2, w3 = 1-fb, fb-fr, fr-fg, fg
+	case fg >= fr && fr >= fb:
+		v1, v2 = at(0, 1, 0), at(1, 1, 0)
+		w0, w1, w2, w3 = 1-fg, fg-fr, fr-fb, fb
+	case fg >= fb && fb >= fr:
+		v1, v2 = at(0, 1, 0), at(0, 1, 1)
+		w0, w1, w2, w3 = 1-fg, fg-fb, fb-fr, fr
+	default:
+		v1, v2 = at(0, 0, 1), at(0, 1, 1)
+		w0, w1, w2, w3 = 1-fb, fb-fg, fg-fr, fr
+	}
+	for c := 0; c < 3; c++ {
+		out[c] = w0*c000[c] + w1*v1[c] + w2*v2[c] + w3*c111[c]
+	}
+	return out[0], out[1], out[2]
+}
+
+// ApplyLUT3D returns a Filter that grades the image through the LUT
+func ApplyLUT3D(lut *LUT3D, interp LUTInterpolation) Filter {
+	return func(img image.Image) image.Image {
+		return applyLUT3D(asRGBA(img), lut, interp)
+	}
+}
+
+func applyLUT3D(img *image.RGBA, lut *LUT3D, interp LUTInterpolation) *image.RGBA {
+	if lut == nil || lut.Size < 2 {
+		return cloneImage(img)
+	}
+	return mapStraightRGB(img, func(r, g, b float64) (float64, float64, float64) {
+		return lut.Lookup(r, g, b, interp)
+	})
+}
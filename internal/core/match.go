@@ -0,0 +1,229 @@
+package core
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// Template matching on luminance
+
+// MatchMethod selects the score computed for each template position
+type MatchMethod int
+
+const (
+	// MatchSSD is the mean squared difference per pixel; lower is better and
+	// 0 is an exact match
+	MatchSSD MatchMethod = iota
+	// MatchNCC is the zero-mean normalized cross-correlation in -1..1; higher
+	// is better and it ignores uniform brightness and contrast changes
+	MatchNCC
+)
+
+// MatchLocation is a template position and its score
+type MatchLocation struct {
+	X, Y  int
+	Score float64
+}
+
+// MatchResult holds the score for every position of the template's top-left
+// corner inside the image
+type MatchResult struct {
+	Width, Height int
+	Scores        []float64
+	Method        MatchMethod
+}
+
+// MatchTemplate slides tmpl over img and scores every position. Window sums
+// come from integral images. The cross-correlation with the template is
+// summed directly for small templates and through the FFT for templates
+// larger than FFTKernelThreshold.
+func MatchTemplate(img, tmpl image.Image, method MatchMethod) (*MatchResult, error) {
+	ib, tb := img.Bounds(), tmpl.Bounds()
+	iw, ih := ib.Dx(), ib.Dy()
+	tw, th := tb.Dx(), tb.Dy()
+	if tw == 0 || th == 0 || tw > iw || th > ih {
+		return nil, NewInvalidParameterError("template", fmt.Sprintf("%dx%d", tw, th), fmt.Sprintf("non-empty and no larger than the %dx%d image", iw, ih))
+	}
+
+	src := luminancePlane(img)
+	t := luminancePlane(tmpl)
+	n := float64(tw * th)
+
+	var tSum, tSq float64
+	for _, v := range t {
+		tSum += v
+		tSq += v * v
+	}
+	tMean := tSum / n
+	tVar := tSq - n*tMean*tMean
+
+	sum, sq := integralImages(src, iw, ih)
+	stride := iw + 1
+	window := func(table []float64, x, y int) float64 {
+		return table[(y+th)*stride+x+tw] - table[y*stride+x+tw] - table[(y+th)*stride+x] + table[y*stride+x]
+	}
+
+	rw, rh := iw-tw+1, ih-th+1
+	cross := templateCross(src, iw, ih, t, tw, th)
+	result := &MatchResult{Width: rw, Height: rh, Scores: make([]float64, rw*rh), Method: method}
+	parallelRows(rh, 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < rw; x++ {
+				c := cross[y*rw+x]
+				s, q := window(sum, x, y), window(sq, x, y)
+
+				var score float64
+				switch method {
+				case MatchNCC:
+					iVar := q - s*s/n
+					denom := math.Sqrt(math.Max(iVar*tVar, 0))
+					if denom > 1e-12 {
+						score = (c - s*tMean) / denom
+					} else if iVar <= 1e-12 && tVar <= 1e-12 {
+						// Two flat patches correlate perfectly
+						score = 1
+					}
+				default:
+					score = math.Max(q-2*c+tSq, 0) / n
+				}
+				result.Scores[y*rw+x] = score
+			}
+		}
+	})
+	return result, nil
+}
+
+// templateCross returns the sum of products of the template and the image
+// window at every position of the template's top-left corner
+func templateCross(src []float64, iw, ih int, t []float64, tw, th int) []float64 {
+	rw, rh := iw-tw+1, ih-th+1
+	cross := make([]float64, rw*rh)
+	if max(tw, th) > FFTKernelThreshold {
+		kernel := make([][]float64, th)
+		for j := range kernel {
+			kernel[j] = t[j*tw : (j+1)*tw]
+		}
+		// correlateFFT centers the kernel, so a window starting at (x, y)
+		// lands on (x+tw/2, y+th/2), which never reaches a clamped edge
+		full := correlateFFT([][]float64{src}, iw, ih, kernel)[0]
+		for y := 0; y < rh; y++ {
+			copy(cross[y*rw:(y+1)*rw], full[(y+th/2)*iw+tw/2:])
+		}
+		return cross
+	}
+
+	parallelRows(rh, 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < rw; x++ {
+				var c float64
+				for j := 0; j < th; j++ {
+					row := src[(y+j)*iw+x : (y+j)*iw+x+tw]
+					trow := t[j*tw : (j+1)*tw]
+					for i, v := range row {
+						c += v * trow[i]
+					}
+				}
+				cross[y*rw+x] = c
+			}
+		}
+	})
+	return cross
+}
+
+// integralImages returns summed-area tables of a plane and of its squares,
+// with an extra leading row and column of zeros
+func integralImages(src []float64, w, h int) ([]float64, []float64) {
+	stride := w + 1
+	sum := make([]float64, stride*(h+1))
+	sq := make([]float64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum, rowSq float64
+		for x := 0; x < w; x++ {
+			v := src[y*w+x]
+			rowSum += v
+			rowSq += v * v
+			sum[(y+1)*stride+x+1] = sum[y*stride+x+1] + rowSum
+			sq[(y+1)*stride+x+1] = sq[y*stride+x+1] + rowSq
+		}
+	}
+	return sum, sq
+}
+
+// At returns the score with the template's top-left corner at (x, y)
+func (r *MatchResult) At(x, y int) float64 {
+	return r.Scores[y*r.Width+x]
+}
+
+// better reports whether score a beats score b for the result's method
+func (r *MatchResult) better(a, b float64) bool {
+	if r.Method == MatchSSD {
+		return a < b
+	}
+	return a > b
+}
+
+// Best returns the best scoring position
+func (r *MatchResult) Best() MatchLocation {
+	best := MatchLocation{Score: r.Scores[0]}
+	for i, s := range r.Scores {
+		if r.better(s, best.Score) {
+			best = MatchLocation{X: i % r.Width, Y: i / r.Width, Score: s}
+		}
+	}
+	return best
+}
+
+// BestN returns up to n of the best positions, best first, keeping only
+// positions at least minDistance pixels apart on both axes so one match is
+// not reported repeatedly at neighboring offsets
+func (r *MatchResult) BestN(n, minDistance int) []MatchLocation {
+	order := make([]int, len(r.Scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return r.better(r.Scores[order[a]], r.Scores[order[b]])
+	})
+
+	var found []MatchLocation
+	for _, i := range order {
+		if len(found) >= n {
+			break
+		}
+		loc := MatchLocation{X: i % r.Width, Y: i / r.Width, Score: r.Scores[i]}
+		separate := true
+		for _, f := range found {
+			if abs(loc.X-f.X) < minDistance && abs(loc.Y-f.Y) < minDistance {
+				separate = false
+				break
+			}
+		}
+		if separate {
+			found = append(found, loc)
+		}
+	}
+	return found
+}
+
+// ScoreImage renders the scores as grayscale with the best matches brightest
+func (r *MatchResult) ScoreImage() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, r.Width, r.Height))
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range r.Scores {
+		lo, hi = math.Min(lo, s), math.Max(hi, s)
+	}
+	span := hi - lo
+	for i, s := range r.Scores {
+		v := 1.0
+		if span > 0 {
+			v = (s - lo) / span
+		}
+		if r.Method == MatchSSD {
+			v = 1 - v
+		}
+		img.Pix[i] = uint8(math.Round(v * 255))
+	}
+	return img
+}
@@ -0,0 +1,507 @@
+package core
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// Image similarity metrics and perceptual hashes
+
+// checkSameSize returns an error unless a and b have the same dimensions
+func checkSameSize(a, b image.Image) error {
+	as, bs := a.Bounds().Size(), b.Bounds().Size()
+	if as != bs {
+		return NewInvalidParameterError("image", fmt.Sprintf("%dx%d", bs.X, bs.Y), fmt.Sprintf("%dx%d to match the reference", as.X, as.Y))
+	}
+	return nil
+}
+
+// ChannelError holds per-channel differences between two images, indexed
+// R, G, B, A, in 0-255 units
+type ChannelError struct {
+	Max  [4]uint8
+	Mean [4]float64
+}
+
+// CompareChannels measures the largest and mean absolute difference of each
+// premultiplied channel
+func CompareChannels(a, b image.Image) (ChannelError, error) {
+	var result ChannelError
+	if err := checkSameSize(a, b); err != nil {
+		return result, err
+	}
+	ra, rb := asRGBA(a), asRGBA(b)
+	ba, bb := ra.Bounds(), rb.Bounds()
+	w, h := ba.Dx(), ba.Dy()
+
+	var sums [4]float64
+	for y := 0; y < h; y++ {
+		ia := ra.PixOffset(ba.Min.X, ba.Min.Y+y)
+		ib := rb.PixOffset(bb.Min.X, bb.Min.Y+y)
+		for x := 0; x < w*4; x++ {
+			pa, pb := ra.Pix[ia+x], rb.Pix[ib+x]
+			d := pa - pb
+			if pb > pa {
+				d = pb - pa
+			}
+			c := x % 4
+			sums[c] += float64(d)
+			if d > result.Max[c] {
+				result.Max[c] = d
+			}
+		}
+	}
+	if n := float64(w * h); n > 0 {
+		for c := range sums {
+			result.Mean[c] = sums[c] / n
+		}
+	}
+	return result, nil
+}
+
+// PSNR returns the peak signal-to-noise ratio in decibels over the
+// premultiplied RGB channels. Identical images give +Inf.
+func PSNR(a, b image.Image) (float64, error) {
+	if err := checkSameSize(a, b); err != nil {
+		return 0, err
+	}
+	ra, rb := asRGBA(a), asRGBA(b)
+	ba, bb := ra.Bounds(), rb.Bounds()
+	w, h := ba.Dx(), ba.Dy()
+	if w == 0 || h == 0 {
+		return math.Inf(1), nil
+	}
+
+	var sum float64
+	for y := 0; y < h; y++ {
+		ia := ra.PixOffset(ba.Min.X, ba.Min.Y+y)
+		ib := rb.PixOffset(bb.Min.X, bb.Min.Y+y)
+		for x := 0; x < w*4; x++ {
+			if x%4 == 3 {
+				continue
+			}
+			d := float64(ra.Pix[ia+x]) - float64(rb.Pix[ib+x])
+			sum += d * d
+		}
+	}
+	mse := sum / float64(w*h*3)
+	if mse == 0 {
+		return math.Inf(1), nil
+	}
+	return 10 * math.Log10(255*255/mse), nil
+}
+
+// SSIM constants for luminance in 0..1
+const (
+	ssimC1 = 0.01 * 0.01
+	ssimC2 = 0.03 * 0.03
+)
+
+// SSIM returns the mean structural similarity of the two images' luminance,
+// using the standard 11x11 Gaussian window with sigma 1.5. The result is 1
+// for identical images.
+func SSIM(a, b image.Image) (float64, error) {
+	if err := checkSameSize(a, b); err != nil {
+		return 0, err
+	}
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	ssim, _ := ssimMeans(luminancePlane(a), luminancePlane(b), w, h)
+	return ssim, nil
+}
+
+// msssimWeights are the per-scale exponents from Wang, Simoncelli and Bovik
+var msssimWeights = []float64{0.0448, 0.2856, 0.3001, 0.2363, 0.1333}
+
+// MSSSIM returns the multi-scale structural similarity over up to five
+// scales, each half the size of the previous. Small images use fewer scales
+// with the weights renormalized.
+func MSSSIM(a, b image.Image) (float64, error) {
+	if err := checkSameSize(a, b); err != nil {
+		return 0, err
+	}
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	pa, pb := luminancePlane(a), luminancePlane(b)
+
+	scales := 1
+	for scales < len(msssimWeights) && min(w, h)>>scales >= 11 {
+		scales++
+	}
+	var total float64
+	for _, wt := range msssimWeights[:scales] {
+		total += wt
+	}
+
+	result := 1.0
+	for s := 0; s < scales; s++ {
+		ssim, cs := ssimMeans(pa, pb, w, h)
+		wt := msssimWeights[s] / total
+		// Negative structure terms would make the product meaningless
+		cs = math.Max(cs, 0)
+		if s == scales-1 {
+			result *= math.Pow(math.Max(ssim, 0), wt)
+		} else {
+			result *= math.Pow(cs, wt)
+		}
+		pa, pb, w, h = halvePlane(pa, w, h), halvePlane(pb, w, h), w/2, h/2
+	}
+	return result, nil
+}
+
+// ssimMeans returns the mean of the SSIM map, the per-pixel product of the
+// luminance and contrast-structure terms, and the mean of the contrast-
+// structure term alone, which MS-SSIM uses at the finer scales
+func ssimMeans(a, b []float64, w, h int) (float64, float64) {
+	n := w * h
+	if n == 0 {
+		return 1, 1
+	}
+	aa := make([]float64, n)
+	bb := make([]float64, n)
+	ab := make([]float64, n)
+	for i := range a {
+		aa[i] = a[i] * a[i]
+		bb[i] = b[i] * b[i]
+		ab[i] = a[i] * b[i]
+	}
+	kernel := gaussianKernel(1.5)
+	muA, muB := gaussianPlane(a, w, h, kernel), gaussianPlane(b, w, h, kernel)
+	sAA, sBB, sAB := gaussianPlane(aa, w, h, kernel), gaussianPlane(bb, w, h, kernel), gaussianPlane(ab, w, h, kernel)
+
+	var ssimSum, csSum float64
+	for i := 0; i < n; i++ {
+		ma, mb := muA[i], muB[i]
+		varA := sAA[i] - ma*ma
+		varB := sBB[i] - mb*mb
+		cov := sAB[i] - ma*mb
+		l := (2*ma*mb + ssimC1) / (ma*ma + mb*mb + ssimC1)
+		cs := (2*cov + ssimC2) / (varA + varB + ssimC2)
+		ssimSum += l * cs
+		csSum += cs
+	}
+	return ssimSum / float64(n), csSum / float64(n)
+}
+
+// gaussianPlane blurs a single-channel plane with a separable kernel,
+// clamping at the edges
+func gaussianPlane(src []float64, w, h int, kernel []float32) []float64 {
+	row := make([]float32, max(w, h))
+	out := make([]float32, max(w, h))
+	tmp := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			row[x] = float32(src[y*w+x])
+		}
+		convolveLine(row[:w], out[:w], kernel, EdgeClamp)
+		for x := 0; x < w; x++ {
+			tmp[y*w+x] = float64(out[x])
+		}
+	}
+	dst := make([]float64, w*h)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			row[y] = float32(tmp[y*w+x])
+		}
+		convolveLine(row[:h], out[:h], kernel, EdgeClamp)
+		for y := 0; y < h; y++ {
+			dst[y*w+x] = float64(out[y])
+		}
+	}
+	return dst
+}
+
+// halvePlane downsamples a plane by averaging 2x2 blocks
+func halvePlane(src []float64, w, h int) []float64 {
+	nw, nh := w/2, h/2
+	dst := make([]float64, nw*nh)
+	for y := 0; y < nh; y++ {
+		for x := 0; x < nw; x++ {
+			i := 2*y*w + 2*x
+			dst[y*nw+x] = (src[i] + src[i+1] + src[i+w] + src[i+w+1]) / 4
+		}
+	}
+	return dst
+}
+
+// DeltaEMap holds the per-pixel CIEDE2000 color difference between two images
+type DeltaEMap struct {
+	Width, Height int
+	Values        []float64
+	Mean, Max     float64
+}
+
+// DeltaE computes the CIEDE2000 difference of every pixel. Pixels are
+// compared composited over both black and white, taking the larger
+// difference, so changes in alpha count as well as changes in color.
+func DeltaE(a, b image.Image) (*DeltaEMap, error) {
+	if err := checkSameSize(a, b); err != nil {
+		return nil, err
+	}
+	ra, rb := asRGBA(a), asRGBA(b)
+	ba, bb := ra.Bounds(), rb.Bounds()
+	w, h := ba.Dx(), ba.Dy()
+	dm := &DeltaEMap{Width: w, Height: h, Values: make([]float64, w*h)}
+
+	parallelRows(h, 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < w; x++ {
+				ia := ra.PixOffset(ba.Min.X+x, ba.Min.Y+y)
+				ib := rb.PixOffset(bb.Min.X+x, bb.Min.Y+y)
+				pa, pb := ra.Pix[ia:ia+4], rb.Pix[ib:ib+4]
+				if pa[0] == pb[0] && pa[1] == pb[1] && pa[2] == pb[2] && pa[3] == pb[3] {
+					continue
+				}
+				d := math.Max(
+					deltaE2000(compositeLAB(pa, 0), compositeLAB(pb, 0)),
+					deltaE2000(compositeLAB(pa, 1), compositeLAB(pb, 1)),
+				)
+				dm.Values[y*w+x] = d
+			}
+		}
+	})
+
+	var sum float64
+	for _, d := range dm.Values {
+		sum += d
+		if d > dm.Max {
+			dm.Max = d
+		}
+	}
+	if len(dm.Values) > 0 {
+		dm.Mean = sum / float64(len(dm.Values))
+	}
+	return dm, nil
+}
+
+// Exceeding counts the pixels whose difference is above threshold
+func (dm *DeltaEMap) Exceeding(threshold float64) int {
+	n := 0
+	for _, d := range dm.Values {
+		if d > threshold {
+			n++
+		}
+	}
+	return n
+}
+
+// Heatmap renders the differences from black (none) through red and yellow
+// to white, reaching white at scale (the map's maximum when not positive)
+func (dm *DeltaEMap) Heatmap(scale float64) *image.RGBA {
+	if scale <= 0 {
+		scale = math.Max(dm.Max, 1e-9)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, dm.Width, dm.Height))
+	for i, d := range dm.Values {
+		t := clamp(d/scale, 0, 1) * 3
+		p := img.Pix[i*4 : i*4+4]
+		p[0] = clampUint8(t * 255)
+		p[1] = clampUint8((t - 1) * 255)
+		p[2] = clampUint8((t - 2) * 255)
+		p[3] = 255
+	}
+	return img
+}
+
+// compositeLAB converts a premultiplied pixel composited over black (bg 0)
+// or white (bg 1) to CIELAB
+func compositeLAB(p []uint8, bg float64) LAB {
+	a := float64(p[3]) / 255
+	return NewColor(
+		float64(p[0])/255+bg*(1-a),
+		float64(p[1])/255+bg*(1-a),
+		float64(p[2])/255+bg*(1-a),
+		1,
+	).ToLAB()
+}
+
+// deltaE2000 implements the CIEDE2000 color difference formula
+func deltaE2000(c1, c2 LAB) float64 {
+	const rad = math.Pi / 180
+	pow25_7 := math.Pow(25, 7)
+
+	cab1 := math.Hypot(c1.A, c1.B)
+	cab2 := math.Hypot(c2.A, c2.B)
+	cabMean7 := math.Pow((cab1+cab2)/2, 7)
+	g := 0.5 * (1 - math.Sqrt(cabMean7/(cabMean7+pow25_7)))
+
+	a1, a2 := (1+g)*c1.A, (1+g)*c2.A
+	cp1, cp2 := math.Hypot(a1, c1.B), math.Hypot(a2, c2.B)
+	hp1, hp2 := hueAngle(a1, c1.B), hueAngle(a2, c2.B)
+
+	dL := c2.L - c1.L
+	dC := cp2 - cp1
+	var dh float64
+	if cp1*cp2 != 0 {
+		dh = hp2 - hp1
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+	}
+	dH := 2 * math.Sqrt(cp1*cp2) * math.Sin(dh/2*rad)
+
+	lMean := (c1.L + c2.L) / 2
+	cMean := (cp1 + cp2) / 2
+	hMean := hp1 + hp2
+	if cp1*cp2 != 0 {
+		if math.Abs(hp1-hp2) > 180 {
+			if hMean < 360 {
+				hMean += 360
+			} else {
+				hMean -= 360
+			}
+		}
+		hMean /= 2
+	}
+
+	t := 1 - 0.17*math.Cos((hMean-30)*rad) + 0.24*math.Cos(2*hMean*rad) +
+		0.32*math.Cos((3*hMean+6)*rad) - 0.20*math.Cos((4*hMean-63)*rad)
+	dTheta := 30 * math.Exp(-math.Pow((hMean-275)/25, 2))
+	cMean7 := math.Pow(cMean, 7)
+	rc := 2 * math.Sqrt(cMean7/(cMean7+pow25_7))
+	l50 := (lMean - 50) * (lMean - 50)
+	sl := 1 + 0.015*l50/math.Sqrt(20+l50)
+	sc := 1 + 0.045*cMean
+	sh := 1 + 0.015*cMean*t
+	rt := -math.Sin(2*dTheta*rad) * rc
+
+	fl, fc, fh := dL/sl, dC/sc, dH/sh
+	return math.Sqrt(fl*fl + fc*fc + fh*fh + rt*fc*fh)
+}
+
+// hueAngle returns atan2(b, a) in degrees in 0..360
+func hueAngle(a, b float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// ImageHash is a 64-bit perceptual fingerprint; similar images have hashes a
+// small Hamming distance apart
+type ImageHash uint64
+
+// Distance returns the number of differing bits between two hashes
+func (h ImageHash) Distance(other ImageHash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// String formats the hash as 16 hex digits
+func (h ImageHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// AverageHash (aHash) sets one bit per cell of an 8x8 thumbnail that is
+// brighter than the thumbnail's mean
+func AverageHash(img image.Image) ImageHash {
+	thumb := grayThumbnail(img, 8, 8)
+	var mean float64
+	for _, v := range thumb {
+		mean += v
+	}
+	mean /= 64
+	var h ImageHash
+	for i, v := range thumb {
+		if v > mean {
+			h |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+// DifferenceHash (dHash) sets one bit per pair of horizontally adjacent
+// cells of a 9x8 thumbnail where brightness increases
+func DifferenceHash(img image.Image) ImageHash {
+	thumb := grayThumbnail(img, 9, 8)
+	var h ImageHash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if thumb[y*9+x+1] > thumb[y*9+x] {
+				h |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return h
+}
+
+// PerceptualHash (pHash) compares the lowest 8x8 DCT frequencies of a 32x32
+// thumbnail against their median, which makes it robust to scaling, mild
+// blur and brightness changes
+func PerceptualHash(img image.Image) ImageHash {
+	const n = 32
+	thumb := grayThumbnail(img, n, n)
+
+	var cosTable [8][n]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < n; x++ {
+			cosTable[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+	// Rows first, keeping only the 8 lowest frequencies
+	var rows [n][8]float64
+	for y := 0; y < n; y++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for x := 0; x < n; x++ {
+				s += thumb[y*n+x] * cosTable[u][x]
+			}
+			rows[y][u] = s
+		}
+	}
+	coeffs := make([]float64, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for y := 0; y < n; y++ {
+				s += rows[y][u] * cosTable[v][y]
+			}
+			coeffs[v*8+u] = s
+		}
+	}
+
+	// The DC term only reflects overall brightness, so leave it out of the
+	// median; the 63 remaining terms have a middle one
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var h ImageHash
+	for i, c := range coeffs {
+		if c > median {
+			h |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+// grayThumbnail downsamples the image's luminance to w x h by area averaging
+func grayThumbnail(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	plane := luminancePlane(img)
+	thumb := make([]float64, w*h)
+	if sw == 0 || sh == 0 {
+		return thumb
+	}
+	for ty := 0; ty < h; ty++ {
+		y0, y1 := ty*sh/h, max((ty+1)*sh/h, ty*sh/h+1)
+		for tx := 0; tx < w; tx++ {
+			x0, x1 := tx*sw/w, max((tx+1)*sw/w, tx*sw/w+1)
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += plane[y*sw+x]
+				}
+			}
+			thumb[ty*w+tx] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return thumb
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+func TestDeltaE2000ReferenceValues(t *testing.T) {
+	// Pairs from Sharma, Wu and Dalal's CIEDE2000 test data
+	cases := []struct {
+		a, b LAB
+		want float64
+	}{
+		{LAB{50, 2.6772, -79.7751}, LAB{50, 0, -82.7485}, 2.0425},
+		{LAB{50, -1.3802, -84.2814}, LAB{50, 0, -82.7485}, 1.0000},
+		{LAB{50, 2.5, 0}, LAB{73, 25, -18}, 27.1492},
+		{LAB{60.2574, -34.0099, 36.2677}, LAB{60.4626, -34.1751, 39.4387}, 1.2644},
+		{LAB{22.7233, 20.0904, -46.694}, LAB{23.0331, 14.973, -42.5619}, 2.0373},
+	}
+	for _, c := range cases {
+		if got := deltaE2000(c.a, c.b); math.Abs(got-c.want) > 1e-3 {
+			t.Errorf("deltaE2000(%v, %v) = %.4f, want %.4f", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestSimilarityMetrics(t *testing.T) {
+	img := gradientImage(64, 64)
+	noisy := copyImageRGBA(img)
+	for i := 0; i < len(noisy.Pix); i += 4 {
+		if (i/4)%7 == 0 {
+			noisy.Pix[i] = clampUint8(float64(noisy.Pix[i]) + 20)
+		}
+	}
+
+	if p, _ := PSNR(img, img); !math.IsInf(p, 1) {
+		t.Errorf("PSNR of identical images = %v, want +Inf", p)
+	}
+	if p, _ := PSNR(img, noisy); p < 25 || p > 50 {
+		t.Errorf("PSNR with sparse noise = %.2f dB, want 25..50", p)
+	}
+	for name, metric := range map[string]func(a, b image.Image) (float64, error){"SSIM": SSIM, "MSSSIM": MSSSIM} {
+		same, _ := metric(img, img)
+		diff, _ := metric(img, noisy)
+		if math.Abs(same-1) > 1e-6 || diff >= same || diff < 0.5 {
+			t.Errorf("%s: identical %.4f, noisy %.4f", name, same, diff)
+		}
+	}
+
+	ce, _ := CompareChannels(img, noisy)
+	if ce.Max[0] != 20 || ce.Max[1] != 0 || ce.Mean[0] == 0 {
+		t.Errorf("CompareChannels = %+v", ce)
+	}
+
+	if _, err := SSIM(img, gradientImage(32, 32)); err == nil {
+		t.Error("expected an error for mismatched sizes")
+	}
+}
+
+func TestSSIMAveragesTheMap(t *testing.T) {
+	// Stripes darkened on the left keep their structure but lose luminance;
+	// inverted on the right they keep luminance but anticorrelate. The map
+	// averages to well below zero, while the product of the two mean terms
+	// would be near zero.
+	a := image.NewGray(image.Rect(0, 0, 64, 32))
+	b := image.NewGray(a.Rect)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(100)
+			if x/2%2 == 0 {
+				v = 155
+			}
+			a.SetGray(x, y, color.Gray{v})
+			if x < 32 {
+				b.SetGray(x, y, color.Gray{v - 90})
+			} else {
+				b.SetGray(x, y, color.Gray{255 - v})
+			}
+		}
+	}
+	if ssim, _ := SSIM(a, b); ssim > -0.1 {
+		t.Errorf("SSIM = %.3f, want the mean of the map, below -0.1", ssim)
+	}
+}
+
+func TestDeltaECountsAlpha(t *testing.T) {
+	a := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	b := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	a.SetRGBA(0, 0, color.RGBA{0, 0, 0, 255})
+	b.SetRGBA(0, 0, color.RGBA{0, 0, 0, 0})
+	a.SetRGBA(1, 0, color.RGBA{10, 20, 30, 255})
+	b.SetRGBA(1, 0, color.RGBA{10, 20, 30, 255})
+
+	dm, err := DeltaE(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dm.Values[0] < 50 || dm.Values[1] != 0 || dm.Exceeding(1) != 1 {
+		t.Errorf("DeltaE values = %v", dm.Values)
+	}
+}
+
+func TestPerceptualHashes(t *testing.T) {
+	img := subjectImage(96, 64, image.Rect(20, 10, 60, 50))
+	scaled := ResizeImage(img, 48, 32)
+	other := subjectImage(96, 64, image.Rect(50, 30, 90, 60))
+
+	for name, hash := range map[string]func(image.Image) ImageHash{
+		"aHash": AverageHash, "dHash": DifferenceHash, "pHash": PerceptualHash,
+	} {
+		h := hash(img)
+		if d := h.Distance(hash(scaled)); d > 6 {
+			t.Errorf("%s: scaled copy is %d bits away", name, d)
+		}
+		if d := h.Distance(hash(other)); d < 8 {
+			t.Errorf("%s: different image is only %d bits away", name, d)
+		}
+	}
+
+	// pHash thresholds the 63 AC terms at their median, so a detailed image
+	// sets exactly 31 of their bits
+	if n := bits.OnesCount64(uint64(PerceptualHash(formatScene(64, 64, false)) &^ 1)); n != 31 {
+		t.Errorf("pHash sets %d AC bits, want 31", n)
+	}
+}
+
+func copyImageRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+	return dst
+}
@@ -0,0 +1,537 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Netpbm images (.pbm, .pgm, .ppm, .pnm, .pam)
+//
+// Plain and raw PBM, PGM and PPM files and PAM files are read. Samples with
+// a maxval above 255 decode to 16-bit images; others to 8-bit images.
+
+// NetpbmFormat selects the Netpbm variant EncodeNetpbm writes
+type NetpbmFormat int
+
+const (
+	// NetpbmAuto writes PGM for grayscale images, PAM for images with
+	// transparency and PPM otherwise
+	NetpbmAuto NetpbmFormat = iota
+	// NetpbmPBM writes a bitmap; pixels darker than mid gray become black
+	NetpbmPBM
+	// NetpbmPGM writes a grayscale image
+	NetpbmPGM
+	// NetpbmPPM writes an RGB image
+	NetpbmPPM
+	// NetpbmPAM writes RGB_ALPHA or GRAYSCALE_ALPHA tuples, or the opaque
+	// tuple types for opaque images
+	NetpbmPAM
+)
+
+// NetpbmOptions configures EncodeNetpbm
+type NetpbmOptions struct {
+	Format NetpbmFormat
+	// Plain writes ASCII samples instead of binary ones. PAM has no plain
+	// variant and ignores it.
+	Plain bool
+}
+
+func netpbmFormatError(details string) *AdvanceError {
+	return NewInvalidFormatError("Netpbm", []string{"pbm", "pgm", "ppm", "pnm", "pam"}).WithContext("details", details)
+}
+
+// LoadNetpbm loads a PBM, PGM, PPM or PAM image
+func LoadNetpbm(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := DecodeNetpbm(file)
+	if err != nil {
+		if ae, ok := err.(*AdvanceError); ok {
+			ae.WithContext("filepath", path)
+		}
+		return nil, err
+	}
+	return img, nil
+}
+
+// SaveNetpbm encodes the image as a Netpbm image and writes it to disk
+func SaveNetpbm(path string, img image.Image, opts *NetpbmOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return EncodeNetpbm(file, img, opts)
+}
+
+// netpbmHeader describes the raster that follows a Netpbm header
+type netpbmHeader struct {
+	magic         byte // '1' to '7'
+	width, height int
+	depth         int
+	maxval        int
+	plain         bool
+}
+
+// readNetpbmToken skips whitespace and comments and returns the next token
+func readNetpbmToken(br *bufio.Reader) (string, error) {
+	var sb strings.Builder
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			if sb.Len() > 0 && err == io.EOF {
+				return sb.String(), nil
+			}
+			return "", err
+		}
+		switch {
+		case c == '#' && sb.Len() == 0:
+			if _, err := br.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f':
+			if sb.Len() > 0 {
+				return sb.String(), nil
+			}
+		default:
+			sb.WriteByte(c)
+			if sb.Len() > 20 {
+				return "", netpbmFormatError("header token too long")
+			}
+		}
+	}
+}
+
+func readNetpbmHeader(br *bufio.Reader) (*netpbmHeader, error) {
+	var magic [2]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil || magic[0] != 'P' || magic[1] < '1' || magic[1] > '7' {
+		return nil, netpbmFormatError("missing P1-P7 signature")
+	}
+	h := &netpbmHeader{magic: magic[1], plain: magic[1] <= '3', depth: 1, maxval: 1}
+	if h.magic == '7' {
+		return readPAMHeader(br, h)
+	}
+
+	fields := 3
+	if h.magic == '1' || h.magic == '4' {
+		fields = 2
+	}
+	values := make([]int, fields)
+	for i := range values {
+		tok, err := readNetpbmToken(br)
+		if err != nil {
+			return nil, netpbmFormatError("truncated header")
+		}
+		if values[i], err = strconv.Atoi(tok); err != nil {
+			return nil, netpbmFormatError(fmt.Sprintf("invalid header value %q", tok))
+		}
+	}
+	// readNetpbmToken consumed the single whitespace byte before the raster
+	h.width, h.height = values[0], values[1]
+	if fields == 3 {
+		h.maxval = values[2]
+	}
+	if h.magic == '3' || h.magic == '6' {
+		h.depth = 3
+	}
+	return h, h.validate()
+}
+
+// readPAMHeader reads the keyword lines of a PAM header up to ENDHDR
+func readPAMHeader(br *bufio.Reader, h *netpbmHeader) (*netpbmHeader, error) {
+	h.width, h.height, h.depth, h.maxval = -1, -1, -1, -1
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, netpbmFormatError("unterminated PAM header")
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			break
+		}
+		if len(fields) < 2 {
+			return nil, netpbmFormatError(fmt.Sprintf("invalid PAM header line %q", strings.TrimSpace(line)))
+		}
+		var dst *int
+		switch fields[0] {
+		case "WIDTH":
+			dst = &h.width
+		case "HEIGHT":
+			dst = &h.height
+		case "DEPTH":
+			dst = &h.depth
+		case "MAXVAL":
+			dst = &h.maxval
+		default:
+			continue // TUPLTYPE is implied by DEPTH
+		}
+		if *dst, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, netpbmFormatError(fmt.Sprintf("invalid PAM header line %q", strings.TrimSpace(line)))
+		}
+	}
+	if h.depth < 1 || h.depth > 4 {
+		return nil, NewUnsupportedOperationError("DecodeNetpbm", fmt.Sprintf("PAM depth %d is not supported", h.depth))
+	}
+	return h, h.validate()
+}
+
+func (h *netpbmHeader) validate() error {
+	if h.width <= 0 || h.height <= 0 {
+		return netpbmFormatError("invalid image size")
+	}
+	if h.maxval < 1 || h.maxval > 65535 {
+		return netpbmFormatError("maxval must be between 1 and 65535")
+	}
+	return nil
+}
+
+// deep reports whether the samples need 16 bits
+func (h *netpbmHeader) deep() bool { return h.maxval > 255 }
+
+func (h *netpbmHeader) colorModel() color.Model {
+	switch {
+	case h.depth == 1 && h.deep():
+		return color.Gray16Model
+	case h.depth == 1:
+		return color.GrayModel
+	case h.depth == 3 && h.deep():
+		return color.RGBA64Model
+	case h.depth == 3:
+		return color.RGBAModel
+	case h.deep():
+		return color.NRGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+// DecodeNetpbmConfig returns the size and color model of a Netpbm image
+// without decoding it
+func DecodeNetpbmConfig(r io.Reader) (image.Config, error) {
+	h, err := readNetpbmHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: h.colorModel(), Width: h.width, Height: h.height}, nil
+}
+
+// DecodeNetpbm reads a PBM, PGM, PPM or PAM image. Bitmaps and grayscale
+// images decode to *image.Gray or *image.Gray16, PPM to *image.RGBA or
+// *image.RGBA64 and PAM images with alpha to *image.NRGBA or *image.NRGBA64.
+func DecodeNetpbm(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readNetpbmHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecodeSize("DecodeNetpbm", h.width, h.height, 0); err != nil {
+		return nil, err
+	}
+
+	samples, err := readNetpbmSamples(br, h)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scale samples to the full 8- or 16-bit range
+	full := 255
+	if h.deep() {
+		full = 65535
+	}
+	if h.magic == '1' || h.magic == '4' {
+		// PBM stores 1 for black
+		for i, v := range samples {
+			samples[i] = uint16((1 - v) * 255)
+		}
+	} else if h.maxval != full {
+		for i, v := range samples {
+			samples[i] = uint16((min(int(v), h.maxval)*full + h.maxval/2) / h.maxval)
+		}
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	var pix []uint8
+	var img image.Image
+	switch h.colorModel() {
+	case color.GrayModel:
+		m := image.NewGray(rect)
+		pix, img = m.Pix, m
+	case color.Gray16Model:
+		m := image.NewGray16(rect)
+		pix, img = m.Pix, m
+	case color.RGBAModel:
+		m := image.NewRGBA(rect)
+		pix, img = m.Pix, m
+	case color.RGBA64Model:
+		m := image.NewRGBA64(rect)
+		pix, img = m.Pix, m
+	case color.NRGBAModel:
+		m := image.NewNRGBA(rect)
+		pix, img = m.Pix, m
+	default:
+		m := image.NewNRGBA64(rect)
+		pix, img = m.Pix, m
+	}
+
+	// Spread the tuples over the gray or RGBA channels of pix
+	channels := 4
+	if h.depth == 1 {
+		channels = 1
+	}
+	size := 1
+	if h.deep() {
+		size = 2
+	}
+	put := func(o int, v uint16) {
+		if size == 2 {
+			pix[o], pix[o+1] = uint8(v>>8), uint8(v)
+		} else {
+			pix[o] = uint8(v)
+		}
+	}
+	for i := 0; i < h.width*h.height; i++ {
+		tuple := samples[i*h.depth : (i+1)*h.depth]
+		o := i * channels * size
+		switch h.depth {
+		case 1:
+			put(o, tuple[0])
+		case 2:
+			for c := 0; c < 3; c++ {
+				put(o+c*size, tuple[0])
+			}
+			put(o+3*size, tuple[1])
+		default:
+			for c := 0; c < 3; c++ {
+				put(o+c*size, tuple[c])
+			}
+			alpha := uint16(full)
+			if h.depth == 4 {
+				alpha = tuple[3]
+			}
+			put(o+3*size, alpha)
+		}
+	}
+	return img, nil
+}
+
+// readNetpbmSamples reads the raster as unscaled samples
+func readNetpbmSamples(br *bufio.Reader, h *netpbmHeader) ([]uint16, error) {
+	n := h.width * h.height * h.depth
+	samples := make([]uint16, n)
+	truncated := netpbmFormatError("truncated pixel data")
+
+	switch {
+	case h.magic == '1':
+		// Plain bitmaps may run their digits together
+		for i := 0; i < n; {
+			c, err := br.ReadByte()
+			if err != nil {
+				return nil, truncated
+			}
+			switch c {
+			case '0', '1':
+				samples[i] = uint16(c - '0')
+				i++
+			case '#':
+				br.ReadString('\n')
+			}
+		}
+	case h.plain:
+		for i := range samples {
+			tok, err := readNetpbmToken(br)
+			if err != nil {
+				return nil, truncated
+			}
+			v, err := strconv.Atoi(tok)
+			if err != nil || v < 0 || v > h.maxval {
+				return nil, netpbmFormatError(fmt.Sprintf("invalid sample %q", tok))
+			}
+			samples[i] = uint16(v)
+		}
+	case h.magic == '4':
+		// Raw bitmap rows are padded to whole bytes
+		row := make([]byte, (h.width+7)/8)
+		for y := 0; y < h.height; y++ {
+			if _, err := io.ReadFull(br, row); err != nil {
+				return nil, truncated
+			}
+			for x := 0; x < h.width; x++ {
+				samples[y*h.width+x] = uint16(row[x/8]>>(7-x%8)) & 1
+			}
+		}
+	default:
+		size := 1
+		if h.deep() {
+			size = 2
+		}
+		raw := make([]byte, n*size)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return nil, truncated
+		}
+		for i := range samples {
+			if size == 2 {
+				samples[i] = uint16(raw[2*i])<<8 | uint16(raw[2*i+1])
+			} else {
+				samples[i] = uint16(raw[i])
+			}
+		}
+	}
+	return samples, nil
+}
+
+// EncodeNetpbm writes img as a Netpbm image. 16-bit and float images are
+// written with a maxval of 65535, everything else with 255. A nil opts
+// picks the format from the image, as NetpbmAuto does.
+func EncodeNetpbm(w io.Writer, img image.Image, opts *NetpbmOptions) error {
+	if opts == nil {
+		opts = &NetpbmOptions{}
+	}
+	model := img.ColorModel()
+	gray := model == color.GrayModel || model == color.Gray16Model
+	format := opts.Format
+	if format == NetpbmAuto {
+		switch {
+		case gray:
+			format = NetpbmPGM
+		case !isOpaqueImage(img):
+			format = NetpbmPAM
+		default:
+			format = NetpbmPPM
+		}
+	}
+	if format < NetpbmAuto || format > NetpbmPAM {
+		return NewInvalidParameterError("Format", format, "a NetpbmFormat")
+	}
+
+	maxval := 255
+	if model == color.Gray16Model || model == color.RGBA64Model || model == color.NRGBA64Model {
+		maxval = 65535
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	plain := opts.Plain && format != NetpbmPAM
+
+	// The tuple of each pixel, unpremultiplied, as samples up to maxval
+	depth := 3
+	alpha := false
+	switch format {
+	case NetpbmPBM:
+		depth, maxval = 1, 1
+	case NetpbmPGM:
+		depth = 1
+	case NetpbmPAM:
+		alpha = !isOpaqueImage(img)
+		if gray {
+			depth = 1
+		}
+		if alpha {
+			depth++
+		}
+	}
+	tuple := func(x, y int, dst []int) {
+		var c color.NRGBA64
+		switch m := img.(type) {
+		case *image.NRGBA:
+			// Straight colors are read directly so translucent pixels keep
+			// their full precision
+			p := m.NRGBAAt(x, y)
+			c = color.NRGBA64{uint16(p.R) * 0x101, uint16(p.G) * 0x101, uint16(p.B) * 0x101, uint16(p.A) * 0x101}
+		case *image.NRGBA64:
+			c = m.NRGBA64At(x, y)
+		default:
+			c = color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+		}
+		if depth == 1 || (depth == 2 && alpha) {
+			lum := color.Gray16Model.Convert(color.NRGBA64{c.R, c.G, c.B, 0xffff}).(color.Gray16).Y
+			switch {
+			case format == NetpbmPBM && lum < 0x8000:
+				dst[0] = 1
+			case format == NetpbmPBM:
+				dst[0] = 0
+			default:
+				dst[0] = int(lum) * maxval / 65535
+			}
+		} else {
+			dst[0] = int(c.R) * maxval / 65535
+			dst[1] = int(c.G) * maxval / 65535
+			dst[2] = int(c.B) * maxval / 65535
+		}
+		if alpha {
+			dst[depth-1] = int(c.A) * maxval / 65535
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	magic := map[NetpbmFormat]int{NetpbmPBM: 4, NetpbmPGM: 5, NetpbmPPM: 6, NetpbmPAM: 7}[format]
+	if plain {
+		magic -= 3
+	}
+	switch {
+	case format == NetpbmPAM:
+		tupleType := map[[2]bool]string{
+			{true, false}: "GRAYSCALE", {true, true}: "GRAYSCALE_ALPHA",
+			{false, false}: "RGB", {false, true}: "RGB_ALPHA",
+		}[[2]bool{gray, alpha}]
+		fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\nTUPLTYPE %s\nENDHDR\n",
+			width, height, depth, maxval, tupleType)
+	case format == NetpbmPBM:
+		fmt.Fprintf(bw, "P%d\n%d %d\n", magic, width, height)
+	default:
+		fmt.Fprintf(bw, "P%d\n%d %d\n%d\n", magic, width, height, maxval)
+	}
+
+	t := make([]int, depth)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		switch {
+		case plain:
+			// Plain rows are broken into lines of at most 70 characters
+			line := 0
+			for x := b.Min.X; x < b.Max.X; x++ {
+				tuple(x, y, t)
+				for _, v := range t {
+					s := strconv.Itoa(v)
+					if line > 0 && line+1+len(s) > 70 {
+						bw.WriteByte('\n')
+						line = 0
+					} else if line > 0 {
+						bw.WriteByte(' ')
+						line++
+					}
+					bw.WriteString(s)
+					line += len(s)
+				}
+			}
+			bw.WriteByte('\n')
+		case format == NetpbmPBM:
+			row := make([]byte, (width+7)/8)
+			for x := 0; x < width; x++ {
+				tuple(b.Min.X+x, y, t)
+				row[x/8] |= byte(t[0]) << (7 - x%8)
+			}
+			bw.Write(row)
+		default:
+			for x := b.Min.X; x < b.Max.X; x++ {
+				tuple(x, y, t)
+				for _, v := range t {
+					if maxval > 255 {
+						bw.WriteByte(byte(v >> 8))
+					}
+					bw.WriteByte(byte(v))
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
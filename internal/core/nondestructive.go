@@ -15,12 +15,25 @@ type EditOperation interface {
 	Clone() EditOperation
 }
 
+// FloatEditOperation is an EditOperation that can also run on float images.
+// Stacks created with NewFloatEditStack use it to avoid rounding to 8 bits
+// between operations.
+type FloatEditOperation interface {
+	EditOperation
+	ApplyFloat(img *RGBAF32) *RGBAF32
+}
+
 // EditStack manages a stack of non-destructive operations
 type EditStack struct {
 	Operations   []EditOperation
 	BaseImage    *image.RGBA
 	CachedResult *image.RGBA
 	CacheDirty   bool
+
+	// FloatBase is the full precision source of stacks created with
+	// NewFloatEditStack; BaseImage then holds its 8-bit rendition
+	FloatBase         *RGBAF32
+	CachedFloatResult *RGBAF32
 }
 
 // NewEditStack creates a new edit stack
@@ -32,6 +45,18 @@ func NewEditStack(baseImage *image.RGBA) *EditStack {
 	}
 }
 
+// NewFloatEditStack creates an edit stack that keeps base at float
+// precision. Results are rounded to 8 bits only once, after the last
+// operation, so long chains of adjustments do not band.
+func NewFloatEditStack(base image.Image) *EditStack {
+	return &EditStack{
+		Operations: make([]EditOperation, 0),
+		BaseImage:  imageToRGBA(base),
+		CacheDirty: true,
+		FloatBase:  ToRGBAF32(base),
+	}
+}
+
 // AddOperation adds an operation to the stack
 func (es *EditStack) AddOperation(op EditOperation) {
 	es.Operations = append(es.Operations, op)
@@ -93,6 +118,10 @@ func (es *EditStack) GetResult() *image.RGBA {
 	if !es.CacheDirty && es.CachedResult != nil {
 		return es.CachedResult
 	}
+	if es.FloatBase != nil {
+		es.GetFloatResult()
+		return es.CachedResult
+	}
 
 	result := cloneImage(es.BaseImage)
 
@@ -101,10 +130,50 @@ func (es *EditStack) GetResult() *image.RGBA {
 	}
 
 	es.CachedResult = result
+	es.CachedFloatResult = nil
 	es.CacheDirty = false
 	return result
 }
 
+// GetFloatResult returns the final image at float precision. Operations
+// without a float implementation are applied to an 8-bit copy.
+func (es *EditStack) GetFloatResult() *RGBAF32 {
+	if !es.CacheDirty && es.CachedFloatResult != nil {
+		return es.CachedFloatResult
+	}
+
+	result := es.applyFloat(len(es.Operations))
+
+	es.CachedFloatResult = result
+	if es.FloatBase != nil {
+		es.CachedResult = imageToRGBA(result)
+	} else {
+		es.CachedResult = nil
+	}
+	es.CacheDirty = false
+	return result
+}
+
+// applyFloat runs the first n operations on a float copy of the base
+func (es *EditStack) applyFloat(n int) *RGBAF32 {
+	var result *RGBAF32
+	if es.FloatBase != nil {
+		result = ToRGBAF32(es.FloatBase)
+	} else {
+		result = ToRGBAF32(es.BaseImage)
+	}
+
+	for _, op := range es.Operations[:n] {
+		if fop, ok := op.(FloatEditOperation); ok {
+			result = fop.ApplyFloat(result)
+		} else {
+			result = ToRGBAF32(op.Apply(imageToRGBA(result)))
+		}
+	}
+
+	return result
+}
+
 // GetPreview returns a preview up to the specified operation index
 func (es *EditStack) GetPreview(upToIndex int) *image.RGBA {
 	if upToIndex < 0 {
@@ -114,6 +183,9 @@ func (es *EditStack) GetPreview(upToIndex int) *image.RGBA {
 	if upToIndex >= len(es.Operations) {
 		return es.GetResult()
 	}
+	if es.FloatBase != nil {
+		return imageToRGBA(es.applyFloat(upToIndex + 1))
+	}
 
 	result := cloneImage(es.BaseImage)
 
@@ -137,6 +209,9 @@ func (es *EditStack) Clone() *EditStack {
 		BaseImage:  cloneImage(es.BaseImage),
 		CacheDirty: true,
 	}
+	if es.FloatBase != nil {
+		clone.FloatBase = ToRGBAF32(es.FloatBase)
+	}
 
 	for i, op := range es.Operations {
 		clone.Operations[i] = op.Clone()
@@ -161,6 +236,13 @@ func (op *BrightnessOperation) Apply(img *image.RGBA) *image.RGBA {
 	})
 }
 
+func (op *BrightnessOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	return mapDeep(img, func(c [4]float32) [4]float32 {
+		f := float32(op.Amount)
+		return [4]float32{max(c[0]*f, 0), max(c[1]*f, 0), max(c[2]*f, 0), c[3]}
+	}).(*RGBAF32)
+}
+
 func (op *BrightnessOperation) GetType() string {
 	return "brightness"
 }
@@ -193,6 +275,17 @@ func (op *ContrastOperation) Apply(img *image.RGBA) *image.RGBA {
 	})
 }
 
+func (op *ContrastOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	const pivot = 128.0 / 255
+	return mapDeep(img, func(c [4]float32) [4]float32 {
+		k := float32(op.Amount)
+		for i := 0; i < 3; i++ {
+			c[i] = max((c[i]-pivot)*k+pivot, 0)
+		}
+		return c
+	}).(*RGBAF32)
+}
+
 func (op *ContrastOperation) GetType() string {
 	return "contrast"
 }
@@ -211,13 +304,25 @@ func (op *ContrastOperation) Clone() EditOperation {
 	return &ContrastOperation{Amount: op.Amount}
 }
 
-// BlurOperation applies blur
+// BlurOperation applies a Gaussian blur. Radius covers two standard
+// deviations; see RadiusToSigma.
 type BlurOperation struct {
 	Radius float64
+	Edge   EdgeMode
 }
 
 func (op *BlurOperation) Apply(img *image.RGBA) *image.RGBA {
-	return SIMDBlur(img, int(op.Radius))
+	if op.Radius <= 0 {
+		return img
+	}
+	return GaussianBlurImage(img, RadiusToSigma(op.Radius), op.Edge)
+}
+
+func (op *BlurOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	if op.Radius <= 0 {
+		return img
+	}
+	return blurDeep(img, BlurOptions{Sigma: RadiusToSigma(op.Radius), Edge: op.Edge}).(*RGBAF32)
 }
 
 func (op *BlurOperation) GetType() string {
@@ -225,17 +330,20 @@ func (op *BlurOperation) GetType() string {
 }
 
 func (op *BlurOperation) GetParameters() map[string]interface{} {
-	return map[string]interface{}{"radius": op.Radius}
+	return map[string]interface{}{"radius": op.Radius, "edge": op.Edge}
 }
 
 func (op *BlurOperation) SetParameters(params map[string]interface{}) {
 	if radius, ok := params["radius"].(float64); ok {
 		op.Radius = radius
 	}
+	if edge, ok := params["edge"].(EdgeMode); ok {
+		op.Edge = edge
+	}
 }
 
 func (op *BlurOperation) Clone() EditOperation {
-	return &BlurOperation{Radius: op.Radius}
+	return &BlurOperation{Radius: op.Radius, Edge: op.Edge}
 }
 
 // CropOperation crops the image
@@ -262,6 +370,16 @@ func (op *CropOperation) Apply(img *image.RGBA) *image.RGBA {
 	return result
 }
 
+func (op *CropOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	cropRect := image.Rect(op.X, op.Y, op.X+op.Width, op.Y+op.Height).Intersect(img.Bounds())
+	result := NewRGBAF32(image.Rect(0, 0, cropRect.Dx(), cropRect.Dy()))
+	for y := cropRect.Min.Y; y < cropRect.Max.Y; y++ {
+		copy(result.Pix[result.PixOffset(0, y-cropRect.Min.Y):result.PixOffset(cropRect.Dx(), y-cropRect.Min.Y)],
+			img.Pix[img.PixOffset(cropRect.Min.X, y):])
+	}
+	return result
+}
+
 func (op *CropOperation) GetType() string {
 	return "crop"
 }
@@ -291,6 +409,333 @@ func (op *CropOperation) Clone() EditOperation {
 	return &CropOperation{X: op.X, Y: op.Y, Width: op.Width, Height: op.Height}
 }
 
+// CurvesOperation applies tone curves
+type CurvesOperation struct {
+	Curves CurveSet
+}
+
+func (op *CurvesOperation) Apply(img *image.RGBA) *image.RGBA {
+	return applyCurves(img, op.Curves)
+}
+
+func (op *CurvesOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	return mapStraightRGBFloat(img, curvesFunc(op.Curves))
+}
+
+func (op *CurvesOperation) GetType() string {
+	return "curves"
+}
+
+func (op *CurvesOperation) GetParameters() map[string]interface{} {
+	return map[string]interface{}{"curves": op.Curves}
+}
+
+func (op *CurvesOperation) SetParameters(params map[string]interface{}) {
+	if curves, ok := params["curves"].(CurveSet); ok {
+		op.Curves = curves
+	}
+}
+
+func (op *CurvesOperation) Clone() EditOperation {
+	return &CurvesOperation{Curves: CurveSet{
+		RGB:   append(SplineCurve(nil), op.Curves.RGB...),
+		Red:   append(SplineCurve(nil), op.Curves.Red...),
+		Green: append(SplineCurve(nil), op.Curves.Green...),
+		Blue:  append(SplineCurve(nil), op.Curves.Blue...),
+	}}
+}
+
+// LevelsOperation applies per-channel levels
+type LevelsOperation struct {
+	Red, Green, Blue LevelsOptions
+}
+
+// NewLevelsOperation creates a levels operation applying opts to every channel
+func NewLevelsOperation(opts LevelsOptions) *LevelsOperation {
+	return &LevelsOperation{Red: opts, Green: opts, Blue: opts}
+}
+
+func (op *LevelsOperation) Apply(img *image.RGBA) *image.RGBA {
+	return applyLevels(img, op.Red, op.Green, op.Blue)
+}
+
+func (op *LevelsOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	return mapStraightRGBFloat(img, levelsFunc(op.Red, op.Green, op.Blue))
+}
+
+func (op *LevelsOperation) GetType() string {
+	return "levels"
+}
+
+func (op *LevelsOperation) GetParameters() map[string]interface{} {
+	return map[string]interface{}{"red": op.Red, "green": op.Green, "blue": op.Blue}
+}
+
+func (op *LevelsOperation) SetParameters(params map[string]interface{}) {
+	if levels, ok := params["levels"].(LevelsOptions); ok {
+		op.Red, op.Green, op.Blue = levels, levels, levels
+	}
+	if red, ok := params["red"].(LevelsOptions); ok {
+		op.Red = red
+	}
+	if green, ok := params["green"].(LevelsOptions); ok {
+		op.Green = green
+	}
+	if blue, ok := params["blue"].(LevelsOptions); ok {
+		op.Blue = blue
+	}
+}
+
+func (op *LevelsOperation) Clone() EditOperation {
+	return &LevelsOperation{Red: op.Red, Green: op.Green, Blue: op.Blue}
+}
+
+// ChannelMixerOperation recombines color channels
+type ChannelMixerOperation struct {
+	Matrix ChannelMatrix
+}
+
+func (op *ChannelMixerOperation) Apply(img *image.RGBA) *image.RGBA {
+	return applyChannelMixer(img, op.Matrix)
+}
+
+func (op *ChannelMixerOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	return mapStraightRGBFloat(img, channelMixerFunc(op.Matrix))
+}
+
+func (op *ChannelMixerOperation) GetType() string {
+	return "channel_mixer"
+}
+
+func (op *ChannelMixerOperation) GetParameters() map[string]interface{} {
+	return map[string]interface{}{"matrix": op.Matrix}
+}
+
+func (op *ChannelMixerOperation) SetParameters(params map[string]interface{}) {
+	if matrix, ok := params["matrix"].(ChannelMatrix); ok {
+		op.Matrix = matrix
+	}
+}
+
+func (op *ChannelMixerOperation) Clone() EditOperation {
+	return &ChannelMixerOperation{Matrix: op.Matrix}
+}
+
+// LUT3DOperation grades the image through a 3D LUT
+type LUT3DOperation struct {
+	LUT           *LUT3D
+	Interpolation LUTInterpolation
+}
+
+func (op *LUT3DOperation) Apply(img *image.RGBA) *image.RGBA {
+	return applyLUT3D(img, op.LUT, op.Interpolation)
+}
+
+func (op *LUT3DOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	if op.LUT == nil || op.LUT.Size < 2 {
+		return img
+	}
+	return mapStraightRGBFloat(img, func(r, g, b float64) (float64, float64, float64) {
+		return op.LUT.Lookup(clamp(r, 0, 1), clamp(g, 0, 1), clamp(b, 0, 1), op.Interpolation)
+	})
+}
+
+func (op *LUT3DOperation) GetType() string {
+	return "lut3d"
+}
+
+func (op *LUT3DOperation) GetParameters() map[string]interface{} {
+	return map[string]interface{}{"lut": op.LUT, "interpolation": op.Interpolation}
+}
+
+func (op *LUT3DOperation) SetParameters(params map[string]interface{}) {
+	if lut, ok := params["lut"].(*LUT3D); ok {
+		op.LUT = lut
+	}
+	if interp, ok := params["interpolation"].(LUTInterpolation); ok {
+		op.Interpolation = interp
+	}
+}
+
+func (op *LUT3DOperation) Clone() EditOperation {
+	// LUTs are treated as immutable once loaded, so the table is shared
+	return &LUT3DOperation{LUT: op.LUT, Interpolation: op.Interpolation}
+}
+
+// WhiteBalanceOperation corrects the color temperature and tint
+type WhiteBalanceOperation struct {
+	Kelvin float64
+	Tint   float64
+}
+
+func (op *WhiteBalanceOperation) Apply(img *image.RGBA) *image.RGBA {
+	return applyWhiteBalance(img, op.Kelvin, op.Tint)
+}
+
+func (op *WhiteBalanceOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	return mapStraightRGBFloat(img, adaptationFunc(adaptationMatrix(WhitePointForTemperature(op.Kelvin, op.Tint))))
+}
+
+func (op *WhiteBalanceOperation) GetType() string {
+	return "white_balance"
+}
+
+func (op *WhiteBalanceOperation) GetParameters() map[string]interface{} {
+	return map[string]interface{}{"kelvin": op.Kelvin, "tint": op.Tint}
+}
+
+func (op *WhiteBalanceOperation) SetParameters(params map[string]interface{}) {
+	if kelvin, ok := params["kelvin"].(float64); ok {
+		op.Kelvin = kelvin
+	}
+	if tint, ok := params["tint"].(float64); ok {
+		op.Tint = tint
+	}
+}
+
+func (op *WhiteBalanceOperation) Clone() EditOperation {
+	return &WhiteBalanceOperation{Kelvin: op.Kelvin, Tint: op.Tint}
+}
+
+// AutoWhiteBalanceOperation neutralizes the estimated scene illuminant
+type AutoWhiteBalanceOperation struct {
+	Method WhiteBalanceMethod
+}
+
+func (op *AutoWhiteBalanceOperation) Apply(img *image.RGBA) *image.RGBA {
+	return applyAutoWhiteBalance(img, op.Method)
+}
+
+func (op *AutoWhiteBalanceOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	// The illuminant estimate does not need more than 8 bits
+	m, ok := autoWhiteBalanceMatrix(imageToRGBA(img), op.Method)
+	if !ok {
+		return img
+	}
+	return mapStraightRGBFloat(img, adaptationFunc(m))
+}
+
+func (op *AutoWhiteBalanceOperation) GetType() string {
+	return "auto_white_balance"
+}
+
+func (op *AutoWhiteBalanceOperation) GetParameters() map[string]interface{} {
+	return map[string]interface{}{"method": op.Method}
+}
+
+func (op *AutoWhiteBalanceOperation) SetParameters(params map[string]interface{}) {
+	if method, ok := params["method"].(WhiteBalanceMethod); ok {
+		op.Method = method
+	}
+}
+
+func (op *AutoWhiteBalanceOperation) Clone() EditOperation {
+	return &AutoWhiteBalanceOperation{Method: op.Method}
+}
+
+// ColorBalanceOperation shifts shadow, midtone and highlight colors
+type ColorBalanceOperation struct {
+	Shadows    [3]float64
+	Midtones   [3]float64
+	Highlights [3]float64
+}
+
+func (op *ColorBalanceOperation) Apply(img *image.RGBA) *image.RGBA {
+	return applyColorBalance(img, op.Shadows, op.Midtones, op.Highlights)
+}
+
+func (op *ColorBalanceOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	return mapStraightRGBFloat(img, colorBalanceFunc(op.Shadows, op.Midtones, op.Highlights))
+}
+
+func (op *ColorBalanceOperation) GetType() string {
+	return "color_balance"
+}
+
+func (op *ColorBalanceOperation) GetParameters() map[string]interface{} {
+	return map[string]interface{}{
+		"shadows": op.Shadows, "midtones": op.Midtones, "highlights": op.Highlights,
+	}
+}
+
+func (op *ColorBalanceOperation) SetParameters(params map[string]interface{}) {
+	if shadows, ok := params["shadows"].([3]float64); ok {
+		op.Shadows = shadows
+	}
+	if midtones, ok := params["midtones"].([3]float64); ok {
+		op.Midtones = midtones
+	}
+	if highlights, ok := params["highlights"].([3]float64); ok {
+		op.Highlights = highlights
+	}
+}
+
+func (op *ColorBalanceOperation) Clone() EditOperation {
+	return &ColorBalanceOperation{Shadows: op.Shadows, Midtones: op.Midtones, Highlights: op.Highlights}
+}
+
+// VibranceOperation boosts the saturation of muted colors
+type VibranceOperation struct {
+	Amount float64
+}
+
+func (op *VibranceOperation) Apply(img *image.RGBA) *image.RGBA {
+	return applyVibrance(img, op.Amount)
+}
+
+func (op *VibranceOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	return mapStraightRGBFloat(img, vibranceFunc(op.Amount))
+}
+
+func (op *VibranceOperation) GetType() string {
+	return "vibrance"
+}
+
+func (op *VibranceOperation) GetParameters() map[string]interface{} {
+	return map[string]interface{}{"amount": op.Amount}
+}
+
+func (op *VibranceOperation) SetParameters(params map[string]interface{}) {
+	if amount, ok := params["amount"].(float64); ok {
+		op.Amount = amount
+	}
+}
+
+func (op *VibranceOperation) Clone() EditOperation {
+	return &VibranceOperation{Amount: op.Amount}
+}
+
+// SelectiveHSLOperation adjusts hue, saturation and lightness per hue range
+type SelectiveHSLOperation struct {
+	Adjustments HSLAdjustments
+}
+
+func (op *SelectiveHSLOperation) Apply(img *image.RGBA) *image.RGBA {
+	return applySelectiveHSL(img, op.Adjustments)
+}
+
+func (op *SelectiveHSLOperation) ApplyFloat(img *RGBAF32) *RGBAF32 {
+	return mapStraightRGBFloat(img, selectiveHSLFunc(op.Adjustments))
+}
+
+func (op *SelectiveHSLOperation) GetType() string {
+	return "selective_hsl"
+}
+
+func (op *SelectiveHSLOperation) GetParameters() map[string]interface{} {
+	return map[string]interface{}{"adjustments": op.Adjustments}
+}
+
+func (op *SelectiveHSLOperation) SetParameters(params map[string]interface{}) {
+	if adjustments, ok := params["adjustments"].(HSLAdjustments); ok {
+		op.Adjustments = adjustments
+	}
+}
+
+func (op *SelectiveHSLOperation) Clone() EditOperation {
+	return &SelectiveHSLOperation{Adjustments: op.Adjustments}
+}
+
 // Helper functions
 
 func cloneImage(img *image.RGBA) *image.RGBA {
@@ -0,0 +1,293 @@
+package core
+
+import "math"
+
+// Perceptual and wide-gamut color spaces
+
+// OKLab represents a color in the OKLab perceptual color space
+type OKLab struct {
+	L, A, B float64 // Lightness (0-1), A (green-red), B (blue-yellow)
+}
+
+// OKLCH represents a color in OKLCH, the polar form of OKLab
+type OKLCH struct {
+	L, C, H float64 // Lightness (0-1), Chroma (0-0.4), Hue (0-360)
+}
+
+// LCh represents a color in CIE LCh(ab), the polar form of LAB
+type LCh struct {
+	L, C, H float64 // Lightness (0-100), Chroma, Hue (0-360)
+}
+
+// LinearRGB represents a color in linear-light sRGB
+type LinearRGB struct {
+	R, G, B float64
+}
+
+// DisplayP3 represents a color in Display P3, encoded with the sRGB curve
+type DisplayP3 struct {
+	R, G, B float64
+}
+
+// Rec2020 represents a color in ITU-R BT.2020, encoded with its curve
+type Rec2020 struct {
+	R, G, B float64
+}
+
+// Linear RGB to and from XYZ (D65) for the wide gamuts; sRGB's matrices
+// live in colorbalance.go
+var (
+	p3ToXYZMatrix = [3][3]float64{
+		{0.4865709486482162, 0.26566769316909306, 0.1982172852343625},
+		{0.2289745640697488, 0.6917385218365064, 0.079286914093745},
+		{0, 0.04511338185890264, 1.043944368900976},
+	}
+	rec2020ToXYZMatrix = [3][3]float64{
+		{0.6369580483012914, 0.14461690358620832, 0.1688809751641721},
+		{0.2627002120112671, 0.6779980715188708, 0.05930171646986196},
+		{0, 0.028072693049087428, 1.060985057710791},
+	}
+	xyzToP3Matrix, _      = invertMatrix3(p3ToXYZMatrix)
+	xyzToRec2020Matrix, _ = invertMatrix3(rec2020ToXYZMatrix)
+)
+
+// RGB to linear sRGB conversion
+func (c Color) ToLinearRGB() LinearRGB {
+	return LinearRGB{R: srgbToLinear(c.R), G: srgbToLinear(c.G), B: srgbToLinear(c.B)}
+}
+
+// Linear sRGB to RGB conversion
+func (l LinearRGB) ToRGB() Color {
+	return Color{
+		R: clamp(linearToSRGB(l.R), 0, 1),
+		G: clamp(linearToSRGB(l.G), 0, 1),
+		B: clamp(linearToSRGB(l.B), 0, 1),
+		A: 1.0,
+	}
+}
+
+// Linear sRGB to OKLab conversion
+func (l LinearRGB) ToOKLab() OKLab {
+	lc := math.Cbrt(0.4122214708*l.R + 0.5363325363*l.G + 0.0514459929*l.B)
+	mc := math.Cbrt(0.2119034982*l.R + 0.6806995451*l.G + 0.1073969566*l.B)
+	sc := math.Cbrt(0.0883024619*l.R + 0.2817188376*l.G + 0.6299787005*l.B)
+	return OKLab{
+		L: 0.2104542553*lc + 0.7936177850*mc - 0.0040720468*sc,
+		A: 1.9779984951*lc - 2.4285922050*mc + 0.4505937099*sc,
+		B: 0.0259040371*lc + 0.7827717662*mc - 0.8086757660*sc,
+	}
+}
+
+// OKLab to linear sRGB conversion; the result is unclamped
+func (lab OKLab) ToLinearRGB() LinearRGB {
+	lc := lab.L + 0.3963377774*lab.A + 0.2158037573*lab.B
+	mc := lab.L - 0.1055613458*lab.A - 0.0638541728*lab.B
+	sc := lab.L - 0.0894841775*lab.A - 1.2914855480*lab.B
+	l, m, s := lc*lc*lc, mc*mc*mc, sc*sc*sc
+	return LinearRGB{
+		R: 4.0767416621*l - 3.3077115913*m + 0.2309699292*s,
+		G: -1.2684380046*l + 2.6097574011*m - 0.3413193965*s,
+		B: -0.0041960863*l - 0.7034186147*m + 1.7076147010*s,
+	}
+}
+
+// RGB to OKLab conversion
+func (c Color) ToOKLab() OKLab {
+	return c.ToLinearRGB().ToOKLab()
+}
+
+// OKLab to RGB conversion, mapping out-of-gamut colors into sRGB
+func (lab OKLab) ToRGB() Color {
+	return lab.ToOKLCH().ToRGB()
+}
+
+// OKLab to OKLCH conversion
+func (lab OKLab) ToOKLCH() OKLCH {
+	return OKLCH{L: lab.L, C: math.Hypot(lab.A, lab.B), H: hueAngle(lab.A, lab.B)}
+}
+
+// OKLCH to OKLab conversion
+func (lch OKLCH) ToOKLab() OKLab {
+	sin, cos := math.Sincos(lch.H * math.Pi / 180)
+	return OKLab{L: lch.L, A: lch.C * cos, B: lch.C * sin}
+}
+
+// RGB to OKLCH conversion
+func (c Color) ToOKLCH() OKLCH {
+	return c.ToOKLab().ToOKLCH()
+}
+
+// OKLCH to RGB conversion, reducing chroma until the color fits sRGB
+func (lch OKLCH) ToRGB() Color {
+	return lch.MapToGamut().ToOKLab().ToLinearRGB().ToRGB()
+}
+
+// InGamut reports whether the color is displayable in sRGB
+func (lch OKLCH) InGamut() bool {
+	return lch.ToOKLab().ToLinearRGB().inGamut()
+}
+
+// MapToGamut brings the color into sRGB by lowering its chroma at constant
+// lightness and hue, following the CSS Color 4 algorithm: the result is the
+// most saturated color whose clipped form is within a just noticeable
+// difference of it.
+func (lch OKLCH) MapToGamut() OKLCH {
+	switch {
+	case lch.L >= 1:
+		return OKLCH{L: 1, H: lch.H}
+	case lch.L <= 0:
+		return OKLCH{H: lch.H}
+	case lch.InGamut():
+		return lch
+	}
+
+	const jnd, epsilon = 0.02, 0.0001
+	clip := func(c OKLCH) OKLab {
+		return c.ToOKLab().ToLinearRGB().clip().ToOKLab()
+	}
+	current := lch
+	clipped := clip(current)
+	if DeltaEOK(clipped, current.ToOKLab()) < jnd {
+		return clipped.ToOKLCH()
+	}
+	lo, hi := 0.0, lch.C
+	loInGamut := true
+	for hi-lo > epsilon {
+		current.C = (lo + hi) / 2
+		if loInGamut && current.InGamut() {
+			lo = current.C
+			continue
+		}
+		clipped = clip(current)
+		e := DeltaEOK(clipped, current.ToOKLab())
+		if e >= jnd {
+			hi = current.C
+			continue
+		}
+		if jnd-e < epsilon {
+			break
+		}
+		loInGamut = false
+		lo = current.C
+	}
+	return clipped.ToOKLCH()
+}
+
+// inGamut reports whether every channel lies in 0..1, allowing for
+// rounding error
+func (l LinearRGB) inGamut() bool {
+	const eps = 1e-6
+	return l.R >= -eps && l.R <= 1+eps && l.G >= -eps && l.G <= 1+eps && l.B >= -eps && l.B <= 1+eps
+}
+
+// clip clamps every channel to 0..1
+func (l LinearRGB) clip() LinearRGB {
+	return LinearRGB{R: clamp(l.R, 0, 1), G: clamp(l.G, 0, 1), B: clamp(l.B, 0, 1)}
+}
+
+// toSRGB encodes linear sRGB, mapping out-of-gamut colors through OKLCH
+func (l LinearRGB) toSRGB() Color {
+	if !l.inGamut() {
+		return l.ToOKLab().ToOKLCH().ToRGB()
+	}
+	return l.ToRGB()
+}
+
+// LAB to LCh conversion
+func (lab LAB) ToLCh() LCh {
+	return LCh{L: lab.L, C: math.Hypot(lab.A, lab.B), H: hueAngle(lab.A, lab.B)}
+}
+
+// LCh to LAB conversion
+func (lch LCh) ToLAB() LAB {
+	sin, cos := math.Sincos(lch.H * math.Pi / 180)
+	return LAB{L: lch.L, A: lch.C * cos, B: lch.C * sin}
+}
+
+// RGB to LCh conversion
+func (c Color) ToLCh() LCh {
+	return c.ToLAB().ToLCh()
+}
+
+// LCh to RGB conversion
+func (lch LCh) ToRGB() Color {
+	return lch.ToLAB().ToRGB()
+}
+
+// RGB to Display P3 conversion
+func (c Color) ToDisplayP3() DisplayP3 {
+	r, g, b := convertGamut(c.ToLinearRGB(), srgbToXYZMatrix, xyzToP3Matrix)
+	return DisplayP3{R: signedSRGBEncode(r), G: signedSRGBEncode(g), B: signedSRGBEncode(b)}
+}
+
+// Display P3 to RGB conversion, mapping colors outside sRGB through OKLCH
+func (p DisplayP3) ToRGB() Color {
+	lin := LinearRGB{R: signedSRGBDecode(p.R), G: signedSRGBDecode(p.G), B: signedSRGBDecode(p.B)}
+	r, g, b := convertGamut(lin, p3ToXYZMatrix, xyzToSRGBMatrix)
+	return LinearRGB{R: r, G: g, B: b}.toSRGB()
+}
+
+// RGB to Rec.2020 conversion
+func (c Color) ToRec2020() Rec2020 {
+	r, g, b := convertGamut(c.ToLinearRGB(), srgbToXYZMatrix, xyzToRec2020Matrix)
+	return Rec2020{R: rec2020Encode(r), G: rec2020Encode(g), B: rec2020Encode(b)}
+}
+
+// Rec.2020 to RGB conversion, mapping colors outside sRGB through OKLCH
+func (p Rec2020) ToRGB() Color {
+	lin := LinearRGB{R: rec2020Decode(p.R), G: rec2020Decode(p.G), B: rec2020Decode(p.B)}
+	r, g, b := convertGamut(lin, rec2020ToXYZMatrix, xyzToSRGBMatrix)
+	return LinearRGB{R: r, G: g, B: b}.toSRGB()
+}
+
+// convertGamut moves linear RGB between gamuts through XYZ
+func convertGamut(l LinearRGB, toXYZ, fromXYZ [3][3]float64) (r, g, b float64) {
+	out := mulMatrix3(fromXYZ, mulMatrix3(toXYZ, [3]float64{l.R, l.G, l.B}))
+	return out[0], out[1], out[2]
+}
+
+// signedSRGBEncode applies the sRGB curve, mirrored for negative values
+func signedSRGBEncode(v float64) float64 {
+	if v < 0 {
+		return -linearToSRGB(-v)
+	}
+	return linearToSRGB(v)
+}
+
+// signedSRGBDecode inverts the sRGB curve, mirrored for negative values
+func signedSRGBDecode(v float64) float64 {
+	if v < 0 {
+		return -srgbToLinear(-v)
+	}
+	return srgbToLinear(v)
+}
+
+// BT.2020 transfer function constants
+const (
+	rec2020Alpha = 1.09929682680944
+	rec2020Beta  = 0.018053968510807
+)
+
+// rec2020Encode applies the BT.2020 transfer function
+func rec2020Encode(v float64) float64 {
+	sign := 1.0
+	if v < 0 {
+		sign, v = -1, -v
+	}
+	if v < rec2020Beta {
+		return sign * 4.5 * v
+	}
+	return sign * (rec2020Alpha*math.Pow(v, 0.45) - (rec2020Alpha - 1))
+}
+
+// rec2020Decode inverts the BT.2020 transfer function
+func rec2020Decode(v float64) float64 {
+	sign := 1.0
+	if v < 0 {
+		sign, v = -1, -v
+	}
+	if v < rec2020Beta*4.5 {
+		return sign * v / 4.5
+	}
+	return sign * math.Pow((v+rec2020Alpha-1)/rec2020Alpha, 1/0.45)
+}
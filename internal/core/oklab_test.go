@@ -0,0 +1,104 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOKLabReference(t *testing.T) {
+	cases := []struct {
+		c    Color
+		want OKLab
+	}{
+		{NewColor(1, 1, 1, 1), OKLab{1, 0, 0}},
+		{NewColor(1, 0, 0, 1), OKLab{0.62796, 0.22486, 0.12585}},
+		{NewColor(0, 0, 1, 1), OKLab{0.45201, -0.03246, -0.31153}},
+	}
+	for _, tc := range cases {
+		got := tc.c.ToOKLab()
+		if DeltaEOK(got, tc.want) > 1e-3 {
+			t.Errorf("%v -> %v, want %v", tc.c, got, tc.want)
+		}
+		back := got.ToRGB()
+		if math.Abs(back.R-tc.c.R)+math.Abs(back.G-tc.c.G)+math.Abs(back.B-tc.c.B) > 1e-4 {
+			t.Errorf("%v does not round trip, got %v", tc.c, back)
+		}
+	}
+
+	lch := NewColor(0.2, 0.6, 0.4, 1).ToOKLCH()
+	if back := lch.ToRGB(); math.Abs(back.G-0.6) > 1e-6 {
+		t.Errorf("OKLCH round trip gave %v", back)
+	}
+	if h := NewColor(0.5, 0.5, 0.5, 1).ToLCh(); h.C > 1e-3 {
+		t.Errorf("gray should have no chroma, got %v", h)
+	}
+}
+
+func TestWideGamutConversions(t *testing.T) {
+	red := NewColor(1, 0, 0, 1)
+	p3 := red.ToDisplayP3()
+	if math.Abs(p3.R-0.9176) > 1e-3 || math.Abs(p3.G-0.2003) > 1e-3 || math.Abs(p3.B-0.1386) > 1e-3 {
+		t.Errorf("sRGB red in P3 = %v", p3)
+	}
+	if back := p3.ToRGB(); math.Abs(back.R-1) > 1e-4 || back.G > 1e-4 {
+		t.Errorf("P3 round trip gave %v", back)
+	}
+	bt := red.ToRec2020()
+	if math.Abs(bt.R-0.7919) > 1e-3 || math.Abs(bt.G-0.2310) > 1e-3 || math.Abs(bt.B-0.0739) > 1e-3 {
+		t.Errorf("sRGB red in Rec.2020 = %v", bt)
+	}
+	if back := bt.ToRGB(); math.Abs(back.R-1) > 1e-4 || back.B > 1e-4 {
+		t.Errorf("Rec.2020 round trip gave %v", back)
+	}
+
+	// P3 green lies outside sRGB: it maps in with its hue kept
+	green := DisplayP3{0, 1, 0}.ToRGB()
+	if green.G < 0.9 || green.R > 0.3 || green.B > 0.3 {
+		t.Errorf("P3 green mapped to %v", green)
+	}
+}
+
+func TestGamutMapping(t *testing.T) {
+	vivid := OKLCH{L: 0.7, C: 0.35, H: 150}
+	if vivid.InGamut() {
+		t.Fatal("test color should be outside sRGB")
+	}
+	mapped := vivid.MapToGamut()
+	if mapped.C >= vivid.C || math.Abs(mapped.L-vivid.L) > 0.02 {
+		t.Errorf("mapped to %v", mapped)
+	}
+	if d := math.Abs(mapped.H - vivid.H); d > 3 {
+		t.Errorf("hue drifted by %.2f", d)
+	}
+	if lch := NewColor(0.3, 0.5, 0.7, 1).ToOKLCH(); lch.MapToGamut() != lch {
+		t.Error("in-gamut colors should be left alone")
+	}
+
+	dc := NewContext(1, 1)
+	dc.SetOKLCH(0.62796, 0.25768, 29.23)
+	dc.Clear()
+	if c := dc.Image().At(0, 0); maxChannelDiff(c, NewColor(1, 0, 0, 1)) > 1 {
+		t.Errorf("SetOKLCH red = %v", c)
+	}
+}
+
+func TestColorDifferences(t *testing.T) {
+	a, b := LAB{50, 0, 0}, LAB{53, 4, 0}
+	if d := DeltaE76(a, b); math.Abs(d-5) > 1e-9 {
+		t.Errorf("DeltaE76 = %v", d)
+	}
+	// Reference pair 1 of Sharma, Wu and Dalal's CIEDE2000 test data
+	p, q := LAB{50, 2.6772, -79.7751}, LAB{50, 0, -82.7485}
+	if d := DeltaE2000(p, q); math.Abs(d-2.0425) > 1e-4 {
+		t.Errorf("DeltaE2000 = %v", d)
+	}
+	if d := DeltaE94(p, q); math.Abs(d-1.3950) > 1e-3 {
+		t.Errorf("DeltaE94 = %v", d)
+	}
+	if d := DeltaE94(a, a); d != 0 {
+		t.Errorf("DeltaE94 of equal colors = %v", d)
+	}
+	if d := DeltaEOK(OKLab{0.5, 0, 0}, OKLab{0.5, 0.03, 0.04}); math.Abs(d-0.05) > 1e-9 {
+		t.Errorf("DeltaEOK = %v", d)
+	}
+}
@@ -0,0 +1,328 @@
+package core
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Named color palettes, harmonies and swatch sheets
+
+// Swatch is a named palette color
+type Swatch struct {
+	Name  string
+	Color Color
+}
+
+// Palette is an ordered list of named colors
+type Palette struct {
+	Name     string
+	Swatches []Swatch
+}
+
+// NewPalette creates a palette from unnamed colors
+func NewPalette(name string, colors ...Color) *Palette {
+	p := &Palette{Name: name}
+	for _, c := range colors {
+		p.Add("", c)
+	}
+	return p
+}
+
+// PaletteFromColors creates a palette from a standard library palette,
+// such as the result of BuildPalette
+func PaletteFromColors(name string, colors color.Palette) *Palette {
+	p := &Palette{Name: name}
+	for _, c := range colors {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		p.Add("", NewColorFromRGBA255(n.R, n.G, n.B, n.A))
+	}
+	return p
+}
+
+// Add appends a swatch
+func (p *Palette) Add(name string, c Color) {
+	p.Swatches = append(p.Swatches, Swatch{Name: name, Color: c})
+}
+
+// Len returns the number of swatches
+func (p *Palette) Len() int {
+	return len(p.Swatches)
+}
+
+// Colors returns the swatch colors in order
+func (p *Palette) Colors() []Color {
+	colors := make([]Color, len(p.Swatches))
+	for i, s := range p.Swatches {
+		colors[i] = s.Color
+	}
+	return colors
+}
+
+// ColorPalette converts the palette for use with Dither and image.Paletted
+func (p *Palette) ColorPalette() color.Palette {
+	palette := make(color.Palette, len(p.Swatches))
+	for i, s := range p.Swatches {
+		palette[i] = s.Color.nrgba()
+	}
+	return palette
+}
+
+// Nearest returns the index of the swatch closest to c by CIEDE2000, or
+// -1 for an empty palette
+func (p *Palette) Nearest(c Color) int {
+	lab := c.ToLAB()
+	best, bestDist := -1, math.Inf(1)
+	for i, s := range p.Swatches {
+		if d := deltaE2000(lab, s.Color.ToLAB()); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// Snap returns the palette color closest to c, keeping c's alpha
+func (p *Palette) Snap(c Color) Color {
+	i := p.Nearest(c)
+	if i < 0 {
+		return c
+	}
+	snapped := p.Swatches[i].Color
+	snapped.A = c.A
+	return snapped
+}
+
+// SnapImage replaces every pixel with its nearest palette color, keeping
+// alpha. Unlike Dither it matches colors perceptually.
+func (p *Palette) SnapImage(img image.Image) *image.RGBA {
+	src := asRGBA(img)
+	b := src.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	if len(p.Swatches) == 0 {
+		draw.Draw(result, result.Bounds(), src, b.Min, draw.Src)
+		return result
+	}
+	labs := make([]LAB, len(p.Swatches))
+	for i, s := range p.Swatches {
+		labs[i] = s.Color.ToLAB()
+	}
+	parallelRows(b.Dy(), 0, func(start, end int) {
+		cache := make(map[[3]uint8]int)
+		for y := start; y < end; y++ {
+			for x := 0; x < b.Dx(); x++ {
+				r, g, bl, a := straightAt(src, b.Min.X+x, b.Min.Y+y)
+				if a == 0 {
+					continue
+				}
+				key := [3]uint8{r, g, bl}
+				idx, ok := cache[key]
+				if !ok {
+					lab := NewColorFromRGBA255(r, g, bl, 255).ToLAB()
+					best := math.Inf(1)
+					for i, l := range labs {
+						if d := deltaE2000(lab, l); d < best {
+							idx, best = i, d
+						}
+					}
+					cache[key] = idx
+				}
+				c := p.Swatches[idx].Color
+				alpha := float64(a)
+				i := result.PixOffset(x, y)
+				setPremultiplied(result.Pix[i:i+4], c.R*alpha, c.G*alpha, c.B*alpha, alpha)
+			}
+		}
+	})
+	return result
+}
+
+// nrgba converts to an 8-bit straight-alpha color, rounding
+func (c Color) nrgba() color.NRGBA {
+	return color.NRGBA{
+		R: clampUint8(c.R*255 + 0.5),
+		G: clampUint8(c.G*255 + 0.5),
+		B: clampUint8(c.B*255 + 0.5),
+		A: clampUint8(c.A*255 + 0.5),
+	}
+}
+
+// Hex formats the color as #rrggbb, or #rrggbbaa when not opaque
+func (c Color) Hex() string {
+	n := c.nrgba()
+	if n.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
+
+// Harmonies
+
+// HarmonyScheme selects the hue relationships of a generated palette
+type HarmonyScheme int
+
+const (
+	// HarmonyComplementary pairs the base with the opposite hue
+	HarmonyComplementary HarmonyScheme = iota
+	// HarmonySplitComplementary adds the two hues beside the complement
+	HarmonySplitComplementary
+	// HarmonyTriadic spaces three hues 120° apart
+	HarmonyTriadic
+	// HarmonyTetradic spaces four hues 90° apart
+	HarmonyTetradic
+	// HarmonyAnalogous adds the neighboring hues 30° either side
+	HarmonyAnalogous
+)
+
+// HarmonySpace selects the polar color space hues are rotated in
+type HarmonySpace int
+
+const (
+	// HarmonyOKLCH rotates hue in OKLCH, reducing chroma to stay in gamut
+	HarmonyOKLCH HarmonySpace = iota
+	// HarmonyLCh rotates hue in CIE LCh(ab)
+	HarmonyLCh
+)
+
+// harmonyOffsets lists the hue offsets of each scheme, base first
+var harmonyOffsets = map[HarmonyScheme][]float64{
+	HarmonyComplementary:      {0, 180},
+	HarmonySplitComplementary: {0, 150, 210},
+	HarmonyTriadic:            {0, 120, 240},
+	HarmonyTetradic:           {0, 90, 180, 270},
+	HarmonyAnalogous:          {-30, 0, 30},
+}
+
+// Harmony builds a palette of colors related to base by hue, keeping its
+// lightness and chroma
+func Harmony(base Color, scheme HarmonyScheme, space HarmonySpace) *Palette {
+	offsets, ok := harmonyOffsets[scheme]
+	if !ok {
+		offsets = []float64{0}
+	}
+	p := &Palette{}
+	for _, offset := range offsets {
+		c := rotateHue(base, offset, space)
+		c.A = base.A
+		p.Add("", c)
+	}
+	return p
+}
+
+// rotateHue turns the hue of c by degrees in the given space
+func rotateHue(c Color, degrees float64, space HarmonySpace) Color {
+	if space == HarmonyLCh {
+		lch := c.ToLCh()
+		lch.H = math.Mod(lch.H+degrees+360, 360)
+		return lch.ToRGB()
+	}
+	lch := c.ToOKLCH()
+	lch.H = math.Mod(lch.H+degrees+360, 360)
+	return lch.ToRGB()
+}
+
+// Ramp builds a lightness ramp around base: tints lighter steps, then base,
+// then shades darker steps. Chroma tapers toward white and black so the
+// ends stay in gamut.
+func Ramp(base Color, tints, shades int, space HarmonySpace) *Palette {
+	// Lightness runs 0..1 in both spaces here; LCh is rescaled on output
+	var l, chroma, hue float64
+	if space == HarmonyLCh {
+		lch := base.ToLCh()
+		l, chroma, hue = lch.L/100, lch.C, lch.H
+	} else {
+		lch := base.ToOKLCH()
+		l, chroma, hue = lch.L, lch.C, lch.H
+	}
+	step := func(t, target float64) Color {
+		nl := l + (target-l)*t
+		nc := chroma * (1 - t)
+		var c Color
+		if space == HarmonyLCh {
+			c = LCh{L: nl * 100, C: nc, H: hue}.ToRGB()
+		} else {
+			c = OKLCH{L: nl, C: nc, H: hue}.ToRGB()
+		}
+		c.A = base.A
+		return c
+	}
+
+	p := &Palette{}
+	// Stop short of pure white and black, which every ramp would share
+	const reach = 0.9
+	for i := tints; i >= 1; i-- {
+		p.Add("", step(reach*float64(i)/float64(tints), 1))
+	}
+	p.Add("", base)
+	for i := 1; i <= shades; i++ {
+		p.Add("", step(reach*float64(i)/float64(shades), 0))
+	}
+	return p
+}
+
+// Swatch sheets
+
+// SwatchSheetOptions configures SwatchSheet
+type SwatchSheetOptions struct {
+	// Columns per row; 8 when zero
+	Columns int
+	// CellSize is the side of each color square in pixels; 64 when zero
+	CellSize int
+	// Labels prints each swatch's name and hex value under it
+	Labels bool
+	// Background fills the sheet; white when nil
+	Background color.Color
+}
+
+// SwatchSheet renders the palette as a grid of color squares
+func (p *Palette) SwatchSheet(opts SwatchSheetOptions) *image.RGBA {
+	columns, cell := opts.Columns, opts.CellSize
+	if columns <= 0 {
+		columns = 8
+	}
+	if cell <= 0 {
+		cell = 64
+	}
+	columns = max(1, min(columns, len(p.Swatches)))
+	rows := (len(p.Swatches) + columns - 1) / columns
+	gap := cell / 8
+	labelHeight := 0
+	if opts.Labels {
+		labelHeight = 30
+	}
+	rowHeight := cell + labelHeight + gap
+	width := columns*(cell+gap) + gap
+	height := rows*rowHeight + gap
+
+	dc := NewContext(width, height)
+	var bg color.Color = color.White
+	if opts.Background != nil {
+		bg = opts.Background
+	}
+	dc.SetColor(bg)
+	dc.Clear()
+
+	// Labels sit on the background, so pick the readable ink for it
+	r, g, b, _ := bg.RGBA()
+	ink := color.Color(color.Black)
+	if 0.299*float64(r)+0.587*float64(g)+0.114*float64(b) < 0.5*65535 {
+		ink = color.White
+	}
+
+	for i, s := range p.Swatches {
+		x := float64(gap + (i%columns)*(cell+gap))
+		y := float64(gap + (i/columns)*rowHeight)
+		dc.SetColor(s.Color.nrgba())
+		dc.DrawRectangle(x, y, float64(cell), float64(cell))
+		dc.Fill()
+		if opts.Labels {
+			dc.SetColor(ink)
+			if s.Name != "" {
+				dc.DrawString(s.Name, x, y+float64(cell)+13)
+			}
+			dc.DrawString(s.Color.Hex(), x, y+float64(cell)+27)
+		}
+	}
+	return dc.im
+}
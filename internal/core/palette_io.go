@@ -0,0 +1,557 @@
+package core
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Palette file formats
+
+// PaletteFormat identifies a palette file format
+type PaletteFormat int
+
+const (
+	// PaletteASE is Adobe Swatch Exchange (.ase)
+	PaletteASE PaletteFormat = iota
+	// PaletteGPL is a GIMP palette (.gpl)
+	PaletteGPL
+	// PaletteJSON is Procreate's swatches JSON; .swatches archives holding
+	// it are read too
+	PaletteJSON
+	// PaletteCSS is a list of CSS custom properties (.css)
+	PaletteCSS
+)
+
+var paletteExtensions = map[string]PaletteFormat{
+	".ase":      PaletteASE,
+	".gpl":      PaletteGPL,
+	".json":     PaletteJSON,
+	".swatches": PaletteJSON,
+	".css":      PaletteCSS,
+}
+
+// paletteFormatFor picks the format from a file extension
+func paletteFormatFor(path string) (PaletteFormat, error) {
+	format, ok := paletteExtensions[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return 0, NewInvalidFormatError(path, []string{"ase", "gpl", "json", "swatches", "css"})
+	}
+	return format, nil
+}
+
+// LoadPalette loads a palette, choosing the format from the file extension
+func LoadPalette(path string) (*Palette, error) {
+	format, err := paletteFormatFor(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	p, err := DecodePalette(file, format)
+	if err != nil {
+		if ae, ok := err.(*AdvanceError); ok {
+			ae.WithContext("filepath", path)
+		}
+		return nil, err
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return p, nil
+}
+
+// Save writes the palette, choosing the format from the file extension.
+// A .swatches path gets plain JSON.
+func (p *Palette) Save(path string) error {
+	format, err := paletteFormatFor(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return p.Encode(file, format)
+}
+
+// DecodePalette reads a palette in the given format
+func DecodePalette(r io.Reader, format PaletteFormat) (*Palette, error) {
+	switch format {
+	case PaletteASE:
+		return decodeASE(r)
+	case PaletteGPL:
+		return decodeGPL(r)
+	case PaletteJSON:
+		return decodeSwatchesJSON(r)
+	case PaletteCSS:
+		return decodeCSSPalette(r)
+	}
+	return nil, NewInvalidParameterError("format", format, "a PaletteFormat")
+}
+
+// Encode writes the palette in the given format
+func (p *Palette) Encode(w io.Writer, format PaletteFormat) error {
+	switch format {
+	case PaletteASE:
+		return p.encodeASE(w)
+	case PaletteGPL:
+		return p.encodeGPL(w)
+	case PaletteJSON:
+		return p.encodeSwatchesJSON(w)
+	case PaletteCSS:
+		return p.encodeCSS(w)
+	}
+	return NewInvalidParameterError("format", format, "a PaletteFormat")
+}
+
+func paletteFormatError(format, details string) error {
+	return NewInvalidFormatError(format+" palette", []string{format}).
+		WithContext("details", details)
+}
+
+// Adobe Swatch Exchange
+
+const (
+	aseGroupStart = 0xC001
+	aseGroupEnd   = 0xC002
+	aseColor      = 0x0001
+)
+
+func decodeASE(r io.Reader) (*Palette, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "ASEF" {
+		return nil, paletteFormatError("ase", "missing ASEF signature")
+	}
+	be := binary.BigEndian
+	count := be.Uint32(data[8:])
+	pos := 12
+	p := &Palette{}
+	for i := uint32(0); i < count; i++ {
+		if pos+6 > len(data) {
+			return nil, paletteFormatError("ase", "truncated block header")
+		}
+		kind, size := be.Uint16(data[pos:]), int(be.Uint32(data[pos+2:]))
+		pos += 6
+		if size < 0 || size > len(data)-pos {
+			return nil, paletteFormatError("ase", "block runs past the end of the file")
+		}
+		block := data[pos : pos+size]
+		pos += size
+
+		switch kind {
+		case aseGroupStart:
+			name, _, err := aseName(block)
+			if err != nil {
+				return nil, err
+			}
+			if p.Name == "" {
+				p.Name = name
+			}
+		case aseColor:
+			name, rest, err := aseName(block)
+			if err != nil {
+				return nil, err
+			}
+			c, err := aseColorValue(rest)
+			if err != nil {
+				return nil, err
+			}
+			p.Add(name, c)
+		}
+	}
+	return p, nil
+}
+
+// aseName reads a length-prefixed, NUL-terminated UTF-16BE name
+func aseName(block []byte) (string, []byte, error) {
+	if len(block) < 2 {
+		return "", nil, paletteFormatError("ase", "truncated name")
+	}
+	n := int(binary.BigEndian.Uint16(block))
+	if 2+n*2 > len(block) {
+		return "", nil, paletteFormatError("ase", "truncated name")
+	}
+	units := make([]uint16, 0, n)
+	for i := 0; i < n; i++ {
+		u := binary.BigEndian.Uint16(block[2+i*2:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units)), block[2+n*2:], nil
+}
+
+// aseColorValue converts a color model and its float32 values to sRGB
+func aseColorValue(b []byte) (Color, error) {
+	if len(b) < 4 {
+		return Color{}, paletteFormatError("ase", "missing color model")
+	}
+	model := string(b[:4])
+	counts := map[string]int{"RGB ": 3, "CMYK": 4, "LAB ": 3, "Gray": 1}
+	n, ok := counts[model]
+	if !ok {
+		return Color{}, paletteFormatError("ase", "unknown color model "+strconv.Quote(model))
+	}
+	if len(b) < 4+n*4 {
+		return Color{}, paletteFormatError("ase", "truncated color values")
+	}
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(b[4+i*4:])))
+	}
+
+	switch model {
+	case "CMYK":
+		return CMYK{C: v[0], M: v[1], Y: v[2], K: v[3]}.ToRGB(), nil
+	case "LAB ":
+		// Lightness is stored as a fraction; a and b are D50 Lab
+		l := v[0]
+		if l <= 1 {
+			l *= 100
+		}
+		rgb := srgbProfile.fromPCS(labToPCS(l, v[1], v[2]), IntentRelativeColorimetric)
+		return NewColor(rgb[0], rgb[1], rgb[2], 1), nil
+	case "Gray":
+		// Gray is a black ink tint: 0 is white
+		g := clamp(1-v[0], 0, 1)
+		return NewColor(g, g, g, 1), nil
+	}
+	return NewColor(clamp(v[0], 0, 1), clamp(v[1], 0, 1), clamp(v[2], 0, 1), 1), nil
+}
+
+func (p *Palette) encodeASE(w io.Writer) error {
+	be := binary.BigEndian
+	var blocks [][]byte
+	block := func(kind uint16, body []byte) {
+		b := be.AppendUint16(nil, kind)
+		b = be.AppendUint32(b, uint32(len(body)))
+		blocks = append(blocks, append(b, body...))
+	}
+	name := func(s string) []byte {
+		units := utf16.Encode([]rune(s))
+		b := be.AppendUint16(nil, uint16(len(units)+1))
+		for _, u := range units {
+			b = be.AppendUint16(b, u)
+		}
+		return be.AppendUint16(b, 0)
+	}
+
+	if p.Name != "" {
+		block(aseGroupStart, name(p.Name))
+	}
+	for _, s := range p.Swatches {
+		body := append(name(s.Name), "RGB "...)
+		for _, v := range []float64{s.Color.R, s.Color.G, s.Color.B} {
+			body = be.AppendUint32(body, math.Float32bits(float32(clamp(v, 0, 1))))
+		}
+		// Color type 2 is a normal (process, non-global) swatch
+		block(aseColor, be.AppendUint16(body, 2))
+	}
+	if p.Name != "" {
+		block(aseGroupEnd, nil)
+	}
+
+	header := append([]byte("ASEF"), 0, 1, 0, 0)
+	header = be.AppendUint32(header, uint32(len(blocks)))
+	out := bytes.Join(append([][]byte{header}, blocks...), nil)
+	_, err := w.Write(out)
+	return err
+}
+
+// GIMP palettes
+
+func decodeGPL(r io.Reader) (*Palette, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "GIMP Palette" {
+		return nil, paletteFormatError("gpl", "missing GIMP Palette header")
+	}
+	p := &Palette{}
+	lineNo := 1
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "Name:"):
+			p.Name = strings.TrimSpace(strings.TrimPrefix(line, "Name:"))
+			continue
+		case strings.HasPrefix(line, "Columns:"):
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, paletteFormatError("gpl", fmt.Sprintf("line %d needs three components", lineNo))
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			v, err := strconv.Atoi(fields[i])
+			if err != nil || v < 0 || v > 255 {
+				return nil, paletteFormatError("gpl", fmt.Sprintf("line %d has an invalid component %q", lineNo, fields[i]))
+			}
+			rgb[i] = uint8(v)
+		}
+		name := strings.Join(fields[3:], " ")
+		p.Add(name, NewColorFromRGBA255(rgb[0], rgb[1], rgb[2], 255))
+	}
+	return p, scanner.Err()
+}
+
+func (p *Palette) encodeGPL(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "GIMP Palette")
+	if p.Name != "" {
+		fmt.Fprintf(bw, "Name: %s\n", p.Name)
+	}
+	fmt.Fprintln(bw, "#")
+	for _, s := range p.Swatches {
+		n := s.Color.nrgba()
+		fmt.Fprintf(bw, "%3d %3d %3d\t%s\n", n.R, n.G, n.B, s.Name)
+	}
+	return bw.Flush()
+}
+
+// Procreate swatches JSON
+
+type swatchesGroup struct {
+	Name     string           `json:"name"`
+	Swatches []*swatchesEntry `json:"swatches"`
+}
+
+type swatchesEntry struct {
+	Name       string  `json:"name,omitempty"`
+	Hue        float64 `json:"hue"`
+	Saturation float64 `json:"saturation"`
+	Brightness float64 `json:"brightness"`
+	Alpha      float64 `json:"alpha"`
+	ColorSpace int     `json:"colorSpace"`
+}
+
+func decodeSwatchesJSON(r io.Reader) (*Palette, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// A .swatches file is a zip archive around Swatches.json
+	if bytes.HasPrefix(data, []byte("PK")) {
+		if data, err = swatchesFromArchive(data); err != nil {
+			return nil, err
+		}
+	}
+
+	var groups []swatchesGroup
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var g swatchesGroup
+		err = json.Unmarshal(trimmed, &g)
+		groups = []swatchesGroup{g}
+	} else {
+		err = json.Unmarshal(data, &groups)
+	}
+	if err != nil {
+		return nil, paletteFormatError("json", err.Error())
+	}
+
+	p := &Palette{}
+	for _, g := range groups {
+		if p.Name == "" {
+			p.Name = g.Name
+		}
+		for _, s := range g.Swatches {
+			// Procreate leaves empty slots as null
+			if s == nil {
+				continue
+			}
+			c := HSV{H: s.Hue * 360, S: s.Saturation, V: s.Brightness}.ToRGB()
+			c.A = s.Alpha
+			p.Add(s.Name, c)
+		}
+	}
+	return p, nil
+}
+
+// maxSwatchesJSON caps the Swatches.json unpacked from a .swatches archive,
+// far above any real palette
+const maxSwatchesJSON = 16 << 20
+
+func swatchesFromArchive(data []byte) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, paletteFormatError("swatches", err.Error())
+	}
+	for _, f := range archive.File {
+		if !strings.EqualFold(filepath.Base(f.Name), "Swatches.json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(io.LimitReader(rc, maxSwatchesJSON+1))
+		if err == nil && len(data) > maxSwatchesJSON {
+			return nil, paletteFormatError("swatches", "Swatches.json is too large")
+		}
+		return data, err
+	}
+	return nil, paletteFormatError("swatches", "archive has no Swatches.json")
+}
+
+func (p *Palette) encodeSwatchesJSON(w io.Writer) error {
+	g := swatchesGroup{Name: p.Name, Swatches: make([]*swatchesEntry, len(p.Swatches))}
+	for i, s := range p.Swatches {
+		hsv := s.Color.ToHSV()
+		g.Swatches[i] = &swatchesEntry{
+			Name:       s.Name,
+			Hue:        hsv.H / 360,
+			Saturation: hsv.S,
+			Brightness: hsv.V,
+			Alpha:      s.Color.A,
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode([]swatchesGroup{g})
+}
+
+// CSS custom properties
+
+var cssPropertyPattern = regexp.MustCompile(`--([A-Za-z0-9_-]+)\s*:\s*([^;}]+)`)
+
+// decodeCSSPalette reads custom properties holding hex, rgb() or oklch()
+// colors; other properties are skipped
+func decodeCSSPalette(r io.Reader) (*Palette, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &Palette{}
+	for _, m := range cssPropertyPattern.FindAllStringSubmatch(string(data), -1) {
+		if c, ok := parseCSSColor(strings.TrimSpace(m[2])); ok {
+			p.Add(m[1], c)
+		}
+	}
+	if len(p.Swatches) == 0 {
+		return nil, paletteFormatError("css", "no color custom properties found")
+	}
+	return p, nil
+}
+
+// parseCSSColor parses #hex, rgb()/rgba() and oklch() values
+func parseCSSColor(s string) (Color, bool) {
+	s = strings.ToLower(s)
+	if strings.HasPrefix(s, "#") {
+		switch len(s) {
+		case 4, 5, 7, 9:
+		default:
+			return Color{}, false
+		}
+		if _, err := strconv.ParseUint(s[1:], 16, 32); err != nil {
+			return Color{}, false
+		}
+		if len(s) == 5 {
+			// #rgba is CSS shorthand; expand it for parseHexColor
+			s = string([]byte{'#', s[1], s[1], s[2], s[2], s[3], s[3], s[4], s[4]})
+		}
+		r, g, b, a := parseHexColor(s)
+		return NewColorFromRGBA255(uint8(r), uint8(g), uint8(b), uint8(a)), true
+	}
+
+	open, end := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if open < 0 || end < open {
+		return Color{}, false
+	}
+	fn := strings.TrimSpace(s[:open])
+	args := strings.FieldsFunc(s[open+1:end], func(r rune) bool {
+		return r == ',' || r == '/' || r == ' ' || r == '\t'
+	})
+	if len(args) < 3 {
+		return Color{}, false
+	}
+	// value parses a number, scaling percentages by full
+	value := func(arg string, full float64) (float64, bool) {
+		scale := 1.0
+		if strings.HasSuffix(arg, "%") {
+			arg, scale = strings.TrimSuffix(arg, "%"), full/100
+		}
+		arg = strings.TrimSuffix(arg, "deg")
+		v, err := strconv.ParseFloat(arg, 64)
+		return v * scale, err == nil
+	}
+	alpha := 1.0
+	if len(args) > 3 {
+		a, ok := value(args[3], 1)
+		if !ok {
+			return Color{}, false
+		}
+		alpha = clamp(a, 0, 1)
+	}
+
+	switch fn {
+	case "rgb", "rgba":
+		var v [3]float64
+		for i := range v {
+			x, ok := value(args[i], 255)
+			if !ok {
+				return Color{}, false
+			}
+			v[i] = clamp(x/255, 0, 1)
+		}
+		return NewColor(v[0], v[1], v[2], alpha), true
+	case "oklch":
+		l, ok1 := value(args[0], 1)
+		c, ok2 := value(args[1], 0.4)
+		h, ok3 := value(args[2], 360)
+		if !ok1 || !ok2 || !ok3 {
+			return Color{}, false
+		}
+		rgb := OKLCH{L: l, C: c, H: h}.ToRGB()
+		rgb.A = alpha
+		return rgb, true
+	}
+	return Color{}, false
+}
+
+var cssNameInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+func (p *Palette) encodeCSS(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if p.Name != "" {
+		fmt.Fprintf(bw, "/* %s */\n", strings.ReplaceAll(p.Name, "*/", ""))
+	}
+	fmt.Fprintln(bw, ":root {")
+	used := map[string]int{}
+	for i, s := range p.Swatches {
+		name := strings.Trim(cssNameInvalid.ReplaceAllString(strings.ToLower(s.Name), "-"), "-")
+		if name == "" {
+			name = fmt.Sprintf("color-%d", i+1)
+		}
+		// Keep names unique so no property overrides another
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, used[name])
+		}
+		fmt.Fprintf(bw, "  --%s: %s;\n", name, s.Color.Hex())
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
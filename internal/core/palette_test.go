@@ -0,0 +1,178 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func testPalette() *Palette {
+	p := &Palette{Name: "Brand"}
+	p.Add("Ink", NewColorFromRGBA255(20, 24, 38, 255))
+	p.Add("Coral", NewColorFromRGBA255(255, 111, 97, 255))
+	p.Add("Mint Leaf", NewColorFromRGBA255(152, 255, 200, 255))
+	return p
+}
+
+func TestPaletteFormatsRoundTrip(t *testing.T) {
+	want := testPalette()
+	for _, format := range []PaletteFormat{PaletteASE, PaletteGPL, PaletteJSON, PaletteCSS} {
+		var buf bytes.Buffer
+		if err := want.Encode(&buf, format); err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		got, err := DecodePalette(&buf, format)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if got.Len() != want.Len() {
+			t.Fatalf("format %d: %d swatches, want %d", format, got.Len(), want.Len())
+		}
+		for i, s := range got.Swatches {
+			if s.Color.Hex() != want.Swatches[i].Color.Hex() {
+				t.Errorf("format %d: swatch %d is %s, want %s", format, i, s.Color.Hex(), want.Swatches[i].Color.Hex())
+			}
+		}
+		if format != PaletteCSS && got.Name != "Brand" {
+			t.Errorf("format %d: name %q", format, got.Name)
+		}
+		if format != PaletteCSS && got.Swatches[2].Name != "Mint Leaf" {
+			t.Errorf("format %d: swatch name %q", format, got.Swatches[2].Name)
+		}
+	}
+}
+
+func TestPaletteDecoding(t *testing.T) {
+	css := `:root {
+  --primary: #336699;
+  --shade: #3698;
+  --accent: rgb(255 0 0 / 50%);
+  --ok: oklch(62.8% 0.2577 29.23);
+  --spacing: 4px;
+}`
+	p, err := DecodePalette(strings.NewReader(css), PaletteCSS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 4 || p.Swatches[0].Name != "primary" || p.Swatches[0].Color.Hex() != "#336699" {
+		t.Fatalf("css palette = %+v", p.Swatches)
+	}
+	if h := p.Swatches[1].Color.Hex(); h != "#33669988" {
+		t.Errorf("#rgba shade = %s", h)
+	}
+	if a := p.Swatches[2].Color; a.R != 1 || math.Abs(a.A-0.5) > 1e-9 {
+		t.Errorf("rgb() with alpha = %v", a)
+	}
+	if h := p.Swatches[3].Color.Hex(); h != "#ff0000" {
+		t.Errorf("oklch red = %s", h)
+	}
+
+	gpl := "GIMP Palette\nName: Tiny\nColumns: 2\n#\n255 0 0\tRed\n  0   0 255 Deep Blue\n"
+	if p, err = DecodePalette(strings.NewReader(gpl), PaletteGPL); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Tiny" || p.Len() != 2 || p.Swatches[1].Name != "Deep Blue" {
+		t.Errorf("gpl palette = %+v", p)
+	}
+	if _, err := DecodePalette(strings.NewReader("JASC-PAL\n"), PaletteGPL); err == nil {
+		t.Error("non-GIMP palettes should be rejected")
+	}
+	if _, err := DecodePalette(strings.NewReader("ASEF\x00\x01\x00\x00\x00\x00\x00\x05"), PaletteASE); err == nil {
+		t.Error("truncated ASE should be rejected")
+	}
+
+	// Procreate .swatches archives hold a single Swatches.json with null
+	// entries for empty slots
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	f, _ := zw.Create("Swatches.json")
+	f.Write([]byte(`[{"name":"Proc","swatches":[{"hue":0.5,"saturation":1,"brightness":1,"alpha":1,"colorSpace":0},null]}]`))
+	zw.Close()
+	if p, err = DecodePalette(&archive, PaletteJSON); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Proc" || p.Len() != 1 || p.Swatches[0].Color.Hex() != "#00ffff" {
+		t.Errorf("swatches archive = %+v", p)
+	}
+
+	// The unpacked JSON is capped, so a small archive cannot expand without bound
+	archive.Reset()
+	zw = zip.NewWriter(&archive)
+	f, _ = zw.Create("Swatches.json")
+	f.Write(bytes.Repeat([]byte(" "), maxSwatchesJSON+1))
+	zw.Close()
+	if _, err := DecodePalette(&archive, PaletteJSON); err == nil {
+		t.Error("expected an error for an oversized Swatches.json")
+	}
+}
+
+func TestPaletteNearest(t *testing.T) {
+	p := testPalette()
+	if i := p.Nearest(NewColorFromRGBA255(240, 120, 100, 255)); i != 1 {
+		t.Errorf("nearest to salmon = %d", i)
+	}
+	if c := p.Snap(NewColor(0.1, 0.1, 0.2, 0.5)); c.Hex() != "#14182680" {
+		t.Errorf("snap = %s", c.Hex())
+	}
+	if (&Palette{}).Nearest(NewColor(0, 0, 0, 1)) != -1 {
+		t.Error("empty palette should have no nearest swatch")
+	}
+
+	dc := NewContext(2, 1)
+	dc.SetRGB255(250, 100, 90)
+	dc.SetPixel(0, 0)
+	snapped := p.SnapImage(dc.Image())
+	if got := snapped.RGBAAt(0, 0); got.R != 255 || got.G != 111 || got.B != 97 {
+		t.Errorf("snapped pixel = %v", got)
+	}
+	if snapped.RGBAAt(1, 0).A != 0 {
+		t.Error("transparent pixels should stay transparent")
+	}
+}
+
+func TestHarmonies(t *testing.T) {
+	base := NewColorFromRGBA255(40, 120, 200, 255)
+	for _, space := range []HarmonySpace{HarmonyOKLCH, HarmonyLCh} {
+		p := Harmony(base, HarmonyTriadic, space)
+		if p.Len() != 3 || p.Swatches[0].Color.Hex() != base.Hex() {
+			t.Fatalf("space %d: triadic = %+v", space, p.Swatches)
+		}
+		comp := Harmony(base, HarmonyComplementary, space).Swatches[1].Color
+		var d float64
+		if space == HarmonyOKLCH {
+			d = math.Abs(comp.ToOKLCH().H - base.ToOKLCH().H)
+		} else {
+			d = math.Abs(comp.ToLCh().H - base.ToLCh().H)
+		}
+		if math.Abs(d-180) > 8 {
+			t.Errorf("space %d: complement is %.1f° away", space, d)
+		}
+	}
+
+	ramp := Ramp(base, 3, 4, HarmonyOKLCH)
+	if ramp.Len() != 8 || ramp.Swatches[3].Color != base {
+		t.Fatalf("ramp = %+v", ramp.Swatches)
+	}
+	for i := 1; i < ramp.Len(); i++ {
+		if ramp.Swatches[i].Color.ToOKLab().L >= ramp.Swatches[i-1].Color.ToOKLab().L {
+			t.Errorf("ramp lightness should fall at step %d", i)
+		}
+	}
+}
+
+func TestSwatchSheet(t *testing.T) {
+	p := testPalette()
+	sheet := p.SwatchSheet(SwatchSheetOptions{Columns: 2, CellSize: 16, Labels: true})
+	// 2 columns of 16 px cells with 2 px gaps; 2 rows with 30 px labels
+	if b := sheet.Bounds(); b.Dx() != 38 || b.Dy() != 98 {
+		t.Fatalf("sheet size = %v", b)
+	}
+	if c := sheet.RGBAAt(2+18+8, 2+8); c.R != 255 || c.G != 111 {
+		t.Errorf("second swatch = %v", c)
+	}
+	if c := sheet.RGBAAt(2+8, 2+48+8); c.R != 152 {
+		t.Errorf("third swatch = %v", c)
+	}
+}
@@ -65,9 +65,10 @@ func NewSurfacePattern(im image.Image, op RepeatOp) Pattern {
 }
 
 type patternPainter struct {
-	im   *image.RGBA
-	mask *image.Alpha
-	p    Pattern
+	im     *image.RGBA
+	mask   *image.Alpha
+	p      Pattern
+	linear bool
 }
 
 // Paint satisfies the Painter interface.
@@ -103,6 +104,10 @@ func (r *patternPainter) Paint(ss []raster.Span, done bool) {
 					continue
 				}
 			}
+			if r.linear {
+				r.blendLinear(i, x, y, ma)
+				continue
+			}
 			c := r.p.ColorAt(x, y)
 			cr, cg, cb, ca := c.RGBA()
 			dr := uint32(r.im.Pix[i+0])
@@ -118,6 +123,6 @@ func (r *patternPainter) Paint(ss []raster.Span, done bool) {
 	}
 }
 
-func newPatternPainter(im *image.RGBA, mask *image.Alpha, p Pattern) *patternPainter {
-	return &patternPainter{im, mask, p}
+func newPatternPainter(im *image.RGBA, mask *image.Alpha, p Pattern, linear bool) *patternPainter {
+	return &patternPainter{im, mask, p, linear}
 }
@@ -0,0 +1,401 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/golang/freetype/raster"
+	"golang.org/x/image/draw"
+)
+
+// High bit-depth and floating-point canvases
+
+// PixelFormat selects the sample type of a Context's canvas
+type PixelFormat int
+
+const (
+	// FormatRGBA8 stores 8-bit premultiplied samples in an *image.RGBA
+	FormatRGBA8 PixelFormat = iota
+	// FormatRGBA16 stores 16-bit premultiplied samples in an *image.RGBA64
+	FormatRGBA16
+	// FormatRGBAF32 stores premultiplied float32 samples in an *RGBAF32,
+	// which may exceed 1 for high dynamic range work
+	FormatRGBAF32
+)
+
+// RGBAF32 is an in-memory image of premultiplied float32 RGBA samples.
+// Samples are nominally 0-1; values above 1 are kept, and At clamps them.
+type RGBAF32 struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewRGBAF32 returns a transparent float image with the given bounds
+func NewRGBAF32(r image.Rectangle) *RGBAF32 {
+	return &RGBAF32{
+		Pix:    make([]float32, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// ColorModel satisfies the image.Image interface
+func (p *RGBAF32) ColorModel() color.Model { return color.RGBA64Model }
+
+// Bounds satisfies the image.Image interface
+func (p *RGBAF32) Bounds() image.Rectangle { return p.Rect }
+
+// At satisfies the image.Image interface
+func (p *RGBAF32) At(x, y int) color.Color { return p.RGBA64At(x, y) }
+
+// PixOffset returns the index of the first sample of the pixel at x, y
+func (p *RGBAF32) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+}
+
+// FloatAt returns the premultiplied samples at x, y
+func (p *RGBAF32) FloatAt(x, y int) (r, g, b, a float32) {
+	if !image.Pt(x, y).In(p.Rect) {
+		return 0, 0, 0, 0
+	}
+	s := p.Pix[p.PixOffset(x, y):]
+	return s[0], s[1], s[2], s[3]
+}
+
+// SetFloat sets the premultiplied samples at x, y
+func (p *RGBAF32) SetFloat(x, y int, r, g, b, a float32) {
+	if !image.Pt(x, y).In(p.Rect) {
+		return
+	}
+	s := p.Pix[p.PixOffset(x, y):]
+	s[0], s[1], s[2], s[3] = r, g, b, a
+}
+
+// RGBA64At returns the pixel clamped to 16 bits
+func (p *RGBAF32) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.FloatAt(x, y)
+	a16 := unitTo16(a, 1)
+	return color.RGBA64{unitTo16(r, a), unitTo16(g, a), unitTo16(b, a), a16}
+}
+
+// Set satisfies the draw.Image interface
+func (p *RGBAF32) Set(x, y int, c color.Color) {
+	r, g, b, a := c.RGBA()
+	p.SetFloat(x, y, float32(r)/0xffff, float32(g)/0xffff, float32(b)/0xffff, float32(a)/0xffff)
+}
+
+// SetRGBA64 sets the pixel from a 16-bit color
+func (p *RGBAF32) SetRGBA64(x, y int, c color.RGBA64) {
+	p.Set(x, y, c)
+}
+
+// unitTo16 converts a sample in 0..limit to 16 bits
+func unitTo16(v, limit float32) uint16 {
+	if limit > 1 {
+		limit = 1
+	}
+	if v > limit {
+		v = limit
+	}
+	if v <= 0 {
+		return 0
+	}
+	return uint16(v*0xffff + 0.5)
+}
+
+// ToRGBA64 converts any image to a 16-bit *image.RGBA64 with the same bounds
+func ToRGBA64(img image.Image) *image.RGBA64 {
+	bounds := img.Bounds()
+	dst := image.NewRGBA64(bounds)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+	return dst
+}
+
+// ToRGBAF32 converts any image to an *RGBAF32 with the same bounds
+func ToRGBAF32(img image.Image) *RGBAF32 {
+	bounds := img.Bounds()
+	dst := NewRGBAF32(bounds)
+	if src, ok := img.(*RGBAF32); ok {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			copy(dst.Pix[dst.PixOffset(bounds.Min.X, y):dst.PixOffset(bounds.Max.X, y)],
+				src.Pix[src.PixOffset(bounds.Min.X, y):])
+		}
+		return dst
+	}
+	parallelRows(bounds.Dy(), 0, func(start, end int) {
+		for y := bounds.Min.Y + start; y < bounds.Min.Y+end; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				dst.Set(x, y, img.At(x, y))
+			}
+		}
+	})
+	return dst
+}
+
+// deepCanvas is a high bit-depth pixel store read and written as
+// premultiplied float samples
+type deepCanvas interface {
+	draw.Image
+	pixel(x, y int) [4]float32
+	setPixel(x, y int, c [4]float32)
+	image() image.Image
+	format() PixelFormat
+}
+
+func (p *RGBAF32) pixel(x, y int) [4]float32 {
+	r, g, b, a := p.FloatAt(x, y)
+	return [4]float32{r, g, b, a}
+}
+
+func (p *RGBAF32) setPixel(x, y int, c [4]float32) {
+	p.SetFloat(x, y, c[0], c[1], c[2], c[3])
+}
+
+func (p *RGBAF32) image() image.Image  { return p }
+func (p *RGBAF32) format() PixelFormat { return FormatRGBAF32 }
+
+// rgba64Canvas adapts an *image.RGBA64 to deepCanvas
+type rgba64Canvas struct {
+	*image.RGBA64
+}
+
+func (p rgba64Canvas) pixel(x, y int) [4]float32 {
+	c := p.RGBA64At(x, y)
+	return [4]float32{float32(c.R) / 0xffff, float32(c.G) / 0xffff, float32(c.B) / 0xffff, float32(c.A) / 0xffff}
+}
+
+func (p rgba64Canvas) setPixel(x, y int, c [4]float32) {
+	p.SetRGBA64(x, y, color.RGBA64{unitTo16(c[0], c[3]), unitTo16(c[1], c[3]), unitTo16(c[2], c[3]), unitTo16(c[3], 1)})
+}
+
+func (p rgba64Canvas) image() image.Image  { return p.RGBA64 }
+func (p rgba64Canvas) format() PixelFormat { return FormatRGBA16 }
+
+// asDeepCanvas returns img as a deepCanvas when it is a 16-bit or float image
+func asDeepCanvas(img image.Image) (deepCanvas, bool) {
+	switch img := img.(type) {
+	case *RGBAF32:
+		return img, true
+	case *image.RGBA64:
+		return rgba64Canvas{img}, true
+	}
+	return nil, false
+}
+
+// newDeepCanvas allocates an empty canvas of the given format
+func newDeepCanvas(format PixelFormat, r image.Rectangle) deepCanvas {
+	if format == FormatRGBAF32 {
+		return NewRGBAF32(r)
+	}
+	return rgba64Canvas{image.NewRGBA64(r)}
+}
+
+// mapDeep applies fn to every premultiplied pixel of a 16-bit or float
+// image, returning an image of the same kind. It returns nil for any other
+// image so callers can fall back to their 8-bit path.
+func mapDeep(img image.Image, fn func(c [4]float32) [4]float32) image.Image {
+	src, ok := asDeepCanvas(img)
+	if !ok {
+		return nil
+	}
+	bounds := img.Bounds()
+	dst := newDeepCanvas(src.format(), bounds)
+	parallelRows(bounds.Dy(), 0, func(start, end int) {
+		for y := bounds.Min.Y + start; y < bounds.Min.Y+end; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				dst.setPixel(x, y, fn(src.pixel(x, y)))
+			}
+		}
+	})
+	return dst.image()
+}
+
+// NewContextWithFormat creates a context whose canvas uses the given pixel
+// format. Fills, strokes, gradients, text, DrawImage, Clear, ImageData,
+// pasting and compositing, flood fills, color space conversion, the
+// built-in color filters and blur, and non-destructive edits keep the full
+// precision of 16-bit and float canvases.
+func NewContextWithFormat(width, height int, format PixelFormat) *Context {
+	dc := NewContext(width, height)
+	if format != FormatRGBA8 {
+		dc.deep = newDeepCanvas(format, image.Rect(0, 0, width, height))
+	}
+	return dc
+}
+
+// NewContextForRGBA64 prepares a 16-bit context for rendering onto the
+// specified image. No copy is made.
+func NewContextForRGBA64(im *image.RGBA64) *Context {
+	dc := NewContextForRGBA(image.NewRGBA(im.Bounds()))
+	dc.deep = rgba64Canvas{im}
+	return dc
+}
+
+// NewContextForRGBAF32 prepares a float context for rendering onto the
+// specified image. No copy is made.
+func NewContextForRGBAF32(im *RGBAF32) *Context {
+	dc := NewContextForRGBA(image.NewRGBA(im.Bounds()))
+	dc.deep = im
+	return dc
+}
+
+// Format returns the pixel format of the context's canvas
+func (dc *Context) Format() PixelFormat {
+	if dc.deep == nil {
+		return FormatRGBA8
+	}
+	return dc.deep.format()
+}
+
+// setDeepImage replaces the contents of the deep canvas with img
+func (dc *Context) setDeepImage(img image.Image) {
+	if c, ok := asDeepCanvas(img); ok && c.format() == dc.deep.format() && img.Bounds() == dc.deep.Bounds() {
+		dc.deep = c
+		return
+	}
+	draw.Draw(dc.deep, dc.deep.Bounds(), img, img.Bounds().Min, draw.Src)
+}
+
+// compositeDeep draws an 8-bit premultiplied layer over the deep canvas,
+// through the clip mask
+func (dc *Context) compositeDeep(layer *image.RGBA) {
+	bounds := layer.Bounds().Intersect(dc.deep.Bounds())
+	parallelRows(bounds.Dy(), 0, func(start, end int) {
+		for y := bounds.Min.Y + start; y < bounds.Min.Y+end; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				i := layer.PixOffset(x, y)
+				if layer.Pix[i+3] == 0 {
+					continue
+				}
+				cover := float32(1)
+				if dc.mask != nil {
+					cover = float32(dc.mask.AlphaAt(x, y).A) / 255
+				}
+				src := [4]float32{
+					float32(layer.Pix[i]) / 255 * cover,
+					float32(layer.Pix[i+1]) / 255 * cover,
+					float32(layer.Pix[i+2]) / 255 * cover,
+					float32(layer.Pix[i+3]) / 255 * cover,
+				}
+				if dc.linearLight {
+					dc.deep.setPixel(x, y, encodeDeep(over(decodeDeep(src), decodeDeep(dc.deep.pixel(x, y)))))
+					continue
+				}
+				dc.deep.setPixel(x, y, over(src, dc.deep.pixel(x, y)))
+			}
+		}
+	})
+}
+
+// over composites premultiplied src over dst
+func over(src, dst [4]float32) [4]float32 {
+	inv := 1 - src[3]
+	return [4]float32{src[0] + dst[0]*inv, src[1] + dst[1]*inv, src[2] + dst[2]*inv, src[3] + dst[3]*inv}
+}
+
+// deepPainter paints a pattern onto a deep canvas. Gradients interpolate
+// their stops at 16 bits rather than 8, and in linear light when linear
+// is set, which also blends in linear light.
+type deepPainter struct {
+	canvas deepCanvas
+	mask   *image.Alpha
+	p      Pattern
+	linear bool
+}
+
+// Paint satisfies the Painter interface.
+func (r *deepPainter) Paint(ss []raster.Span, done bool) {
+	b := r.canvas.Bounds()
+	mix, mixes := r.p.(mixingPattern)
+	for _, s := range ss {
+		if s.Y < b.Min.Y || s.Y >= b.Max.Y {
+			continue
+		}
+		x0, x1 := max(s.X0, b.Min.X), s.X1
+		if x1 > b.Max.X {
+			x1 = b.Max.X
+		}
+		for x := x0; x < x1; x++ {
+			cover := float32(s.Alpha) / 0xffff
+			if r.mask != nil {
+				cover *= float32(r.mask.AlphaAt(x, s.Y).A) / 255
+				if cover == 0 {
+					continue
+				}
+			}
+			lerp := colorLerp64
+			if r.linear {
+				lerp = colorLerpLinear
+			}
+			var c color.Color
+			if mixes {
+				c = mix.colorAt(x, s.Y, lerp)
+			} else {
+				c = r.p.ColorAt(x, s.Y)
+			}
+			var src [4]float32
+			if lc, ok := c.(linearColor); ok {
+				src = [4]float32{float32(lc.r) / 0xffff, float32(lc.g) / 0xffff, float32(lc.b) / 0xffff, float32(lc.a) / 0xffff}
+			} else {
+				cr, cg, cb, ca := c.RGBA()
+				src = [4]float32{float32(cr) / 0xffff, float32(cg) / 0xffff, float32(cb) / 0xffff, float32(ca) / 0xffff}
+				if r.linear {
+					src = decodeDeep(src)
+				}
+			}
+			for i := range src {
+				src[i] *= cover
+			}
+			if r.linear {
+				r.canvas.setPixel(x, s.Y, encodeDeep(over(src, decodeDeep(r.canvas.pixel(x, s.Y)))))
+				continue
+			}
+			r.canvas.setPixel(x, s.Y, over(src, r.canvas.pixel(x, s.Y)))
+		}
+	}
+}
+
+// colorLerp64 interpolates two colors in premultiplied 16-bit precision
+func colorLerp64(c0, c1 color.Color, t float64) color.Color {
+	r0, g0, b0, a0 := c0.RGBA()
+	r1, g1, b1, a1 := c1.RGBA()
+	mix := func(a, b uint32) uint16 {
+		return uint16(math.Round(float64(a)*(1-t) + float64(b)*t))
+	}
+	return color.RGBA64{mix(r0, r1), mix(g0, g1), mix(b0, b1), mix(a0, a1)}
+}
+
+// blurDeep blurs a 16-bit or float image, keeping its format. With
+// opts.Linear it blurs in linear light.
+func blurDeep(src deepCanvas, opts BlurOptions) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := newDeepCanvas(src.format(), bounds)
+	buf := make([]float32, w*h*4)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := src.pixel(bounds.Min.X+x, bounds.Min.Y+y)
+			if opts.Linear {
+				p = decodeDeep(p)
+			}
+			copy(buf[(y*w+x)*4:], p[:])
+		}
+	}
+	if opts.Sigma > 0 && w > 0 && h > 0 {
+		blurPlanar(buf, w, h, 4, opts)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			o := (y*w + x) * 4
+			a := float32(math.Max(0, float64(buf[o+3])))
+			c := [4]float32{clampFloat32(buf[o], 0, a), clampFloat32(buf[o+1], 0, a), clampFloat32(buf[o+2], 0, a), a}
+			if opts.Linear {
+				c = encodeDeep(c)
+			}
+			dst.setPixel(bounds.Min.X+x, bounds.Min.Y+y, c)
+		}
+	}
+	return dst.image()
+}
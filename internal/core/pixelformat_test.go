@@ -0,0 +1,247 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// distinctRed counts the distinct red values along the first row of img
+func distinctRed(img image.Image) int {
+	seen := map[uint32]bool{}
+	b := img.Bounds()
+	for x := b.Min.X; x < b.Max.X; x++ {
+		r, _, _, _ := img.At(x, b.Min.Y).RGBA()
+		seen[r] = true
+	}
+	return len(seen)
+}
+
+// darkRamp paints a gradient over the darkest quarter of the range, which
+// only has 64 steps at 8 bits
+func darkRamp(dc *Context) {
+	g := NewLinearGradient(0, 0, float64(dc.Width()), 0)
+	g.AddColorStop(0, color.Black)
+	g.AddColorStop(1, color.RGBA64{0x4000, 0x4000, 0x4000, 0xffff})
+	dc.SetFillStyle(g)
+	dc.DrawRectangle(0, 0, float64(dc.Width()), float64(dc.Height()))
+	dc.Fill()
+}
+
+func TestDeepContextGradient(t *testing.T) {
+	for _, format := range []PixelFormat{FormatRGBA16, FormatRGBAF32} {
+		dc := NewContextWithFormat(1024, 2, format)
+		if dc.Format() != format {
+			t.Fatalf("Format() = %v, want %v", dc.Format(), format)
+		}
+		darkRamp(dc)
+		if n := distinctRed(dc.Image()); n < 900 {
+			t.Errorf("format %v: %d distinct levels, want a smooth ramp", format, n)
+		}
+		r, _, _, _ := dc.Image().At(1023, 1).RGBA()
+		if math.Abs(float64(r)-0x4000) > 0x40 {
+			t.Errorf("format %v: ramp ends at %#x", format, r)
+		}
+	}
+
+	dc := NewContext(1024, 2)
+	darkRamp(dc)
+	if n := distinctRed(dc.Image()); n > 70 {
+		t.Errorf("8-bit ramp has %d levels", n)
+	}
+}
+
+func TestDeepContextFilters(t *testing.T) {
+	dc := NewContextWithFormat(64, 4, FormatRGBA16)
+	darkRamp(dc)
+	dc.ApplyFilter(Brightness(2))
+	dc.ApplyFilter(GaussianBlur(1))
+	dc.ApplyFilter(Invert)
+	if _, ok := dc.Image().(*image.RGBA64); !ok {
+		t.Fatalf("filters changed the canvas to %T", dc.Image())
+	}
+	r, _, _, _ := dc.Image().At(32, 2).RGBA()
+	if want := 0xffff - 2*0x4000*32.0/64; math.Abs(float64(r)-want) > 0x200 {
+		t.Errorf("filtered pixel = %#x, want about %#x", r, int(want))
+	}
+}
+
+func TestDeepContextSave(t *testing.T) {
+	dc := NewContextWithFormat(256, 1, FormatRGBAF32)
+	darkRamp(dc)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "ramp.png")
+	if err := dc.SavePNG(path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := distinctRed(img); n < 250 {
+		t.Errorf("PNG kept %d levels, want 16-bit output", n)
+	}
+
+	path = filepath.Join(dir, "ramp.tiff")
+	if err := dc.SaveTIFF(path); err != nil {
+		t.Fatal(err)
+	}
+	img, err = LoadTIFF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := distinctRed(img); n < 250 {
+		t.Errorf("TIFF kept %d levels, want 16-bit output", n)
+	}
+}
+
+func TestDeepImageData(t *testing.T) {
+	dc := NewContextWithFormat(128, 1, FormatRGBA16)
+	darkRamp(dc)
+	data := dc.GetImageData()
+	if data.Format != FormatRGBA16 {
+		t.Fatalf("ImageData format = %v", data.Format)
+	}
+	for x := 0; x < 128; x++ {
+		r, _, _, a := data.GetPixelFloat(x, 0)
+		want, _, _, _ := dc.Image().At(x, 0).RGBA()
+		if a != 1 || r*0xffff != float32(want) {
+			t.Fatalf("pixel %d = %v, want %v", x, r*0xffff, want)
+		}
+	}
+
+	data.SetPixelFloat(5, 0, 0.123456, 0, 0, 1)
+	dc.PutImageData(data)
+	r, _, _, _ := dc.Image().At(5, 0).RGBA()
+	if want := math.Round(0.123456 * 0xffff); float64(r) != want {
+		t.Errorf("put pixel = %v, want %v", r, want)
+	}
+}
+
+func TestDeepContextOperations(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	for _, format := range []PixelFormat{FormatRGBA16, FormatRGBAF32} {
+		dc := NewContextWithFormat(16, 16, format)
+		dc.SetRGB(0, 0, 1)
+		dc.DrawRectangle(0, 0, 8, 16)
+		dc.Fill()
+
+		if mask := dc.AsMask(); mask.AlphaAt(4, 4).A != 255 || mask.AlphaAt(12, 4).A != 0 {
+			t.Errorf("format %v: AsMask did not read the canvas", format)
+		}
+
+		patch := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		draw.Draw(patch, patch.Rect, image.NewUniform(red), image.Point{}, draw.Src)
+		dc.PasteImageWithMask(patch, image.NewUniform(color.Alpha{255}), 0, 0)
+		dc.CompositeImage(patch, 14, 14, draw.Src)
+		dc.FloodFill(12, 4, color.RGBA{0, 255, 0, 255}, 0, Connectivity4)
+		if _, ok := asDeepCanvas(dc.Image()); !ok {
+			t.Fatalf("format %v: operations changed the canvas to %T", format, dc.Image())
+		}
+		for _, tc := range []struct {
+			x, y int
+			want color.RGBA
+		}{
+			{1, 1, red},
+			{4, 4, color.RGBA{0, 0, 255, 255}},
+			{12, 4, color.RGBA{0, 255, 0, 255}},
+			{15, 15, red},
+		} {
+			if got := color.RGBAModel.Convert(dc.Image().At(tc.x, tc.y)); got != tc.want {
+				t.Errorf("format %v: pixel (%d, %d) = %v, want %v", format, tc.x, tc.y, got, tc.want)
+			}
+		}
+
+		dc.SetShadow(2, 2, 0, color.Black)
+		dc.DrawRectangle(12, 8, 1, 1)
+		dc.FillWithShadow()
+		if got := color.RGBAModel.Convert(dc.Image().At(14, 10)); got == (color.RGBA{0, 255, 0, 255}) {
+			t.Errorf("format %v: the shadow did not reach the canvas", format)
+		}
+
+		dc.ConvertToColorSpace(CreateAdobeRGBProfile())
+		if got := color.RGBAModel.Convert(dc.Image().At(12, 4)).(color.RGBA); maxChannelDiff(got, color.RGBA{144, 255, 60, 255}) > 2 {
+			t.Errorf("format %v: converted green = %v", format, got)
+		}
+	}
+}
+
+func TestDeepImageDataOperations(t *testing.T) {
+	data := NewImageDataWithFormat(8, 8, FormatRGBAF32)
+	data.Fill(0, 0, 255, 255)
+	if _, _, b, a := data.GetPixelFloat(3, 3); b != 1 || a != 1 {
+		t.Fatalf("filled pixel = %v alpha %v", b, a)
+	}
+	data.SetPixelFloat(0, 0, 0.25, 0, 0, 1)
+	data.FloodFill(7, 7, color.RGBA{0, 255, 0, 255}, 0, Connectivity4)
+	if r, g, _, _ := data.GetPixelFloat(0, 0); r != 0.25 || g != 0 {
+		t.Errorf("flood fill reached the odd pixel: %v %v", r, g)
+	}
+	if _, g, _, _ := data.GetPixelFloat(4, 4); g != 1 {
+		t.Errorf("flood fill missed (4, 4)")
+	}
+
+	identity := func(x, y float64) (float64, float64) { return x, y }
+	for name, out := range map[string]*ImageData{
+		"Warp":        data.Warp(identity, SamplerNearest),
+		"ApplyFilter": data.ApplyFilter(Invert),
+		"Rotate":      data.Rotate(math.Pi, RotateOptions{}),
+	} {
+		if out.Format != FormatRGBAF32 || out.FloatData == nil || out.Width != 8 || out.Height != 8 {
+			t.Errorf("%s returned %v data of %dx%d", name, out.Format, out.Width, out.Height)
+		}
+	}
+}
+
+func TestFloatEditStack(t *testing.T) {
+	base := image.NewRGBA64(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		v := uint16(x * 0x100)
+		base.SetRGBA64(x, 0, color.RGBA64{v, v, v, 0xffff})
+	}
+	addOps := func(es *EditStack) {
+		for i := 0; i < 3; i++ {
+			es.AddOperation(&BrightnessOperation{Amount: 0.1})
+			es.AddOperation(&BrightnessOperation{Amount: 10})
+		}
+	}
+
+	es := NewFloatEditStack(base)
+	addOps(es)
+	result := es.GetFloatResult()
+	for x := 0; x < 256; x++ {
+		r, _, _, _ := result.FloatAt(x, 0)
+		if want := float32(x*0x100) / 0xffff; math.Abs(float64(r-want)) > 1e-5 {
+			t.Fatalf("pixel %d = %v, want %v", x, r, want)
+		}
+	}
+	if n := distinctRed(es.GetResult()); n != 256 {
+		t.Errorf("8-bit result has %d levels, want 256", n)
+	}
+
+	banded := NewEditStack(imageToRGBA(base))
+	addOps(banded)
+	if n := distinctRed(banded.GetResult()); n > 30 {
+		t.Errorf("8-bit stack kept %d levels", n)
+	}
+
+	ctx := NewContextForRGBA64(base)
+	ctx.EnableNonDestructiveEditing()
+	addOps(ctx.GetEditStack())
+	ctx.AddEditOperation(&CurvesOperation{Curves: CurveSet{}})
+	ctx.ApplyNonDestructiveEdits()
+	if n := distinctRed(ctx.Image()); n != 256 {
+		t.Errorf("context edits kept %d levels, want 256", n)
+	}
+}
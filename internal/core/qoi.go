@@ -0,0 +1,215 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+// Quite OK Image format (.qoi)
+//
+// QOI stores 8-bit RGB or RGBA with straight alpha. Images decode to
+// *image.NRGBA; the colorspace byte of the header is informational and
+// does not change how samples are read.
+
+const (
+	qoiOpIndex = 0x00
+	qoiOpDiff  = 0x40
+	qoiOpLuma  = 0x80
+	qoiOpRun   = 0xc0
+	qoiOpRGB   = 0xfe
+	qoiOpRGBA  = 0xff
+	qoiMask    = 0xc0
+)
+
+var qoiPadding = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+func qoiFormatError(details string) *AdvanceError {
+	return NewInvalidFormatError("QOI", []string{"qoi"}).WithContext("details", details)
+}
+
+func qoiHash(p [4]byte) int {
+	return (int(p[0])*3 + int(p[1])*5 + int(p[2])*7 + int(p[3])*11) % 64
+}
+
+// LoadQOI loads a QOI image
+func LoadQOI(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := DecodeQOI(file)
+	if err != nil {
+		if ae, ok := err.(*AdvanceError); ok {
+			ae.WithContext("filepath", path)
+		}
+		return nil, err
+	}
+	return img, nil
+}
+
+// SaveQOI encodes the image as a QOI and writes it to disk
+func SaveQOI(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return EncodeQOI(file, img)
+}
+
+// readQOIHeader reads the 14-byte header and returns the image size
+func readQOIHeader(r io.Reader) (width, height int, err error) {
+	var header [14]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, qoiFormatError("truncated header")
+	}
+	if string(header[:4]) != "qoif" {
+		return 0, 0, qoiFormatError("missing qoif signature")
+	}
+	width = int(binary.BigEndian.Uint32(header[4:]))
+	height = int(binary.BigEndian.Uint32(header[8:]))
+	if channels := header[12]; channels != 3 && channels != 4 {
+		return 0, 0, qoiFormatError("invalid channel count")
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, qoiFormatError("invalid image size")
+	}
+	return width, height, nil
+}
+
+// DecodeQOIConfig returns the size of a QOI image without decoding it
+func DecodeQOIConfig(r io.Reader) (image.Config, error) {
+	width, height, err := readQOIHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: width, Height: height}, nil
+}
+
+// DecodeQOI reads a QOI image
+func DecodeQOI(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	width, height, err := readQOIHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecodeSize("DecodeQOI", width, height, 0); err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	run := 0
+	for o := 0; o < len(img.Pix); o += 4 {
+		if run > 0 {
+			run--
+			copy(img.Pix[o:o+4], px[:])
+			continue
+		}
+		op, err := br.ReadByte()
+		if err != nil {
+			return nil, qoiFormatError("truncated pixel data")
+		}
+		switch {
+		case op == qoiOpRGB, op == qoiOpRGBA:
+			n := 3
+			if op == qoiOpRGBA {
+				n = 4
+			}
+			if _, err := io.ReadFull(br, px[:n]); err != nil {
+				return nil, qoiFormatError("truncated pixel data")
+			}
+		case op&qoiMask == qoiOpIndex:
+			px = index[op]
+		case op&qoiMask == qoiOpDiff:
+			px[0] += (op>>4)&3 - 2
+			px[1] += (op>>2)&3 - 2
+			px[2] += op&3 - 2
+		case op&qoiMask == qoiOpLuma:
+			next, err := br.ReadByte()
+			if err != nil {
+				return nil, qoiFormatError("truncated pixel data")
+			}
+			dg := op&0x3f - 32
+			px[0] += dg + next>>4 - 8
+			px[1] += dg
+			px[2] += dg + next&0x0f - 8
+		default:
+			run = int(op & 0x3f)
+		}
+		index[qoiHash(px)] = px
+		copy(img.Pix[o:o+4], px[:])
+	}
+	return img, nil
+}
+
+// EncodeQOI writes img as a QOI image. Opaque images are written with three
+// channels, others with four.
+func EncodeQOI(w io.Writer, img image.Image) error {
+	src := imageToNRGBA(img)
+	b := src.Bounds()
+	channels := byte(3)
+	if !src.Opaque() {
+		channels = 4
+	}
+
+	bw := bufio.NewWriter(w)
+	var header [14]byte
+	copy(header[:], "qoif")
+	binary.BigEndian.PutUint32(header[4:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(header[8:], uint32(b.Dy()))
+	header[12] = channels
+	bw.Write(header[:])
+
+	var index [64][4]byte
+	prev := [4]byte{0, 0, 0, 255}
+	run := 0
+	for o := 0; o < len(src.Pix); o += 4 {
+		var px [4]byte
+		copy(px[:], src.Pix[o:o+4])
+		if px == prev {
+			run++
+			if run == 62 || o+4 == len(src.Pix) {
+				bw.WriteByte(qoiOpRun | byte(run-1))
+				run = 0
+			}
+			continue
+		}
+		if run > 0 {
+			bw.WriteByte(qoiOpRun | byte(run-1))
+			run = 0
+		}
+
+		h := qoiHash(px)
+		switch {
+		case index[h] == px:
+			bw.WriteByte(qoiOpIndex | byte(h))
+		case px[3] != prev[3]:
+			bw.Write([]byte{qoiOpRGBA, px[0], px[1], px[2], px[3]})
+		default:
+			dr := int8(px[0] - prev[0])
+			dg := int8(px[1] - prev[1])
+			db := int8(px[2] - prev[2])
+			drg, dbg := int(dr)-int(dg), int(db)-int(dg)
+			switch {
+			case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+				bw.WriteByte(qoiOpDiff | byte(dr+2)<<4 | byte(dg+2)<<2 | byte(db+2))
+			case dg >= -32 && dg <= 31 && drg >= -8 && drg <= 7 && dbg >= -8 && dbg <= 7:
+				bw.Write([]byte{qoiOpLuma | byte(dg+32), byte(drg+8)<<4 | byte(dbg+8)})
+			default:
+				bw.Write([]byte{qoiOpRGB, px[0], px[1], px[2]})
+			}
+		}
+		index[h] = px
+		prev = px
+	}
+	bw.Write(qoiPadding)
+	return bw.Flush()
+}
@@ -0,0 +1,613 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// Color quantization and palette extraction
+
+// QuantizeMethod selects the palette construction algorithm
+type QuantizeMethod int
+
+const (
+	// QuantizeWu uses Wu's variance-minimizing box splits; fast and accurate
+	QuantizeWu QuantizeMethod = iota
+	// QuantizeMedianCut splits color boxes at their population median
+	QuantizeMedianCut
+	// QuantizeOctree merges the least populated octree branches
+	QuantizeOctree
+	// QuantizeKMeans refines a Wu palette with k-means iterations
+	QuantizeKMeans
+)
+
+// WeightedColor is a palette color with the fraction of pixels it represents
+type WeightedColor struct {
+	Color  color.RGBA
+	Weight float64
+}
+
+// histEntry is one occupied bin of a 5-bit-per-channel color histogram
+type histEntry struct {
+	r, g, b float64 // mean color of the pixels in the bin
+	count   float64
+	bin     [3]int // 5-bit bin coordinates
+}
+
+// Quantize reduces the image to at most n colors and maps every pixel to its
+// nearest palette entry without dithering. If the image has transparent
+// pixels, one palette slot is reserved for a fully transparent color.
+func Quantize(img image.Image, n int, method QuantizeMethod) *image.Paletted {
+	palette := BuildPalette(img, n, method)
+	return Dither(img, palette, DitherNone)
+}
+
+// BuildPalette computes a palette of at most n colors for the image
+func BuildPalette(img image.Image, n int, method QuantizeMethod) color.Palette {
+	if n < 1 {
+		n = 1
+	}
+	if n > 256 {
+		n = 256
+	}
+
+	entries, transparent := colorHistogram(asRGBA(img))
+	if transparent && n > 1 {
+		n--
+	}
+
+	var colors []color.RGBA
+	switch method {
+	case QuantizeMedianCut:
+		colors = medianCut(entries, n)
+	case QuantizeOctree:
+		colors = octreeQuantize(entries, n)
+	case QuantizeKMeans:
+		colors, _ = kMeans(entries, wuQuantize(entries, n), 16)
+	default:
+		colors = wuQuantize(entries, n)
+	}
+
+	palette := make(color.Palette, 0, len(colors)+1)
+	for _, c := range colors {
+		palette = append(palette, c)
+	}
+	if transparent || len(palette) == 0 {
+		palette = append(palette, color.RGBA{})
+	}
+	return palette
+}
+
+// DominantColors returns the k most representative colors of the image,
+// sorted by the fraction of opaque pixels they cover
+func DominantColors(img image.Image, k int) []WeightedColor {
+	entries, _ := colorHistogram(asRGBA(img))
+	if k < 1 || len(entries) == 0 {
+		return nil
+	}
+
+	colors, weights := kMeans(entries, wuQuantize(entries, k), 16)
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+
+	result := make([]WeightedColor, 0, len(colors))
+	for i, c := range colors {
+		if weights[i] == 0 {
+			continue
+		}
+		result = append(result, WeightedColor{Color: c, Weight: weights[i] / total})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Weight > result[j].Weight })
+	return result
+}
+
+// colorHistogram bins the straight colors of pixels at least half opaque and
+// reports whether any pixel is mostly transparent
+func colorHistogram(img *image.RGBA) ([]histEntry, bool) {
+	type bin struct {
+		r, g, b, count float64
+	}
+	bins := make([]bin, 32*32*32)
+	transparent := false
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := img.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x, i = x+1, i+4 {
+			a := uint32(img.Pix[i+3])
+			if a < 128 {
+				transparent = true
+				continue
+			}
+			r, g, b := img.Pix[i], img.Pix[i+1], img.Pix[i+2]
+			if a < 255 {
+				r, g, b = unpremultiply8(r, a), unpremultiply8(g, a), unpremultiply8(b, a)
+			}
+			k := int(r>>3)<<10 | int(g>>3)<<5 | int(b>>3)
+			bins[k].r += float64(r)
+			bins[k].g += float64(g)
+			bins[k].b += float64(b)
+			bins[k].count++
+		}
+	}
+
+	var entries []histEntry
+	for k, b := range bins {
+		if b.count == 0 {
+			continue
+		}
+		entries = append(entries, histEntry{
+			r:     b.r / b.count,
+			g:     b.g / b.count,
+			b:     b.b / b.count,
+			count: b.count,
+			bin:   [3]int{k >> 10, (k >> 5) & 31, k & 31},
+		})
+	}
+	return entries, transparent
+}
+
+// meanColor returns the population-weighted mean of the entries
+func meanColor(entries []histEntry) color.RGBA {
+	var r, g, b, n float64
+	for _, e := range entries {
+		r += e.r * e.count
+		g += e.g * e.count
+		b += e.b * e.count
+		n += e.count
+	}
+	if n == 0 {
+		return color.RGBA{A: 255}
+	}
+	return color.RGBA{uint8(r/n + 0.5), uint8(g/n + 0.5), uint8(b/n + 0.5), 255}
+}
+
+// medianCut repeatedly splits the box with the largest population-weighted
+// extent at the median of its longest axis
+func medianCut(entries []histEntry, n int) []color.RGBA {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	channel := func(e *histEntry, axis int) float64 {
+		switch axis {
+		case 0:
+			return e.r
+		case 1:
+			return e.g
+		default:
+			return e.b
+		}
+	}
+	longestAxis := func(box []histEntry) (int, float64) {
+		bestAxis, bestRange := 0, -1.0
+		for axis := 0; axis < 3; axis++ {
+			lo, hi := 256.0, -1.0
+			for i := range box {
+				v := channel(&box[i], axis)
+				if v < lo {
+					lo = v
+				}
+				if v > hi {
+					hi = v
+				}
+			}
+			if hi-lo > bestRange {
+				bestAxis, bestRange = axis, hi-lo
+			}
+		}
+		return bestAxis, bestRange
+	}
+	population := func(box []histEntry) float64 {
+		var n float64
+		for _, e := range box {
+			n += e.count
+		}
+		return n
+	}
+
+	boxes := [][]histEntry{append([]histEntry(nil), entries...)}
+	for len(boxes) < n {
+		best, bestScore, bestAxis := -1, 0.0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			axis, extent := longestAxis(box)
+			if score := extent * population(box); score > bestScore {
+				best, bestScore, bestAxis = i, score, axis
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool {
+			return channel(&box[i], bestAxis) < channel(&box[j], bestAxis)
+		})
+		half := population(box) / 2
+		split, acc := 1, 0.0
+		for i := range box[:len(box)-1] {
+			acc += box[i].count
+			if acc >= half {
+				split = i + 1
+				break
+			}
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	colors := make([]color.RGBA, len(boxes))
+	for i, box := range boxes {
+		colors[i] = meanColor(box)
+	}
+	return colors
+}
+
+// octreeNode is a node of the color octree; every node accumulates the
+// population of its subtree
+type octreeNode struct {
+	children [8]*octreeNode
+	count    float64
+	sum      [3]float64
+	leaf     bool
+}
+
+// octreeQuantize builds a five-level octree over the histogram and merges the
+// least populated branches, deepest first, until at most n leaves remain
+func octreeQuantize(entries []histEntry, n int) []color.RGBA {
+	const depth = 5
+	root := &octreeNode{}
+	levels := make([][]*octreeNode, depth)
+	leaves := 0
+
+	for _, e := range entries {
+		node := root
+		for level := 0; level <= depth; level++ {
+			node.count += e.count
+			node.sum[0] += e.r * e.count
+			node.sum[1] += e.g * e.count
+			node.sum[2] += e.b * e.count
+			if level == depth {
+				if !node.leaf {
+					node.leaf = true
+					leaves++
+				}
+				break
+			}
+			shift := uint(depth - 1 - level)
+			idx := (e.bin[0]>>shift&1)<<2 | (e.bin[1]>>shift&1)<<1 | (e.bin[2] >> shift & 1)
+			if node.children[idx] == nil {
+				node.children[idx] = &octreeNode{}
+				if level+1 < depth {
+					levels[level+1] = append(levels[level+1], node.children[idx])
+				}
+			}
+			node = node.children[idx]
+		}
+	}
+	levels[0] = []*octreeNode{root}
+
+	for level := depth - 1; level >= 0 && leaves > n; level-- {
+		nodes := levels[level]
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+		for _, node := range nodes {
+			if leaves <= n {
+				break
+			}
+			merged := 0
+			for i, child := range node.children {
+				if child != nil {
+					merged++
+					node.children[i] = nil
+				}
+			}
+			node.leaf = true
+			leaves -= merged - 1
+		}
+	}
+
+	var colors []color.RGBA
+	var collect func(node *octreeNode)
+	collect = func(node *octreeNode) {
+		if node.leaf {
+			colors = append(colors, color.RGBA{
+				uint8(node.sum[0]/node.count + 0.5),
+				uint8(node.sum[1]/node.count + 0.5),
+				uint8(node.sum[2]/node.count + 0.5),
+				255,
+			})
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				collect(child)
+			}
+		}
+	}
+	if len(entries) > 0 {
+		collect(root)
+	}
+	return colors
+}
+
+// kMeans runs weighted Lloyd iterations over the histogram starting from the
+// given centers and returns the refined centers with their populations
+func kMeans(entries []histEntry, centers []color.RGBA, iterations int) ([]color.RGBA, []float64) {
+	k := len(centers)
+	c := make([][3]float64, k)
+	for i, center := range centers {
+		c[i] = [3]float64{float64(center.R), float64(center.G), float64(center.B)}
+	}
+	weights := make([]float64, k)
+
+	for iter := 0; iter < iterations && k > 0; iter++ {
+		sums := make([][3]float64, k)
+		for i := range weights {
+			weights[i] = 0
+		}
+		for _, e := range entries {
+			best, bestDist := 0, -1.0
+			for i := range c {
+				dr, dg, db := e.r-c[i][0], e.g-c[i][1], e.b-c[i][2]
+				if d := dr*dr + dg*dg + db*db; bestDist < 0 || d < bestDist {
+					best, bestDist = i, d
+				}
+			}
+			sums[best][0] += e.r * e.count
+			sums[best][1] += e.g * e.count
+			sums[best][2] += e.b * e.count
+			weights[best] += e.count
+		}
+
+		moved := 0.0
+		for i := range c {
+			if weights[i] == 0 {
+				continue
+			}
+			next := [3]float64{sums[i][0] / weights[i], sums[i][1] / weights[i], sums[i][2] / weights[i]}
+			for j := 0; j < 3; j++ {
+				d := next[j] - c[i][j]
+				moved += d * d
+			}
+			c[i] = next
+		}
+		if moved < 0.25 {
+			break
+		}
+	}
+
+	colors := make([]color.RGBA, k)
+	for i := range c {
+		colors[i] = color.RGBA{uint8(clamp(c[i][0], 0, 255) + 0.5), uint8(clamp(c[i][1], 0, 255) + 0.5), uint8(clamp(c[i][2], 0, 255) + 0.5), 255}
+	}
+	return colors, weights
+}
+
+// wuBox is a box of the Wu histogram; lower bounds are exclusive
+type wuBox struct {
+	r0, r1, g0, g1, b0, b1 int
+	vol                    int
+}
+
+// wuMoments holds the cumulative moments used by Wu's quantizer
+type wuMoments struct {
+	wt, mr, mg, mb, m2 []float64
+}
+
+const wuSide = 33
+
+func wuIndex(r, g, b int) int {
+	return (r*wuSide+g)*wuSide + b
+}
+
+// wuQuantize implements Xiaolin Wu's greedy orthogonal bipartition, which
+// splits the box with the largest variance at the cut that minimizes the
+// summed variance of the two halves
+func wuQuantize(entries []histEntry, n int) []color.RGBA {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	size := wuSide * wuSide * wuSide
+	m := wuMoments{
+		wt: make([]float64, size),
+		mr: make([]float64, size),
+		mg: make([]float64, size),
+		mb: make([]float64, size),
+		m2: make([]float64, size),
+	}
+	for _, e := range entries {
+		i := wuIndex(e.bin[0]+1, e.bin[1]+1, e.bin[2]+1)
+		m.wt[i] += e.count
+		m.mr[i] += e.r * e.count
+		m.mg[i] += e.g * e.count
+		m.mb[i] += e.b * e.count
+		m.m2[i] += (e.r*e.r + e.g*e.g + e.b*e.b) * e.count
+	}
+	for _, table := range [][]float64{m.wt, m.mr, m.mg, m.mb, m.m2} {
+		wuCumulate(table)
+	}
+
+	boxes := make([]wuBox, n)
+	variances := make([]float64, n)
+	boxes[0] = wuBox{r1: 32, g1: 32, b1: 32, vol: 32 * 32 * 32}
+	count := 1
+	next := 0
+	for count < n {
+		if m.cut(&boxes[next], &boxes[count]) {
+			variances[next] = m.variance(&boxes[next])
+			variances[count] = m.variance(&boxes[count])
+			count++
+		} else {
+			variances[next] = 0
+		}
+
+		next = 0
+		best := variances[0]
+		for i := 1; i < count; i++ {
+			if variances[i] > best {
+				best, next = variances[i], i
+			}
+		}
+		if best <= 0 {
+			break
+		}
+	}
+
+	colors := make([]color.RGBA, 0, count)
+	for i := 0; i < count; i++ {
+		w := wuVolume(&boxes[i], m.wt)
+		if w == 0 {
+			continue
+		}
+		colors = append(colors, color.RGBA{
+			uint8(clamp(wuVolume(&boxes[i], m.mr)/w, 0, 255) + 0.5),
+			uint8(clamp(wuVolume(&boxes[i], m.mg)/w, 0, 255) + 0.5),
+			uint8(clamp(wuVolume(&boxes[i], m.mb)/w, 0, 255) + 0.5),
+			255,
+		})
+	}
+	return colors
+}
+
+// wuCumulate turns a histogram into a 3D summed-volume table in place
+func wuCumulate(t []float64) {
+	area := make([]float64, wuSide)
+	for r := 1; r < wuSide; r++ {
+		for i := range area {
+			area[i] = 0
+		}
+		for g := 1; g < wuSide; g++ {
+			line := 0.0
+			for b := 1; b < wuSide; b++ {
+				line += t[wuIndex(r, g, b)]
+				area[b] += line
+				t[wuIndex(r, g, b)] = t[wuIndex(r-1, g, b)] + area[b]
+			}
+		}
+	}
+}
+
+// wuVolume sums a moment over the box
+func wuVolume(c *wuBox, t []float64) float64 {
+	return t[wuIndex(c.r1, c.g1, c.b1)] - t[wuIndex(c.r1, c.g1, c.b0)] -
+		t[wuIndex(c.r1, c.g0, c.b1)] + t[wuIndex(c.r1, c.g0, c.b0)] -
+		t[wuIndex(c.r0, c.g1, c.b1)] + t[wuIndex(c.r0, c.g1, c.b0)] +
+		t[wuIndex(c.r0, c.g0, c.b1)] - t[wuIndex(c.r0, c.g0, c.b0)]
+}
+
+// wuBottom returns the part of the box volume that does not depend on the
+// cut position along axis
+func wuBottom(c *wuBox, axis int, t []float64) float64 {
+	switch axis {
+	case 0:
+		return -t[wuIndex(c.r0, c.g1, c.b1)] + t[wuIndex(c.r0, c.g1, c.b0)] +
+			t[wuIndex(c.r0, c.g0, c.b1)] - t[wuIndex(c.r0, c.g0, c.b0)]
+	case 1:
+		return -t[wuIndex(c.r1, c.g0, c.b1)] + t[wuIndex(c.r1, c.g0, c.b0)] +
+			t[wuIndex(c.r0, c.g0, c.b1)] - t[wuIndex(c.r0, c.g0, c.b0)]
+	default:
+		return -t[wuIndex(c.r1, c.g1, c.b0)] + t[wuIndex(c.r1, c.g0, c.b0)] +
+			t[wuIndex(c.r0, c.g1, c.b0)] - t[wuIndex(c.r0, c.g0, c.b0)]
+	}
+}
+
+// wuTop returns the part of the box volume that depends on a cut at pos
+func wuTop(c *wuBox, axis, pos int, t []float64) float64 {
+	switch axis {
+	case 0:
+		return t[wuIndex(pos, c.g1, c.b1)] - t[wuIndex(pos, c.g1, c.b0)] -
+			t[wuIndex(pos, c.g0, c.b1)] + t[wuIndex(pos, c.g0, c.b0)]
+	case 1:
+		return t[wuIndex(c.r1, pos, c.b1)] - t[wuIndex(c.r1, pos, c.b0)] -
+			t[wuIndex(c.r0, pos, c.b1)] + t[wuIndex(c.r0, pos, c.b0)]
+	default:
+		return t[wuIndex(c.r1, c.g1, pos)] - t[wuIndex(c.r1, c.g0, pos)] -
+			t[wuIndex(c.r0, c.g1, pos)] + t[wuIndex(c.r0, c.g0, pos)]
+	}
+}
+
+// variance returns the summed squared error of the box around its mean
+func (m *wuMoments) variance(c *wuBox) float64 {
+	if c.vol <= 1 {
+		return 0
+	}
+	w := wuVolume(c, m.wt)
+	if w == 0 {
+		return 0
+	}
+	dr, dg, db := wuVolume(c, m.mr), wuVolume(c, m.mg), wuVolume(c, m.mb)
+	return wuVolume(c, m.m2) - (dr*dr+dg*dg+db*db)/w
+}
+
+// maximize finds the cut along axis that maximizes the between-box sum of
+// squares, returning -1 when no cut separates any pixels
+func (m *wuMoments) maximize(c *wuBox, axis, first, last int, whole [4]float64) (float64, int) {
+	baseR := wuBottom(c, axis, m.mr)
+	baseG := wuBottom(c, axis, m.mg)
+	baseB := wuBottom(c, axis, m.mb)
+	baseW := wuBottom(c, axis, m.wt)
+
+	best, cut := 0.0, -1
+	for i := first; i < last; i++ {
+		hr := baseR + wuTop(c, axis, i, m.mr)
+		hg := baseG + wuTop(c, axis, i, m.mg)
+		hb := baseB + wuTop(c, axis, i, m.mb)
+		hw := baseW + wuTop(c, axis, i, m.wt)
+		if hw == 0 {
+			continue
+		}
+		score := (hr*hr + hg*hg + hb*hb) / hw
+
+		hr, hg, hb, hw = whole[0]-hr, whole[1]-hg, whole[2]-hb, whole[3]-hw
+		if hw == 0 {
+			continue
+		}
+		score += (hr*hr + hg*hg + hb*hb) / hw
+		if score > best {
+			best, cut = score, i
+		}
+	}
+	return best, cut
+}
+
+// cut splits set1 in two, storing the upper half in set2
+func (m *wuMoments) cut(set1, set2 *wuBox) bool {
+	whole := [4]float64{wuVolume(set1, m.mr), wuVolume(set1, m.mg), wuVolume(set1, m.mb), wuVolume(set1, m.wt)}
+
+	maxR, cutR := m.maximize(set1, 0, set1.r0+1, set1.r1, whole)
+	maxG, cutG := m.maximize(set1, 1, set1.g0+1, set1.g1, whole)
+	maxB, cutB := m.maximize(set1, 2, set1.b0+1, set1.b1, whole)
+
+	set2.r1, set2.g1, set2.b1 = set1.r1, set1.g1, set1.b1
+	switch {
+	case maxR >= maxG && maxR >= maxB:
+		if cutR < 0 {
+			return false
+		}
+		set2.r0, set1.r1 = cutR, cutR
+		set2.g0, set2.b0 = set1.g0, set1.b0
+	case maxG >= maxR && maxG >= maxB:
+		if cutG < 0 {
+			return false
+		}
+		set2.g0, set1.g1 = cutG, cutG
+		set2.r0, set2.b0 = set1.r0, set1.b0
+	default:
+		if cutB < 0 {
+			return false
+		}
+		set2.b0, set1.b1 = cutB, cutB
+		set2.r0, set2.g0 = set1.r0, set1.g0
+	}
+
+	set1.vol = (set1.r1 - set1.r0) * (set1.g1 - set1.g0) * (set1.b1 - set1.b0)
+	set2.vol = (set2.r1 - set2.r0) * (set2.g1 - set2.g0) * (set2.b1 - set2.b0)
+	return true
+}
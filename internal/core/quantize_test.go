@@ -0,0 +1,92 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// fourColorImage returns an image made of four flat quadrants
+func fourColorImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	colors := []color.RGBA{{230, 20, 20, 255}, {20, 200, 40, 255}, {30, 40, 220, 255}, {240, 240, 240, 255}}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetRGBA(x, y, colors[(y/8)*2+x/8])
+		}
+	}
+	return img
+}
+
+func TestQuantizeRecoversFlatColors(t *testing.T) {
+	img := fourColorImage()
+	methods := map[string]QuantizeMethod{
+		"wu": QuantizeWu, "median cut": QuantizeMedianCut, "octree": QuantizeOctree, "k-means": QuantizeKMeans,
+	}
+	for name, method := range methods {
+		out := Quantize(img, 4, method)
+		if len(out.Palette) != 4 {
+			t.Errorf("%s: palette size = %d, want 4", name, len(out.Palette))
+			continue
+		}
+		for y := 0; y < 16; y += 5 {
+			for x := 0; x < 16; x += 5 {
+				want := img.RGBAAt(x, y)
+				got := color.RGBAModel.Convert(out.At(x, y)).(color.RGBA)
+				if got != want {
+					t.Errorf("%s: pixel (%d,%d) = %v, want %v", name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDominantColorsWeights(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for i := 0; i < 100; i++ {
+		c := color.RGBA{10, 10, 200, 255}
+		if i < 75 {
+			c = color.RGBA{200, 180, 20, 255}
+		}
+		img.SetRGBA(i%10, i/10, c)
+	}
+
+	colors := DominantColors(img, 2)
+	if len(colors) != 2 {
+		t.Fatalf("got %d colors, want 2", len(colors))
+	}
+	if math.Abs(colors[0].Weight-0.75) > 1e-9 || colors[0].Color != (color.RGBA{200, 180, 20, 255}) {
+		t.Errorf("dominant = %+v, want yellow at 0.75", colors[0])
+	}
+}
+
+func TestDitherPreservesMeanGray(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = 64
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	palette := color.Palette{color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}}
+
+	algos := map[string]DitherAlgorithm{
+		"floyd-steinberg": DitherFloydSteinberg,
+		"jjn":             DitherJarvisJudiceNinke,
+		"sierra":          DitherSierra,
+		"bayer":           DitherBayer,
+		"blue noise":      DitherBlueNoise,
+	}
+	for name, algo := range algos {
+		out := Dither(img, palette, algo)
+		white := 0
+		for _, idx := range out.Pix {
+			white += int(idx)
+		}
+		// 64/255 of the pixels should end up white
+		if frac := float64(white) / 1024; math.Abs(frac-64.0/255) > 0.03 {
+			t.Errorf("%s: white fraction = %.3f, want %.3f", name, frac, 64.0/255)
+		}
+	}
+}
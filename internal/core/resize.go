@@ -94,20 +94,21 @@ func ResizeImageWithAlgorithm(img image.Image, newWidth, newHeight int, algo Res
 	case ResizeNearestNeighbor:
 		return SIMDResize(rgba, newWidth, newHeight)
 	case ResizeBilinear:
-		return resizeBilinearRGBA(rgba, newWidth, newHeight)
+		return resizeBilinearRGBA(rgba, newWidth, newHeight, false)
 	case ResizeBicubic:
 		// TODO: implement true bicubic; fallback to bilinear for now
-		return resizeBilinearRGBA(rgba, newWidth, newHeight)
+		return resizeBilinearRGBA(rgba, newWidth, newHeight, false)
 	case ResizeLanczos:
 		// TODO: implement true Lanczos; fallback to bilinear for now
-		return resizeBilinearRGBA(rgba, newWidth, newHeight)
+		return resizeBilinearRGBA(rgba, newWidth, newHeight, false)
 	default:
-		return resizeBilinearRGBA(rgba, newWidth, newHeight)
+		return resizeBilinearRGBA(rgba, newWidth, newHeight, false)
 	}
 }
 
-// resizeBilinearRGBA performs bilinear interpolation on an RGBA image.
-func resizeBilinearRGBA(src *image.RGBA, newW, newH int) *image.RGBA {
+// resizeBilinearRGBA performs bilinear interpolation on an RGBA image,
+// optionally in linear light.
+func resizeBilinearRGBA(src *image.RGBA, newW, newH int, linear bool) *image.RGBA {
 	if newW <= 0 || newH <= 0 {
 		return src
 	}
@@ -119,6 +120,18 @@ func resizeBilinearRGBA(src *image.RGBA, newW, newH int) *image.RGBA {
 	}
 	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
 
+	at := func(x, y int) (r, g, b, a float64) {
+		c := src.RGBAAt(x, y)
+		return float64(c.R), float64(c.G), float64(c.B), float64(c.A)
+	}
+	if linear {
+		decoded := decodeLinearRGBA64(src, b)
+		at = func(x, y int) (r, g, b, a float64) {
+			c := decoded.RGBA64At(x, y)
+			return float64(c.R) / 257, float64(c.G) / 257, float64(c.B) / 257, float64(c.A) / 257
+		}
+	}
+
 	xRatio := float64(ow-1) / float64(newW)
 	yRatio := float64(oh-1) / float64(newH)
 	for y := 0; y < newH; y++ {
@@ -138,22 +151,26 @@ func resizeBilinearRGBA(src *image.RGBA, newW, newH int) *image.RGBA {
 				dx = 1
 			}
 
-			c00 := src.RGBAAt(b.Min.X+sx, b.Min.Y+sy)
-			c10 := src.RGBAAt(b.Min.X+sx+1, b.Min.Y+sy)
-			c01 := src.RGBAAt(b.Min.X+sx, b.Min.Y+sy+1)
-			c11 := src.RGBAAt(b.Min.X+sx+1, b.Min.Y+sy+1)
+			r00, g00, b00, a00 := at(b.Min.X+sx, b.Min.Y+sy)
+			r10, g10, b10, a10 := at(b.Min.X+sx+1, b.Min.Y+sy)
+			r01, g01, b01, a01 := at(b.Min.X+sx, b.Min.Y+sy+1)
+			r11, g11, b11, a11 := at(b.Min.X+sx+1, b.Min.Y+sy+1)
 
 			w00 := (1 - dx) * (1 - dy)
 			w10 := dx * (1 - dy)
 			w01 := (1 - dx) * dy
 			w11 := dx * dy
 
-			r := float64(c00.R)*w00 + float64(c10.R)*w10 + float64(c01.R)*w01 + float64(c11.R)*w11
-			g := float64(c00.G)*w00 + float64(c10.G)*w10 + float64(c01.G)*w01 + float64(c11.G)*w11
-			b := float64(c00.B)*w00 + float64(c10.B)*w10 + float64(c01.B)*w01 + float64(c11.B)*w11
-			a := float64(c00.A)*w00 + float64(c10.A)*w10 + float64(c01.A)*w01 + float64(c11.A)*w11
+			r := r00*w00 + r10*w10 + r01*w01 + r11*w11
+			g := g00*w00 + g10*w10 + g01*w01 + g11*w11
+			b := b00*w00 + b10*w10 + b01*w01 + b11*w11
+			a := a00*w00 + a10*w10 + a01*w01 + a11*w11
 
-			dst.SetRGBA(x, y, colorFromFloats(r, g, b, a))
+			if linear {
+				dst.SetRGBA(x, y, encodeLinear255(r, g, b, a))
+			} else {
+				dst.SetRGBA(x, y, colorFromFloats(r, g, b, a))
+			}
 		}
 	}
 	return dst
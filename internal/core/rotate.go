@@ -0,0 +1,221 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+// Arbitrary-angle rotation and skew correction
+
+// RotateMode controls the size of a rotated image
+type RotateMode int
+
+const (
+	// RotateExpand grows the canvas so the whole rotated image fits
+	RotateExpand RotateMode = iota
+	// RotateCrop crops to the largest upright rectangle that holds only
+	// image pixels, so no background shows
+	RotateCrop
+	// RotateKeepSize keeps the original size, clipping the corners
+	RotateKeepSize
+)
+
+// RotateOptions configures RotateImageWithOptions
+type RotateOptions struct {
+	Mode    RotateMode
+	Sampler Sampler
+	// Background fills the uncovered corners; transparent when nil
+	Background color.Color
+}
+
+// RotateImage rotates img clockwise by angle radians about its center,
+// expanding the canvas to fit and leaving the corners transparent
+func RotateImage(img image.Image, angle float64) *image.RGBA {
+	return RotateImageWithOptions(img, angle, RotateOptions{})
+}
+
+// RotateImageWithOptions rotates img clockwise by angle radians about its
+// center. The result starts at the origin.
+func RotateImageWithOptions(img image.Image, angle float64, opts RotateOptions) *image.RGBA {
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	sin, cos := math.Sincos(angle)
+	// Snap exact quarter turns so they stay lossless
+	if math.Abs(sin) < 1e-12 {
+		sin = 0
+	}
+	if math.Abs(cos) < 1e-12 {
+		cos = 0
+	}
+
+	var size image.Point
+	edge := EdgeTransparent
+	switch opts.Mode {
+	case RotateCrop:
+		cw, ch := largestRotatedRect(w, h, sin, cos)
+		size = image.Pt(int(cw+1e-6), int(ch+1e-6))
+		// Every sample lies inside the source, so only the filter taps at
+		// the very edge reach past it
+		edge = EdgeClamp
+	case RotateKeepSize:
+		size = b.Size()
+	default:
+		size = image.Pt(
+			int(math.Ceil(math.Abs(w*cos)+math.Abs(h*sin)-1e-6)),
+			int(math.Ceil(math.Abs(w*sin)+math.Abs(h*cos)-1e-6)),
+		)
+	}
+	if size.X <= 0 || size.Y <= 0 {
+		return image.NewRGBA(image.Rectangle{})
+	}
+
+	ox, oy := float64(size.X)/2, float64(size.Y)/2
+	result := WarpWithOptions(img, func(x, y float64) (float64, float64) {
+		dx, dy := x-ox, y-oy
+		return cos*dx + sin*dy + w/2, -sin*dx + cos*dy + h/2
+	}, WarpOptions{Sampler: opts.Sampler, Edge: edge, Size: size})
+
+	if opts.Background != nil {
+		dst := image.NewRGBA(result.Rect)
+		draw.Draw(dst, dst.Rect, image.NewUniform(opts.Background), image.Point{}, draw.Src)
+		draw.Draw(dst, dst.Rect, result, image.Point{}, draw.Over)
+		result = dst
+	}
+	return result
+}
+
+// largestRotatedRect returns the size of the largest upright rectangle
+// inside a w x h rectangle rotated by an angle with the given sine and cosine
+func largestRotatedRect(w, h, sin, cos float64) (float64, float64) {
+	if w <= 0 || h <= 0 {
+		return 0, 0
+	}
+	sin, cos = math.Abs(sin), math.Abs(cos)
+	long, short := math.Max(w, h), math.Min(w, h)
+	if short <= 2*sin*cos*long || math.Abs(sin-cos) < 1e-10 {
+		// Two corners of the crop touch the longer side
+		x := short / 2
+		if w >= h {
+			return x / sin, x / cos
+		}
+		return x / cos, x / sin
+	}
+	cos2 := cos*cos - sin*sin
+	return (w*cos - h*sin) / cos2, (h*cos - w*sin) / cos2
+}
+
+// EstimateSkew returns the clockwise rotation, in radians, of the text lines
+// in a scanned page, searching up to maxAngle (15 degrees when not
+// positive) either way. It maximises the sharpness of the horizontal
+// projection profile of the dark pixels.
+func EstimateSkew(img image.Image, maxAngle float64) float64 {
+	if maxAngle <= 0 {
+		maxAngle = Radians(15)
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+	lum := luminancePlane(img)
+
+	// Ink is anything clearly darker than the average page
+	var mean float64
+	for _, v := range lum {
+		mean += v
+	}
+	mean /= float64(len(lum))
+	threshold := mean * 0.6
+	step := max(1, max(w, h)/800)
+	var ink [][2]float64
+	for y := 0; y < h; y += step {
+		for x := 0; x < w; x += step {
+			if lum[y*w+x] < threshold {
+				ink = append(ink, [2]float64{float64(x) - float64(w)/2, float64(y) - float64(h)/2})
+			}
+		}
+	}
+	if len(ink) == 0 {
+		return 0
+	}
+
+	diag := math.Hypot(float64(w), float64(h))
+	bins := make([]float64, int(diag)+2)
+	score := func(angle float64) float64 {
+		sin, cos := math.Sincos(angle)
+		for i := range bins {
+			bins[i] = 0
+		}
+		for _, p := range ink {
+			row := -sin*p[0] + cos*p[1] + diag/2
+			bins[clampInt(int(row), 0, len(bins)-1)]++
+		}
+		var s float64
+		for _, v := range bins {
+			s += v * v
+		}
+		return s
+	}
+
+	// Coarse scan, then successively finer scans around the best angle
+	best, bestScore := 0.0, score(0)
+	span, delta := maxAngle, Radians(0.5)
+	for delta > Radians(0.01) {
+		center := best
+		for a := center - span; a <= center+span+1e-12; a += delta {
+			if math.Abs(a) > maxAngle {
+				continue
+			}
+			if s := score(a); s > bestScore {
+				best, bestScore = a, s
+			}
+		}
+		span, delta = delta, delta/5
+	}
+	return best
+}
+
+// Deskew straightens a scanned page. It estimates the skew with
+// EstimateSkew and rotates the page back, keeping its size and filling
+// the corners with the median border color.
+func Deskew(img image.Image) *image.RGBA {
+	angle := EstimateSkew(img, 0)
+	return RotateImageWithOptions(img, -angle, RotateOptions{
+		Mode:       RotateKeepSize,
+		Sampler:    SamplerBicubic,
+		Background: borderColor(asRGBA(img)),
+	})
+}
+
+// borderColor returns the per-channel median of the border pixels
+func borderColor(src *image.RGBA) color.RGBA {
+	b := src.Bounds()
+	var ch [4][]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if x == b.Min.X || y == b.Min.Y || x == b.Max.X-1 || y == b.Max.Y-1 {
+				i := src.PixOffset(x, y)
+				for c := range ch {
+					ch[c] = append(ch[c], int(src.Pix[i+c]))
+				}
+			}
+		}
+	}
+	if len(ch[0]) == 0 {
+		return color.RGBA{}
+	}
+	var out [4]uint8
+	for c := range ch {
+		sort.Ints(ch[c])
+		out[c] = uint8(ch[c][len(ch[c])/2])
+	}
+	return color.RGBA{out[0], out[1], out[2], out[3]}
+}
+
+// Rotate rotates the pixel data clockwise by angle radians about its center
+func (id *ImageData) Rotate(angle float64, opts RotateOptions) *ImageData {
+	return id.withFormat(RotateImageWithOptions(id.Image(), angle, opts))
+}
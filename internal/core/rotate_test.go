@@ -0,0 +1,86 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestRotateImageQuarterTurn(t *testing.T) {
+	img := gradientImage(30, 20)
+	out := RotateImage(img, Radians(90))
+	if out.Bounds() != image.Rect(0, 0, 20, 30) {
+		t.Fatalf("bounds = %v, want 20x30", out.Bounds())
+	}
+	// Clockwise: the top-left source pixel ends up at the top right
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 20; x++ {
+			if got, want := out.RGBAAt(x, y), img.RGBAAt(y, 19-x); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestRotateImageModes(t *testing.T) {
+	img := gradientImage(100, 50)
+	angle := Radians(30)
+
+	expanded := RotateImage(img, angle)
+	wantW := int(math.Ceil(100*math.Cos(angle) + 50*math.Sin(angle)))
+	wantH := int(math.Ceil(100*math.Sin(angle) + 50*math.Cos(angle)))
+	if expanded.Bounds().Dx() != wantW || expanded.Bounds().Dy() != wantH {
+		t.Errorf("expanded size = %v, want %dx%d", expanded.Bounds().Size(), wantW, wantH)
+	}
+	if expanded.RGBAAt(0, 0).A != 0 {
+		t.Error("expanded corner should be transparent")
+	}
+	if expanded.RGBAAt(wantW/2, wantH/2).A != 255 {
+		t.Error("expanded center should be opaque")
+	}
+
+	cropped := RotateImageWithOptions(img, angle, RotateOptions{Mode: RotateCrop, Sampler: SamplerLanczos})
+	if cropped.Bounds().Dx() < 30 || cropped.Bounds().Dy() < 15 {
+		t.Errorf("cropped size %v is too small", cropped.Bounds().Size())
+	}
+	for i := 3; i < len(cropped.Pix); i += 4 {
+		if cropped.Pix[i] < 250 {
+			t.Fatalf("cropped result shows background at pixel %d", i/4)
+		}
+	}
+
+	kept := RotateImageWithOptions(img, angle, RotateOptions{Mode: RotateKeepSize, Background: color.White})
+	if kept.Bounds() != img.Bounds() {
+		t.Errorf("kept size = %v", kept.Bounds())
+	}
+	if kept.RGBAAt(0, 0) != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("corner = %v, want the background color", kept.RGBAAt(0, 0))
+	}
+}
+
+func TestDeskew(t *testing.T) {
+	// A page of text lines, skewed clockwise by 3 degrees
+	dc := NewContext(300, 200)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
+	dc.RotateAbout(Radians(3), 150, 100)
+	for y := 30.0; y < 180; y += 16 {
+		for x := 30.0; x < 260; x += 23 {
+			dc.DrawRectangle(x, y, 18, 7)
+		}
+	}
+	dc.Fill()
+
+	if skew := Degrees(EstimateSkew(dc.Image(), 0)); math.Abs(skew-3) > 0.15 {
+		t.Errorf("estimated skew = %.2f degrees, want 3", skew)
+	}
+	straight := Deskew(dc.Image())
+	if skew := Degrees(EstimateSkew(straight, 0)); math.Abs(skew) > 0.15 {
+		t.Errorf("skew after Deskew = %.2f degrees", skew)
+	}
+	if straight.RGBAAt(0, 0) != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("corner = %v, want the page color", straight.RGBAAt(0, 0))
+	}
+}
@@ -0,0 +1,356 @@
+package core
+
+import (
+	"image"
+	"math"
+)
+
+// Content-aware resizing by seam carving (Avidan & Shamir)
+
+// SeamEnergy selects the energy function used to rank seams
+type SeamEnergy int
+
+const (
+	// SeamEnergyGradient removes the seam with the lowest gradient magnitude
+	SeamEnergyGradient SeamEnergy = iota
+	// SeamEnergyForward removes the seam that introduces the least new
+	// gradient once its neighbors are joined (Rubinstein et al.), which
+	// produces fewer jagged artifacts
+	SeamEnergyForward
+)
+
+// maskEnergy is the energy added or removed by a fully opaque mask pixel
+const maskEnergy = 1e6
+
+// ContentAwareOptions configures ResizeContentAware and RemoveObject
+type ContentAwareOptions struct {
+	Energy SeamEnergy
+	// Protect marks pixels that seams should avoid
+	Protect *image.Alpha
+	// Remove marks pixels that seams should pass through first
+	Remove *image.Alpha
+}
+
+// ResizeContentAware resizes the image to width x height by removing or
+// inserting low-energy seams, preserving the salient content instead of
+// scaling it. Width is changed first, then height.
+func ResizeContentAware(img image.Image, width, height int, opts ContentAwareOptions) image.Image {
+	src := asRGBA(img)
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	if src.Bounds().Empty() {
+		return image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+
+	forward := opts.Energy == SeamEnergyForward
+	c := newSeamCarver(src, maskBias(src.Bounds(), opts.Protect, opts.Remove), forward)
+	c = c.resizeWidth(width)
+	if height != c.h {
+		c = c.transposed().resizeWidth(height).transposed()
+	}
+	return c.image()
+}
+
+// RemoveObject erases the masked region by carving seams through it until
+// no masked pixel remains, then inserts seams to restore the original size.
+// Pixels in opts.Protect are avoided.
+func RemoveObject(img image.Image, mask *image.Alpha, opts ContentAwareOptions) image.Image {
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	if bounds.Empty() || mask == nil {
+		return cloneImage(src)
+	}
+
+	c := newSeamCarver(src, maskBias(bounds, opts.Protect, mask), opts.Energy == SeamEnergyForward)
+
+	// Carve across the narrower dimension of the object
+	minX, minY, maxX, maxY := bounds.Dx(), bounds.Dy(), -1, -1
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if c.bias[y*c.stride+x] < 0 {
+				minX, maxX = min(minX, x), max(maxX, x)
+				minY, maxY = min(minY, y), max(maxY, y)
+			}
+		}
+	}
+	if maxX < 0 {
+		return cloneImage(src)
+	}
+	transpose := maxY-minY < maxX-minX
+	if transpose {
+		c = c.transposed()
+	}
+
+	target := c.w
+	for c.w > 1 && c.hasRemovalPixels() {
+		c.removeSeam(c.findSeam())
+	}
+	c = c.resizeWidth(target)
+
+	if transpose {
+		c = c.transposed()
+	}
+	return c.image()
+}
+
+// seamCarver holds the working image with a fixed row stride so seams can be
+// removed in place
+type seamCarver struct {
+	w, h    int
+	stride  int
+	pix     []uint8   // premultiplied RGBA, stride*4 bytes per row
+	lum     []float64 // luma per pixel
+	bias    []float64 // mask energy per pixel
+	forward bool
+}
+
+// maskBias converts protect and remove masks into per-pixel energy offsets
+func maskBias(bounds image.Rectangle, protect, remove *image.Alpha) []float64 {
+	w, h := bounds.Dx(), bounds.Dy()
+	bias := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := image.Point{bounds.Min.X + x, bounds.Min.Y + y}
+			var b float64
+			if protect != nil && p.In(protect.Rect) {
+				b += maskEnergy * float64(protect.AlphaAt(p.X, p.Y).A) / 255
+			}
+			if remove != nil && p.In(remove.Rect) {
+				b -= maskEnergy * float64(remove.AlphaAt(p.X, p.Y).A) / 255
+			}
+			bias[y*w+x] = b
+		}
+	}
+	return bias
+}
+
+func newSeamCarver(img *image.RGBA, bias []float64, forward bool) *seamCarver {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	c := &seamCarver{
+		w: w, h: h, stride: w,
+		pix:     make([]uint8, w*h*4),
+		lum:     make([]float64, w*h),
+		bias:    bias,
+		forward: forward,
+	}
+	for y := 0; y < h; y++ {
+		copy(c.pix[y*w*4:(y+1)*w*4], img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):])
+	}
+	for i := 0; i < w*h; i++ {
+		c.lum[i] = 0.299*float64(c.pix[i*4]) + 0.587*float64(c.pix[i*4+1]) + 0.114*float64(c.pix[i*4+2])
+	}
+	return c
+}
+
+// image copies the current state into a new RGBA image
+func (c *seamCarver) image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, c.w, c.h))
+	for y := 0; y < c.h; y++ {
+		copy(img.Pix[y*img.Stride:y*img.Stride+c.w*4], c.pix[y*c.stride*4:])
+	}
+	return img
+}
+
+// transposed returns a compact copy with rows and columns swapped
+func (c *seamCarver) transposed() *seamCarver {
+	t := &seamCarver{
+		w: c.h, h: c.w, stride: c.h,
+		pix:     make([]uint8, c.w*c.h*4),
+		lum:     make([]float64, c.w*c.h),
+		bias:    make([]float64, c.w*c.h),
+		forward: c.forward,
+	}
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			si, di := y*c.stride+x, x*t.stride+y
+			copy(t.pix[di*4:di*4+4], c.pix[si*4:si*4+4])
+			t.lum[di] = c.lum[si]
+			t.bias[di] = c.bias[si]
+		}
+	}
+	return t
+}
+
+// resizeWidth removes or inserts vertical seams until the width matches
+func (c *seamCarver) resizeWidth(width int) *seamCarver {
+	for c.w > width {
+		c.removeSeam(c.findSeam())
+	}
+	for c.w < width {
+		// Insert at most half the width per pass so the same seams are not
+		// duplicated over and over
+		n := min(width-c.w, max(1, c.w/2))
+		c = c.insertSeams(n)
+	}
+	return c
+}
+
+func (c *seamCarver) hasRemovalPixels() bool {
+	for y := 0; y < c.h; y++ {
+		for _, b := range c.bias[y*c.stride : y*c.stride+c.w] {
+			if b < 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gradientEnergy returns the L1 gradient magnitude of the luma plus bias
+func (c *seamCarver) gradientEnergy() []float64 {
+	energy := make([]float64, c.w*c.h)
+	parallelRows(c.h, 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			up, down := max(y-1, 0), min(y+1, c.h-1)
+			for x := 0; x < c.w; x++ {
+				left, right := max(x-1, 0), min(x+1, c.w-1)
+				dx := c.lum[y*c.stride+right] - c.lum[y*c.stride+left]
+				dy := c.lum[down*c.stride+x] - c.lum[up*c.stride+x]
+				energy[y*c.w+x] = math.Abs(dx) + math.Abs(dy) + c.bias[y*c.stride+x]
+			}
+		}
+	})
+	return energy
+}
+
+// findSeam returns the column of the minimum-energy vertical seam in each row
+func (c *seamCarver) findSeam() []int {
+	w, h := c.w, c.h
+	cost := make([]float64, w*h)
+	from := make([]int8, w*h)
+
+	var energy []float64
+	if !c.forward {
+		energy = c.gradientEnergy()
+		copy(cost[:w], energy[:w])
+	} else {
+		for x := 0; x < w; x++ {
+			cost[x] = c.bias[x]
+		}
+	}
+
+	for y := 1; y < h; y++ {
+		row, prev := y*w, (y-1)*w
+		for x := 0; x < w; x++ {
+			var cl, cu, cr, base float64
+			if c.forward {
+				li, ri := y*c.stride+max(x-1, 0), y*c.stride+min(x+1, w-1)
+				up := c.lum[(y-1)*c.stride+x]
+				cu = math.Abs(c.lum[ri] - c.lum[li])
+				cl = cu + math.Abs(up-c.lum[li])
+				cr = cu + math.Abs(up-c.lum[ri])
+				base = c.bias[y*c.stride+x]
+			} else {
+				base = energy[row+x]
+			}
+
+			best, dir := cost[prev+x]+cu, int8(0)
+			if x > 0 && cost[prev+x-1]+cl < best {
+				best, dir = cost[prev+x-1]+cl, -1
+			}
+			if x < w-1 && cost[prev+x+1]+cr < best {
+				best, dir = cost[prev+x+1]+cr, 1
+			}
+			cost[row+x] = base + best
+			from[row+x] = dir
+		}
+	}
+
+	seam := make([]int, h)
+	last := (h - 1) * w
+	for x := 1; x < w; x++ {
+		if cost[last+x] < cost[last+seam[h-1]] {
+			seam[h-1] = x
+		}
+	}
+	for y := h - 1; y > 0; y-- {
+		seam[y-1] = seam[y] + int(from[y*w+seam[y]])
+	}
+	return seam
+}
+
+// removeSeam deletes one pixel per row, shifting the rest of the row left
+func (c *seamCarver) removeSeam(seam []int) {
+	for y, x := range seam {
+		row := y * c.stride
+		copy(c.pix[(row+x)*4:(row+c.w-1)*4], c.pix[(row+x+1)*4:(row+c.w)*4])
+		copy(c.lum[row+x:row+c.w-1], c.lum[row+x+1:row+c.w])
+		copy(c.bias[row+x:row+c.w-1], c.bias[row+x+1:row+c.w])
+	}
+	c.w--
+}
+
+// insertSeams finds the n lowest seams on a scratch copy and duplicates each
+// of them in the original, blending the new pixel with its right neighbor
+func (c *seamCarver) insertSeams(n int) *seamCarver {
+	scratch := c.compact()
+	// orig tracks which column of c each scratch pixel came from
+	orig := make([]int, scratch.w*scratch.h)
+	for i := range orig {
+		orig[i] = i % scratch.w
+	}
+	dup := make([]bool, c.w*c.h)
+	for i := 0; i < n; i++ {
+		seam := scratch.findSeam()
+		for y, x := range seam {
+			row := y * scratch.stride
+			dup[y*c.w+orig[row+x]] = true
+			copy(orig[row+x:row+scratch.w-1], orig[row+x+1:row+scratch.w])
+		}
+		scratch.removeSeam(seam)
+	}
+
+	width := c.w + n
+	out := &seamCarver{
+		w: width, h: c.h, stride: width,
+		pix:     make([]uint8, width*c.h*4),
+		lum:     make([]float64, width*c.h),
+		bias:    make([]float64, width*c.h),
+		forward: c.forward,
+	}
+	for y := 0; y < c.h; y++ {
+		dx := 0
+		for x := 0; x < c.w; x++ {
+			si, di := y*c.stride+x, y*width+dx
+			copy(out.pix[di*4:di*4+4], c.pix[si*4:si*4+4])
+			out.lum[di] = c.lum[si]
+			out.bias[di] = c.bias[si]
+			dx++
+			if !dup[y*c.w+x] {
+				continue
+			}
+
+			ni := y*c.stride + min(x+1, c.w-1)
+			di++
+			for k := 0; k < 4; k++ {
+				out.pix[di*4+k] = uint8((uint16(c.pix[si*4+k]) + uint16(c.pix[ni*4+k]) + 1) / 2)
+			}
+			out.lum[di] = (c.lum[si] + c.lum[ni]) / 2
+			out.bias[di] = c.bias[si]
+			dx++
+		}
+	}
+	return out
+}
+
+// compact returns a copy whose stride equals its width
+func (c *seamCarver) compact() *seamCarver {
+	out := &seamCarver{
+		w: c.w, h: c.h, stride: c.w,
+		pix:     make([]uint8, c.w*c.h*4),
+		lum:     make([]float64, c.w*c.h),
+		bias:    make([]float64, c.w*c.h),
+		forward: c.forward,
+	}
+	for y := 0; y < c.h; y++ {
+		copy(out.pix[y*c.w*4:(y+1)*c.w*4], c.pix[y*c.stride*4:])
+		copy(out.lum[y*c.w:(y+1)*c.w], c.lum[y*c.stride:])
+		copy(out.bias[y*c.w:(y+1)*c.w], c.bias[y*c.stride:])
+	}
+	return out
+}
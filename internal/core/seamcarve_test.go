@@ -0,0 +1,78 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// subjectImage draws a textured red square on a flat gray background. The
+// texture matters for forward energy, which can narrow flat regions for free.
+func subjectImage(w, h int, subject image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{128, 128, 128, 255}
+			if (image.Point{x, y}).In(subject) {
+				c = color.RGBA{uint8(205 + (x*37+y*11)%50), 30, 30, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func countRed(img image.Image) int {
+	n := 0
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r, g, _, _ := img.At(x, y).RGBA(); r>>8 > 200 && g>>8 < 60 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestResizeContentAwareKeepsSubject(t *testing.T) {
+	img := subjectImage(40, 20, image.Rect(15, 5, 25, 15))
+	for _, energy := range []SeamEnergy{SeamEnergyGradient, SeamEnergyForward} {
+		out := ResizeContentAware(img, 24, 16, ContentAwareOptions{Energy: energy})
+		if b := out.Bounds(); b.Dx() != 24 || b.Dy() != 16 {
+			t.Fatalf("energy %d: size = %v, want 24x16", energy, b)
+		}
+		if n := countRed(out); n != 100 {
+			t.Errorf("energy %d: subject pixels = %d, want 100", energy, n)
+		}
+	}
+}
+
+func TestResizeContentAwareEnlarge(t *testing.T) {
+	img := subjectImage(20, 10, image.Rect(8, 2, 12, 8))
+	out := ResizeContentAware(img, 35, 10, ContentAwareOptions{})
+	if b := out.Bounds(); b.Dx() != 35 || b.Dy() != 10 {
+		t.Fatalf("size = %v, want 35x10", b)
+	}
+	if n := countRed(out); n != 24 {
+		t.Errorf("subject pixels = %d, want 24", n)
+	}
+}
+
+func TestRemoveObject(t *testing.T) {
+	img := subjectImage(30, 20, image.Rect(10, 4, 14, 16))
+	mask := image.NewAlpha(img.Bounds())
+	for y := 4; y < 16; y++ {
+		for x := 10; x < 14; x++ {
+			mask.SetAlpha(x, y, color.Alpha{255})
+		}
+	}
+
+	out := RemoveObject(img, mask, ContentAwareOptions{})
+	if out.Bounds() != img.Bounds() {
+		t.Fatalf("bounds = %v, want %v", out.Bounds(), img.Bounds())
+	}
+	if n := countRed(out); n != 0 {
+		t.Errorf("%d object pixels remain", n)
+	}
+}
@@ -0,0 +1,422 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Region fills and soft pixel selections
+
+// Connectivity selects which neighbours a region fill spreads to
+type Connectivity int
+
+const (
+	// Connectivity4 spreads to horizontal and vertical neighbours only
+	Connectivity4 Connectivity = 4
+	// Connectivity8 also spreads across diagonals
+	Connectivity8 Connectivity = 8
+)
+
+// Selection is a soft pixel selection. Mask holds the selection strength of
+// every pixel, 255 being fully selected, and can be passed directly to
+// Context.SetMask or used as a layer mask.
+type Selection struct {
+	Mask *image.Alpha
+}
+
+// NewSelection creates an empty selection
+func NewSelection(width, height int) *Selection {
+	return &Selection{Mask: image.NewAlpha(image.Rect(0, 0, width, height))}
+}
+
+// SelectionFromMask creates a selection from a copy of mask, moved to the origin
+func SelectionFromMask(mask *image.Alpha) *Selection {
+	b := mask.Bounds()
+	s := NewSelection(b.Dx(), b.Dy())
+	draw.Draw(s.Mask, s.Mask.Rect, mask, b.Min, draw.Src)
+	return s
+}
+
+// SelectAll creates a selection covering every pixel
+func SelectAll(width, height int) *Selection {
+	s := NewSelection(width, height)
+	for i := range s.Mask.Pix {
+		s.Mask.Pix[i] = 255
+	}
+	return s
+}
+
+// SelectRect creates a selection of the pixels inside r
+func SelectRect(width, height int, r image.Rectangle) *Selection {
+	s := NewSelection(width, height)
+	draw.Draw(s.Mask, r, image.Opaque, image.Point{}, draw.Src)
+	return s
+}
+
+// SelectPath creates an antialiased selection of the area the path encloses,
+// as it would be filled with the nonzero winding rule. Open subpaths are
+// closed implicitly, so freehand lasso strokes work as drawn.
+func SelectPath(width, height int, path *Path2D) *Selection {
+	dc := NewContext(width, height)
+	dc.DrawPath2D(path)
+	dc.SetRGB(1, 1, 1)
+	dc.Fill()
+	return &Selection{Mask: dc.AsMask()}
+}
+
+// SelectPolygon creates an antialiased selection of the polygon through points
+func SelectPolygon(width, height int, points []Point) *Selection {
+	path := NewPath2D()
+	for i, p := range points {
+		if i == 0 {
+			path.MoveTo(p.X, p.Y)
+		} else {
+			path.LineTo(p.X, p.Y)
+		}
+	}
+	path.ClosePath()
+	return SelectPath(width, height, path)
+}
+
+// MagicWand selects the region connected to (x, y) whose colors are within
+// tolerance of the color at (x, y). Tolerance is the largest allowed
+// difference of any RGBA channel in 0..1; 0 selects only exact matches.
+func MagicWand(img image.Image, x, y int, tolerance float64, connectivity Connectivity) *Selection {
+	src := asRGBA(img)
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	s := NewSelection(w, h)
+	if x < 0 || x >= w || y < 0 || y >= h {
+		return s
+	}
+	region := colorRegion(w, h, x, y, tolerance, connectivity, func(x, y int) [4]uint8 {
+		r, g, b2, a := straightAt(src, b.Min.X+x, b.Min.Y+y)
+		return [4]uint8{r, g, b2, a}
+	})
+	for i, in := range region {
+		if in {
+			s.Mask.Pix[i] = 255
+		}
+	}
+	return s
+}
+
+// SelectColorRange selects every pixel whose color is within tolerance of c,
+// whether or not it is connected to other matches
+func SelectColorRange(img image.Image, c color.Color, tolerance float64) *Selection {
+	src := asRGBA(img)
+	b := src.Bounds()
+	s := NewSelection(b.Dx(), b.Dy())
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	key := [4]uint8{n.R, n.G, n.B, n.A}
+	limit := tolerance * 255
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, a := straightAt(src, b.Min.X+x, b.Min.Y+y)
+			if colorDistance([4]uint8{r, g, bl, a}, key) <= limit {
+				s.Mask.Pix[y*s.Mask.Stride+x] = 255
+			}
+		}
+	}
+	return s
+}
+
+// Clone returns a copy of the selection
+func (s *Selection) Clone() *Selection {
+	return SelectionFromMask(s.Mask)
+}
+
+// Contains reports whether the pixel at (x, y) is at least half selected
+func (s *Selection) Contains(x, y int) bool {
+	return s.Mask.AlphaAt(x, y).A >= 128
+}
+
+// Bounds returns the smallest rectangle containing every selected pixel
+func (s *Selection) Bounds() image.Rectangle {
+	var r image.Rectangle
+	b := s.Mask.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if s.Mask.AlphaAt(x, y).A != 0 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+// IsEmpty reports whether no pixel is selected
+func (s *Selection) IsEmpty() bool {
+	for _, a := range s.Mask.Pix {
+		if a != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Invert returns a selection of everything s does not select
+func (s *Selection) Invert() *Selection {
+	out := s.Clone()
+	for i, a := range out.Mask.Pix {
+		out.Mask.Pix[i] = 255 - a
+	}
+	return out
+}
+
+// Add returns the union of s and other
+func (s *Selection) Add(other *Selection) *Selection {
+	return s.combine(other, func(a, b uint32) uint32 { return max(a, b) })
+}
+
+// Subtract returns s with the pixels of other removed
+func (s *Selection) Subtract(other *Selection) *Selection {
+	return s.combine(other, func(a, b uint32) uint32 { return (a*(255-b) + 127) / 255 })
+}
+
+// Intersect returns the pixels selected by both s and other
+func (s *Selection) Intersect(other *Selection) *Selection {
+	return s.combine(other, func(a, b uint32) uint32 { return uint32(min(int(a), int(b))) })
+}
+
+// combine merges other into a copy of s pixel by pixel. Pixels outside
+// other count as unselected.
+func (s *Selection) combine(other *Selection, op func(a, b uint32) uint32) *Selection {
+	out := s.Clone()
+	for y := 0; y < out.Mask.Rect.Dy(); y++ {
+		for x := 0; x < out.Mask.Rect.Dx(); x++ {
+			i := y*out.Mask.Stride + x
+			b := other.Mask.AlphaAt(other.Mask.Rect.Min.X+x, other.Mask.Rect.Min.Y+y).A
+			out.Mask.Pix[i] = uint8(op(uint32(out.Mask.Pix[i]), uint32(b)))
+		}
+	}
+	return out
+}
+
+// Feather returns the selection with its edges softened over roughly
+// radius pixels on either side
+func (s *Selection) Feather(radius float64) *Selection {
+	out := s.Clone()
+	if radius <= 0 {
+		return out
+	}
+	w, h := out.Mask.Rect.Dx(), out.Mask.Rect.Dy()
+	plane := make([]float64, w*h)
+	for i, a := range out.Mask.Pix {
+		plane[i] = float64(a)
+	}
+	blurred := gaussianPlane(plane, w, h, gaussianKernel(radius/2))
+	for i, v := range blurred {
+		out.Mask.Pix[i] = clampUint8(math.Round(v))
+	}
+	return out
+}
+
+// Grow returns the selection expanded outwards by px pixels with rounded,
+// antialiased corners. Negative values shrink it.
+func (s *Selection) Grow(px float64) *Selection {
+	if px < 0 {
+		return s.Shrink(-px)
+	}
+	out := s.Clone()
+	// Distance from every pixel to the nearest selected one
+	outside := image.NewAlpha(out.Mask.Rect)
+	for i, a := range out.Mask.Pix {
+		if a < 128 {
+			outside.Pix[i] = 255
+		}
+	}
+	df := DistanceTransform(outside)
+	for i, d := range df.Data {
+		out.Mask.Pix[i] = max(out.Mask.Pix[i], uint8(clamp(px+1-d, 0, 1)*255+0.5))
+	}
+	return out
+}
+
+// Shrink returns the selection contracted inwards by px pixels. The image
+// border does not count as an edge, so a full selection stays full.
+func (s *Selection) Shrink(px float64) *Selection {
+	if px < 0 {
+		return s.Grow(-px)
+	}
+	out := s.Clone()
+	inside := image.NewAlpha(out.Mask.Rect)
+	for i, a := range out.Mask.Pix {
+		if a >= 128 {
+			inside.Pix[i] = 255
+		}
+	}
+	df := DistanceTransform(inside)
+	for i, d := range df.Data {
+		out.Mask.Pix[i] = uint8(min(int(out.Mask.Pix[i]), int(clamp(d-px, 0, 1)*255+0.5)))
+	}
+	return out
+}
+
+// FilterInSelection returns a Filter that applies f only inside the
+// selection, blending its result with the original by the selection
+// strength. f must preserve the image size.
+func FilterInSelection(f Filter, s *Selection) Filter {
+	return func(img image.Image) image.Image {
+		src := asRGBA(img)
+		filtered := asRGBA(f(img))
+		b := src.Bounds()
+		fb := filtered.Bounds()
+		dst := image.NewRGBA(b)
+		parallelRows(b.Dy(), 0, func(start, end int) {
+			for y := start; y < end; y++ {
+				for x := 0; x < b.Dx(); x++ {
+					si := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+					di := dst.PixOffset(b.Min.X+x, b.Min.Y+y)
+					a := uint32(s.Mask.AlphaAt(s.Mask.Rect.Min.X+x, s.Mask.Rect.Min.Y+y).A)
+					p := image.Point{fb.Min.X + x, fb.Min.Y + y}
+					if a == 0 || !p.In(fb) {
+						copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+						continue
+					}
+					fi := filtered.PixOffset(p.X, p.Y)
+					for c := 0; c < 4; c++ {
+						dst.Pix[di+c] = uint8((uint32(src.Pix[si+c])*(255-a) + uint32(filtered.Pix[fi+c])*a + 127) / 255)
+					}
+				}
+			}
+		})
+		return dst
+	}
+}
+
+// FloodFill replaces the region connected to (x, y) whose colors are within
+// tolerance of the color at (x, y) with c. Tolerance is the largest allowed
+// difference of any RGBA channel in 0..1.
+func (id *ImageData) FloodFill(x, y int, c color.Color, tolerance float64, connectivity Connectivity) {
+	if x < 0 || x >= id.Width || y < 0 || y >= id.Height {
+		return
+	}
+	deep := id.Format != FormatRGBA8
+	region := colorRegion(id.Width, id.Height, x, y, tolerance, connectivity, func(x, y int) [4]uint8 {
+		if deep {
+			r, g, b, a := id.GetPixel(x, y)
+			return [4]uint8{r, g, b, a}
+		}
+		i := (y*id.Width + x) * 4
+		return [4]uint8{id.Data[i], id.Data[i+1], id.Data[i+2], id.Data[i+3]}
+	})
+	r, g, b, a := c.RGBA()
+	fill := [4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+	for i, in := range region {
+		if !in {
+			continue
+		}
+		if deep {
+			id.SetPixelFloat(i%id.Width, i/id.Width, float32(r)/0xffff, float32(g)/0xffff, float32(b)/0xffff, float32(a)/0xffff)
+			continue
+		}
+		copy(id.Data[i*4:i*4+4], fill[:])
+	}
+}
+
+// FloodFill replaces the region connected to (x, y) whose colors are within
+// tolerance of the color at (x, y) with c, respecting the clipping mask.
+// Tolerance is the largest allowed difference of any RGBA channel in 0..1.
+func (dc *Context) FloodFill(x, y int, c color.Color, tolerance float64, connectivity Connectivity) {
+	sel := MagicWand(dc.Image(), x, y, tolerance, connectivity)
+	if dc.mask != nil {
+		sel = sel.Intersect(&Selection{Mask: dc.mask})
+	}
+	r, g, b, a := c.RGBA()
+	fill := [4]uint32{r >> 8, g >> 8, b >> 8, a >> 8}
+	deepFill := [4]float32{float32(r) / 0xffff, float32(g) / 0xffff, float32(b) / 0xffff, float32(a) / 0xffff}
+	bounds := dc.im.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// Blend by the selection strength so pixels outside it keep their color
+			m := uint32(sel.Mask.AlphaAt(x, y).A)
+			if m == 0 {
+				continue
+			}
+			if dc.deep != nil {
+				k := float32(m) / 255
+				p := dc.deep.pixel(x, y)
+				for ch := range p {
+					p[ch] = p[ch]*(1-k) + deepFill[ch]*k
+				}
+				dc.deep.setPixel(x, y, p)
+				continue
+			}
+			i := dc.im.PixOffset(x, y)
+			for ch := 0; ch < 4; ch++ {
+				dc.im.Pix[i+ch] = uint8((uint32(dc.im.Pix[i+ch])*(255-m) + fill[ch]*m + 127) / 255)
+			}
+		}
+	}
+}
+
+// colorRegion returns the pixels connected to (x, y) whose colors are within
+// tolerance of its color
+func colorRegion(w, h, x, y int, tolerance float64, connectivity Connectivity, at func(x, y int) [4]uint8) []bool {
+	seed := at(x, y)
+	limit := tolerance * 255
+	return scanlineFill(w, h, []int{y*w + x}, connectivity, func(x, y int) bool {
+		return colorDistance(at(x, y), seed) <= limit
+	})
+}
+
+// colorDistance returns the largest channel difference between two colors
+func colorDistance(a, b [4]uint8) float64 {
+	d := 0
+	for c := range a {
+		d = max(d, abs(int(a[c])-int(b[c])))
+	}
+	return float64(d)
+}
+
+// scanlineFill marks the pixels reachable from seeds through pixels for
+// which inside is true, filling whole horizontal spans at a time
+func scanlineFill(w, h int, seeds []int, connectivity Connectivity, inside func(x, y int) bool) []bool {
+	region := make([]bool, w*h)
+	reach := 0
+	if connectivity == Connectivity8 {
+		reach = 1
+	}
+	stack := append([]int(nil), seeds...)
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := i%w, i/w
+		if region[i] || !inside(x, y) {
+			continue
+		}
+		left, right := x, x
+		for left > 0 && !region[y*w+left-1] && inside(left-1, y) {
+			left--
+		}
+		for right < w-1 && !region[y*w+right+1] && inside(right+1, y) {
+			right++
+		}
+		for k := left; k <= right; k++ {
+			region[y*w+k] = true
+		}
+		// Queue one seed for every run of fillable pixels above and below
+		lo, hi := max(left-reach, 0), min(right+reach, w-1)
+		for _, ny := range [2]int{y - 1, y + 1} {
+			if ny < 0 || ny >= h {
+				continue
+			}
+			run := false
+			for k := lo; k <= hi; k++ {
+				j := ny*w + k
+				if !region[j] && inside(k, ny) {
+					if !run {
+						stack = append(stack, j)
+						run = true
+					}
+				} else {
+					run = false
+				}
+			}
+		}
+	}
+	return region
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func countSelected(s *Selection) int {
+	n := 0
+	for _, a := range s.Mask.Pix {
+		if a >= 128 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFloodFillConnectivity(t *testing.T) {
+	// A one pixel black diagonal splits white into two triangles that
+	// touch only at corners
+	diagonal := func() *ImageData {
+		id := NewImageData(10, 10)
+		id.Fill(255, 255, 255, 255)
+		for i := 0; i < 10; i++ {
+			id.SetPixel(i, i, 0, 0, 0, 255)
+		}
+		return id
+	}
+	red := color.RGBA{255, 0, 0, 255}
+	count := func(id *ImageData) int {
+		n := 0
+		for y := 0; y < id.Height; y++ {
+			for x := 0; x < id.Width; x++ {
+				if id.GetPixelColor(x, y) == red {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	id := diagonal()
+	id.FloodFill(9, 0, red, 0, Connectivity4)
+	if n := count(id); n != 45 {
+		t.Errorf("4-connected fill covered %d pixels, want 45", n)
+	}
+
+	id = diagonal()
+	id.FloodFill(9, 0, red, 0, Connectivity8)
+	if n := count(id); n != 90 {
+		t.Errorf("8-connected fill covered %d pixels, want 90", n)
+	}
+}
+
+func TestContextFloodFillTolerance(t *testing.T) {
+	dc := NewContext(20, 4)
+	for x := 0; x < 20; x++ {
+		v := float64(x) / 19
+		dc.SetRGB(v, v, v)
+		dc.DrawRectangle(float64(x), 0, 1, 4)
+		dc.Fill()
+	}
+	before := image.NewRGBA(image.Rect(0, 0, 20, 4))
+	copy(before.Pix, dc.Image().(*image.RGBA).Pix)
+	dc.FloodFill(0, 0, color.RGBA{0, 0, 255, 255}, 0.25, Connectivity4)
+	// Columns are 255/19 ≈ 13.4 levels apart, so 0.25 reaches columns 0..4
+	for x := 0; x < 20; x++ {
+		got := dc.Image().(*image.RGBA).RGBAAt(x, 2)
+		filled := got == color.RGBA{0, 0, 255, 255}
+		if filled != (x <= 4) {
+			t.Errorf("column %d filled = %v", x, filled)
+		}
+		if x > 4 && got != before.RGBAAt(x, 2) {
+			t.Errorf("column %d changed from %v to %v", x, before.RGBAAt(x, 2), got)
+		}
+	}
+}
+
+func TestSelectionOperations(t *testing.T) {
+	a := SelectRect(40, 40, image.Rect(10, 10, 20, 20))
+	b := SelectRect(40, 40, image.Rect(15, 10, 25, 20))
+
+	if n := countSelected(a.Add(b)); n != 150 {
+		t.Errorf("add selected %d pixels, want 150", n)
+	}
+	if n := countSelected(a.Subtract(b)); n != 50 {
+		t.Errorf("subtract selected %d pixels, want 50", n)
+	}
+	if n := countSelected(a.Intersect(b)); n != 50 {
+		t.Errorf("intersect selected %d pixels, want 50", n)
+	}
+	if n := countSelected(a.Invert()); n != 1500 {
+		t.Errorf("invert selected %d pixels, want 1500", n)
+	}
+
+	if r := a.Shrink(2).Bounds(); r != image.Rect(12, 12, 18, 18) {
+		t.Errorf("shrink bounds = %v", r)
+	}
+	grown := a.Grow(2)
+	if r := grown.Bounds(); !r.In(image.Rect(7, 7, 23, 23)) || !grown.Contains(8, 15) || grown.Contains(7, 15) {
+		t.Errorf("grow bounds = %v", r)
+	}
+	if grown.Contains(8, 8) {
+		t.Error("grown corner should be rounded")
+	}
+
+	feathered := a.Feather(4)
+	if v := feathered.Mask.AlphaAt(10, 15).A; v < 80 || v > 175 {
+		t.Errorf("feathered edge = %d, want about half", v)
+	}
+	if feathered.Mask.AlphaAt(15, 15).A < 200 || feathered.Mask.AlphaAt(3, 15).A != 0 {
+		t.Error("feathering should leave the center and far outside unchanged")
+	}
+}
+
+func TestSelectPolygonAndMagicWand(t *testing.T) {
+	tri := SelectPolygon(40, 40, []Point{{5, 5}, {35, 5}, {5, 35}})
+	if !tri.Contains(10, 10) || tri.Contains(30, 30) {
+		t.Error("polygon selection does not match the triangle")
+	}
+	if n := countSelected(tri); n < 420 || n > 480 {
+		t.Errorf("triangle selected %d pixels, want about 450", n)
+	}
+
+	dc := NewContext(40, 40)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0.2, 0.4, 0.8)
+	dc.DrawRectangle(10, 10, 10, 10)
+	dc.DrawRectangle(25, 25, 10, 10)
+	dc.Fill()
+
+	wand := MagicWand(dc.Image(), 12, 12, 0.05, Connectivity4)
+	if n := countSelected(wand); n != 100 {
+		t.Errorf("magic wand selected %d pixels, want 100", n)
+	}
+	if n := countSelected(SelectColorRange(dc.Image(), color.RGBA{51, 102, 204, 255}, 0.05)); n != 200 {
+		t.Errorf("color range selected %d pixels, want 200", n)
+	}
+
+	// Clear only the pixels inside the wand selection
+	out := asRGBA(FilterInSelection(func(img image.Image) image.Image {
+		return image.NewRGBA(img.Bounds())
+	}, wand)(dc.Image()))
+	if out.RGBAAt(15, 15).A != 0 {
+		t.Error("filter was not applied inside the selection")
+	}
+	if out.RGBAAt(30, 30) != (color.RGBA{51, 102, 204, 255}) || out.RGBAAt(2, 2) != (color.RGBA{255, 255, 255, 255}) {
+		t.Error("filter leaked outside the selection")
+	}
+
+	lm := NewLayerManager(40, 40)
+	layer := lm.AddLayer("masked")
+	layer.AddMaskFromSelection(wand)
+	if layer.Mask.AlphaAt(15, 15).A != 255 || layer.Mask.AlphaAt(30, 30).A != 0 {
+		t.Error("layer mask does not match the selection")
+	}
+	if err := dc.SetMask(wand.Mask); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,273 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"os"
+)
+
+// CMYK separation and CMYK TIFF output
+
+// BlackGeneration selects how the black plate is built
+type BlackGeneration int
+
+const (
+	// BlackProfile keeps the black generation of the profile's tables
+	BlackProfile BlackGeneration = iota
+	// BlackGCR replaces the gray component of every color with black
+	// (gray component replacement)
+	BlackGCR
+	// BlackUCR replaces the gray component with black only in
+	// near-neutral colors (under color removal)
+	BlackUCR
+)
+
+// SeparationOptions configures SeparateWithOptions
+type SeparationOptions struct {
+	Intent RenderingIntent
+	Black  BlackGeneration
+	// BlackAmount is the share, 0..1, of the gray component moved to the
+	// black plate by BlackGCR and BlackUCR; 1 when zero
+	BlackAmount float64
+	// BlackStart is the gray component, 0..1, below which no black is
+	// generated
+	BlackStart float64
+	// InkLimit caps the total coverage of all four plates in percent,
+	// e.g. 300; unlimited when zero
+	InkLimit float64
+}
+
+// Separation holds the four plates of a CMYK separation. Each plate stores
+// ink coverage, with 255 for solid ink.
+type Separation struct {
+	Cyan, Magenta, Yellow, Black *image.Gray
+}
+
+// Separate splits an sRGB image into C, M, Y and K plates using the output
+// profile's black generation
+func Separate(img image.Image, profile *ICCProfile) (*Separation, error) {
+	return SeparateWithOptions(img, profile, SeparationOptions{})
+}
+
+// SeparateWithOptions splits an sRGB image into C, M, Y and K plates.
+// Profiles without tables fall back to a naive conversion. Transparent
+// pixels are separated as if composited over white paper.
+func SeparateWithOptions(img image.Image, profile *ICCProfile, opts SeparationOptions) (*Separation, error) {
+	if profile == nil || profile.ColorSpace != ColorSpaceCMYK {
+		return nil, NewInvalidParameterError("profile", profile, "a CMYK output profile")
+	}
+	converter := NewColorConverter(srgbProfile, profile)
+	converter.Intent = opts.Intent
+	converter.BlackPointCompensation = true
+	fn := converter.transform()
+
+	b := img.Bounds()
+	rect := image.Rect(0, 0, b.Dx(), b.Dy())
+	sep := &Separation{
+		Cyan:    image.NewGray(rect),
+		Magenta: image.NewGray(rect),
+		Yellow:  image.NewGray(rect),
+		Black:   image.NewGray(rect),
+	}
+	plates := sep.Plates()
+	parallelRows(b.Dy(), 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < b.Dx(); x++ {
+				in, alpha := deviceValues(img.At(b.Min.X+x, b.Min.Y+y), ColorSpaceRGB)
+				for i := range in {
+					in[i] = in[i]*alpha + 1 - alpha
+				}
+				ink := fn(in)
+				if opts.Black != BlackProfile {
+					ink = generateBlack(ink, opts)
+				}
+				if opts.InkLimit > 0 {
+					limitInk(ink, opts.InkLimit/100)
+				}
+				i := rect.Dx()*y + x
+				for c, plate := range plates {
+					plate.Pix[i] = uint8(clamp(ink[c], 0, 1)*255 + 0.5)
+				}
+			}
+		}
+	})
+	return sep, nil
+}
+
+// generateBlack rebuilds the black plate of a CMYK value. The value is
+// first folded back to CMY, then the gray component is moved to black so
+// that (1-C)(1-K) is kept for each ink.
+func generateBlack(ink []float64, opts SeparationOptions) []float64 {
+	k := clamp(ink[3], 0, 1)
+	cmy := [3]float64{}
+	for i := range cmy {
+		cmy[i] = 1 - (1-clamp(ink[i], 0, 1))*(1-k)
+	}
+	lo := math.Min(cmy[0], math.Min(cmy[1], cmy[2]))
+	hi := math.Max(cmy[0], math.Max(cmy[1], cmy[2]))
+
+	amount := opts.BlackAmount
+	if amount <= 0 {
+		amount = 1
+	}
+	start := clamp(opts.BlackStart, 0, 0.999)
+	black := amount * math.Max(0, lo-start) / (1 - start)
+	if opts.Black == BlackUCR && hi > 0 {
+		// Fade black out as the color moves away from neutral
+		black *= 1 - (hi-lo)/hi
+	}
+	black = math.Min(black, lo)
+
+	out := []float64{0, 0, 0, black}
+	if black < 1 {
+		for i, v := range cmy {
+			out[i] = (v - black) / (1 - black)
+		}
+	}
+	return out
+}
+
+// limitInk scales the chromatic inks down so the total coverage stays
+// within limit, a fraction where 4 is all plates solid
+func limitInk(ink []float64, limit float64) {
+	cmy := ink[0] + ink[1] + ink[2]
+	if cmy+ink[3] <= limit {
+		return
+	}
+	if ink[3] >= limit {
+		ink[0], ink[1], ink[2], ink[3] = 0, 0, 0, limit
+		return
+	}
+	s := (limit - ink[3]) / cmy
+	for i := 0; i < 3; i++ {
+		ink[i] *= s
+	}
+}
+
+// Plates returns the plates in C, M, Y, K order
+func (s *Separation) Plates() []*image.Gray {
+	return []*image.Gray{s.Cyan, s.Magenta, s.Yellow, s.Black}
+}
+
+// CMYK interleaves the plates into a CMYK image
+func (s *Separation) CMYK() *image.CMYK {
+	b := s.Cyan.Bounds()
+	img := image.NewCMYK(b)
+	for i, plate := range s.Plates() {
+		for y := 0; y < b.Dy(); y++ {
+			src := plate.Pix[y*plate.Stride : y*plate.Stride+b.Dx()]
+			dst := img.Pix[y*img.Stride:]
+			for x, v := range src {
+				dst[x*4+i] = v
+			}
+		}
+	}
+	return img
+}
+
+// MaxInk returns the highest total coverage of any pixel in percent
+func (s *Separation) MaxInk() float64 {
+	plates := s.Plates()
+	best := 0
+	for i := range s.Cyan.Pix {
+		total := 0
+		for _, plate := range plates {
+			total += int(plate.Pix[i])
+		}
+		best = max(best, total)
+	}
+	return float64(best) * 100 / 255
+}
+
+// SaveTIFF writes the plates as a 4-channel CMYK TIFF
+func (s *Separation) SaveTIFF(path string) error {
+	return SaveCMYKTIFF(path, s.CMYK())
+}
+
+// SaveCMYKTIFF writes a CMYK image as an uncompressed 4-channel TIFF
+func SaveCMYKTIFF(path string, img *image.CMYK) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return EncodeCMYKTIFF(file, img)
+}
+
+// EncodeCMYKTIFF writes a CMYK image as an uncompressed, separated
+// (PhotometricInterpretation 5) TIFF with 8 bits per ink
+func EncodeCMYKTIFF(w io.Writer, img *image.CMYK) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	type entry struct {
+		tag, kind   uint16
+		count, data uint32
+	}
+	const (
+		tShort    = 3
+		tLong     = 4
+		tRational = 5
+		nEntries  = 14
+		ifdOffset = 8
+	)
+	extra := uint32(ifdOffset + 2 + nEntries*12 + 4)
+	bitsOffset, xresOffset, yresOffset := extra, extra+8, extra+16
+	pixOffset := extra + 24
+	entries := [nEntries]entry{
+		{256, tLong, 1, uint32(width)},
+		{257, tLong, 1, uint32(height)},
+		{258, tShort, 4, bitsOffset},
+		{259, tShort, 1, 1},             // no compression
+		{262, tShort, 1, 5},             // separated
+		{273, tLong, 1, pixOffset},      // strip offset
+		{277, tShort, 1, 4},             // samples per pixel
+		{278, tLong, 1, uint32(height)}, // one strip
+		{279, tLong, 1, uint32(width * height * 4)},
+		{282, tRational, 1, xresOffset},
+		{283, tRational, 1, yresOffset},
+		{284, tShort, 1, 1}, // chunky
+		{296, tShort, 1, 2}, // inches
+		{332, tShort, 1, 1}, // CMYK ink set
+	}
+
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	buf := make([]byte, 0, pixOffset)
+	buf = append(buf, 'I', 'I', 42, 0)
+	buf = le.AppendUint32(buf, ifdOffset)
+	buf = le.AppendUint16(buf, nEntries)
+	for _, e := range entries {
+		buf = le.AppendUint16(buf, e.tag)
+		buf = le.AppendUint16(buf, e.kind)
+		buf = le.AppendUint32(buf, e.count)
+		if e.kind == tShort && e.count == 1 {
+			// Short values are left-justified in the field
+			buf = le.AppendUint16(buf, uint16(e.data))
+			buf = le.AppendUint16(buf, 0)
+		} else {
+			buf = le.AppendUint32(buf, e.data)
+		}
+	}
+	buf = le.AppendUint32(buf, 0)
+	for i := 0; i < 4; i++ {
+		buf = le.AppendUint16(buf, 8)
+	}
+	for i := 0; i < 2; i++ {
+		buf = le.AppendUint32(buf, 72)
+		buf = le.AppendUint32(buf, 1)
+	}
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	for y := 0; y < height; y++ {
+		i := img.PixOffset(b.Min.X, b.Min.Y+y)
+		if _, err := bw.Write(img.Pix[i : i+width*4]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// narrowGamutProfile is an RGB profile with primaries pulled halfway
+// toward white, so saturated sRGB colors fall outside it
+func narrowGamutProfile() *ICCProfile {
+	p := CreateSRGBProfile()
+	mix := func(c XYZColor) XYZColor {
+		w := 1.0 / 3
+		return XYZColor{
+			X: (c.X + w*IlluminantD50.X*c.Y/IlluminantD50.Y) / 2,
+			Y: (c.Y + w*c.Y) / 2,
+			Z: (c.Z + w*IlluminantD50.Z*c.Y/IlluminantD50.Y) / 2,
+		}
+	}
+	p.RedColorant, p.GreenColorant, p.BlueColorant = mix(p.RedColorant), mix(p.GreenColorant), mix(p.BlueColorant)
+	return p
+}
+
+func TestSoftProof(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+	img.SetRGBA(1, 0, color.RGBA{128, 128, 128, 255})
+	srgb := CreateSRGBProfile()
+
+	same := SoftProof(img, srgb, srgb, IntentRelativeColorimetric)
+	for x := 0; x < 2; x++ {
+		if d := maxChannelDiff(img.At(x, 0), same.At(x, 0)); d > 1 {
+			t.Errorf("proofing on the display profile changed pixel %d by %d", x, d)
+		}
+	}
+
+	narrow := narrowGamutProfile()
+	proof := SoftProof(img, narrow, srgb, IntentRelativeColorimetric)
+	if red := proof.RGBAAt(0, 0); red.G < 20 || red.R < 180 {
+		t.Errorf("red should print desaturated, got %v", red)
+	}
+	if d := maxChannelDiff(img.At(1, 0), proof.At(1, 0)); d > 2 {
+		t.Errorf("gray should print unchanged, off by %d", d)
+	}
+
+	warn := color.RGBA{0, 255, 0, 255}
+	flagged := SoftProofWithOptions(img, narrow, srgb, SoftProofOptions{
+		Intent:       IntentRelativeColorimetric,
+		GamutWarning: true,
+		WarningColor: warn,
+	})
+	if flagged.RGBAAt(0, 0) != warn {
+		t.Errorf("out-of-gamut red should carry the warning, got %v", flagged.RGBAAt(0, 0))
+	}
+	if flagged.RGBAAt(1, 0) == warn {
+		t.Error("gray should not carry the warning")
+	}
+	mask := GamutMask(img, narrow, srgb, 0)
+	if mask.AlphaAt(0, 0).A != 255 || mask.AlphaAt(1, 0).A != 0 {
+		t.Errorf("gamut mask = %v", mask.Pix)
+	}
+}
+
+func TestSeparate(t *testing.T) {
+	cmyk := cmykTestProfile(t)
+	if _, err := Separate(image.NewRGBA(image.Rect(0, 0, 1, 1)), CreateSRGBProfile()); err == nil {
+		t.Error("separating with an RGB profile should fail")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{64, 64, 64, 255})
+	img.SetRGBA(1, 0, color.RGBA{200, 40, 40, 255})
+	img.SetRGBA(2, 0, color.RGBA{10, 10, 30, 255})
+
+	gcr, err := SeparateWithOptions(img, cmyk, SeparationOptions{Black: BlackGCR})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, m, y := gcr.Cyan.Pix[0], gcr.Magenta.Pix[0], gcr.Yellow.Pix[0]; c > 4 || m > 4 || y > 4 {
+		t.Errorf("full GCR should print gray with black only, got %d %d %d", c, m, y)
+	}
+	if gcr.Black.Pix[0] < 180 {
+		t.Errorf("dark gray black plate = %d", gcr.Black.Pix[0])
+	}
+
+	ucr, _ := SeparateWithOptions(img, cmyk, SeparationOptions{Black: BlackUCR})
+	if ucr.Black.Pix[1] >= gcr.Black.Pix[1]/2 {
+		t.Errorf("UCR should add little black to red: %d vs GCR %d", ucr.Black.Pix[1], gcr.Black.Pix[1])
+	}
+	back := NewColorConverter(cmyk, CreateSRGBProfile())
+	for x := 0; x < 3; x++ {
+		for _, sep := range []*Separation{gcr, ucr} {
+			if d := maxChannelDiff(img.At(x, 0), back.ConvertColor(sep.CMYK().CMYKAt(x, 0))); d > 12 {
+				t.Errorf("pixel %d separates off by %d", x, d)
+			}
+		}
+	}
+
+	rich, _ := SeparateWithOptions(img, cmyk, SeparationOptions{Black: BlackUCR, BlackStart: 0.5})
+	if rich.MaxInk() <= 260 {
+		t.Fatalf("UCR shadows should be rich, max ink %.0f%%", rich.MaxInk())
+	}
+	limited, _ := SeparateWithOptions(img, cmyk, SeparationOptions{Black: BlackUCR, BlackStart: 0.5, InkLimit: 260})
+	if limited.MaxInk() > 261 {
+		t.Errorf("ink limit exceeded: %.0f%%", limited.MaxInk())
+	}
+}
+
+func TestEncodeCMYKTIFF(t *testing.T) {
+	img := image.NewCMYK(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 11)
+	}
+	var buf bytes.Buffer
+	if err := EncodeCMYKTIFF(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if string(data[:4]) != "II*\x00" {
+		t.Fatalf("bad header %q", data[:4])
+	}
+	// Short values sit in the low half of the little-endian field
+	fields := map[uint16]uint32{}
+	ifd := binary.LittleEndian.Uint32(data[4:])
+	n := int(binary.LittleEndian.Uint16(data[ifd:]))
+	for i := 0; i < n; i++ {
+		e := data[int(ifd)+2+i*12:]
+		fields[binary.LittleEndian.Uint16(e)] = binary.LittleEndian.Uint32(e[8:])
+	}
+	if fields[262] != 5 || fields[277] != 4 || fields[256] != 3 || fields[257] != 2 {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+	strip := data[fields[273] : fields[273]+fields[279]]
+	if !bytes.Equal(strip, img.Pix) {
+		t.Errorf("strip = %v", strip)
+	}
+}
@@ -16,11 +16,13 @@ func (dc *Context) applyShadow(drawFunc func()) {
 
 	// Save current state
 	originalIm := dc.im
+	originalDeep := dc.deep
 	originalColor := dc.color
 
-	// Create shadow image
+	// Create shadow image; deep contexts draw it in 8 bits too
 	shadowIm := image.NewRGBA(dc.im.Bounds())
 	dc.im = shadowIm
+	dc.deep = nil
 	dc.color = dc.shadowColor
 
 	// Draw the shape for shadow
@@ -33,6 +35,7 @@ func (dc *Context) applyShadow(drawFunc func()) {
 
 	// Restore original image and color
 	dc.im = originalIm
+	dc.deep = originalDeep
 	dc.color = originalColor
 
 	// Draw shadow with offset
@@ -67,6 +70,20 @@ func (dc *Context) blendPixel(x, y int, newPixel color.RGBA) {
 	if x < 0 || x >= dc.width || y < 0 || y >= dc.height {
 		return
 	}
+	if dc.deep != nil {
+		alpha := float32(newPixel.A) / 255
+		p := dc.deep.pixel(x, y)
+		src := [4]float32{float32(newPixel.R) / 255 * alpha, float32(newPixel.G) / 255 * alpha, float32(newPixel.B) / 255 * alpha, alpha}
+		for c := range p {
+			p[c] = src[c] + p[c]*(1-alpha)
+		}
+		dc.deep.setPixel(x, y, p)
+		return
+	}
+	if dc.linearLight {
+		overLinear(dc.im.Pix[dc.im.PixOffset(x, y):], newPixel)
+		return
+	}
 
 	existing := dc.im.RGBAAt(x, y)
 	alpha := float64(newPixel.A) / 255.0
@@ -82,51 +99,18 @@ func (dc *Context) blendPixel(x, y int, newPixel color.RGBA) {
 	dc.im.SetRGBA(x, y, blended)
 }
 
-// blurImage applies a simple box blur to the image
+// blurImage blurs the shadow layer. As with the canvas shadowBlur
+// attribute, the blur amount is twice the Gaussian standard deviation and
+// the area outside the canvas is transparent.
 func (dc *Context) blurImage(img *image.RGBA, radius float64) *image.RGBA {
 	if radius <= 0 {
 		return img
 	}
-
-	bounds := img.Bounds()
-	blurred := image.NewRGBA(bounds)
-	
-	r := int(radius)
-	if r < 1 {
-		r = 1
-	}
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			var rSum, gSum, bSum, aSum, count uint32
-
-			// Sample surrounding pixels
-			for dy := -r; dy <= r; dy++ {
-				for dx := -r; dx <= r; dx++ {
-					px, py := x+dx, y+dy
-					if px >= bounds.Min.X && px < bounds.Max.X && py >= bounds.Min.Y && py < bounds.Max.Y {
-						pixel := img.RGBAAt(px, py)
-						rSum += uint32(pixel.R)
-						gSum += uint32(pixel.G)
-						bSum += uint32(pixel.B)
-						aSum += uint32(pixel.A)
-						count++
-					}
-				}
-			}
-
-			if count > 0 {
-				blurred.SetRGBA(x, y, color.RGBA{
-					R: uint8(rSum / count),
-					G: uint8(gSum / count),
-					B: uint8(bSum / count),
-					A: uint8(aSum / count),
-				})
-			}
-		}
-	}
-
-	return blurred
+	return GaussianBlurWithOptions(img, BlurOptions{
+		Sigma:  RadiusToSigma(radius),
+		Edge:   EdgeTransparent,
+		Linear: dc.linearLight,
+	})
 }
 
 // Shadow-enabled drawing methods
@@ -240,11 +224,5 @@ func (dc *Context) copyForShadow() *Context {
 
 // Gaussian blur implementation for better shadow quality
 func (dc *Context) gaussianBlur(img *image.RGBA, radius float64) *image.RGBA {
-	if radius <= 0 {
-		return img
-	}
-
-	// For simplicity, use box blur approximation
-	// A true Gaussian blur would require more complex implementation
 	return dc.blurImage(img, radius)
 }
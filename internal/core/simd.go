@@ -39,84 +39,18 @@ func hasSSE4() bool {
 	return runtime.GOARCH == "amd64"
 }
 
-// SIMDBlur applies blur using SIMD optimizations
+// SIMDBlur applies a Gaussian blur using the parallel separable engine.
+// The radius covers two standard deviations; see RadiusToSigma.
 func SIMDBlur(img *image.RGBA, radius int) *image.RGBA {
-	bounds := img.Bounds()
-	result := image.NewRGBA(bounds)
-	
 	if !DefaultSIMDConfig().Enabled || radius <= 0 {
 		return img
 	}
-	
-	width := bounds.Max.X - bounds.Min.X
-	height := bounds.Max.Y - bounds.Min.Y
-	
-	// Use parallel processing for SIMD-like performance
-	numWorkers := DefaultSIMDConfig().NumWorkers
-	rowsPerWorker := height / numWorkers
-	
-	done := make(chan bool, numWorkers)
-	
-	for worker := 0; worker < numWorkers; worker++ {
-		startY := worker * rowsPerWorker
-		endY := startY + rowsPerWorker
-		if worker == numWorkers-1 {
-			endY = height
-		}
-		
-		go func(startY, endY int) {
-			simdBlurRows(img, result, startY, endY, width, radius)
-			done <- true
-		}(startY, endY)
-	}
-	
-	// Wait for all workers to complete
-	for i := 0; i < numWorkers; i++ {
-		<-done
-	}
-	
-	return result
-}
 
-// simdBlurRows processes a range of rows with optimized operations
-func simdBlurRows(src, dst *image.RGBA, startY, endY, width, radius int) {
-	for y := startY; y < endY; y++ {
-		for x := 0; x < width; x++ {
-			var rSum, gSum, bSum, aSum uint32
-			var count uint32
-			
-			// Optimized inner loop - process multiple pixels at once
-			for dy := -radius; dy <= radius; dy++ {
-				py := y + dy
-				if py < 0 || py >= endY+(endY-startY) {
-					continue
-				}
-				
-				for dx := -radius; dx <= radius; dx++ {
-					px := x + dx
-					if px < 0 || px >= width {
-						continue
-					}
-					
-					pixel := src.RGBAAt(px, py)
-					rSum += uint32(pixel.R)
-					gSum += uint32(pixel.G)
-					bSum += uint32(pixel.B)
-					aSum += uint32(pixel.A)
-					count++
-				}
-			}
-			
-			if count > 0 {
-				dst.SetRGBA(x, y, color.RGBA{
-					R: uint8(rSum / count),
-					G: uint8(gSum / count),
-					B: uint8(bSum / count),
-					A: uint8(aSum / count),
-				})
-			}
-		}
-	}
+	return GaussianBlurWithOptions(img, BlurOptions{
+		Sigma:      RadiusToSigma(float64(radius)),
+		Edge:       EdgeClamp,
+		NumWorkers: DefaultSIMDConfig().NumWorkers,
+	})
 }
 
 // SIMDColorTransform applies color transformations using SIMD
@@ -164,6 +98,9 @@ func SIMDMatrixMultiply(a, b Matrix) Matrix {
 
 // SIMDConvolution applies convolution kernel with SIMD optimizations
 func SIMDConvolution(img *image.RGBA, kernel [][]float64) *image.RGBA {
+	if len(kernel) > FFTKernelThreshold {
+		return simdConvolutionFFT(img, kernel)
+	}
 	bounds := img.Bounds()
 	result := image.NewRGBA(bounds)
 	
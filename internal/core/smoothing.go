@@ -0,0 +1,394 @@
+package core
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Edge-preserving smoothing: bilateral, guided, median and non-local means
+
+// BilateralFilter smooths the image while keeping edges sharp. Neighbors are
+// weighted by spatial distance (sigmaSpace, in pixels) and by color
+// difference (sigmaColor, in 0-255 units).
+func BilateralFilter(sigmaSpace, sigmaColor float64) Filter {
+	return func(img image.Image) image.Image {
+		src := asRGBA(img)
+		bounds := src.Bounds()
+		result := image.NewRGBA(bounds)
+		if sigmaSpace <= 0 || sigmaColor <= 0 {
+			copyRGBA(result, src)
+			return result
+		}
+
+		radius := int(math.Ceil(2 * sigmaSpace))
+		size := 2*radius + 1
+		spatial := make([]float64, size*size)
+		for dy := -radius; dy <= radius; dy++ {
+			for dx := -radius; dx <= radius; dx++ {
+				d2 := float64(dx*dx + dy*dy)
+				spatial[(dy+radius)*size+dx+radius] = math.Exp(-d2 / (2 * sigmaSpace * sigmaSpace))
+			}
+		}
+
+		// Range weights indexed by squared color distance over three channels
+		rangeLUT := make([]float64, 3*255*255+1)
+		for i := range rangeLUT {
+			rangeLUT[i] = math.Exp(-float64(i) / (2 * sigmaColor * sigmaColor))
+		}
+
+		w, h := bounds.Dx(), bounds.Dy()
+		parallelRows(h, 0, func(startY, endY int) {
+			for y := startY; y < endY; y++ {
+				for x := 0; x < w; x++ {
+					ci := src.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+					cr, cg, cb := int(src.Pix[ci]), int(src.Pix[ci+1]), int(src.Pix[ci+2])
+
+					var sr, sg, sb, sa, sw float64
+					for dy := -radius; dy <= radius; dy++ {
+						py := clampInt(y+dy, 0, h-1)
+						for dx := -radius; dx <= radius; dx++ {
+							px := clampInt(x+dx, 0, w-1)
+							i := src.PixOffset(bounds.Min.X+px, bounds.Min.Y+py)
+							r, g, b := int(src.Pix[i]), int(src.Pix[i+1]), int(src.Pix[i+2])
+							d2 := (r-cr)*(r-cr) + (g-cg)*(g-cg) + (b-cb)*(b-cb)
+							wt := spatial[(dy+radius)*size+dx+radius] * rangeLUT[d2]
+							sr += float64(r) * wt
+							sg += float64(g) * wt
+							sb += float64(b) * wt
+							sa += float64(src.Pix[i+3]) * wt
+							sw += wt
+						}
+					}
+
+					o := result.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+					setPremultiplied(result.Pix[o:o+4], sr/sw, sg/sw, sb/sw, sa/sw)
+				}
+			}
+		})
+		return result
+	}
+}
+
+// GuidedFilter smooths the image using itself as the guide (He et al.).
+// Radius is the window radius in pixels and eps the regularization on a
+// 0-1 intensity scale; larger eps smooths across stronger edges.
+func GuidedFilter(radius int, eps float64) Filter {
+	return func(img image.Image) image.Image {
+		result, _ := GuidedFilterImage(img, img, radius, eps) // a self guide always fits
+		return result
+	}
+}
+
+// checkGuideSize reports a guide whose size differs from the filtered image
+func checkGuideSize(bounds image.Rectangle, guide image.Image) error {
+	if guide.Bounds().Size() != bounds.Size() {
+		return NewInvalidParameterError("guide", guide.Bounds().Size(), fmt.Sprintf("an image of size %v", bounds.Size()))
+	}
+	return nil
+}
+
+// GuidedFilterImage filters every channel of img, transferring the edge
+// structure of guide. Both images must have the same size.
+func GuidedFilterImage(img, guide image.Image, radius int, eps float64) (*image.RGBA, error) {
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	if err := checkGuideSize(bounds, guide); err != nil {
+		return nil, err
+	}
+	w, h := bounds.Dx(), bounds.Dy()
+	result := image.NewRGBA(bounds)
+	if radius <= 0 {
+		copyRGBA(result, src)
+		return result, nil
+	}
+
+	guidePlane := luminancePlane(guide)
+	for c := 0; c < 4; c++ {
+		p := make([]float64, w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				p[y*w+x] = float64(src.Pix[src.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)+c]) / 255
+			}
+		}
+		q := guidedFilterPlane(guidePlane, p, w, h, radius, eps)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				result.Pix[result.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)+c] = uint8(clamp(q[y*w+x]*255, 0, 255) + 0.5)
+			}
+		}
+	}
+
+	// Keep premultiplied colors within the filtered alpha
+	for i := 0; i < len(result.Pix); i += 4 {
+		a := result.Pix[i+3]
+		for c := 0; c < 3; c++ {
+			if result.Pix[i+c] > a {
+				result.Pix[i+c] = a
+			}
+		}
+	}
+	return result, nil
+}
+
+// RefineMask snaps a rough alpha mask to the edges of guide, which is useful
+// after a coarse selection or a chroma key. The guide must have the size of
+// the mask.
+func RefineMask(mask *image.Alpha, guide image.Image, radius int, eps float64) (*image.Alpha, error) {
+	bounds := mask.Bounds()
+	if err := checkGuideSize(bounds, guide); err != nil {
+		return nil, err
+	}
+	w, h := bounds.Dx(), bounds.Dy()
+	p := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p[y*w+x] = float64(mask.AlphaAt(bounds.Min.X+x, bounds.Min.Y+y).A) / 255
+		}
+	}
+
+	q := guidedFilterPlane(luminancePlane(guide), p, w, h, radius, eps)
+	refined := image.NewAlpha(bounds)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			refined.Pix[y*refined.Stride+x] = uint8(clamp(q[y*w+x]*255, 0, 255) + 0.5)
+		}
+	}
+	return refined, nil
+}
+
+// guidedFilterPlane runs the gray-guide guided filter on one plane
+func guidedFilterPlane(guide, p []float64, w, h, radius int, eps float64) []float64 {
+	n := w * h
+	ip := make([]float64, n)
+	ii := make([]float64, n)
+	for i := 0; i < n; i++ {
+		ip[i] = guide[i] * p[i]
+		ii[i] = guide[i] * guide[i]
+	}
+
+	meanI := boxMean(guide, w, h, radius)
+	meanP := boxMean(p, w, h, radius)
+	corrIP := boxMean(ip, w, h, radius)
+	corrII := boxMean(ii, w, h, radius)
+
+	a := make([]float64, n)
+	b := make([]float64, n)
+	for i := 0; i < n; i++ {
+		varI := corrII[i] - meanI[i]*meanI[i]
+		covIP := corrIP[i] - meanI[i]*meanP[i]
+		a[i] = covIP / (varI + eps)
+		b[i] = meanP[i] - a[i]*meanI[i]
+	}
+
+	meanA := boxMean(a, w, h, radius)
+	meanB := boxMean(b, w, h, radius)
+	q := make([]float64, n)
+	for i := 0; i < n; i++ {
+		q[i] = meanA[i]*guide[i] + meanB[i]
+	}
+	return q
+}
+
+// boxMean averages each sample over a (2r+1)x(2r+1) window using a summed
+// area table. Windows are cropped at the image border.
+func boxMean(src []float64, w, h, r int) []float64 {
+	sat := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum float64
+		for x := 0; x < w; x++ {
+			rowSum += src[y*w+x]
+			sat[(y+1)*(w+1)+x+1] = sat[y*(w+1)+x+1] + rowSum
+		}
+	}
+
+	out := make([]float64, w*h)
+	parallelRows(h, 0, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			y0, y1 := clampInt(y-r, 0, h), clampInt(y+r+1, 0, h)
+			for x := 0; x < w; x++ {
+				x0, x1 := clampInt(x-r, 0, w), clampInt(x+r+1, 0, w)
+				sum := sat[y1*(w+1)+x1] - sat[y0*(w+1)+x1] - sat[y1*(w+1)+x0] + sat[y0*(w+1)+x0]
+				out[y*w+x] = sum / float64((x1-x0)*(y1-y0))
+			}
+		}
+	})
+	return out
+}
+
+// luminancePlane returns the Rec. 601 luma of an image on a 0-1 scale
+func luminancePlane(img image.Image) []float64 {
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	plane := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := src.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			plane[y*w+x] = (0.299*float64(src.Pix[i]) + 0.587*float64(src.Pix[i+1]) + 0.114*float64(src.Pix[i+2])) / 255
+		}
+	}
+	return plane
+}
+
+// MedianFilter replaces every channel value with the median of its
+// (2r+1)x(2r+1) neighborhood, using a sliding histogram per row (Huang)
+func MedianFilter(radius int) Filter {
+	return func(img image.Image) image.Image {
+		src := asRGBA(img)
+		bounds := src.Bounds()
+		result := image.NewRGBA(bounds)
+		if radius <= 0 {
+			copyRGBA(result, src)
+			return result
+		}
+
+		w, h := bounds.Dx(), bounds.Dy()
+		half := (2*radius + 1) * (2*radius + 1) / 2
+		sample := func(x, y, c int) uint8 {
+			x = clampInt(x, 0, w-1)
+			y = clampInt(y, 0, h-1)
+			return src.Pix[src.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)+c]
+		}
+
+		parallelRows(h, 0, func(startY, endY int) {
+			var hist [4][256]int
+			for y := startY; y < endY; y++ {
+				for c := 0; c < 4; c++ {
+					hist[c] = [256]int{}
+					for dy := -radius; dy <= radius; dy++ {
+						for dx := -radius; dx <= radius; dx++ {
+							hist[c][sample(dx, y+dy, c)]++
+						}
+					}
+				}
+
+				for x := 0; x < w; x++ {
+					if x > 0 {
+						for c := 0; c < 4; c++ {
+							for dy := -radius; dy <= radius; dy++ {
+								hist[c][sample(x-radius-1, y+dy, c)]--
+								hist[c][sample(x+radius, y+dy, c)]++
+							}
+						}
+					}
+
+					o := result.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+					for c := 0; c < 4; c++ {
+						count := 0
+						for v := 0; v < 256; v++ {
+							count += hist[c][v]
+							if count > half {
+								result.Pix[o+c] = uint8(v)
+								break
+							}
+						}
+					}
+				}
+			}
+		})
+
+		for i := 0; i < len(result.Pix); i += 4 {
+			a := result.Pix[i+3]
+			for c := 0; c < 3; c++ {
+				if result.Pix[i+c] > a {
+					result.Pix[i+c] = a
+				}
+			}
+		}
+		return result
+	}
+}
+
+// Denoise removes noise with non-local means: each pixel becomes a weighted
+// average of pixels whose surrounding 3x3 patches look alike. Strength is
+// the filtering parameter h in 0-255 units; 5-15 suits typical photo noise.
+func Denoise(strength float64) Filter {
+	return DenoiseWithOptions(strength, 1, 5)
+}
+
+// DenoiseWithOptions runs non-local means with explicit patch and search
+// window radii
+func DenoiseWithOptions(strength float64, patchRadius, searchRadius int) Filter {
+	return func(img image.Image) image.Image {
+		src := asRGBA(img)
+		bounds := src.Bounds()
+		result := image.NewRGBA(bounds)
+		if strength <= 0 || searchRadius <= 0 {
+			copyRGBA(result, src)
+			return result
+		}
+
+		w, h := bounds.Dx(), bounds.Dy()
+		patchSize := float64((2*patchRadius + 1) * (2*patchRadius + 1) * 3)
+		h2 := strength * strength
+		at := func(x, y int) int {
+			return src.PixOffset(bounds.Min.X+clampInt(x, 0, w-1), bounds.Min.Y+clampInt(y, 0, h-1))
+		}
+
+		parallelRows(h, 0, func(startY, endY int) {
+			for y := startY; y < endY; y++ {
+				for x := 0; x < w; x++ {
+					var sr, sg, sb, sa, sw float64
+					for sy := -searchRadius; sy <= searchRadius; sy++ {
+						for sx := -searchRadius; sx <= searchRadius; sx++ {
+							var d2 float64
+							for py := -patchRadius; py <= patchRadius; py++ {
+								for px := -patchRadius; px <= patchRadius; px++ {
+									a := at(x+px, y+py)
+									b := at(x+sx+px, y+sy+py)
+									for c := 0; c < 3; c++ {
+										d := float64(src.Pix[a+c]) - float64(src.Pix[b+c])
+										d2 += d * d
+									}
+								}
+							}
+
+							wt := math.Exp(-(d2 / patchSize) / h2)
+							i := at(x+sx, y+sy)
+							sr += float64(src.Pix[i]) * wt
+							sg += float64(src.Pix[i+1]) * wt
+							sb += float64(src.Pix[i+2]) * wt
+							sa += float64(src.Pix[i+3]) * wt
+							sw += wt
+						}
+					}
+
+					o := result.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+					setPremultiplied(result.Pix[o:o+4], sr/sw, sg/sw, sb/sw, sa/sw)
+				}
+			}
+		})
+		return result
+	}
+}
+
+// setPremultiplied stores rounded premultiplied samples, keeping every color
+// channel within alpha
+func setPremultiplied(pix []uint8, r, g, b, a float64) {
+	a = clamp(a, 0, 255)
+	pix[0] = uint8(clamp(r, 0, a) + 0.5)
+	pix[1] = uint8(clamp(g, 0, a) + 0.5)
+	pix[2] = uint8(clamp(b, 0, a) + 0.5)
+	pix[3] = uint8(a + 0.5)
+}
+
+// copyRGBA copies src into dst, which must have the same bounds
+func copyRGBA(dst, src *image.RGBA) {
+	bounds := dst.Bounds()
+	rowLen := bounds.Dx() * 4
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		copy(dst.Pix[dst.PixOffset(bounds.Min.X, y):][:rowLen], src.Pix[src.PixOffset(bounds.Min.X, y):][:rowLen])
+	}
+}
+
+// clampInt clamps an int value between min and max
+func clampInt(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func stepImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(40)
+			if x >= w/2 {
+				v = 220
+			}
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestMedianRemovesSaltNoise(t *testing.T) {
+	img := stepImage(16, 16)
+	img.SetRGBA(3, 3, color.RGBA{255, 255, 255, 255})
+	img.SetRGBA(12, 9, color.RGBA{0, 0, 0, 255})
+
+	out := MedianFilter(1)(img).(*image.RGBA)
+	if got := out.RGBAAt(3, 3).R; got != 40 {
+		t.Errorf("salt pixel = %d, want 40", got)
+	}
+	if got := out.RGBAAt(12, 9).R; got != 220 {
+		t.Errorf("pepper pixel = %d, want 220", got)
+	}
+	if out.RGBAAt(7, 5).R != 40 || out.RGBAAt(8, 5).R != 220 {
+		t.Errorf("median moved the step edge")
+	}
+}
+
+func TestSmoothingPreservesEdges(t *testing.T) {
+	img := stepImage(16, 8)
+	filters := map[string]Filter{
+		"bilateral": BilateralFilter(3, 20),
+		"guided":    GuidedFilter(3, 0.001),
+		"denoise":   Denoise(10),
+	}
+	for name, f := range filters {
+		out := f(img).(*image.RGBA)
+		left, right := out.RGBAAt(7, 4), out.RGBAAt(8, 4)
+		if diff := int(right.R) - int(left.R); diff < 150 {
+			t.Errorf("%s: edge contrast = %d, want >= 150", name, diff)
+		}
+		if a := left.A; a != 255 {
+			t.Errorf("%s: alpha = %d, want 255", name, a)
+		}
+	}
+}
+
+func TestRefineMask(t *testing.T) {
+	guide := stepImage(16, 8)
+	mask := image.NewAlpha(image.Rect(0, 0, 16, 8))
+	// A soft mask that ramps across the step over eight pixels
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			v := clampInt((x-4)*32, 0, 255)
+			mask.SetAlpha(x, y, color.Alpha{uint8(v)})
+		}
+	}
+
+	refined, err := RefineMask(mask, guide, 4, 0.0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := int(mask.AlphaAt(8, 4).A) - int(mask.AlphaAt(7, 4).A)
+	after := int(refined.AlphaAt(8, 4).A) - int(refined.AlphaAt(7, 4).A)
+	if after <= 2*before {
+		t.Errorf("edge step = %d, want much steeper than %d", after, before)
+	}
+	if a := refined.AlphaAt(0, 4).A; a > 32 {
+		t.Errorf("background alpha = %d, want near 0", a)
+	}
+
+	// A guide of another size is rejected rather than indexed out of range
+	small := stepImage(8, 8)
+	if _, err := RefineMask(mask, small, 4, 0.0001); err == nil {
+		t.Error("RefineMask accepted a smaller guide")
+	}
+	if _, err := GuidedFilterImage(guide, small, 4, 0.0001); err == nil {
+		t.Error("GuidedFilterImage accepted a smaller guide")
+	}
+}
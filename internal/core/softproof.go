@@ -0,0 +1,117 @@
+package core
+
+import (
+	"image"
+	"image/color"
+)
+
+// Soft-proofing: previewing how an image reproduces on an output device
+
+// SoftProofOptions configures SoftProofWithOptions
+type SoftProofOptions struct {
+	// Intent is the rendering intent of the simulated print conversion
+	Intent RenderingIntent
+	// BlackPointCompensation applies to the simulated print conversion
+	BlackPointCompensation bool
+	// PaperWhite simulates the output's paper white and ink black instead
+	// of mapping them to the display's white and black
+	PaperWhite bool
+	// GamutWarning paints colors the output cannot reproduce
+	GamutWarning bool
+	// WarningColor is the gamut warning overlay; gray when nil
+	WarningColor color.Color
+	// GamutThreshold is the CIEDE2000 difference above which a color
+	// counts as out of gamut; 2 when zero
+	GamutThreshold float64
+}
+
+// SoftProof simulates how img, encoded in display, looks when printed
+// with output and viewed on display
+func SoftProof(img image.Image, output, display *ICCProfile, intent RenderingIntent) *image.RGBA {
+	return SoftProofWithOptions(img, output, display, SoftProofOptions{Intent: intent})
+}
+
+// SoftProofWithOptions simulates how img, encoded in display, looks when
+// printed with output and viewed on display. The display profile must be
+// RGB or gray.
+func SoftProofWithOptions(img image.Image, output, display *ICCProfile, opts SoftProofOptions) *image.RGBA {
+	toOutput := NewColorConverter(display, output)
+	toOutput.Intent = opts.Intent
+	toOutput.BlackPointCompensation = opts.BlackPointCompensation
+	toDisplay := NewColorConverter(output, display)
+	toDisplay.Intent = IntentRelativeColorimetric
+	if opts.PaperWhite {
+		toDisplay.Intent = IntentAbsoluteColorimetric
+	}
+	simulate, view := toOutput.transform(), toDisplay.transform()
+
+	var inGamut func([]float64) bool
+	if opts.GamutWarning {
+		inGamut = gamutCheck(display, output, opts.GamutThreshold)
+	}
+	var warnR, warnG, warnB float64 = 0.5, 0.5, 0.5
+	if opts.WarningColor != nil {
+		n := color.NRGBAModel.Convert(opts.WarningColor).(color.NRGBA)
+		warnR, warnG, warnB = float64(n.R)/255, float64(n.G)/255, float64(n.B)/255
+	}
+
+	b := img.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	space := display.ColorSpace
+	parallelRows(b.Dy(), 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < b.Dx(); x++ {
+				in, alpha := deviceValues(img.At(b.Min.X+x, b.Min.Y+y), space)
+				var r, g, bl float64
+				if inGamut != nil && !inGamut(in) {
+					r, g, bl = warnR, warnG, warnB
+				} else {
+					out := view(simulate(in))
+					r = out[0]
+					g, bl = r, r
+					if len(out) >= 3 {
+						g, bl = out[1], out[2]
+					}
+				}
+				i := result.PixOffset(x, y)
+				setPremultiplied(result.Pix[i:i+4], r*alpha*255, g*alpha*255, bl*alpha*255, alpha*255)
+			}
+		}
+	})
+	return result
+}
+
+// GamutMask marks the pixels of img, encoded in display, that output
+// cannot reproduce within threshold CIEDE2000 (2 when zero)
+func GamutMask(img image.Image, output, display *ICCProfile, threshold float64) *image.Alpha {
+	inGamut := gamutCheck(display, output, threshold)
+	b := img.Bounds()
+	mask := image.NewAlpha(image.Rect(0, 0, b.Dx(), b.Dy()))
+	parallelRows(b.Dy(), 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < b.Dx(); x++ {
+				in, _ := deviceValues(img.At(b.Min.X+x, b.Min.Y+y), display.ColorSpace)
+				if !inGamut(in) {
+					mask.Pix[mask.PixOffset(x, y)] = 255
+				}
+			}
+		}
+	})
+	return mask
+}
+
+// gamutCheck reports whether display values survive a relative
+// colorimetric round trip through output
+func gamutCheck(display, output *ICCProfile, threshold float64) func([]float64) bool {
+	if threshold <= 0 {
+		threshold = 2
+	}
+	toOutput := NewColorConverter(display, output)
+	toOutput.Intent = IntentRelativeColorimetric
+	fn := toOutput.transform()
+	return func(in []float64) bool {
+		want := pcsToLab(display.toPCS(in, IntentRelativeColorimetric))
+		got := pcsToLab(output.toPCS(fn(in), IntentRelativeColorimetric))
+		return deltaE2000(LAB{want[0], want[1], want[2]}, LAB{got[0], got[1], got[2]}) <= threshold
+	}
+}
@@ -0,0 +1,393 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+// Truevision TGA images (.tga)
+//
+// Color-mapped, true-color and grayscale images are read, uncompressed or
+// run-length encoded, at 8, 15, 16, 24 and 32 bits per pixel. TGA alpha is
+// straight, so images with alpha decode to *image.NRGBA.
+
+// TGAOptions configures EncodeTGA
+type TGAOptions struct {
+	// Uncompressed writes raw pixels instead of run-length packets
+	Uncompressed bool
+}
+
+// TGA image types; tgaRLE is added to the others for run-length encoding
+const (
+	tgaColorMapped = 1
+	tgaTrueColor   = 2
+	tgaGrayscale   = 3
+	tgaRLE         = 8
+)
+
+// Image descriptor bits
+const (
+	tgaAlphaBitsMask = 0x0f
+	tgaRightToLeft   = 0x10
+	tgaTopToBottom   = 0x20
+)
+
+const (
+	tgaHeaderSize      = 18
+	tgaFooterSignature = "TRUEVISION-XFILE.\x00"
+)
+
+func tgaFormatError(details string) *AdvanceError {
+	return NewInvalidFormatError("TGA", []string{"tga"}).WithContext("details", details)
+}
+
+// LoadTGA loads a Truevision TGA image
+func LoadTGA(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := DecodeTGA(file)
+	if err != nil {
+		if ae, ok := err.(*AdvanceError); ok {
+			ae.WithContext("filepath", path)
+		}
+		return nil, err
+	}
+	return img, nil
+}
+
+// SaveTGA encodes the image as a TGA and writes it to disk. A nil opts
+// writes run-length encoded pixels.
+func SaveTGA(path string, img image.Image, opts *TGAOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return EncodeTGA(file, img, opts)
+}
+
+// tgaHeader is the fixed 18-byte TGA header
+type tgaHeader struct {
+	idLength      int
+	colorMapType  int
+	imageType     int
+	mapFirst      int
+	mapLength     int
+	mapEntryBits  int
+	width, height int
+	depth         int
+	descriptor    byte
+}
+
+func readTGAHeader(r io.Reader) (*tgaHeader, error) {
+	var b [tgaHeaderSize]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, tgaFormatError("truncated header")
+	}
+	h := &tgaHeader{
+		idLength:     int(b[0]),
+		colorMapType: int(b[1]),
+		imageType:    int(b[2]),
+		mapFirst:     int(binary.LittleEndian.Uint16(b[3:])),
+		mapLength:    int(binary.LittleEndian.Uint16(b[5:])),
+		mapEntryBits: int(b[7]),
+		width:        int(binary.LittleEndian.Uint16(b[12:])),
+		height:       int(binary.LittleEndian.Uint16(b[14:])),
+		depth:        int(b[16]),
+		descriptor:   b[17],
+	}
+
+	if h.colorMapType > 1 {
+		return nil, tgaFormatError("invalid color map type")
+	}
+	// A true-color image may still carry a color map, which is read
+	if h.colorMapType == 1 {
+		switch h.mapEntryBits {
+		case 15, 16, 24, 32:
+		default:
+			return nil, tgaFormatError(fmt.Sprintf("unsupported color map entry size %d", h.mapEntryBits))
+		}
+	}
+	switch h.imageType &^ tgaRLE {
+	case tgaColorMapped:
+		if h.colorMapType != 1 || (h.depth != 8 && h.depth != 16) {
+			return nil, tgaFormatError("invalid color-mapped image")
+		}
+	case tgaTrueColor:
+		if h.depth != 15 && h.depth != 16 && h.depth != 24 && h.depth != 32 {
+			return nil, tgaFormatError(fmt.Sprintf("unsupported true-color depth %d", h.depth))
+		}
+	case tgaGrayscale:
+		if h.depth != 8 && h.depth != 16 {
+			return nil, tgaFormatError(fmt.Sprintf("unsupported grayscale depth %d", h.depth))
+		}
+	default:
+		return nil, NewUnsupportedOperationError("DecodeTGA", fmt.Sprintf("image type %d is not supported", h.imageType))
+	}
+	if h.width == 0 || h.height == 0 {
+		return nil, tgaFormatError("invalid image size")
+	}
+	return h, nil
+}
+
+// hasAlpha reports whether pixels carry alpha. The descriptor gives the
+// number of attribute bits; files that leave it at zero are opaque.
+func (h *tgaHeader) hasAlpha() bool {
+	if h.imageType&^tgaRLE == tgaColorMapped {
+		return h.mapEntryBits == 32 || (h.mapEntryBits == 16 && h.descriptor&tgaAlphaBitsMask > 0)
+	}
+	return h.descriptor&tgaAlphaBitsMask > 0 && (h.depth == 32 || h.depth == 16)
+}
+
+func (h *tgaHeader) colorModel() color.Model {
+	if h.imageType&^tgaRLE == tgaGrayscale && !h.hasAlpha() {
+		return color.GrayModel
+	}
+	return color.NRGBAModel
+}
+
+// DecodeTGAConfig returns the size and color model of a TGA image without
+// decoding it
+func DecodeTGAConfig(r io.Reader) (image.Config, error) {
+	h, err := readTGAHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: h.colorModel(), Width: h.width, Height: h.height}, nil
+}
+
+// tgaColor expands a little-endian pixel of 15 to 32 bits to straight RGBA
+func tgaColor(p []byte, bits int, alpha bool) color.NRGBA {
+	switch bits {
+	case 15, 16:
+		v := uint16(p[0]) | uint16(p[1])<<8
+		expand := func(c uint16) uint8 { return uint8(c<<3 | c>>2) }
+		c := color.NRGBA{expand(v >> 10 & 0x1f), expand(v >> 5 & 0x1f), expand(v & 0x1f), 255}
+		if alpha && v&0x8000 == 0 {
+			c.A = 0
+		}
+		return c
+	case 24:
+		return color.NRGBA{p[2], p[1], p[0], 255}
+	}
+	c := color.NRGBA{p[2], p[1], p[0], 255}
+	if alpha {
+		c.A = p[3]
+	}
+	return c
+}
+
+// DecodeTGA reads a TGA image. Grayscale images without alpha decode to
+// *image.Gray and everything else to *image.NRGBA.
+func DecodeTGA(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readTGAHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDecodeSize("DecodeTGA", h.width, h.height, 0); err != nil {
+		return nil, err
+	}
+	if _, err := br.Discard(h.idLength); err != nil {
+		return nil, tgaFormatError("truncated image ID")
+	}
+
+	// The color map is read even when a true-color image only carries one
+	var palette []color.NRGBA
+	if h.colorMapType == 1 {
+		entryBytes := (h.mapEntryBits + 7) / 8
+		raw := make([]byte, h.mapLength*entryBytes)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return nil, tgaFormatError("truncated color map")
+		}
+		palette = make([]color.NRGBA, h.mapLength)
+		for i := range palette {
+			palette[i] = tgaColor(raw[i*entryBytes:], h.mapEntryBits, h.hasAlpha())
+		}
+	}
+
+	// Read the pixel stream, expanding run-length packets. Packets may
+	// cross scanlines in older files, so the whole stream is decoded first.
+	pixelBytes := (h.depth + 7) / 8
+	data := make([]byte, h.width*h.height*pixelBytes)
+	truncated := tgaFormatError("truncated pixel data")
+	if h.imageType&tgaRLE == 0 {
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, truncated
+		}
+	} else {
+		for o := 0; o < len(data); {
+			packet, err := br.ReadByte()
+			if err != nil {
+				return nil, truncated
+			}
+			n := min(int(packet&0x7f)+1, (len(data)-o)/pixelBytes)
+			if packet&0x80 != 0 {
+				if _, err := io.ReadFull(br, data[o:o+pixelBytes]); err != nil {
+					return nil, truncated
+				}
+				for i := 1; i < n; i++ {
+					copy(data[o+i*pixelBytes:], data[o:o+pixelBytes])
+				}
+			} else if _, err := io.ReadFull(br, data[o:o+n*pixelBytes]); err != nil {
+				return nil, truncated
+			}
+			o += n * pixelBytes
+		}
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	gray := h.colorModel() == color.GrayModel
+	var grayImg *image.Gray
+	var rgbaImg *image.NRGBA
+	if gray {
+		grayImg = image.NewGray(rect)
+	} else {
+		rgbaImg = image.NewNRGBA(rect)
+	}
+	alpha := h.hasAlpha()
+	kind := h.imageType &^ tgaRLE
+	for i := 0; i < h.width*h.height; i++ {
+		x, y := i%h.width, i/h.width
+		if h.descriptor&tgaRightToLeft != 0 {
+			x = h.width - 1 - x
+		}
+		if h.descriptor&tgaTopToBottom == 0 {
+			y = h.height - 1 - y
+		}
+		p := data[i*pixelBytes : (i+1)*pixelBytes]
+		if gray {
+			grayImg.Pix[y*grayImg.Stride+x] = p[0]
+			continue
+		}
+
+		var c color.NRGBA
+		switch kind {
+		case tgaColorMapped:
+			index := int(p[0])
+			if pixelBytes == 2 {
+				index |= int(p[1]) << 8
+			}
+			index -= h.mapFirst
+			if index < 0 || index >= len(palette) {
+				return nil, tgaFormatError("color index outside the color map")
+			}
+			c = palette[index]
+		case tgaGrayscale:
+			c = color.NRGBA{p[0], p[0], p[0], p[1]}
+		default:
+			c = tgaColor(p, h.depth, alpha)
+		}
+		o := rgbaImg.PixOffset(x, y)
+		rgbaImg.Pix[o], rgbaImg.Pix[o+1], rgbaImg.Pix[o+2], rgbaImg.Pix[o+3] = c.R, c.G, c.B, c.A
+	}
+	if gray {
+		return grayImg, nil
+	}
+	return rgbaImg, nil
+}
+
+// EncodeTGA writes img as a TGA image: grayscale images as 8-bit gray,
+// opaque images as 24-bit BGR and the rest as 32-bit BGRA. Rows are stored
+// bottom-up, the TGA default, and a TGA 2.0 footer is appended. A nil opts
+// writes run-length encoded pixels.
+func EncodeTGA(w io.Writer, img image.Image, opts *TGAOptions) error {
+	if opts == nil {
+		opts = &TGAOptions{}
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width > 0xffff || height > 0xffff {
+		return NewInvalidParameterError("img", b, "an image between 1 and 65535 pixels on each side")
+	}
+
+	var header [tgaHeaderSize]byte
+	var rows [][]byte
+	pixelBytes := 1
+	if model := img.ColorModel(); model == color.GrayModel || model == color.Gray16Model {
+		header[2], header[16] = tgaGrayscale, 8
+		gray := image.NewGray(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				gray.Pix[y*gray.Stride+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+		}
+		for y := height - 1; y >= 0; y-- {
+			rows = append(rows, gray.Pix[y*gray.Stride:y*gray.Stride+width])
+		}
+	} else {
+		src := imageToNRGBA(img)
+		pixelBytes = 3
+		header[2], header[16] = tgaTrueColor, 24
+		if !src.Opaque() {
+			pixelBytes = 4
+			header[16], header[17] = 32, 8
+		}
+		for y := height - 1; y >= 0; y-- {
+			row := make([]byte, width*pixelBytes)
+			for x := 0; x < width; x++ {
+				p := src.Pix[y*src.Stride+x*4:]
+				copy(row[x*pixelBytes:], []byte{p[2], p[1], p[0], p[3]}[:pixelBytes])
+			}
+			rows = append(rows, row)
+		}
+	}
+	if !opts.Uncompressed {
+		header[2] |= tgaRLE
+	}
+	binary.LittleEndian.PutUint16(header[12:], uint16(width))
+	binary.LittleEndian.PutUint16(header[14:], uint16(height))
+
+	bw := bufio.NewWriter(w)
+	bw.Write(header[:])
+	for _, row := range rows {
+		if opts.Uncompressed {
+			bw.Write(row)
+		} else {
+			writeTGARLE(bw, row, pixelBytes)
+		}
+	}
+	// TGA 2.0 footer without extension or developer areas
+	bw.Write(make([]byte, 8))
+	bw.WriteString(tgaFooterSignature)
+	return bw.Flush()
+}
+
+// writeTGARLE encodes one scanline as run-length and raw packets of up to
+// 128 pixels. Runs of two identical pixels are left in raw packets.
+func writeTGARLE(bw *bufio.Writer, row []byte, pixelBytes int) {
+	n := len(row) / pixelBytes
+	pixel := func(i int) []byte { return row[i*pixelBytes : (i+1)*pixelBytes] }
+	for i := 0; i < n; {
+		run := 1
+		for i+run < n && run < 128 && bytes.Equal(pixel(i+run), pixel(i)) {
+			run++
+		}
+		if run > 2 {
+			bw.WriteByte(0x80 | byte(run-1))
+			bw.Write(pixel(i))
+			i += run
+			continue
+		}
+		// Collect raw pixels until a run of three begins
+		start := i
+		for i < n && i-start < 128 {
+			if i+2 < n && bytes.Equal(pixel(i), pixel(i+1)) && bytes.Equal(pixel(i), pixel(i+2)) {
+				break
+			}
+			i++
+		}
+		bw.WriteByte(byte(i - start - 1))
+		bw.Write(row[start*pixelBytes : i*pixelBytes])
+	}
+}
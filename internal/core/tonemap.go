@@ -0,0 +1,257 @@
+package core
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// Tone mapping of high dynamic range images
+
+// ToneMapOperator selects the curve ToneMap uses to compress luminance
+type ToneMapOperator int
+
+const (
+	// ToneMapReinhard is the global photographic operator of Reinhard et al.
+	ToneMapReinhard ToneMapOperator = iota
+	// ToneMapReinhardLocal adds Reinhard's dodging and burning: every pixel
+	// is compressed against the largest surround without strong contrast
+	ToneMapReinhardLocal
+	// ToneMapACES is the ACES filmic curve in Stephen Hill's fit of the
+	// reference and output transforms
+	ToneMapACES
+	// ToneMapDrago is the adaptive logarithmic mapping of Drago et al.
+	ToneMapDrago
+	// ToneMapMantiuk compresses log contrast in the response domain of
+	// Mantiuk's contrast transducer
+	ToneMapMantiuk
+)
+
+// ToneMapOptions configures ToneMap. Zero values pick the defaults.
+type ToneMapOptions struct {
+	Operator ToneMapOperator
+	// Exposure scales the input by 2^Exposure before mapping
+	Exposure float64
+	// Key is the Reinhard middle gray, 0.18 by default
+	Key float64
+	// White is the smallest key-scaled luminance the global Reinhard
+	// operator maps to white; by default the brightest pixel
+	White float64
+	// Bias sets Drago's contrast between 0.5 and 1, 0.85 by default
+	Bias float64
+	// Contrast scales Mantiuk contrast responses, 0.7 by default
+	Contrast float64
+	// Saturation is applied when colors are rebuilt from the mapped
+	// luminance, 1 by default. ToneMapACES maps channels directly and
+	// ignores it.
+	Saturation float64
+}
+
+// toneLuminance is the Rec. 709 luminance of a linear color
+func toneLuminance(r, g, b float64) float64 {
+	return math.Max(0.2126*r+0.7152*g+0.0722*b, 0)
+}
+
+// logAverage returns the geometric mean luminance, Reinhard's estimate of
+// the scene key
+func logAverage(lum []float64) float64 {
+	var sum float64
+	for _, l := range lum {
+		sum += math.Log(1e-6 + l)
+	}
+	return math.Exp(sum / float64(max(len(lum), 1)))
+}
+
+// ToneMap compresses a linear light image, as produced by LoadHDR, LoadEXR
+// or MergeDebevec, into a displayable sRGB image
+func ToneMap(img *RGBAF32, opts ToneMapOptions) *image.RGBA {
+	if opts.Key <= 0 {
+		opts.Key = 0.18
+	}
+	if opts.Bias <= 0 {
+		opts.Bias = 0.85
+	}
+	if opts.Contrast <= 0 {
+		opts.Contrast = 0.7
+	}
+	if opts.Saturation <= 0 {
+		opts.Saturation = 1
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return image.NewRGBA(image.Rect(0, 0, 0, 0))
+	}
+	exposure := math.Exp2(opts.Exposure)
+	rgb := make([]float64, w*h*3)
+	alpha := make([]float64, w*h)
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			r, g, bl, a := img.FloatAt(b.Min.X+x, b.Min.Y+y)
+			alpha[i] = clamp(float64(a), 0, 1)
+			if a > 0 {
+				rgb[i*3] = math.Max(float64(r/a)*exposure, 0)
+				rgb[i*3+1] = math.Max(float64(g/a)*exposure, 0)
+				rgb[i*3+2] = math.Max(float64(bl/a)*exposure, 0)
+			}
+			lum[i] = toneLuminance(rgb[i*3], rgb[i*3+1], rgb[i*3+2])
+		}
+	}
+
+	if opts.Operator == ToneMapACES {
+		for i := 0; i < len(rgb); i += 3 {
+			rgb[i], rgb[i+1], rgb[i+2] = acesFitted(rgb[i], rgb[i+1], rgb[i+2])
+		}
+	} else {
+		var display []float64
+		switch opts.Operator {
+		case ToneMapReinhardLocal:
+			display = reinhardLocal(lum, w, h, opts)
+		case ToneMapDrago:
+			display = drago(lum, opts)
+		case ToneMapMantiuk:
+			display = mantiuk(lum, opts)
+		default:
+			display = reinhardGlobal(lum, opts)
+		}
+		// Rebuild colors from the mapped luminance, keeping hue
+		for i, l := range lum {
+			for c := 0; c < 3; c++ {
+				if l > 0 {
+					rgb[i*3+c] = math.Pow(rgb[i*3+c]/l, opts.Saturation) * display[i]
+				} else {
+					rgb[i*3+c] = display[i]
+				}
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, a := range alpha {
+		for c := 0; c < 3; c++ {
+			dst.Pix[i*4+c] = uint8(linearToSRGB(clamp(rgb[i*3+c], 0, 1))*a*255 + 0.5)
+		}
+		dst.Pix[i*4+3] = uint8(a*255 + 0.5)
+	}
+	return dst
+}
+
+func reinhardGlobal(lum []float64, opts ToneMapOptions) []float64 {
+	scale := opts.Key / logAverage(lum)
+	white := opts.White
+	if white <= 0 {
+		for _, l := range lum {
+			white = math.Max(white, l*scale)
+		}
+	}
+	white2 := math.Max(white*white, 1e-12)
+	out := make([]float64, len(lum))
+	for i, l := range lum {
+		l *= scale
+		out[i] = l * (1 + l/white2) / (1 + l)
+	}
+	return out
+}
+
+// reinhardLocal implements dodging and burning: for each pixel the
+// surround grows through scales 1.6^i while the center-surround contrast
+// stays below a threshold, and the pixel is compressed by that surround
+func reinhardLocal(lum []float64, w, h int, opts ToneMapOptions) []float64 {
+	const (
+		scales    = 8
+		alpha1    = 0.35
+		phi       = 8.0
+		threshold = 0.05
+	)
+	scale := opts.Key / logAverage(lum)
+	scaled := make([]float32, len(lum))
+	for i, l := range lum {
+		scaled[i] = float32(l * scale)
+	}
+
+	// blurred[i] is the center response at scale 1.6^i; the surround of
+	// scale i is the center of scale i+1
+	blurred := make([][]float32, scales+1)
+	for i := range blurred {
+		s := math.Pow(1.6, float64(i))
+		buf := append([]float32(nil), scaled...)
+		blurPlanar(buf, w, h, 1, BlurOptions{Sigma: alpha1 * s / math.Sqrt2, Edge: EdgeClamp})
+		blurred[i] = buf
+	}
+
+	out := make([]float64, len(lum))
+	for p := range lum {
+		v1 := float64(blurred[0][p])
+		for i := 0; i < scales; i++ {
+			s := math.Pow(1.6, float64(i))
+			center, surround := float64(blurred[i][p]), float64(blurred[i+1][p])
+			if math.Abs((center-surround)/(math.Exp2(phi)*opts.Key/(s*s)+center)) >= threshold {
+				break
+			}
+			v1 = center
+		}
+		out[p] = float64(scaled[p]) / (1 + v1)
+	}
+	return out
+}
+
+// acesFitted applies Stephen Hill's fit of the ACES RRT and sRGB ODT to a
+// linear sRGB color
+func acesFitted(r, g, b float64) (float64, float64, float64) {
+	in := mulMatrix3([3][3]float64{
+		{0.59719, 0.35458, 0.04823},
+		{0.07600, 0.90834, 0.01566},
+		{0.02840, 0.13383, 0.83777},
+	}, [3]float64{r, g, b})
+	for i, v := range in {
+		in[i] = (v*(v+0.0245786) - 0.000090537) / (v*(0.983729*v+0.4329510) + 0.238081)
+	}
+	out := mulMatrix3([3][3]float64{
+		{1.60475, -0.53108, -0.07367},
+		{-0.10208, 1.10813, -0.00605},
+		{-0.00327, -0.07276, 1.07602},
+	}, in)
+	return out[0], out[1], out[2]
+}
+
+// drago maps luminance logarithmically, with a log base that moves from
+// 2 for dark pixels to 10 for the brightest
+func drago(lum []float64, opts ToneMapOptions) []float64 {
+	avg := logAverage(lum)
+	lmax := 0.0
+	for _, l := range lum {
+		lmax = math.Max(lmax, l/avg)
+	}
+	lmax = math.Max(lmax, 1e-6)
+	biasPower := math.Log(clamp(opts.Bias, 0.5, 1)) / math.Log(0.5)
+	norm := 1 / math.Log10(lmax+1)
+	out := make([]float64, len(lum))
+	for i, l := range lum {
+		l /= avg
+		out[i] = norm * math.Log(l+1) / math.Log(2+8*math.Pow(l/lmax, biasPower))
+	}
+	return out
+}
+
+// mantiuk scales log10 contrast through the power-law transducer
+// R = 54.09 G^0.4185, so a response scale of Contrast compresses log
+// contrast by Contrast^(1/0.4185). The 99.5th percentile maps to white.
+func mantiuk(lum []float64, opts ToneMapOptions) []float64 {
+	const responsePower = 0.4185
+	factor := math.Pow(opts.Contrast, 1/responsePower)
+	logs := make([]float64, len(lum))
+	for i, l := range lum {
+		logs[i] = math.Log10(l + 1e-6)
+	}
+	sorted := append([]float64(nil), logs...)
+	sort.Float64s(sorted)
+	white := sorted[min(len(sorted)-1, len(sorted)*995/1000)]
+	out := make([]float64, len(lum))
+	for i, v := range logs {
+		out[i] = math.Pow(10, factor*(v-white))
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// radianceRamp spans 14 stops of radiance from left to right
+func radianceRamp(w, h int) *RGBAF32 {
+	img := NewRGBAF32(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := float32(math.Exp2(float64(x)/float64(w-1)*14 - 8))
+			img.SetFloat(x, y, v, v*0.8, v*0.6, 1)
+		}
+	}
+	return img
+}
+
+func TestToneMapOperators(t *testing.T) {
+	src := radianceRamp(256, 8)
+	for _, op := range []ToneMapOperator{ToneMapReinhard, ToneMapReinhardLocal, ToneMapACES, ToneMapDrago, ToneMapMantiuk} {
+		out := ToneMap(src, ToneMapOptions{Operator: op})
+		if out.Bounds() != src.Bounds() {
+			t.Fatalf("operator %d: bounds %v", op, out.Bounds())
+		}
+		// Global operators keep the ramp monotonic, and every operator
+		// compresses the range into the display without crushing either end
+		prev := -1
+		for x := 0; x < 256 && op != ToneMapReinhardLocal; x++ {
+			g := int(out.RGBAAt(x, 4).G)
+			if g+1 < prev {
+				t.Errorf("operator %d: pixel %d = %d after %d", op, x, g, prev)
+				break
+			}
+			prev = max(prev, g)
+		}
+		if first, last := out.RGBAAt(0, 4), out.RGBAAt(255, 4); first.G > 40 || last.G < 200 {
+			t.Errorf("operator %d: ramp maps to %v .. %v", op, first, last)
+		}
+		if mid := out.RGBAAt(128, 4); mid.R <= mid.G || mid.G <= mid.B || mid.A != 255 {
+			t.Errorf("operator %d: warm midtone became %v", op, mid)
+		}
+	}
+}
+
+func TestToneMapReferenceValues(t *testing.T) {
+	// A uniform image at its own key maps to Reinhard's L/(1+L) of the key
+	flat := NewRGBAF32(image.Rect(0, 0, 4, 4))
+	for i := range flat.Pix {
+		flat.Pix[i] = 2
+	}
+	got := ToneMap(flat, ToneMapOptions{White: 1e9}).RGBAAt(1, 1).G
+	assertNear(t, "reinhard", got, refEncode(0.18/1.18), 1)
+
+	// Hill's ACES fit keeps neutrals neutral and maps 1.0 to about 0.62
+	one := NewRGBAF32(image.Rect(0, 0, 1, 1))
+	one.SetFloat(0, 0, 1, 1, 1, 1)
+	r, g, b := acesFitted(1, 1, 1)
+	if math.Abs(r-0.619) > 0.002 || math.Abs(r-g) > 1e-3 || math.Abs(r-b) > 1e-3 {
+		t.Errorf("ACES(1) = %v %v %v", r, g, b)
+	}
+	assertNear(t, "aces", ToneMap(one, ToneMapOptions{Operator: ToneMapACES}).RGBAAt(0, 0).R, refEncode(r), 1)
+
+	// Exposure shifts the input by whole stops
+	dark := ToneMap(one, ToneMapOptions{Operator: ToneMapACES, Exposure: -1}).RGBAAt(0, 0).R
+	r, _, _ = acesFitted(0.5, 0.5, 0.5)
+	assertNear(t, "aces exposure", dark, refEncode(r), 1)
+}
+
+func TestToneMapReinhardLocal(t *testing.T) {
+	// Without local contrast every surround equals the pixel, so dodging
+	// and burning reduces to L/(1+L) of the key-scaled luminance
+	flat := NewRGBAF32(image.Rect(0, 0, 16, 16))
+	for i := range flat.Pix {
+		flat.Pix[i] = 5
+	}
+	got := ToneMap(flat, ToneMapOptions{Operator: ToneMapReinhardLocal}).RGBAAt(8, 8).G
+	assertNear(t, "flat", got, refEncode(0.18/1.18), 1)
+
+	// A dim pixel beside a bright area is compressed by the small surround
+	// that excludes the bright area, not by the bright area itself
+	img := NewRGBAF32(image.Rect(0, 0, 64, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 64; x++ {
+			v := float32(0.05)
+			if x >= 32 {
+				v = 100
+			}
+			img.SetFloat(x, y, v, v, v, 1)
+		}
+	}
+	lum := make([]float64, 64*16)
+	for i := range lum {
+		lum[i] = float64(img.Pix[i*4])
+	}
+	out := reinhardLocal(lum, 64, 16, ToneMapOptions{Key: 0.18})
+	scale := 0.18 / logAverage(lum)
+	if want := 0.05 * scale / (1 + 0.05*scale); math.Abs(out[8*64+29]-want) > want*0.05 {
+		t.Errorf("dim pixel = %g, want %g", out[8*64+29], want)
+	}
+}
+
+func TestMergeDebevec(t *testing.T) {
+	// Simulate a camera with an sRGB-like response photographing a scene
+	// spanning 10 stops, bracketed at -2, 0 and +2 EV
+	const w, h = 64, 48
+	radiance := func(x, y int) float64 { return math.Exp2(float64(x)/(w-1)*9.5-8) * (1 + 0.3*float64(y%3)) }
+	times := []float64{0.25, 1, 4}
+	var exposures []image.Image
+	for _, t := range times {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				e := radiance(x, y) * t
+				v := uint8(linearToSRGB(clamp(e, 0, 1))*255 + 0.5)
+				img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+			}
+		}
+		exposures = append(exposures, img)
+	}
+
+	response, err := RecoverCameraResponse(exposures, times, DebevecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The curve rises with the pixel value and matches the simulated one
+	// up to the arbitrary scale fixed at 128
+	for z := 20; z < 250; z += 10 {
+		want := math.Log(srgbToLinear(float64(z)/255)) - math.Log(srgbToLinear(128.0/255))
+		if math.Abs(response[1][z]-want) > 0.15 {
+			t.Errorf("g(%d) = %.3f, want %.3f", z, response[1][z], want)
+		}
+	}
+
+	// Every pixel can be a sample without growing the solved system
+	dense, err := RecoverCameraResponse(exposures, times, DebevecOptions{Samples: w * h})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for z := 20; z < 250; z += 10 {
+		want := math.Log(srgbToLinear(float64(z)/255)) - math.Log(srgbToLinear(128.0/255))
+		if math.Abs(dense[1][z]-want) > 0.15 {
+			t.Errorf("g(%d) = %.3f from every pixel, want %.3f", z, dense[1][z], want)
+		}
+	}
+
+	hdr, err := MergeDebevec(exposures, times, DebevecOptions{Response: response})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Relative radiance is recovered across the whole range
+	ref, _, _, _ := hdr.FloatAt(w/2, 0)
+	for x := 4; x < w-4; x += 6 {
+		got, _, _, _ := hdr.FloatAt(x, 0)
+		want := radiance(x, 0) / radiance(w/2, 0)
+		if ratio := float64(got/ref) / want; ratio < 0.85 || ratio > 1.15 {
+			t.Errorf("pixel %d has relative radiance %.3f, want %.3f", x, got/ref, want)
+		}
+	}
+	if out := ToneMap(hdr, ToneMapOptions{}); out.Bounds().Dx() != w {
+		t.Error("tone mapping the merge failed")
+	}
+
+	if _, err := MergeDebevec(exposures, times[:2], DebevecOptions{}); err == nil {
+		t.Error("expected an error for mismatched exposure times")
+	}
+}
@@ -33,25 +33,21 @@ func Degrees(radians float64) float64 {
 	return radians * 180 / math.Pi
 }
 
+// LoadImage loads an image in any registered format, detected from the
+// file content. The header is checked against the decode limits first.
 func LoadImage(path string) (image.Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	im, _, err := image.Decode(file)
-	return im, err
+	return loadImageFile(path, "LoadImage", loadFormats, image.DecodeConfig, func(r io.Reader) (image.Image, error) {
+		im, _, err := image.Decode(r)
+		return im, err
+	})
 }
 
 func LoadPNG(path string) (image.Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	return png.Decode(file)
+	return loadImageFile(path, "LoadPNG", []string{"png"}, withFormat("png", png.DecodeConfig), png.Decode)
 }
 
+// SavePNG encodes the image as a PNG and writes it to disk. 16-bit and
+// float images are written with 16 bits per channel.
 func SavePNG(path string, im image.Image) error {
 	file, err := os.Create(path)
 	if err != nil {
@@ -91,63 +87,47 @@ func SaveBMP(path string, im image.Image) error {
 	return bmp.Encode(file, im)
 }
 
-// SaveTIFF encodes the image as a TIFF and writes it to disk.
+// SaveTIFF encodes the image as a TIFF and writes it to disk. CMYK images
+// are written as 4-channel separated TIFFs, and 16-bit and float images
+// with 16 bits per sample.
 func SaveTIFF(path string, im image.Image) error {
 	file, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	switch m := im.(type) {
+	case *image.CMYK:
+		return EncodeCMYKTIFF(file, m)
+	case *RGBAF32:
+		// Written as 16 bits per sample
+		im = ToRGBA64(m)
+	}
 	return tiff.Encode(file, im, nil)
 }
 
 func LoadJPG(path string) (image.Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	return jpeg.Decode(file)
+	return loadImageFile(path, "LoadJPG", []string{"jpg"}, withFormat("jpeg", jpeg.DecodeConfig), jpeg.Decode)
 }
 
 // LoadGIF loads a GIF image from the specified file path.
 func LoadGIF(path string) (image.Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	return gif.Decode(file)
+	return loadImageFile(path, "LoadGIF", []string{"gif"}, withFormat("gif", gif.DecodeConfig), gif.Decode)
 }
 
 // LoadBMP loads a BMP image from the specified file path.
 func LoadBMP(path string) (image.Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	return bmp.Decode(file)
+	return loadImageFile(path, "LoadBMP", []string{"bmp"}, withFormat("bmp", bmp.DecodeConfig), bmp.Decode)
 }
 
 // LoadTIFF loads a TIFF image from the specified file path.
 func LoadTIFF(path string) (image.Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	return tiff.Decode(file)
+	return loadImageFile(path, "LoadTIFF", []string{"tiff"}, withFormat("tiff", tiff.DecodeConfig), tiff.Decode)
 }
 
 // LoadWebP loads a WebP image from the specified file path.
 func LoadWebP(path string) (image.Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	return webp.Decode(file)
+	return loadImageFile(path, "LoadWebP", []string{"webp"}, withFormat("webp", webp.DecodeConfig), webp.Decode)
 }
 
 func SaveJPG(path string, im image.Image, quality int) error {
@@ -170,6 +150,15 @@ func imageToRGBA(src image.Image) *image.RGBA {
 	return dst
 }
 
+// asRGBA returns img itself when it is already an *image.RGBA, otherwise a
+// converted copy with the same bounds
+func asRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	return imageToRGBA(img)
+}
+
 func parseHexColor(x string) (r, g, b, a int) {
 	x = strings.TrimPrefix(x, "#")
 	a = 255
@@ -226,7 +215,13 @@ func LoadFontFace(path string, points float64) (font.Face, error) {
 }
 
 // LoadFontBytes loads font data from a file, handling WOFF conversion if necessary.
+// Files larger than the MaxFontSize decode limit are rejected before reading.
 func LoadFontBytes(path string) ([]byte, error) {
+	if info, err := os.Stat(path); err == nil {
+		if limit := GetDecodeLimits().MaxFontSize; limit > 0 && info.Size() > limit {
+			return nil, limitError("LoadFontBytes", info.Size(), "MaxFontSize", limit).WithContext("filepath", path)
+		}
+	}
 	fontBytes, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -234,14 +229,25 @@ func LoadFontBytes(path string) ([]byte, error) {
 
 	// Check for WOFF header
 	if len(fontBytes) >= 4 && string(fontBytes[:4]) == "wOFF" {
-		return ParseWOFF(fontBytes)
+		if fontBytes, err = ParseWOFF(fontBytes); err != nil {
+			return nil, err
+		}
 	}
 
 	// TODO: Add WOFF2 support
+	if err := checkFontData("LoadFontBytes", fontBytes); err != nil {
+		return nil, err.WithContext("filepath", path)
+	}
 	return fontBytes, nil
 }
 
+func woffFormatError(details string) *AdvanceError {
+	return NewInvalidFormatError("WOFF", []string{"woff"}).WithContext("details", details)
+}
+
 // ParseWOFF parses a WOFF file and returns the SFNT (TTF/OTF) data.
+// Tables are checked against the font decode limits before they are
+// decompressed.
 func ParseWOFF(data []byte) ([]byte, error) {
 	reader := bytes.NewReader(data)
 
@@ -263,11 +269,18 @@ func ParseWOFF(data []byte) ([]byte, error) {
 	}
 
 	if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
-		return nil, err
+		return nil, woffFormatError("truncated header")
 	}
 
 	if string(header.Signature[:]) != "wOFF" {
-		return nil, fmt.Errorf("invalid WOFF signature")
+		return nil, woffFormatError("invalid WOFF signature")
+	}
+	if header.NumTables == 0 {
+		return nil, woffFormatError("no tables")
+	}
+	limits := GetDecodeLimits()
+	if limits.MaxFontSize > 0 && int64(header.TotalSfntSize) > limits.MaxFontSize {
+		return nil, limitError("ParseWOFF", int64(header.TotalSfntSize), "MaxFontSize", limits.MaxFontSize)
 	}
 
 	// Read Table Directory
@@ -281,7 +294,27 @@ func ParseWOFF(data []byte) ([]byte, error) {
 
 	woffTables := make([]woffTableEntry, header.NumTables)
 	if err := binary.Read(reader, binary.BigEndian, &woffTables); err != nil {
-		return nil, err
+		return nil, woffFormatError("truncated table directory")
+	}
+
+	// Check every table against the file and the limits before anything
+	// is decompressed
+	var total int64
+	for _, entry := range woffTables {
+		if int64(entry.Offset)+int64(entry.CompLength) > int64(len(data)) {
+			return nil, woffFormatError(fmt.Sprintf("table %q lies outside the file", entry.Tag[:]))
+		}
+		if entry.CompLength > entry.OrigLength {
+			return nil, woffFormatError(fmt.Sprintf("table %q is larger compressed than decompressed", entry.Tag[:]))
+		}
+		if limits.MaxFontTableSize > 0 && int64(entry.OrigLength) > limits.MaxFontTableSize {
+			return nil, limitError("ParseWOFF", int64(entry.OrigLength), "MaxFontTableSize", limits.MaxFontTableSize).
+				WithContext("table", string(entry.Tag[:]))
+		}
+		total += int64(entry.OrigLength)
+	}
+	if limits.MaxFontSize > 0 && total > limits.MaxFontSize {
+		return nil, limitError("ParseWOFF", total, "MaxFontSize", limits.MaxFontSize)
 	}
 
 	// Prepare SFNT Header
@@ -343,29 +376,27 @@ func ParseWOFF(data []byte) ([]byte, error) {
 		decodedTables[i].Checksum = entry.OrigChecksum
 
 		// Read compressed data
-		compressedData := make([]byte, entry.CompLength)
-		if _, err := reader.ReadAt(compressedData, int64(entry.Offset)); err != nil {
-			return nil, err
-		}
+		compressedData := data[entry.Offset : entry.Offset+entry.CompLength]
 
 		var tableData []byte
 		if entry.CompLength < entry.OrigLength {
 			// Decompress
 			zr, err := zlib.NewReader(bytes.NewReader(compressedData))
 			if err != nil {
-				return nil, err
+				return nil, woffFormatError(err.Error())
 			}
-			tableData, err = io.ReadAll(zr)
+			// Never inflate past the declared size
+			tableData, err = io.ReadAll(io.LimitReader(zr, int64(entry.OrigLength)+1))
 			zr.Close()
 			if err != nil {
-				return nil, err
+				return nil, woffFormatError(err.Error())
 			}
 			if uint32(len(tableData)) != entry.OrigLength {
-				return nil, fmt.Errorf("decompressed size mismatch")
+				return nil, woffFormatError("decompressed size mismatch")
 			}
 		} else {
-			// Uncompressed
-			tableData = compressedData
+			// Uncompressed; copied because padding is appended below
+			tableData = append([]byte(nil), compressedData...)
 		}
 
 		// Padding to 4-byte boundary
@@ -441,6 +472,9 @@ func LoadOTFFace(path string, points float64) (font.Face, error) {
 // ParseFontFace parses font data from bytes and creates a font face.
 // Supports both TTF and OTF formats.
 func ParseFontFace(fontBytes []byte, points float64) (font.Face, error) {
+	if err := checkFontData("ParseFontFace", fontBytes); err != nil {
+		return nil, err
+	}
 	f, err := truetype.Parse(fontBytes)
 	if err != nil {
 		return nil, err
@@ -454,6 +488,9 @@ func ParseFontFace(fontBytes []byte, points float64) (font.Face, error) {
 
 // ParseFontFaceWithOptions parses font data with custom options.
 func ParseFontFaceWithOptions(fontBytes []byte, options *truetype.Options) (font.Face, error) {
+	if err := checkFontData("ParseFontFaceWithOptions", fontBytes); err != nil {
+		return nil, err
+	}
 	f, err := truetype.Parse(fontBytes)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,409 @@
+package core
+
+import (
+	"image"
+	"math"
+)
+
+// Inverse-mapping warp engine and geometric distortion filters
+
+// Sampler selects how source pixels are interpolated during a warp
+type Sampler int
+
+const (
+	SamplerBilinear Sampler = iota
+	SamplerNearest
+	// SamplerBicubic uses a Catmull-Rom kernel over a 4x4 neighborhood
+	SamplerBicubic
+	// SamplerLanczos uses a 3-lobe Lanczos kernel over a 6x6 neighborhood
+	SamplerLanczos
+)
+
+// WarpFunc maps a destination point to the source point it samples. Both are
+// in pixel units relative to the image's top-left corner, so the center of
+// the first pixel is (0.5, 0.5).
+type WarpFunc func(x, y float64) (sx, sy float64)
+
+// WarpOptions configures WarpWithOptions
+type WarpOptions struct {
+	Sampler Sampler
+	// Edge controls samples that fall outside the source image
+	Edge EdgeMode
+	// Size of the output, starting at the origin; the source bounds when zero
+	Size image.Point
+}
+
+// Warp resamples img through mapFunc, repeating edge pixels for samples that
+// fall outside the image
+func Warp(img image.Image, mapFunc WarpFunc, sampler Sampler) *image.RGBA {
+	return WarpWithOptions(img, mapFunc, WarpOptions{Sampler: sampler, Edge: EdgeClamp})
+}
+
+// WarpWithOptions resamples img through mapFunc in premultiplied alpha
+func WarpWithOptions(img image.Image, mapFunc WarpFunc, opts WarpOptions) *image.RGBA {
+	src := asRGBA(img)
+	bounds := src.Bounds()
+	result := image.NewRGBA(bounds)
+	if opts.Size != (image.Point{}) {
+		result = image.NewRGBA(image.Rectangle{Max: opts.Size})
+	}
+	w, h := result.Bounds().Dx(), result.Bounds().Dy()
+	if w == 0 || h == 0 || bounds.Empty() {
+		return result
+	}
+
+	parallelRows(h, 0, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := result.Pix[y*result.Stride:]
+			for x := 0; x < w; x++ {
+				sx, sy := mapFunc(float64(x)+0.5, float64(y)+0.5)
+				var px [4]float64
+				switch opts.Sampler {
+				case SamplerNearest:
+					px = sampleNearest(src, sx, sy, opts.Edge)
+				case SamplerBicubic:
+					px = sampleBicubic(src, sx, sy, opts.Edge)
+				case SamplerLanczos:
+					px = sampleLanczos(src, sx, sy, opts.Edge)
+				default:
+					px = sampleBilinear(src, sx, sy, opts.Edge)
+				}
+				setPremultiplied(row[x*4:x*4+4], px[0], px[1], px[2], px[3])
+			}
+		}
+	})
+	return result
+}
+
+// WarpFilter returns a Filter that warps through the map built for each
+// image's size by makeMap
+func WarpFilter(makeMap func(w, h int) WarpFunc, opts WarpOptions) Filter {
+	return func(img image.Image) image.Image {
+		b := img.Bounds()
+		return WarpWithOptions(img, makeMap(b.Dx(), b.Dy()), opts)
+	}
+}
+
+// fetchPixel returns the premultiplied sample at integer coordinates relative
+// to the image origin, resolving out-of-range coordinates with edge
+func fetchPixel(img *image.RGBA, x, y int, edge EdgeMode) [4]float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if x < 0 || x >= w || y < 0 || y >= h {
+		switch edge {
+		case EdgeTransparent:
+			return [4]float64{}
+		case EdgeWrap:
+			x, y = ((x%w)+w)%w, ((y%h)+h)%h
+		default:
+			x, y = clampInt(x, 0, w-1), clampInt(y, 0, h-1)
+		}
+	}
+	i := img.PixOffset(b.Min.X+x, b.Min.Y+y)
+	return [4]float64{float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2]), float64(img.Pix[i+3])}
+}
+
+func sampleNearest(img *image.RGBA, sx, sy float64, edge EdgeMode) [4]float64 {
+	return fetchPixel(img, int(math.Floor(sx)), int(math.Floor(sy)), edge)
+}
+
+func sampleBilinear(img *image.RGBA, sx, sy float64, edge EdgeMode) [4]float64 {
+	fx, fy := sx-0.5, sy-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+
+	p00 := fetchPixel(img, x0, y0, edge)
+	p10 := fetchPixel(img, x0+1, y0, edge)
+	p01 := fetchPixel(img, x0, y0+1, edge)
+	p11 := fetchPixel(img, x0+1, y0+1, edge)
+
+	var out [4]float64
+	for c := 0; c < 4; c++ {
+		top := p00[c]*(1-tx) + p10[c]*tx
+		bottom := p01[c]*(1-tx) + p11[c]*tx
+		out[c] = top*(1-ty) + bottom*ty
+	}
+	return out
+}
+
+func sampleBicubic(img *image.RGBA, sx, sy float64, edge EdgeMode) [4]float64 {
+	fx, fy := sx-0.5, sy-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+
+	var wx, wy [4]float64
+	for i := 0; i < 4; i++ {
+		wx[i] = catmullRom(tx - float64(i-1))
+		wy[i] = catmullRom(ty - float64(i-1))
+	}
+
+	var out [4]float64
+	for j := 0; j < 4; j++ {
+		var row [4]float64
+		for i := 0; i < 4; i++ {
+			p := fetchPixel(img, x0+i-1, y0+j-1, edge)
+			for c := 0; c < 4; c++ {
+				row[c] += p[c] * wx[i]
+			}
+		}
+		for c := 0; c < 4; c++ {
+			out[c] += row[c] * wy[j]
+		}
+	}
+	return out
+}
+
+func sampleLanczos(img *image.RGBA, sx, sy float64, edge EdgeMode) [4]float64 {
+	fx, fy := sx-0.5, sy-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+
+	var wx, wy [6]float64
+	var sumX, sumY float64
+	for i := 0; i < 6; i++ {
+		wx[i] = lanczos3(tx - float64(i-2))
+		wy[i] = lanczos3(ty - float64(i-2))
+		sumX += wx[i]
+		sumY += wy[i]
+	}
+
+	var out [4]float64
+	for j := 0; j < 6; j++ {
+		var row [4]float64
+		for i := 0; i < 6; i++ {
+			p := fetchPixel(img, x0+i-2, y0+j-2, edge)
+			for c := 0; c < 4; c++ {
+				row[c] += p[c] * wx[i]
+			}
+		}
+		for c := 0; c < 4; c++ {
+			out[c] += row[c] * wy[j]
+		}
+	}
+	for c := range out {
+		out[c] /= sumX * sumY
+	}
+	return out
+}
+
+// lanczos3 evaluates the 3-lobe Lanczos window at distance t
+func lanczos3(t float64) float64 {
+	t = math.Abs(t)
+	switch {
+	case t < 1e-9:
+		return 1
+	case t < 3:
+		pt := math.Pi * t
+		return 3 * math.Sin(pt) * math.Sin(pt/3) / (pt * pt)
+	default:
+		return 0
+	}
+}
+
+// catmullRom evaluates the Catmull-Rom cubic (a = -0.5) at distance t
+func catmullRom(t float64) float64 {
+	t = math.Abs(t)
+	switch {
+	case t < 1:
+		return 1.5*t*t*t - 2.5*t*t + 1
+	case t < 2:
+		return -0.5*t*t*t + 2.5*t*t - 4*t + 2
+	default:
+		return 0
+	}
+}
+
+// defaultRadius returns r, or half the shorter side when r is not positive
+func defaultRadius(r float64, w, h int) float64 {
+	if r > 0 {
+		return r
+	}
+	return math.Min(float64(w), float64(h)) / 2
+}
+
+// CartesianToPolar unwraps the image around its center: in the result,
+// x runs through the angle from -pi to pi and y through the radius from the
+// center out to maxRadius (half the shorter side when not positive)
+func CartesianToPolar(maxRadius float64) Filter {
+	return WarpFilter(func(w, h int) WarpFunc {
+		cx, cy := float64(w)/2, float64(h)/2
+		r := defaultRadius(maxRadius, w, h)
+		return func(x, y float64) (float64, float64) {
+			theta := x/float64(w)*2*math.Pi - math.Pi
+			rho := y / float64(h) * r
+			return cx + rho*math.Cos(theta), cy + rho*math.Sin(theta)
+		}
+	}, WarpOptions{Edge: EdgeTransparent})
+}
+
+// PolarToCartesian is the inverse of CartesianToPolar: it wraps a polar
+// image, angle along x and radius along y, back around the image center
+func PolarToCartesian(maxRadius float64) Filter {
+	return WarpFilter(func(w, h int) WarpFunc {
+		cx, cy := float64(w)/2, float64(h)/2
+		r := defaultRadius(maxRadius, w, h)
+		return func(x, y float64) (float64, float64) {
+			dx, dy := x-cx, y-cy
+			theta := math.Atan2(dy, dx)
+			rho := math.Hypot(dx, dy)
+			if rho > r {
+				return -1, -1
+			}
+			return (theta + math.Pi) / (2 * math.Pi) * float64(w), rho / r * float64(h)
+		}
+	}, WarpOptions{Edge: EdgeTransparent})
+}
+
+// CartesianToLogPolar unwraps the image like CartesianToPolar but spaces the
+// radius logarithmically from 1 pixel to maxRadius, so scaling about the
+// center becomes a vertical shift
+func CartesianToLogPolar(maxRadius float64) Filter {
+	return WarpFilter(func(w, h int) WarpFunc {
+		cx, cy := float64(w)/2, float64(h)/2
+		logR := math.Log(math.Max(defaultRadius(maxRadius, w, h), 1.0001))
+		return func(x, y float64) (float64, float64) {
+			theta := x/float64(w)*2*math.Pi - math.Pi
+			rho := math.Exp(y / float64(h) * logR)
+			return cx + rho*math.Cos(theta), cy + rho*math.Sin(theta)
+		}
+	}, WarpOptions{Edge: EdgeTransparent})
+}
+
+// LogPolarToCartesian is the inverse of CartesianToLogPolar
+func LogPolarToCartesian(maxRadius float64) Filter {
+	return WarpFilter(func(w, h int) WarpFunc {
+		cx, cy := float64(w)/2, float64(h)/2
+		r := defaultRadius(maxRadius, w, h)
+		logR := math.Log(math.Max(r, 1.0001))
+		return func(x, y float64) (float64, float64) {
+			dx, dy := x-cx, y-cy
+			rho := math.Hypot(dx, dy)
+			if rho > r || rho < 1 {
+				return -1, -1
+			}
+			theta := math.Atan2(dy, dx)
+			return (theta + math.Pi) / (2 * math.Pi) * float64(w), math.Log(rho) / logR * float64(h)
+		}
+	}, WarpOptions{Edge: EdgeTransparent})
+}
+
+// Twirl rotates the image around its center by angle radians, fading to no
+// rotation at radius (half the shorter side when not positive)
+func Twirl(angle, radius float64) Filter {
+	return WarpFilter(func(w, h int) WarpFunc {
+		cx, cy := float64(w)/2, float64(h)/2
+		r := defaultRadius(radius, w, h)
+		return func(x, y float64) (float64, float64) {
+			dx, dy := x-cx, y-cy
+			d := math.Hypot(dx, dy)
+			if d >= r {
+				return x, y
+			}
+			t := 1 - d/r
+			phi := angle * t * t
+			sin, cos := math.Sincos(phi)
+			return cx + dx*cos - dy*sin, cy + dx*sin + dy*cos
+		}
+	}, WarpOptions{Edge: EdgeClamp})
+}
+
+// PinchBulge pulls the image toward its center for positive amounts (pinch)
+// and pushes it outward for negative amounts (bulge). Amount is in -1..1
+// and the effect fades out at radius.
+func PinchBulge(amount, radius float64) Filter {
+	amount = clamp(amount, -1, 1)
+	return WarpFilter(func(w, h int) WarpFunc {
+		cx, cy := float64(w)/2, float64(h)/2
+		r := defaultRadius(radius, w, h)
+		return func(x, y float64) (float64, float64) {
+			dx, dy := x-cx, y-cy
+			d := math.Hypot(dx, dy)
+			if d >= r || d == 0 {
+				return x, y
+			}
+			factor := math.Pow(math.Sin(math.Pi/2*d/r), -amount)
+			return cx + dx*factor, cy + dy*factor
+		}
+	}, WarpOptions{Edge: EdgeClamp})
+}
+
+// Ripple displaces pixels radially with concentric waves around the center
+func Ripple(amplitude, wavelength float64) Filter {
+	return WarpFilter(func(w, h int) WarpFunc {
+		cx, cy := float64(w)/2, float64(h)/2
+		return func(x, y float64) (float64, float64) {
+			dx, dy := x-cx, y-cy
+			d := math.Hypot(dx, dy)
+			if d == 0 || wavelength == 0 {
+				return x, y
+			}
+			offset := amplitude * math.Sin(2*math.Pi*d/wavelength)
+			return x + dx/d*offset, y + dy/d*offset
+		}
+	}, WarpOptions{Edge: EdgeClamp})
+}
+
+// Wave displaces pixels with sine waves: rows shift horizontally by up to
+// amplitudeX with period wavelengthX along y, and columns shift vertically
+// by up to amplitudeY with period wavelengthY along x
+func Wave(amplitudeX, wavelengthX, amplitudeY, wavelengthY float64) Filter {
+	return WarpFilter(func(w, h int) WarpFunc {
+		return func(x, y float64) (float64, float64) {
+			sx, sy := x, y
+			if wavelengthX != 0 {
+				sx += amplitudeX * math.Sin(2*math.Pi*y/wavelengthX)
+			}
+			if wavelengthY != 0 {
+				sy += amplitudeY * math.Sin(2*math.Pi*x/wavelengthY)
+			}
+			return sx, sy
+		}
+	}, WarpOptions{Edge: EdgeClamp})
+}
+
+// LensCorrection removes radial lens distortion using the Brown-Conrady
+// model. k1 and k2 are the lens's distortion coefficients with the radius
+// normalized to half the image diagonal: negative values describe barrel
+// distortion, positive values pincushion.
+func LensCorrection(k1, k2 float64) Filter {
+	return WarpFilter(func(w, h int) WarpFunc {
+		cx, cy := float64(w)/2, float64(h)/2
+		norm := math.Hypot(cx, cy)
+		return func(x, y float64) (float64, float64) {
+			nx, ny := (x-cx)/norm, (y-cy)/norm
+			r2 := nx*nx + ny*ny
+			scale := 1 + k1*r2 + k2*r2*r2
+			return cx + nx*scale*norm, cy + ny*scale*norm
+		}
+	}, WarpOptions{Edge: EdgeTransparent})
+}
+
+// DisplacementMap shifts every pixel by the red and green channels of
+// displacement, stretched to the image size. As in SVG's
+// feDisplacementMap, 50% gray means no shift and full intensity shifts by
+// scaleX/2 and scaleY/2 pixels.
+func DisplacementMap(displacement image.Image, scaleX, scaleY float64) Filter {
+	disp := asRGBA(displacement)
+	db := disp.Bounds()
+	return WarpFilter(func(w, h int) WarpFunc {
+		return func(x, y float64) (float64, float64) {
+			if db.Empty() {
+				return x, y
+			}
+			mx := clampInt(int(x*float64(db.Dx())/float64(w)), 0, db.Dx()-1)
+			my := clampInt(int(y*float64(db.Dy())/float64(h)), 0, db.Dy()-1)
+			r, g, _, _ := straightAt(disp, db.Min.X+mx, db.Min.Y+my)
+			return x + scaleX*(float64(r)/255-0.5), y + scaleY*(float64(g)/255-0.5)
+		}
+	}, WarpOptions{Edge: EdgeClamp})
+}
+
+// Warp resamples the pixel data through mapFunc
+func (id *ImageData) Warp(mapFunc WarpFunc, sampler Sampler) *ImageData {
+	return id.withFormat(Warp(id.Image(), mapFunc, sampler))
+}
+
+// ApplyFilter runs a Filter, such as Twirl or LensCorrection, on the pixel data
+func (id *ImageData) ApplyFilter(filter Filter) *ImageData {
+	return id.withFormat(filter(id.Image()))
+}
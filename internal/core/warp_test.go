@@ -0,0 +1,87 @@
+package core
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func gradientImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / (w - 1)), uint8(y * 255 / (h - 1)), 100, 255})
+		}
+	}
+	return img
+}
+
+func maxPixelDiff(a, b *image.RGBA) int {
+	worst := 0
+	for i := range a.Pix {
+		if d := int(absDiff(a.Pix[i], b.Pix[i])); d > worst {
+			worst = d
+		}
+	}
+	return worst
+}
+
+func TestWarpIdentity(t *testing.T) {
+	img := gradientImage(24, 16)
+	identity := func(x, y float64) (float64, float64) { return x, y }
+	for _, s := range []Sampler{SamplerNearest, SamplerBilinear, SamplerBicubic} {
+		if d := maxPixelDiff(img, Warp(img, identity, s)); d > 1 {
+			t.Errorf("sampler %d: identity warp differs by %d", s, d)
+		}
+	}
+
+	for name, f := range map[string]Filter{
+		"twirl":  Twirl(0, 0),
+		"lens":   LensCorrection(0, 0),
+		"ripple": Ripple(0, 10),
+	} {
+		if d := maxPixelDiff(img, f(img).(*image.RGBA)); d > 1 {
+			t.Errorf("%s with zero strength differs by %d", name, d)
+		}
+	}
+}
+
+func TestWarpShiftAndEdges(t *testing.T) {
+	img := gradientImage(20, 20)
+	shift := func(x, y float64) (float64, float64) { return x + 3, y }
+
+	clamped := Warp(img, shift, SamplerBilinear)
+	if got, want := clamped.RGBAAt(0, 5), img.RGBAAt(3, 5); got != want {
+		t.Errorf("shifted pixel = %v, want %v", got, want)
+	}
+	if got, want := clamped.RGBAAt(19, 5), img.RGBAAt(19, 5); got != want {
+		t.Errorf("clamped edge pixel = %v, want %v", got, want)
+	}
+
+	transparent := WarpWithOptions(img, shift, WarpOptions{Edge: EdgeTransparent})
+	if a := transparent.RGBAAt(19, 5).A; a != 0 {
+		t.Errorf("pixel sampled outside image has alpha %d, want 0", a)
+	}
+}
+
+func TestPolarRoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.RGBA{40, 40, 200, 255}
+			if x >= 32 {
+				c = color.RGBA{200, 40, 40, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	back := PolarToCartesian(0)(CartesianToPolar(0)(img)).(*image.RGBA)
+	for _, p := range []image.Point{{16, 32}, {48, 32}, {20, 12}, {44, 50}} {
+		want := img.RGBAAt(p.X, p.Y)
+		got := back.RGBAAt(p.X, p.Y)
+		if absDiff(got.R, want.R) > 40 || absDiff(got.B, want.B) > 40 {
+			t.Errorf("round trip at %v = %v, want about %v", p, got, want)
+		}
+	}
+}
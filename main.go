@@ -27,6 +27,20 @@ type HSV = core.HSV
 type HSL = core.HSL
 type LAB = core.LAB
 type XYZ = core.XYZ
+type OKLab = core.OKLab
+type OKLCH = core.OKLCH
+type LCh = core.LCh
+type LinearRGB = core.LinearRGB
+type DisplayP3 = core.DisplayP3
+type Rec2020 = core.Rec2020
+
+// Color difference functions
+var (
+	DeltaE76   = core.DeltaE76
+	DeltaE94   = core.DeltaE94
+	DeltaE2000 = core.DeltaE2000
+	DeltaEOK   = core.DeltaEOK
+)
 
 // ImageData type for pixel manipulation
 type ImageData = core.ImageData
@@ -90,6 +104,11 @@ var (
 	NewContextForImage = core.NewContextForImage
 	NewContextForRGBA  = core.NewContextForRGBA
 
+	// High bit-depth canvases
+	NewContextWithFormat = core.NewContextWithFormat
+	NewContextForRGBA64  = core.NewContextForRGBA64
+	NewContextForRGBAF32 = core.NewContextForRGBAF32
+
 	// New image creation functions (Pillow-style)
 	CreateNew            = core.CreateNew
 	CreateNewRGBA        = core.CreateNewRGBA
@@ -167,12 +186,23 @@ const (
 	ResizeLanczos         = core.ResizeLanczos
 )
 
+type SeamEnergy = core.SeamEnergy
+type ContentAwareOptions = core.ContentAwareOptions
+
+const (
+	SeamEnergyGradient = core.SeamEnergyGradient
+	SeamEnergyForward  = core.SeamEnergyForward
+)
+
 var (
 	ResizeImage              = core.ResizeImage
 	ResizeImageFit           = core.ResizeImageFit
 	ResizeImageFill          = core.ResizeImageFill
 	ResizeImageWithAlgorithm = core.ResizeImageWithAlgorithm
+	ResizeImageLinear        = core.ResizeImageLinear
 	ScaleImage               = core.ScaleImage
+	ResizeContentAware       = core.ResizeContentAware
+	RemoveObject             = core.RemoveObject
 )
 
 // Image filter functions
@@ -193,6 +223,447 @@ var (
 	Vignette      = core.Vignette
 )
 
+// Gaussian blur exports
+type EdgeMode = core.EdgeMode
+type BlurMethod = core.BlurMethod
+type BlurOptions = core.BlurOptions
+
+const (
+	EdgeClamp       = core.EdgeClamp
+	EdgeWrap        = core.EdgeWrap
+	EdgeTransparent = core.EdgeTransparent
+
+	BlurAuto        = core.BlurAuto
+	BlurExact       = core.BlurExact
+	BlurExtendedBox = core.BlurExtendedBox
+)
+
+var (
+	GaussianBlur            = core.GaussianBlur
+	GaussianBlurImage       = core.GaussianBlurImage
+	GaussianBlurWithOptions = core.GaussianBlurWithOptions
+	RadiusToSigma           = core.RadiusToSigma
+)
+
+// Edge-preserving smoothing exports
+var (
+	BilateralFilter    = core.BilateralFilter
+	GuidedFilter       = core.GuidedFilter
+	GuidedFilterImage  = core.GuidedFilterImage
+	RefineMask         = core.RefineMask
+	MedianFilter       = core.MedianFilter
+	Denoise            = core.Denoise
+	DenoiseWithOptions = core.DenoiseWithOptions
+)
+
+// Color grading exports
+type CurvePoint = core.CurvePoint
+type SplineCurve = core.SplineCurve
+type CurveSet = core.CurveSet
+type LevelsOptions = core.LevelsOptions
+type ChannelMatrix = core.ChannelMatrix
+type LUT3D = core.LUT3D
+type LUTInterpolation = core.LUTInterpolation
+
+const (
+	LUTTetrahedral = core.LUTTetrahedral
+	LUTTrilinear   = core.LUTTrilinear
+)
+
+var (
+	Curves                = core.Curves
+	Levels                = core.Levels
+	ChannelLevels         = core.ChannelLevels
+	ChannelMixer          = core.ChannelMixer
+	DefaultLevels         = core.DefaultLevels
+	IdentityChannelMatrix = core.IdentityChannelMatrix
+	NewIdentityLUT3D      = core.NewIdentityLUT3D
+	LoadCubeLUT           = core.LoadCubeLUT
+	ParseCubeLUT          = core.ParseCubeLUT
+	LoadHaldCLUT          = core.LoadHaldCLUT
+	NewLUT3DFromHald      = core.NewLUT3DFromHald
+	NewHaldCLUTImage      = core.NewHaldCLUTImage
+	ApplyLUT3D            = core.ApplyLUT3D
+)
+
+// Color balance exports
+type WhiteBalanceMethod = core.WhiteBalanceMethod
+type HueRange = core.HueRange
+type HSLAdjustment = core.HSLAdjustment
+type HSLAdjustments = core.HSLAdjustments
+
+const (
+	WhiteBalanceGrayWorld  = core.WhiteBalanceGrayWorld
+	WhiteBalanceWhitePatch = core.WhiteBalanceWhitePatch
+
+	HueReds     = core.HueReds
+	HueOranges  = core.HueOranges
+	HueYellows  = core.HueYellows
+	HueGreens   = core.HueGreens
+	HueAquas    = core.HueAquas
+	HueBlues    = core.HueBlues
+	HuePurples  = core.HuePurples
+	HueMagentas = core.HueMagentas
+)
+
+var (
+	WhiteBalance             = core.WhiteBalance
+	AutoWhiteBalance         = core.AutoWhiteBalance
+	WhitePointForTemperature = core.WhitePointForTemperature
+	ColorBalance             = core.ColorBalance
+	Vibrance                 = core.Vibrance
+	SelectiveHSL             = core.SelectiveHSL
+)
+
+// Quantization and dithering exports
+type QuantizeMethod = core.QuantizeMethod
+type WeightedColor = core.WeightedColor
+type DitherAlgorithm = core.DitherAlgorithm
+
+const (
+	QuantizeWu        = core.QuantizeWu
+	QuantizeMedianCut = core.QuantizeMedianCut
+	QuantizeOctree    = core.QuantizeOctree
+	QuantizeKMeans    = core.QuantizeKMeans
+
+	DitherNone              = core.DitherNone
+	DitherFloydSteinberg    = core.DitherFloydSteinberg
+	DitherAtkinson          = core.DitherAtkinson
+	DitherJarvisJudiceNinke = core.DitherJarvisJudiceNinke
+	DitherSierra            = core.DitherSierra
+	DitherBayer             = core.DitherBayer
+	DitherBlueNoise         = core.DitherBlueNoise
+)
+
+var (
+	Quantize       = core.Quantize
+	BuildPalette   = core.BuildPalette
+	DominantColors = core.DominantColors
+	Dither         = core.Dither
+	DitherFilter   = core.DitherFilter
+)
+
+// Geometric warp exports
+type Sampler = core.Sampler
+type WarpFunc = core.WarpFunc
+type WarpOptions = core.WarpOptions
+
+const (
+	SamplerBilinear = core.SamplerBilinear
+	SamplerNearest  = core.SamplerNearest
+	SamplerBicubic  = core.SamplerBicubic
+	SamplerLanczos  = core.SamplerLanczos
+)
+
+var (
+	Warp                = core.Warp
+	WarpWithOptions     = core.WarpWithOptions
+	WarpFilter          = core.WarpFilter
+	CartesianToPolar    = core.CartesianToPolar
+	PolarToCartesian    = core.PolarToCartesian
+	CartesianToLogPolar = core.CartesianToLogPolar
+	LogPolarToCartesian = core.LogPolarToCartesian
+	Twirl               = core.Twirl
+	PinchBulge          = core.PinchBulge
+	Ripple              = core.Ripple
+	Wave                = core.Wave
+	LensCorrection      = core.LensCorrection
+	DisplacementMap     = core.DisplacementMap
+)
+
+// Image similarity exports
+type ChannelError = core.ChannelError
+type DeltaEMap = core.DeltaEMap
+type ImageHash = core.ImageHash
+
+var (
+	PSNR            = core.PSNR
+	SSIM            = core.SSIM
+	MSSSIM          = core.MSSSIM
+	CompareChannels = core.CompareChannels
+	DeltaE          = core.DeltaE
+	AverageHash     = core.AverageHash
+	DifferenceHash  = core.DifferenceHash
+	PerceptualHash  = core.PerceptualHash
+)
+
+// Template matching and feature alignment exports
+type MatchMethod = core.MatchMethod
+type MatchLocation = core.MatchLocation
+type MatchResult = core.MatchResult
+type Keypoint = core.Keypoint
+type CornerOptions = core.CornerOptions
+type Descriptor = core.Descriptor
+type DescriptorMatch = core.DescriptorMatch
+type Homography = core.Homography
+type RANSACOptions = core.RANSACOptions
+type TransformModel = core.TransformModel
+
+const (
+	MatchSSD = core.MatchSSD
+	MatchNCC = core.MatchNCC
+
+	TransformAffine     = core.TransformAffine
+	TransformHomography = core.TransformHomography
+)
+
+var (
+	MatchTemplate        = core.MatchTemplate
+	HarrisCorners        = core.HarrisCorners
+	FASTCorners          = core.FASTCorners
+	DescribeORB          = core.DescribeORB
+	DetectORB            = core.DetectORB
+	MatchDescriptors     = core.MatchDescriptors
+	IdentityHomography   = core.IdentityHomography
+	HomographyFromMatrix = core.HomographyFromMatrix
+	EstimateAffine       = core.EstimateAffine
+	EstimateHomography   = core.EstimateHomography
+	AlignImage           = core.AlignImage
+)
+
+// Frequency-domain exports
+type Spectrum = core.Spectrum
+type FrequencyMask = core.FrequencyMask
+type Notch = core.Notch
+
+var (
+	FFT2D               = core.FFT2D
+	FFTChannel          = core.FFTChannel
+	FFTLuminance        = core.FFTLuminance
+	FrequencyFilter     = core.FrequencyFilter
+	LowPassFilter       = core.LowPassFilter
+	HighPassFilter      = core.HighPassFilter
+	BandPassFilter      = core.BandPassFilter
+	NotchFilter         = core.NotchFilter
+	GaussianPSF         = core.GaussianPSF
+	MotionPSF           = core.MotionPSF
+	WienerDeconvolution = core.WienerDeconvolution
+)
+
+// Inpainting exports
+type InpaintMethod = core.InpaintMethod
+type InpaintOptions = core.InpaintOptions
+
+const (
+	InpaintTelea        = core.InpaintTelea
+	InpaintNavierStokes = core.InpaintNavierStokes
+	InpaintPatchMatch   = core.InpaintPatchMatch
+)
+
+var (
+	Inpaint            = core.Inpaint
+	InpaintWithOptions = core.InpaintWithOptions
+)
+
+// Background removal exports
+type ChromaKeyOptions = core.ChromaKeyOptions
+
+const (
+	TrimapBackground = core.TrimapBackground
+	TrimapForeground = core.TrimapForeground
+)
+
+var (
+	ChromaKey               = core.ChromaKey
+	ChromaKeyWithOptions    = core.ChromaKeyWithOptions
+	MatteTrimap             = core.MatteTrimap
+	RemoveUniformBackground = core.RemoveUniformBackground
+)
+
+// Selection exports
+type Connectivity = core.Connectivity
+type Selection = core.Selection
+
+const (
+	Connectivity4 = core.Connectivity4
+	Connectivity8 = core.Connectivity8
+)
+
+var (
+	NewSelection      = core.NewSelection
+	SelectionFromMask = core.SelectionFromMask
+	SelectAll         = core.SelectAll
+	SelectRect        = core.SelectRect
+	SelectPath        = core.SelectPath
+	SelectPolygon     = core.SelectPolygon
+	MagicWand         = core.MagicWand
+	SelectColorRange  = core.SelectColorRange
+	FilterInSelection = core.FilterInSelection
+)
+
+// Rotation exports
+type RotateMode = core.RotateMode
+type RotateOptions = core.RotateOptions
+
+const (
+	RotateExpand   = core.RotateExpand
+	RotateCrop     = core.RotateCrop
+	RotateKeepSize = core.RotateKeepSize
+)
+
+var (
+	RotateImage            = core.RotateImage
+	RotateImageWithOptions = core.RotateImageWithOptions
+	EstimateSkew           = core.EstimateSkew
+	Deskew                 = core.Deskew
+)
+
+// Palette exports
+type Palette = core.Palette
+type Swatch = core.Swatch
+type PaletteFormat = core.PaletteFormat
+type HarmonyScheme = core.HarmonyScheme
+type HarmonySpace = core.HarmonySpace
+type SwatchSheetOptions = core.SwatchSheetOptions
+
+const (
+	PaletteASE  = core.PaletteASE
+	PaletteGPL  = core.PaletteGPL
+	PaletteJSON = core.PaletteJSON
+	PaletteCSS  = core.PaletteCSS
+
+	HarmonyComplementary      = core.HarmonyComplementary
+	HarmonySplitComplementary = core.HarmonySplitComplementary
+	HarmonyTriadic            = core.HarmonyTriadic
+	HarmonyTetradic           = core.HarmonyTetradic
+	HarmonyAnalogous          = core.HarmonyAnalogous
+
+	HarmonyOKLCH = core.HarmonyOKLCH
+	HarmonyLCh   = core.HarmonyLCh
+)
+
+var (
+	NewPalette        = core.NewPalette
+	PaletteFromColors = core.PaletteFromColors
+	LoadPalette       = core.LoadPalette
+	DecodePalette     = core.DecodePalette
+	Harmony           = core.Harmony
+	Ramp              = core.Ramp
+)
+
+// Accessibility exports
+type CVDType = core.CVDType
+type CVDModel = core.CVDModel
+type WCAGLevel = core.WCAGLevel
+type ContrastAuditOptions = core.ContrastAuditOptions
+type TextContrastResult = core.TextContrastResult
+
+const (
+	Protanopia    = core.Protanopia
+	Deuteranopia  = core.Deuteranopia
+	Tritanopia    = core.Tritanopia
+	Achromatopsia = core.Achromatopsia
+
+	CVDMachado = core.CVDMachado
+	CVDBrettel = core.CVDBrettel
+
+	WCAGAA  = core.WCAGAA
+	WCAGAAA = core.WCAGAAA
+)
+
+var (
+	SimulateCVD          = core.SimulateCVD
+	SimulateCVDWithModel = core.SimulateCVDWithModel
+	CVDSimulation        = core.CVDSimulation
+	Daltonize            = core.Daltonize
+	RelativeLuminance    = core.RelativeLuminance
+	ContrastRatio        = core.ContrastRatio
+	MeetsWCAG            = core.MeetsWCAG
+	APCAContrast         = core.APCAContrast
+	MeetsAPCA            = core.MeetsAPCA
+)
+
+// HDR image exports
+type EXRCompression = core.EXRCompression
+type EXROptions = core.EXROptions
+type ToneMapOperator = core.ToneMapOperator
+type ToneMapOptions = core.ToneMapOptions
+type CameraResponse = core.CameraResponse
+type DebevecOptions = core.DebevecOptions
+
+const (
+	EXRNoCompression  = core.EXRNoCompression
+	EXRZIPCompression = core.EXRZIPCompression
+	EXRPIZCompression = core.EXRPIZCompression
+
+	ToneMapReinhard      = core.ToneMapReinhard
+	ToneMapReinhardLocal = core.ToneMapReinhardLocal
+	ToneMapACES          = core.ToneMapACES
+	ToneMapDrago         = core.ToneMapDrago
+	ToneMapMantiuk       = core.ToneMapMantiuk
+)
+
+var (
+	LoadHDR               = core.LoadHDR
+	SaveHDR               = core.SaveHDR
+	DecodeHDR             = core.DecodeHDR
+	EncodeHDR             = core.EncodeHDR
+	LoadEXR               = core.LoadEXR
+	SaveEXR               = core.SaveEXR
+	DecodeEXR             = core.DecodeEXR
+	EncodeEXR             = core.EncodeEXR
+	ToneMap               = core.ToneMap
+	RecoverCameraResponse = core.RecoverCameraResponse
+	MergeDebevec          = core.MergeDebevec
+)
+
+// Image format exports
+type SaveOptions = core.SaveOptions
+type NetpbmFormat = core.NetpbmFormat
+type NetpbmOptions = core.NetpbmOptions
+type TGAOptions = core.TGAOptions
+type Icon = core.Icon
+type IconEntry = core.IconEntry
+
+const (
+	NetpbmAuto = core.NetpbmAuto
+	NetpbmPBM  = core.NetpbmPBM
+	NetpbmPGM  = core.NetpbmPGM
+	NetpbmPPM  = core.NetpbmPPM
+	NetpbmPAM  = core.NetpbmPAM
+)
+
+var (
+	SaveImage    = core.SaveImage
+	LoadQOI      = core.LoadQOI
+	SaveQOI      = core.SaveQOI
+	DecodeQOI    = core.DecodeQOI
+	EncodeQOI    = core.EncodeQOI
+	LoadNetpbm   = core.LoadNetpbm
+	SaveNetpbm   = core.SaveNetpbm
+	DecodeNetpbm = core.DecodeNetpbm
+	EncodeNetpbm = core.EncodeNetpbm
+	LoadTGA      = core.LoadTGA
+	SaveTGA      = core.SaveTGA
+	DecodeTGA    = core.DecodeTGA
+	EncodeTGA    = core.EncodeTGA
+	NewIcon      = core.NewIcon
+	NewCursor    = core.NewCursor
+	LoadIcon     = core.LoadIcon
+	SaveIcon     = core.SaveIcon
+	DecodeIcon   = core.DecodeIcon
+	EncodeIcon   = core.EncodeIcon
+)
+
+// Decode limit exports
+type DecodeLimits = core.DecodeLimits
+
+var (
+	DefaultDecodeLimits = core.DefaultDecodeLimits
+	SetDecodeLimits     = core.SetDecodeLimits
+	GetDecodeLimits     = core.GetDecodeLimits
+	LoadAnimatedGIF     = core.LoadAnimatedGIF
+)
+
+// Distance transform exports
+type DistanceField = core.DistanceField
+
+var (
+	NewDistanceField  = core.NewDistanceField
+	DistanceTransform = core.DistanceTransform
+	GenerateSDF       = core.GenerateSDF
+)
+
 // Color space functions
 var (
 	NewColor            = core.NewColor
@@ -201,8 +672,25 @@ var (
 
 // ImageData functions
 var (
-	NewImageData          = core.NewImageData
-	NewImageDataFromImage = core.NewImageDataFromImage
+	NewImageData           = core.NewImageData
+	NewImageDataFromImage  = core.NewImageDataFromImage
+	NewImageDataWithFormat = core.NewImageDataWithFormat
+)
+
+// Pixel format exports
+type PixelFormat = core.PixelFormat
+type RGBAF32 = core.RGBAF32
+
+const (
+	FormatRGBA8   = core.FormatRGBA8
+	FormatRGBA16  = core.FormatRGBA16
+	FormatRGBAF32 = core.FormatRGBAF32
+)
+
+var (
+	NewRGBAF32 = core.NewRGBAF32
+	ToRGBA64   = core.ToRGBA64
+	ToRGBAF32  = core.ToRGBAF32
 )
 
 // Layer system exports
@@ -250,14 +738,26 @@ var (
 
 // Non-destructive editing exports
 type EditOperation = core.EditOperation
+type FloatEditOperation = core.FloatEditOperation
 type EditStack = core.EditStack
 type BrightnessOperation = core.BrightnessOperation
 type ContrastOperation = core.ContrastOperation
 type BlurOperation = core.BlurOperation
 type CropOperation = core.CropOperation
+type CurvesOperation = core.CurvesOperation
+type LevelsOperation = core.LevelsOperation
+type ChannelMixerOperation = core.ChannelMixerOperation
+type LUT3DOperation = core.LUT3DOperation
+type WhiteBalanceOperation = core.WhiteBalanceOperation
+type AutoWhiteBalanceOperation = core.AutoWhiteBalanceOperation
+type ColorBalanceOperation = core.ColorBalanceOperation
+type VibranceOperation = core.VibranceOperation
+type SelectiveHSLOperation = core.SelectiveHSLOperation
 
 var (
-	NewEditStack = core.NewEditStack
+	NewEditStack       = core.NewEditStack
+	NewFloatEditStack  = core.NewFloatEditStack
+	NewLevelsOperation = core.NewLevelsOperation
 )
 
 // Batch operation exports
@@ -364,6 +864,7 @@ type XYZColor = core.XYZColor
 type RenderingIntent = core.RenderingIntent
 type DeviceClass = core.DeviceClass
 type ColorSpace = core.ColorSpace
+type ToneCurve = core.ToneCurve
 
 const (
 	IntentPerceptual           = core.IntentPerceptual
@@ -393,6 +894,28 @@ var (
 	CreateAdobeRGBProfile = core.CreateAdobeRGBProfile
 )
 
+// Soft-proofing and separation exports
+type SoftProofOptions = core.SoftProofOptions
+type BlackGeneration = core.BlackGeneration
+type SeparationOptions = core.SeparationOptions
+type Separation = core.Separation
+
+const (
+	BlackProfile = core.BlackProfile
+	BlackGCR     = core.BlackGCR
+	BlackUCR     = core.BlackUCR
+)
+
+var (
+	SoftProof            = core.SoftProof
+	SoftProofWithOptions = core.SoftProofWithOptions
+	GamutMask            = core.GamutMask
+	Separate             = core.Separate
+	SeparateWithOptions  = core.SeparateWithOptions
+	SaveCMYKTIFF         = core.SaveCMYKTIFF
+	EncodeCMYKTIFF       = core.EncodeCMYKTIFF
+)
+
 // Simple Text-on-Path exports
 type SimpleTextOnPath = advance.SimpleTextOnPath
 type SimpleTextAlignment = advance.SimpleTextAlignment